@@ -56,3 +56,11 @@ done
 echo "Building program: mqitest"
 go build -o $GOPATH/bin/mqitest mqitest/mqitest.go
 
+# And the utilities
+cd $GOPATH/src/$ORG/$REPO/cmd
+for tool in */
+do
+  exe=`basename $tool`
+  echo "Building program: $exe"
+  go build -o $GOPATH/bin/$exe ./$exe
+done
@@ -0,0 +1,321 @@
+/*
+ * This program puts messages to a queue, or gets them from one, in the same way as
+ * the amqsput and amqsget samples but with more control over the messages and more
+ * information about what is received.
+ *
+ * With -put, the message body is read from stdin, or from a file given by -f. The whole
+ * input is one message unless -l is used, when each line is a separate message. For example
+ *
+ *   echo "Hello" | mqcat -m QM1 -q DEV.QUEUE.1 -put -persistent -p colour=blue
+ *
+ * Without -put, messages are read from the queue and shown with their message descriptor,
+ * properties and any MQ headers such as a dead letter header. Bodies in MQSTR format are
+ * printed as text, and anything else as a hex dump. Use -b to browse instead of removing
+ * the messages.
+ *
+ *   mqcat -m QM1 -q DEV.QUEUE.1 -b -n 5
+ *
+ * Only the public interfaces of the ibmmq package are used, so this is also an example
+ * of how to work with message properties and headers.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// Message properties given as repeated -p name=value options
+type propertyList []string
+
+func (p *propertyList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *propertyList) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("Property must be given as name=value")
+	}
+	*p = append(*p, s)
+	return nil
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	var props propertyList
+
+	qMgrName := flag.String("m", "", "Queue manager name")
+	qName := flag.String("q", "", "Queue name")
+	put := flag.Bool("put", false, "Put messages instead of getting them")
+	fileName := flag.String("f", "", "File containing the message body. Default is stdin")
+	lines := flag.Bool("l", false, "Put each line of the input as a separate message")
+	persistent := flag.Bool("persistent", false, "Make the messages persistent")
+	format := flag.String("format", ibmmq.MQFMT_STRING, "Format name for the messages")
+	flag.Var(&props, "p", "Message property as name=value. Can be repeated")
+	browse := flag.Bool("b", false, "Browse the messages instead of removing them")
+	count := flag.Int("n", 0, "Maximum number of messages to get. Default is all of them")
+	wait := flag.Int("w", 0, "Seconds to wait for a message when the queue is empty")
+	flag.Parse()
+
+	if *qName == "" {
+		fmt.Fprintf(os.Stderr, "A queue name must be given with -q\n")
+		return 1
+	}
+
+	qMgr, err := ibmmq.Conn(*qMgrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer qMgr.Disc()
+
+	openOptions := ibmmq.MQOO_FAIL_IF_QUIESCING
+	switch {
+	case *put:
+		openOptions |= ibmmq.MQOO_OUTPUT
+	case *browse:
+		openOptions |= ibmmq.MQOO_BROWSE
+	default:
+		openOptions |= ibmmq.MQOO_INPUT_AS_Q_DEF
+	}
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = *qName
+	qObject, err := qMgr.Open(mqod, openOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open queue %s: %v\n", *qName, err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer qObject.Close(0)
+
+	hMsg, err := qMgr.CrtMH(ibmmq.NewMQCMHO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create message handle: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer hMsg.DltMH(ibmmq.NewMQDMHO())
+
+	if *put {
+		var in io.Reader = os.Stdin
+		if *fileName != "" {
+			f, err := os.Open(*fileName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot open input file: %v\n", err)
+				return 1
+			}
+			defer f.Close()
+			in = f
+		}
+		err = putMessages(qObject, hMsg, in, *lines, *persistent, *format, props)
+	} else {
+		err = getMessages(qObject, hMsg, *browse, *count, *wait)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if mqreturn, ok := err.(*ibmmq.MQReturn); ok {
+			return int(mqreturn.MQCC)
+		}
+		return 1
+	}
+	return 0
+}
+
+func putMessages(qObject ibmmq.MQObject, hMsg ibmmq.MQMessageHandle, in io.Reader, lines bool, persistent bool, format string, props propertyList) error {
+	for _, p := range props {
+		kv := strings.SplitN(p, "=", 2)
+		if err := hMsg.SetMP(ibmmq.NewMQSMPO(), kv[0], ibmmq.NewMQPD(), kv[1]); err != nil {
+			return fmt.Errorf("Cannot set property %s: %v", kv[0], err)
+		}
+	}
+
+	put := func(data []byte) error {
+		md := ibmmq.NewMQMD()
+		md.Format = format
+		if persistent {
+			md.Persistence = ibmmq.MQPER_PERSISTENT
+		} else {
+			md.Persistence = ibmmq.MQPER_NOT_PERSISTENT
+		}
+		pmo := ibmmq.NewMQPMO()
+		pmo.Options = ibmmq.MQPMO_NO_SYNCPOINT | ibmmq.MQPMO_NEW_MSG_ID | ibmmq.MQPMO_FAIL_IF_QUIESCING
+		pmo.OriginalMsgHandle = hMsg
+
+		err := qObject.Put(md, pmo, data)
+		if err == nil {
+			fmt.Printf("Put message %s (%d bytes)\n", hex.EncodeToString(md.MsgId), len(data))
+		}
+		return err
+	}
+
+	if !lines {
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return put(data)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 100*1024*1024)
+	for scanner.Scan() {
+		if err := put(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func getMessages(qObject ibmmq.MQObject, hMsg ibmmq.MQMessageHandle, browse bool, count int, wait int) error {
+	buffer := make([]byte, 0, 4*1024*1024)
+
+	for n := 0; count <= 0 || n < count; n++ {
+		md := ibmmq.NewMQMD()
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_CONVERT | ibmmq.MQGMO_PROPERTIES_IN_HANDLE | ibmmq.MQGMO_FAIL_IF_QUIESCING
+		if browse {
+			gmo.Options |= ibmmq.MQGMO_BROWSE_NEXT
+		}
+		if wait > 0 {
+			gmo.Options |= ibmmq.MQGMO_WAIT
+			gmo.WaitInterval = int32(wait * 1000)
+		} else {
+			gmo.Options |= ibmmq.MQGMO_NO_WAIT
+		}
+		gmo.MsgHandle = hMsg
+
+		data, _, err := qObject.GetSlice(md, gmo, buffer[:cap(buffer)])
+		if err != nil {
+			if err.(*ibmmq.MQReturn).MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				if n == 0 {
+					fmt.Println("No messages")
+				}
+				return nil
+			}
+			return err
+		}
+
+		fmt.Printf("Message %d\n", n+1)
+		printMD(md)
+		printProperties(hMsg)
+		printBody(md, data)
+		fmt.Println()
+	}
+	return nil
+}
+
+func printMD(md *ibmmq.MQMD) {
+	fmt.Printf("  MsgId:        %s\n", hex.EncodeToString(md.MsgId))
+	fmt.Printf("  CorrelId:     %s\n", hex.EncodeToString(md.CorrelId))
+	fmt.Printf("  Format:       %s\n", strings.TrimSpace(md.Format))
+	fmt.Printf("  CCSID:        %d\n", md.CodedCharSetId)
+	fmt.Printf("  Encoding:     %d\n", md.Encoding)
+	fmt.Printf("  MsgType:      %s\n", ibmmq.MQItoString("MT", int(md.MsgType)))
+	fmt.Printf("  Persistence:  %s\n", ibmmq.MQItoString("PER", int(md.Persistence)))
+	fmt.Printf("  Priority:     %d\n", md.Priority)
+	fmt.Printf("  Expiry:       %d\n", md.Expiry)
+	fmt.Printf("  BackoutCount: %d\n", md.BackoutCount)
+	fmt.Printf("  ReplyTo:      %s@%s\n", strings.TrimSpace(md.ReplyToQ), strings.TrimSpace(md.ReplyToQMgr))
+	fmt.Printf("  UserId:       %s\n", strings.TrimSpace(md.UserIdentifier))
+	fmt.Printf("  PutApplName:  %s\n", strings.TrimSpace(md.PutApplName))
+	fmt.Printf("  PutDateTime:  %s\n", md.PutDateTime.Format(time.RFC3339))
+}
+
+func printProperties(hMsg ibmmq.MQMessageHandle) {
+	impo := ibmmq.NewMQIMPO()
+	pd := ibmmq.NewMQPD()
+
+	first := true
+	impo.Options = ibmmq.MQIMPO_CONVERT_VALUE | ibmmq.MQIMPO_INQ_FIRST
+	for {
+		name, value, err := hMsg.InqMP(impo, pd, "%")
+		if err != nil {
+			if err.(*ibmmq.MQReturn).MQRC != ibmmq.MQRC_PROPERTY_NOT_AVAILABLE {
+				fmt.Printf("  Cannot read properties: %v\n", err)
+			}
+			return
+		}
+		if first {
+			fmt.Println("  Properties:")
+			first = false
+		}
+		fmt.Printf("    %s: %v\n", name, value)
+		impo.Options = ibmmq.MQIMPO_CONVERT_VALUE | ibmmq.MQIMPO_INQ_NEXT
+	}
+}
+
+func printBody(md *ibmmq.MQMD, data []byte) {
+	headers, body, err := ibmmq.WalkHeaders(md, data)
+	for _, h := range headers {
+		fmt.Printf("  Header %s at offset %d, length %d\n", h.Format, h.Offset, h.Length)
+		printHeader(h.Header)
+	}
+	if err != nil {
+		fmt.Printf("  Cannot parse headers: %v\n", err)
+	}
+
+	b := data[body.Offset:]
+	fmt.Printf("  Body: Format %s, CCSID %d, %d bytes\n", strings.TrimSpace(body.Format), body.CodedCharSetId, len(b))
+	if strings.TrimSpace(body.Format) == strings.TrimSpace(ibmmq.MQFMT_STRING) {
+		fmt.Println(string(b))
+	} else {
+		fmt.Print(hex.Dump(b))
+	}
+}
+
+func printHeader(hdr interface{}) {
+	switch h := hdr.(type) {
+	case *ibmmq.MQDLH:
+		fmt.Printf("    Reason:       %s (%d)\n", ibmmq.MQItoString("RC", int(h.Reason)), h.Reason)
+		fmt.Printf("    Destination:  %s@%s\n", strings.TrimSpace(h.DestQName), strings.TrimSpace(h.DestQMgrName))
+		fmt.Printf("    PutApplName:  %s\n", strings.TrimSpace(h.PutApplName))
+		fmt.Printf("    PutDateTime:  %s\n", h.PutDateTime.Format(time.RFC3339))
+	case *ibmmq.MQXQH:
+		fmt.Printf("    Destination:  %s@%s\n", strings.TrimSpace(h.RemoteQName), strings.TrimSpace(h.RemoteQMgrName))
+		fmt.Printf("    MsgId:        %s\n", hex.EncodeToString(h.MsgDesc.MsgId))
+	case *ibmmq.MQRFH2:
+		for _, nv := range h.NameValueData {
+			fmt.Printf("    %s\n", strings.TrimRight(nv, "\x00 "))
+		}
+	case *ibmmq.MQRMH:
+		fmt.Printf("    ObjectType:   %s\n", strings.TrimSpace(h.ObjectType))
+		fmt.Printf("    Source:       %s %s\n", h.SrcEnv, h.SrcName)
+		fmt.Printf("    Destination:  %s %s\n", h.DestEnv, h.DestName)
+	case *ibmmq.MQGenericHeader:
+		fmt.Printf("    StrucId:      %s\n", h.StrucId)
+		fmt.Printf("    Version:      %d\n", h.Version)
+	}
+}
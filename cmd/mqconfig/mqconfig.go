@@ -0,0 +1,158 @@
+/*
+ * This program saves the definitions of the objects on a queue manager as a JSON
+ * document, and can compare the current definitions with a saved document to find
+ * configuration drift. For example
+ *
+ *   mqconfig -m QM1 -o /var/mq/QM1.json
+ *   mqconfig -m QM1 -diff /var/mq/QM1.json
+ *
+ * With -diff, each difference is printed and the exit code is 1 if there are any, so
+ * the program can be used as a check in a pipeline. Use -json to print the differences
+ * as JSON instead of text.
+ *
+ * The object types are selected with -t, from qmgr, queue, channel, topic and authinfo.
+ * All of them are included by default.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+var objectTypes = map[string]int32{
+	"qmgr":     ibmmq.MQOT_Q_MGR,
+	"queue":    ibmmq.MQOT_Q,
+	"channel":  ibmmq.MQOT_CHANNEL,
+	"topic":    ibmmq.MQOT_TOPIC,
+	"authinfo": ibmmq.MQOT_AUTH_INFO,
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	types := flag.String("t", "", "Comma-separated object types: qmgr, queue, channel, topic, authinfo. Default is all")
+	pattern := flag.String("p", "*", "Object name pattern such as 'APP.*'")
+	outFile := flag.String("o", "", "Write the snapshot to this file instead of stdout")
+	diffFile := flag.String("diff", "", "Compare the current definitions with the snapshot in this file")
+	jsonOut := flag.Bool("json", false, "Print the differences as JSON")
+	flag.Parse()
+
+	ots := ibmmq.ConfigObjectTypes
+	if *types != "" {
+		ots = nil
+		for _, t := range strings.Split(*types, ",") {
+			ot, ok := objectTypes[strings.ToLower(strings.TrimSpace(t))]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Object type '%s' is not supported\n", t)
+				return 2
+			}
+			ots = append(ots, ot)
+		}
+	}
+
+	var old *ibmmq.ConfigSnapshot
+	if *diffFile != "" {
+		b, err := ioutil.ReadFile(*diffFile)
+		if err == nil {
+			old = new(ibmmq.ConfigSnapshot)
+			err = json.Unmarshal(b, old)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read snapshot: %v\n", err)
+			return 2
+		}
+	}
+
+	qMgr, err := ibmmq.Conn(*qMgrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return 2
+	}
+	defer qMgr.Disc()
+
+	snap, err := qMgr.ConfigSnapshot(ots, *pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot inquire object definitions: %v\n", err)
+		return 2
+	}
+
+	if old != nil {
+		return printChanges(ibmmq.DiffConfigSnapshots(old, snap), *jsonOut)
+	}
+
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	b = append(b, '\n')
+	if *outFile != "" {
+		err = ioutil.WriteFile(*outFile, b, 0644)
+	} else {
+		_, err = os.Stdout.Write(b)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write snapshot: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+// Print the differences, returning 1 if there are any
+func printChanges(changes []ibmmq.ConfigChange, jsonOut bool) int {
+	if jsonOut {
+		if changes == nil {
+			changes = []ibmmq.ConfigChange{}
+		}
+		b, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		for _, c := range changes {
+			switch {
+			case c.Attribute == "":
+				fmt.Printf("%s %s: %s\n", c.Type, c.Name, c.Change)
+			case c.Change == "changed":
+				fmt.Printf("%s %s: %s changed from %v to %v\n", c.Type, c.Name, c.Attribute, c.Old, c.New)
+			case c.Change == "added":
+				fmt.Printf("%s %s: %s added with %v\n", c.Type, c.Name, c.Attribute, c.New)
+			default:
+				fmt.Printf("%s %s: %s removed, was %v\n", c.Type, c.Name, c.Attribute, c.Old)
+			}
+		}
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,276 @@
+/*
+ * This program looks at the messages on a dead letter queue. By default it browses
+ * the queue and prints how many messages there are for each combination of reason
+ * code and original destination queue, which is usually enough to see what has
+ * gone wrong.
+ *
+ * Messages can be selected by reason and by destination queue, and the selected
+ * messages can then be exported to a file, retried, or discarded. For example
+ *
+ *   mqdlq -m QM1 -reason MQRC_Q_FULL -dest 'APP*' -export /tmp/dlq.json
+ *   mqdlq -m QM1 -reason MQRC_Q_FULL -dest 'APP*' -retry
+ *
+ * A retried message has its dead letter header removed and is put back to its original
+ * destination with its original context. The put and the removal from the DLQ are done
+ * in a single unit of work. The export file has one JSON record per line, with the message
+ * body base64-encoded.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+	"github.com/ibm-messaging/mq-golang/v5/mqmetric"
+)
+
+// One line of the export file
+type exportRecord struct {
+	PutTime      time.Time `json:"putTime"`
+	Reason       string    `json:"reason"`
+	ReasonCode   int32     `json:"reasonCode"`
+	DestQName    string    `json:"destQName"`
+	DestQMgrName string    `json:"destQMgrName"`
+	PutApplName  string    `json:"putApplName"`
+	Format       string    `json:"format"`
+	MsgId        string    `json:"msgId"`
+	CorrelId     string    `json:"correlId"`
+	Data         []byte    `json:"data"`
+}
+
+// The messages are summarised by these fields
+type groupKey struct {
+	reason    int32
+	destQName string
+}
+
+// Which messages the actions apply to
+type selector struct {
+	reasons map[int32]bool
+	dest    string
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	qName := flag.String("q", "", "Dead letter queue name. Default is the queue manager's DLQ")
+	reasons := flag.String("reason", "", "Comma-separated reason codes to select, as numbers or names such as MQRC_Q_FULL")
+	dest := flag.String("dest", "", "Destination queue patterns to select, such as 'APP*,!APP.TEMP*'")
+	exportFile := flag.String("export", "", "Write the selected messages to this file")
+	retry := flag.Bool("retry", false, "Put the selected messages back to their destination queues")
+	discard := flag.Bool("discard", false, "Remove the selected messages from the DLQ")
+	flag.Parse()
+
+	if *retry && *discard {
+		fmt.Fprintf(os.Stderr, "Only one of -retry and -discard can be used\n")
+		return 1
+	}
+
+	sel, err := newSelector(*reasons, *dest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var enc *json.Encoder
+	if *exportFile != "" {
+		f, err := os.Create(*exportFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create export file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		enc = json.NewEncoder(f)
+	}
+
+	qMgr, err := ibmmq.Conn(*qMgrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer qMgr.Disc()
+
+	h, err := ibmmq.NewDLQHandler(ibmmq.NewQMgrConnection(&qMgr), *qName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open dead letter queue: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer h.Close()
+
+	groups := make(map[groupKey]int)
+	var exportErr error
+
+	stats, err := h.Process(func(dl *ibmmq.DeadLetter) int {
+		groups[groupKey{dl.DLH.Reason, dl.DLH.DestQName}]++
+		if !sel.matches(dl) {
+			return ibmmq.DLQ_KEEP
+		}
+
+		if enc != nil && exportErr == nil {
+			exportErr = enc.Encode(newExportRecord(dl))
+		}
+		// Do not remove anything once the export has failed, as it would be lost
+		switch {
+		case exportErr != nil:
+			return ibmmq.DLQ_KEEP
+		case *retry:
+			return ibmmq.DLQ_RETRY
+		case *discard:
+			return ibmmq.DLQ_DISCARD
+		}
+		return ibmmq.DLQ_KEEP
+	})
+
+	printSummary(h.QName, groups)
+	fmt.Printf("\nBrowsed: %d Kept: %d Retried: %d Discarded: %d Failed: %d\n",
+		stats.Browsed, stats.Kept, stats.Retried, stats.Discarded, stats.Failed)
+
+	if exportErr != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write to export file: %v\n", exportErr)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read dead letter queue: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func newSelector(reasons string, dest string) (*selector, error) {
+	sel := &selector{reasons: make(map[int32]bool), dest: dest}
+
+	for _, r := range strings.Split(reasons, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(r); err == nil {
+			sel.reasons[int32(n)] = true
+			continue
+		}
+		n, ok := reasonCode(r)
+		if !ok {
+			return nil, fmt.Errorf("Reason '%s' is not known", r)
+		}
+		sel.reasons[n] = true
+	}
+
+	if dest != "" {
+		if strings.Contains(dest, "@") {
+			return nil, fmt.Errorf("Preset patterns cannot be used with -dest")
+		}
+		if err := mqmetric.VerifyQueuePatterns(dest); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// There is no reverse lookup for the MQRC names, but the reason codes used in a DLH
+// are all in a small range so they can be searched
+func reasonCode(name string) (int32, bool) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "MQRC_") {
+		name = "MQRC_" + name
+	}
+	for rc := 2001; rc < 2600; rc++ {
+		if ibmmq.MQItoString("RC", rc) == name {
+			return int32(rc), true
+		}
+	}
+	return 0, false
+}
+
+func (s *selector) matches(dl *ibmmq.DeadLetter) bool {
+	if len(s.reasons) > 0 && !s.reasons[dl.DLH.Reason] {
+		return false
+	}
+	if s.dest != "" && len(mqmetric.FilterRegExp(s.dest, []string{dl.DLH.DestQName})) == 0 {
+		return false
+	}
+	return true
+}
+
+func newExportRecord(dl *ibmmq.DeadLetter) *exportRecord {
+	// The data is in a reused buffer, but it is encoded before the next message is read
+	return &exportRecord{
+		PutTime:      dl.MD.PutDateTime,
+		Reason:       reasonName(dl.DLH.Reason),
+		ReasonCode:   dl.DLH.Reason,
+		DestQName:    dl.DLH.DestQName,
+		DestQMgrName: dl.DLH.DestQMgrName,
+		PutApplName:  dl.DLH.PutApplName,
+		Format:       strings.TrimSpace(dl.MD.Format),
+		MsgId:        hex.EncodeToString(dl.MD.MsgId),
+		CorrelId:     hex.EncodeToString(dl.MD.CorrelId),
+		Data:         dl.Data,
+	}
+}
+
+// The reason in a DLH can be an MQRC or an MQFB value
+func reasonName(reason int32) string {
+	s := ibmmq.MQItoString("RC", int(reason))
+	if s == "" {
+		s = ibmmq.MQItoString("FB", int(reason))
+	}
+	if s == "" {
+		s = strconv.Itoa(int(reason))
+	}
+	return s
+}
+
+func printSummary(qName string, groups map[groupKey]int) {
+	keys := make([]groupKey, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].reason != keys[j].reason {
+			return keys[i].reason < keys[j].reason
+		}
+		return keys[i].destQName < keys[j].destQName
+	})
+
+	fmt.Printf("Dead letter queue: %s\n\n", qName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REASON\tCODE\tDESTINATION\tCOUNT")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", reasonName(k.reason), k.reason, k.destQName, groups[k])
+	}
+	w.Flush()
+}
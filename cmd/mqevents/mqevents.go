@@ -0,0 +1,333 @@
+/*
+ * This program reads MQ event messages, in the same way as the amqsevt sample
+ * that comes with MQ, and prints them as JSON or as readable text. Each record
+ * can also be sent to a webhook, which is an easy way to get events into
+ * a log aggregation system such as Splunk.
+ *
+ * By default the standard event queues are read, and the program stops once they
+ * are empty. Use -w to keep waiting for new events. For example
+ *
+ *   mqevents -m QM1 -o json -w -1 -webhook https://splunk.example.com:8088/services/collector/raw
+ *
+ * Messages are removed from the queues as they are read, unless -b is used to
+ * browse them instead.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const defaultQueues = "SYSTEM.ADMIN.QMGR.EVENT,SYSTEM.ADMIN.PERFM.EVENT,SYSTEM.ADMIN.CHANNEL.EVENT," +
+	"SYSTEM.ADMIN.LOGGER.EVENT,SYSTEM.ADMIN.CONFIG.EVENT,SYSTEM.ADMIN.COMMAND.EVENT"
+
+// How long to pause when all the queues are empty
+const pollInterval = 500 * time.Millisecond
+
+/*
+eventRecord is one decoded event. The names in eventData are the MQI constants for
+each parameter, with integer values that represent an MQI constant replaced by its name.
+*/
+type eventRecord struct {
+	Time       time.Time              `json:"eventCreation"`
+	Queue      string                 `json:"eventQueue"`
+	QMgrName   string                 `json:"queueMgr"`
+	Type       string                 `json:"eventType"`
+	Reason     string                 `json:"eventReason"`
+	ReasonCode int32                  `json:"eventReasonCode"`
+	Data       map[string]interface{} `json:"eventData"`
+}
+
+// Integer parameters whose values are shown as the name of an MQI constant,
+// and the class used to look up the name
+var paramEnums = map[int32]string{
+	ibmmq.MQIA_Q_TYPE:             "QT",
+	ibmmq.MQIA_APPL_TYPE:          "AT",
+	ibmmq.MQIACF_EVENT_APPL_TYPE:  "AT",
+	ibmmq.MQIACF_EVENT_ORIGIN:     "EVO",
+	ibmmq.MQIACF_COMMAND:          "CMD",
+	ibmmq.MQIACF_REASON_QUALIFIER: "RQ",
+	ibmmq.MQIACF_OBJECT_TYPE:      "OT",
+	ibmmq.MQIACH_CHANNEL_TYPE:     "CHT",
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type eventQueue struct {
+	name string
+	obj  ibmmq.MQObject
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	queues := flag.String("q", defaultQueues, "Comma-separated list of event queues")
+	output := flag.String("o", "text", "Output format: text or json")
+	wait := flag.Int("w", 0, "Seconds to wait for more events once the queues are empty. -1 waits forever")
+	browse := flag.Bool("b", false, "Browse the events instead of removing them")
+	webhook := flag.String("webhook", "", "URL to POST each event to as JSON")
+	flag.Parse()
+
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Output format '%s' is not supported\n", *output)
+		return 1
+	}
+
+	qMgr, err := ibmmq.Conn(*qMgrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer qMgr.Disc()
+
+	openOptions := ibmmq.MQOO_INPUT_SHARED | ibmmq.MQOO_FAIL_IF_QUIESCING
+	if *browse {
+		openOptions = ibmmq.MQOO_BROWSE | ibmmq.MQOO_FAIL_IF_QUIESCING
+	}
+
+	var eqs []*eventQueue
+	for _, q := range strings.Split(*queues, ",") {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			continue
+		}
+		mqod := ibmmq.NewMQOD()
+		mqod.ObjectType = ibmmq.MQOT_Q
+		mqod.ObjectName = q
+		obj, err := qMgr.Open(mqod, openOptions)
+		if err != nil {
+			// Not every queue manager has all of the event queues
+			fmt.Fprintf(os.Stderr, "Cannot open %s: %v\n", q, err)
+			continue
+		}
+		defer obj.Close(0)
+		eqs = append(eqs, &eventQueue{name: q, obj: obj})
+	}
+	if len(eqs) == 0 {
+		fmt.Fprintf(os.Stderr, "No event queues could be opened\n")
+		return 1
+	}
+
+	buffer := make([]byte, 0, 1024*1024)
+	deadline := time.Now().Add(time.Duration(*wait) * time.Second)
+	for {
+		found := false
+		for _, eq := range eqs {
+			md := ibmmq.NewMQMD()
+			gmo := ibmmq.NewMQGMO()
+			gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_NO_WAIT | ibmmq.MQGMO_CONVERT | ibmmq.MQGMO_FAIL_IF_QUIESCING
+			if *browse {
+				gmo.Options |= ibmmq.MQGMO_BROWSE_NEXT
+			}
+			data, _, err := eq.obj.GetSlice(md, gmo, buffer[:cap(buffer)])
+			if err != nil {
+				mqreturn := err.(*ibmmq.MQReturn)
+				if mqreturn.MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE {
+					fmt.Fprintf(os.Stderr, "Cannot get from %s: %v\n", eq.name, err)
+					return int(mqreturn.MQCC)
+				}
+				continue
+			}
+			found = true
+
+			rec, err := decodeEvent(eq.name, md, data)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Ignoring message on %s: %v\n", eq.name, err)
+				continue
+			}
+			if err = printEvent(rec, *output); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			if *webhook != "" {
+				if err = postEvent(*webhook, rec); err != nil {
+					fmt.Fprintf(os.Stderr, "Cannot send event to webhook: %v\n", err)
+				}
+			}
+		}
+
+		if !found {
+			if *wait >= 0 && time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(pollInterval)
+		} else if *wait > 0 {
+			deadline = time.Now().Add(time.Duration(*wait) * time.Second)
+		}
+	}
+	return 0
+}
+
+// Build the record from the event message
+func decodeEvent(qName string, md *ibmmq.MQMD, buf []byte) (*eventRecord, error) {
+	cfh, params := ibmmq.ReadPCFMessage(buf)
+	if cfh == nil || cfh.Type != ibmmq.MQCFT_EVENT {
+		return nil, fmt.Errorf("Message is not an MQ event")
+	}
+
+	rec := &eventRecord{
+		Time:       md.PutDateTime,
+		Queue:      qName,
+		QMgrName:   strings.TrimSpace(md.ReplyToQMgr),
+		Type:       ibmmq.MQItoString("CMD", int(cfh.Command)),
+		Reason:     ibmmq.MQItoString("RC", int(cfh.Reason)),
+		ReasonCode: cfh.Reason,
+		Data:       decodeParams(params),
+	}
+	return rec, nil
+}
+
+func decodeParams(params []*ibmmq.PCFParameter) map[string]interface{} {
+	data := make(map[string]interface{})
+	for _, p := range params {
+		name, value := decodeParam(p)
+		// Some events, such as configuration events, have repeated groups
+		if prev, ok := data[name]; ok {
+			if l, ok := prev.([]interface{}); ok {
+				data[name] = append(l, value)
+			} else {
+				data[name] = []interface{}{prev, value}
+			}
+		} else {
+			data[name] = value
+		}
+	}
+	return data
+}
+
+func decodeParam(p *ibmmq.PCFParameter) (string, interface{}) {
+	var name string
+	var value interface{}
+
+	switch p.Type {
+	case ibmmq.MQCFT_STRING, ibmmq.MQCFT_STRING_LIST:
+		name = ibmmq.MQItoString("CA", int(p.Parameter))
+		l := make([]string, len(p.String))
+		for i, s := range p.String {
+			l[i] = strings.TrimSpace(s)
+		}
+		if p.Type == ibmmq.MQCFT_STRING && len(l) == 1 {
+			value = l[0]
+		} else {
+			value = l
+		}
+	case ibmmq.MQCFT_BYTE_STRING:
+		name = ibmmq.MQItoString("BACF", int(p.Parameter))
+		if len(p.String) > 0 {
+			value = hex.EncodeToString([]byte(p.String[0]))
+		}
+	case ibmmq.MQCFT_INTEGER, ibmmq.MQCFT_INTEGER64:
+		name = ibmmq.MQItoString("IA", int(p.Parameter))
+		value = p.Int64Value[0]
+		if class, ok := paramEnums[p.Parameter]; ok {
+			if s := ibmmq.MQItoString(class, int(p.Int64Value[0])); s != "" {
+				value = s
+			}
+		}
+	case ibmmq.MQCFT_INTEGER_LIST, ibmmq.MQCFT_INTEGER64_LIST:
+		name = ibmmq.MQItoString("IA", int(p.Parameter))
+		value = p.Int64Value
+	case ibmmq.MQCFT_GROUP:
+		name = ibmmq.MQItoString("GACF", int(p.Parameter))
+		value = decodeParams(p.GroupList)
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("%d", p.Parameter)
+	}
+	return name, value
+}
+
+func printEvent(rec *eventRecord, output string) error {
+	if output == "json" {
+		b, err := json.Marshal(rec)
+		if err == nil {
+			fmt.Println(string(b))
+		}
+		return err
+	}
+
+	fmt.Printf("%s %s %s [%s] %s (%d)\n", rec.Time.Format(time.RFC3339), rec.QMgrName, rec.Queue, rec.Type, rec.Reason, rec.ReasonCode)
+	printData(rec.Data, "  ")
+	fmt.Println()
+	return nil
+}
+
+func printData(data map[string]interface{}, indent string) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch v := data[k].(type) {
+		case map[string]interface{}:
+			fmt.Printf("%s%s:\n", indent, k)
+			printData(v, indent+"  ")
+		case []interface{}:
+			for _, e := range v {
+				if m, ok := e.(map[string]interface{}); ok {
+					fmt.Printf("%s%s:\n", indent, k)
+					printData(m, indent+"  ")
+				} else {
+					fmt.Printf("%s%s: %v\n", indent, k, e)
+				}
+			}
+		default:
+			fmt.Printf("%s%s: %v\n", indent, k, v)
+		}
+	}
+}
+
+// Send the record to the webhook. Failures are reported but do not stop the program.
+func postEvent(url string, rec *eventRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return nil
+}
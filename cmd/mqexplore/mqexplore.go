@@ -0,0 +1,354 @@
+/*
+ * This program lists the queues, channels or topics on a queue manager, with a chosen
+ * set of attributes, as a table or as JSON.
+ *
+ * The object names are selected using the same pattern syntax as the mqmetric package,
+ * where a pattern can be prefixed with "!" to exclude matching objects. So it is a quick
+ * way of checking which objects a monitoring configuration will pick up. For example
+ *
+ *   mqexplore -m QM1 -t queue -p 'APP*,!APP.TEMP*' -a NAME,CURDEPTH,MAXDEPTH
+ *
+ * Attributes can be given by the names shown with the -l option, or as numbers
+ * such as 2016 for MQCA_Q_NAME.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+	"github.com/ibm-messaging/mq-golang/v5/mqmetric"
+)
+
+// An attribute that can be shown. If enumClass is set, integer values are
+// shown using the MQI constant names.
+type attrDef struct {
+	name      string
+	selector  int32
+	enumClass string
+}
+
+// How to inquire on each type of object
+type objectDef struct {
+	command      int32
+	nameSelector int32
+	attrsParm    int32
+	attrs        []attrDef
+	defaults     string
+}
+
+var objectDefs = map[string]objectDef{
+	"queue": {
+		command:      ibmmq.MQCMD_INQUIRE_Q,
+		nameSelector: ibmmq.MQCA_Q_NAME,
+		attrsParm:    ibmmq.MQIACF_Q_ATTRS,
+		attrs: []attrDef{
+			{"NAME", ibmmq.MQCA_Q_NAME, ""},
+			{"TYPE", ibmmq.MQIA_Q_TYPE, "QT"},
+			{"CURDEPTH", ibmmq.MQIA_CURRENT_Q_DEPTH, ""},
+			{"MAXDEPTH", ibmmq.MQIA_MAX_Q_DEPTH, ""},
+			{"IPPROCS", ibmmq.MQIA_OPEN_INPUT_COUNT, ""},
+			{"OPPROCS", ibmmq.MQIA_OPEN_OUTPUT_COUNT, ""},
+			{"GET", ibmmq.MQIA_INHIBIT_GET, "QA_GET"},
+			{"PUT", ibmmq.MQIA_INHIBIT_PUT, "QA_PUT"},
+			{"USAGE", ibmmq.MQIA_USAGE, "US"},
+			{"DESCR", ibmmq.MQCA_Q_DESC, ""},
+			{"CLUSTER", ibmmq.MQCA_CLUSTER_NAME, ""},
+			{"TARGET", ibmmq.MQCA_BASE_OBJECT_NAME, ""},
+			{"RNAME", ibmmq.MQCA_REMOTE_Q_NAME, ""},
+		},
+		defaults: "NAME,TYPE,CURDEPTH,MAXDEPTH,IPPROCS,OPPROCS",
+	},
+	"channel": {
+		command:      ibmmq.MQCMD_INQUIRE_CHANNEL,
+		nameSelector: ibmmq.MQCACH_CHANNEL_NAME,
+		attrsParm:    ibmmq.MQIACF_CHANNEL_ATTRS,
+		attrs: []attrDef{
+			{"NAME", ibmmq.MQCACH_CHANNEL_NAME, ""},
+			{"CHLTYPE", ibmmq.MQIACH_CHANNEL_TYPE, "CHT"},
+			{"CONNAME", ibmmq.MQCACH_CONNECTION_NAME, ""},
+			{"XMITQ", ibmmq.MQCACH_XMIT_Q_NAME, ""},
+			{"MAXINST", ibmmq.MQIACH_MAX_INSTANCES, ""},
+			{"MAXINSTC", ibmmq.MQIACH_MAX_INSTS_PER_CLIENT, ""},
+			{"MAXMSGL", ibmmq.MQIACH_MAX_MSG_LENGTH, ""},
+			{"DESCR", ibmmq.MQCACH_DESC, ""},
+		},
+		defaults: "NAME,CHLTYPE,CONNAME,XMITQ",
+	},
+	"topic": {
+		command:      ibmmq.MQCMD_INQUIRE_TOPIC,
+		nameSelector: ibmmq.MQCA_TOPIC_NAME,
+		attrsParm:    ibmmq.MQIACF_TOPIC_ATTRS,
+		attrs: []attrDef{
+			{"NAME", ibmmq.MQCA_TOPIC_NAME, ""},
+			{"TOPICSTR", ibmmq.MQCA_TOPIC_STRING, ""},
+			{"PUB", ibmmq.MQIA_INHIBIT_PUB, "TA_PUB"},
+			{"SUB", ibmmq.MQIA_INHIBIT_SUB, "TA_SUB"},
+			{"CLUSTER", ibmmq.MQCA_CLUSTER_NAME, ""},
+			{"DESCR", ibmmq.MQCA_TOPIC_DESC, ""},
+		},
+		defaults: "NAME,TOPICSTR",
+	},
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	objType := flag.String("t", "queue", "Object type: queue, channel or topic")
+	patterns := flag.String("p", "*", "Object name patterns, such as 'APP*,!APP.TEMP*'")
+	attrList := flag.String("a", "", "Attributes to show. Default depends on the object type")
+	output := flag.String("o", "table", "Output format: table or json")
+	list := flag.Bool("l", false, "List the attribute names for the object type")
+	flag.Parse()
+
+	od, ok := objectDefs[strings.ToLower(*objType)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Object type '%s' is not supported\n", *objType)
+		return 1
+	}
+
+	if *list {
+		for _, a := range od.attrs {
+			fmt.Printf("%-10s %s\n", a.name, attrTitle(a.selector))
+		}
+		return 0
+	}
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Output format '%s' is not supported\n", *output)
+		return 1
+	}
+
+	// Presets depend on queue attributes that the mqmetric package finds during
+	// its discovery, so they cannot be used here
+	if strings.Contains(*patterns, "@") {
+		fmt.Fprintf(os.Stderr, "Presets are not supported in patterns\n")
+		return 1
+	}
+	if err := mqmetric.VerifyQueuePatterns(*patterns); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if *attrList == "" {
+		*attrList = od.defaults
+	}
+	attrs, err := selectAttrs(od, *attrList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	qMgr, err := ibmmq.Conn(*qMgrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return int(err.(*ibmmq.MQReturn).MQCC)
+	}
+	defer qMgr.Disc()
+
+	objects, err := inquire(&qMgr, od, attrs, *patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Inquire failed: %v\n", err)
+		return 1
+	}
+
+	if *output == "json" {
+		err = printJSON(objects)
+	} else {
+		err = printTable(attrs, objects)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// The name of an MQI constant for an attribute that is given by number
+func attrTitle(selector int32) string {
+	class := "IA"
+	if selector >= ibmmq.MQCA_FIRST && selector <= ibmmq.MQCA_LAST {
+		class = "CA"
+	}
+	s := ibmmq.MQItoString(class, int(selector))
+	if s == "" {
+		s = strconv.Itoa(int(selector))
+	}
+	return s
+}
+
+// Turn the list of attributes into their definitions. The object name is always included.
+func selectAttrs(od objectDef, list string) ([]attrDef, error) {
+	attrs := []attrDef{od.attrs[0]}
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s == "" || s == od.attrs[0].name {
+			continue
+		}
+		found := false
+		for _, a := range od.attrs {
+			if a.name == s {
+				attrs = append(attrs, a)
+				found = true
+			}
+		}
+		if !found {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("Attribute '%s' is not known. Use -l to list the names", s)
+			}
+			attrs = append(attrs, attrDef{name: attrTitle(int32(n)), selector: int32(n)})
+		}
+	}
+	return attrs, nil
+}
+
+/*
+Issue the inquiry and return the chosen attributes for each object whose name
+matches the patterns. Only a single positive pattern can be given to the command
+server, so anything more complicated asks for all objects and filters the list.
+*/
+func inquire(qMgr *ibmmq.MQQueueManager, od objectDef, attrs []attrDef, patterns string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+
+	generic := strings.TrimSpace(patterns)
+	if strings.ContainsAny(generic, ",!") {
+		generic = "*"
+	}
+
+	cmdParms := []*ibmmq.PCFParameter{
+		{Type: ibmmq.MQCFT_STRING, Parameter: od.nameSelector, String: []string{generic}},
+	}
+	selectors := &ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER_LIST, Parameter: od.attrsParm}
+	for _, a := range attrs {
+		selectors.Int64Value = append(selectors.Int64Value, int64(a.selector))
+	}
+	cmdParms = append(cmdParms, selectors)
+
+	responses, err := qMgr.PCFCommand(od.command, cmdParms)
+	if err != nil {
+		// Nothing matching the pattern is not an error for this program
+		if mqreturn, ok := err.(*ibmmq.MQReturn); !ok || mqreturn.MQRC != ibmmq.MQRCCF_OBJECT_NAME_ERROR && mqreturn.MQRC != ibmmq.MQRC_UNKNOWN_OBJECT_NAME {
+			return nil, err
+		}
+	}
+
+	byName := make(map[string]map[string]interface{})
+	var names []string
+	for _, r := range responses {
+		cfh, params := ibmmq.ReadPCFMessage(r)
+		if cfh == nil || cfh.CompCode != ibmmq.MQCC_OK {
+			continue
+		}
+		obj := make(map[string]interface{})
+		for _, p := range params {
+			for _, a := range attrs {
+				if a.selector == p.Parameter {
+					obj[a.name] = attrValue(a, p)
+				}
+			}
+		}
+		name, _ := obj[attrs[0].name].(string)
+		if _, ok := byName[name]; !ok && name != "" {
+			byName[name] = obj
+			names = append(names, name)
+		}
+	}
+
+	names = mqmetric.FilterRegExp(patterns, names)
+	sort.Strings(names)
+	for _, name := range names {
+		objects = append(objects, byName[name])
+	}
+	return objects, nil
+}
+
+func attrValue(a attrDef, p *ibmmq.PCFParameter) interface{} {
+	switch p.Type {
+	case ibmmq.MQCFT_STRING, ibmmq.MQCFT_STRING_LIST:
+		if len(p.String) == 1 {
+			return strings.TrimSpace(p.String[0])
+		}
+		l := make([]string, len(p.String))
+		for i, s := range p.String {
+			l[i] = strings.TrimSpace(s)
+		}
+		return l
+	case ibmmq.MQCFT_INTEGER, ibmmq.MQCFT_INTEGER64:
+		if a.enumClass != "" {
+			if s := ibmmq.MQItoStringStripPrefix(a.enumClass, int(p.Int64Value[0])); s != "" {
+				return s
+			}
+		}
+		return p.Int64Value[0]
+	case ibmmq.MQCFT_INTEGER_LIST, ibmmq.MQCFT_INTEGER64_LIST:
+		return p.Int64Value
+	}
+	return nil
+}
+
+func printTable(attrs []attrDef, objects []map[string]interface{}) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, a := range attrs {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, a.name)
+	}
+	fmt.Fprintln(w)
+
+	for _, obj := range objects {
+		for i, a := range attrs {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			if v, ok := obj[a.name]; ok {
+				fmt.Fprint(w, v)
+			} else {
+				fmt.Fprint(w, "-")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func printJSON(objects []map[string]interface{}) error {
+	if objects == nil {
+		objects = make([]map[string]interface{}, 0)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(objects)
+}
@@ -0,0 +1,339 @@
+/*
+ * This program publishes queue manager, queue and channel status as Windows
+ * Performance Counters, so that they can be seen in perfmon and collected by SCOM
+ * or any other tool that reads counters, without a Prometheus server.
+ *
+ * The counters have to be registered once, by an administrator, from a manifest
+ * that the program writes:
+ *
+ *   mqperfmon -manifest > mqperfmon.man
+ *   lodctr /m:mqperfmon.man <directory containing mqperfmon.exe>
+ *
+ * Then run it for each queue manager, for example
+ *
+ *   mqperfmon -m QM1 -q 'APP.*,!APP.TEMP*' -c 'TO.*' -i 15
+ *
+ * The status is collected with the mqmetric package, using PCF status commands
+ * instead of resource publications. Each instance name starts with the queue manager
+ * name, so several copies of the program can run on one machine.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/mqmetric"
+)
+
+// The provider GUID identifies this program to Windows. It must match the manifest.
+const providerGuid = "{ca6e5a20-1e4e-4c8e-b10e-00a7a8cc77be}"
+
+// One counter, with the mqmetric status attribute that it comes from
+type counterDef struct {
+	name        string
+	description string
+	attr        string
+}
+
+/*
+counterSet is a Windows counter set, known in perfmon as an object, built from one
+of the mqmetric status types. The counters are numbered from 1 in the order given, and
+new counters must only be added at the end, as the numbers are in the registered manifest.
+*/
+type counterSet struct {
+	guid        string
+	name        string
+	description string
+	objectType  int
+	counters    []counterDef
+}
+
+var counterSets = []*counterSet{
+	{
+		guid:        "{ba5aebf5-1be4-4322-8a77-15483a427ced}",
+		name:        "IBM MQ Queue Manager",
+		description: "Status of an IBM MQ queue manager",
+		objectType:  mqmetric.OT_Q_MGR,
+		counters: []counterDef{
+			{"Connection Count", "Number of connections to the queue manager", mqmetric.ATTR_QMGR_CONNECTION_COUNT},
+			{"Channel Initiator Status", "Channel initiator status, as an MQSVC_STATUS value", mqmetric.ATTR_QMGR_CHINIT_STATUS},
+			{"Command Server Status", "Command server status, as an MQSVC_STATUS value", mqmetric.ATTR_QMGR_CMD_SERVER_STATUS},
+			{"Uptime", "Seconds since the queue manager started", mqmetric.ATTR_QMGR_UPTIME},
+			{"Active Listeners", "Number of running listeners", mqmetric.ATTR_QMGR_ACTIVE_LISTENERS},
+		},
+	},
+	{
+		guid:        "{a9f8ed17-9f28-49db-a6d7-a3eb80b09437}",
+		name:        "IBM MQ Queue",
+		description: "Status of IBM MQ local queues",
+		objectType:  mqmetric.OT_Q,
+		counters: []counterDef{
+			{"Depth", "Number of messages on the queue", mqmetric.ATTR_Q_DEPTH},
+			{"Oldest Message Age", "Age in seconds of the oldest message on the queue", mqmetric.ATTR_Q_MSGAGE},
+			{"Input Handles", "Number of handles open for input", mqmetric.ATTR_Q_IPPROCS},
+			{"Output Handles", "Number of handles open for output", mqmetric.ATTR_Q_OPPROCS},
+			{"Uncommitted Messages", "Number of uncommitted changes on the queue", mqmetric.ATTR_Q_UNCOM},
+			{"Time Since Put", "Seconds since a message was last put to the queue", mqmetric.ATTR_Q_SINCE_PUT},
+			{"Time Since Get", "Seconds since a message was last got from the queue", mqmetric.ATTR_Q_SINCE_GET},
+			{"Queue Time Short", "Recent average time on the queue in microseconds", mqmetric.ATTR_Q_QTIME_SHORT},
+			{"Maximum Depth", "The MAXDEPTH attribute of the queue", mqmetric.ATTR_Q_MAX_DEPTH},
+		},
+	},
+	{
+		guid:        "{60015a57-85a4-4d8a-8146-6ec2eb5823cc}",
+		name:        "IBM MQ Channel",
+		description: "Status of IBM MQ channel instances",
+		objectType:  mqmetric.OT_CHANNEL,
+		counters: []counterDef{
+			{"Status", "Channel status, as an MQCHS value", mqmetric.ATTR_CHL_STATUS},
+			{"Messages", "Messages sent or received since the channel started", mqmetric.ATTR_CHL_MESSAGES},
+			{"Bytes Sent", "Bytes sent since the channel started", mqmetric.ATTR_CHL_BYTES_SENT},
+			{"Bytes Received", "Bytes received since the channel started", mqmetric.ATTR_CHL_BYTES_RCVD},
+			{"Batches", "Batches completed since the channel started", mqmetric.ATTR_CHL_BATCHES},
+			{"Time Since Message", "Seconds since the last message on the channel", mqmetric.ATTR_CHL_SINCE_MSG},
+		},
+	},
+}
+
+// Characters with a special meaning in counter paths such as \Object(Instance)\Counter
+var instanceNameReplacer = strings.NewReplacer("(", "[", ")", "]", "/", "_", "\\", "_", "#", "_")
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	queues := flag.String("q", "*", "Queue patterns, such as 'APP.*,!APP.TEMP*'")
+	channels := flag.String("c", "*", "Channel patterns. Use an empty value to skip channel status")
+	interval := flag.Int("i", 10, "Seconds between updates")
+	client := flag.Bool("client", false, "Connect as a client")
+	manifest := flag.Bool("manifest", false, "Write the counter manifest for lodctr to stdout and exit")
+	flag.Parse()
+
+	if *manifest {
+		if err := writeManifest(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write manifest: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "The interval must be at least 1 second\n")
+		return 1
+	}
+
+	p, err := startProvider(counterSets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot start the counter provider: %v\n", err)
+		return 1
+	}
+	defer p.stop()
+
+	cc := mqmetric.ConnectionConfig{ClientMode: *client, UseStatus: true, UsePublications: false}
+	if err = mqmetric.InitConnection(*qMgrName, "SYSTEM.DEFAULT.MODEL.QUEUE", "", &cc); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return 1
+	}
+	defer mqmetric.EndConnection()
+
+	dc := mqmetric.DiscoverConfig{MonitoredQueues: mqmetric.DiscoverObject{ObjectNames: *queues, UseWildcard: true}}
+	if err = mqmetric.DiscoverAndSubscribe(dc); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find the queues: %v\n", err)
+		return 1
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+
+	d := time.Duration(*interval) * time.Second
+	opts := mqmetric.CollectOptions{QueuePatterns: *queues, ChannelPatterns: *channels, QMgrStatus: true, Budget: d}
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		// Errors from one collection are reported, but the counters that were
+		// collected are still updated and the next interval is tried
+		if err = mqmetric.CollectOnce(context.Background(), opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		for _, cs := range counterSets {
+			if err = p.publish(cs, counterValues(*qMgrName, cs)); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot update %s counters: %v\n", cs.name, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return 0
+		}
+	}
+}
+
+// Build the counter values for each instance from the most recent status
+func counterValues(qMgrName string, cs *counterSet) map[string][]uint64 {
+	values := make(map[string][]uint64)
+
+	st := mqmetric.GetObjectStatus("", cs.objectType)
+	for i, c := range cs.counters {
+		attr, ok := st.Attributes[c.attr]
+		if !ok {
+			continue
+		}
+		for key, v := range attr.Values {
+			name := instanceName(qMgrName, cs.objectType, key)
+			if _, ok := values[name]; !ok {
+				values[name] = make([]uint64, len(cs.counters))
+			}
+			// Negative values such as an unknown message age are shown as 0
+			if v.IsInt64 && v.ValueInt64 > 0 {
+				values[name][i] = uint64(v.ValueInt64)
+			}
+		}
+	}
+	return values
+}
+
+func instanceName(qMgrName string, objectType int, key string) string {
+	if objectType == mqmetric.OT_Q_MGR {
+		return instanceNameReplacer.Replace(key)
+	}
+	// A channel key is name/connname/rqmname/jobname, and the unknown parts are "-"
+	if objectType == mqmetric.OT_CHANNEL {
+		var l []string
+		for _, s := range strings.Split(key, "/") {
+			if s != mqmetric.DUMMY_STRING {
+				l = append(l, s)
+			}
+		}
+		key = strings.Join(l, " ")
+	}
+	return instanceNameReplacer.Replace(qMgrName + ":" + key)
+}
+
+// The XML elements of the manifest that lodctr reads
+type manifestCounter struct {
+	XMLName     xml.Name `xml:"counter"`
+	Id          int      `xml:"id,attr"`
+	Uri         string   `xml:"uri,attr"`
+	Name        string   `xml:"name,attr"`
+	Description string   `xml:"description,attr"`
+	Type        string   `xml:"type,attr"`
+	DetailLevel string   `xml:"detailLevel,attr"`
+}
+
+type manifestCounterSet struct {
+	XMLName     xml.Name `xml:"counterSet"`
+	Guid        string   `xml:"guid,attr"`
+	Uri         string   `xml:"uri,attr"`
+	Name        string   `xml:"name,attr"`
+	Description string   `xml:"description,attr"`
+	Symbol      string   `xml:"symbol,attr"`
+	Instances   string   `xml:"instances,attr"`
+	Counters    []manifestCounter
+}
+
+type manifestProvider struct {
+	XMLName             xml.Name `xml:"provider"`
+	ApplicationIdentity string   `xml:"applicationIdentity,attr"`
+	ProviderType        string   `xml:"providerType,attr"`
+	ProviderGuid        string   `xml:"providerGuid,attr"`
+	ProviderName        string   `xml:"providerName,attr"`
+	Symbol              string   `xml:"symbol,attr"`
+	CounterSets         []manifestCounterSet
+}
+
+type instrumentationManifest struct {
+	XMLName  xml.Name         `xml:"http://schemas.microsoft.com/win/2004/08/events instrumentationManifest"`
+	Counters manifestCounters `xml:"instrumentation>counters"`
+}
+
+type manifestCounters struct {
+	Xmlns         string `xml:"xmlns,attr"`
+	SchemaVersion string `xml:"schemaVersion,attr"`
+	Provider      manifestProvider
+}
+
+// Write the manifest that registers the counter sets, using the same definitions
+// that the provider is started with
+func writeManifest(w io.Writer) error {
+	exe := "mqperfmon.exe"
+	if p, err := os.Executable(); err == nil {
+		exe = filepath.Base(p)
+	}
+
+	m := instrumentationManifest{Counters: manifestCounters{
+		Xmlns:         "http://schemas.microsoft.com/win/2005/12/counters",
+		SchemaVersion: "1.1",
+		Provider: manifestProvider{
+			ApplicationIdentity: exe,
+			ProviderType:        "userMode",
+			ProviderGuid:        providerGuid,
+			ProviderName:        "IBM MQ",
+			Symbol:              "MQPerfmonProvider",
+		},
+	}}
+
+	for i, cs := range counterSets {
+		uri := "IBM.MQ." + strings.ReplaceAll(strings.TrimPrefix(cs.name, "IBM MQ "), " ", "")
+		mcs := manifestCounterSet{
+			Guid:        cs.guid,
+			Uri:         uri,
+			Name:        cs.name,
+			Description: cs.description,
+			Symbol:      fmt.Sprintf("MQCounterSet%d", i+1),
+			Instances:   "multiple",
+		}
+		for j, c := range cs.counters {
+			mcs.Counters = append(mcs.Counters, manifestCounter{
+				Id:          j + 1,
+				Uri:         uri + "." + strings.ReplaceAll(c.name, " ", ""),
+				Name:        c.name,
+				Description: c.description,
+				Type:        "perf_counter_large_rawcount",
+				DetailLevel: "standard",
+			})
+		}
+		m.Counters.Provider.CounterSets = append(m.Counters.Provider.CounterSets, mcs)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
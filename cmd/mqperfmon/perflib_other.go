@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"errors"
+)
+
+// Performance counters only exist on Windows. The manifest can still be written
+// on other platforms.
+type provider struct{}
+
+func startProvider(sets []*counterSet) (*provider, error) {
+	return nil, errors.New("Performance counters are only available on Windows")
+}
+
+func (p *provider) publish(cs *counterSet, values map[string][]uint64) error {
+	return nil
+}
+
+func (p *provider) stop() {
+}
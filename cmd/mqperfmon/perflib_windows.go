@@ -0,0 +1,213 @@
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file is the counter provider, using the PerfLib V2 functions in advapi32.dll. Each
+counter set is described to Windows with a PERF_COUNTERSET_INFO followed by one
+PERF_COUNTER_INFO for each counter, and every instance has a block of 64-bit values
+in the same order.
+
+The MQ client on Windows is 64-bit only, so the 64-bit counter values can be passed
+directly as syscall arguments.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procPerfStartProvider            = advapi32.NewProc("PerfStartProvider")
+	procPerfStopProvider             = advapi32.NewProc("PerfStopProvider")
+	procPerfSetCounterSetInfo        = advapi32.NewProc("PerfSetCounterSetInfo")
+	procPerfCreateInstance           = advapi32.NewProc("PerfCreateInstance")
+	procPerfDeleteInstance           = advapi32.NewProc("PerfDeleteInstance")
+	procPerfSetULongLongCounterValue = advapi32.NewProc("PerfSetULongLongCounterValue")
+)
+
+// Values from perflib.h and winperf.h
+const (
+	perfCountersetMultiInstances = 2
+	perfCounterLargeRawcount     = 0x00010100
+	perfDetailNovice             = 100
+)
+
+type perfCountersetInfo struct {
+	CounterSetGuid syscall.GUID
+	ProviderGuid   syscall.GUID
+	NumCounters    uint32
+	InstanceType   uint32
+}
+
+type perfCounterInfo struct {
+	CounterId   uint32
+	Type        uint32
+	Attrib      uint64
+	Size        uint32
+	DetailLevel uint32
+	Scale       int32
+	Offset      uint32
+}
+
+type perfInstance struct {
+	handle uintptr // The PERF_COUNTERSET_INSTANCE returned by PerfCreateInstance
+	seen   bool
+}
+
+type provider struct {
+	handle    uintptr
+	guids     map[*counterSet]syscall.GUID
+	instances map[*counterSet]map[string]*perfInstance
+	nextId    uint32
+}
+
+func startProvider(sets []*counterSet) (*provider, error) {
+	pGuid, err := parseGuid(providerGuid)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &provider{guids: make(map[*counterSet]syscall.GUID), instances: make(map[*counterSet]map[string]*perfInstance)}
+	rc, _, _ := procPerfStartProvider.Call(uintptr(unsafe.Pointer(&pGuid)), 0, uintptr(unsafe.Pointer(&p.handle)))
+	if rc != 0 {
+		return nil, fmt.Errorf("PerfStartProvider failed: %v", syscall.Errno(rc))
+	}
+
+	for _, cs := range sets {
+		if err = p.addCounterSet(pGuid, cs); err != nil {
+			p.stop()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *provider) addCounterSet(pGuid syscall.GUID, cs *counterSet) error {
+	csGuid, err := parseGuid(cs.guid)
+	if err != nil {
+		return err
+	}
+
+	infoSize := unsafe.Sizeof(perfCountersetInfo{})
+	counterSize := unsafe.Sizeof(perfCounterInfo{})
+	template := make([]byte, infoSize+uintptr(len(cs.counters))*counterSize)
+
+	info := (*perfCountersetInfo)(unsafe.Pointer(&template[0]))
+	info.CounterSetGuid = csGuid
+	info.ProviderGuid = pGuid
+	info.NumCounters = uint32(len(cs.counters))
+	info.InstanceType = perfCountersetMultiInstances
+
+	for i := range cs.counters {
+		ci := (*perfCounterInfo)(unsafe.Pointer(&template[infoSize+uintptr(i)*counterSize]))
+		ci.CounterId = uint32(i + 1)
+		ci.Type = perfCounterLargeRawcount
+		ci.Size = 8
+		ci.DetailLevel = perfDetailNovice
+		ci.Offset = uint32(i * 8)
+	}
+
+	rc, _, _ := procPerfSetCounterSetInfo.Call(p.handle, uintptr(unsafe.Pointer(&template[0])), uintptr(len(template)))
+	if rc != 0 {
+		return fmt.Errorf("PerfSetCounterSetInfo failed for %s: %v", cs.name, syscall.Errno(rc))
+	}
+	p.guids[cs] = csGuid
+	p.instances[cs] = make(map[string]*perfInstance)
+	return nil
+}
+
+// Set the values for each instance, creating any new instances and deleting those
+// that are no longer reported
+func (p *provider) publish(cs *counterSet, values map[string][]uint64) error {
+	guid := p.guids[cs]
+	instances := p.instances[cs]
+
+	for _, inst := range instances {
+		inst.seen = false
+	}
+
+	for name, l := range values {
+		inst, ok := instances[name]
+		if !ok {
+			namep, err := syscall.UTF16PtrFromString(name)
+			if err != nil {
+				return err
+			}
+			p.nextId++
+			h, _, callErr := procPerfCreateInstance.Call(p.handle, uintptr(unsafe.Pointer(&guid)), uintptr(unsafe.Pointer(namep)), uintptr(p.nextId))
+			if h == 0 {
+				return fmt.Errorf("PerfCreateInstance failed for %s: %v", name, callErr)
+			}
+			inst = &perfInstance{handle: h}
+			instances[name] = inst
+		}
+		inst.seen = true
+
+		for i, v := range l {
+			rc, _, _ := procPerfSetULongLongCounterValue.Call(p.handle, inst.handle, uintptr(i+1), uintptr(v))
+			if rc != 0 {
+				return fmt.Errorf("PerfSetULongLongCounterValue failed for %s: %v", name, syscall.Errno(rc))
+			}
+		}
+	}
+
+	for name, inst := range instances {
+		if !inst.seen {
+			procPerfDeleteInstance.Call(p.handle, inst.handle)
+			delete(instances, name)
+		}
+	}
+	return nil
+}
+
+func (p *provider) stop() {
+	for _, instances := range p.instances {
+		for _, inst := range instances {
+			procPerfDeleteInstance.Call(p.handle, inst.handle)
+		}
+	}
+	procPerfStopProvider.Call(p.handle)
+}
+
+// Convert "{xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx}" to the Windows structure
+func parseGuid(s string) (syscall.GUID, error) {
+	var g syscall.GUID
+
+	if len(s) != 38 || s[0] != '{' || s[37] != '}' || s[9] != '-' || s[14] != '-' || s[19] != '-' || s[24] != '-' {
+		return g, fmt.Errorf("GUID %s is not valid", s)
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(s[1:37], "-", ""))
+	if err != nil {
+		return g, fmt.Errorf("GUID %s is not valid", s)
+	}
+
+	g.Data1 = binary.BigEndian.Uint32(b[0:4])
+	g.Data2 = binary.BigEndian.Uint16(b[4:6])
+	g.Data3 = binary.BigEndian.Uint16(b[6:8])
+	copy(g.Data4[:], b[8:16])
+	return g, nil
+}
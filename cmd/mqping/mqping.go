@@ -0,0 +1,328 @@
+/*
+ * This program checks that a queue manager can be reached, one step at a time, so
+ * that when a collector or application cannot connect it is easy to see why. The
+ * steps are:
+ *
+ *   - A TCP connection to the listener
+ *   - A TLS handshake, if a CipherSpec is given, showing the negotiated cipher and
+ *     the queue manager's certificate
+ *   - The MQCONNX itself
+ *   - Authorisation checks for the things that the mqmetric package needs: inquiring
+ *     on the queue manager, putting to the command queue and subscribing to $SYS topics
+ *
+ * For example
+ *
+ *   mqping -m QM1 -conname 'mq.example.com(1414)' -channel APP.SVRCONN -cipher ANY_TLS12 -keyrepos /var/mqm/key
+ *
+ * The TCP and TLS steps are only done when the connection name is given. The exit code
+ * shows which step failed. The TLS handshake in this program is made with the Go TLS
+ * libraries, not the MQ client, so it cannot use a client certificate from the key
+ * repository; give one in PEM format with -tlscert and -tlskey if the channel needs it.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+	"github.com/ibm-messaging/mq-golang/v5/mqutil"
+)
+
+// Exit codes for each step that can fail
+const (
+	rcOK         = 0
+	rcUsage      = 1
+	rcTCP        = 2
+	rcTLS        = 3
+	rcConnect    = 4
+	rcAuthQMgr   = 5
+	rcAuthCmdQ   = 6
+	rcAuthSysSub = 7
+)
+
+// A topic that is always published by a queue manager at V9 or later
+const sysTopic = "$SYS/MQ/INFO/QMGR/%s/Monitor/METADATA/CLASSES"
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	connName := flag.String("conname", "", "Connection name such as 'host(1414)'. A list can be given, separated by commas")
+	channel := flag.String("channel", "SYSTEM.DEF.SVRCONN", "Channel name, used with -conname")
+	ccdtUrl := flag.String("ccdt", "", "CCDT URL, instead of -conname and -channel")
+	userId := flag.String("u", "", "User id. The password is taken from the MQ_PASSWORD environment variable")
+	cipher := flag.String("cipher", "", "CipherSpec for the channel")
+	keyRepos := flag.String("keyrepos", "", "Key repository for the MQ client, without the .kdb extension")
+	certLabel := flag.String("certlabel", "", "Certificate label in the key repository")
+	tlsCert := flag.String("tlscert", "", "Client certificate in PEM format for the TLS check")
+	tlsKey := flag.String("tlskey", "", "Client key in PEM format for the TLS check")
+	tlsCA := flag.String("tlsca", "", "CA certificates in PEM format to verify the queue manager's certificate")
+	timeout := flag.Int("t", 10, "Timeout in seconds for the TCP and TLS checks")
+	flag.Parse()
+
+	if *ccdtUrl != "" && *connName != "" {
+		fmt.Fprintf(os.Stderr, "Only one of -ccdt and -conname can be used\n")
+		return rcUsage
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintf(os.Stderr, "Both -tlscert and -tlskey must be given\n")
+		return rcUsage
+	}
+
+	d := time.Duration(*timeout) * time.Second
+	if *connName != "" {
+		address, err := checkTCP(*connName, d)
+		if err != nil {
+			report("TCP", err)
+			return rcTCP
+		}
+		if *cipher != "" {
+			if err = checkTLS(address, *tlsCert, *tlsKey, *tlsCA, d); err != nil {
+				report("TLS", err)
+				return rcTLS
+			}
+		}
+	}
+
+	cno := ibmmq.NewMQCNO()
+	if *ccdtUrl != "" {
+		cno.Options = ibmmq.MQCNO_CLIENT_BINDING
+		cno.CCDTUrl = *ccdtUrl
+	} else if *connName != "" {
+		cno.Options = ibmmq.MQCNO_CLIENT_BINDING
+		cd := ibmmq.NewMQCD()
+		cd.ChannelName = *channel
+		cd.ConnectionName = *connName
+		cd.SSLCipherSpec = *cipher
+		cd.CertificateLabel = *certLabel
+		cno.ClientConn = cd
+	}
+	if *keyRepos != "" || *certLabel != "" {
+		sco := ibmmq.NewMQSCO()
+		sco.KeyRepository = *keyRepos
+		sco.CertificateLabel = *certLabel
+		cno.SSLConfig = sco
+	}
+	if *userId != "" {
+		csp := ibmmq.NewMQCSP()
+		csp.AuthenticationType = ibmmq.MQCSP_AUTH_USER_ID_AND_PWD
+		csp.UserId = *userId
+		csp.Password = os.Getenv("MQ_PASSWORD")
+		cno.SecurityParms = csp
+	}
+
+	start := time.Now()
+	qMgr, err := ibmmq.Connx(*qMgrName, cno)
+	if err != nil {
+		report("MQCONNX", err)
+		hint(err.(*ibmmq.MQReturn).MQRC)
+		return rcConnect
+	}
+	defer qMgr.Disc()
+	fmt.Printf("MQCONNX:   OK in %v\n", time.Since(start).Round(time.Millisecond))
+
+	// Inquire on the queue manager to find its real name and level
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q_MGR
+	qMgrObject, err := qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		report("INQUIRE QMGR", err)
+		return rcAuthQMgr
+	}
+	v, err := qMgrObject.InqMap([]int32{ibmmq.MQCA_Q_MGR_NAME, ibmmq.MQIA_COMMAND_LEVEL, ibmmq.MQIA_PLATFORM})
+	qMgrObject.Close(0)
+	if err != nil {
+		report("INQUIRE QMGR", err)
+		return rcAuthQMgr
+	}
+	resolvedName := strings.TrimSpace(v[ibmmq.MQCA_Q_MGR_NAME].(string))
+	platform := v[ibmmq.MQIA_PLATFORM].(int32)
+	fmt.Printf("QMGR:      %s, command level %d, platform %s\n", resolvedName, v[ibmmq.MQIA_COMMAND_LEVEL].(int32),
+		ibmmq.MQItoString("PL", int(platform)))
+
+	mqod = ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = "SYSTEM.ADMIN.COMMAND.QUEUE"
+	if platform == ibmmq.MQPL_ZOS {
+		mqod.ObjectName = "SYSTEM.COMMAND.INPUT"
+	}
+	cmdQObject, err := qMgr.Open(mqod, ibmmq.MQOO_OUTPUT|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		report("COMMAND QUEUE", err)
+		return rcAuthCmdQ
+	}
+	cmdQObject.Close(0)
+	fmt.Printf("CMDQ:      OK, %s can be opened for output\n", mqod.ObjectName)
+
+	// z/OS does not publish the resource statistics
+	if platform != ibmmq.MQPL_ZOS {
+		topic := fmt.Sprintf(sysTopic, resolvedName)
+		sd := ibmmq.NewMQSD()
+		sd.Options = ibmmq.MQSO_CREATE | ibmmq.MQSO_NON_DURABLE | ibmmq.MQSO_MANAGED | ibmmq.MQSO_FAIL_IF_QUIESCING
+		sd.ObjectString = topic
+		var managedQ ibmmq.MQObject
+		sub, err := qMgr.Sub(sd, &managedQ)
+		if err != nil {
+			report("$SYS SUBSCRIBE", err)
+			return rcAuthSysSub
+		}
+		sub.Close(0)
+		managedQ.Close(0)
+		fmt.Printf("SUBSCRIBE: OK, %s\n", topic)
+	}
+
+	fmt.Println("All checks passed")
+	return rcOK
+}
+
+func report(step string, err error) {
+	fmt.Printf("%s: FAILED: %v\n", step, err)
+}
+
+// Suggest what to look at for the common connection failures
+func hint(rc int32) {
+	s := ""
+	switch rc {
+	case ibmmq.MQRC_NOT_AUTHORIZED:
+		s = "Check the user id and password, and the CHLAUTH and CONNAUTH configuration. The queue manager error log shows the reason."
+	case ibmmq.MQRC_HOST_NOT_AVAILABLE:
+		s = "Check the connection name, and that the listener is running."
+	case ibmmq.MQRC_UNKNOWN_CHANNEL_NAME:
+		s = "Check the channel name, which is case-sensitive."
+	case ibmmq.MQRC_SSL_INITIALIZATION_ERROR:
+		s = "Check the key repository name and the certificate label."
+	case ibmmq.MQRC_SSL_PEER_NAME_MISMATCH, ibmmq.MQRC_SSL_CERTIFICATE_REVOKED, ibmmq.MQRC_SSL_NOT_ALLOWED:
+		s = "Check the TLS configuration of the channel against the client."
+	case ibmmq.MQRC_Q_MGR_NAME_ERROR:
+		s = "Check the queue manager name, or leave it blank to accept the one on the channel."
+	}
+	if s != "" {
+		fmt.Printf("Hint: %s\n", s)
+	}
+}
+
+// Try each of the addresses in the connection name, returning the first that works
+func checkTCP(connName string, timeout time.Duration) (string, error) {
+	addresses, err := mqutil.ParseConnName(connName)
+	if err != nil {
+		fmt.Printf("TCP:       %v\n", err)
+		return "", err
+	}
+	for _, a := range addresses {
+		address := a.String()
+		start := time.Now()
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", address, timeout)
+		if err == nil {
+			conn.Close()
+			fmt.Printf("TCP:       OK, connected to %s in %v\n", address, time.Since(start).Round(time.Millisecond))
+			return address, nil
+		}
+		fmt.Printf("TCP:       Cannot connect to %s: %v\n", address, err)
+	}
+	return "", err
+}
+
+func checkTLS(address string, certFile string, keyFile string, caFile string, timeout time.Duration) error {
+	// The certificate is verified separately so that the handshake details can
+	// still be shown if it is not trusted
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if host, _, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, cfg)
+	if err != nil {
+		if certFile == "" {
+			err = fmt.Errorf("%v. If the channel needs a client certificate, use -tlscert and -tlskey", err)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	fmt.Printf("TLS:       OK, %s with %s\n", tlsVersion(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("No certificate was sent by the queue manager")
+	}
+	peer := state.PeerCertificates[0]
+	fmt.Printf("  Peer DN: %s\n", peer.Subject.String())
+	fmt.Printf("  Issuer:  %s\n", peer.Issuer.String())
+	fmt.Printf("  Expires: %s\n", peer.NotAfter.Format(time.RFC3339))
+	if time.Now().After(peer.NotAfter) {
+		return fmt.Errorf("The queue manager's certificate has expired")
+	}
+
+	if caFile == "" {
+		fmt.Printf("  The certificate has not been verified. Use -tlsca to check it.\n")
+		return nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("No certificates found in %s", caFile)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err = peer.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		return fmt.Errorf("The queue manager's certificate cannot be verified: %v", err)
+	}
+	fmt.Printf("  The certificate is trusted by %s\n", caFile)
+	return nil
+}
+
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS version 0x%04x", v)
+}
@@ -0,0 +1,293 @@
+/*
+ * This program runs beside an application and gives it a small REST API for putting,
+ * getting and browsing messages. The application then only needs an HTTP client, not
+ * the MQ client libraries, which helps teams using languages that have no MQ binding.
+ * Only a REST API is provided; a gRPC one would need modules that this repository does
+ * not otherwise depend on.
+ *
+ * The API listens on localhost by default, and has no authentication of its own, so it
+ * should only be reachable by the application it serves. The -queues option restricts
+ * the queues that can be used, with the same patterns as the mqmetric package.
+ *
+ *   mqsidecar -m QM1 -listen localhost:8080 -queues 'APP.*'
+ *
+ *   curl -X POST --data 'Hello' -H 'Content-Type: text/plain' \
+ *        'http://localhost:8080/v1/queues/APP.IN/messages?persistent=true&prop=colour=blue'
+ *   curl 'http://localhost:8080/v1/queues/APP.IN/messages?wait=5000'
+ *   curl 'http://localhost:8080/v1/queues/APP.IN/messages?browse=true&count=10'
+ *
+ * A GET returns JSON with the messages it found, which may be none. Text messages are
+ * returned in the "text" field, converted to UTF-8, and anything else is base64 in "data".
+ * The MQ connections are kept in a pool of up to -c connections, and a connection that
+ * has failed is replaced the next time one is needed.
+ */
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+	"github.com/ibm-messaging/mq-golang/v5/mqmetric"
+)
+
+const (
+	apiPrefix     = "/v1/queues/"
+	maxBodySize   = 100 * 1024 * 1024
+	maxCount      = 100
+	maxWait       = 60 * 1000
+	utf8CCSID     = 1208
+	poolWaitLimit = 30 * time.Second
+)
+
+// A message as returned by a GET
+type messageRecord struct {
+	MsgId        string                 `json:"msgId"`
+	CorrelId     string                 `json:"correlId"`
+	Format       string                 `json:"format"`
+	Persistent   bool                   `json:"persistent"`
+	PutTime      time.Time              `json:"putTime"`
+	ReplyToQ     string                 `json:"replyToQ,omitempty"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+	Text         *string                `json:"text,omitempty"`
+	Data         []byte                 `json:"data,omitempty"`
+	BackoutCount int32                  `json:"backoutCount"`
+}
+
+type errorRecord struct {
+	Error string `json:"error"`
+	MQCC  int32  `json:"mqcc,omitempty"`
+	MQRC  int32  `json:"mqrc,omitempty"`
+}
+
+type sidecar struct {
+	pool   *connPool
+	queues string
+}
+
+func main() {
+	os.Exit(mainWithRc())
+}
+
+// The real main function is here to set a return code.
+func mainWithRc() int {
+	qMgrName := flag.String("m", "", "Queue manager name")
+	listen := flag.String("listen", "localhost:8080", "Address for the REST API")
+	queues := flag.String("queues", "", "Queue patterns that can be used, such as 'APP.*,!APP.ADMIN*'. Default is all queues")
+	size := flag.Int("c", 4, "Most MQ connections to use at once")
+	flag.Parse()
+
+	if *queues != "" {
+		if strings.Contains(*queues, "@") {
+			fmt.Fprintf(os.Stderr, "Preset patterns cannot be used with -queues\n")
+			return 1
+		}
+		if err := mqmetric.VerifyQueuePatterns(*queues); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if *size < 1 {
+		*size = 1
+	}
+
+	s := &sidecar{pool: newConnPool(*qMgrName, *size), queues: *queues}
+
+	// Make one connection now, so that a bad configuration is reported at once
+	c, err := s.pool.acquire(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to queue manager: %v\n", err)
+		return 1
+	}
+	s.pool.release(c, nil)
+	defer s.pool.close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix, s.handleMessages)
+	mux.HandleFunc("/healthz", s.handleHealth)
+
+	fmt.Printf("Listening on %s\n", *listen)
+	if err = http.ListenAndServe(*listen, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (s *sidecar) handleHealth(w http.ResponseWriter, r *http.Request) {
+	open, idle := s.pool.stats()
+	writeJSON(w, http.StatusOK, map[string]int{"connections": open, "idle": idle})
+}
+
+// The URL is /v1/queues/<name>/messages. Queue names can contain a '/', so the
+// name is everything between the prefix and the last part.
+func (s *sidecar) handleMessages(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	if !strings.HasSuffix(path, "/messages") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Unknown path %s", r.URL.Path))
+		return
+	}
+	qName := strings.TrimSuffix(path, "/messages")
+	if qName == "" || len(qName) > int(ibmmq.MQ_Q_NAME_LENGTH) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Queue name is not valid"))
+		return
+	}
+	if s.queues != "" && len(mqmetric.FilterRegExp(s.queues, []string{qName})) == 0 {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Queue %s cannot be used", qName))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.putMessage(w, r, qName)
+	case http.MethodGet:
+		s.getMessages(w, r, qName)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s is not supported", r.Method))
+	}
+}
+
+func (s *sidecar) putMessage(w http.ResponseWriter, r *http.Request, qName string) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	query := r.URL.Query()
+	md := ibmmq.NewMQMD()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/") {
+		md.Format = ibmmq.MQFMT_STRING
+		md.CodedCharSetId = utf8CCSID
+	}
+	if query.Get("persistent") == "true" {
+		md.Persistence = ibmmq.MQPER_PERSISTENT
+	} else {
+		md.Persistence = ibmmq.MQPER_NOT_PERSISTENT
+	}
+	if v := query.Get("correlId"); v != "" {
+		if md.CorrelId, err = hex.DecodeString(v); err != nil || len(md.CorrelId) > int(ibmmq.MQ_CORREL_ID_LENGTH) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("CorrelId must be up to 24 bytes in hex"))
+			return
+		}
+	}
+	md.ReplyToQ = query.Get("replyToQ")
+
+	c, err := s.pool.acquire(r)
+	if err != nil {
+		writeMQError(w, err)
+		return
+	}
+	err = c.put(qName, md, data, query["prop"])
+	s.pool.release(c, err)
+	if err != nil {
+		writeMQError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"msgId":    hex.EncodeToString(md.MsgId),
+		"correlId": hex.EncodeToString(md.CorrelId),
+	})
+}
+
+func (s *sidecar) getMessages(w http.ResponseWriter, r *http.Request, qName string) {
+	query := r.URL.Query()
+	browse := query.Get("browse") == "true"
+	count, err := intParameter(query.Get("count"), 1, maxCount)
+	if err == nil {
+		var wait int
+		wait, err = intParameter(query.Get("wait"), 0, maxWait)
+		if err == nil {
+			c, aerr := s.pool.acquire(r)
+			if aerr != nil {
+				writeMQError(w, aerr)
+				return
+			}
+			var msgs []*messageRecord
+			msgs, err = c.get(qName, browse, count, wait)
+			s.pool.release(c, err)
+			if err != nil {
+				writeMQError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"messages": msgs})
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+func intParameter(s string, def int, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("Parameter value %s must be a number from 0 to %d", s, max)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorRecord{Error: err.Error()})
+}
+
+// Choose an HTTP status that says roughly what went wrong in MQ
+func writeMQError(w http.ResponseWriter, err error) {
+	mqreturn, ok := err.(*ibmmq.MQReturn)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch mqreturn.MQRC {
+	case ibmmq.MQRC_UNKNOWN_OBJECT_NAME:
+		status = http.StatusNotFound
+	case ibmmq.MQRC_NOT_AUTHORIZED:
+		status = http.StatusForbidden
+	case ibmmq.MQRC_Q_FULL, ibmmq.MQRC_PUT_INHIBITED, ibmmq.MQRC_GET_INHIBITED:
+		status = http.StatusConflict
+	case ibmmq.MQRC_MSG_TOO_BIG_FOR_Q, ibmmq.MQRC_MSG_TOO_BIG_FOR_CHANNEL:
+		status = http.StatusRequestEntityTooLarge
+	default:
+		if isConnectionError(err) {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, errorRecord{Error: err.Error(), MQCC: mqreturn.MQCC, MQRC: mqreturn.MQRC})
+}
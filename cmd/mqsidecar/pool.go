@@ -0,0 +1,266 @@
+package main
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the license.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+The connections to the queue manager. Each request uses one connection on its own, so
+there is no sharing of handles between goroutines. Connections are made when they are
+needed, up to the limit, and kept for later requests. One that has failed is
+disconnected when it is released, and another is made in its place next time.
+*/
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+type connPool struct {
+	qMgrName string
+	idle     chan *pooledConn
+	slots    chan struct{} // One entry for each open connection
+}
+
+type pooledConn struct {
+	qMgr  ibmmq.MQQueueManager
+	cache *ibmmq.ObjectCache // Queues opened for output
+}
+
+func newConnPool(qMgrName string, size int) *connPool {
+	return &connPool{qMgrName: qMgrName,
+		idle:  make(chan *pooledConn, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Get a connection, waiting for one to be released if they are all in use. The
+// wait ends early if the HTTP request is cancelled.
+func (p *connPool) acquire(r *http.Request) (*pooledConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	var done <-chan struct{}
+	if r != nil {
+		done = r.Context().Done()
+	}
+	timer := time.NewTimer(poolWaitLimit)
+	defer timer.Stop()
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	case p.slots <- struct{}{}:
+		c, err := p.connect()
+		if err != nil {
+			<-p.slots
+		}
+		return c, err
+	case <-done:
+		return nil, fmt.Errorf("Request cancelled while waiting for a connection")
+	case <-timer.C:
+		return nil, fmt.Errorf("No connection became available within %v", poolWaitLimit)
+	}
+}
+
+func (p *connPool) connect() (*pooledConn, error) {
+	qMgr, err := ibmmq.Conn(p.qMgrName)
+	if err != nil {
+		return nil, err
+	}
+	c := &pooledConn{qMgr: qMgr}
+	c.cache = ibmmq.NewObjectCache(ibmmq.NewQMgrConnection(&c.qMgr), ibmmq.MQOO_OUTPUT|ibmmq.MQOO_FAIL_IF_QUIESCING, 5*time.Minute, 50)
+	return c, nil
+}
+
+// Give back a connection, with the error from the last thing done with it
+func (p *connPool) release(c *pooledConn, err error) {
+	if isConnectionError(err) {
+		c.disconnect()
+		<-p.slots
+		return
+	}
+	p.idle <- c
+}
+
+func (p *connPool) stats() (int, int) {
+	return len(p.slots), len(p.idle)
+}
+
+func (p *connPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.disconnect()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+func (c *pooledConn) disconnect() {
+	c.cache.Close()
+	c.qMgr.Disc()
+}
+
+// Errors that mean the connection cannot be used again
+func isConnectionError(err error) bool {
+	mqreturn, ok := err.(*ibmmq.MQReturn)
+	if !ok {
+		return false
+	}
+	switch mqreturn.MQRC {
+	case ibmmq.MQRC_CONNECTION_BROKEN, ibmmq.MQRC_Q_MGR_NOT_AVAILABLE, ibmmq.MQRC_HCONN_ERROR,
+		ibmmq.MQRC_Q_MGR_QUIESCING, ibmmq.MQRC_Q_MGR_STOPPING, ibmmq.MQRC_CONNECTION_QUIESCING,
+		ibmmq.MQRC_CONNECTION_STOPPING, ibmmq.MQRC_RECONNECT_FAILED:
+		return true
+	}
+	return false
+}
+
+func (c *pooledConn) put(qName string, md *ibmmq.MQMD, data []byte, props []string) error {
+	pmo := ibmmq.NewMQPMO()
+	pmo.Options = ibmmq.MQPMO_NO_SYNCPOINT | ibmmq.MQPMO_NEW_MSG_ID | ibmmq.MQPMO_FAIL_IF_QUIESCING
+
+	if len(props) > 0 {
+		hMsg, err := c.qMgr.CrtMH(ibmmq.NewMQCMHO())
+		if err != nil {
+			return err
+		}
+		defer hMsg.DltMH(ibmmq.NewMQDMHO())
+		for _, p := range props {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if err = hMsg.SetMP(ibmmq.NewMQSMPO(), kv[0], ibmmq.NewMQPD(), kv[1]); err != nil {
+				return err
+			}
+		}
+		pmo.OriginalMsgHandle = hMsg
+	}
+
+	return c.cache.Put(qName, "", md, pmo, data)
+}
+
+func (c *pooledConn) get(qName string, browse bool, count int, wait int) ([]*messageRecord, error) {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = qName
+	openOptions := ibmmq.MQOO_FAIL_IF_QUIESCING
+	if browse {
+		openOptions |= ibmmq.MQOO_BROWSE
+	} else {
+		openOptions |= ibmmq.MQOO_INPUT_AS_Q_DEF
+	}
+	qObject, err := c.qMgr.Open(mqod, openOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer qObject.Close(0)
+
+	hMsg, err := c.qMgr.CrtMH(ibmmq.NewMQCMHO())
+	if err != nil {
+		return nil, err
+	}
+	defer hMsg.DltMH(ibmmq.NewMQDMHO())
+
+	msgs := make([]*messageRecord, 0)
+	buffer := make([]byte, 0, 1024*1024)
+	getOption := ibmmq.MQGMO_BROWSE_FIRST
+	for len(msgs) < count {
+		md := ibmmq.NewMQMD()
+		md.CodedCharSetId = utf8CCSID
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_CONVERT | ibmmq.MQGMO_PROPERTIES_IN_HANDLE | ibmmq.MQGMO_FAIL_IF_QUIESCING
+		if browse {
+			gmo.Options |= getOption
+		}
+		// Only the first message is waited for
+		if wait > 0 && len(msgs) == 0 {
+			gmo.Options |= ibmmq.MQGMO_WAIT
+			gmo.WaitInterval = int32(wait)
+		} else {
+			gmo.Options |= ibmmq.MQGMO_NO_WAIT
+		}
+		gmo.MsgHandle = hMsg
+
+		data, datalen, err := qObject.GetSlice(md, gmo, buffer[:cap(buffer)])
+		if err != nil {
+			mqreturn := err.(*ibmmq.MQReturn)
+			if mqreturn.MQRC == ibmmq.MQRC_TRUNCATED_MSG_FAILED {
+				buffer = make([]byte, 0, datalen)
+				if browse {
+					getOption = ibmmq.MQGMO_BROWSE_MSG_UNDER_CURSOR
+				}
+				continue
+			}
+			if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				break
+			}
+			if mqreturn.MQCC == ibmmq.MQCC_FAILED {
+				return nil, err
+			}
+		}
+		getOption = ibmmq.MQGMO_BROWSE_NEXT
+		msgs = append(msgs, newMessageRecord(md, hMsg, data))
+	}
+	return msgs, nil
+}
+
+// The data is copied, as the buffer is reused for the next message
+func newMessageRecord(md *ibmmq.MQMD, hMsg ibmmq.MQMessageHandle, data []byte) *messageRecord {
+	m := &messageRecord{MsgId: hex.EncodeToString(md.MsgId),
+		CorrelId:     hex.EncodeToString(md.CorrelId),
+		Format:       strings.TrimSpace(md.Format),
+		Persistent:   md.Persistence == ibmmq.MQPER_PERSISTENT,
+		PutTime:      md.PutDateTime,
+		ReplyToQ:     strings.TrimSpace(md.ReplyToQ),
+		BackoutCount: md.BackoutCount,
+	}
+	if m.Format == strings.TrimSpace(ibmmq.MQFMT_STRING) {
+		s := string(data)
+		m.Text = &s
+	} else {
+		m.Data = append([]byte(nil), data...)
+	}
+
+	impo := ibmmq.NewMQIMPO()
+	impo.Options = ibmmq.MQIMPO_CONVERT_VALUE | ibmmq.MQIMPO_INQ_FIRST
+	for {
+		name, value, err := hMsg.InqMP(impo, ibmmq.NewMQPD(), "%")
+		if err != nil {
+			break
+		}
+		if m.Properties == nil {
+			m.Properties = make(map[string]interface{})
+		}
+		m.Properties[name] = value
+		impo.Options = ibmmq.MQIMPO_CONVERT_VALUE | ibmmq.MQIMPO_INQ_NEXT
+	}
+	return m
+}
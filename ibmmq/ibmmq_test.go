@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 /*
 © Copyright IBM Corporation 2018
 
@@ -16,7 +19,14 @@ limitations under the License.
 package ibmmq
 
 import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Tests for mqistr.go
@@ -228,10 +238,88 @@ func TestReadPCFParameter(t *testing.T) {
 	back, _ = ReadPCFParameter(start.Bytes())
 	verifyParam(t, &start, back)
 
+	t.Log("-MQCFT_INTEGER_FILTER-")
+	start.Type = MQCFT_INTEGER_FILTER
+	start.Operator = MQCFOP_NOT_EQUAL
+	back, _ = ReadPCFParameter(start.Bytes())
+	verifyParam(t, &start, back)
+	if back.Operator != MQCFOP_NOT_EQUAL {
+		t.Logf("Integer filter operator. Expected: %d Got: %d", MQCFOP_NOT_EQUAL, back.Operator)
+		t.Fail()
+	}
+
+	t.Log("-MQCFT_STRING_FILTER-")
+	start.Type = MQCFT_STRING_FILTER
+	start.Operator = MQCFOP_LIKE
+	back, _ = ReadPCFParameter(start.Bytes())
+	verifyParam(t, &start, back)
+	if back.Operator != MQCFOP_LIKE {
+		t.Logf("String filter operator. Expected: %d Got: %d", MQCFOP_LIKE, back.Operator)
+		t.Fail()
+	}
+
 	// The rest of the types are not implemented in the Bytes()
 	// function so cannot be tested.
 }
 
+func TestPCFReader(t *testing.T) {
+	parms := []*PCFParameter{
+		{Type: MQCFT_INTEGER, Parameter: MQIA_CURRENT_Q_DEPTH, Int64Value: []int64{42}},
+		{Type: MQCFT_STRING, Parameter: MQCA_Q_NAME, String: []string{"APP.QUEUE"}},
+		{Type: MQCFT_INTEGER_LIST, Parameter: MQIACF_Q_ATTRS, Int64Value: []int64{1, 2, 3}},
+		{Type: MQCFT_INTEGER_FILTER, Parameter: MQIA_USAGE, Operator: MQCFOP_EQUAL, Int64Value: []int64{int64(MQUS_TRANSMISSION)}},
+		{Type: MQCFT_STRING_FILTER, Parameter: MQCA_CLUSTER_NAME, Operator: MQCFOP_NOT_EQUAL, String: []string{"CLUS1"}},
+		{Type: MQCFT_GROUP, Parameter: MQGACF_MONITOR_ELEMENT, ParameterCount: 2, GroupList: []*PCFParameter{
+			{Type: MQCFT_INTEGER, Parameter: MQIAMO_MONITOR_ELEMENT, Int64Value: []int64{7}},
+			{Type: MQCFT_STRING, Parameter: MQCAMO_MONITOR_DESC, String: []string{"Description"}},
+		}},
+	}
+	buf := make([]byte, 0)
+	for _, p := range parms {
+		buf = append(buf, p.Bytes()...)
+	}
+
+	r := NewPCFReader()
+	for pass := 0; pass < 2; pass++ {
+		i := 0
+		n := r.Visit(buf, int32(len(parms)), func(p *PCFParameter) bool {
+			verifyParam(t, parms[i], p)
+			if p.Operator != parms[i].Operator {
+				t.Logf("Filter operator. Expected: %d Got: %d", parms[i].Operator, p.Operator)
+				t.Fail()
+			}
+			if p.Type == MQCFT_INTEGER_LIST && len(p.Int64Value) != 3 {
+				t.Logf("Integer list. Got: %v", p.Int64Value)
+				t.Fail()
+			}
+			for j := range p.GroupList {
+				verifyParam(t, parms[i].GroupList[j], p.GroupList[j])
+			}
+			i++
+			return true
+		})
+		if n != len(buf) || i != len(parms) {
+			t.Logf("Visit. Expected: %d bytes %d parameters, Got: %d %d", len(buf), len(parms), n, i)
+			t.Fail()
+		}
+	}
+
+	// Once the structures have been created, integers need no allocations
+	ints := parms[0].Bytes()
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Visit(ints, 1, func(p *PCFParameter) bool { return true })
+	})
+	if allocs != 0 {
+		t.Logf("Allocations for an integer parameter. Expected: 0, Got: %v", allocs)
+		t.Fail()
+	}
+
+	if _, l := r.Read(buf[0:10]); l != 0 {
+		t.Logf("Short buffer was accepted")
+		t.Fail()
+	}
+}
+
 func verifyParam(t *testing.T, given, returned *PCFParameter) {
 	t.Log("Testing Type")
 	if given.Type != returned.Type {
@@ -338,3 +426,675 @@ func TestGetAttrInfoConcurrentCalls(t *testing.T) {
 		<-doneCh
 	}
 }
+
+func TestValidateOptions(t *testing.T) {
+	gmo := NewMQGMO()
+	gmo.Options = MQGMO_BROWSE_FIRST | MQGMO_SYNCPOINT
+	err := ValidateGMO(gmo)
+	if oe, ok := err.(*MQOptionsError); !ok || oe.Option != "MQGMO_SYNCPOINT" || oe.Other != "MQGMO_BROWSE_FIRST" {
+		t.Logf("BROWSE with SYNCPOINT. Expected options error, Got: %v", err)
+		t.Fail()
+	}
+
+	gmo.Options = MQGMO_LOCK | MQGMO_NO_SYNCPOINT
+	if err = ValidateGMO(gmo); err == nil {
+		t.Logf("LOCK without BROWSE. Expected error, Got: nil")
+		t.Fail()
+	}
+
+	gmo.Options = MQGMO_BROWSE_NEXT | MQGMO_LOCK | MQGMO_WAIT | MQGMO_NO_SYNCPOINT
+	if err = ValidateGMO(gmo); err != nil {
+		t.Logf("Valid GMO options. Expected: nil, Got: %v", err)
+		t.Fail()
+	}
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_NO_SYNCPOINT
+	var mqret *MQReturn
+	if err = ValidatePMO(pmo); !errors.As(err, &mqret) || mqret.MQRC != MQRC_OPTIONS_ERROR {
+		t.Logf("Conflicting syncpoint options. Expected: MQRC_OPTIONS_ERROR, Got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestExpiryAndReports(t *testing.T) {
+	md := NewMQMD()
+	md.SetExpiry(1050 * time.Millisecond)
+	if md.Expiry != 11 {
+		t.Logf("Gave 1050ms. Expected: 11, Got: %d", md.Expiry)
+		t.Fail()
+	}
+	md.SetExpiry(0)
+	if _, ok := md.GetExpiry(); ok || md.Expiry != MQEI_UNLIMITED {
+		t.Logf("Gave 0. Expected: %d, Got: %d", MQEI_UNLIMITED, md.Expiry)
+		t.Fail()
+	}
+
+	md.RequestReports(MQRO_EXPIRATION, "REPLY.Q", "")
+	md.MsgId = []byte("ORIGINAL-MSGID")
+
+	report := NewMQMD()
+	report.MsgType = MQMT_REPORT
+	report.Feedback = MQFB_EXPIRATION
+	report.CorrelId = []byte("ORIGINAL-MSGID")
+	if !report.IsReportFor(md) || report.ReportFeedback() != "EXPIRATION" {
+		t.Logf("Report not matched. Got: %t %s", report.IsReportFor(md), report.ReportFeedback())
+		t.Fail()
+	}
+}
+
+func TestSetIdentityContext(t *testing.T) {
+	md := NewMQMD()
+	ic := &IdentityContext{UserIdentifier: "gateway", AccountingToken: []byte("acct")}
+	if err := md.SetIdentityContext(ic); err != nil || md.UserIdentifier != "gateway" || len(md.AccountingToken) != int(MQ_ACCOUNTING_TOKEN_LENGTH) {
+		t.Logf("Valid identity not set. Got: %v %s %d", err, md.UserIdentifier, len(md.AccountingToken))
+		t.Fail()
+	}
+
+	ic.UserIdentifier = "averylonguserid"
+	var mqret *MQReturn
+	if err := md.SetIdentityContext(ic); !errors.As(err, &mqret) || mqret.MQRC != MQRC_MD_ERROR || md.UserIdentifier != "gateway" {
+		t.Logf("Long UserIdentifier. Expected: MQRC_MD_ERROR, Got: %v %s", err, md.UserIdentifier)
+		t.Fail()
+	}
+}
+
+func TestRMHRoundTrip(t *testing.T) {
+	md := NewMQMD()
+	md.Format = MQFMT_STRING
+	rmh := NewMQRMH(md)
+	rmh.ObjectType = "FILE"
+	rmh.SrcName = "/tmp/source.dat"
+	rmh.DestName = "/tmp/dest.dat"
+	rmh.SetDataRange(0x100000000+5, 1024)
+
+	buf := rmh.Bytes()
+	if len(buf)%4 != 0 || md.Format != MQFMT_REF_MSG_HEADER {
+		t.Logf("Bad RMH. Length: %d Format: %s", len(buf), md.Format)
+		t.Fail()
+	}
+
+	hdr, l, err := GetHeader(md, append(buf, []byte("body")...))
+	if err != nil {
+		t.Fatalf("GetHeader failed: %v", err)
+	}
+	got := hdr.(*MQRMH)
+	if l != len(buf) || got.SrcName != rmh.SrcName || got.DestName != rmh.DestName || got.SrcEnv != "" ||
+		got.Format != MQFMT_STRING || got.DataOffset() != 0x100000000+5 || got.DataLogicalLength != 1024 {
+		t.Logf("RMH mismatch. Got: %d %+v", l, got)
+		t.Fail()
+	}
+}
+
+func TestConvertEBCDIC(t *testing.T) {
+	ebcdic := []byte{0xC8, 0xC5, 0xD3, 0xD3, 0xD6, 0x40, 0xF1, 0xF2}
+	md := NewMQMD()
+	md.SetString(CCSID_ZOS_UNIX)
+	s, err := DecodeString(md, ebcdic)
+	if err != nil || s != "HELLO 12" {
+		t.Logf("Decode 1047. Expected: HELLO 12, Got: %q %v", s, err)
+		t.Fail()
+	}
+
+	b, err := ConvertFromUTF8("HELLO 12", CCSID_ZOS_UNIX)
+	if err != nil || string(b) != string(ebcdic) {
+		t.Logf("Encode 1047. Expected: % X, Got: % X %v", ebcdic, b, err)
+		t.Fail()
+	}
+
+	if _, err = ConvertFromUTF8("€", CCSID_EBCDIC); err == nil {
+		t.Logf("Euro sign in CCSID 37. Expected: error, Got: nil")
+		t.Fail()
+	}
+}
+
+func TestWalkHeaders(t *testing.T) {
+	md := NewMQMD()
+	md.Format = MQFMT_RF_HEADER_2
+	md.Encoding = MQENC_NATIVE
+	dlh := NewMQDLH(md)
+	dlh.Reason = MQRC_Q_FULL
+
+	// An RFH2 with one folder, followed by a string body
+	folder := "<usr><a>1</a></usr>" // 19 bytes, padded to 20
+	rfh2 := make([]byte, 36+4+20)
+	copy(rfh2, "RFH ")
+	endian.PutUint32(rfh2[4:], 2)
+	endian.PutUint32(rfh2[8:], uint32(len(rfh2)))
+	endian.PutUint32(rfh2[12:], uint32(MQENC_NATIVE))
+	endian.PutUint32(rfh2[16:], 1208)
+	copy(rfh2[20:], "MQSTR   ")
+	endian.PutUint32(rfh2[32:], 1208)
+	endian.PutUint32(rfh2[36:], 20)
+	copy(rfh2[40:], folder+" ")
+
+	buf := append(append(dlh.Bytes(), rfh2...), []byte("body")...)
+	headers, body, err := WalkHeaders(md, buf)
+	if err != nil || len(headers) != 2 {
+		t.Fatalf("WalkHeaders. Expected: 2 headers, Got: %d %v", len(headers), err)
+	}
+	if d, ok := headers[0].Header.(*MQDLH); !ok || d.Reason != MQRC_Q_FULL {
+		t.Logf("First header. Expected: MQDLH, Got: %+v", headers[0])
+		t.Fail()
+	}
+	if r, ok := headers[1].Header.(*MQRFH2); !ok || len(r.NameValueData) != 1 || r.NameValueData[0] != folder {
+		t.Logf("Second header. Expected: MQRFH2, Got: %+v", headers[1].Header)
+		t.Fail()
+	}
+	if body.Format != MQFMT_STRING || body.CodedCharSetId != 1208 || string(buf[body.Offset:]) != "body" {
+		t.Logf("Body. Got: %+v", body)
+		t.Fail()
+	}
+}
+
+func TestParseDeadLetter(t *testing.T) {
+	md := NewMQMD()
+	md.Format = MQFMT_STRING
+	md.Encoding = MQENC_NATIVE
+	md.CodedCharSetId = 1208
+	dlh := NewMQDLH(md)
+	dlh.Reason = MQRC_Q_FULL
+	dlh.DestQName = "APP.Q"
+
+	buf := append(dlh.Bytes(), []byte("body")...)
+	dl, err := ParseDeadLetter(md, buf)
+	if err != nil {
+		t.Fatalf("ParseDeadLetter. Got: %v", err)
+	}
+	if dl.DLH.Reason != MQRC_Q_FULL || dl.DLH.DestQName != "APP.Q" || string(dl.Data) != "body" {
+		t.Logf("Dead letter. Got: %+v %s", dl.DLH, dl.Data)
+		t.Fail()
+	}
+	if dl.MD.Format != MQFMT_STRING || dl.MD.CodedCharSetId != 1208 || md.Format != MQFMT_DEAD_LETTER_HEADER {
+		t.Logf("Restored MQMD. Got: %s %d", dl.MD.Format, dl.MD.CodedCharSetId)
+		t.Fail()
+	}
+
+	md.Format = MQFMT_STRING
+	if _, err = ParseDeadLetter(md, []byte("body")); err == nil {
+		t.Logf("Message without a DLH should fail")
+		t.Fail()
+	}
+}
+
+func TestMsgLengthLimits(t *testing.T) {
+	l := &MsgLengthLimits{QName: "APP.Q", QMgr: 4194304, Queue: 1024, Channel: 0}
+	if l.Max() != 1024 {
+		t.Logf("Max. Expected: 1024, Got: %d", l.Max())
+		t.Fail()
+	}
+	if err := l.Check(1024); err != nil {
+		t.Logf("Check(1024). Expected no error, Got: %v", err)
+		t.Fail()
+	}
+
+	err := l.Check(2048)
+	var mqret *MQReturn
+	if err == nil || !errors.As(err, &mqret) || mqret.MQRC != MQRC_MSG_TOO_BIG_FOR_Q {
+		t.Logf("Check(2048). Expected MQRC_MSG_TOO_BIG_FOR_Q, Got: %v", err)
+		t.Fail()
+	}
+
+	l.Channel = 512
+	if err = l.Check(600); err == nil || err.(*MsgLengthError).Object != "channel" {
+		t.Logf("Check(600). Expected channel limit, Got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestObjectCache(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("REPLY.1")
+	qm.DefineQueue("REPLY.2")
+
+	events := make([]string, 0)
+	c := NewObjectCache(qm, MQOO_OUTPUT, 0, 1)
+	c.Hook = func(event string, qName string, qMgrName string) {
+		events = append(events, event+":"+qName)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := c.Put("REPLY.1", "", NewMQMD(), NewMQPMO(), []byte("reply")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := c.Put("REPLY.2", "", NewMQMD(), NewMQPMO(), []byte("reply")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if qm.Depth("REPLY.1") != 3 || qm.Depth("REPLY.2") != 1 {
+		t.Logf("Depths. Got: %d %d", qm.Depth("REPLY.1"), qm.Depth("REPLY.2"))
+		t.Fail()
+	}
+
+	s := c.Stats()
+	if s.Hits != 2 || s.Misses != 2 || s.Evictions != 1 || s.OpenNow != 1 {
+		t.Logf("Stats. Got: %+v", s)
+		t.Fail()
+	}
+
+	c.Close()
+	expected := "open:REPLY.1 hit:REPLY.1 hit:REPLY.1 close:REPLY.1 open:REPLY.2 close:REPLY.2"
+	if strings.Join(events, " ") != expected {
+		t.Logf("Events. Expected: %s, Got: %v", expected, events)
+		t.Fail()
+	}
+}
+
+func TestPutAuto(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("ONCE")
+	qm.DefineQueue("OFTEN")
+
+	c := NewObjectCache(qm, MQOO_OUTPUT, 0, 0)
+	if err := c.PutAuto("ONCE", "", NewMQMD(), NewMQPMO(), []byte("msg")); err != nil {
+		t.Fatalf("PutAuto failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := c.PutAuto("OFTEN", "", NewMQMD(), NewMQPMO(), []byte("msg")); err != nil {
+			t.Fatalf("PutAuto failed: %v", err)
+		}
+	}
+	if qm.Depth("ONCE") != 1 || qm.Depth("OFTEN") != 4 {
+		t.Logf("Depths. Got: %d %d", qm.Depth("ONCE"), qm.Depth("OFTEN"))
+		t.Fail()
+	}
+
+	// The first use of each queue is an MQPUT1; the second use of OFTEN
+	// opens it, and the rest reuse that handle
+	s := c.Stats()
+	if s.Put1s != 2 || s.Misses != 1 || s.Hits != 2 || s.OpenNow != 1 {
+		t.Logf("Stats. Got: %+v", s)
+		t.Fail()
+	}
+	c.Close()
+
+	if err := Put1ToQueue(qm, "MISSING", "", NewMQMD(), NewMQPMO(), []byte("msg")); err == nil {
+		t.Logf("Put1ToQueue to a missing queue did not fail")
+		t.Fail()
+	}
+}
+
+// The benchmarks use the in-memory queue manager unless MQ_BENCH_QMGR is set, when
+// they connect to a real one and put to MQ_BENCH_QUEUE. That shows the real difference
+// between MQPUT1 and a cached MQOPEN+MQPUT.
+func benchConnection(b *testing.B) (QMgrConnection, string, func()) {
+	qName := os.Getenv("MQ_BENCH_QUEUE")
+	if qName == "" {
+		qName = "DEV.QUEUE.1"
+	}
+	if qMgrName := os.Getenv("MQ_BENCH_QMGR"); qMgrName != "" {
+		qMgr, err := Conn(qMgrName)
+		if err != nil {
+			b.Fatalf("Cannot connect to %s: %v", qMgrName, err)
+		}
+		return NewQMgrConnection(&qMgr), qName, func() { qMgr.Disc() }
+	}
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue(qName)
+	return qm, qName, func() { qm.Disc() }
+}
+
+func BenchmarkPut1(b *testing.B) {
+	conn, qName, done := benchConnection(b)
+	defer done()
+	for i := 0; i < b.N; i++ {
+		if err := Put1ToQueue(conn, qName, "", NewMQMD(), NewMQPMO(), []byte("msg")); err != nil {
+			b.Fatalf("Put1 failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkCachedPut(b *testing.B) {
+	conn, qName, done := benchConnection(b)
+	defer done()
+	c := NewObjectCache(conn, MQOO_OUTPUT, 0, 0)
+	defer c.Close()
+	for i := 0; i < b.N; i++ {
+		if err := c.Put(qName, "", NewMQMD(), NewMQPMO(), []byte("msg")); err != nil {
+			b.Fatalf("Put failed: %v", err)
+		}
+	}
+}
+
+func TestCoOpBrowse(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("WORK")
+	for _, s := range []string{"one", "two"} {
+		if err := Put1ToQueue(qm, "WORK", "", NewMQMD(), NewMQPMO(), []byte(s)); err != nil {
+			t.Fatalf("Put1 failed: %v", err)
+		}
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectName = "WORK"
+	o1, _ := qm.Open(mqod, CoOpBrowseOpenOptions)
+	o2, _ := qm.Open(mqod, CoOpBrowseOpenOptions)
+	b1 := NewCoOpBrowser(o1)
+	b2 := NewCoOpBrowser(o2)
+
+	buffer := make([]byte, 100)
+	data1, token1, err := b1.Browse(NewMQMD(), 0, buffer)
+	if err != nil || string(data1) != "one" {
+		t.Fatalf("First browse. Got: %s %v", data1, err)
+	}
+	// The second browser must skip the message marked by the first
+	data2, token2, err := b2.Browse(NewMQMD(), 0, make([]byte, 100))
+	if err != nil || string(data2) != "two" {
+		t.Fatalf("Second browse. Got: %s %v", data2, err)
+	}
+
+	if err = b2.Unmark(token2); err != nil {
+		t.Logf("Unmark failed: %v", err)
+		t.Fail()
+	}
+	if _, err = b1.Get(token1, NewMQMD(), nil, buffer); err != nil {
+		t.Logf("Get by token failed: %v", err)
+		t.Fail()
+	}
+
+	data1, _, err = b1.Browse(NewMQMD(), 0, buffer)
+	if err != nil || string(data1) != "two" || qm.Depth("WORK") != 1 {
+		t.Logf("Browse after unmark. Got: %s %v depth %d", data1, err, qm.Depth("WORK"))
+		t.Fail()
+	}
+}
+
+func TestConfigSnapshotDiff(t *testing.T) {
+	name, attrs := configAttributes(MQCA_Q_NAME, []*PCFParameter{
+		{Type: MQCFT_STRING, Parameter: MQCA_Q_NAME, String: []string{"APP.Q   "}},
+		{Type: MQCFT_INTEGER, Parameter: MQIA_MAX_Q_DEPTH, Int64Value: []int64{5000}},
+		{Type: MQCFT_INTEGER, Parameter: MQIA_CURRENT_Q_DEPTH, Int64Value: []int64{12}},
+	})
+	if name != "APP.Q" || attrs["MQIA_MAX_Q_DEPTH"] != int64(5000) || attrs["MQIA_CURRENT_Q_DEPTH"] != nil {
+		t.Logf("Attributes. Got: %s %v", name, attrs)
+		t.Fail()
+	}
+
+	old := &ConfigSnapshot{Objects: map[string]map[string]ConfigAttributes{
+		"queue": {"APP.Q": attrs, "OLD.Q": {}},
+	}}
+	// A snapshot read back from JSON has float64 numbers
+	new := &ConfigSnapshot{Objects: map[string]map[string]ConfigAttributes{
+		"queue": {"APP.Q": {"MQCA_Q_NAME": "APP.Q", "MQIA_MAX_Q_DEPTH": float64(5000)}, "NEW.Q": {}},
+	}}
+	if changes := DiffConfigSnapshots(old, new); len(changes) != 2 || changes[0].Name != "NEW.Q" || changes[0].Change != "added" || changes[1].Name != "OLD.Q" {
+		t.Logf("Changes. Got: %+v", changes)
+		t.Fail()
+	}
+
+	new.Objects["queue"]["APP.Q"]["MQIA_MAX_Q_DEPTH"] = float64(9000)
+	if changes := DiffConfigSnapshots(old, new); len(changes) != 3 || changes[0].Attribute != "MQIA_MAX_Q_DEPTH" || changes[0].Change != "changed" {
+		t.Logf("Changes. Got: %+v", changes)
+		t.Fail()
+	}
+}
+
+func TestStructSupport(t *testing.T) {
+	cno := NewMQCNO()
+	cno.CCDTUrl = "file:///tmp/ccdt.json"
+	cno.SecurityParms = NewMQCSP()
+	cno.SecurityParms.Token = "token"
+	auditCNOFields(cno)
+
+	for _, s := range GetStructSupport() {
+		if s.Compiled < 0 || s.Bindings < 1 {
+			t.Logf("Versions for %s. Got: %+v", s.Name, s)
+			t.Fail()
+		}
+		for _, f := range s.Fields {
+			if f.Compiled != (s.Compiled >= f.Version) {
+				t.Logf("Field %s.%s compiled. Got: %v", s.Name, f.Name, f.Compiled)
+				t.Fail()
+			}
+			// Only fields that were set and are not in the headers are ignored
+			set := (s.Name == "MQCNO" && f.Name == "CCDTUrl") || (s.Name == "MQCSP" && f.Name == "Token")
+			if f.Ignored != (set && !f.Compiled) {
+				t.Logf("Field %s.%s ignored. Got: %v", s.Name, f.Name, f.Ignored)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	sum, err := ComputeDigest("", []byte("hello"))
+	if err != nil || sum != "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Logf("Digest. Got: %s %v", sum, err)
+		t.Fail()
+	}
+	if _, err = ComputeDigest("md4", nil); err != ErrDigestAlgorithm {
+		t.Logf("Unknown algorithm. Got: %v", err)
+		t.Fail()
+	}
+	sum, _ = ComputeDigest(DIGEST_SHA512, []byte("hello"))
+	if verifyDigestValue(sum, []byte("hello")) != nil || verifyDigestValue(sum, []byte("hellO")) != ErrDigestMismatch {
+		t.Logf("Verify SHA-512 digest failed")
+		t.Fail()
+	}
+
+	now := time.Now()
+	c := NewDedupCache(time.Minute, 2)
+	if c.seenAt("a", now) || !c.seenAt("a", now.Add(time.Second)) {
+		t.Logf("Duplicate not found")
+		t.Fail()
+	}
+	c.Forget("a")
+	if c.seenAt("a", now.Add(2*time.Second)) {
+		t.Logf("Forgotten key still seen")
+		t.Fail()
+	}
+	// The oldest key makes room when the cache is full
+	c.seenAt("b", now.Add(3*time.Second))
+	c.seenAt("c", now.Add(4*time.Second))
+	if c.Len() != 2 || c.seenAt("a", now.Add(5*time.Second)) {
+		t.Logf("Eviction. Got: %d", c.Len())
+		t.Fail()
+	}
+	// And everything goes after the ttl
+	if c.seenAt("c", now.Add(2*time.Minute)) || c.Len() != 1 {
+		t.Logf("Expiry. Got: %d", c.Len())
+		t.Fail()
+	}
+}
+
+func TestDelayMillis(t *testing.T) {
+	for _, v := range []interface{}{int64(1700000000000), int32(5), int8(-1)} {
+		if _, ok := delayMillis(v); !ok {
+			t.Logf("Delivery time %v not accepted", v)
+			t.Fail()
+		}
+	}
+	if _, ok := delayMillis("1700000000000"); ok {
+		t.Logf("String delivery time accepted")
+		t.Fail()
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter(nil)
+	if r.AddRoute(Route{Source: "APP.IN"}) == nil {
+		t.Logf("Route without a handler accepted")
+		t.Fail()
+	}
+	if err := r.AddRoute(Route{Source: "APP.IN", Handler: ForwardRouteHandler()}); err != nil {
+		t.Logf("Route not accepted: %v", err)
+		t.Fail()
+	}
+	if r.AddRoute(Route{Source: "APP.IN", Handler: ForwardRouteHandler()}) == nil {
+		t.Logf("Duplicate route accepted")
+		t.Fail()
+	}
+	if s, ok := r.Stats()["APP.IN"]; !ok || s.Received != 0 {
+		t.Logf("Stats. Got: %v", r.Stats())
+		t.Fail()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(req.Header.Get("X-MQ-MsgId") + ":" + string(body)))
+	}))
+	defer server.Close()
+
+	h := HTTPRouteHandler(server.Client(), server.URL, true)
+	md := NewMQMD()
+	md.MsgId = []byte{1, 2}
+	outputs, err := h(&RouteMessage{MD: md, Data: []byte("hello")})
+	if err != nil || len(outputs) != 1 || string(outputs[0].Data) != "0102:hello" || outputs[0].MD.MsgType != MQMT_REPLY {
+		t.Logf("HTTP reply. Got: %v %v", outputs, err)
+		t.Fail()
+	}
+	if _, err = h(&RouteMessage{MD: md, Data: []byte("fail")}); err == nil {
+		t.Logf("HTTP error not returned")
+		t.Fail()
+	}
+}
+
+func TestAMQPMapping(t *testing.T) {
+	m := &AMQPMessage{Data: []byte("hello")}
+	m.Header.Durable = true
+	m.Header.Priority = 7
+	m.Header.TTL = 1500 * time.Millisecond
+	m.Properties.MessageID = "ID:not-binary"
+	m.Properties.CorrelationID = []byte{1, 2, 3}
+	m.Properties.ContentType = "text/plain"
+	m.Properties.Subject = "greeting"
+	m.ApplicationProperties = map[string]interface{}{"colour": "blue", "count": 3}
+	m.MessageAnnotations = map[string]interface{}{"x-opt-jms-type": "T1"}
+
+	md, props, data := MQFromAMQP(m)
+	if md.Persistence != MQPER_PERSISTENT || md.Priority != 7 || md.Expiry != 15 || md.Format != MQFMT_STRING {
+		t.Logf("MQMD. Got: %d %d %d %s", md.Persistence, md.Priority, md.Expiry, md.Format)
+		t.Fail()
+	}
+	if props[AMQP_PROP_MESSAGE_ID] != "ID:not-binary" || props["count"] != int64(3) || string(md.CorrelId) != "\x01\x02\x03" {
+		t.Logf("Properties. Got: %v", props)
+		t.Fail()
+	}
+
+	back := AMQPFromMQ(md, props, data)
+	if back.Properties.MessageID != "ID:not-binary" || back.Properties.Subject != "greeting" || back.Header.TTL != m.Header.TTL {
+		t.Logf("Round trip. Got: %+v", back.Properties)
+		t.Fail()
+	}
+	if _, ok := back.ApplicationProperties[AMQP_PROP_SUBJECT]; ok || back.ApplicationProperties["colour"] != "blue" {
+		t.Logf("Application properties. Got: %v", back.ApplicationProperties)
+		t.Fail()
+	}
+	if back.MessageAnnotations["x-opt-jms-type"] != "T1" {
+		t.Logf("Annotations. Got: %v", back.MessageAnnotations)
+		t.Fail()
+	}
+}
+
+func TestJMSMessage(t *testing.T) {
+	m := &JMSMessage{Type: JMS_MAP, Destination: "queue:///APP.Q"}
+	m.Map = map[string]interface{}{"colour": "blue & green", "count": int32(3), "ok": true, "raw": []byte{0xff}}
+
+	md := NewMQMD()
+	buf, err := m.Build(md)
+	if err != nil || md.Format != MQFMT_RF_HEADER_2 {
+		t.Fatalf("Build. Got: %s %v", md.Format, err)
+	}
+
+	back, err := ParseJMSMessage(md, buf)
+	if err != nil || back.Type != JMS_MAP || back.Destination != m.Destination {
+		t.Fatalf("Parse. Got: %+v %v", back, err)
+	}
+	if back.Map["colour"] != "blue & green" || back.Map["count"] != int32(3) || back.Map["ok"] != true {
+		t.Logf("Map. Got: %v", back.Map)
+		t.Fail()
+	}
+	if b, ok := back.Map["raw"].([]byte); !ok || len(b) != 1 || b[0] != 0xff {
+		t.Logf("Map bytes. Got: %v", back.Map["raw"])
+		t.Fail()
+	}
+
+	// Without an RFH2 the type comes from the format
+	md = NewMQMD()
+	md.Format = MQFMT_STRING
+	back, err = ParseJMSMessage(md, []byte("hello"))
+	if err != nil || back.Type != JMS_TEXT || back.Text != "hello" {
+		t.Logf("Text. Got: %+v %v", back, err)
+		t.Fail()
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	d := NewDispatcher(nil)
+	if err := d.AddQueue(DispatchQueue{QName: "APP.Q"}); err == nil {
+		t.Logf("Queue without a handler accepted")
+		t.Fail()
+	}
+	d.AddQueue(DispatchQueue{QName: "APP.Q", Handler: func(msg *DispatchMessage) error {
+		if string(msg.Data) == "panic" {
+			panic("bad message")
+		}
+		return nil
+	}})
+
+	var seen []string
+	d.Hooks = append(d.Hooks, func(msg *DispatchMessage) func(error) {
+		return func(err error) {
+			if err != nil {
+				seen = append(seen, string(msg.Data)+":failed")
+			} else {
+				seen = append(seen, string(msg.Data))
+			}
+		}
+	})
+	d.pool = make(chan struct{}, 1)
+	stop := make(chan struct{})
+
+	dq := d.queues[0]
+	if ran, err := d.dispatch(stop, dq, &DispatchMessage{QName: "APP.Q", Data: []byte("ok")}); !ran || err != nil {
+		t.Logf("Dispatch. Got: %v %v", ran, err)
+		t.Fail()
+	}
+	if ran, err := d.dispatch(stop, dq, &DispatchMessage{QName: "APP.Q", Data: []byte("panic")}); !ran || err == nil {
+		t.Logf("Panic not returned as an error. Got: %v %v", ran, err)
+		t.Fail()
+	}
+	if len(seen) != 2 || seen[0] != "ok" || seen[1] != "panic:failed" || d.Stats()["APP.Q"].Panics != 1 {
+		t.Logf("Hooks. Got: %v %+v", seen, d.Stats())
+		t.Fail()
+	}
+
+	// With every worker busy, a stopped dispatcher does not run the handler
+	d.pool <- struct{}{}
+	close(stop)
+	if ran, _ := d.dispatch(stop, dq, &DispatchMessage{QName: "APP.Q"}); ran {
+		t.Logf("Handler ran after stop")
+		t.Fail()
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	id := OutboxMsgId("order-1")
+	if len(id) != int(MQ_MSG_ID_LENGTH) || string(id) != string(OutboxMsgId("order-1")) || string(id) == string(OutboxMsgId("order-2")) {
+		t.Logf("MsgId. Got: %x", id)
+		t.Fail()
+	}
+
+	store := NewMemoryOutboxStore()
+	now := time.Now()
+	store.Add(OutboxRecord{ID: "b", QName: "APP.Q", Created: now.Add(time.Second)})
+	store.Add(OutboxRecord{ID: "a", QName: "APP.Q", Created: now})
+	store.Add(OutboxRecord{ID: "c", QName: "APP.Q", Created: now.Add(2 * time.Second)})
+
+	recs, _ := store.Pending(2)
+	if len(recs) != 2 || recs[0].ID != "a" || recs[1].ID != "b" {
+		t.Logf("Pending. Got: %v", recs)
+		t.Fail()
+	}
+	store.MarkSent([]string{"a", "b", "unknown"})
+	recs, _ = store.Pending(0)
+	if len(recs) != 1 || recs[0].ID != "c" {
+		t.Logf("Pending after MarkSent. Got: %v", recs)
+		t.Fail()
+	}
+}
@@ -95,6 +95,7 @@ MQQueueManager contains the connection to the queue manager
 type MQQueueManager struct {
 	hConn C.MQHCONN
 	Name  string
+	stats *connStats // Shared by copies of this structure
 }
 
 /*
@@ -117,18 +118,6 @@ type MQMessageHandle struct {
 	qMgr *MQQueueManager
 }
 
-/*
-MQReturn holds the MQRC and MQCC values returned from an MQI verb. It
-implements the Error() function so is returned as the specific error
-from the verbs. See the sample programs for how to access the
-MQRC/MQCC values in this returned error.
-*/
-type MQReturn struct {
-	MQCC int32
-	MQRC int32
-	verb string
-}
-
 func (e *MQReturn) Error() string {
 	return mqstrerror(e.verb, C.MQLONG(e.MQCC), C.MQLONG(e.MQRC))
 }
@@ -141,23 +130,12 @@ func IsUsableHObj(o MQObject) bool {
 	}
 }
 
-var endian binary.ByteOrder // Used by structure formatters such as MQCFH
-const space8 = "        "
 const (
 	mqDateTimeFormat = "20060102150405.00 MST" // Used as the way to parse a string into a time.Time type with magic values
 	mqDateFormat     = "20060102"
 	mqTimeFormat     = "150405.00"
 )
 
-// This function is executed once before any other code in the package
-func init() {
-	if C.MQENC_NATIVE%2 == 0 {
-		endian = binary.LittleEndian
-	} else {
-		endian = binary.BigEndian
-	}
-}
-
 /*
  * Copy a Go string in "strings"
  * to a fixed-size C char array such as MQCHAR12
@@ -216,6 +194,7 @@ func Connx(goQMgrName string, gocno *MQCNO) (MQQueueManager, error) {
 
 	qMgr := MQQueueManager{}
 	qMgr.Name = goQMgrName
+	qMgr.stats = newConnStats()
 	mqQMgrName := unsafe.Pointer(C.CString(goQMgrName))
 	defer C.free(mqQMgrName)
 
@@ -227,14 +206,20 @@ func Connx(goQMgrName string, gocno *MQCNO) (MQQueueManager, error) {
 		gocno = NewMQCNO()
 		gocno.Options = MQCNO_HANDLE_SHARE_NO_BLOCK
 	} else {
+		// Unshared handles do not work when the goroutine moves between threads. See mqishare.go.
+		if (gocno.Options & MQCNO_HANDLE_SHARE_NONE) != 0 {
+			return qMgr, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_OPTIONS_ERROR, verb: "MQCONNX"}
+		}
 		if (gocno.Options & (MQCNO_HANDLE_SHARE_NO_BLOCK |
 			MQCNO_HANDLE_SHARE_BLOCK)) == 0 {
 			gocno.Options |= MQCNO_HANDLE_SHARE_NO_BLOCK
 		}
 	}
+	auditCNOFields(gocno)
 	copyCNOtoC(&mqcno, gocno)
 
 	C.MQCONNX((*C.MQCHAR)(mqQMgrName), &mqcno, &qMgr.hConn, &mqcc, &mqrc)
+	auditConnx(&mqcno, mqcc, mqrc)
 
 	if gocno != nil {
 		copyCNOfromC(&mqcno, gocno)
@@ -312,16 +297,40 @@ func (x *MQQueueManager) Open(good *MQOD, goOpenOptions int32) (MQObject, error)
 		return object, &mqreturn
 	}
 
-	// ObjectName may have changed because it's a model queue
+	// ObjectName may have changed because it's a model queue. For topics, use
+	// the full resolved topic string if we have it, as the ObjectString
+	// might only be a part of the topic tree beneath an administered topic object.
 	object.Name = good.ObjectName
 	if good.ObjectType == C.MQOT_TOPIC {
 		object.Name = good.ObjectString
+		if good.ResObjectString != "" {
+			object.Name = good.ResObjectString
+		}
 	}
 
 	return object, nil
 
 }
 
+/*
+OpenTopic opens a topic so that messages can be published to it with Put. The
+topic can be given as an administered topic object name, as a topic string, or as
+both - in which case the topic string is appended to the one defined by the topic
+object. MQOO_OUTPUT is always added to the open options.
+
+The returned string is the complete topic that will be used for publications, as
+resolved by the queue manager. It is also used as the Name of the returned object.
+*/
+func (x *MQQueueManager) OpenTopic(topicObject string, topicString string, goOpenOptions int32) (MQObject, string, error) {
+	mqod := NewMQOD()
+	mqod.ObjectType = C.MQOT_TOPIC
+	mqod.ObjectName = topicObject
+	mqod.ObjectString = topicString
+
+	object, err := x.Open(mqod, goOpenOptions|C.MQOO_OUTPUT)
+	return object, mqod.ResObjectString, err
+}
+
 /*
 Close the object
 */
@@ -553,11 +562,13 @@ func (object MQObject) Put(gomd *MQMD,
 		ptr = nil
 	}
 
+	start := time.Now()
 	C.MQPUT(object.qMgr.hConn, object.hObj, (C.PMQVOID)(unsafe.Pointer(&mqmd)),
 		(C.PMQVOID)(unsafe.Pointer(&mqpmo)),
 		(C.MQLONG)(bufflen),
 		ptr,
 		&mqcc, &mqrc)
+	object.qMgr.stats.recordPut(bufflen, start, int32(mqcc))
 
 	copyMDfromC(&mqmd, gomd)
 	copyPMOfromC(&mqpmo, gopmo)
@@ -605,12 +616,14 @@ func (x *MQQueueManager) Put1(good *MQOD, gomd *MQMD,
 		ptr = nil
 	}
 
+	start := time.Now()
 	C.MQPUT1(x.hConn, (C.PMQVOID)(unsafe.Pointer(&mqod)),
 		(C.PMQVOID)(unsafe.Pointer(&mqmd)),
 		(C.PMQVOID)(unsafe.Pointer(&mqpmo)),
 		(C.MQLONG)(bufflen),
 		ptr,
 		&mqcc, &mqrc)
+	x.stats.recordPut(bufflen, start, int32(mqcc))
 
 	copyODfromC(&mqod, good)
 	copyMDfromC(&mqmd, gomd)
@@ -704,6 +717,7 @@ func (object MQObject) getInternal(gomd *MQMD,
 		ptr = nil
 	}
 
+	start := time.Now()
 	C.MQGET(object.qMgr.hConn, object.hObj, (C.PMQVOID)(unsafe.Pointer(&mqmd)),
 		(C.PMQVOID)(unsafe.Pointer(&mqgmo)),
 		(C.MQLONG)(bufflen),
@@ -712,6 +726,11 @@ func (object MQObject) getInternal(gomd *MQMD,
 		&mqcc, &mqrc)
 
 	godatalen := int(datalen)
+	if godatalen < bufflen {
+		object.qMgr.stats.recordGet(godatalen, start, int32(mqcc), int32(mqrc))
+	} else {
+		object.qMgr.stats.recordGet(bufflen, start, int32(mqcc), int32(mqrc))
+	}
 	copyMDfromC(&mqmd, gomd)
 	copyGMOfromC(&mqgmo, gogmo)
 
@@ -1386,8 +1405,8 @@ func (handle *MQMessageHandle) InqMP(goimpo *MQIMPO, gopd *MQPD, name string) (s
 
 /*
 GetHeader returns a structure containing a parsed-out version of an MQI
-message header such as the MQDLH (which is currently the only structure
-supported). Other structures like the RFH2 could follow.
+message header such as the MQDLH or MQRMH. Other structures like the RFH2
+could follow.
 
 The caller of this function needs to cast the returned structure to the
 specific type in order to reference the fields.
@@ -1396,6 +1415,8 @@ func GetHeader(md *MQMD, buf []byte) (interface{}, int, error) {
 	switch md.Format {
 	case MQFMT_DEAD_LETTER_HEADER:
 		return getHeaderDLH(md, buf)
+	case MQFMT_REF_MSG_HEADER:
+		return getHeaderRMH(md, buf)
 	}
 
 	mqreturn := &MQReturn{MQCC: int32(MQCC_FAILED),
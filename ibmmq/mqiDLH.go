@@ -128,6 +128,12 @@ the C structure definition for each field. The DLH does not have multiple
 versions defined so we don't need to check that as we go through.
 */
 func getHeaderDLH(md *MQMD, buf []byte) (*MQDLH, int, error) {
+	return parseDLH(buf, endian)
+}
+
+// The byte order is given separately so that the header walker can use the encoding
+// of the preceding structure
+func parseDLH(buf []byte, order binary.ByteOrder) (*MQDLH, int, error) {
 
 	var version int32
 
@@ -135,17 +141,17 @@ func getHeaderDLH(md *MQMD, buf []byte) (*MQDLH, int, error) {
 
 	r := bytes.NewBuffer(buf)
 	_ = readStringFromFixedBuffer(r, 4) // StrucId
-	binary.Read(r, endian, &version)
-	binary.Read(r, endian, &dlh.Reason)
+	binary.Read(r, order, &version)
+	binary.Read(r, order, &dlh.Reason)
 	dlh.DestQName = readStringFromFixedBuffer(r, MQ_OBJECT_NAME_LENGTH)
 	dlh.DestQMgrName = readStringFromFixedBuffer(r, MQ_Q_MGR_NAME_LENGTH)
 
-	binary.Read(r, endian, &dlh.Encoding)
-	binary.Read(r, endian, &dlh.CodedCharSetId)
+	binary.Read(r, order, &dlh.Encoding)
+	binary.Read(r, order, &dlh.CodedCharSetId)
 
 	dlh.Format = readStringFromFixedBuffer(r, MQ_FORMAT_LENGTH)
 
-	binary.Read(r, endian, &dlh.PutApplType)
+	binary.Read(r, order, &dlh.PutApplType)
 
 	dlh.PutApplName = readStringFromFixedBuffer(r, MQ_PUT_APPL_NAME_LENGTH)
 	dlh.PutDate = readStringFromFixedBuffer(r, MQ_PUT_DATE_LENGTH)
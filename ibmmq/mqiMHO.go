@@ -28,31 +28,6 @@ package ibmmq
 */
 import "C"
 
-/*
-MQCMHO is a structure containing the MQ Create Message Handle Options
-*/
-type MQCMHO struct {
-	Options int32
-}
-
-/*
-MQDMHO is a structure containing the MQ Delete Message Handle Options
-*/
-type MQDMHO struct {
-	Options int32
-}
-
-/*
-NewMQCMHO fills in default values for the MQCMHO structure
-*/
-func NewMQCMHO() *MQCMHO {
-
-	cmho := new(MQCMHO)
-	cmho.Options = int32(C.MQCMHO_DEFAULT_VALIDATION)
-
-	return cmho
-}
-
 func copyCMHOtoC(mqcmho *C.MQCMHO, gocmho *MQCMHO) {
 	setMQIString((*C.char)(&mqcmho.StrucId[0]), "CMHO", 4)
 	mqcmho.Version = C.MQCMHO_VERSION_1
@@ -65,15 +40,6 @@ func copyCMHOfromC(mqcmho *C.MQCMHO, gocmho *MQCMHO) {
 	return
 }
 
-/*
-NewMQDMHO fills in default values for the MQDMHO structure
-*/
-func NewMQDMHO() *MQDMHO {
-	dmho := new(MQDMHO)
-	dmho.Options = int32(C.MQDMHO_NONE)
-	return dmho
-}
-
 func copyDMHOtoC(mqdmho *C.MQDMHO, godmho *MQDMHO) {
 	setMQIString((*C.char)(&mqdmho.StrucId[0]), "DMHO", 4)
 	mqdmho.Version = C.MQDMHO_VERSION_1
@@ -30,60 +30,10 @@ import "C"
 import "unsafe"
 
 /*
-This module contains the Message Property structures
+This module converts the Message Property structures, which are in mqitypes.go,
+to and from the C versions
 */
 
-type MQIMPO struct {
-	Options      int32
-	ReturnedName string
-	TypeString   string
-}
-
-type MQSMPO struct {
-	Options int32
-}
-
-type MQDMPO struct {
-	Options int32
-}
-
-type MQPD struct {
-	Options     int32
-	Support     int32
-	Context     int32
-	CopyOptions int32
-}
-
-func NewMQIMPO() *MQIMPO {
-	impo := new(MQIMPO)
-	impo.Options = int32(C.MQIMPO_NONE)
-	impo.ReturnedName = ""
-	impo.TypeString = ""
-
-	return impo
-}
-
-func NewMQDMPO() *MQDMPO {
-	dmpo := new(MQDMPO)
-	dmpo.Options = int32(C.MQDMPO_DEL_FIRST)
-	return dmpo
-}
-
-func NewMQSMPO() *MQSMPO {
-	smpo := new(MQSMPO)
-	smpo.Options = int32(C.MQSMPO_SET_FIRST)
-	return smpo
-}
-
-func NewMQPD() *MQPD {
-	pd := new(MQPD)
-	pd.Options = int32(C.MQPD_NONE)
-	pd.Support = int32(C.MQPD_SUPPORT_OPTIONAL)
-	pd.Context = int32(C.MQPD_NO_CONTEXT)
-	pd.CopyOptions = int32(C.MQCOPY_DEFAULT)
-	return pd
-}
-
 func copyIMPOtoC(mqimpo *C.MQIMPO, goimpo *MQIMPO) {
 	const vsbufsize = 10240
 	setMQIString((*C.char)(&mqimpo.StrucId[0]), "IMPO", 4)
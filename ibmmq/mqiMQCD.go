@@ -33,71 +33,6 @@ import (
 	"unsafe"
 )
 
-/*
-MQCD is a structure containing the MQ Channel Definition (MQCD)
-Only fields relevant to a client connection are included in the
-Go version of this structure.
-*/
-type MQCD struct {
-	ChannelName          string
-	ConnectionName       string
-	DiscInterval         int32
-	SecurityExit         string
-	SecurityUserData     string
-	MaxMsgLength         int32
-	HeartbeatInterval    int32
-	SSLCipherSpec        string
-	SSLPeerName          string
-	SSLClientAuth        int32 // Not used by client, but leave field for compatibility
-	KeepAliveInterval    int32
-	SharingConversations int32
-	PropertyControl      int32
-	ClientChannelWeight  int32
-	ConnectionAffinity   int32
-	DefReconnect         int32
-	CertificateLabel     string
-	HdrCompList          [2]int32
-	MsgCompList          [16]int32
-}
-
-/*
-NewMQCD fills in default values for the MQCD structure, based on the
-MQCD_CLIENT_CONN_DEFAULT
-*/
-func NewMQCD() *MQCD {
-
-	cd := new(MQCD)
-
-	cd.ChannelName = ""
-	cd.DiscInterval = 6000
-	cd.SecurityExit = ""
-	cd.SecurityUserData = ""
-	cd.MaxMsgLength = 4194304
-	cd.ConnectionName = ""
-	cd.HeartbeatInterval = 1
-	cd.SSLCipherSpec = ""
-	cd.SSLPeerName = ""
-	cd.SSLClientAuth = int32(C.MQSCA_REQUIRED)
-	cd.KeepAliveInterval = -1
-	cd.SharingConversations = 10
-	cd.PropertyControl = int32(C.MQPROP_COMPATIBILITY)
-	cd.ClientChannelWeight = 0
-	cd.ConnectionAffinity = int32(C.MQCAFTY_PREFERRED)
-	cd.DefReconnect = int32(C.MQRCN_NO)
-	cd.CertificateLabel = ""
-
-	cd.HdrCompList[0] = int32(C.MQCOMPRESS_NONE)
-	for i := 1; i < 2; i++ {
-		cd.HdrCompList[i] = int32(C.MQCOMPRESS_NOT_AVAILABLE)
-	}
-	cd.MsgCompList[0] = int32(C.MQCOMPRESS_NONE)
-	for i := 1; i < 16; i++ {
-		cd.MsgCompList[i] = int32(C.MQCOMPRESS_NOT_AVAILABLE)
-	}
-
-	return cd
-}
-
 /*
 It is expected that copyXXtoC and copyXXfromC will be called as
 matching pairs.
@@ -148,8 +83,8 @@ func copyCDtoC(mqcd *C.MQCD, gocd *MQCD) {
 	setMQIString((*C.char)(&mqcd.MsgRetryUserData[0]), "", C.MQ_EXIT_DATA_LENGTH)
 	mqcd.MsgRetryCount = 10
 	mqcd.MsgRetryInterval = 1000
-	mqcd.HeartbeatInterval = 1
-	mqcd.BatchInterval = 0
+	mqcd.HeartbeatInterval = C.MQLONG(gocd.HeartbeatInterval)
+	mqcd.BatchInterval = C.MQLONG(gocd.BatchInterval)
 	mqcd.NonPersistentMsgSpeed = C.MQNPMS_FAST
 	mqcd.StrucLength = C.MQCD_LENGTH_11
 	mqcd.ExitNameLength = C.MQ_EXIT_NAME_LENGTH
@@ -185,7 +120,7 @@ func copyCDtoC(mqcd *C.MQCD, gocd *MQCD) {
 	// mqcd.SSLClientAuth = C.MQLONG(gocd.SSLClientAuth)
 	mqcd.KeepAliveInterval = C.MQLONG(gocd.KeepAliveInterval)
 	setMQIString((*C.char)(&mqcd.LocalAddress[0]), "", C.MQ_LOCAL_ADDRESS_LENGTH)
-	mqcd.BatchHeartbeat = 0
+	mqcd.BatchHeartbeat = C.MQLONG(gocd.BatchHeartbeat)
 	for i := 0; i < 2; i++ {
 		mqcd.HdrCompList[i] = C.MQLONG(gocd.HdrCompList[i])
 	}
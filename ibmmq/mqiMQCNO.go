@@ -204,87 +204,6 @@ size_t getMaxCSPLength() {
 import "C"
 import "unsafe"
 
-/*
-MQCNO is a structure containing the MQ Connection Options (MQCNO)
-Note that only a subset of the real structure is exposed in this
-version.
-*/
-type MQCNO struct {
-	Version       int32
-	Options       int32
-	SecurityParms *MQCSP
-	CCDTUrl       string
-	ClientConn    *MQCD
-	SSLConfig     *MQSCO
-	ApplName      string
-	BalanceParms  *MQBNO
-}
-
-/*
-MQCSP is a structure containing the MQ Security Parameters (MQCSP)
-*/
-type MQCSP struct {
-	AuthenticationType int32
-	UserId             string
-	Password           string
-	InitialKey         string
-	Token              string
-}
-
-/*
-MQBNO is a structure to allow an application provision of balancing options
-*/
-type MQBNO struct {
-	ApplType int32
-	Timeout  int32
-	Options  int32
-}
-
-/*
-NewMQCNO fills in default values for the MQCNO structure
-*/
-func NewMQCNO() *MQCNO {
-
-	cno := new(MQCNO)
-	cno.Version = int32(C.MQCNO_VERSION_1)
-	cno.Options = int32(C.MQCNO_NONE)
-	cno.SecurityParms = nil
-	cno.ClientConn = nil
-	cno.CCDTUrl = ""
-	cno.ApplName = ""
-
-	return cno
-}
-
-/*
-NewMQCSP fills in default values for the MQCSP structure
-*/
-func NewMQCSP() *MQCSP {
-
-	csp := new(MQCSP)
-	csp.AuthenticationType = int32(C.MQCSP_AUTH_NONE)
-	csp.UserId = ""
-	csp.Password = ""
-	csp.InitialKey = ""
-	csp.Token = ""
-
-	return csp
-}
-
-/*
-NewMQBNO fills in default values for the MQBNO structure. We
-use the constant values directly as the #define macros may not be
-available when building against older levels of the MQ client code.
-*/
-func NewMQBNO() *MQBNO {
-	bno := new(MQBNO)
-	bno.ApplType = 0 /* MQBNO_BALTYPE_SIMPLE */
-	bno.Timeout = -1 /* MQBNO_TIMEOUT_AS_DEFAULT */
-	bno.Options = 0  /* MQBNO_OPTIONS_NONE */
-
-	return bno
-}
-
 func copyCNOtoC(mqcno *C.MQCNO, gocno *MQCNO) {
 	var i int
 	var mqcsp C.PMQCSP
@@ -27,53 +27,6 @@ package ibmmq
 
 */
 import "C"
-import "bytes"
-
-/*
-MQGMO is a structure containing the MQ Get Message Options (MQGMO)
-*/
-type MQGMO struct {
-	Version        int32
-	Options        int32
-	WaitInterval   int32
-	Signal1        int32
-	Signal2        int32
-	ResolvedQName  string
-	MatchOptions   int32
-	GroupStatus    rune
-	SegmentStatus  rune
-	Segmentation   rune
-	Reserved1      rune
-	MsgToken       []byte
-	ReturnedLength int32
-	Reserved2      int32
-	MsgHandle      MQMessageHandle
-}
-
-/*
-NewMQGMO fills in default values for the MQGMO structure
-*/
-func NewMQGMO() *MQGMO {
-
-	gmo := new(MQGMO)
-	gmo.Version = int32(C.MQGMO_VERSION_1)
-	gmo.Options = int32(C.MQGMO_NO_WAIT + C.MQGMO_PROPERTIES_AS_Q_DEF)
-	gmo.WaitInterval = int32(C.MQWI_UNLIMITED)
-	gmo.Signal1 = 0
-	gmo.Signal2 = 0
-	gmo.ResolvedQName = ""
-	gmo.MatchOptions = int32(C.MQMO_MATCH_MSG_ID + C.MQMO_MATCH_CORREL_ID)
-	gmo.GroupStatus = rune(C.MQGS_NOT_IN_GROUP)
-	gmo.SegmentStatus = rune(C.MQSS_NOT_A_SEGMENT)
-	gmo.Segmentation = rune(C.MQSEG_INHIBITED)
-	gmo.Reserved1 = ' '
-	gmo.MsgToken = bytes.Repeat([]byte{0}, C.MQ_MSG_TOKEN_LENGTH)
-	gmo.ReturnedLength = int32(C.MQRL_UNDEFINED)
-	gmo.Reserved2 = 0
-	gmo.MsgHandle.hMsg = C.MQHM_NONE
-
-	return gmo
-}
 
 func checkGMO(gogmo *MQGMO, verb string) error {
 	mqrc := C.MQRC_NONE
@@ -112,6 +65,20 @@ func copyGMOtoC(mqgmo *C.MQGMO, gogmo *MQGMO) {
 	}
 	mqgmo.ReturnedLength = C.MQLONG(gogmo.ReturnedLength)
 	mqgmo.Reserved2 = C.MQLONG(gogmo.Reserved2)
+
+	// The cooperative browse options and matching on the MsgToken are only
+	// recognised in later versions of the structure
+	if gogmo.MatchOptions&C.MQMO_MATCH_MSG_TOKEN != 0 {
+		if mqgmo.Version < C.MQGMO_VERSION_3 {
+			mqgmo.Version = C.MQGMO_VERSION_3
+		}
+	}
+	if gogmo.Options&(C.MQGMO_MARK_BROWSE_HANDLE|C.MQGMO_MARK_BROWSE_CO_OP|C.MQGMO_UNMARK_BROWSE_HANDLE|C.MQGMO_UNMARK_BROWSE_CO_OP|C.MQGMO_UNMARKED_BROWSE_MSG) != 0 {
+		if mqgmo.Version < C.MQGMO_VERSION_4 {
+			mqgmo.Version = C.MQGMO_VERSION_4
+		}
+	}
+
 	if gogmo.MsgHandle.hMsg != C.MQHM_NONE {
 		if mqgmo.Version < C.MQGMO_VERSION_4 {
 			mqgmo.Version = C.MQGMO_VERSION_4
@@ -28,83 +28,6 @@ package ibmmq
 */
 import "C"
 
-import (
-	"bytes"
-	"time"
-)
-
-/*
-MQMD is a structure containing the MQ Message Descriptor (MQMD)
-*/
-type MQMD struct {
-	Version          int32
-	Report           int32
-	MsgType          int32
-	Expiry           int32
-	Feedback         int32
-	Encoding         int32
-	CodedCharSetId   int32
-	Format           string
-	Priority         int32
-	Persistence      int32
-	MsgId            []byte
-	CorrelId         []byte
-	BackoutCount     int32
-	ReplyToQ         string
-	ReplyToQMgr      string
-	UserIdentifier   string
-	AccountingToken  []byte
-	ApplIdentityData string
-	PutApplType      int32
-	PutApplName      string
-	PutDate          string    // Deprecated
-	PutTime          string    // Deprecated
-	PutDateTime      time.Time // Combines the PutDate and PutTime fields - takes precedence if both styles are used
-	ApplOriginData   string
-	GroupId          []byte
-	MsgSeqNumber     int32
-	Offset           int32
-	MsgFlags         int32
-	OriginalLength   int32
-}
-
-/*
-NewMQMD fills in default values for the MQMD structure
-*/
-func NewMQMD() *MQMD {
-	md := new(MQMD)
-	md.Version = int32(C.MQMD_VERSION_1)
-	md.Report = int32(C.MQRO_NONE)
-	md.MsgType = int32(C.MQMT_DATAGRAM)
-	md.Expiry = int32(C.MQEI_UNLIMITED)
-	md.Feedback = int32(C.MQFB_NONE)
-	md.Encoding = int32(C.MQENC_NATIVE)
-	md.CodedCharSetId = int32(C.MQCCSI_Q_MGR)
-	md.Format = space8
-	md.Priority = int32(C.MQPRI_PRIORITY_AS_Q_DEF)
-	md.Persistence = int32(C.MQPER_PERSISTENCE_AS_Q_DEF)
-	md.MsgId = bytes.Repeat([]byte{0}, C.MQ_MSG_ID_LENGTH)
-	md.CorrelId = bytes.Repeat([]byte{0}, C.MQ_CORREL_ID_LENGTH)
-	md.BackoutCount = 0
-	md.ReplyToQ = ""
-	md.ReplyToQMgr = ""
-	md.UserIdentifier = ""
-	md.AccountingToken = bytes.Repeat([]byte{0}, C.MQ_ACCOUNTING_TOKEN_LENGTH)
-	md.ApplIdentityData = ""
-	md.PutApplType = int32(C.MQAT_NO_CONTEXT)
-	md.PutApplName = ""
-	md.PutDate = ""
-	md.PutTime = ""
-	md.ApplOriginData = ""
-	md.GroupId = bytes.Repeat([]byte{0}, C.MQ_GROUP_ID_LENGTH)
-	md.MsgSeqNumber = 1
-	md.Offset = 0
-	md.MsgFlags = int32(C.MQMF_NONE)
-	md.OriginalLength = int32(C.MQOL_UNDEFINED)
-
-	return md
-}
-
 func checkMD(gomd *MQMD, verb string) error {
 	mqrc := C.MQRC_NONE
 
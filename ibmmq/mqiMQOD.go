@@ -28,64 +28,7 @@ package ibmmq
 */
 import "C"
 
-import (
-	"bytes"
-	"unsafe"
-)
-
-/*
-MQOD is a structure containing the MQ Object Descriptor (MQOD)
-*/
-type MQOD struct {
-	Version         int32
-	ObjectType      int32
-	ObjectName      string
-	ObjectQMgrName  string
-	DynamicQName    string
-	AlternateUserId string
-
-	// TODO: These fields are not currently mapped. The Dist List feature is not
-	// really supported here as Pub/Sub is the recommended approach.
-	//RecsPresent       int32
-	//KnownDestCount    int32
-	//UnknownDestCount  int32
-	//InvalidDestCount  int32
-	//ObjectRec     []MQOR
-	//ResponseRec   []MQOR
-
-	AlternateSecurityId []byte
-	ResolvedQName       string
-	ResolvedQMgrName    string
-
-	ObjectString    string
-	SelectionString string
-	ResObjectString string
-	ResolvedType    int32
-}
-
-/*
-NewMQOD fills in default values for the MQOD structure
-*/
-func NewMQOD() *MQOD {
-
-	od := new(MQOD)
-	od.Version = 1
-	od.ObjectType = C.MQOT_Q
-	od.ObjectName = ""
-	od.ObjectQMgrName = ""
-	od.DynamicQName = "AMQ.*"
-	od.AlternateUserId = ""
-
-	od.AlternateSecurityId = bytes.Repeat([]byte{0}, C.MQ_SECURITY_ID_LENGTH)
-	od.ResolvedQName = ""
-	od.ResolvedQMgrName = ""
-
-	od.ObjectString = ""
-	od.SelectionString = ""
-	od.ResObjectString = ""
-	od.ResolvedType = C.MQOT_NONE
-	return od
-}
+import "unsafe"
 
 func checkOD(good *MQOD, verb string) error {
 	mqrc := C.MQRC_NONE
@@ -160,7 +103,9 @@ func copyODtoC(mqod *C.MQOD, good *MQOD) {
 	} else {
 		mqod.SelectionString.VSPtr = (C.MQPTR)(C.CString(good.SelectionString))
 	}
-	if mqod.SelectionString.VSLength > 0 || mqod.ObjectString.VSLength > 0 {
+	// A topic may be opened using only the ObjectName, but we still need
+	// the later version of the structure to get back the ResObjectString
+	if mqod.SelectionString.VSLength > 0 || mqod.ObjectString.VSLength > 0 || good.ObjectType == C.MQOT_TOPIC {
 		if mqod.Version < C.MQOD_VERSION_4 {
 			mqod.Version = C.MQOD_VERSION_4
 		}
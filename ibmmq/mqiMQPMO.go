@@ -28,57 +28,6 @@ package ibmmq
 */
 import "C"
 
-/*
-MQPMO is a structure containing the MQ Put MessageOptions (MQPMO)
-*/
-type MQPMO struct {
-	Version          int32
-	Options          int32
-	Timeout          int32
-	Context          *MQObject
-	KnownDestCount   int32
-	UnknownDestCount int32
-	InvalidDestCount int32
-	ResolvedQName    string
-	ResolvedQMgrName string
-
-	// TODO: These fields are not currently mapped. The Dist List feature is not
-	// fully supported as Pub/Sub is the recommended approach.
-	//RecsPresent       int32
-	//PutMsgRec   []MQPMR
-	//ResponseRec []MQRR
-
-	OriginalMsgHandle MQMessageHandle
-	NewMsgHandle      MQMessageHandle
-	Action            int32
-	PubLevel          int32
-}
-
-/*
-NewMQPMO fills in default values for the MQPMO structure
-*/
-func NewMQPMO() *MQPMO {
-
-	pmo := new(MQPMO)
-
-	pmo.Version = int32(C.MQPMO_VERSION_1)
-	pmo.Options = int32(C.MQPMO_NONE)
-	pmo.Timeout = -1
-	pmo.Context = nil
-	pmo.KnownDestCount = 0
-	pmo.UnknownDestCount = 0
-	pmo.InvalidDestCount = 0
-	pmo.ResolvedQName = ""
-	pmo.ResolvedQMgrName = ""
-
-	pmo.OriginalMsgHandle.hMsg = C.MQHM_NONE
-	pmo.NewMsgHandle.hMsg = C.MQHM_NONE
-	pmo.Action = int32(C.MQACTP_NEW)
-	pmo.PubLevel = 9
-
-	return pmo
-}
-
 func copyPMOtoC(mqpmo *C.MQPMO, gopmo *MQPMO) {
 
 	setMQIString((*C.char)(&mqpmo.StrucId[0]), "PMO ", 4)
@@ -55,43 +55,6 @@ void freeScoKeyRepoPassword(MQSCO *mqsco) {
 */
 import "C"
 
-/*
-MQSCO is a structure containing the MQ SSL/TLS Configuration (MQSCO)
-options.
-*/
-type MQSCO struct {
-	KeyRepository          string
-	CryptoHardware         string
-	KeyResetCount          int32
-	FipsRequired           bool
-	EncryptionPolicySuiteB [4]int32
-	CertificateValPolicy   int32
-	CertificateLabel       string
-	KeyRepoPassword        string
-}
-
-/*
-NewMQSCO fills in default values for the MQSCO structure
-*/
-func NewMQSCO() *MQSCO {
-
-	sco := new(MQSCO)
-
-	sco.KeyRepository = ""
-	sco.CryptoHardware = ""
-	sco.KeyResetCount = int32(C.MQSCO_RESET_COUNT_DEFAULT)
-	sco.FipsRequired = false
-	sco.EncryptionPolicySuiteB[0] = int32(C.MQ_SUITE_B_NONE)
-	for i := 1; i < 4; i++ {
-		sco.EncryptionPolicySuiteB[i] = int32(C.MQ_SUITE_B_NOT_AVAILABLE)
-	}
-	sco.CertificateValPolicy = int32(C.MQ_CERT_VAL_POLICY_DEFAULT)
-	sco.CertificateLabel = ""
-	sco.KeyRepoPassword = ""
-
-	return sco
-}
-
 /*
 It is expected that copyXXtoC and copyXXfromC will be called as
 matching pairs.
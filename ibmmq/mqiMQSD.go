@@ -28,67 +28,7 @@ package ibmmq
 */
 import "C"
 
-import (
-	"bytes"
-	"unsafe"
-)
-
-/*
-MQSD is a structure containing the MQ Subscription Descriptor (MQSD)
-*/
-type MQSD struct {
-	Version int32
-	Options int32
-
-	ObjectName          string
-	AlternateUserId     string
-	AlternateSecurityId []byte
-	SubExpiry           int32
-	ObjectString        string
-	SubName             string
-	SubUserData         string
-	SubCorrelId         []byte
-
-	PubPriority        int32
-	PubAccountingToken []byte
-
-	PubApplIdentityData string
-
-	SelectionString string
-	SubLevel        int32
-	ResObjectString string
-}
-
-/*
-NewMQSD fills in default values for the MQSD structure
-*/
-func NewMQSD() *MQSD {
-
-	sd := new(MQSD)
-
-	sd.Version = int32(C.MQSD_VERSION_1)
-	sd.Options = 0
-
-	sd.ObjectName = ""
-	sd.AlternateUserId = ""
-	sd.AlternateSecurityId = bytes.Repeat([]byte{0}, C.MQ_SECURITY_ID_LENGTH)
-	sd.SubExpiry = int32(C.MQEI_UNLIMITED)
-	sd.ObjectString = ""
-	sd.SubName = ""
-	sd.SubUserData = ""
-	sd.SubCorrelId = bytes.Repeat([]byte{0}, C.MQ_CORREL_ID_LENGTH)
-
-	sd.PubPriority = int32(C.MQPRI_PRIORITY_AS_PUBLISHED)
-	sd.PubAccountingToken = bytes.Repeat([]byte{0}, C.MQ_ACCOUNTING_TOKEN_LENGTH)
-
-	sd.PubApplIdentityData = ""
-
-	sd.SelectionString = ""
-	sd.SubLevel = 1
-	sd.ResObjectString = ""
-
-	return sd
-}
+import "unsafe"
 
 func checkSD(gosd *MQSD, verb string) error {
 	mqrc := C.MQRC_NONE
@@ -99,6 +39,14 @@ func checkSD(gosd *MQSD, verb string) error {
 	if len(gosd.PubAccountingToken) != C.MQ_ACCOUNTING_TOKEN_LENGTH {
 		mqrc = C.MQRC_SD_ERROR
 	}
+	// Only one wildcard scheme can be chosen, and the SubLevel
+	// has to be in the range the queue manager allows
+	if gosd.Options&C.MQSO_WILDCARD_CHAR != 0 && gosd.Options&C.MQSO_WILDCARD_TOPIC != 0 {
+		mqrc = C.MQRC_OPTIONS_ERROR
+	}
+	if gosd.SubLevel < 0 || gosd.SubLevel > 9 {
+		mqrc = C.MQRC_SD_ERROR
+	}
 	if mqrc != C.MQRC_NONE {
 		mqreturn := MQReturn{MQCC: C.MQCC_FAILED,
 			MQRC: int32(mqrc),
@@ -19,13 +19,6 @@ package ibmmq
      Mark Taylor - Initial Contribution
 */
 
-/*
-#include <stdlib.h>
-#include <cmqc.h>
-#include <cmqcfc.h>
-*/
-import "C"
-
 import (
 	"bytes"
 	"encoding/binary"
@@ -77,6 +70,7 @@ type PCFParameter struct {
 	CodedCharSetId int32
 	ParameterCount int32
 	GroupList      []*PCFParameter
+	Operator       int32 // MQCFOP_* for the INTEGER_FILTER and STRING_FILTER types
 	strucLength    int32 // Do not need to expose these
 	stringLength   int32 // lengths
 }
@@ -86,14 +80,14 @@ NewMQCFH returns a PCF Command Header structure with correct initialisation
 */
 func NewMQCFH() *MQCFH {
 	cfh := new(MQCFH)
-	cfh.Type = C.MQCFT_COMMAND
-	cfh.StrucLength = C.MQCFH_STRUC_LENGTH
-	cfh.Version = C.MQCFH_VERSION_1
-	cfh.Command = C.MQCMD_NONE
+	cfh.Type = MQCFT_COMMAND
+	cfh.StrucLength = MQCFH_STRUC_LENGTH
+	cfh.Version = MQCFH_VERSION_1
+	cfh.Command = MQCMD_NONE
 	cfh.MsgSeqNumber = 1
-	cfh.Control = C.MQCFC_LAST
-	cfh.CompCode = C.MQCC_OK
-	cfh.Reason = C.MQRC_NONE
+	cfh.Control = MQCFC_LAST
+	cfh.CompCode = MQCC_OK
+	cfh.Reason = MQRC_NONE
 	cfh.ParameterCount = 0
 
 	return cfh
@@ -104,12 +98,12 @@ NewMQEPH returns a PCF Embedded Header structure with correct initialisation
 */
 func NewMQEPH() *MQEPH {
 	eph := new(MQEPH)
-	eph.StrucLength = C.MQEPH_STRUC_LENGTH_FIXED
-	eph.Version = C.MQCFH_VERSION_1
+	eph.StrucLength = MQEPH_STRUC_LENGTH_FIXED
+	eph.Version = MQCFH_VERSION_1
 	eph.Encoding = 0
-	eph.CodedCharSetId = C.MQCCSI_UNDEFINED
-	eph.Format = C.MQFMT_NONE
-	eph.Flags = C.MQEPH_NONE
+	eph.CodedCharSetId = MQCCSI_UNDEFINED
+	eph.Format = MQFMT_NONE
+	eph.Flags = MQEPH_NONE
 
 	return eph
 }
@@ -157,8 +151,8 @@ func (p *PCFParameter) Bytes() []byte {
 	var buf []byte
 
 	switch p.Type {
-	case C.MQCFT_GROUP:
-		buf = make([]byte, C.MQCFGR_STRUC_LENGTH)
+	case MQCFT_GROUP:
+		buf = make([]byte, MQCFGR_STRUC_LENGTH)
 		offset := 0
 		l := len(p.GroupList)
 
@@ -174,8 +168,8 @@ func (p *PCFParameter) Bytes() []byte {
 			buf = append(buf, p.GroupList[i].Bytes()...)
 		}
 
-	case C.MQCFT_INTEGER:
-		buf = make([]byte, C.MQCFIN_STRUC_LENGTH)
+	case MQCFT_INTEGER:
+		buf = make([]byte, MQCFIN_STRUC_LENGTH)
 		offset := 0
 
 		endian.PutUint32(buf[offset:], uint32(p.Type))
@@ -187,9 +181,9 @@ func (p *PCFParameter) Bytes() []byte {
 		endian.PutUint32(buf[offset:], uint32(p.Int64Value[0]))
 		offset += 4
 
-	case C.MQCFT_INTEGER_LIST:
+	case MQCFT_INTEGER_LIST:
 		l := len(p.Int64Value)
-		buf = make([]byte, C.MQCFIL_STRUC_LENGTH_FIXED+4*l)
+		buf = make([]byte, int(MQCFIL_STRUC_LENGTH_FIXED)+4*l)
 		offset := 0
 
 		endian.PutUint32(buf[offset:], uint32(p.Type))
@@ -205,8 +199,38 @@ func (p *PCFParameter) Bytes() []byte {
 			offset += 4
 		}
 
-	case C.MQCFT_STRING:
-		buf = make([]byte, C.MQCFST_STRUC_LENGTH_FIXED+roundTo4(int32(len(p.String[0]))))
+	case MQCFT_STRING:
+		buf = make([]byte, MQCFST_STRUC_LENGTH_FIXED+roundTo4(int32(len(p.String[0]))))
+		offset := 0
+		endian.PutUint32(buf[offset:], uint32(p.Type))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(len(buf)))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(p.Parameter))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(MQCCSI_DEFAULT))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(len(p.String[0])))
+		offset += 4
+		copy(buf[offset:], []byte(p.String[0]))
+
+	case MQCFT_INTEGER_FILTER:
+		buf = make([]byte, MQCFIF_STRUC_LENGTH)
+		offset := 0
+
+		endian.PutUint32(buf[offset:], uint32(p.Type))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(len(buf)))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(p.Parameter))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(p.Operator))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(p.Int64Value[0]))
+		offset += 4
+
+	case MQCFT_STRING_FILTER:
+		buf = make([]byte, MQCFSF_STRUC_LENGTH_FIXED+roundTo4(int32(len(p.String[0]))))
 		offset := 0
 		endian.PutUint32(buf[offset:], uint32(p.Type))
 		offset += 4
@@ -214,7 +238,9 @@ func (p *PCFParameter) Bytes() []byte {
 		offset += 4
 		endian.PutUint32(buf[offset:], uint32(p.Parameter))
 		offset += 4
-		endian.PutUint32(buf[offset:], uint32(C.MQCCSI_DEFAULT))
+		endian.PutUint32(buf[offset:], uint32(p.Operator))
+		offset += 4
+		endian.PutUint32(buf[offset:], uint32(MQCCSI_DEFAULT))
 		offset += 4
 		endian.PutUint32(buf[offset:], uint32(len(p.String[0])))
 		offset += 4
@@ -232,7 +258,7 @@ func ReadPCFHeader(buf []byte) (*MQCFH, int) {
 
 	fullLen := len(buf)
 
-	if fullLen < C.MQCFH_STRUC_LENGTH {
+	if fullLen < int(MQCFH_STRUC_LENGTH) {
 		return nil, 0
 	}
 
@@ -260,7 +286,7 @@ func ReadPCFEmbeddedHeader(buf []byte) (*MQEPH, int) {
 	var dummy int32
 
 	fullLen := len(buf)
-	if fullLen < C.MQEPH_STRUC_LENGTH_FIXED {
+	if fullLen < int(MQEPH_STRUC_LENGTH_FIXED) {
 		return nil, 0
 	}
 
@@ -313,12 +339,12 @@ func ReadPCFParameter(buf []byte) (*PCFParameter, int) {
 	switch pcfParm.Type {
 	// There are more PCF element types but the monitoring packages only
 	// needed a subset. We can add the others later if necessary.
-	case C.MQCFT_INTEGER:
+	case MQCFT_INTEGER:
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &i32)
 		pcfParm.Int64Value = append(pcfParm.Int64Value, int64(i32))
 
-	case C.MQCFT_INTEGER_LIST:
+	case MQCFT_INTEGER_LIST:
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &count)
 		for i := 0; i < int(count); i++ {
@@ -326,13 +352,13 @@ func ReadPCFParameter(buf []byte) (*PCFParameter, int) {
 			pcfParm.Int64Value = append(pcfParm.Int64Value, int64(i32))
 		}
 
-	case C.MQCFT_INTEGER64:
+	case MQCFT_INTEGER64:
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &mqlong) // Used for alignment
 		binary.Read(p, endian, &i64)
 		pcfParm.Int64Value = append(pcfParm.Int64Value, i64)
 
-	case C.MQCFT_INTEGER64_LIST:
+	case MQCFT_INTEGER64_LIST:
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &count)
 		for i := 0; i < int(count); i++ {
@@ -340,8 +366,8 @@ func ReadPCFParameter(buf []byte) (*PCFParameter, int) {
 			pcfParm.Int64Value = append(pcfParm.Int64Value, i64)
 		}
 
-	case C.MQCFT_STRING:
-		offset := int32(C.MQCFST_STRUC_LENGTH_FIXED)
+	case MQCFT_STRING:
+		offset := int32(MQCFST_STRUC_LENGTH_FIXED)
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &pcfParm.CodedCharSetId)
 		binary.Read(p, endian, &pcfParm.stringLength)
@@ -350,20 +376,20 @@ func ReadPCFParameter(buf []byte) (*PCFParameter, int) {
 		pcfParm.String = append(pcfParm.String, s)
 		p.Next(int(pcfParm.strucLength - offset))
 
-	case C.MQCFT_STRING_LIST:
+	case MQCFT_STRING_LIST:
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &pcfParm.CodedCharSetId)
 		binary.Read(p, endian, &count)
 		binary.Read(p, endian, &pcfParm.stringLength)
 		for i := 0; i < int(count); i++ {
-			offset := C.MQCFSL_STRUC_LENGTH_FIXED + i*int(pcfParm.stringLength)
+			offset := int(MQCFSL_STRUC_LENGTH_FIXED) + i*int(pcfParm.stringLength)
 			s := string(buf[offset : int(pcfParm.stringLength)+offset])
 			s = trimToNull(s)
 			pcfParm.String = append(pcfParm.String, s)
 		}
-		p.Next(int(pcfParm.strucLength - C.MQCFSL_STRUC_LENGTH_FIXED))
+		p.Next(int(pcfParm.strucLength - MQCFSL_STRUC_LENGTH_FIXED))
 
-	case C.MQCFT_GROUP:
+	case MQCFT_GROUP:
 		// This reads the entire group, including the group elements.
 		// Which might in turn be nested groups
 		binary.Read(p, endian, &pcfParm.Parameter)
@@ -377,16 +403,33 @@ func ReadPCFParameter(buf []byte) (*PCFParameter, int) {
 		}
 		return pcfParm, offset
 
-	case C.MQCFT_BYTE_STRING:
+	case MQCFT_BYTE_STRING:
 		// The byte string is converted to a hex string as that's how
 		// we expect to use it in reporting
-		offset := int32(C.MQCFBS_STRUC_LENGTH_FIXED)
+		offset := int32(MQCFBS_STRUC_LENGTH_FIXED)
 		binary.Read(p, endian, &pcfParm.Parameter)
 		binary.Read(p, endian, &pcfParm.stringLength)
 		s := hex.EncodeToString(buf[offset : pcfParm.stringLength+offset])
 		pcfParm.String = append(pcfParm.String, s)
 		p.Next(int(pcfParm.strucLength - offset))
 
+	case MQCFT_INTEGER_FILTER:
+		binary.Read(p, endian, &pcfParm.Parameter)
+		binary.Read(p, endian, &pcfParm.Operator)
+		binary.Read(p, endian, &i32)
+		pcfParm.Int64Value = append(pcfParm.Int64Value, int64(i32))
+
+	case MQCFT_STRING_FILTER:
+		offset := int32(MQCFSF_STRUC_LENGTH_FIXED)
+		binary.Read(p, endian, &pcfParm.Parameter)
+		binary.Read(p, endian, &pcfParm.Operator)
+		binary.Read(p, endian, &pcfParm.CodedCharSetId)
+		binary.Read(p, endian, &pcfParm.stringLength)
+		s := string(buf[offset : pcfParm.stringLength+offset])
+		s = trimToNull(s)
+		pcfParm.String = append(pcfParm.String, s)
+		p.Next(int(pcfParm.strucLength - offset))
+
 	default:
 		// This should not happen, but if it does then dump various pieces of
 		// debug information that might help solve the problem.
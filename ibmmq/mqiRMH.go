@@ -0,0 +1,226 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+/*
+MQRMH is the Reference Message Header. A reference message refers to data held
+outside MQ, such as a file. A message exit on the sending channel is expected to
+read the referenced data and append it to the message, with a matching exit on the
+receiving side writing it out again. This package only builds and parses the header;
+it does not provide the exits.
+
+The four environment and name strings are held in the variable-length part of
+the structure following the fixed fields. Their offsets and lengths are
+calculated when the structure is serialised.
+*/
+type MQRMH struct {
+	Encoding           int32
+	CodedCharSetId     int32
+	Format             string
+	Flags              int32
+	ObjectType         string
+	ObjectInstanceId   []byte
+	SrcEnv             string
+	SrcName            string
+	DestEnv            string
+	DestName           string
+	DataLogicalLength  int32
+	DataLogicalOffset  int32
+	DataLogicalOffset2 int32
+	strucLength        int // Not exported
+}
+
+/*
+NewMQRMH fills in default values for the MQRMH structure. As with NewMQDLH, the
+MQMD fields describing the message body are copied into the header, and the MQMD
+is changed to say that the message now starts with an MQRMH.
+*/
+func NewMQRMH(md *MQMD) *MQRMH {
+	rmh := new(MQRMH)
+	rmh.Encoding = MQENC_NATIVE
+	rmh.CodedCharSetId = MQCCSI_UNDEFINED
+	rmh.Format = ""
+	rmh.Flags = MQRMHF_LAST
+	rmh.ObjectInstanceId = make([]byte, MQ_OBJECT_INSTANCE_ID_LENGTH)
+	rmh.strucLength = int(MQRMH_CURRENT_LENGTH)
+
+	if md != nil {
+		rmh.Encoding = md.Encoding
+		if md.CodedCharSetId == MQCCSI_DEFAULT {
+			rmh.CodedCharSetId = MQCCSI_INHERIT
+		} else {
+			rmh.CodedCharSetId = md.CodedCharSetId
+		}
+		rmh.Format = md.Format
+
+		md.Format = MQFMT_REF_MSG_HEADER
+		md.CodedCharSetId = MQCCSI_Q_MGR
+	}
+
+	return rmh
+}
+
+/*
+SetDataRange sets the offset and length of the referenced data. The offset is
+64 bits, split across the DataLogicalOffset and DataLogicalOffset2 fields.
+*/
+func (rmh *MQRMH) SetDataRange(offset int64, length int32) {
+	rmh.DataLogicalOffset = int32(uint32(offset))
+	rmh.DataLogicalOffset2 = int32(uint32(offset >> 32))
+	rmh.DataLogicalLength = length
+}
+
+/*
+DataOffset returns the 64-bit offset of the referenced data
+*/
+func (rmh *MQRMH) DataOffset() int64 {
+	return int64(uint32(rmh.DataLogicalOffset2))<<32 | int64(uint32(rmh.DataLogicalOffset))
+}
+
+/*
+Bytes serialises the MQRMH, including the variable-length strings, so it can be
+put at the start of a message
+*/
+func (rmh *MQRMH) Bytes() []byte {
+	fixedLen := int(MQRMH_CURRENT_LENGTH)
+	strs := []string{rmh.SrcEnv, rmh.SrcName, rmh.DestEnv, rmh.DestName}
+
+	// The total length is kept to a multiple of 4
+	varLen := 0
+	for _, s := range strs {
+		varLen += len(s)
+	}
+	rmh.strucLength = (fixedLen + varLen + 3) &^ 3
+
+	buf := make([]byte, rmh.strucLength)
+	offset := 0
+
+	copy(buf[offset:], "RMH ")
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(MQRMH_CURRENT_VERSION))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rmh.strucLength))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rmh.Encoding))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rmh.CodedCharSetId))
+	offset += 4
+	copy(buf[offset:], (rmh.Format + space8)[0:8])
+	offset += int(MQ_FORMAT_LENGTH)
+	endian.PutUint32(buf[offset:], uint32(rmh.Flags))
+	offset += 4
+	copy(buf[offset:], (rmh.ObjectType + space8)[0:8])
+	offset += 8
+	copy(buf[offset:], rmh.ObjectInstanceId)
+	offset += int(MQ_OBJECT_INSTANCE_ID_LENGTH)
+
+	// Each string is described by a length and an offset from the start of the structure
+	varOffset := fixedLen
+	for _, s := range strs {
+		endian.PutUint32(buf[offset:], uint32(len(s)))
+		offset += 4
+		if len(s) > 0 {
+			endian.PutUint32(buf[offset:], uint32(varOffset))
+			copy(buf[varOffset:], s)
+			varOffset += len(s)
+		}
+		offset += 4
+	}
+
+	endian.PutUint32(buf[offset:], uint32(rmh.DataLogicalLength))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rmh.DataLogicalOffset))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rmh.DataLogicalOffset2))
+
+	return buf
+}
+
+/*
+We have a byte array for the message contents. The start of that buffer
+is the MQRMH structure. The fixed fields are read in order, and then the
+strings are extracted from the variable part using their offsets.
+*/
+func getHeaderRMH(md *MQMD, buf []byte) (*MQRMH, int, error) {
+	return parseRMH(buf, endian)
+}
+
+func parseRMH(buf []byte, order binary.ByteOrder) (*MQRMH, int, error) {
+	var version int32
+	var strucLength int32
+	var lengths [4]int32
+	var offsets [4]int32
+
+	mqreturn := &MQReturn{MQCC: int32(MQCC_FAILED),
+		MQRC: int32(MQRC_RMH_ERROR),
+	}
+
+	if len(buf) < int(MQRMH_CURRENT_LENGTH) {
+		return nil, 0, mqreturn
+	}
+
+	rmh := NewMQRMH(nil)
+
+	r := bytes.NewBuffer(buf)
+	_ = readStringFromFixedBuffer(r, 4) // StrucId
+	binary.Read(r, order, &version)
+	binary.Read(r, order, &strucLength)
+	binary.Read(r, order, &rmh.Encoding)
+	binary.Read(r, order, &rmh.CodedCharSetId)
+	rmh.Format = readStringFromFixedBuffer(r, MQ_FORMAT_LENGTH)
+	binary.Read(r, order, &rmh.Flags)
+	rmh.ObjectType = readStringFromFixedBuffer(r, 8)
+	rmh.ObjectInstanceId = make([]byte, MQ_OBJECT_INSTANCE_ID_LENGTH)
+	binary.Read(r, order, rmh.ObjectInstanceId)
+	for i := 0; i < 4; i++ {
+		binary.Read(r, order, &lengths[i])
+		binary.Read(r, order, &offsets[i])
+	}
+	binary.Read(r, order, &rmh.DataLogicalLength)
+	binary.Read(r, order, &rmh.DataLogicalOffset)
+	binary.Read(r, order, &rmh.DataLogicalOffset2)
+
+	if int(strucLength) > len(buf) || strucLength < MQRMH_CURRENT_LENGTH {
+		return nil, 0, mqreturn
+	}
+	rmh.strucLength = int(strucLength)
+
+	strs := make([]string, 4)
+	for i := 0; i < 4; i++ {
+		if lengths[i] == 0 {
+			continue
+		}
+		if offsets[i] < 0 || lengths[i] < 0 || offsets[i]+lengths[i] > strucLength {
+			return nil, 0, mqreturn
+		}
+		strs[i] = string(buf[offsets[i] : offsets[i]+lengths[i]])
+	}
+	rmh.SrcEnv, rmh.SrcName, rmh.DestEnv, rmh.DestName = strs[0], strs[1], strs[2], strs[3]
+
+	return rmh, rmh.strucLength, nil
+}
@@ -0,0 +1,119 @@
+//go:build !cgo
+// +build !cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+When cgo is not available, this package only has the MQI constants and the structures
+in mqitypes.go, the PCF functions, the QMgrConnection and Object interfaces, and the
+in-memory queue manager from mqifake.go. That is enough to build and unit test
+application code, and packages such as mqmetric, on a machine without the MQ headers
+and libraries.
+
+The types and functions here stand in for the ones in mqi.go, which hold C handles.
+They cannot be used to call the MQI: the verbs all fail with MQRC_FUNCTION_NOT_SUPPORTED.
+*/
+
+import "fmt"
+
+type MQQueueManager struct {
+	Name string
+}
+
+type MQObject struct {
+	Name string
+}
+
+type MQMessageHandle struct {
+	hMsg int64
+}
+
+type noConnection struct {
+	qMgr *MQQueueManager
+}
+
+func notSupported(verb string) error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_FUNCTION_NOT_SUPPORTED, verb: verb}
+}
+
+// There is no cmqstrc.h to turn the values into names
+func (e *MQReturn) Error() string {
+	return fmt.Sprintf("%s: MQCC = %d MQRC = %d", e.verb, e.MQCC, e.MQRC)
+}
+
+// MQItoString has no names to return without cmqstrc.h
+func MQItoString(class string, value int) string {
+	return ""
+}
+
+func Conn(goQMgrName string) (MQQueueManager, error) {
+	return MQQueueManager{}, notSupported("MQCONN")
+}
+
+func Connx(goQMgrName string, gocno *MQCNO) (MQQueueManager, error) {
+	return MQQueueManager{}, notSupported("MQCONNX")
+}
+
+// NewQMgrConnection returns a connection whose verbs all fail
+func NewQMgrConnection(qMgr *MQQueueManager) QMgrConnection {
+	return &noConnection{qMgr: qMgr}
+}
+
+func (c *noConnection) Disc() error {
+	return notSupported("MQDISC")
+}
+
+func (c *noConnection) Open(good *MQOD, goOpenOptions int32) (Object, error) {
+	return nil, notSupported("MQOPEN")
+}
+
+func (c *noConnection) Sub(gosd *MQSD, qObject Object) (Object, Object, error) {
+	return nil, nil, notSupported("MQSUB")
+}
+
+func (c *noConnection) Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	return notSupported("MQPUT1")
+}
+
+func (c *noConnection) Cmit() error {
+	return notSupported("MQCMIT")
+}
+
+func (c *noConnection) Back() error {
+	return notSupported("MQBACK")
+}
+
+func (handle *MQMessageHandle) DltMH(godmho *MQDMHO) error {
+	return notSupported("MQDLTMH")
+}
+
+func (handle *MQMessageHandle) SetMP(gosmpo *MQSMPO, name string, gopd *MQPD, value interface{}) error {
+	return notSupported("MQSETMP")
+}
+
+func (handle *MQMessageHandle) DltMP(godmpo *MQDMPO, name string) error {
+	return notSupported("MQDLTMP")
+}
+
+func (handle *MQMessageHandle) InqMP(goimpo *MQIMPO, gopd *MQPD, name string) (string, interface{}, error) {
+	return "", nil, notSupported("MQINQMP")
+}
@@ -0,0 +1,147 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file wraps the MQQueueManager and MQObject structures so that they can be used
+through the QMgrConnection and Object interfaces from mqiface.go.
+*/
+
+import "time"
+
+// These types adapt the real MQI structures to the interfaces
+type mqiConnection struct {
+	qMgr *MQQueueManager
+}
+
+type mqiObject struct {
+	object MQObject
+}
+
+/*
+NewQMgrConnection wraps an existing connection so it can be used through
+the QMgrConnection interface
+*/
+func NewQMgrConnection(qMgr *MQQueueManager) QMgrConnection {
+	return &mqiConnection{qMgr: qMgr}
+}
+
+/*
+NewObject wraps an existing object handle so it can be used through
+the Object interface
+*/
+func NewObject(object MQObject) Object {
+	return &mqiObject{object: object}
+}
+
+func (c *mqiConnection) Disc() error {
+	return c.qMgr.Disc()
+}
+
+func (c *mqiConnection) Open(good *MQOD, goOpenOptions int32) (Object, error) {
+	object, err := c.qMgr.Open(good, goOpenOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &mqiObject{object: object}, nil
+}
+
+// The queue object for a managed subscription is filled in by MQSUB, so we
+// need a real MQObject to pass through, copied back out afterwards.
+func (c *mqiConnection) Sub(gosd *MQSD, qObject Object) (Object, Object, error) {
+	var q MQObject
+
+	if o, ok := qObject.(*mqiObject); ok && o != nil {
+		q = o.object
+	}
+	subObject, err := c.qMgr.Sub(gosd, &q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mqiObject{object: subObject}, &mqiObject{object: q}, nil
+}
+
+func (c *mqiConnection) Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	return c.qMgr.Put1(good, gomd, gopmo, buffer)
+}
+
+func (c *mqiConnection) Cmit() error {
+	return c.qMgr.Cmit()
+}
+
+func (c *mqiConnection) Back() error {
+	return c.qMgr.Back()
+}
+
+func (c *mqiConnection) CrtMH(gocmho *MQCMHO) (MQMessageHandle, error) {
+	return c.qMgr.CrtMH(gocmho)
+}
+
+func (o *mqiObject) Close(goCloseOptions int32) error {
+	return o.object.Close(goCloseOptions)
+}
+
+func (o *mqiObject) Put(gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	return o.object.Put(gomd, gopmo, buffer)
+}
+
+func (o *mqiObject) Get(gomd *MQMD, gogmo *MQGMO, buffer []byte) (int, error) {
+	return o.object.Get(gomd, gogmo, buffer)
+}
+
+func (o *mqiObject) GetSlice(gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, int, error) {
+	return o.object.GetSlice(gomd, gogmo, buffer)
+}
+
+func (o *mqiObject) GetBatch(n int, maxWait time.Duration, gogmo *MQGMO, buffer []byte) ([]BatchMessage, error) {
+	return o.object.GetBatch(n, maxWait, gogmo, buffer)
+}
+
+func (o *mqiObject) Inq(goSelectors []int32) (map[int32]interface{}, error) {
+	return o.object.Inq(goSelectors)
+}
+
+func (o *mqiObject) Set(goSelectors map[int32]interface{}) error {
+	return o.object.Set(goSelectors)
+}
+
+func (o *mqiObject) ObjectName() string {
+	return o.object.Name
+}
+
+// Returns the MQObject behind an Object, for use as the Context in an MQPMO.
+// Other implementations of the interface do not have one.
+func mqObjectOf(o Object) *MQObject {
+	switch v := o.(type) {
+	case *mqiObject:
+		return &v.object
+	case *serialObject:
+		return mqObjectOf(v.object)
+	}
+	return nil
+}
+
+// Closing the object makes the handle unusable
+func (o *mqiObject) usable() bool {
+	return IsUsableHObj(o.object)
+}
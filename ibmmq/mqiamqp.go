@@ -0,0 +1,330 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file maps between the MQMD and message properties of an MQ message and the
+sections of an AMQP 1.0 message, for Go programs that pass messages between AMQP
+clients and MQI applications. It does not depend on any AMQP library: the AMQPMessage
+structure holds the fields that such a library would need to fill in or read.
+
+Where an AMQP field has an MQMD equivalent, it is mapped as the MQ AMQP channel does:
+
+  durable          MQMD.Persistence
+  priority         MQMD.Priority
+  ttl              MQMD.Expiry, which is in tenths of a second
+  delivery-count   MQMD.BackoutCount
+  message-id       MQMD.MsgId, when it is binary of up to 24 bytes
+  correlation-id   MQMD.CorrelId, when it is binary of up to 24 bytes
+  user-id          MQMD.UserIdentifier
+  reply-to         MQMD.ReplyToQ
+  creation-time    MQMD.PutDateTime
+  group-id         MQMD.GroupId, when it is up to 24 bytes
+  group-sequence   MQMD.MsgSeqNumber
+  content-type     MQMD.Format is MQSTR for text types
+
+Application properties are MQ message properties with the same names. The AMQP fields
+that have no MQMD equivalent, or whose values do not fit, are kept in message properties
+with the "amqp." prefix so that a message can go from AMQP to MQ and back without losing
+them. The message annotations are kept as one JSON property, as their names are not
+valid MQ property names; their values come back as JSON types.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The message properties used for AMQP fields that do not fit in the MQMD
+const (
+	AMQP_PROP_PREFIX              = "amqp."
+	AMQP_PROP_MESSAGE_ID          = "amqp.messageId"
+	AMQP_PROP_CORRELATION_ID      = "amqp.correlationId"
+	AMQP_PROP_TO                  = "amqp.to"
+	AMQP_PROP_SUBJECT             = "amqp.subject"
+	AMQP_PROP_CONTENT_TYPE        = "amqp.contentType"
+	AMQP_PROP_CONTENT_ENCODING    = "amqp.contentEncoding"
+	AMQP_PROP_ABSOLUTE_EXPIRY     = "amqp.absoluteExpiryTime" // Milliseconds since the Unix epoch
+	AMQP_PROP_GROUP_ID            = "amqp.groupId"
+	AMQP_PROP_REPLY_TO_GROUP_ID   = "amqp.replyToGroupId"
+	AMQP_PROP_MESSAGE_ANNOTATIONS = "amqp.messageAnnotations"
+)
+
+// The AMQP default priority, used when the MQ priority is taken from the queue
+const amqpDefaultPriority = 4
+
+/*
+AMQPHeader is the AMQP header section
+*/
+type AMQPHeader struct {
+	Durable       bool
+	Priority      uint8
+	TTL           time.Duration // 0 means the message does not expire
+	DeliveryCount uint32
+}
+
+/*
+AMQPProperties is the AMQP properties section. The MessageID and CorrelationID can be
+a []byte or a string; other AMQP types should be converted to a string first.
+*/
+type AMQPProperties struct {
+	MessageID          interface{}
+	UserID             []byte
+	To                 string
+	Subject            string
+	ReplyTo            string
+	CorrelationID      interface{}
+	ContentType        string
+	ContentEncoding    string
+	AbsoluteExpiryTime time.Time
+	CreationTime       time.Time
+	GroupID            string
+	GroupSequence      uint32
+	ReplyToGroupID     string
+}
+
+/*
+AMQPMessage holds the sections of an AMQP message that have MQ equivalents, and a
+body made of a single data section
+*/
+type AMQPMessage struct {
+	Header                AMQPHeader
+	Properties            AMQPProperties
+	ApplicationProperties map[string]interface{}
+	MessageAnnotations    map[string]interface{}
+	Data                  []byte
+}
+
+/*
+AMQPFromMQ builds an AMQP message from an MQ message and its properties, as returned
+by GetProperties. The data is not copied.
+*/
+func AMQPFromMQ(md *MQMD, props map[string]interface{}, data []byte) *AMQPMessage {
+	m := &AMQPMessage{Data: data, ApplicationProperties: make(map[string]interface{})}
+
+	m.Header.Durable = md.Persistence == MQPER_PERSISTENT
+	m.Header.Priority = amqpDefaultPriority
+	if md.Priority >= 0 {
+		m.Header.Priority = uint8(md.Priority)
+	}
+	if md.Expiry > 0 {
+		m.Header.TTL = time.Duration(md.Expiry) * 100 * time.Millisecond
+	}
+	if md.BackoutCount > 0 {
+		m.Header.DeliveryCount = uint32(md.BackoutCount)
+	}
+
+	p := &m.Properties
+	p.MessageID = amqpID(md.MsgId, props[AMQP_PROP_MESSAGE_ID])
+	p.CorrelationID = amqpID(md.CorrelId, props[AMQP_PROP_CORRELATION_ID])
+	if u := strings.TrimSpace(md.UserIdentifier); u != "" {
+		p.UserID = []byte(u)
+	}
+	p.ReplyTo = strings.TrimSpace(md.ReplyToQ)
+	p.CreationTime = md.PutDateTime
+	if v, ok := props[AMQP_PROP_GROUP_ID].(string); ok {
+		p.GroupID = v
+	} else if len(bytes.Trim(md.GroupId, "\x00")) > 0 {
+		p.GroupID = string(bytes.TrimRight(md.GroupId, "\x00"))
+	}
+	if md.MsgSeqNumber > 0 {
+		p.GroupSequence = uint32(md.MsgSeqNumber)
+	}
+	if v, ok := props[AMQP_PROP_CONTENT_TYPE].(string); ok {
+		p.ContentType = v
+	} else if strings.TrimSpace(md.Format) == strings.TrimSpace(MQFMT_STRING) {
+		p.ContentType = "text/plain"
+	}
+	p.To, _ = props[AMQP_PROP_TO].(string)
+	p.Subject, _ = props[AMQP_PROP_SUBJECT].(string)
+	p.ContentEncoding, _ = props[AMQP_PROP_CONTENT_ENCODING].(string)
+	p.ReplyToGroupID, _ = props[AMQP_PROP_REPLY_TO_GROUP_ID].(string)
+	if v, ok := props[AMQP_PROP_ABSOLUTE_EXPIRY].(int64); ok {
+		p.AbsoluteExpiryTime = time.Unix(0, v*int64(time.Millisecond))
+	}
+
+	if v, ok := props[AMQP_PROP_MESSAGE_ANNOTATIONS].(string); ok {
+		json.Unmarshal([]byte(v), &m.MessageAnnotations)
+	}
+
+	for name, value := range props {
+		if !strings.HasPrefix(name, AMQP_PROP_PREFIX) {
+			m.ApplicationProperties[name] = value
+		}
+	}
+	return m
+}
+
+// The MQMD field is used unless the original AMQP value was kept in a property
+func amqpID(mqid []byte, prop interface{}) interface{} {
+	if prop != nil {
+		return prop
+	}
+	if len(bytes.Trim(mqid, "\x00")) == 0 {
+		return nil
+	}
+	return append([]byte(nil), mqid...)
+}
+
+/*
+MQFromAMQP builds the MQMD and message properties for an AMQP message. The properties
+can be set on a message handle with SetProperties before the message is put.
+*/
+func MQFromAMQP(m *AMQPMessage) (*MQMD, map[string]interface{}, []byte) {
+	md := NewMQMD()
+	props := make(map[string]interface{})
+
+	for name, value := range m.ApplicationProperties {
+		props[name] = amqpPropertyValue(value)
+	}
+
+	if m.Header.Durable {
+		md.Persistence = MQPER_PERSISTENT
+	} else {
+		md.Persistence = MQPER_NOT_PERSISTENT
+	}
+	md.Priority = int32(m.Header.Priority)
+	if md.Priority > 9 {
+		md.Priority = 9
+	}
+	if m.Header.TTL > 0 {
+		md.Expiry = int32((m.Header.TTL + 99*time.Millisecond) / (100 * time.Millisecond))
+	}
+	md.BackoutCount = int32(m.Header.DeliveryCount)
+
+	p := &m.Properties
+	if !mqIDFromAMQP(p.MessageID, &md.MsgId) {
+		props[AMQP_PROP_MESSAGE_ID] = amqpPropertyValue(p.MessageID)
+	}
+	if !mqIDFromAMQP(p.CorrelationID, &md.CorrelId) {
+		props[AMQP_PROP_CORRELATION_ID] = amqpPropertyValue(p.CorrelationID)
+	}
+	if len(p.UserID) > 0 && len(p.UserID) <= int(MQ_USER_ID_LENGTH) {
+		md.UserIdentifier = string(p.UserID)
+	}
+	md.ReplyToQ = p.ReplyTo
+	md.PutDateTime = p.CreationTime
+	if p.GroupID != "" {
+		if len(p.GroupID) <= int(MQ_GROUP_ID_LENGTH) {
+			md.GroupId = []byte(p.GroupID)
+		} else {
+			props[AMQP_PROP_GROUP_ID] = p.GroupID
+		}
+	}
+	if p.GroupSequence > 0 {
+		md.MsgSeqNumber = int32(p.GroupSequence)
+	}
+	if strings.HasPrefix(p.ContentType, "text/") {
+		md.Format = MQFMT_STRING
+	}
+
+	for name, value := range map[string]string{
+		AMQP_PROP_CONTENT_TYPE:      p.ContentType,
+		AMQP_PROP_TO:                p.To,
+		AMQP_PROP_SUBJECT:           p.Subject,
+		AMQP_PROP_CONTENT_ENCODING:  p.ContentEncoding,
+		AMQP_PROP_REPLY_TO_GROUP_ID: p.ReplyToGroupID,
+	} {
+		if value != "" {
+			props[name] = value
+		}
+	}
+	if !p.AbsoluteExpiryTime.IsZero() {
+		props[AMQP_PROP_ABSOLUTE_EXPIRY] = p.AbsoluteExpiryTime.UnixNano() / int64(time.Millisecond)
+	}
+	if len(m.MessageAnnotations) > 0 {
+		if b, err := json.Marshal(m.MessageAnnotations); err == nil {
+			props[AMQP_PROP_MESSAGE_ANNOTATIONS] = string(b)
+		}
+	}
+
+	return md, props, m.Data
+}
+
+// Binary ids of up to 24 bytes go in the MQMD. Returns false if the id has to be
+// kept in a property instead.
+func mqIDFromAMQP(id interface{}, field *[]byte) bool {
+	switch v := id.(type) {
+	case nil:
+		return true
+	case []byte:
+		if len(v) <= 24 {
+			*field = append([]byte(nil), v...)
+			return true
+		}
+	}
+	return false
+}
+
+// Message properties can only hold the basic types
+func amqpPropertyValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, []byte, string, int8, int16, int32, int64, float32, float64:
+		return v
+	case int:
+		return int64(v.(int))
+	case uint8:
+		return int16(v.(uint8))
+	case uint16:
+		return int32(v.(uint16))
+	case uint32:
+		return int64(v.(uint32))
+	}
+	return fmt.Sprint(v)
+}
+
+/*
+GetProperties returns all the properties of a message that was retrieved with
+MQGMO_PROPERTIES_IN_HANDLE using this message handle
+*/
+func (handle *MQMessageHandle) GetProperties() (map[string]interface{}, error) {
+	props := make(map[string]interface{})
+	impo := NewMQIMPO()
+	impo.Options = MQIMPO_CONVERT_VALUE | MQIMPO_INQ_FIRST
+	for {
+		name, value, err := handle.InqMP(impo, NewMQPD(), "%")
+		if err != nil {
+			if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_PROPERTY_NOT_AVAILABLE {
+				return props, nil
+			}
+			return props, err
+		}
+		props[name] = value
+		impo.Options = MQIMPO_CONVERT_VALUE | MQIMPO_INQ_NEXT
+	}
+}
+
+/*
+SetProperties sets all the properties in the map on the message handle
+*/
+func (handle *MQMessageHandle) SetProperties(props map[string]interface{}) error {
+	for name, value := range props {
+		if err := handle.SetMP(NewMQSMPO(), name, NewMQPD(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
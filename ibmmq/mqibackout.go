@@ -0,0 +1,183 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has a helper for the usual way of dealing with "poison" messages - messages
+that cannot be processed, and which would otherwise be returned to the queue every
+time the application backs out its unit of work.
+
+The queue's BOTHRESH and BOQNAME attributes say how many attempts are allowed and where
+the message should be moved to after that. They are inquired once when the handler is
+created. A consumer that gets messages under syncpoint then calls Check for each
+message before processing it. If the message has been backed out too many times,
+it is put to the backout queue within the same unit of work, and the caller only needs
+to commit. The amqsbo.go sample shows the same pattern written out in full.
+*/
+
+import (
+	"sync"
+)
+
+/*
+BackoutStats contains the counts of messages seen by a BackoutHandler
+*/
+type BackoutStats struct {
+	Checked  int64 // Messages passed to Check
+	Requeued int64 // Messages moved to the backout queue
+	Failed   int64 // Messages that should have been moved, but could not be
+}
+
+/*
+BackoutHandler checks messages against the backout configuration of a queue.
+If UseDLH is set, which is the default, a Dead Letter Header is added to the
+front of each message that is moved to the backout queue.
+*/
+type BackoutHandler struct {
+	QName         string
+	Threshold     int32
+	BackoutQName  string
+	BackoutReason int32 // Used in the DLH
+	UseDLH        bool
+
+	conn     QMgrConnection
+	qMgrName string
+	stats    BackoutStats
+	mutex    sync.Mutex
+}
+
+/*
+NewBackoutHandler inquires the backout attributes of the queue and returns a
+handler that uses them. The queue is opened separately for the inquiry, so the
+application's own object handle does not need to have MQOO_INQUIRE.
+
+It is not an error if the queue does not have a backout threshold or backout queue
+configured, but the handler will then never move any messages.
+*/
+func NewBackoutHandler(conn QMgrConnection, qName string) (*BackoutHandler, error) {
+	b := &BackoutHandler{
+		QName:         qName,
+		BackoutReason: MQRC_BACKED_OUT,
+		UseDLH:        true,
+		conn:          conn,
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	qObject, err := conn.Open(mqod, MQOO_INQUIRE|MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return nil, err
+	}
+	defer qObject.Close(0)
+	b.qMgrName = mqod.ResolvedQMgrName
+
+	values, err := qObject.Inq([]int32{MQIA_BACKOUT_THRESHOLD, MQCA_BACKOUT_REQ_Q_NAME})
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := values[MQIA_BACKOUT_THRESHOLD].(int32); ok {
+		b.Threshold = v
+	}
+	if v, ok := values[MQCA_BACKOUT_REQ_Q_NAME].(string); ok {
+		b.BackoutQName = v
+	}
+
+	return b, nil
+}
+
+/*
+IsPoison returns true if the message has reached the backout threshold. It always
+returns false if there is no backout queue configured, as there is nowhere to move the
+message to.
+*/
+func (b *BackoutHandler) IsPoison(md *MQMD) bool {
+	if b.Threshold <= 0 || b.BackoutQName == "" {
+		return false
+	}
+	return md.BackoutCount >= b.Threshold
+}
+
+/*
+Check looks at a message that has been retrieved under syncpoint. If it has been
+backed out too many times, it is put to the backout queue, with the original MQMD and
+optionally a DLH, and the return value is true. The caller should then commit
+the unit of work instead of processing the message.
+
+The MQMD passed in is not modified.
+*/
+func (b *BackoutHandler) Check(md *MQMD, buffer []byte) (bool, error) {
+	b.mutex.Lock()
+	b.stats.Checked++
+	b.mutex.Unlock()
+
+	if !b.IsPoison(md) {
+		return false, nil
+	}
+
+	err := b.requeue(md, buffer)
+
+	b.mutex.Lock()
+	if err != nil {
+		b.stats.Failed++
+	} else {
+		b.stats.Requeued++
+	}
+	b.mutex.Unlock()
+
+	return err == nil, err
+}
+
+/*
+Stats returns a copy of the counts so far
+*/
+func (b *BackoutHandler) Stats() BackoutStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.stats
+}
+
+// Put the message to the backout queue. A copy of the MQMD is used because
+// creating the DLH changes some of its fields.
+func (b *BackoutHandler) requeue(md *MQMD, buffer []byte) error {
+	lmd := *md
+	msg := buffer
+
+	if b.UseDLH {
+		dlh := NewMQDLH(&lmd)
+		dlh.Reason = b.BackoutReason
+		dlh.DestQName = b.QName
+		dlh.DestQMgrName = b.qMgrName
+		msg = append(dlh.Bytes(), buffer...)
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = b.BackoutQName
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_FAIL_IF_QUIESCING
+
+	return b.conn.Put1(mqod, &lmd, pmo, msg)
+}
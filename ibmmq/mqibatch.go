@@ -0,0 +1,186 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+
+#include <stdlib.h>
+#include <string.h>
+#include <cmqc.h>
+
+// Do up to n MQGETs without returning to Go in between. Only the first MQGET
+// waits; after that, we take whatever is already available. Each message is
+// put into the buffer straight after the previous one. The loop stops at the
+// first call that does not give MQCC_OK, and the reason is returned. A message
+// that gave a warning has still been removed from the queue, so it is counted,
+// except for MQRC_TRUNCATED_MSG_FAILED where the message stays on the queue.
+static void batchGet(MQHCONN hConn, MQHOBJ hObj, PMQMD mds, PMQGMO gmo, MQLONG n,
+                     PMQBYTE buffer, MQLONG bufflen, PMQLONG lens, PMQLONG count,
+                     PMQLONG pCompCode, PMQLONG pReason) {
+  MQLONG i;
+  MQLONG offset = 0;
+  MQLONG datalen;
+
+  *count = 0;
+  *pCompCode = MQCC_OK;
+  *pReason = MQRC_NONE;
+
+  for (i = 0; i < n; i++) {
+    datalen = 0;
+    MQGET(hConn, hObj, &mds[i], gmo, bufflen - offset,
+          (bufflen - offset > 0) ? buffer + offset : NULL,
+          &datalen, pCompCode, pReason);
+
+    if (*pCompCode == MQCC_FAILED || *pReason == MQRC_TRUNCATED_MSG_FAILED) {
+      break;
+    }
+
+    if (datalen > bufflen - offset) {
+      datalen = bufflen - offset;
+    }
+    lens[i] = datalen;
+    offset += datalen;
+    (*count)++;
+
+    if (*pCompCode != MQCC_OK) {
+      break;
+    }
+    gmo->Options &= ~MQGMO_WAIT;
+    gmo->WaitInterval = 0;
+  }
+  return;
+}
+
+*/
+import "C"
+
+import (
+	"time"
+	"unsafe"
+)
+
+/*
+GetBatch removes up to n messages from the queue in a single call from Go to C,
+which is cheaper than calling Get for each one when a consumer processes
+messages in groups.
+
+The first MQGET waits for up to maxWait for a message to arrive; the remaining
+ones only take messages that are already on the queue. A maxWait of 0 means
+there is no waiting at all.
+
+The gogmo can be nil, in which case messages are read outside syncpoint with
+data conversion. If MQGMO_SYNCPOINT is set, all the messages are part of the
+same unit of work and the application must then call Cmit or Back. Any wait
+options in the gogmo are replaced by maxWait. Each message is matched with a
+default MQMD, so MatchOptions other than MQMO_NONE are not useful here.
+
+All the messages are put into the buffer. If there is not enough space for the
+next message, the batch stops there and that message is left on the queue,
+unless it is the first message, when the error is returned as for Get. A nil
+buffer means a 4MB buffer is allocated.
+
+If the batch stops because of an error, the messages already retrieved are
+returned along with the error. MQRC_NO_MSG_AVAILABLE is only returned when no
+messages at all were found.
+*/
+func (object MQObject) GetBatch(n int, maxWait time.Duration, gogmo *MQGMO, buffer []byte) ([]BatchMessage, error) {
+	var mqrc C.MQLONG
+	var mqcc C.MQLONG
+	var mqgmo C.MQGMO
+	var count C.MQLONG
+	var ptr C.PMQBYTE
+
+	if n <= 0 {
+		return nil, nil
+	}
+
+	if gogmo == nil {
+		gogmo = NewMQGMO()
+		gogmo.Options = MQGMO_NO_SYNCPOINT | MQGMO_FAIL_IF_QUIESCING | MQGMO_CONVERT
+		gogmo.MatchOptions = MQMO_NONE
+	}
+	err := checkGMO(gogmo, "MQGET")
+	if err != nil {
+		return nil, err
+	}
+
+	gogmo.Options &^= MQGMO_WAIT | MQGMO_NO_WAIT
+	if maxWait > 0 {
+		gogmo.Options |= MQGMO_WAIT
+		gogmo.WaitInterval = int32(maxWait / time.Millisecond)
+	} else {
+		gogmo.Options |= MQGMO_NO_WAIT
+		gogmo.WaitInterval = 0
+	}
+
+	if buffer == nil {
+		buffer = make([]byte, 4*1024*1024)
+	}
+	bufflen := len(buffer)
+	if bufflen > 0 {
+		ptr = (C.PMQBYTE)(unsafe.Pointer(&buffer[0]))
+	}
+
+	// The MQMDs and lengths are allocated in C memory as they are
+	// an array that is filled in by the C loop
+	mds := (*[1 << 20]C.MQMD)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(C.MQMD{}))))[:n:n]
+	defer C.free(unsafe.Pointer(&mds[0]))
+	lens := (*[1 << 20]C.MQLONG)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(C.MQLONG(0)))))[:n:n]
+	defer C.free(unsafe.Pointer(&lens[0]))
+
+	for i := 0; i < n; i++ {
+		copyMDtoC(&mds[i], NewMQMD())
+	}
+	copyGMOtoC(&mqgmo, gogmo)
+
+	start := time.Now()
+	C.batchGet(object.qMgr.hConn, object.hObj, &mds[0], &mqgmo, C.MQLONG(n),
+		ptr, C.MQLONG(bufflen), &lens[0], &count, &mqcc, &mqrc)
+	copyGMOfromC(&mqgmo, gogmo)
+
+	msgs := make([]BatchMessage, int(count))
+	offset := 0
+	for i := 0; i < int(count); i++ {
+		l := int(lens[i])
+		gomd := NewMQMD()
+		copyMDfromC(&mds[i], gomd)
+		msgs[i] = BatchMessage{MD: gomd, Data: buffer[offset : offset+l]}
+		offset += l
+		object.qMgr.stats.recordGet(l, start, MQCC_OK, MQRC_NONE)
+	}
+
+	if mqcc == C.MQCC_OK {
+		return msgs, nil
+	}
+
+	// Running out of messages or buffer space after the first message is
+	// the normal end of a batch
+	if count > 0 && (mqrc == C.MQRC_NO_MSG_AVAILABLE || mqrc == C.MQRC_TRUNCATED_MSG_FAILED) {
+		return msgs, nil
+	}
+
+	if count == 0 {
+		object.qMgr.stats.recordGet(0, start, int32(mqcc), int32(mqrc))
+	}
+	return msgs, &MQReturn{MQCC: int32(mqcc),
+		MQRC: int32(mqrc),
+		verb: "MQGET",
+	}
+}
@@ -128,6 +128,10 @@ func MQCALLBACK_Go(hConn C.MQHCONN, mqmd *C.MQMD, mqgmo *C.MQGMO, mqBuffer C.PMQ
 	}
 
 	if ok {
+		if gocbc.CallType == MQCBCT_EVENT_CALL && mqreturn.MQRC == MQRC_RECONNECTED {
+			cbHObj.qMgr.stats.recordReconnect()
+		}
+
 		if gogmo.MsgHandle.hMsg != C.MQHM_NONE {
 			gogmo.MsgHandle.qMgr = cbHObj.qMgr
 		}
@@ -0,0 +1,275 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file takes a snapshot of the definitions of the objects on a queue manager, using
+PCF inquiry commands, so that it can be saved and later compared with another snapshot to
+find configuration drift.
+
+The snapshot holds every attribute that the command server returns, named by its MQI
+constant such as "MQCA_Q_DESC". Strings have their padding removed and integer values are
+kept as numbers. Values that change as the queue manager runs, such as the current depth
+of a queue, are left out, so two snapshots of an unchanged queue manager are identical.
+The structure converts directly to JSON, with the map keys in sorted order.
+
+The inquiries use PCFCommand, and so are not suitable for z/OS.
+*/
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ConfigAttributes holds the attributes of one object. The values are int64, string,
+[]int64 or []string.
+*/
+type ConfigAttributes map[string]interface{}
+
+/*
+ConfigSnapshot holds the definitions of the objects, keyed first by the object type
+("qmgr", "queue", "channel", "topic" or "authinfo") and then by the object name
+*/
+type ConfigSnapshot struct {
+	QMgrName string                                 `json:"queueManager"`
+	Time     time.Time                              `json:"time"`
+	Objects  map[string]map[string]ConfigAttributes `json:"objects"`
+}
+
+/*
+ConfigChange is one difference between two snapshots. For an object that has been
+added or removed, the Attribute is empty.
+*/
+type ConfigChange struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Attribute string      `json:"attribute,omitempty"`
+	Change    string      `json:"change"` // "added", "removed" or "changed"
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+}
+
+// How to inquire each type of object
+type configInquiry struct {
+	name      string
+	command   int32
+	nameParm  int32 // Zero for the queue manager
+	attrsParm int32
+}
+
+var configInquiries = map[int32]configInquiry{
+	MQOT_Q_MGR:     {"qmgr", MQCMD_INQUIRE_Q_MGR, MQCA_Q_MGR_NAME, MQIACF_Q_MGR_ATTRS},
+	MQOT_Q:         {"queue", MQCMD_INQUIRE_Q, MQCA_Q_NAME, MQIACF_Q_ATTRS},
+	MQOT_CHANNEL:   {"channel", MQCMD_INQUIRE_CHANNEL, MQCACH_CHANNEL_NAME, MQIACF_CHANNEL_ATTRS},
+	MQOT_TOPIC:     {"topic", MQCMD_INQUIRE_TOPIC, MQCA_TOPIC_NAME, MQIACF_TOPIC_ATTRS},
+	MQOT_AUTH_INFO: {"authinfo", MQCMD_INQUIRE_AUTH_INFO, MQCA_AUTH_INFO_NAME, MQIACF_AUTH_INFO_ATTRS},
+}
+
+// ConfigObjectTypes are the object types that can be given to ConfigSnapshot
+var ConfigObjectTypes = []int32{MQOT_Q_MGR, MQOT_Q, MQOT_CHANNEL, MQOT_TOPIC, MQOT_AUTH_INFO}
+
+// Attributes that are returned by the inquiries but are not part of the definition
+var configVolatileAttrs = map[int32]bool{
+	MQIA_CURRENT_Q_DEPTH:   true,
+	MQIA_OPEN_INPUT_COUNT:  true,
+	MQIA_OPEN_OUTPUT_COUNT: true,
+}
+
+/*
+ConfigSnapshot inquires the definitions of the given types of object. The pattern is
+a name such as "APP.*", and applies to every type except the queue manager. An empty
+pattern means all objects.
+*/
+func (x *MQQueueManager) ConfigSnapshot(objectTypes []int32, pattern string) (*ConfigSnapshot, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	snap := &ConfigSnapshot{QMgrName: strings.TrimSpace(x.Name),
+		Time:    time.Now().UTC(),
+		Objects: make(map[string]map[string]ConfigAttributes)}
+
+	for _, ot := range objectTypes {
+		inq, ok := configInquiries[ot]
+		if !ok {
+			return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_OBJECT_TYPE_ERROR, verb: "ConfigSnapshot"}
+		}
+
+		var params []*PCFParameter
+		if ot != MQOT_Q_MGR {
+			params = append(params, &PCFParameter{Type: MQCFT_STRING, Parameter: inq.nameParm, String: []string{pattern}})
+		}
+		params = append(params, &PCFParameter{Type: MQCFT_INTEGER_LIST, Parameter: inq.attrsParm, Int64Value: []int64{int64(MQIACF_ALL)}})
+
+		responses, err := x.pcfCommand("ConfigSnapshot", inq.command, params)
+		if err != nil {
+			// A pattern that matches nothing is not an error here
+			if mqreturn, ok := err.(*MQReturn); !ok || mqreturn.MQRC != MQRC_UNKNOWN_OBJECT_NAME {
+				return nil, err
+			}
+		}
+
+		objects := make(map[string]ConfigAttributes)
+		for _, r := range responses {
+			cfh, params := ReadPCFMessage(r)
+			if cfh == nil || cfh.CompCode != MQCC_OK || len(params) == 0 {
+				continue
+			}
+			name, attrs := configAttributes(inq.nameParm, params)
+			if ot == MQOT_Q_MGR && snap.QMgrName == "" {
+				snap.QMgrName = name
+			}
+			objects[name] = attrs
+		}
+		snap.Objects[inq.name] = objects
+	}
+
+	return snap, nil
+}
+
+// Convert the parameters from an inquiry response into the normalised attributes
+func configAttributes(nameParm int32, params []*PCFParameter) (string, ConfigAttributes) {
+	name := ""
+	attrs := make(ConfigAttributes)
+
+	for _, p := range params {
+		if configVolatileAttrs[p.Parameter] {
+			continue
+		}
+
+		var attrName string
+		var value interface{}
+
+		switch p.Type {
+		case MQCFT_STRING:
+			attrName = MQItoString("CA", int(p.Parameter))
+			if len(p.String) > 0 {
+				value = strings.TrimSpace(p.String[0])
+			}
+			if p.Parameter == nameParm {
+				name, _ = value.(string)
+			}
+		case MQCFT_STRING_LIST:
+			attrName = MQItoString("CA", int(p.Parameter))
+			l := make([]string, len(p.String))
+			for i, s := range p.String {
+				l[i] = strings.TrimSpace(s)
+			}
+			value = l
+		case MQCFT_BYTE_STRING:
+			attrName = MQItoString("BACF", int(p.Parameter))
+			if len(p.String) > 0 {
+				value = hex.EncodeToString([]byte(p.String[0]))
+			}
+		case MQCFT_INTEGER, MQCFT_INTEGER64:
+			attrName = MQItoString("IA", int(p.Parameter))
+			if len(p.Int64Value) > 0 {
+				value = p.Int64Value[0]
+			}
+		case MQCFT_INTEGER_LIST, MQCFT_INTEGER64_LIST:
+			attrName = MQItoString("IA", int(p.Parameter))
+			value = append([]int64(nil), p.Int64Value...)
+		default:
+			continue
+		}
+
+		if attrName == "" {
+			attrName = fmt.Sprintf("%d", p.Parameter)
+		}
+		attrs[attrName] = value
+	}
+	return name, attrs
+}
+
+/*
+DiffConfigSnapshots lists the differences between two snapshots, sorted by object type,
+name and attribute. Values are compared by their printed form, so a snapshot that has
+been read back from JSON, where all numbers become float64, can be compared with a new one.
+*/
+func DiffConfigSnapshots(old *ConfigSnapshot, new *ConfigSnapshot) []ConfigChange {
+	var changes []ConfigChange
+
+	for _, t := range unionKeys(old.Objects, new.Objects) {
+		oldObjs := old.Objects[t]
+		newObjs := new.Objects[t]
+
+		for _, name := range unionKeys(oldObjs, newObjs) {
+			oldAttrs, inOld := oldObjs[name]
+			newAttrs, inNew := newObjs[name]
+			switch {
+			case !inOld:
+				changes = append(changes, ConfigChange{Type: t, Name: name, Change: "added"})
+			case !inNew:
+				changes = append(changes, ConfigChange{Type: t, Name: name, Change: "removed"})
+			default:
+				for _, a := range unionKeys(oldAttrs, newAttrs) {
+					ov, inOld := oldAttrs[a]
+					nv, inNew := newAttrs[a]
+					if inOld && inNew && fmt.Sprint(ov) == fmt.Sprint(nv) {
+						continue
+					}
+					c := ConfigChange{Type: t, Name: name, Attribute: a, Change: "changed", Old: ov, New: nv}
+					if !inOld {
+						c.Change = "added"
+					} else if !inNew {
+						c.Change = "removed"
+					}
+					changes = append(changes, c)
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// The sorted keys that are in either map. The maps must have string keys.
+func unionKeys(a interface{}, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []interface{}{a, b} {
+		switch m := m.(type) {
+		case map[string]map[string]ConfigAttributes:
+			for k := range m {
+				seen[k] = true
+			}
+		case map[string]ConfigAttributes:
+			for k := range m {
+				seen[k] = true
+			}
+		case ConfigAttributes:
+			for k := range m {
+				seen[k] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
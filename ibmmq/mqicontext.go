@@ -0,0 +1,209 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has helpers for applications, such as gateways, that act on behalf of other users.
+There are two separate features:
+
+  - Alternate user authority, where the queue manager checks whether the named user
+    is allowed to open the queue, instead of the application's own user
+  - Setting the context fields in the MQMD, so that the message appears to have come
+    from another user or application
+
+Both need the application itself to have the relevant authorities. The functions
+here set the matching open and put options together, which is easy to get wrong when
+done by hand. The context values are checked against the MQMD field lengths instead of
+being silently truncated.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// Values for the contextLevel parameter of OpenWithContext
+const (
+	CONTEXT_DEFAULT  = 0 // The queue manager sets all the context fields
+	CONTEXT_IDENTITY = 1 // The application sets the identity context
+	CONTEXT_ALL      = 2 // The application sets the identity and origin context
+)
+
+/*
+IdentityContext contains the MQMD fields that identify the user of the message
+*/
+type IdentityContext struct {
+	UserIdentifier   string
+	AccountingToken  []byte
+	ApplIdentityData string
+}
+
+/*
+OriginContext contains the MQMD fields that describe the application that put the message
+*/
+type OriginContext struct {
+	PutApplType    int32
+	PutApplName    string
+	PutDateTime    time.Time // The current time is used if this is not set
+	ApplOriginData string
+}
+
+/*
+OpenWithContext opens a queue for output so that messages can be put with the given
+level of context. If altUser is not empty, the queue is opened with alternate user
+authority on behalf of that user.
+*/
+func (x *MQQueueManager) OpenWithContext(qName string, qMgrName string, contextLevel int, altUser string) (MQObject, error) {
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	mqod.ObjectQMgrName = qMgrName
+
+	openOptions := MQOO_OUTPUT | MQOO_FAIL_IF_QUIESCING
+	switch contextLevel {
+	case CONTEXT_IDENTITY:
+		openOptions |= MQOO_SET_IDENTITY_CONTEXT
+	case CONTEXT_ALL:
+		openOptions |= MQOO_SET_ALL_CONTEXT
+	}
+
+	if altUser != "" {
+		if err := checkContextField("MQOPEN", "AlternateUserId", altUser, MQ_USER_ID_LENGTH); err != nil {
+			return MQObject{}, err
+		}
+		mqod.AlternateUserId = altUser
+		openOptions |= MQOO_ALTERNATE_USER_AUTHORITY
+	}
+
+	return x.Open(mqod, openOptions)
+}
+
+/*
+SetIdentityContext copies the identity fields into the MQMD. An error is returned
+if any of the values is too long for the MQMD.
+*/
+func (md *MQMD) SetIdentityContext(ic *IdentityContext) error {
+	if err := checkContextField("MQPUT", "UserIdentifier", ic.UserIdentifier, MQ_USER_ID_LENGTH); err != nil {
+		return err
+	}
+	if err := checkContextField("MQPUT", "ApplIdentityData", ic.ApplIdentityData, MQ_APPL_IDENTITY_DATA_LENGTH); err != nil {
+		return err
+	}
+	if len(ic.AccountingToken) > int(MQ_ACCOUNTING_TOKEN_LENGTH) {
+		return contextFieldError("MQPUT", "AccountingToken", MQ_ACCOUNTING_TOKEN_LENGTH)
+	}
+
+	md.UserIdentifier = ic.UserIdentifier
+	md.ApplIdentityData = ic.ApplIdentityData
+	md.AccountingToken = make([]byte, MQ_ACCOUNTING_TOKEN_LENGTH)
+	copy(md.AccountingToken, ic.AccountingToken)
+	return nil
+}
+
+/*
+SetOriginContext copies the origin fields into the MQMD. An error is returned
+if any of the values is too long for the MQMD.
+*/
+func (md *MQMD) SetOriginContext(oc *OriginContext) error {
+	if err := checkContextField("MQPUT", "PutApplName", oc.PutApplName, MQ_PUT_APPL_NAME_LENGTH); err != nil {
+		return err
+	}
+	if err := checkContextField("MQPUT", "ApplOriginData", oc.ApplOriginData, MQ_APPL_ORIGIN_DATA_LENGTH); err != nil {
+		return err
+	}
+
+	md.PutApplType = oc.PutApplType
+	md.PutApplName = oc.PutApplName
+	md.ApplOriginData = oc.ApplOriginData
+	if oc.PutDateTime.IsZero() {
+		md.PutDateTime = time.Now()
+	} else {
+		md.PutDateTime = oc.PutDateTime
+	}
+	return nil
+}
+
+/*
+GetIdentityContext returns the identity fields from the MQMD
+*/
+func (md *MQMD) GetIdentityContext() IdentityContext {
+	return IdentityContext{
+		UserIdentifier:   md.UserIdentifier,
+		AccountingToken:  md.AccountingToken,
+		ApplIdentityData: md.ApplIdentityData,
+	}
+}
+
+/*
+PutWithIdentity puts a message using the identity context given here. The object must
+have been opened with CONTEXT_IDENTITY or CONTEXT_ALL.
+*/
+func (object MQObject) PutWithIdentity(gomd *MQMD, gopmo *MQPMO, buffer []byte, ic *IdentityContext) error {
+	if err := gomd.SetIdentityContext(ic); err != nil {
+		return err
+	}
+
+	lpmo := *gopmo
+	lpmo.Options &^= MQPMO_PASS_IDENTITY_CONTEXT | MQPMO_PASS_ALL_CONTEXT | MQPMO_SET_ALL_CONTEXT | MQPMO_DEFAULT_CONTEXT | MQPMO_NO_CONTEXT
+	lpmo.Options |= MQPMO_SET_IDENTITY_CONTEXT
+	err := object.Put(gomd, &lpmo, buffer)
+	gopmo.ResolvedQName = lpmo.ResolvedQName
+	gopmo.ResolvedQMgrName = lpmo.ResolvedQMgrName
+	return err
+}
+
+/*
+PutWithContext puts a message using both the identity and origin context given here. The object
+must have been opened with CONTEXT_ALL.
+*/
+func (object MQObject) PutWithContext(gomd *MQMD, gopmo *MQPMO, buffer []byte, ic *IdentityContext, oc *OriginContext) error {
+	if err := gomd.SetIdentityContext(ic); err != nil {
+		return err
+	}
+	if err := gomd.SetOriginContext(oc); err != nil {
+		return err
+	}
+
+	lpmo := *gopmo
+	lpmo.Options &^= MQPMO_PASS_IDENTITY_CONTEXT | MQPMO_PASS_ALL_CONTEXT | MQPMO_SET_IDENTITY_CONTEXT | MQPMO_DEFAULT_CONTEXT | MQPMO_NO_CONTEXT
+	lpmo.Options |= MQPMO_SET_ALL_CONTEXT
+	err := object.Put(gomd, &lpmo, buffer)
+	gopmo.ResolvedQName = lpmo.ResolvedQName
+	gopmo.ResolvedQMgrName = lpmo.ResolvedQMgrName
+	return err
+}
+
+func checkContextField(verb string, field string, value string, maxLen int32) error {
+	if len(value) > int(maxLen) {
+		return contextFieldError(verb, field, maxLen)
+	}
+	return nil
+}
+
+func contextFieldError(verb string, field string, maxLen int32) error {
+	mqrc := MQRC_MD_ERROR
+	if field == "AlternateUserId" {
+		mqrc = MQRC_OD_ERROR
+	}
+	return fmt.Errorf("%s is longer than %d bytes: %w", field, maxLen, &MQReturn{MQCC: MQCC_FAILED, MQRC: mqrc, verb: verb})
+}
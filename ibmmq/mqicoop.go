@@ -0,0 +1,110 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file helps with the dispatcher pattern, where several instances of an application
+browse the same queue and decide which messages to process, before getting them.
+
+Each browse marks the message for all the handles that were opened with MQOO_CO_OP,
+so another instance does not see it. The instance that marked it then either gets it,
+using the MsgToken from the browse, or unmarks it so that someone else can have it.
+If neither happens, the queue manager removes the mark after the queue manager's
+MSGMARKBROWSEINT time.
+*/
+
+import (
+	"time"
+)
+
+// The open options needed by a cooperative browser
+const CoOpBrowseOpenOptions = MQOO_BROWSE | MQOO_INPUT_SHARED | MQOO_CO_OP | MQOO_FAIL_IF_QUIESCING
+
+/*
+CoOpBrowser browses a queue that has been opened with CoOpBrowseOpenOptions
+*/
+type CoOpBrowser struct {
+	object Object
+}
+
+/*
+NewCoOpBrowser uses an already-open queue for cooperative browsing
+*/
+func NewCoOpBrowser(object Object) *CoOpBrowser {
+	return &CoOpBrowser{object: object}
+}
+
+/*
+Browse returns the next message that has not been marked by any of the cooperating
+instances, and marks it. The returned token is used to Get or Unmark the message.
+A wait of 0 means that the call returns immediately if there is no suitable message.
+*/
+func (b *CoOpBrowser) Browse(gomd *MQMD, wait time.Duration, buffer []byte) ([]byte, []byte, error) {
+	gmo := NewMQGMO()
+	gmo.Options = MQGMO_BROWSE_FIRST | MQGMO_UNMARKED_BROWSE_MSG | MQGMO_MARK_BROWSE_CO_OP
+	gmo.Options |= MQGMO_FAIL_IF_QUIESCING | MQGMO_CONVERT
+	gmo.MatchOptions = MQMO_NONE
+	if wait > 0 {
+		gmo.Options |= MQGMO_WAIT
+		gmo.WaitInterval = int32(wait / time.Millisecond)
+	}
+
+	data, _, err := b.object.GetSlice(gomd, gmo, buffer)
+	if err != nil {
+		return data, nil, err
+	}
+	token := make([]byte, len(gmo.MsgToken))
+	copy(token, gmo.MsgToken)
+	return data, token, nil
+}
+
+/*
+Get removes a message that was returned by Browse. The gogmo can be nil, or can
+give extra options such as MQGMO_SYNCPOINT.
+*/
+func (b *CoOpBrowser) Get(token []byte, gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, error) {
+	if gogmo == nil {
+		gogmo = NewMQGMO()
+		gogmo.Options = MQGMO_NO_SYNCPOINT | MQGMO_FAIL_IF_QUIESCING | MQGMO_CONVERT
+	}
+	gogmo.MatchOptions = MQMO_MATCH_MSG_TOKEN
+	copy(gogmo.MsgToken, token)
+
+	data, _, err := b.object.GetSlice(gomd, gogmo, buffer)
+	return data, err
+}
+
+/*
+Unmark gives up a message that was returned by Browse, so it can be
+processed by another instance
+*/
+func (b *CoOpBrowser) Unmark(token []byte) error {
+	gmo := NewMQGMO()
+	gmo.Options = MQGMO_BROWSE_FIRST | MQGMO_UNMARK_BROWSE_CO_OP | MQGMO_FAIL_IF_QUIESCING
+	gmo.Options |= MQGMO_ACCEPT_TRUNCATED_MSG
+	gmo.MatchOptions = MQMO_MATCH_MSG_TOKEN
+	copy(gmo.MsgToken, token)
+
+	_, err := b.object.Get(NewMQMD(), gmo, nil)
+	if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_ACCEPTED {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,363 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+MQ has no way to put a message that only becomes available at a later time. This file
+provides the usual way round that: the message is put to a staging queue, with message
+properties saying when it is due and where it should go, and a DelayMover moves it to
+its destination once that time has passed.
+
+The mover browses the whole staging queue on each pass, so it suits a modest number of
+waiting messages. Each message is moved in a unit of work, with its original context, so
+it is always on exactly one of the two queues. It is checked again at the PollInterval,
+or sooner if a message is due before then; a message is delivered late by up to the
+PollInterval if it was put while the mover was waiting and is due before the next pass.
+
+The mover uses its connection from its own goroutine, so it should be given a connection
+that the application is not using for anything else.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// The properties on a message in the staging queue
+const (
+	DELAY_PROP_DELIVER_AT      = "mqgo.deliverAt" // Milliseconds since the Unix epoch
+	DELAY_PROP_DELIVER_TO      = "mqgo.deliverTo"
+	DELAY_PROP_DELIVER_TO_QMGR = "mqgo.deliverToQMgr"
+)
+
+/*
+DefaultDelayPollInterval is how often the staging queue is checked unless the
+PollInterval is changed
+*/
+const DefaultDelayPollInterval = 5 * time.Second
+
+/*
+DelayStats contains the counters for a DelayMover
+*/
+type DelayStats struct {
+	Passes    int64     // Times the staging queue has been checked
+	Moved     int64     // Messages delivered to their destination
+	Failed    int64     // Messages that could not be moved, and were left on the staging queue
+	Invalid   int64     // Messages seen without a destination, counted on each pass
+	Waiting   int       // Messages not yet due at the end of the last pass
+	NextDue   time.Time // When the next waiting message is due. Zero if there are none
+	LastError error
+}
+
+/*
+DelayMover moves messages from a staging queue when they are due
+*/
+type DelayMover struct {
+	QName        string
+	PollInterval time.Duration
+
+	conn   QMgrConnection
+	object Object
+	handle MQMessageHandle
+	buffer []byte
+
+	mutex sync.Mutex
+	stats DelayStats
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+/*
+PutDelayed puts a message to the staging queue, to be delivered to the destination queue
+at deliverAt. The destination queue manager can be empty. If the MQPMO has an
+OriginalMsgHandle, the delivery properties are added to it along with any properties the
+application has set; otherwise a handle is created for the put.
+*/
+func (x *MQQueueManager) PutDelayed(stagingQ string, destQ string, destQMgr string, deliverAt time.Time, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	var err error
+
+	handle := gopmo.OriginalMsgHandle
+	if int64(handle.hMsg) == int64(MQHM_NONE) {
+		handle, err = x.CrtMH(NewMQCMHO())
+		if err != nil {
+			return err
+		}
+		defer handle.DltMH(NewMQDMHO())
+	}
+
+	err = handle.SetMP(NewMQSMPO(), DELAY_PROP_DELIVER_AT, NewMQPD(), deliverAt.UnixNano()/int64(time.Millisecond))
+	if err == nil {
+		err = handle.SetMP(NewMQSMPO(), DELAY_PROP_DELIVER_TO, NewMQPD(), destQ)
+	}
+	if err == nil && destQMgr != "" {
+		err = handle.SetMP(NewMQSMPO(), DELAY_PROP_DELIVER_TO_QMGR, NewMQPD(), destQMgr)
+	}
+	if err != nil {
+		return err
+	}
+
+	saved := gopmo.OriginalMsgHandle
+	gopmo.OriginalMsgHandle = handle
+	defer func() { gopmo.OriginalMsgHandle = saved }()
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = stagingQ
+	return x.Put1(mqod, gomd, gopmo, buffer)
+}
+
+/*
+NewDelayMover opens the staging queue. Call Start to move the messages in the
+background, or MoveDue to do a single pass. The connection has to be able to
+create message handles.
+*/
+func NewDelayMover(conn QMgrConnection, stagingQ string) (*DelayMover, error) {
+	creator, ok := conn.(MessageHandleCreator)
+	if !ok {
+		return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_FUNCTION_NOT_SUPPORTED, verb: "DELAY"}
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = stagingQ
+	openOptions := MQOO_BROWSE | MQOO_INPUT_SHARED | MQOO_SAVE_ALL_CONTEXT | MQOO_FAIL_IF_QUIESCING
+	object, err := conn.Open(mqod, openOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := creator.CrtMH(NewMQCMHO())
+	if err != nil {
+		object.Close(0)
+		return nil, err
+	}
+
+	return &DelayMover{QName: stagingQ,
+		PollInterval: DefaultDelayPollInterval,
+		conn:         conn,
+		object:       object,
+		handle:       handle,
+		buffer:       make([]byte, 0, 1024*1024),
+	}, nil
+}
+
+/*
+Start moves the messages from a goroutine until Stop is called
+*/
+func (m *DelayMover) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+
+	go func(stop chan struct{}, done chan struct{}) {
+		defer close(done)
+		for {
+			wait := m.PollInterval
+			if next, err := m.MoveDue(time.Now()); err == nil && !next.IsZero() {
+				if d := time.Until(next); d < wait {
+					wait = d
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}(m.stop, m.done)
+}
+
+/*
+Stop ends the goroutine started by Start, waiting for any pass in progress to finish
+*/
+func (m *DelayMover) Stop() {
+	m.mutex.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+/*
+Close stops the mover and closes the staging queue
+*/
+func (m *DelayMover) Close() error {
+	m.Stop()
+	m.handle.DltMH(NewMQDMHO())
+	return m.object.Close(0)
+}
+
+/*
+Stats returns a copy of the counters
+*/
+func (m *DelayMover) Stats() DelayStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stats
+}
+
+/*
+MoveDue makes one pass over the staging queue, moving every message that is due at
+the given time. It returns when the next waiting message is due, or a zero time if there
+are none. Messages without a delivery time are due at once.
+*/
+func (m *DelayMover) MoveDue(now time.Time) (time.Time, error) {
+	var next time.Time
+	var moved, failed, invalid int64
+	waiting := 0
+
+	var err error
+	browse := MQGMO_BROWSE_FIRST
+	for {
+		md := NewMQMD()
+		gmo := NewMQGMO()
+		gmo.Options = browse | MQGMO_NO_WAIT | MQGMO_PROPERTIES_IN_HANDLE | MQGMO_FAIL_IF_QUIESCING
+		gmo.MsgHandle = m.handle
+
+		var data []byte
+		var datalen int
+		data, datalen, err = m.object.GetSlice(md, gmo, m.buffer[:cap(m.buffer)])
+		if err != nil {
+			mqreturn, _ := err.(*MQReturn)
+			if mqreturn != nil && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED {
+				m.buffer = make([]byte, 0, datalen)
+				browse = MQGMO_BROWSE_MSG_UNDER_CURSOR
+				continue
+			}
+			if mqreturn != nil && mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE {
+				err = nil
+			}
+			break
+		}
+		browse = MQGMO_BROWSE_NEXT
+
+		destQ, destQMgr, deliverAt := m.delivery()
+		if destQ == "" {
+			invalid++
+			continue
+		}
+		if deliverAt.After(now) {
+			waiting++
+			if next.IsZero() || deliverAt.Before(next) {
+				next = deliverAt
+			}
+			continue
+		}
+
+		if m.move(md, data, destQ, destQMgr) != nil {
+			failed++
+		} else {
+			moved++
+		}
+	}
+
+	m.mutex.Lock()
+	m.stats.Passes++
+	m.stats.Moved += moved
+	m.stats.Failed += failed
+	m.stats.Invalid += invalid
+	m.stats.Waiting = waiting
+	m.stats.NextDue = next
+	m.stats.LastError = err
+	m.mutex.Unlock()
+
+	return next, err
+}
+
+// Read the delivery properties of the message that has just been browsed
+func (m *DelayMover) delivery() (string, string, time.Time) {
+	var destQ, destQMgr string
+	var deliverAt time.Time
+
+	if _, v, err := m.handle.InqMP(NewMQIMPO(), NewMQPD(), DELAY_PROP_DELIVER_TO); err == nil {
+		destQ, _ = v.(string)
+	}
+	if _, v, err := m.handle.InqMP(NewMQIMPO(), NewMQPD(), DELAY_PROP_DELIVER_TO_QMGR); err == nil {
+		destQMgr, _ = v.(string)
+	}
+	if _, v, err := m.handle.InqMP(NewMQIMPO(), NewMQPD(), DELAY_PROP_DELIVER_AT); err == nil {
+		if ms, ok := delayMillis(v); ok {
+			deliverAt = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+	return destQ, destQMgr, deliverAt
+}
+
+// The property is put as an int64, but may have been set another way by a
+// different application
+func delayMillis(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// Move the message that has just been browsed, in a single unit of work. The
+// delivery properties are removed before it is put to its destination.
+func (m *DelayMover) move(md *MQMD, data []byte, destQ string, destQMgr string) error {
+	gmo := NewMQGMO()
+	gmo.Options = MQGMO_MSG_UNDER_CURSOR | MQGMO_SYNCPOINT | MQGMO_ACCEPT_TRUNCATED_MSG | MQGMO_FAIL_IF_QUIESCING
+	_, err := m.object.Get(NewMQMD(), gmo, nil)
+	if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_ACCEPTED {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{DELAY_PROP_DELIVER_AT, DELAY_PROP_DELIVER_TO, DELAY_PROP_DELIVER_TO_QMGR} {
+		m.handle.DltMP(NewMQDMPO(), p)
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = destQ
+	mqod.ObjectQMgrName = destQMgr
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_PASS_ALL_CONTEXT | MQPMO_FAIL_IF_QUIESCING
+	pmo.Context = mqObjectOf(m.object)
+	pmo.OriginalMsgHandle = m.handle
+
+	err = m.conn.Put1(mqod, md, pmo, data)
+	if err != nil {
+		m.conn.Back()
+		return err
+	}
+	return m.conn.Cmit()
+}
@@ -0,0 +1,396 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides a Dispatcher, which runs handler functions for the messages on
+several queues. It is the loop of MQGET calls, goroutines and error handling that most
+services write for themselves:
+
+  - Each queue has a number of listeners, which is the most messages from that queue
+    that can be handled at once. Each listener has its own connection.
+  - The handlers for all the queues share a pool of workers, which limits how many
+    messages are handled at once across the whole Dispatcher.
+  - Each message is got in a unit of work, which is committed if the handler returns
+    nil and backed out otherwise. A handler that panics is treated as failing, and the
+    other handlers carry on. Messages that keep failing are moved to the backout queue
+    by a BackoutHandler.
+  - Stop lets the handlers that are running finish and commit, and backs out any
+    message that is still waiting for a worker.
+  - Hooks are called around each handler, for metrics or tracing.
+
+A listener does not hold a worker while it waits for a message, so a quiet queue does
+not take workers away from a busy one. A message that has been read waits for a worker
+inside its unit of work, so it is never lost.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+DispatchMessage is a message given to a DispatchHandler. The Data is only valid until
+the handler returns.
+*/
+type DispatchMessage struct {
+	QName string
+	MD    *MQMD
+	Data  []byte
+}
+
+/*
+DispatchHandler processes one message. If it returns an error or panics, the message
+is backed out.
+*/
+type DispatchHandler func(msg *DispatchMessage) error
+
+/*
+DispatchHook is called before each handler. The function it returns, if it is not nil,
+is called after the handler with the handler's result.
+*/
+type DispatchHook func(msg *DispatchMessage) func(err error)
+
+/*
+DispatchQueue is a queue read by a Dispatcher
+*/
+type DispatchQueue struct {
+	QName        string
+	Handler      DispatchHandler
+	Concurrency  int           // The number of listeners, each with its own connection. Default 1
+	GetOptions   int32         // Added to the MQGET options, such as MQGMO_CONVERT
+	WaitInterval time.Duration // How long each MQGET waits, which limits how long Stop takes. Default 2 seconds
+}
+
+/*
+DispatchStats contains the counters for a queue
+*/
+type DispatchStats struct {
+	Received  int64 // Messages read from the queue
+	Succeeded int64 // Messages handled and committed
+	Failed    int64 // Messages backed out because the handler or the commit failed
+	Panics    int64 // The Failed messages where the handler panicked
+	Poisoned  int64 // Messages moved to the backout queue
+	Active    int   // Handlers running now
+	Listeners int   // Listeners that are connected now
+	LastError error
+}
+
+/*
+Dispatcher runs the handlers for a set of queues
+*/
+type Dispatcher struct {
+	Connect        func() (*MQQueueManager, error)
+	Workers        int // Handlers that can run at once. Default is the total Concurrency of the queues
+	ReconnectDelay time.Duration
+	Hooks          []DispatchHook
+
+	mutex  sync.Mutex
+	queues []*dispatchQueue
+	pool   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type dispatchQueue struct {
+	DispatchQueue
+	stats DispatchStats
+}
+
+type dispatchListener struct {
+	d       *Dispatcher
+	queue   *dispatchQueue
+	qMgr    *MQQueueManager
+	object  MQObject
+	tx      *Transaction
+	backout *BackoutHandler
+	buffer  []byte
+}
+
+const defaultDispatchWait = 2 * time.Second
+
+/*
+NewDispatcher returns a Dispatcher that uses the connect function to make a connection
+for each listener
+*/
+func NewDispatcher(connect func() (*MQQueueManager, error)) *Dispatcher {
+	return &Dispatcher{Connect: connect, ReconnectDelay: defaultReconnectDelay}
+}
+
+/*
+AddQueue adds a queue. Queues cannot be added once the Dispatcher has started.
+*/
+func (d *Dispatcher) AddQueue(q DispatchQueue) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stop != nil {
+		return fmt.Errorf("Dispatcher is already running")
+	}
+	if q.QName == "" || q.Handler == nil {
+		return fmt.Errorf("Dispatcher needs a queue name and a handler")
+	}
+	for _, dq := range d.queues {
+		if dq.QName == q.QName {
+			return fmt.Errorf("Queue %s is already defined", q.QName)
+		}
+	}
+	if q.Concurrency <= 0 {
+		q.Concurrency = 1
+	}
+	if q.WaitInterval <= 0 {
+		q.WaitInterval = defaultDispatchWait
+	}
+	d.queues = append(d.queues, &dispatchQueue{DispatchQueue: q})
+	return nil
+}
+
+/*
+Start runs the listeners for all the queues. Connection failures are not returned, but
+are retried and shown in the Stats.
+*/
+func (d *Dispatcher) Start() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stop != nil {
+		return
+	}
+
+	workers := d.Workers
+	if workers <= 0 {
+		for _, dq := range d.queues {
+			workers += dq.Concurrency
+		}
+	}
+	d.pool = make(chan struct{}, workers)
+	d.stop = make(chan struct{})
+
+	for _, dq := range d.queues {
+		for i := 0; i < dq.Concurrency; i++ {
+			l := &dispatchListener{d: d, queue: dq, buffer: make([]byte, 0, 1024*1024)}
+			d.wg.Add(1)
+			go l.run(d.stop)
+		}
+	}
+}
+
+/*
+Stop ends the listeners, waiting for the handlers that are running to finish. It can
+take up to the longest WaitInterval of the queues.
+*/
+func (d *Dispatcher) Stop() {
+	d.mutex.Lock()
+	stop := d.stop
+	d.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	d.wg.Wait()
+
+	d.mutex.Lock()
+	d.stop = nil
+	d.mutex.Unlock()
+}
+
+/*
+Stats returns a copy of the counters for each queue, keyed by the queue name
+*/
+func (d *Dispatcher) Stats() map[string]DispatchStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	rc := make(map[string]DispatchStats, len(d.queues))
+	for _, dq := range d.queues {
+		rc[dq.QName] = dq.stats
+	}
+	return rc
+}
+
+func (d *Dispatcher) update(dq *dispatchQueue, f func(s *DispatchStats)) {
+	d.mutex.Lock()
+	f(&dq.stats)
+	d.mutex.Unlock()
+}
+
+// Run the handler for a message once a worker is free. It returns false without
+// running the handler if the Dispatcher is stopped first.
+func (d *Dispatcher) dispatch(stop chan struct{}, dq *dispatchQueue, msg *DispatchMessage) (bool, error) {
+	select {
+	case d.pool <- struct{}{}:
+	case <-stop:
+		return false, nil
+	}
+	defer func() { <-d.pool }()
+
+	d.update(dq, func(s *DispatchStats) { s.Active++ })
+	defer d.update(dq, func(s *DispatchStats) { s.Active-- })
+
+	var done []func(error)
+	for _, hook := range d.Hooks {
+		if f := hook(msg); f != nil {
+			done = append(done, f)
+		}
+	}
+
+	err := d.call(dq, msg)
+
+	for i := len(done) - 1; i >= 0; i-- {
+		done[i](err)
+	}
+	return true, err
+}
+
+// A panic in the handler is returned as an error so that it only affects this message
+func (d *Dispatcher) call(dq *dispatchQueue, msg *DispatchMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Handler for queue %s panicked: %v", dq.QName, r)
+			d.update(dq, func(s *DispatchStats) { s.Panics++ })
+		}
+	}()
+	return dq.Handler(msg)
+}
+
+func (l *dispatchListener) run(stop chan struct{}) {
+	defer l.d.wg.Done()
+
+	for {
+		err := l.connect()
+		if err == nil {
+			l.d.update(l.queue, func(s *DispatchStats) { s.Listeners++ })
+			err = l.process(stop)
+			l.d.update(l.queue, func(s *DispatchStats) { s.Listeners-- })
+		}
+		l.disconnect()
+		if err == nil {
+			return
+		}
+		l.d.update(l.queue, func(s *DispatchStats) { s.LastError = err })
+		if !sleepUnlessStopped(stop, l.d.ReconnectDelay) {
+			return
+		}
+	}
+}
+
+func (l *dispatchListener) connect() error {
+	qMgr, err := l.d.Connect()
+	if err != nil {
+		return err
+	}
+	l.qMgr = qMgr
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = l.queue.QName
+	l.object, err = qMgr.Open(mqod, MQOO_INPUT_SHARED|MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return err
+	}
+	if l.backout, err = NewBackoutHandler(NewQMgrConnection(qMgr), l.queue.QName); err != nil {
+		return err
+	}
+	l.tx = qMgr.NewTransaction()
+	return nil
+}
+
+func (l *dispatchListener) disconnect() {
+	if l.qMgr == nil {
+		return
+	}
+	if l.tx != nil {
+		l.tx.Close()
+	}
+	if l.object.qMgr != nil {
+		l.object.Close(0)
+	}
+	l.qMgr.Disc()
+	l.qMgr = nil
+	l.object = MQObject{}
+	l.tx = nil
+	l.backout = nil
+}
+
+// Handle messages until the Dispatcher is stopped, which returns nil, or until there
+// is an error from MQ
+func (l *dispatchListener) process(stop chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		md := NewMQMD()
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_WAIT | MQGMO_FAIL_IF_QUIESCING | l.queue.GetOptions
+		gmo.WaitInterval = int32(l.queue.WaitInterval / time.Millisecond)
+
+		data, datalen, err := l.tx.GetSlice(l.object, md, gmo, l.buffer[:cap(l.buffer)])
+		if err != nil {
+			mqreturn, _ := err.(*MQReturn)
+			if mqreturn != nil && mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE {
+				continue
+			}
+			if mqreturn != nil && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED {
+				l.buffer = make([]byte, 0, datalen)
+				continue
+			}
+			if mqreturn == nil || mqreturn.MQCC == MQCC_FAILED {
+				return err
+			}
+		}
+		l.d.update(l.queue, func(s *DispatchStats) { s.Received++ })
+
+		poisoned, err := l.backout.Check(md, data)
+		if err != nil {
+			l.tx.Backout()
+			return err
+		}
+		if poisoned {
+			if err = l.tx.Commit(); err != nil {
+				return err
+			}
+			l.d.update(l.queue, func(s *DispatchStats) { s.Poisoned++ })
+			continue
+		}
+
+		ran, err := l.d.dispatch(stop, l.queue, &DispatchMessage{QName: l.queue.QName, MD: md, Data: data})
+		if !ran {
+			l.tx.Backout()
+			return nil
+		}
+		if err == nil {
+			err = l.tx.Commit()
+			if err == nil {
+				l.d.update(l.queue, func(s *DispatchStats) { s.Succeeded++ })
+				continue
+			}
+		}
+		l.tx.Backout()
+		l.d.update(l.queue, func(s *DispatchStats) {
+			s.Failed++
+			s.LastError = err
+		})
+	}
+}
@@ -0,0 +1,240 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file helps with processing the messages on a dead letter queue, in a similar way to
+the runmqdlq program. Each message is browsed and given to a function that decides what to
+do with it:
+
+  - Leave it on the queue
+  - Retry it, by removing the MQDLH and putting it back to its original destination
+  - Discard it
+
+A retried message is put with the original context, inside a unit of work, so that it
+is either on the DLQ or the destination queue but never lost. If the put fails, perhaps
+because the destination queue is still full, the message stays on the DLQ.
+*/
+
+import (
+	"strings"
+)
+
+// What to do with each message on the DLQ
+const (
+	DLQ_KEEP = iota
+	DLQ_RETRY
+	DLQ_DISCARD
+)
+
+/*
+DeadLetter is a message from a dead letter queue, split into its MQDLH and the
+original message
+*/
+type DeadLetter struct {
+	DLH  *MQDLH
+	MD   *MQMD  // The descriptor for the original message
+	Data []byte // The original message without the MQDLH
+}
+
+/*
+DLQAction is called for each message on the queue, and returns one of the DLQ_*
+values. The DeadLetter can be modified, for example to retry to a different queue
+by changing the DLH.DestQName. The Data is only valid until the function returns,
+so it must be copied if it is needed later.
+*/
+type DLQAction func(*DeadLetter) int
+
+/*
+DLQStats counts what happened to the messages in a call to Process
+*/
+type DLQStats struct {
+	Browsed   int
+	Kept      int
+	Retried   int
+	Discarded int
+	Failed    int
+}
+
+/*
+DLQHandler processes the messages on a dead letter queue
+*/
+type DLQHandler struct {
+	QName  string
+	conn   QMgrConnection
+	object Object
+	buffer []byte
+}
+
+/*
+ParseDeadLetter removes the MQDLH from a message, and returns a copy of the MQMD
+with the Format, Encoding and CodedCharSetId that the message had before it was put
+to the DLQ
+*/
+func ParseDeadLetter(md *MQMD, buf []byte) (*DeadLetter, error) {
+	if md.Format != MQFMT_DEAD_LETTER_HEADER || len(buf) < int(MQDLH_CURRENT_LENGTH) || string(buf[0:4]) != "DLH " {
+		return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_FORMAT_ERROR, verb: "DLQ"}
+	}
+
+	dlh, l, err := parseDLH(buf, encodingByteOrder(md.Encoding))
+	if err != nil {
+		return nil, err
+	}
+
+	// A DLH built by an application may be padded with nulls instead of spaces
+	dlh.DestQName = strings.TrimRight(dlh.DestQName, "\x00 ")
+	dlh.DestQMgrName = strings.TrimRight(dlh.DestQMgrName, "\x00 ")
+	dlh.PutApplName = strings.TrimRight(dlh.PutApplName, "\x00 ")
+
+	lmd := *md
+	lmd.Format = dlh.Format
+	lmd.Encoding = dlh.Encoding
+	lmd.CodedCharSetId = dlh.CodedCharSetId
+
+	return &DeadLetter{DLH: dlh, MD: &lmd, Data: buf[l:]}, nil
+}
+
+/*
+NewDLQHandler opens the named queue for processing. If the name is empty, the queue
+manager's own dead letter queue is used.
+*/
+func NewDLQHandler(conn QMgrConnection, qName string) (*DLQHandler, error) {
+	if qName == "" {
+		mqod := NewMQOD()
+		mqod.ObjectType = MQOT_Q_MGR
+		qMgrObject, err := conn.Open(mqod, MQOO_INQUIRE|MQOO_FAIL_IF_QUIESCING)
+		if err != nil {
+			return nil, err
+		}
+		values, err := qMgrObject.Inq([]int32{MQCA_DEAD_LETTER_Q_NAME})
+		qMgrObject.Close(0)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := values[MQCA_DEAD_LETTER_Q_NAME].(string); ok {
+			qName = strings.TrimSpace(v)
+		}
+		if qName == "" {
+			return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_UNKNOWN_OBJECT_NAME, verb: "DLQ"}
+		}
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	openOptions := MQOO_BROWSE | MQOO_INPUT_SHARED | MQOO_SAVE_ALL_CONTEXT | MQOO_FAIL_IF_QUIESCING
+	object, err := conn.Open(mqod, openOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DLQHandler{QName: qName, conn: conn, object: object, buffer: make([]byte, 0, 4*1024*1024)}, nil
+}
+
+/*
+Close the DLQ
+*/
+func (h *DLQHandler) Close() error {
+	return h.object.Close(0)
+}
+
+/*
+Process browses every message on the queue, calling the action function for each one.
+Messages that are not in dead letter format are always kept. An error is returned if the
+queue cannot be read; failures to retry individual messages are only counted.
+*/
+func (h *DLQHandler) Process(action DLQAction) (DLQStats, error) {
+	var stats DLQStats
+
+	for {
+		md := NewMQMD()
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_BROWSE_NEXT | MQGMO_NO_WAIT | MQGMO_FAIL_IF_QUIESCING
+		data, _, err := h.object.GetSlice(md, gmo, h.buffer[:cap(h.buffer)])
+		if err != nil {
+			if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE {
+				err = nil
+			}
+			return stats, err
+		}
+		stats.Browsed++
+
+		dl, err := ParseDeadLetter(md, data)
+		if err != nil {
+			stats.Kept++
+			continue
+		}
+
+		switch action(dl) {
+		case DLQ_RETRY:
+			if err = h.retry(dl); err != nil {
+				stats.Failed++
+			} else {
+				stats.Retried++
+			}
+		case DLQ_DISCARD:
+			if err = h.removeUnderCursor(MQGMO_NO_SYNCPOINT); err != nil {
+				stats.Failed++
+			} else {
+				stats.Discarded++
+			}
+		default:
+			stats.Kept++
+		}
+	}
+}
+
+// Get the message that has just been browsed
+func (h *DLQHandler) removeUnderCursor(syncOption int32) error {
+	gmo := NewMQGMO()
+	gmo.Options = MQGMO_MSG_UNDER_CURSOR | MQGMO_ACCEPT_TRUNCATED_MSG | MQGMO_FAIL_IF_QUIESCING | syncOption
+	_, err := h.object.Get(NewMQMD(), gmo, nil)
+	if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_ACCEPTED {
+		err = nil
+	}
+	return err
+}
+
+// Move the message back to its destination in a single unit of work
+func (h *DLQHandler) retry(dl *DeadLetter) error {
+	err := h.removeUnderCursor(MQGMO_SYNCPOINT)
+	if err != nil {
+		return err
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = dl.DLH.DestQName
+	mqod.ObjectQMgrName = dl.DLH.DestQMgrName
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_PASS_ALL_CONTEXT | MQPMO_FAIL_IF_QUIESCING
+	pmo.Context = mqObjectOf(h.object)
+
+	err = h.conn.Put1(mqod, dl.MD, pmo, dl.Data)
+	if err != nil {
+		h.conn.Back()
+		return err
+	}
+	return h.conn.Cmit()
+}
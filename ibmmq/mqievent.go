@@ -0,0 +1,126 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file gives a simpler way of registering a connection-level event handler than
+filling in an MQCBD with MQCBT_EVENT_HANDLER. The event handler is called by MQ for
+events that affect the whole connection, such as:
+
+  - An automatic reconnection starting, succeeding or failing
+  - The queue manager quiescing or stopping
+  - The connection being broken
+
+Applications that use automatic reconnection (MQCNO_RECONNECT) can use these events
+to pause their work while reconnection is in progress, or to clean up if it fails.
+The handler runs on a thread owned by MQ, so it should not block for long.
+*/
+
+/*
+ConnectionEvent describes an event passed to an EventHandlerFunction
+*/
+type ConnectionEvent struct {
+	CompCode int32
+	Reason   int32
+}
+
+/*
+EventHandlerFunction is the signature of a connection-level event handler
+*/
+type EventHandlerFunction func(*MQQueueManager, *ConnectionEvent)
+
+/*
+IsReconnecting returns true if an automatic reconnection has started
+*/
+func (e *ConnectionEvent) IsReconnecting() bool {
+	return e.Reason == MQRC_RECONNECTING
+}
+
+/*
+IsReconnected returns true if an automatic reconnection has succeeded
+*/
+func (e *ConnectionEvent) IsReconnected() bool {
+	return e.Reason == MQRC_RECONNECTED
+}
+
+/*
+IsReconnectFailed returns true if an automatic reconnection has been abandoned.
+The connection can no longer be used.
+*/
+func (e *ConnectionEvent) IsReconnectFailed() bool {
+	switch e.Reason {
+	case MQRC_RECONNECT_FAILED,
+		MQRC_RECONNECT_INCOMPATIBLE,
+		MQRC_RECONNECT_QMID_MISMATCH,
+		MQRC_RECONNECT_TIMED_OUT:
+		return true
+	}
+	return false
+}
+
+/*
+IsQuiescing returns true if the queue manager or connection is being shut down
+*/
+func (e *ConnectionEvent) IsQuiescing() bool {
+	switch e.Reason {
+	case MQRC_Q_MGR_QUIESCING, MQRC_CONNECTION_QUIESCING, MQRC_Q_MGR_STOPPING:
+		return true
+	}
+	return false
+}
+
+/*
+IsBroken returns true if the connection has been lost without reconnection
+*/
+func (e *ConnectionEvent) IsBroken() bool {
+	return e.Reason == MQRC_CONNECTION_BROKEN
+}
+
+/*
+String returns the name of the reason code
+*/
+func (e *ConnectionEvent) String() string {
+	return MQItoString("RC", int(e.Reason))
+}
+
+/*
+SetEventHandler registers a function to be called for connection-level events. Only one
+event handler can be registered on a connection; calling this again replaces it.
+*/
+func (x *MQQueueManager) SetEventHandler(fn EventHandlerFunction) error {
+	cbd := NewMQCBD()
+	cbd.CallbackType = MQCBT_EVENT_HANDLER
+	cbd.CallbackFunction = func(qMgr *MQQueueManager, hObj *MQObject, md *MQMD, gmo *MQGMO, buf []byte, cbc *MQCBC, mqreturn *MQReturn) {
+		fn(qMgr, &ConnectionEvent{CompCode: mqreturn.MQCC, Reason: mqreturn.MQRC})
+	}
+	return x.CB(MQOP_REGISTER, cbd)
+}
+
+/*
+RemoveEventHandler deregisters the connection's event handler
+*/
+func (x *MQQueueManager) RemoveEventHandler() error {
+	cbd := NewMQCBD()
+	cbd.CallbackType = MQCBT_EVENT_HANDLER
+	return x.CB(MQOP_DEREGISTER, cbd)
+}
@@ -0,0 +1,83 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file defines interfaces covering the most commonly-used MQI verbs. Applications
+that are written against these interfaces instead of the MQQueueManager and MQObject
+structures can substitute an alternative implementation - such as the in-memory queue
+manager in mqifake.go - when running unit tests.
+
+The interfaces deliberately mirror the existing verbs so that converting an application
+is mostly a matter of changing variable types. The one difference is Sub, where the
+queue used for a managed subscription is returned instead of being updated in place.
+Verbs that fail return a nil Object.
+
+This file, mqitypes.go and mqifake.go do not need cgo, so code that only uses the
+interfaces can be unit tested with the fake without the MQ headers and libraries.
+*/
+
+import "time"
+
+// QMgrConnection is the set of connection-level verbs
+type QMgrConnection interface {
+	Disc() error
+	Open(good *MQOD, goOpenOptions int32) (Object, error)
+	Sub(gosd *MQSD, qObject Object) (Object, Object, error)
+	Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error
+	Cmit() error
+	Back() error
+}
+
+// Object is the set of verbs that work with an opened queue, topic or
+// queue manager object
+type Object interface {
+	Close(goCloseOptions int32) error
+	Put(gomd *MQMD, gopmo *MQPMO, buffer []byte) error
+	Get(gomd *MQMD, gogmo *MQGMO, buffer []byte) (int, error)
+	GetSlice(gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, int, error)
+	GetBatch(n int, maxWait time.Duration, gogmo *MQGMO, buffer []byte) ([]BatchMessage, error)
+	Inq(goSelectors []int32) (map[int32]interface{}, error)
+	Set(goSelectors map[int32]interface{}) error
+	ObjectName() string
+}
+
+/*
+MessageHandleCreator is implemented by connections that can create message handles,
+for working with message properties. The in-memory queue manager does not have
+message properties, so check for this with a type assertion on the QMgrConnection.
+*/
+type MessageHandleCreator interface {
+	CrtMH(gocmho *MQCMHO) (MQMessageHandle, error)
+}
+
+/*
+IsUsableObject says whether the Object refers to an open object. It is the
+equivalent of IsUsableHObj for the interfaces.
+*/
+func IsUsableObject(o Object) bool {
+	if o == nil {
+		return false
+	}
+	if u, ok := o.(interface{ usable() bool }); ok {
+		return u.usable()
+	}
+	return true
+}
@@ -0,0 +1,841 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file contains an in-memory implementation of the QMgrConnection and Object
+interfaces. It is intended for unit tests of applications, so that they can exercise
+their messaging logic without a running queue manager. It does not call into the MQ
+C library at all, and is available when the package is built without cgo.
+
+The behaviour is a simplified version of what a real queue manager does:
+  * Local and model queues, with dynamic queues created from the model
+  * Messages are delivered in priority order, then FIFO
+  * MsgId/CorrelId/GroupId/MsgSeqNumber matching, browse cursors and truncation rules for MQGET
+  * Syncpoint puts and gets, with Cmit and Back
+  * Topic subscriptions (including '#' and '+' wildcards) delivering to
+    either a provided or a managed queue; durable subscriptions can be resumed
+  * MQINQ/MQSET of a handful of attributes, and any others that a test preloads
+
+There is no security checking, no message expiry, no clustering or
+remote queue resolution, and no message properties.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+FakeQueueManager is an in-memory queue manager that implements the QMgrConnection interface
+*/
+type FakeQueueManager struct {
+	mu sync.Mutex
+
+	name      string
+	connected bool
+	queues    map[string]*fakeQueue
+	subs      map[*fakeSub]bool
+	attrs     map[int32]interface{}
+
+	msgSeq      uint64
+	dynSeq      int
+	uncommitted []fakeUnitOfWork
+}
+
+type fakeQueue struct {
+	name     string
+	model    bool
+	dynamic  bool
+	maxDepth int32
+	maxMsgL  int32
+	msgs     []*fakeMessage
+	attrs    map[int32]interface{}
+}
+
+type fakeMessage struct {
+	seq     uint64
+	md      MQMD
+	data    []byte
+	pending bool // Put under syncpoint but not yet committed
+	marked  bool // Marked by a cooperative browse
+}
+
+type fakeSub struct {
+	name    string
+	topic   string
+	durable bool
+	managed bool
+	queue   *fakeQueue
+}
+
+// Each operation done under syncpoint is remembered so that it can
+// be completed or undone
+type fakeUnitOfWork struct {
+	q   *fakeQueue
+	msg *fakeMessage
+	get bool
+}
+
+type fakeObject struct {
+	qMgr       *FakeQueueManager
+	name       string
+	objectType int32
+	options    int32
+	q          *fakeQueue
+	sub        *fakeSub
+	topic      string
+	browseSeq  uint64
+	closed     bool
+}
+
+const fakeMaxMsgLength = 4 * 1024 * 1024
+const fakeMaxDepth = 5000
+
+/*
+NewFakeQueueManager creates an in-memory queue manager. It already has the
+default model queue and the command queue defined so that programs expecting
+those basic objects can open them.
+*/
+func NewFakeQueueManager(name string) *FakeQueueManager {
+	qm := new(FakeQueueManager)
+	qm.name = name
+	qm.connected = true
+	qm.queues = make(map[string]*fakeQueue)
+	qm.subs = make(map[*fakeSub]bool)
+	qm.attrs = make(map[int32]interface{})
+
+	qm.attrs[MQCA_Q_MGR_NAME] = name
+	qm.attrs[MQIA_COMMAND_LEVEL] = int32(MQCMDL_CURRENT_LEVEL)
+	qm.attrs[MQIA_PLATFORM] = int32(MQPL_UNIX)
+	qm.attrs[MQIA_MAX_HANDLES] = int32(256)
+	qm.attrs[MQIA_MAX_MSG_LENGTH] = int32(fakeMaxMsgLength)
+
+	qm.DefineModelQueue("SYSTEM.DEFAULT.MODEL.QUEUE")
+	qm.DefineQueue("SYSTEM.ADMIN.COMMAND.QUEUE")
+	return qm
+}
+
+/*
+DefineQueue creates a local queue. An existing queue of the same name is replaced.
+*/
+func (qm *FakeQueueManager) DefineQueue(name string) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.queues[name] = newFakeQueue(name)
+}
+
+/*
+DefineModelQueue creates a model queue, which can be opened to create dynamic queues
+*/
+func (qm *FakeQueueManager) DefineModelQueue(name string) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q := newFakeQueue(name)
+	q.model = true
+	qm.queues[name] = q
+}
+
+/*
+SetQueueAttr sets an attribute that will be returned by a later Inq on the queue
+*/
+func (qm *FakeQueueManager) SetQueueAttr(name string, selector int32, value interface{}) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q, ok := qm.queues[name]
+	if !ok {
+		return fakeError("MQSET", MQRC_UNKNOWN_OBJECT_NAME)
+	}
+	q.setAttr(selector, value)
+	return nil
+}
+
+/*
+SetQMgrAttr sets an attribute that will be returned by a later Inq on the queue manager
+*/
+func (qm *FakeQueueManager) SetQMgrAttr(selector int32, value interface{}) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.attrs[selector] = value
+}
+
+/*
+Depth returns the number of committed messages on a queue, or -1 if the queue
+does not exist
+*/
+func (qm *FakeQueueManager) Depth(name string) int {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q, ok := qm.queues[name]
+	if !ok {
+		return -1
+	}
+	return q.depth()
+}
+
+func newFakeQueue(name string) *fakeQueue {
+	q := new(fakeQueue)
+	q.name = name
+	q.maxDepth = fakeMaxDepth
+	q.maxMsgL = fakeMaxMsgLength
+	q.msgs = make([]*fakeMessage, 0)
+	q.attrs = make(map[int32]interface{})
+	return q
+}
+
+func (q *fakeQueue) depth() int {
+	d := 0
+	for _, m := range q.msgs {
+		if !m.pending {
+			d++
+		}
+	}
+	return d
+}
+
+func (q *fakeQueue) setAttr(selector int32, value interface{}) {
+	switch selector {
+	case MQIA_MAX_Q_DEPTH:
+		q.maxDepth = fakeInt32(value)
+	case MQIA_MAX_MSG_LENGTH:
+		q.maxMsgL = fakeInt32(value)
+	default:
+		q.attrs[selector] = value
+	}
+}
+
+// Insert the message in priority order, FIFO within a priority
+func (q *fakeQueue) insert(m *fakeMessage) {
+	i := len(q.msgs)
+	for i > 0 && q.msgs[i-1].md.Priority < m.md.Priority {
+		i--
+	}
+	q.msgs = append(q.msgs, nil)
+	copy(q.msgs[i+1:], q.msgs[i:])
+	q.msgs[i] = m
+}
+
+func (q *fakeQueue) remove(m *fakeMessage) {
+	for i := range q.msgs {
+		if q.msgs[i] == m {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			return
+		}
+	}
+}
+
+func fakeError(verb string, rc int32) error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: rc, verb: verb}
+}
+
+func fakeWarning(verb string, rc int32) error {
+	return &MQReturn{MQCC: MQCC_WARNING, MQRC: rc, verb: verb}
+}
+
+func fakeInt32(v interface{}) int32 {
+	switch i := v.(type) {
+	case int32:
+		return i
+	case int:
+		return int32(i)
+	case int64:
+		return int32(i)
+	}
+	return 0
+}
+
+// Create a unique 24-byte identifier
+func (qm *FakeQueueManager) newId() []byte {
+	qm.msgSeq++
+	id := make([]byte, MQ_MSG_ID_LENGTH)
+	copy(id, []byte("FAKE"))
+	binary.BigEndian.PutUint64(id[16:], qm.msgSeq)
+	return id
+}
+
+func (qm *FakeQueueManager) Disc() error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if !qm.connected {
+		return fakeError("MQDISC", MQRC_HCONN_ERROR)
+	}
+	// Disconnecting normally commits outstanding work
+	qm.commit()
+	qm.connected = false
+	return nil
+}
+
+func (qm *FakeQueueManager) Open(good *MQOD, goOpenOptions int32) (Object, error) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if !qm.connected {
+		return nil, fakeError("MQOPEN", MQRC_HCONN_ERROR)
+	}
+
+	o := &fakeObject{qMgr: qm, objectType: good.ObjectType, options: goOpenOptions}
+
+	switch good.ObjectType {
+	case MQOT_Q_MGR:
+		o.name = qm.name
+	case MQOT_TOPIC:
+		o.topic = good.ObjectString
+		o.name = good.ObjectString
+		good.ResObjectString = good.ObjectString
+	case MQOT_Q:
+		q, ok := qm.queues[good.ObjectName]
+		if !ok {
+			return nil, fakeError("MQOPEN", MQRC_UNKNOWN_OBJECT_NAME)
+		}
+		if q.model {
+			q = qm.createDynamicQueue(good.DynamicQName)
+			good.ObjectName = q.name
+		}
+		o.q = q
+		o.name = q.name
+		good.ResolvedQName = q.name
+		good.ResolvedQMgrName = qm.name
+	default:
+		return nil, fakeError("MQOPEN", MQRC_OBJECT_TYPE_ERROR)
+	}
+
+	return o, nil
+}
+
+func (qm *FakeQueueManager) createDynamicQueue(pattern string) *fakeQueue {
+	if pattern == "" {
+		pattern = "AMQ.*"
+	}
+	qm.dynSeq++
+	name := strings.TrimSuffix(pattern, "*")
+	if strings.HasSuffix(pattern, "*") {
+		name = name + fmt.Sprintf("%016X", qm.dynSeq)
+	}
+	q := newFakeQueue(name)
+	q.dynamic = true
+	qm.queues[name] = q
+	return q
+}
+
+func (qm *FakeQueueManager) Sub(gosd *MQSD, qObject Object) (Object, Object, error) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if !qm.connected {
+		return nil, nil, fakeError("MQSUB", MQRC_HCONN_ERROR)
+	}
+
+	var sub *fakeSub
+	var qo *fakeObject
+
+	topic := gosd.ObjectString
+	durable := (gosd.Options & MQSO_DURABLE) != 0
+
+	if durable && gosd.SubName != "" {
+		for s := range qm.subs {
+			if s.name == gosd.SubName {
+				sub = s
+				break
+			}
+		}
+	}
+
+	if sub == nil {
+		if (gosd.Options & MQSO_CREATE) == 0 {
+			return nil, nil, fakeError("MQSUB", MQRC_NO_SUBSCRIPTION)
+		}
+		sub = &fakeSub{name: gosd.SubName, topic: topic, durable: durable}
+		qm.subs[sub] = true
+	} else if (gosd.Options & (MQSO_RESUME | MQSO_ALTER)) == 0 {
+		return nil, nil, fakeError("MQSUB", MQRC_SUB_ALREADY_EXISTS)
+	}
+	if (gosd.Options&MQSO_ALTER) != 0 && topic != "" {
+		sub.topic = topic
+	}
+
+	if (gosd.Options & MQSO_MANAGED) != 0 {
+		if sub.queue == nil {
+			sub.queue = qm.createDynamicQueue("SYSTEM.MANAGED.DURABLE.*")
+			sub.managed = true
+		}
+		qo = &fakeObject{qMgr: qm, objectType: MQOT_Q, options: MQOO_INPUT_AS_Q_DEF, q: sub.queue, name: sub.queue.name}
+	} else {
+		o, ok := qObject.(*fakeObject)
+		if !ok || o == nil || o.q == nil {
+			if sub.queue == nil {
+				delete(qm.subs, sub)
+				return nil, nil, fakeError("MQSUB", MQRC_HOBJ_ERROR)
+			}
+		} else {
+			sub.queue = o.q
+			qo = o
+		}
+	}
+
+	gosd.ResObjectString = sub.topic
+	so := &fakeObject{qMgr: qm, objectType: MQOT_TOPIC, sub: sub, topic: sub.topic, name: gosd.ObjectName + "[" + sub.topic + "]"}
+	return so, qo, nil
+}
+
+func (qm *FakeQueueManager) Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	o, err := qm.Open(good, MQOO_OUTPUT)
+	if err != nil {
+		return err
+	}
+	err = o.Put(gomd, gopmo, buffer)
+	o.Close(0)
+	return err
+}
+
+func (qm *FakeQueueManager) Cmit() error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if !qm.connected {
+		return fakeError("MQCMIT", MQRC_HCONN_ERROR)
+	}
+	qm.commit()
+	return nil
+}
+
+func (qm *FakeQueueManager) Back() error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if !qm.connected {
+		return fakeError("MQBACK", MQRC_HCONN_ERROR)
+	}
+	for i := len(qm.uncommitted) - 1; i >= 0; i-- {
+		u := qm.uncommitted[i]
+		if u.get {
+			u.msg.md.BackoutCount++
+			u.q.insert(u.msg)
+		} else {
+			u.q.remove(u.msg)
+		}
+	}
+	qm.uncommitted = nil
+	return nil
+}
+
+func (qm *FakeQueueManager) commit() {
+	for _, u := range qm.uncommitted {
+		if !u.get {
+			u.msg.pending = false
+		}
+	}
+	qm.uncommitted = nil
+}
+
+func (o *fakeObject) ObjectName() string {
+	return o.name
+}
+
+func (o *fakeObject) usable() bool {
+	o.qMgr.mu.Lock()
+	defer o.qMgr.mu.Unlock()
+	return !o.closed
+}
+
+func (o *fakeObject) Close(goCloseOptions int32) error {
+	qm := o.qMgr
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if o.closed {
+		return fakeError("MQCLOSE", MQRC_HOBJ_ERROR)
+	}
+	o.closed = true
+
+	if o.sub != nil {
+		if !o.sub.durable || (goCloseOptions&MQCO_REMOVE_SUB) != 0 {
+			delete(qm.subs, o.sub)
+			if o.sub.managed && o.sub.queue != nil {
+				delete(qm.queues, o.sub.queue.name)
+			}
+		}
+	}
+
+	// Temporary dynamic queues go away when they are closed
+	if o.q != nil && o.q.dynamic && !qm.queueInUseBySub(o.q) {
+		delete(qm.queues, o.q.name)
+	}
+	return nil
+}
+
+func (qm *FakeQueueManager) queueInUseBySub(q *fakeQueue) bool {
+	for s := range qm.subs {
+		if s.queue == q {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *fakeObject) Put(gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	qm := o.qMgr
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if o.closed {
+		return fakeError("MQPUT", MQRC_HOBJ_ERROR)
+	}
+	if o.objectType == MQOT_Q && (o.options&MQOO_OUTPUT) == 0 {
+		return fakeError("MQPUT", MQRC_NOT_OPEN_FOR_OUTPUT)
+	}
+
+	if (gopmo.Options&MQPMO_NEW_MSG_ID) != 0 || bytes.Count(gomd.MsgId, []byte{0}) == len(gomd.MsgId) {
+		gomd.MsgId = qm.newId()
+	}
+	if (gopmo.Options & MQPMO_NEW_CORREL_ID) != 0 {
+		gomd.CorrelId = qm.newId()
+	}
+	gomd.PutDateTime = time.Now()
+	if gomd.Priority == MQPRI_PRIORITY_AS_Q_DEF {
+		gomd.Priority = 0
+	}
+	syncpoint := (gopmo.Options & MQPMO_SYNCPOINT) != 0
+
+	if o.objectType == MQOT_TOPIC {
+		for s := range qm.subs {
+			if s.queue != nil && fakeTopicMatch(s.topic, o.topic) {
+				if err := qm.putToQueue(s.queue, gomd, buffer, syncpoint); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return qm.putToQueue(o.q, gomd, buffer, syncpoint)
+}
+
+func (qm *FakeQueueManager) putToQueue(q *fakeQueue, gomd *MQMD, buffer []byte, syncpoint bool) error {
+	if len(buffer) > int(q.maxMsgL) {
+		return fakeError("MQPUT", MQRC_MSG_TOO_BIG_FOR_Q)
+	}
+	if len(q.msgs) >= int(q.maxDepth) {
+		return fakeError("MQPUT", MQRC_Q_FULL)
+	}
+
+	qm.msgSeq++
+	m := &fakeMessage{seq: qm.msgSeq, md: fakeCopyMD(gomd), data: append([]byte{}, buffer...), pending: syncpoint}
+	q.insert(m)
+	if syncpoint {
+		qm.uncommitted = append(qm.uncommitted, fakeUnitOfWork{q: q, msg: m, get: false})
+	}
+	return nil
+}
+
+func (o *fakeObject) Get(gomd *MQMD, gogmo *MQGMO, buffer []byte) (int, error) {
+	return o.getInternal(gomd, gogmo, buffer, false)
+}
+
+func (o *fakeObject) GetSlice(gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, int, error) {
+	realDatalen, err := o.getInternal(gomd, gogmo, buffer, true)
+	datalen := realDatalen
+	if datalen > cap(buffer) {
+		datalen = cap(buffer)
+	}
+	return buffer[0:datalen], realDatalen, err
+}
+
+/*
+GetBatch follows the rules of the real GetBatch, but gets the messages one at a time
+*/
+func (o *fakeObject) GetBatch(n int, maxWait time.Duration, gogmo *MQGMO, buffer []byte) ([]BatchMessage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if gogmo == nil {
+		gogmo = NewMQGMO()
+		gogmo.Options = MQGMO_NO_SYNCPOINT | MQGMO_FAIL_IF_QUIESCING | MQGMO_CONVERT
+		gogmo.MatchOptions = MQMO_NONE
+	}
+	gogmo.Options &^= MQGMO_WAIT | MQGMO_NO_WAIT
+	if maxWait > 0 {
+		gogmo.Options |= MQGMO_WAIT
+		gogmo.WaitInterval = int32(maxWait / time.Millisecond)
+	} else {
+		gogmo.Options |= MQGMO_NO_WAIT
+		gogmo.WaitInterval = 0
+	}
+	if buffer == nil {
+		buffer = make([]byte, 4*1024*1024)
+	}
+
+	msgs := make([]BatchMessage, 0)
+	offset := 0
+	for len(msgs) < n {
+		gomd := NewMQMD()
+		datalen, err := o.Get(gomd, gogmo, buffer[offset:])
+		if err != nil {
+			mqreturn := err.(*MQReturn)
+			if mqreturn.MQCC == MQCC_FAILED || mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED {
+				if len(msgs) > 0 && (mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE || mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED) {
+					err = nil
+				}
+				return msgs, err
+			}
+		}
+		if datalen > len(buffer)-offset {
+			datalen = len(buffer) - offset
+		}
+		msgs = append(msgs, BatchMessage{MD: gomd, Data: buffer[offset : offset+datalen]})
+		offset += datalen
+		if err != nil {
+			return msgs, err
+		}
+
+		gogmo.Options &^= MQGMO_WAIT
+		gogmo.Options |= MQGMO_NO_WAIT
+		gogmo.WaitInterval = 0
+	}
+	return msgs, nil
+}
+
+func (o *fakeObject) getInternal(gomd *MQMD, gogmo *MQGMO, buffer []byte, useCap bool) (int, error) {
+	var deadline time.Time
+
+	wait := (gogmo.Options & MQGMO_WAIT) != 0
+	if wait && gogmo.WaitInterval != MQWI_UNLIMITED {
+		deadline = time.Now().Add(time.Duration(gogmo.WaitInterval) * time.Millisecond)
+	}
+
+	for {
+		datalen, found, err := o.tryGet(gomd, gogmo, buffer, useCap)
+		if found || err != nil {
+			return datalen, err
+		}
+		if !wait || (!deadline.IsZero() && time.Now().After(deadline)) {
+			return 0, fakeError("MQGET", MQRC_NO_MSG_AVAILABLE)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (o *fakeObject) tryGet(gomd *MQMD, gogmo *MQGMO, buffer []byte, useCap bool) (int, bool, error) {
+	qm := o.qMgr
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if o.closed || o.q == nil {
+		return 0, false, fakeError("MQGET", MQRC_HOBJ_ERROR)
+	}
+	browse := (gogmo.Options & (MQGMO_BROWSE_FIRST | MQGMO_BROWSE_NEXT | MQGMO_BROWSE_MSG_UNDER_CURSOR)) != 0
+	if !browse && (o.options&(MQOO_INPUT_AS_Q_DEF|MQOO_INPUT_SHARED|MQOO_INPUT_EXCLUSIVE)) == 0 {
+		return 0, false, fakeError("MQGET", MQRC_NOT_OPEN_FOR_INPUT)
+	}
+	if browse && (o.options&MQOO_BROWSE) == 0 {
+		return 0, false, fakeError("MQGET", MQRC_NOT_OPEN_FOR_BROWSE)
+	}
+	if (gogmo.Options & MQGMO_BROWSE_FIRST) != 0 {
+		o.browseSeq = 0
+	}
+
+	var m *fakeMessage
+	for _, c := range o.q.msgs {
+		if c.pending {
+			continue
+		}
+		if browse && (gogmo.Options&MQGMO_BROWSE_NEXT) != 0 && c.seq <= o.browseSeq {
+			continue
+		}
+		if (gogmo.MatchOptions&MQMO_MATCH_MSG_ID) != 0 && !fakeIsNone(gomd.MsgId) && !bytes.Equal(gomd.MsgId, c.md.MsgId) {
+			continue
+		}
+		if (gogmo.MatchOptions&MQMO_MATCH_CORREL_ID) != 0 && !fakeIsNone(gomd.CorrelId) && !bytes.Equal(gomd.CorrelId, c.md.CorrelId) {
+			continue
+		}
+		if (gogmo.MatchOptions&MQMO_MATCH_GROUP_ID) != 0 && !fakeIsNone(gomd.GroupId) && !bytes.Equal(gomd.GroupId, c.md.GroupId) {
+			continue
+		}
+		if (gogmo.MatchOptions&MQMO_MATCH_MSG_SEQ_NUMBER) != 0 && gomd.MsgSeqNumber != c.md.MsgSeqNumber {
+			continue
+		}
+		if (gogmo.MatchOptions&MQMO_MATCH_MSG_TOKEN) != 0 && !bytes.Equal(gogmo.MsgToken, fakeMsgToken(c.seq)) {
+			continue
+		}
+		if (gogmo.Options&(MQGMO_MSG_UNDER_CURSOR|MQGMO_BROWSE_MSG_UNDER_CURSOR)) != 0 && c.seq != o.browseSeq {
+			continue
+		}
+		if (gogmo.Options&MQGMO_UNMARKED_BROWSE_MSG) != 0 && c.marked {
+			continue
+		}
+		m = c
+		break
+	}
+	if m == nil {
+		return 0, false, nil
+	}
+
+	bufflen := len(buffer)
+	if useCap {
+		bufflen = cap(buffer)
+		buffer = buffer[0:bufflen]
+	}
+	datalen := len(m.data)
+	copy(buffer, m.data)
+	*gomd = fakeCopyMD(&m.md)
+	gogmo.ResolvedQName = o.q.name
+	gogmo.ReturnedLength = int32(datalen)
+	gogmo.MsgToken = fakeMsgToken(m.seq)
+
+	truncated := datalen > bufflen
+	if truncated && (gogmo.Options&MQGMO_ACCEPT_TRUNCATED_MSG) == 0 {
+		return datalen, true, fakeWarning("MQGET", MQRC_TRUNCATED_MSG_FAILED)
+	}
+
+	if browse {
+		o.browseSeq = m.seq
+		if (gogmo.Options & MQGMO_MARK_BROWSE_CO_OP) != 0 {
+			if m.marked {
+				return datalen, true, fakeError("MQGET", MQRC_MSG_MARKED_BROWSE_CO_OP)
+			}
+			m.marked = true
+		}
+		if (gogmo.Options & MQGMO_UNMARK_BROWSE_CO_OP) != 0 {
+			m.marked = false
+		}
+	} else {
+		o.q.remove(m)
+		if (gogmo.Options & MQGMO_SYNCPOINT) != 0 {
+			qm.uncommitted = append(qm.uncommitted, fakeUnitOfWork{q: o.q, msg: m, get: true})
+		}
+	}
+
+	if truncated {
+		return datalen, true, fakeWarning("MQGET", MQRC_TRUNCATED_MSG_ACCEPTED)
+	}
+	return datalen, true, nil
+}
+
+// The token for a message is made from its sequence number
+func fakeMsgToken(seq uint64) []byte {
+	token := make([]byte, MQ_MSG_TOKEN_LENGTH)
+	binary.BigEndian.PutUint64(token[8:], seq)
+	return token
+}
+
+func (o *fakeObject) Inq(goSelectors []int32) (map[int32]interface{}, error) {
+	qm := o.qMgr
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if o.closed {
+		return nil, fakeError("MQINQ", MQRC_HOBJ_ERROR)
+	}
+
+	rc := make(map[int32]interface{})
+	for _, s := range goSelectors {
+		var v interface{}
+		ok := false
+
+		if o.q != nil {
+			ok = true
+			switch s {
+			case MQCA_Q_NAME:
+				v = o.q.name
+			case MQIA_CURRENT_Q_DEPTH:
+				v = int32(o.q.depth())
+			case MQIA_MAX_Q_DEPTH:
+				v = o.q.maxDepth
+			case MQIA_MAX_MSG_LENGTH:
+				v = o.q.maxMsgL
+			case MQIA_Q_TYPE:
+				v = int32(MQQT_LOCAL)
+			case MQIA_MSG_DELIVERY_SEQUENCE:
+				// Messages are always delivered in priority order, but a test can say otherwise
+				if v, ok = o.q.attrs[s]; !ok {
+					v, ok = int32(MQMDS_PRIORITY), true
+				}
+			case MQIA_DEFINITION_TYPE:
+				if o.q.dynamic {
+					v = int32(MQQDT_TEMPORARY_DYNAMIC)
+				} else {
+					v = int32(MQQDT_PREDEFINED)
+				}
+			default:
+				v, ok = o.q.attrs[s]
+			}
+		} else if o.objectType == MQOT_Q_MGR {
+			v, ok = qm.attrs[s]
+		}
+
+		if !ok {
+			return nil, fakeError("MQINQ", MQRC_SELECTOR_ERROR)
+		}
+		rc[s] = v
+	}
+	return rc, nil
+}
+
+func (o *fakeObject) Set(goSelectors map[int32]interface{}) error {
+	qm := o.qMgr
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if o.closed {
+		return fakeError("MQSET", MQRC_HOBJ_ERROR)
+	}
+	if o.q == nil {
+		return fakeError("MQSET", MQRC_OBJECT_TYPE_ERROR)
+	}
+	for s, v := range goSelectors {
+		o.q.setAttr(s, v)
+	}
+	return nil
+}
+
+func fakeIsNone(b []byte) bool {
+	return len(b) == 0 || bytes.Count(b, []byte{0}) == len(b)
+}
+
+// Take a copy of the MQMD including its byte slices, so the caller cannot
+// modify a stored message
+func fakeCopyMD(md *MQMD) MQMD {
+	c := *md
+	c.MsgId = append([]byte{}, md.MsgId...)
+	c.CorrelId = append([]byte{}, md.CorrelId...)
+	c.AccountingToken = append([]byte{}, md.AccountingToken...)
+	c.GroupId = append([]byte{}, md.GroupId...)
+	return c
+}
+
+// Topic matching using the MQ topic-based wildcard scheme, where '#' matches
+// any number of levels and '+' matches exactly one.
+func fakeTopicMatch(pattern string, topic string) bool {
+	p := strings.Split(pattern, "/")
+	t := strings.Split(topic, "/")
+	for i := 0; i < len(p); i++ {
+		if p[i] == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if p[i] != "+" && p[i] != t[i] {
+			return false
+		}
+	}
+	return len(p) == len(t)
+}
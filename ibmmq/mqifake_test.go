@@ -0,0 +1,418 @@
+/*
+© Copyright IBM Corporation 2023
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ibmmq
+
+// These tests only use the in-memory queue manager, so they do not need cgo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeQueueManagerPutGet(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("Q1")
+
+	od := NewMQOD()
+	od.ObjectName = "Q1"
+	q, err := qm.Open(od, MQOO_OUTPUT|MQOO_INPUT_AS_Q_DEF)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// A higher priority message should be returned first. The syncpoint
+	// message is not visible until it is committed.
+	for i, p := range []int32{0, 5, 0} {
+		md := NewMQMD()
+		md.Priority = p
+		pmo := NewMQPMO()
+		if i == 2 {
+			pmo.Options = MQPMO_SYNCPOINT
+		}
+		if err = q.Put(md, pmo, []byte{byte('a' + i)}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if d := qm.Depth("Q1"); d != 2 {
+		t.Logf("Depth before commit. Expected: 2, Got: %d", d)
+		t.Fail()
+	}
+	qm.Cmit()
+
+	got := ""
+	buf := make([]byte, 10)
+	for {
+		n, err := q.Get(NewMQMD(), NewMQGMO(), buf)
+		if err != nil {
+			if err.(*MQReturn).MQRC != MQRC_NO_MSG_AVAILABLE {
+				t.Fatalf("Get failed: %v", err)
+			}
+			break
+		}
+		got += string(buf[:n])
+	}
+	if got != "bac" {
+		t.Logf("Message order. Expected: bac, Got: %s", got)
+		t.Fail()
+	}
+}
+
+func TestFakeQueueManagerGetBatch(t *testing.T) {
+	var conn QMgrConnection = NewFakeQueueManager("QM1")
+	conn.(*FakeQueueManager).DefineQueue("Q1")
+
+	od := NewMQOD()
+	od.ObjectName = "Q1"
+	q, err := conn.Open(od, MQOO_OUTPUT|MQOO_INPUT_AS_Q_DEF)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, m := range []string{"one", "two", "three"} {
+		if err = q.Put(NewMQMD(), NewMQPMO(), []byte(m)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	// The third message does not fit in what is left of the buffer, so stays on the queue
+	msgs, err := q.GetBatch(5, 0, nil, make([]byte, 8))
+	if err != nil || len(msgs) != 2 || string(msgs[0].Data) != "one" || string(msgs[1].Data) != "two" {
+		t.Logf("First batch. Got: %d messages, err %v", len(msgs), err)
+		t.Fail()
+	}
+	msgs, err = q.GetBatch(5, 0, nil, make([]byte, 8))
+	if err != nil || len(msgs) != 1 || string(msgs[0].Data) != "three" {
+		t.Logf("Second batch. Got: %d messages, err %v", len(msgs), err)
+		t.Fail()
+	}
+	if _, err = q.GetBatch(5, 0, nil, nil); err == nil || err.(*MQReturn).MQRC != MQRC_NO_MSG_AVAILABLE {
+		t.Logf("Empty queue. Expected 2033, Got: %v", err)
+		t.Fail()
+	}
+
+	if !IsUsableObject(q) {
+		t.Logf("Open object is not usable")
+		t.Fail()
+	}
+	q.Close(0)
+	if IsUsableObject(q) || IsUsableObject(nil) {
+		t.Logf("Closed object is still usable")
+		t.Fail()
+	}
+}
+
+func TestPriorityConsumer(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("HIGH")
+	qm.DefineQueue("LOW")
+	qm.DefineQueue("BULK")
+	qm.SetQueueAttr("BULK", MQIA_MSG_DELIVERY_SEQUENCE, int32(MQMDS_FIFO))
+
+	put := func(qName string, msgs ...string) {
+		for _, m := range msgs {
+			od := NewMQOD()
+			od.ObjectName = qName
+			if err := qm.Put1(od, NewMQMD(), NewMQPMO(), []byte(m)); err != nil {
+				t.Fatalf("Put1 to %s failed: %v", qName, err)
+			}
+		}
+	}
+	drain := func(c *PriorityConsumer) string {
+		var got []string
+		for {
+			m, err := c.Get(0)
+			if err != nil {
+				if err.(*MQReturn).MQRC != MQRC_NO_MSG_AVAILABLE {
+					t.Fatalf("Get failed: %v", err)
+				}
+				return strings.Join(got, " ")
+			}
+			got = append(got, string(m.Data))
+		}
+	}
+
+	if _, err := NewPriorityConsumer(qm); err == nil {
+		t.Logf("No sources. Expected an error")
+		t.Fail()
+	}
+	if _, err := NewPriorityConsumer(qm, PrioritySource{QName: "MISSING"}); err == nil {
+		t.Logf("Unknown queue. Expected an error")
+		t.Fail()
+	}
+
+	// Once HIGH has had 2 messages in a row, each of the queues below it gets one
+	// before HIGH is served again. BULK has no weight of its own, but still only gets
+	// one message in each of those turns.
+	c, err := NewPriorityConsumer(qm, PrioritySource{QName: "HIGH", Weight: 2}, PrioritySource{QName: "LOW", Weight: 3}, PrioritySource{QName: "BULK"})
+	if err != nil {
+		t.Fatalf("NewPriorityConsumer failed: %v", err)
+	}
+	put("HIGH", "h1", "h2", "h3", "h4", "h5")
+	put("LOW", "l1", "l2", "l3", "l4")
+	put("BULK", "b1", "b2", "b3")
+	expected := "h1 h2 l1 b1 h3 h4 l2 b2 h5 l3 l4 b3"
+	if got := drain(c); got != expected {
+		t.Logf("Weighted order. Expected: %s, Got: %s", expected, got)
+		t.Fail()
+	}
+
+	c.Close()
+
+	// When HIGH is empty, LOW uses its own weight to share with BULK
+	c, err = NewPriorityConsumer(qm, PrioritySource{QName: "HIGH", Weight: 2}, PrioritySource{QName: "LOW", Weight: 3}, PrioritySource{QName: "BULK"})
+	if err != nil {
+		t.Fatalf("NewPriorityConsumer failed: %v", err)
+	}
+	put("LOW", "l5", "l6", "l7", "l8")
+	put("BULK", "b4", "b5")
+	put("HIGH", "h6")
+	expected = "h6 l5 l6 l7 b4 l8 b5"
+	if got := drain(c); got != expected {
+		t.Logf("Weight used by a lower queue. Expected: %s, Got: %s", expected, got)
+		t.Fail()
+	}
+
+	if seq := c.DeliverySequence("HIGH"); seq != MQMDS_PRIORITY {
+		t.Logf("DeliverySequence for HIGH. Expected: %d, Got: %d", MQMDS_PRIORITY, seq)
+		t.Fail()
+	}
+	if seq := c.DeliverySequence("BULK"); seq != MQMDS_FIFO {
+		t.Logf("DeliverySequence for BULK. Expected: %d, Got: %d", MQMDS_FIFO, seq)
+		t.Fail()
+	}
+	if seq := c.DeliverySequence("OTHER"); seq != -1 {
+		t.Logf("DeliverySequence for an unknown queue. Expected: -1, Got: %d", seq)
+		t.Fail()
+	}
+	c.Close()
+
+	// With no weight, the first queue is always emptied before the others are read
+	c, err = NewPriorityConsumer(qm, PrioritySource{QName: "HIGH"}, PrioritySource{QName: "LOW"})
+	if err != nil {
+		t.Fatalf("NewPriorityConsumer failed: %v", err)
+	}
+	put("HIGH", "h1", "h2", "h3")
+	put("LOW", "l1")
+	expected = "h1 h2 h3 l1"
+	if got := drain(c); got != expected {
+		t.Logf("Unweighted order. Expected: %s, Got: %s", expected, got)
+		t.Fail()
+	}
+
+	// A message bigger than the buffer is read again with a buffer that fits
+	c.buffer = make([]byte, 0, 4)
+	put("HIGH", "a longer message")
+	if m, err := c.Get(0); err != nil || string(m.Data) != "a longer message" {
+		t.Logf("Long message. Got: %v, err %v", m, err)
+		t.Fail()
+	}
+
+	// A message arriving on a lower queue while waiting is found at the next poll
+	c.PollInterval = 50 * time.Millisecond
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		put("LOW", "late")
+	}()
+	start := time.Now()
+	if m, err := c.Get(5 * time.Second); err != nil || string(m.Data) != "late" || m.QName != "LOW" {
+		t.Logf("Waiting Get. Got: %v, err %v", m, err)
+		t.Fail()
+	} else if elapsed := time.Since(start); elapsed > time.Second {
+		t.Logf("Waiting Get took too long: %v", elapsed)
+		t.Fail()
+	}
+
+	start = time.Now()
+	if _, err = c.Get(100 * time.Millisecond); err == nil || err.(*MQReturn).MQRC != MQRC_NO_MSG_AVAILABLE {
+		t.Logf("Empty queues. Expected 2033, Got: %v", err)
+		t.Fail()
+	} else if time.Since(start) < 100*time.Millisecond {
+		t.Logf("Empty queues. Returned before the wait expired")
+		t.Fail()
+	}
+	c.Close()
+}
+
+func TestStreamHash(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	expected := sha256.Sum256(data)
+
+	// The state saved part way through carries on as if nothing had happened
+	h, _ := restoreStreamHash(nil)
+	h.Write(data[:10])
+	h, err := restoreStreamHash(saveStreamHash(h))
+	if err != nil {
+		t.Fatalf("restoreStreamHash failed: %v", err)
+	}
+	h.Write(data[10:])
+	if !bytes.Equal(h.Sum(nil), expected[:]) {
+		t.Logf("Restored hash. Expected: %x, Got: %x", expected, h.Sum(nil))
+		t.Fail()
+	}
+
+	if err = checkStreamChecksum(hex.EncodeToString(expected[:]), h); err != nil {
+		t.Logf("Matching checksum. Got: %v", err)
+		t.Fail()
+	}
+	for _, sum := range []string{hex.EncodeToString(expected[:4]), "not hex"} {
+		if err = checkStreamChecksum(sum, h); err != ErrStreamChecksum {
+			t.Logf("Checksum %s. Expected: %v, Got: %v", sum, ErrStreamChecksum, err)
+			t.Fail()
+		}
+	}
+
+	if _, err = restoreStreamHash([]byte("junk")); err == nil {
+		t.Logf("Bad hash state. Expected an error")
+		t.Fail()
+	}
+}
+
+func TestMessageStream(t *testing.T) {
+	qm := NewFakeQueueManager("QM1")
+	qm.DefineQueue("STREAM")
+	od := NewMQOD()
+	od.ObjectName = "STREAM"
+	q, err := qm.Open(od, MQOO_OUTPUT|MQOO_INPUT_AS_Q_DEF)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+
+	// Writes of any size are put as full chunks, with the rest sent by Close
+	w, err := NewMessageWriter(qm, q, NewMQMD())
+	if err != nil {
+		t.Fatalf("NewMessageWriter failed: %v", err)
+	}
+	w.ChunkSize = 1000
+	for _, piece := range [][]byte{data[:700], data[700:1400], data[1400:]} {
+		if n, err := w.Write(piece); err != nil || n != len(piece) {
+			t.Fatalf("Write failed: %d %v", n, err)
+		}
+	}
+	if pos := w.Position(); qm.Depth("STREAM") != 2 || pos.NextSeq != 3 || pos.Offset != 2000 {
+		t.Logf("Before Close. Expected 2 messages, NextSeq 3 and Offset 2000. Got: %d, %d and %d", qm.Depth("STREAM"), pos.NextSeq, pos.Offset)
+		t.Fail()
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if qm.Depth("STREAM") != 3 {
+		t.Logf("After Close. Expected 3 messages, Got: %d", qm.Depth("STREAM"))
+		t.Fail()
+	}
+	if !bytes.Equal(w.hash.Sum(nil), sum[:]) {
+		t.Logf("Writer checksum. Expected: %x, Got: %x", sum, w.hash.Sum(nil))
+		t.Fail()
+	}
+	if _, err = w.Write(data); err != io.ErrClosedPipe {
+		t.Logf("Write after Close. Expected: %v, Got: %v", io.ErrClosedPipe, err)
+		t.Fail()
+	}
+
+	// A small buffer means the first get of each chunk is truncated, and has to be retried
+	r, err := NewMessageReader(qm, q, 0)
+	if err != nil {
+		t.Fatalf("NewMessageReader failed: %v", err)
+	}
+	r.buf = make([]byte, 0, 10)
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Logf("Read stream. Got: %d bytes, err %v", len(got), err)
+		t.Fail()
+	}
+	if !bytes.Equal(r.hash.Sum(nil), sum[:]) {
+		t.Logf("Reader checksum. Expected: %x, Got: %x", sum, r.hash.Sum(nil))
+		t.Fail()
+	}
+	r.Close()
+
+	// A writer and a reader restarted from their saved positions continue the same stream,
+	// and the checksum covers all of it
+	w, _ = NewMessageWriter(qm, q, NewMQMD())
+	w.ChunkSize = 1000
+	w.Write(data[:1500])
+	pos := w.Position()
+	w, err = ResumeMessageWriter(qm, q, NewMQMD(), &pos)
+	if err != nil {
+		t.Fatalf("ResumeMessageWriter failed: %v", err)
+	}
+	w.ChunkSize = 1000
+	w.Write(data[pos.Offset:])
+	w.Close()
+	if !bytes.Equal(w.hash.Sum(nil), sum[:]) {
+		t.Logf("Resumed writer checksum. Expected: %x, Got: %x", sum, w.hash.Sum(nil))
+		t.Fail()
+	}
+
+	r, _ = NewMessageReader(qm, q, 0)
+	first := make([]byte, 1000)
+	if _, err = io.ReadFull(r, first); err != nil || !bytes.Equal(first, data[:1000]) {
+		t.Logf("First chunk. Got: %v", err)
+		t.Fail()
+	}
+	pos = r.Position()
+	r, err = ResumeMessageReader(qm, q, &pos, 0)
+	if err != nil {
+		t.Fatalf("ResumeMessageReader failed: %v", err)
+	}
+	got, err = io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data[1000:]) {
+		t.Logf("Resumed read. Got: %d bytes, err %v", len(got), err)
+		t.Fail()
+	}
+	if !bytes.Equal(r.hash.Sum(nil), sum[:]) {
+		t.Logf("Resumed reader checksum. Expected: %x, Got: %x", sum, r.hash.Sum(nil))
+		t.Fail()
+	}
+
+	// Chunks from two streams put at the same time are kept apart by their GroupId
+	wa, _ := NewMessageWriter(qm, q, NewMQMD())
+	wb, _ := NewMessageWriter(qm, q, NewMQMD())
+	wa.ChunkSize = 4
+	wb.ChunkSize = 4
+	wa.Write([]byte("aaaa"))
+	wb.Write([]byte("bbbbBBBB"))
+	wa.Write([]byte("AAAA"))
+	wb.Close()
+	wa.Close()
+	for _, expected := range []string{"aaaaAAAA", "bbbbBBBB"} {
+		r, _ = NewMessageReader(qm, q, 0)
+		got, err = io.ReadAll(r)
+		if err != nil || string(got) != expected {
+			t.Logf("Interleaved streams. Expected: %s, Got: %s, err %v", expected, got, err)
+			t.Fail()
+		}
+	}
+	if qm.Depth("STREAM") != 0 {
+		t.Logf("Messages left on the queue: %d", qm.Depth("STREAM"))
+		t.Fail()
+	}
+
+	r, _ = NewMessageReader(qm, q, 0)
+	if _, err = r.Read(first); err == nil || err.(*MQReturn).MQRC != MQRC_NO_MSG_AVAILABLE {
+		t.Logf("Empty queue. Expected 2033, Got: %v", err)
+		t.Fail()
+	}
+}
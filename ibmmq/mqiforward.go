@@ -0,0 +1,79 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has a helper for applications such as routers and bridges, which receive a
+message and send it on to another queue without changing it.
+
+To keep the original context (the UserIdentifier, PutApplName, PutDateTime and so on), the
+source queue must have been opened with MQOO_SAVE_ALL_CONTEXT and the application needs
+authority to pass all context to the target queue. To keep the message properties,
+the message must have been got with a message handle in the MQGMO.
+*/
+
+/*
+Forward puts a message that was retrieved from this object to another queue. The MQMD and MQGMO
+are the ones used on the Get. If the message was got under syncpoint, then it is
+forwarded under syncpoint too, so the Get and the Put can be committed together.
+
+The message is first put with MQPMO_PASS_ALL_CONTEXT. If that is not permitted, because the
+application is not authorised or the source queue was not opened to save context, the
+message is put again with the application's default context. The boolean return says whether
+the original context was kept.
+*/
+func (object *MQObject) Forward(gomd *MQMD, gogmo *MQGMO, buffer []byte, targetQName string, targetQMgrName string) (bool, error) {
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = targetQName
+	mqod.ObjectQMgrName = targetQMgrName
+
+	lmd := *gomd
+
+	pmo := NewMQPMO()
+	pmo.OriginalMsgHandle = gogmo.MsgHandle
+	if gogmo.Options&MQGMO_SYNCPOINT != 0 {
+		pmo.Options = MQPMO_SYNCPOINT
+	} else {
+		pmo.Options = MQPMO_NO_SYNCPOINT
+	}
+	pmo.Options |= MQPMO_FAIL_IF_QUIESCING
+
+	ctxpmo := *pmo
+	ctxpmo.Options |= MQPMO_PASS_ALL_CONTEXT
+	ctxpmo.Context = object
+
+	err := object.qMgr.Put1(mqod, &lmd, &ctxpmo, buffer)
+	if err == nil {
+		return true, nil
+	}
+
+	if mqreturn, ok := err.(*MQReturn); ok {
+		switch mqreturn.MQRC {
+		case MQRC_NOT_AUTHORIZED, MQRC_CONTEXT_HANDLE_ERROR, MQRC_CONTEXT_NOT_AVAILABLE:
+			lmd = *gomd
+			err = object.qMgr.Put1(mqod, &lmd, pmo, buffer)
+		}
+	}
+	return false, err
+}
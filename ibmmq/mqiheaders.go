@@ -0,0 +1,377 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file walks the chain of MQ headers at the front of a message. Each header contains
+the Format, Encoding and CodedCharSetId of whatever follows it, in the same way that the
+MQMD describes the start of the message. So a message on a dead letter queue might be
+
+	MQMD(MQDEAD) -> MQDLH(MQXMIT) -> MQXQH(MQHRF2) -> MQRFH2(MQSTR) -> body
+
+The MQDLH, MQXQH, MQRFH2 and MQRMH headers are returned as their own types. Other
+formats beginning "MQH" are assumed to have the common layout shared by most of the
+MQ headers, and are returned as MQGenericHeader so they can at least be skipped.
+The walk stops at the first format that is not recognised as a header.
+
+Integers in each header are read using the encoding given by the previous structure.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// Guard against a corrupt message that appears to loop
+const maxHeaderChain = 32
+
+/*
+HeaderInfo describes one header found by WalkHeaders
+*/
+type HeaderInfo struct {
+	Format string      // The format name that described this header
+	Offset int         // Where the header starts in the buffer
+	Length int         // The length of the header
+	Header interface{} // One of *MQDLH, *MQXQH, *MQRFH2, *MQRMH or *MQGenericHeader
+}
+
+/*
+BodyInfo describes the data that follows the last header
+*/
+type BodyInfo struct {
+	Offset         int
+	Format         string
+	Encoding       int32
+	CodedCharSetId int32
+}
+
+/*
+MQXQH is the Transmission Queue Header, found on messages in a transmission queue.
+Only the version 1 fields of the embedded MQMD are present.
+*/
+type MQXQH struct {
+	RemoteQName    string
+	RemoteQMgrName string
+	MsgDesc        *MQMD
+}
+
+/*
+MQRFH2 is the Rules and Formatting Header version 2, used to carry message
+properties and JMS information. Each folder in the NameValueData is an XML-like
+string such as "<usr><color>blue</color></usr>".
+*/
+type MQRFH2 struct {
+	Encoding       int32
+	CodedCharSetId int32
+	Format         string
+	Flags          int32
+	NameValueCCSID int32
+	NameValueData  []string
+	strucLength    int
+}
+
+/*
+MQGenericHeader contains the fields that are common to most of the MQ headers
+*/
+type MQGenericHeader struct {
+	StrucId        string
+	Version        int32
+	StrucLength    int32
+	Encoding       int32
+	CodedCharSetId int32
+	Format         string
+}
+
+/*
+WalkHeaders follows the chain of headers at the start of the message. It returns the
+headers in order, and a description of the real message body.
+*/
+func WalkHeaders(md *MQMD, buf []byte) ([]HeaderInfo, *BodyInfo, error) {
+	headers := make([]HeaderInfo, 0)
+	body := &BodyInfo{Offset: 0, Format: md.Format, Encoding: md.Encoding, CodedCharSetId: md.CodedCharSetId}
+
+	for i := 0; i < maxHeaderChain; i++ {
+		var hdr interface{}
+		var l int
+		var err error
+
+		format := strings.TrimSpace(body.Format)
+		data := buf[body.Offset:]
+		order := encodingByteOrder(body.Encoding)
+
+		next := *body
+		switch format {
+		case MQFMT_DEAD_LETTER_HEADER:
+			if len(data) < int(MQDLH_CURRENT_LENGTH) {
+				return headers, body, headerError()
+			}
+			var dlh *MQDLH
+			dlh, l, err = parseDLH(data, order)
+			if err == nil {
+				hdr = dlh
+				next.Format, next.Encoding, next.CodedCharSetId = dlh.Format, dlh.Encoding, dlh.CodedCharSetId
+			}
+		case MQFMT_XMIT_Q_HEADER:
+			var xqh *MQXQH
+			xqh, l, err = parseXQH(data, order)
+			if err == nil {
+				hdr = xqh
+				next.Format, next.Encoding, next.CodedCharSetId = xqh.MsgDesc.Format, xqh.MsgDesc.Encoding, xqh.MsgDesc.CodedCharSetId
+			}
+		case MQFMT_RF_HEADER_2:
+			var rfh2 *MQRFH2
+			rfh2, l, err = parseRFH2(data, order)
+			if err == nil {
+				hdr = rfh2
+				next.Format, next.Encoding, next.CodedCharSetId = rfh2.Format, rfh2.Encoding, rfh2.CodedCharSetId
+			}
+		case MQFMT_REF_MSG_HEADER:
+			var rmh *MQRMH
+			rmh, l, err = parseRMH(data, order)
+			if err == nil {
+				hdr = rmh
+				next.Format, next.Encoding, next.CodedCharSetId = rmh.Format, rmh.Encoding, rmh.CodedCharSetId
+			}
+		default:
+			if !strings.HasPrefix(format, "MQH") {
+				return headers, body, nil
+			}
+			var gh *MQGenericHeader
+			gh, l, err = parseGenericHeader(data, order)
+			if err == nil {
+				hdr = gh
+				next.Format, next.Encoding, next.CodedCharSetId = gh.Format, gh.Encoding, gh.CodedCharSetId
+			}
+		}
+
+		if err != nil {
+			return headers, body, err
+		}
+		if l <= 0 || l > len(data) {
+			return headers, body, headerError()
+		}
+
+		// A CCSID of MQCCSI_INHERIT means that the following data uses the same
+		// CCSID as this header
+		if next.CodedCharSetId == MQCCSI_INHERIT {
+			next.CodedCharSetId = body.CodedCharSetId
+		}
+
+		headers = append(headers, HeaderInfo{Format: format, Offset: body.Offset, Length: l, Header: hdr})
+		next.Offset = body.Offset + l
+		body = &next
+	}
+
+	return headers, body, headerError()
+}
+
+func headerError() error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_FORMAT_ERROR, verb: "WALKHEADERS"}
+}
+
+// Choose the byte order for integers based on an MQENC value. If it is not given,
+// the native order is used.
+func encodingByteOrder(encoding int32) binary.ByteOrder {
+	switch encoding & MQENC_INTEGER_MASK {
+	case MQENC_INTEGER_REVERSED:
+		return binary.LittleEndian
+	case MQENC_INTEGER_NORMAL:
+		return binary.BigEndian
+	}
+	return endian
+}
+
+func parseXQH(buf []byte, order binary.ByteOrder) (*MQXQH, int, error) {
+	var version int32
+
+	if len(buf) < int(MQXQH_CURRENT_LENGTH) {
+		return nil, 0, headerError()
+	}
+
+	xqh := new(MQXQH)
+	md := NewMQMD()
+	xqh.MsgDesc = md
+
+	r := bytes.NewBuffer(buf)
+	_ = readStringFromFixedBuffer(r, 4) // StrucId
+	binary.Read(r, order, &version)
+	xqh.RemoteQName = readStringFromFixedBuffer(r, MQ_Q_NAME_LENGTH)
+	xqh.RemoteQMgrName = readStringFromFixedBuffer(r, MQ_Q_MGR_NAME_LENGTH)
+
+	_ = readStringFromFixedBuffer(r, 4) // MD StrucId
+	binary.Read(r, order, &md.Version)
+	binary.Read(r, order, &md.Report)
+	binary.Read(r, order, &md.MsgType)
+	binary.Read(r, order, &md.Expiry)
+	binary.Read(r, order, &md.Feedback)
+	binary.Read(r, order, &md.Encoding)
+	binary.Read(r, order, &md.CodedCharSetId)
+	md.Format = readStringFromFixedBuffer(r, MQ_FORMAT_LENGTH)
+	binary.Read(r, order, &md.Priority)
+	binary.Read(r, order, &md.Persistence)
+	binary.Read(r, order, md.MsgId)
+	binary.Read(r, order, md.CorrelId)
+	binary.Read(r, order, &md.BackoutCount)
+	md.ReplyToQ = readStringFromFixedBuffer(r, MQ_Q_NAME_LENGTH)
+	md.ReplyToQMgr = readStringFromFixedBuffer(r, MQ_Q_MGR_NAME_LENGTH)
+	md.UserIdentifier = readStringFromFixedBuffer(r, MQ_USER_ID_LENGTH)
+	binary.Read(r, order, md.AccountingToken)
+	md.ApplIdentityData = readStringFromFixedBuffer(r, MQ_APPL_IDENTITY_DATA_LENGTH)
+	binary.Read(r, order, &md.PutApplType)
+	md.PutApplName = readStringFromFixedBuffer(r, MQ_PUT_APPL_NAME_LENGTH)
+	md.PutDate = readStringFromFixedBuffer(r, MQ_PUT_DATE_LENGTH)
+	md.PutTime = readStringFromFixedBuffer(r, MQ_PUT_TIME_LENGTH)
+	md.PutDateTime = createGoDateTime(md.PutDate, md.PutTime)
+	md.ApplOriginData = readStringFromFixedBuffer(r, MQ_APPL_ORIGIN_DATA_LENGTH)
+
+	return xqh, int(MQXQH_CURRENT_LENGTH), nil
+}
+
+func parseRFH2(buf []byte, order binary.ByteOrder) (*MQRFH2, int, error) {
+	var version int32
+	var strucLength int32
+
+	if len(buf) < int(MQRFH_STRUC_LENGTH_FIXED_2) {
+		return nil, 0, headerError()
+	}
+
+	rfh2 := new(MQRFH2)
+
+	r := bytes.NewBuffer(buf)
+	_ = readStringFromFixedBuffer(r, 4) // StrucId
+	binary.Read(r, order, &version)
+	binary.Read(r, order, &strucLength)
+	binary.Read(r, order, &rfh2.Encoding)
+	binary.Read(r, order, &rfh2.CodedCharSetId)
+	rfh2.Format = readStringFromFixedBuffer(r, MQ_FORMAT_LENGTH)
+	binary.Read(r, order, &rfh2.Flags)
+	binary.Read(r, order, &rfh2.NameValueCCSID)
+
+	if strucLength < MQRFH_STRUC_LENGTH_FIXED_2 || int(strucLength) > len(buf) {
+		return nil, 0, headerError()
+	}
+	rfh2.strucLength = int(strucLength)
+
+	// Each folder is preceded by its length, and padded with spaces or nulls
+	offset := int(MQRFH_STRUC_LENGTH_FIXED_2)
+	for offset+4 <= rfh2.strucLength {
+		l := int(int32(order.Uint32(buf[offset:])))
+		offset += 4
+		if l < 0 || offset+l > rfh2.strucLength {
+			return nil, 0, headerError()
+		}
+		folder := strings.TrimRight(string(buf[offset:offset+l]), " \x00")
+		rfh2.NameValueData = append(rfh2.NameValueData, folder)
+		offset += l
+	}
+
+	return rfh2, rfh2.strucLength, nil
+}
+
+/*
+NewMQRFH2 creates an RFH2 to go in front of a message described by the MQMD. The MQMD
+is updated to say that the message now starts with the RFH2. Folders are added to the
+NameValueData before calling Bytes.
+*/
+func NewMQRFH2(md *MQMD) *MQRFH2 {
+	rfh2 := new(MQRFH2)
+	rfh2.CodedCharSetId = MQCCSI_INHERIT
+	rfh2.Format = MQFMT_NONE
+	rfh2.Flags = MQRFH_NONE
+	rfh2.NameValueCCSID = 1208
+
+	if md != nil {
+		rfh2.Encoding = md.Encoding
+		if md.CodedCharSetId != MQCCSI_DEFAULT {
+			rfh2.CodedCharSetId = md.CodedCharSetId
+		}
+		rfh2.Format = md.Format
+
+		md.Format = MQFMT_RF_HEADER_2
+		md.CodedCharSetId = MQCCSI_Q_MGR
+	}
+	return rfh2
+}
+
+/*
+Bytes returns the RFH2 ready to be put in front of the message body. Each folder is
+padded with spaces to a multiple of 4 bytes.
+*/
+func (rfh2 *MQRFH2) Bytes() []byte {
+	l := int(MQRFH_STRUC_LENGTH_FIXED_2)
+	for _, folder := range rfh2.NameValueData {
+		l += 4 + (len(folder)+3)/4*4
+	}
+	buf := make([]byte, l)
+	offset := 0
+
+	copy(buf[offset:], "RFH ")
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(MQRFH_VERSION_2))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(l))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rfh2.Encoding))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rfh2.CodedCharSetId))
+	offset += 4
+	copy(buf[offset:], (rfh2.Format + space8)[0:8])
+	offset += int(MQ_FORMAT_LENGTH)
+	endian.PutUint32(buf[offset:], uint32(rfh2.Flags))
+	offset += 4
+	endian.PutUint32(buf[offset:], uint32(rfh2.NameValueCCSID))
+	offset += 4
+
+	for _, folder := range rfh2.NameValueData {
+		padded := (len(folder) + 3) / 4 * 4
+		endian.PutUint32(buf[offset:], uint32(padded))
+		offset += 4
+		copy(buf[offset:offset+padded], folder+"   ")
+		offset += padded
+	}
+
+	rfh2.strucLength = l
+	return buf
+}
+
+func parseGenericHeader(buf []byte, order binary.ByteOrder) (*MQGenericHeader, int, error) {
+	// StrucId, Version, StrucLength, Encoding, CodedCharSetId, Format
+	if len(buf) < 28 {
+		return nil, 0, headerError()
+	}
+
+	gh := new(MQGenericHeader)
+	r := bytes.NewBuffer(buf)
+	gh.StrucId = readStringFromFixedBuffer(r, 4)
+	binary.Read(r, order, &gh.Version)
+	binary.Read(r, order, &gh.StrucLength)
+	binary.Read(r, order, &gh.Encoding)
+	binary.Read(r, order, &gh.CodedCharSetId)
+	gh.Format = readStringFromFixedBuffer(r, MQ_FORMAT_LENGTH)
+
+	if gh.StrucLength < 28 || int(gh.StrucLength) > len(buf) {
+		return nil, 0, headerError()
+	}
+	return gh, int(gh.StrucLength), nil
+}
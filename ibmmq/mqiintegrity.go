@@ -0,0 +1,263 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has two helpers for applications that need to be sure that each message is
+processed once, and unchanged:
+
+  - A digest of the message body can be sent in a message property by the producer,
+    and checked by the consumer. The property value is the algorithm name and the
+    hex digest, such as "sha256:9f86d08...", so the consumer does not need to know
+    which algorithm was used.
+  - A DedupCache remembers the messages that have been processed recently, keyed on
+    the MsgId or on an application property, so that a message delivered again after
+    a backout or a reconnection can be recognised and skipped.
+
+The cache is only in memory, so it does not help across a restart of the consumer, and
+it cannot replace a unit of work. It is meant to make the duplicates that MQ can deliver
+after a failure harmless, not to give exactly-once delivery.
+*/
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The digest algorithms that can be used
+const (
+	DIGEST_SHA256 = "sha256"
+	DIGEST_SHA512 = "sha512"
+)
+
+/*
+DefaultDigestProperty is the message property used for the digest if no other name is given
+*/
+const DefaultDigestProperty = "mqgo.digest"
+
+/*
+ErrDigestMismatch is returned by VerifyDigest when the message body does not match the
+digest in the property. ErrDigestMissing is returned when the message has no digest, and
+ErrDigestAlgorithm when the algorithm is not one of the DIGEST_* values.
+*/
+var ErrDigestMismatch = errors.New("message digest does not match")
+var ErrDigestMissing = errors.New("message has no digest")
+var ErrDigestAlgorithm = errors.New("digest algorithm is not known")
+
+func newDigestHash(alg string) hash.Hash {
+	switch alg {
+	case DIGEST_SHA256:
+		return sha256.New()
+	case DIGEST_SHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+/*
+ComputeDigest returns the digest of the data in the form used for the property value.
+An empty algorithm means SHA-256.
+*/
+func ComputeDigest(alg string, data []byte) (string, error) {
+	if alg == "" {
+		alg = DIGEST_SHA256
+	}
+	h := newDigestHash(alg)
+	if h == nil {
+		return "", ErrDigestAlgorithm
+	}
+	h.Write(data)
+	return alg + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/*
+SetDigest sets a property containing the digest of the message body, so that it is sent
+with the message when this handle is used as the OriginalMsgHandle in the MQPMO. An empty
+name means DefaultDigestProperty.
+*/
+func (handle *MQMessageHandle) SetDigest(name string, alg string, data []byte) error {
+	if name == "" {
+		name = DefaultDigestProperty
+	}
+	sum, err := ComputeDigest(alg, data)
+	if err != nil {
+		return err
+	}
+	return handle.SetMP(NewMQSMPO(), name, NewMQPD(), sum)
+}
+
+/*
+VerifyDigest checks the message body against the digest in a property of a message that
+was retrieved with MQGMO_PROPERTIES_IN_HANDLE using this message handle. An empty name
+means DefaultDigestProperty.
+*/
+func (handle *MQMessageHandle) VerifyDigest(name string, data []byte) error {
+	if name == "" {
+		name = DefaultDigestProperty
+	}
+	_, v, err := handle.InqMP(NewMQIMPO(), NewMQPD(), name)
+	if err != nil {
+		if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_PROPERTY_NOT_AVAILABLE {
+			return ErrDigestMissing
+		}
+		return err
+	}
+	sum, ok := v.(string)
+	if !ok {
+		return ErrDigestMissing
+	}
+	return verifyDigestValue(sum, data)
+}
+
+func verifyDigestValue(sum string, data []byte) error {
+	alg := sum
+	if i := strings.Index(sum, ":"); i >= 0 {
+		alg = sum[:i]
+	}
+	expected, err := ComputeDigest(alg, data)
+	if err != nil {
+		return ErrDigestMismatch
+	}
+	if !strings.EqualFold(expected, sum) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+/*
+DedupCache remembers the keys of messages that have been processed for a fixed time
+*/
+type DedupCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mutex   sync.Mutex
+	entries map[string]time.Time // Expiry time for each key
+	order   []dedupEntry         // In the order the keys were added, for expiring them
+}
+
+type dedupEntry struct {
+	key    string
+	expiry time.Time
+}
+
+/*
+NewDedupCache creates a cache that remembers each key for the ttl. A maxSize of 0 means
+there is no limit; otherwise the oldest keys are forgotten early to make room.
+*/
+func NewDedupCache(ttl time.Duration, maxSize int) *DedupCache {
+	return &DedupCache{ttl: ttl, maxSize: maxSize, entries: make(map[string]time.Time)}
+}
+
+/*
+DedupKey returns the key to use for a message: the value of the named property if one is
+given, or otherwise the MsgId. The property is read from the handle used for the MQGET.
+*/
+func DedupKey(md *MQMD, handle *MQMessageHandle, property string) (string, error) {
+	if property == "" {
+		return hex.EncodeToString(md.MsgId), nil
+	}
+	_, v, err := handle.InqMP(NewMQIMPO(), NewMQPD(), property)
+	if err != nil {
+		return "", err
+	}
+	switch value := v.(type) {
+	case []byte:
+		return hex.EncodeToString(value), nil
+	case string:
+		return value, nil
+	}
+	return "", &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_PROPERTY_TYPE_ERROR, verb: "MQINQMP"}
+}
+
+/*
+Seen records the key and returns true if it was already in the cache, meaning that the
+message is a duplicate and should be skipped
+*/
+func (c *DedupCache) Seen(key string) bool {
+	return c.seenAt(key, time.Now())
+}
+
+func (c *DedupCache) seenAt(key string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expire(now)
+	if _, ok := c.entries[key]; ok {
+		return true
+	}
+
+	if c.maxSize > 0 {
+		for len(c.entries) >= c.maxSize && len(c.order) > 0 {
+			c.removeOldest()
+		}
+	}
+	expiry := now.Add(c.ttl)
+	c.entries[key] = expiry
+	c.order = append(c.order, dedupEntry{key: key, expiry: expiry})
+	return false
+}
+
+/*
+Forget removes a key, so that the message is processed again if it is redelivered. This
+is for a message whose processing failed and was backed out.
+*/
+func (c *DedupCache) Forget(key string) {
+	c.mutex.Lock()
+	delete(c.entries, key)
+	c.mutex.Unlock()
+}
+
+/*
+Len returns how many keys are in the cache, including any that have expired but have
+not yet been removed
+*/
+func (c *DedupCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// Every key has the same ttl, so the ones at the front of the list expire first
+func (c *DedupCache) expire(now time.Time) {
+	for len(c.order) > 0 && !now.Before(c.order[0].expiry) {
+		c.removeOldest()
+	}
+}
+
+// A key that was forgotten and then added again has a later entry in the list,
+// and the map is only changed when the expiry times match
+func (c *DedupCache) removeOldest() {
+	e := c.order[0]
+	c.order[0] = dedupEntry{}
+	c.order = c.order[1:]
+	if expiry, ok := c.entries[e.key]; ok && expiry.Equal(e.expiry) {
+		delete(c.entries, e.key)
+	}
+}
@@ -0,0 +1,339 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file helps Go programs exchange messages with JMS applications using the MQ
+classes for JMS. A JMS message carries its type in the Msd field of the "mcd" folder
+of an MQRFH2 header:
+
+  jms_text     A TextMessage, with the body as a string
+  jms_bytes    A BytesMessage, with the body as it is
+  jms_map      A MapMessage, with the body as XML such as
+                 <map><elt name="count" dt="i4">3</elt><elt name="colour">blue</elt></map>
+  jms_stream   A StreamMessage, with the body as XML such as
+                 <stream><elt dt="boolean">true</elt><elt>text</elt></stream>
+  jms_object   An ObjectMessage, with the body as a serialized Java object
+
+A message with no RFH2 is treated by JMS as a TextMessage if its format is MQSTR, and
+otherwise as a BytesMessage, and ParseJMSMessage does the same. The RFH2 is only seen by
+an MQI application if the message is read with MQGMO_PROPERTIES_FORCE_MQRFH2 or from a
+queue with PROPCTL(FORCE); otherwise the queue manager turns the folders into message
+properties and the type is lost.
+
+The "dt" attribute in the XML gives the type of each element, and is mapped to Go types:
+
+  (none)    string
+  boolean   bool
+  i1 i2     int8 int16
+  i4 i8     int32 int64
+  r4 r8     float32 float64
+  bin.hex   []byte
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The values of the mcd.Msd field for each JMS message type
+const (
+	JMS_NONE   = "jms_none"
+	JMS_TEXT   = "jms_text"
+	JMS_BYTES  = "jms_bytes"
+	JMS_MAP    = "jms_map"
+	JMS_STREAM = "jms_stream"
+	JMS_OBJECT = "jms_object"
+)
+
+/*
+JMSMessage is the body of a JMS message, and the fields from the RFH2 that say
+how to interpret it. Only the field that matches the Type is used.
+*/
+type JMSMessage struct {
+	Type        string                 // One of the JMS_* values
+	Destination string                 // The jms.Dst field, such as "queue:///APP.Q"
+	Text        string                 // For JMS_TEXT
+	Bytes       []byte                 // For JMS_BYTES and JMS_OBJECT
+	Map         map[string]interface{} // For JMS_MAP
+	Stream      []interface{}          // For JMS_STREAM
+}
+
+// The layout of the body of a MapMessage or StreamMessage
+type jmsElement struct {
+	Name  string `xml:"name,attr"`
+	Dt    string `xml:"dt,attr"`
+	Value string `xml:",chardata"`
+}
+
+type jmsElements struct {
+	XMLName  xml.Name
+	Elements []jmsElement `xml:"elt"`
+}
+
+// Used to pick out single fields from an RFH2 folder
+type rfh2Folder struct {
+	XMLName xml.Name
+	Fields  []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+/*
+ParseJMSMessage reads a message that may have been put by a JMS application. The body
+should already have been converted to the local CCSID, for example by using
+MQGMO_CONVERT. Any Bytes slice refers to the original buffer.
+*/
+func ParseJMSMessage(md *MQMD, buf []byte) (*JMSMessage, error) {
+	headers, body, err := WalkHeaders(md, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &JMSMessage{}
+	for _, h := range headers {
+		if rfh2, ok := h.Header.(*MQRFH2); ok {
+			for _, folder := range rfh2.NameValueData {
+				if v, ok := rfh2FolderField(folder, "mcd", "Msd"); ok {
+					m.Type = v
+				}
+				if v, ok := rfh2FolderField(folder, "jms", "Dst"); ok {
+					m.Destination = v
+				}
+			}
+		}
+	}
+
+	if m.Type == "" {
+		if strings.TrimSpace(body.Format) == strings.TrimSpace(MQFMT_STRING) {
+			m.Type = JMS_TEXT
+		} else {
+			m.Type = JMS_BYTES
+		}
+	}
+
+	data := buf[body.Offset:]
+	switch m.Type {
+	case JMS_TEXT:
+		m.Text = string(data)
+	case JMS_MAP:
+		elements, err := decodeJMSElements(data, "map")
+		if err != nil {
+			return nil, err
+		}
+		m.Map = make(map[string]interface{})
+		for _, e := range elements {
+			if m.Map[e.Name], err = jmsValue(e); err != nil {
+				return nil, err
+			}
+		}
+	case JMS_STREAM:
+		elements, err := decodeJMSElements(data, "stream")
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range elements {
+			v, err := jmsValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m.Stream = append(m.Stream, v)
+		}
+	default:
+		m.Bytes = data
+	}
+	return m, nil
+}
+
+/*
+Build returns the RFH2 and body for the JMS message, and updates the MQMD so that
+a JMS application sees the message as the right type.
+*/
+func (m *JMSMessage) Build(md *MQMD) ([]byte, error) {
+	var body []byte
+	var err error
+
+	msgType := m.Type
+	if msgType == "" {
+		msgType = JMS_TEXT
+	}
+
+	md.Format = MQFMT_STRING
+	md.CodedCharSetId = 1208
+	switch msgType {
+	case JMS_TEXT:
+		body = []byte(m.Text)
+	case JMS_MAP:
+		names := make([]string, 0, len(m.Map))
+		for name := range m.Map {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]interface{}, len(names))
+		for i, name := range names {
+			values[i] = m.Map[name]
+		}
+		body, err = encodeJMSElements("map", names, values)
+	case JMS_STREAM:
+		body, err = encodeJMSElements("stream", nil, m.Stream)
+	default:
+		md.Format = MQFMT_NONE
+		body = m.Bytes
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rfh2 := NewMQRFH2(md)
+	rfh2.NameValueData = append(rfh2.NameValueData, "<mcd><Msd>"+msgType+"</Msd></mcd>")
+	if m.Destination != "" {
+		rfh2.NameValueData = append(rfh2.NameValueData, "<jms><Dst>"+xmlEscape(m.Destination)+"</Dst></jms>")
+	}
+
+	return append(rfh2.Bytes(), body...), nil
+}
+
+// Returns the value of a field if the folder has the given name
+func rfh2FolderField(folder string, folderName string, fieldName string) (string, bool) {
+	if !strings.HasPrefix(folder, "<"+folderName+">") && !strings.HasPrefix(folder, "<"+folderName+" ") {
+		return "", false
+	}
+	var f rfh2Folder
+	if err := xml.Unmarshal([]byte(folder), &f); err != nil {
+		return "", false
+	}
+	for _, field := range f.Fields {
+		if field.XMLName.Local == fieldName {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+func jmsError(rc int32) error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: rc, verb: "JMS"}
+}
+
+func decodeJMSElements(data []byte, root string) ([]jmsElement, error) {
+	var elements jmsElements
+	if err := xml.Unmarshal(data, &elements); err != nil || elements.XMLName.Local != root {
+		return nil, jmsError(MQRC_FORMAT_ERROR)
+	}
+	return elements.Elements, nil
+}
+
+// Names are only written for elements of a map
+func encodeJMSElements(root string, names []string, values []interface{}) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString("<" + root + ">")
+	for i, v := range values {
+		var dt, s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case bool:
+			dt, s = "boolean", strconv.FormatBool(v)
+		case int8:
+			dt, s = "i1", strconv.FormatInt(int64(v), 10)
+		case int16:
+			dt, s = "i2", strconv.FormatInt(int64(v), 10)
+		case int32:
+			dt, s = "i4", strconv.FormatInt(int64(v), 10)
+		case int64:
+			dt, s = "i8", strconv.FormatInt(v, 10)
+		case int:
+			dt, s = "i8", strconv.FormatInt(int64(v), 10)
+		case float32:
+			dt, s = "r4", strconv.FormatFloat(float64(v), 'g', -1, 32)
+		case float64:
+			dt, s = "r8", strconv.FormatFloat(v, 'g', -1, 64)
+		case []byte:
+			dt, s = "bin.hex", hex.EncodeToString(v)
+		default:
+			return nil, jmsError(MQRC_PROPERTY_TYPE_ERROR)
+		}
+
+		b.WriteString("<elt")
+		if names != nil {
+			b.WriteString(` name="` + xmlEscape(names[i]) + `"`)
+		}
+		if dt != "" {
+			b.WriteString(` dt="` + dt + `"`)
+		}
+		b.WriteString(">" + xmlEscape(s) + "</elt>")
+	}
+	b.WriteString("</" + root + ">")
+	return b.Bytes(), nil
+}
+
+func jmsValue(e jmsElement) (interface{}, error) {
+	var v interface{}
+	var err error
+
+	switch e.Dt {
+	case "", "string", "char":
+		return e.Value, nil
+	case "boolean":
+		v, err = strconv.ParseBool(e.Value)
+	case "i1":
+		var n int64
+		n, err = strconv.ParseInt(e.Value, 10, 8)
+		v = int8(n)
+	case "i2":
+		var n int64
+		n, err = strconv.ParseInt(e.Value, 10, 16)
+		v = int16(n)
+	case "i4":
+		var n int64
+		n, err = strconv.ParseInt(e.Value, 10, 32)
+		v = int32(n)
+	case "i8":
+		v, err = strconv.ParseInt(e.Value, 10, 64)
+	case "r4":
+		var f float64
+		f, err = strconv.ParseFloat(e.Value, 32)
+		v = float32(f)
+	case "r8":
+		v, err = strconv.ParseFloat(e.Value, 64)
+	case "bin.hex":
+		v, err = hex.DecodeString(e.Value)
+	default:
+		return nil, jmsError(MQRC_PROPERTY_TYPE_ERROR)
+	}
+	if err != nil {
+		return nil, jmsError(MQRC_PROP_NUMBER_FORMAT_ERROR)
+	}
+	return v, nil
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
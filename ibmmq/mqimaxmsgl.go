@@ -0,0 +1,173 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file finds the maximum message length (MAXMSGL) that applies when putting to
+a queue. A message has to fit within the limits of the queue manager, the queue and,
+for a client, the channel. When it does not, the MQPUT fails with 2030, 2031 or 2218
+and it is not always obvious which of the limits is involved.
+
+The queue and queue manager values come from MQINQ. The channel value needs a PCF
+command, so it is only inquired when a channel name is given. Limits that cannot be
+found - for example the queue limit for a remote queue - are left as 0, and are
+then ignored by the checks.
+*/
+
+import (
+	"fmt"
+)
+
+/*
+MsgLengthLimits holds the MAXMSGL values that apply to a queue
+*/
+type MsgLengthLimits struct {
+	QName   string
+	QMgr    int32
+	Queue   int32
+	Channel int32
+}
+
+/*
+MsgLengthError is returned by MsgLengthLimits.Check. It says which limit has been
+exceeded. The MQReturn that the queue manager would have given is available through
+errors.As or errors.Unwrap.
+*/
+type MsgLengthError struct {
+	Length int
+	Limit  int32
+	Object string // "queue", "queue manager" or "channel"
+	QName  string
+	mqrc   int32
+}
+
+func (e *MsgLengthError) Error() string {
+	return fmt.Sprintf("Message length %d is greater than the %s MAXMSGL of %d for queue %s", e.Length, e.Object, e.Limit, e.QName)
+}
+
+func (e *MsgLengthError) Unwrap() error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: e.mqrc, verb: "MQPUT"}
+}
+
+/*
+InqMaxMsgLength finds the limits for messages put to the queue. The channel
+name is optional, and is the SVRCONN channel that a client is using.
+*/
+func (x *MQQueueManager) InqMaxMsgLength(qName string, channelName string) (*MsgLengthLimits, error) {
+	l := &MsgLengthLimits{QName: qName}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q_MGR
+	qMgrObject, err := x.Open(mqod, MQOO_INQUIRE|MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return nil, err
+	}
+	values, err := qMgrObject.Inq([]int32{MQIA_MAX_MSG_LENGTH})
+	qMgrObject.Close(0)
+	if err != nil {
+		return nil, err
+	}
+	l.QMgr = values[MQIA_MAX_MSG_LENGTH].(int32)
+
+	// Only local and model queues have a MAXMSGL, so errors here are not fatal.
+	// Alias queues are resolved to the base queue.
+	mqod = NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	qObject, err := x.Open(mqod, MQOO_INQUIRE|MQOO_FAIL_IF_QUIESCING)
+	if err == nil {
+		values, err = qObject.Inq([]int32{MQIA_MAX_MSG_LENGTH})
+		qObject.Close(0)
+		if err == nil {
+			l.Queue = values[MQIA_MAX_MSG_LENGTH].(int32)
+		}
+	}
+
+	if channelName != "" {
+		l.Channel, err = x.inqChannelMaxMsgLength(channelName)
+		if err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+// Use a PCF command to find the MAXMSGL of a channel
+func (x *MQQueueManager) inqChannelMaxMsgLength(channelName string) (int32, error) {
+	params := []*PCFParameter{
+		{Type: MQCFT_STRING, Parameter: MQCACH_CHANNEL_NAME, String: []string{channelName}},
+		{Type: MQCFT_INTEGER_LIST, Parameter: MQIACF_CHANNEL_ATTRS, Int64Value: []int64{int64(MQIACH_MAX_MSG_LENGTH)}},
+	}
+	responses, err := x.pcfCommand("INQUIRE CHANNEL", MQCMD_INQUIRE_CHANNEL, params)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range responses {
+		_, offset := ReadPCFHeader(r)
+		for offset < len(r) {
+			p, n := ReadPCFParameter(r[offset:])
+			if n <= 0 {
+				break
+			}
+			offset += n
+			if p.Parameter == MQIACH_MAX_MSG_LENGTH && len(p.Int64Value) > 0 {
+				return int32(p.Int64Value[0]), nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+/*
+Max returns the largest message that can be put, which is the smallest of the
+known limits. It returns 0 if none of them are known.
+*/
+func (l *MsgLengthLimits) Max() int32 {
+	max := int32(0)
+	for _, v := range []int32{l.QMgr, l.Queue, l.Channel} {
+		if v > 0 && (max == 0 || v < max) {
+			max = v
+		}
+	}
+	return max
+}
+
+/*
+Check returns a MsgLengthError if a message of this length would be rejected.
+The limits are checked in the same order as the MQPUT would fail: channel, then
+queue manager, then queue.
+*/
+func (l *MsgLengthLimits) Check(length int) error {
+	if l.Channel > 0 && length > int(l.Channel) {
+		return &MsgLengthError{Length: length, Limit: l.Channel, Object: "channel", QName: l.QName, mqrc: MQRC_MSG_TOO_BIG_FOR_CHANNEL}
+	}
+	if l.QMgr > 0 && length > int(l.QMgr) {
+		return &MsgLengthError{Length: length, Limit: l.QMgr, Object: "queue manager", QName: l.QName, mqrc: MQRC_MSG_TOO_BIG_FOR_Q_MGR}
+	}
+	if l.Queue > 0 && length > int(l.Queue) {
+		return &MsgLengthError{Length: length, Limit: l.Queue, Object: "queue", QName: l.QName, mqrc: MQRC_MSG_TOO_BIG_FOR_Q}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has a cache of open queue handles. A service that sends replies to many
+different ReplyToQ names would otherwise either do an MQOPEN and MQCLOSE for every
+message, or use MQPUT1 which does the same work inside the queue manager. When the
+same queues are used repeatedly, keeping them open is much cheaper.
+
+Each handle is reference-counted while it is in use. When it is no longer in use, it
+stays open until it has been idle for the configured time, and is then closed. If the
+cache is full, the least recently used idle handle is closed to make room; handles that
+are in use are never closed by the cache.
+
+All handles are opened on the one connection, so the usual rules about sharing a
+connection between goroutines apply. See mqishare.go.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// Events reported to the ObjectCache hook
+const (
+	OBJCACHE_OPEN  = "open"
+	OBJCACHE_HIT   = "hit"
+	OBJCACHE_CLOSE = "close"
+	OBJCACHE_ERROR = "error"
+)
+
+/*
+ObjectCacheStats contains the counters for an ObjectCache
+*/
+type ObjectCacheStats struct {
+	Hits      int64 // Requests satisfied by an already-open handle
+	Misses    int64 // Requests that needed an MQOPEN
+	Closes    int64 // Handles closed because they were idle, or to make room
+	Errors    int64 // Failed MQOPEN and MQCLOSE calls
+	OpenNow   int   // Handles currently open
+	InUseNow  int   // Handles currently being used
+	Evictions int64 // Handles closed to make room for another
+	Put1s     int64 // Messages sent with MQPUT1 by PutAuto
+}
+
+/*
+ObjectCache holds open handles for queues. The Hook, if set, is called for each
+event with the name of the queue; it is called while the cache is locked, so
+it must not call back into the cache.
+*/
+type ObjectCache struct {
+	Hook func(event string, qName string, qMgrName string)
+
+	conn        QMgrConnection
+	openOptions int32
+	idleTime    time.Duration
+	maxSize     int
+
+	mutex   sync.Mutex
+	entries map[objectCacheKey]*objectCacheEntry
+	stats   ObjectCacheStats
+	closed  bool
+
+	// Used by PutAuto
+	put1Threshold int
+	put1Window    time.Duration
+	put1Usage     map[objectCacheKey]*put1Usage
+}
+
+type objectCacheKey struct {
+	qName    string
+	qMgrName string
+}
+
+type objectCacheEntry struct {
+	key      objectCacheKey
+	object   Object
+	refs     int
+	lastUsed time.Time
+	timer    *time.Timer
+}
+
+/*
+CachedObject is returned by Acquire. It must be given back with Release when the
+caller has finished with it.
+*/
+type CachedObject struct {
+	Object
+	entry *objectCacheEntry
+}
+
+/*
+NewObjectCache creates a cache for queues opened with the given options, which would
+usually include MQOO_OUTPUT. Idle handles are closed after idleTime; 0 means they
+stay open until they are evicted or the cache is closed. A maxSize of 0 means
+there is no limit.
+*/
+func NewObjectCache(conn QMgrConnection, openOptions int32, idleTime time.Duration, maxSize int) *ObjectCache {
+	return &ObjectCache{
+		conn:        conn,
+		openOptions: openOptions,
+		idleTime:    idleTime,
+		maxSize:     maxSize,
+		entries:     make(map[objectCacheKey]*objectCacheEntry),
+	}
+}
+
+func (c *ObjectCache) event(event string, key objectCacheKey) {
+	if c.Hook != nil {
+		c.Hook(event, key.qName, key.qMgrName)
+	}
+}
+
+/*
+Acquire returns an open handle for the queue, opening it if necessary. The queue
+manager name can be empty, or name a remote queue manager as in a ReplyToQMgr.
+*/
+func (c *ObjectCache) Acquire(qName string, qMgrName string) (*CachedObject, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_HOBJ_ERROR, verb: "MQOPEN"}
+	}
+
+	key := objectCacheKey{qName: qName, qMgrName: qMgrName}
+	if e, ok := c.entries[key]; ok {
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+		e.refs++
+		e.lastUsed = time.Now()
+		c.stats.Hits++
+		c.event(OBJCACHE_HIT, key)
+		return &CachedObject{Object: e.object, entry: e}, nil
+	}
+
+	if c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		c.evict()
+	}
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	mqod.ObjectQMgrName = qMgrName
+	object, err := c.conn.Open(mqod, c.openOptions)
+	c.stats.Misses++
+	if err != nil {
+		c.stats.Errors++
+		c.event(OBJCACHE_ERROR, key)
+		return nil, err
+	}
+
+	e := &objectCacheEntry{key: key, object: object, refs: 1, lastUsed: time.Now()}
+	c.entries[key] = e
+	c.event(OBJCACHE_OPEN, key)
+	return &CachedObject{Object: e.object, entry: e}, nil
+}
+
+/*
+Release says that the caller has finished with the handle. It must not be
+used again after this.
+*/
+func (c *ObjectCache) Release(o *CachedObject) {
+	if o == nil || o.entry == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e := o.entry
+	o.entry = nil
+	e.refs--
+	e.lastUsed = time.Now()
+
+	if c.closed && e.refs == 0 {
+		c.closeEntry(e)
+		return
+	}
+	if e.refs == 0 && c.idleTime > 0 {
+		e.timer = time.AfterFunc(c.idleTime, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if cur, ok := c.entries[e.key]; ok && cur == e && e.refs == 0 && time.Since(e.lastUsed) >= c.idleTime {
+				c.closeEntry(e)
+			}
+		})
+	}
+}
+
+/*
+Put is a convenience function that acquires the handle, puts the message,
+and releases the handle again
+*/
+func (c *ObjectCache) Put(qName string, qMgrName string, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	o, err := c.Acquire(qName, qMgrName)
+	if err != nil {
+		return err
+	}
+	defer c.Release(o)
+	return o.Put(gomd, gopmo, buffer)
+}
+
+/*
+Stats returns a copy of the counters
+*/
+func (c *ObjectCache) Stats() ObjectCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := c.stats
+	s.OpenNow = len(c.entries)
+	for _, e := range c.entries {
+		if e.refs > 0 {
+			s.InUseNow++
+		}
+	}
+	return s
+}
+
+/*
+Close closes all the idle handles. Handles that are still in use are closed
+when they are released. The cache cannot be used after this.
+*/
+func (c *ObjectCache) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	for _, e := range c.entries {
+		if e.refs == 0 {
+			c.closeEntry(e)
+		}
+	}
+}
+
+// Close the least recently used idle handle. Called with the lock held.
+func (c *ObjectCache) evict() {
+	var oldest *objectCacheEntry
+	for _, e := range c.entries {
+		if e.refs == 0 && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+			oldest = e
+		}
+	}
+	if oldest != nil {
+		c.stats.Evictions++
+		c.closeEntry(oldest)
+	}
+}
+
+// Called with the lock held
+func (c *ObjectCache) closeEntry(e *objectCacheEntry) {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	delete(c.entries, e.key)
+	if err := e.object.Close(0); err != nil {
+		c.stats.Errors++
+		c.event(OBJCACHE_ERROR, e.key)
+		return
+	}
+	c.stats.Closes++
+	c.event(OBJCACHE_CLOSE, e.key)
+}
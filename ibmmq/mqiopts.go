@@ -0,0 +1,264 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file contains functions to check the Options fields of the MQGMO and MQPMO
+before making an MQGET or MQPUT call. The queue manager reports an invalid combination
+as MQRC_OPTIONS_ERROR, but does not say which options are in conflict. The checks here
+cover the most common mistakes, and return an error that names the options involved.
+
+The checks are not applied automatically, as that would change the error returned
+from the MQI verbs themselves. An application calls ValidateGMO or ValidatePMO
+when it wants the more detailed explanation. Passing these checks does not
+guarantee that the queue manager will accept the options, as some combinations
+depend on the platform or the type of object being used.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+MQOptionsError is returned by the validation functions. It names the option, and
+the conflicting option if there is one. The MQReturn that the queue manager would
+have given is available through errors.As or errors.Unwrap.
+*/
+type MQOptionsError struct {
+	Verb   string
+	Option string
+	Other  string
+	Reason string
+	mqrc   int32
+}
+
+func (e *MQOptionsError) Error() string {
+	if e.Other != "" {
+		return fmt.Sprintf("%s: %s cannot be used with %s: %s", e.Verb, e.Option, e.Other, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Verb, e.Option, e.Reason)
+}
+
+func (e *MQOptionsError) Unwrap() error {
+	return &MQReturn{MQCC: MQCC_FAILED, MQRC: e.mqrc, verb: e.Verb}
+}
+
+type optionName struct {
+	value int32
+	name  string
+}
+
+// A pair of options that must not be used together
+type optionConflict struct {
+	a      optionName
+	b      optionName
+	reason string
+}
+
+// An option that needs at least one of a set of other options
+type optionRequirement struct {
+	opt    optionName
+	anyOf  []optionName
+	reason string
+}
+
+var (
+	gmoWait               = optionName{MQGMO_WAIT, "MQGMO_WAIT"}
+	gmoSetSignal          = optionName{MQGMO_SET_SIGNAL, "MQGMO_SET_SIGNAL"}
+	gmoSyncpoint          = optionName{MQGMO_SYNCPOINT, "MQGMO_SYNCPOINT"}
+	gmoNoSyncpoint        = optionName{MQGMO_NO_SYNCPOINT, "MQGMO_NO_SYNCPOINT"}
+	gmoSyncpointIfPers    = optionName{MQGMO_SYNCPOINT_IF_PERSISTENT, "MQGMO_SYNCPOINT_IF_PERSISTENT"}
+	gmoBrowseFirst        = optionName{MQGMO_BROWSE_FIRST, "MQGMO_BROWSE_FIRST"}
+	gmoBrowseNext         = optionName{MQGMO_BROWSE_NEXT, "MQGMO_BROWSE_NEXT"}
+	gmoBrowseUnderCursor  = optionName{MQGMO_BROWSE_MSG_UNDER_CURSOR, "MQGMO_BROWSE_MSG_UNDER_CURSOR"}
+	gmoMsgUnderCursor     = optionName{MQGMO_MSG_UNDER_CURSOR, "MQGMO_MSG_UNDER_CURSOR"}
+	gmoLock               = optionName{MQGMO_LOCK, "MQGMO_LOCK"}
+	gmoUnlock             = optionName{MQGMO_UNLOCK, "MQGMO_UNLOCK"}
+	gmoMarkSkipBackout    = optionName{MQGMO_MARK_SKIP_BACKOUT, "MQGMO_MARK_SKIP_BACKOUT"}
+	gmoMarkBrowseHandle   = optionName{MQGMO_MARK_BROWSE_HANDLE, "MQGMO_MARK_BROWSE_HANDLE"}
+	gmoMarkBrowseCoOp     = optionName{MQGMO_MARK_BROWSE_CO_OP, "MQGMO_MARK_BROWSE_CO_OP"}
+	gmoUnmarkBrowseHandle = optionName{MQGMO_UNMARK_BROWSE_HANDLE, "MQGMO_UNMARK_BROWSE_HANDLE"}
+	gmoUnmarkBrowseCoOp   = optionName{MQGMO_UNMARK_BROWSE_CO_OP, "MQGMO_UNMARK_BROWSE_CO_OP"}
+	gmoUnmarkedBrowseMsg  = optionName{MQGMO_UNMARKED_BROWSE_MSG, "MQGMO_UNMARKED_BROWSE_MSG"}
+	gmoPropsInHandle      = optionName{MQGMO_PROPERTIES_IN_HANDLE, "MQGMO_PROPERTIES_IN_HANDLE"}
+	gmoNoProps            = optionName{MQGMO_NO_PROPERTIES, "MQGMO_NO_PROPERTIES"}
+	gmoPropsForceRFH2     = optionName{MQGMO_PROPERTIES_FORCE_MQRFH2, "MQGMO_PROPERTIES_FORCE_MQRFH2"}
+	gmoPropsCompat        = optionName{MQGMO_PROPERTIES_COMPATIBILITY, "MQGMO_PROPERTIES_COMPATIBILITY"}
+	gmoBrowseOptions      = []optionName{gmoBrowseFirst, gmoBrowseNext, gmoBrowseUnderCursor}
+	gmoSyncpointOptions   = []optionName{gmoSyncpoint, gmoNoSyncpoint, gmoSyncpointIfPers}
+	gmoPropertiesOptions  = []optionName{gmoPropsInHandle, gmoNoProps, gmoPropsForceRFH2, gmoPropsCompat}
+	gmoMarkOptions        = []optionName{gmoMarkBrowseHandle, gmoMarkBrowseCoOp}
+	gmoUnmarkOptions      = []optionName{gmoUnmarkBrowseHandle, gmoUnmarkBrowseCoOp}
+
+	pmoSyncpoint       = optionName{MQPMO_SYNCPOINT, "MQPMO_SYNCPOINT"}
+	pmoNoSyncpoint     = optionName{MQPMO_NO_SYNCPOINT, "MQPMO_NO_SYNCPOINT"}
+	pmoNoContext       = optionName{MQPMO_NO_CONTEXT, "MQPMO_NO_CONTEXT"}
+	pmoDefaultContext  = optionName{MQPMO_DEFAULT_CONTEXT, "MQPMO_DEFAULT_CONTEXT"}
+	pmoPassIdentity    = optionName{MQPMO_PASS_IDENTITY_CONTEXT, "MQPMO_PASS_IDENTITY_CONTEXT"}
+	pmoPassAll         = optionName{MQPMO_PASS_ALL_CONTEXT, "MQPMO_PASS_ALL_CONTEXT"}
+	pmoSetIdentity     = optionName{MQPMO_SET_IDENTITY_CONTEXT, "MQPMO_SET_IDENTITY_CONTEXT"}
+	pmoSetAll          = optionName{MQPMO_SET_ALL_CONTEXT, "MQPMO_SET_ALL_CONTEXT"}
+	pmoAsyncResponse   = optionName{MQPMO_ASYNC_RESPONSE, "MQPMO_ASYNC_RESPONSE"}
+	pmoSyncResponse    = optionName{MQPMO_SYNC_RESPONSE, "MQPMO_SYNC_RESPONSE"}
+	pmoContextOptions  = []optionName{pmoNoContext, pmoDefaultContext, pmoPassIdentity, pmoPassAll, pmoSetIdentity, pmoSetAll}
+	pmoResponseOptions = []optionName{pmoAsyncResponse, pmoSyncResponse}
+)
+
+var gmoConflicts = []optionConflict{
+	{gmoWait, gmoSetSignal, "only one way of waiting for a message can be chosen"},
+	{gmoSyncpoint, gmoBrowseFirst, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoSyncpoint, gmoBrowseNext, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoSyncpoint, gmoBrowseUnderCursor, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoSyncpoint, gmoLock, "a locked message cannot be part of a unit of work"},
+	{gmoSyncpoint, gmoUnlock, "MQGMO_UNLOCK does not retrieve a message"},
+	{gmoSyncpointIfPers, gmoBrowseFirst, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoSyncpointIfPers, gmoBrowseNext, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoSyncpointIfPers, gmoBrowseUnderCursor, "browsing does not remove the message so cannot be part of a unit of work"},
+	{gmoMsgUnderCursor, gmoBrowseFirst, "the browse cursor is used by MQGMO_MSG_UNDER_CURSOR, not moved"},
+	{gmoMsgUnderCursor, gmoBrowseNext, "the browse cursor is used by MQGMO_MSG_UNDER_CURSOR, not moved"},
+	{gmoMsgUnderCursor, gmoBrowseUnderCursor, "a message cannot be both browsed and removed"},
+	{gmoUnlock, gmoLock, "a message cannot be locked and unlocked at the same time"},
+	{gmoUnlock, gmoBrowseFirst, "MQGMO_UNLOCK does not retrieve a message"},
+	{gmoUnlock, gmoBrowseNext, "MQGMO_UNLOCK does not retrieve a message"},
+	{gmoUnlock, gmoBrowseUnderCursor, "MQGMO_UNLOCK does not retrieve a message"},
+	{gmoUnlock, gmoMsgUnderCursor, "MQGMO_UNLOCK does not retrieve a message"},
+	{gmoMarkSkipBackout, gmoNoSyncpoint, "a message can only be marked for backout within a unit of work"},
+	{gmoMarkSkipBackout, gmoBrowseFirst, "a message can only be marked for backout when it is removed"},
+	{gmoMarkSkipBackout, gmoBrowseNext, "a message can only be marked for backout when it is removed"},
+	{gmoMarkSkipBackout, gmoBrowseUnderCursor, "a message can only be marked for backout when it is removed"},
+}
+
+var gmoRequirements = []optionRequirement{
+	{gmoLock, gmoBrowseOptions, "a message can only be locked while it is being browsed"},
+	{gmoMarkBrowseHandle, gmoBrowseOptions, "a message can only be marked while it is being browsed"},
+	{gmoMarkBrowseCoOp, gmoBrowseOptions, "a message can only be marked while it is being browsed"},
+	{gmoUnmarkedBrowseMsg, gmoBrowseOptions, "only applies to browse operations"},
+}
+
+/*
+ValidateGMO checks the Options field in an MQGMO, returning an MQOptionsError
+for the first problem found
+*/
+func ValidateGMO(gogmo *MQGMO) error {
+	opts := gogmo.Options
+	verb := "MQGET"
+
+	if err := checkExclusive(verb, opts, gmoSyncpointOptions, "only one syncpoint option can be chosen"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, gmoBrowseOptions, "only one browse option can be chosen"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, gmoPropertiesOptions, "only one message properties option can be chosen"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, gmoMarkOptions, "a browsed message can only be marked in one way"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, gmoUnmarkOptions, "a browsed message can only be unmarked in one way"); err != nil {
+		return err
+	}
+	if err := checkConflicts(verb, opts, gmoConflicts); err != nil {
+		return err
+	}
+	if err := checkRequirements(verb, opts, gmoRequirements); err != nil {
+		return err
+	}
+
+	if opts&MQGMO_PROPERTIES_IN_HANDLE != 0 && int64(gogmo.MsgHandle.hMsg) == int64(MQHM_NONE) {
+		return &MQOptionsError{Verb: verb, Option: gmoPropsInHandle.name,
+			Reason: "a message handle must be provided in the MQGMO", mqrc: MQRC_HMSG_ERROR}
+	}
+
+	return nil
+}
+
+/*
+ValidatePMO checks the Options field in an MQPMO, returning an MQOptionsError
+for the first problem found
+*/
+func ValidatePMO(gopmo *MQPMO) error {
+	opts := gopmo.Options
+	verb := "MQPUT"
+
+	if err := checkExclusive(verb, opts, []optionName{pmoSyncpoint, pmoNoSyncpoint}, "only one syncpoint option can be chosen"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, pmoContextOptions, "only one context option can be chosen"); err != nil {
+		return err
+	}
+	if err := checkExclusive(verb, opts, pmoResponseOptions, "only one response option can be chosen"); err != nil {
+		return err
+	}
+
+	// Passing context needs an input queue from which the context is taken
+	for _, o := range []optionName{pmoPassIdentity, pmoPassAll} {
+		if opts&o.value != 0 && gopmo.Context == nil {
+			return &MQOptionsError{Verb: verb, Option: o.name,
+				Reason: "the Context field must refer to the queue from which the context is passed", mqrc: MQRC_CONTEXT_HANDLE_ERROR}
+		}
+	}
+
+	return nil
+}
+
+func checkExclusive(verb string, opts int32, set []optionName, reason string) error {
+	found := make([]string, 0)
+	for _, o := range set {
+		if opts&o.value != 0 {
+			found = append(found, o.name)
+		}
+	}
+	if len(found) > 1 {
+		return &MQOptionsError{Verb: verb, Option: found[0], Other: strings.Join(found[1:], "+"), Reason: reason, mqrc: MQRC_OPTIONS_ERROR}
+	}
+	return nil
+}
+
+func checkConflicts(verb string, opts int32, conflicts []optionConflict) error {
+	for _, c := range conflicts {
+		if opts&c.a.value != 0 && opts&c.b.value != 0 {
+			return &MQOptionsError{Verb: verb, Option: c.a.name, Other: c.b.name, Reason: c.reason, mqrc: MQRC_OPTIONS_ERROR}
+		}
+	}
+	return nil
+}
+
+func checkRequirements(verb string, opts int32, reqs []optionRequirement) error {
+	for _, r := range reqs {
+		if opts&r.opt.value == 0 {
+			continue
+		}
+		ok := false
+		names := make([]string, 0)
+		for _, o := range r.anyOf {
+			if opts&o.value != 0 {
+				ok = true
+			}
+			names = append(names, o.name)
+		}
+		if !ok {
+			return &MQOptionsError{Verb: verb, Option: r.opt.name,
+				Reason: r.reason + " (requires one of " + strings.Join(names, ", ") + ")", mqrc: MQRC_OPTIONS_ERROR}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file implements the transactional outbox pattern, for applications that need a
+database update and an MQ message to happen together. The application writes the message
+to an outbox table in the same database transaction as its own update. An OutboxRelay
+then reads the pending records, puts them, and marks them as sent. The storage is given
+to the relay through the OutboxStore interface, so any database can be used; an outbox
+table needs little more than
+
+  id VARCHAR PRIMARY KEY, queue VARCHAR, data BLOB, created TIMESTAMP, sent BOOLEAN
+
+There are two ways to run the relay:
+
+  OUTBOX_IDEMPOTENT  The puts are committed, and then the records are marked as sent.
+                     If the relay fails between the two, the messages are sent again
+                     on the next pass. Each message has a MsgId made from its record id,
+                     so a consumer can recognise a repeat, for example with a DedupCache
+                     keyed by DedupKey.
+  OUTBOX_TWO_PHASE   The puts and the update of the records are in one global unit of
+                     work started with MQBEGIN, and committed together by the queue
+                     manager. This needs a local bindings connection, and the database
+                     must be defined to the queue manager as an XA resource manager. The
+                     OutboxStore must do its update on the database connection that the
+                     queue manager is coordinating.
+
+The Go database/sql package has no XA support, so OUTBOX_IDEMPOTENT is the one that most
+applications can use.
+
+The relay uses its connection from its own goroutine, so it should be given a connection
+that the application is not using for anything else.
+*/
+
+import (
+	"crypto/sha256"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How the relay makes the puts and the store update consistent
+const (
+	OUTBOX_IDEMPOTENT = iota
+	OUTBOX_TWO_PHASE
+)
+
+/*
+DefaultOutboxPollInterval is how often the store is checked unless the PollInterval
+is changed
+*/
+const DefaultOutboxPollInterval = 1 * time.Second
+
+const defaultOutboxBatch = 100
+
+/*
+OutboxRecord is a message waiting in the outbox. If the MD is nil, a persistent
+message with default values is put.
+*/
+type OutboxRecord struct {
+	ID       string
+	QName    string
+	QMgrName string
+	MD       *MQMD
+	Data     []byte
+	Created  time.Time
+}
+
+/*
+OutboxStore is the storage for an outbox. Pending returns up to max records that have
+not been sent, oldest first. MarkSent records that the messages with the given ids have
+been put; it must not fail if some of them are already marked.
+*/
+type OutboxStore interface {
+	Pending(max int) ([]OutboxRecord, error)
+	MarkSent(ids []string) error
+}
+
+/*
+OutboxStats contains the counters for an OutboxRelay
+*/
+type OutboxStats struct {
+	Passes    int64 // Times the store has been checked
+	Sent      int64 // Messages committed and marked as sent
+	Failed    int64 // Passes that were backed out, or whose records could not be marked
+	LastError error
+}
+
+/*
+OutboxRelay puts the messages from an OutboxStore
+*/
+type OutboxRelay struct {
+	Strategy     int
+	BatchSize    int // The most records put in one unit of work
+	PollInterval time.Duration
+
+	qMgr  *MQQueueManager
+	store OutboxStore
+	cache *ObjectCache
+
+	mutex sync.Mutex
+	stats OutboxStats
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+/*
+OutboxMsgId returns the MsgId used for a record, which is the first 24 bytes of the
+SHA-256 hash of its id
+*/
+func OutboxMsgId(id string) []byte {
+	sum := sha256.Sum256([]byte(id))
+	return sum[:MQ_MSG_ID_LENGTH]
+}
+
+/*
+NewOutboxRelay returns a relay for the store. Call Start to relay the messages in the
+background, or Relay to do a single pass.
+*/
+func NewOutboxRelay(qMgr *MQQueueManager, store OutboxStore, strategy int) *OutboxRelay {
+	return &OutboxRelay{Strategy: strategy,
+		BatchSize:    defaultOutboxBatch,
+		PollInterval: DefaultOutboxPollInterval,
+		qMgr:         qMgr,
+		store:        store,
+		cache:        NewObjectCache(NewQMgrConnection(qMgr), MQOO_OUTPUT|MQOO_FAIL_IF_QUIESCING, time.Minute, 0),
+	}
+}
+
+/*
+Close stops the relay and closes the queues it has opened
+*/
+func (r *OutboxRelay) Close() {
+	r.Stop()
+	r.cache.Close()
+}
+
+/*
+Start relays the messages from a goroutine until Stop is called. A full batch is
+followed at once by the next one.
+*/
+func (r *OutboxRelay) Start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func(stop chan struct{}, done chan struct{}) {
+		defer close(done)
+		for {
+			wait := r.PollInterval
+			if n, err := r.Relay(); err == nil && n >= r.batchSize() {
+				wait = 0
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}(r.stop, r.done)
+}
+
+/*
+Stop ends the goroutine started by Start, waiting for any pass in progress to finish
+*/
+func (r *OutboxRelay) Stop() {
+	r.mutex.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+/*
+Stats returns a copy of the counters
+*/
+func (r *OutboxRelay) Stats() OutboxStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+func (r *OutboxRelay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return defaultOutboxBatch
+	}
+	return r.BatchSize
+}
+
+/*
+Relay does one pass, putting up to BatchSize pending records in a single unit of work.
+It returns the number of messages sent.
+*/
+func (r *OutboxRelay) Relay() (int, error) {
+	records, err := r.store.Pending(r.batchSize())
+	if err == nil && len(records) > 0 {
+		err = r.relay(records)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Passes++
+	if err != nil {
+		r.stats.Failed++
+		r.stats.LastError = err
+		return 0, err
+	}
+	r.stats.Sent += int64(len(records))
+	return len(records), nil
+}
+
+func (r *OutboxRelay) relay(records []OutboxRecord) error {
+	if r.Strategy == OUTBOX_TWO_PHASE {
+		if err := r.qMgr.Begin(NewMQBO()); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, 0, len(records))
+	for i := range records {
+		if err := r.put(&records[i]); err != nil {
+			r.qMgr.Back()
+			return err
+		}
+		ids = append(ids, records[i].ID)
+	}
+
+	if r.Strategy == OUTBOX_TWO_PHASE {
+		if err := r.store.MarkSent(ids); err != nil {
+			r.qMgr.Back()
+			return err
+		}
+		return r.qMgr.Cmit()
+	}
+
+	// If the records cannot be marked now, they are sent again with the same MsgIds
+	if err := r.qMgr.Cmit(); err != nil {
+		return err
+	}
+	return r.store.MarkSent(ids)
+}
+
+func (r *OutboxRelay) put(rec *OutboxRecord) error {
+	var md *MQMD
+	if rec.MD != nil {
+		lmd := *rec.MD
+		md = &lmd
+	} else {
+		md = NewMQMD()
+		md.Persistence = MQPER_PERSISTENT
+	}
+	md.MsgId = OutboxMsgId(rec.ID)
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_FAIL_IF_QUIESCING
+	return r.cache.Put(rec.QName, rec.QMgrName, md, pmo, rec.Data)
+}
+
+/*
+MemoryOutboxStore is an OutboxStore that keeps the records in memory. It is not
+persistent, so it is only useful for testing, or as an example of the interface.
+*/
+type MemoryOutboxStore struct {
+	mutex   sync.Mutex
+	records map[string]OutboxRecord
+	sent    map[string]bool
+}
+
+/*
+NewMemoryOutboxStore returns an empty store
+*/
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{records: make(map[string]OutboxRecord), sent: make(map[string]bool)}
+}
+
+/*
+Add puts a record in the store. A record with the same id replaces it.
+*/
+func (s *MemoryOutboxStore) Add(rec OutboxRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if rec.Created.IsZero() {
+		rec.Created = time.Now()
+	}
+	s.records[rec.ID] = rec
+	delete(s.sent, rec.ID)
+}
+
+/*
+Pending returns the records not yet sent, oldest first
+*/
+func (s *MemoryOutboxStore) Pending(max int) ([]OutboxRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rc := make([]OutboxRecord, 0)
+	for id, rec := range s.records {
+		if !s.sent[id] {
+			rc = append(rc, rec)
+		}
+	}
+	sort.Slice(rc, func(i, j int) bool {
+		if !rc[i].Created.Equal(rc[j].Created) {
+			return rc[i].Created.Before(rc[j].Created)
+		}
+		return rc[i].ID < rc[j].ID
+	})
+	if max > 0 && len(rc) > max {
+		rc = rc[:max]
+	}
+	return rc, nil
+}
+
+/*
+MarkSent records that the messages have been sent
+*/
+func (s *MemoryOutboxStore) MarkSent(ids []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		if _, ok := s.records[id]; ok {
+			s.sent[id] = true
+		}
+	}
+	return nil
+}
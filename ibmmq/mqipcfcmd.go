@@ -0,0 +1,158 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file contains a simple way of sending a PCF command to the command server and
+collecting the responses. It is used by the helpers in this package that need an
+administrative operation, which cannot be done through the MQI verbs directly, and
+by small administrative tools.
+
+A temporary dynamic queue is created for the replies on each call. That keeps
+the function self-contained, but it is not the most efficient way of issuing many
+commands - applications that do that should manage their own queues.
+
+The command queue name is the one used on Distributed platforms. z/OS uses a different
+queue and a different response format, so these helpers are not suitable there.
+*/
+
+const (
+	pcfCommandQueue  = "SYSTEM.ADMIN.COMMAND.QUEUE"
+	pcfReplyModelQ   = "SYSTEM.DEFAULT.MODEL.QUEUE"
+	pcfReplyWaitTime = 30 * 1000 // milliseconds
+)
+
+/*
+PCFCommand sends a PCF command to the command server and waits for all of the
+responses, which are returned complete with their MQCFH headers. If any response
+reports a failure, the first one is also returned as the error. Use ReadPCFMessage
+to split up each response.
+*/
+func (x *MQQueueManager) PCFCommand(command int32, params []*PCFParameter) ([][]byte, error) {
+	return x.pcfCommand("PCFCommand", command, params)
+}
+
+/*
+ReadPCFMessage splits a PCF message into its header and parameters. The header
+is nil if the buffer is too short to be a PCF message.
+*/
+func ReadPCFMessage(buf []byte) (*MQCFH, []*PCFParameter) {
+	var params []*PCFParameter
+
+	cfh, offset := ReadPCFHeader(buf)
+	if cfh == nil {
+		return nil, params
+	}
+	for i := 0; i < int(cfh.ParameterCount) && offset < len(buf); i++ {
+		p, bytesRead := ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		params = append(params, p)
+	}
+	return cfh, params
+}
+
+/*
+Send a PCF command and wait for all of the responses. The response messages are
+returned without their MQCFH headers having been checked, except that the first failing
+response is also returned as an MQReturn error using the verb string.
+*/
+func (x *MQQueueManager) pcfCommand(verb string, command int32, params []*PCFParameter) ([][]byte, error) {
+	var firstErr error
+
+	responses := make([][]byte, 0)
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = pcfCommandQueue
+	cmdQObj, err := x.Open(mqod, MQOO_OUTPUT)
+	if err != nil {
+		return responses, err
+	}
+	defer cmdQObj.Close(0)
+
+	mqod = NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = pcfReplyModelQ
+	replyQObj, err := x.Open(mqod, MQOO_INPUT_EXCLUSIVE)
+	if err != nil {
+		return responses, err
+	}
+	defer replyQObj.Close(0)
+
+	cfh := NewMQCFH()
+	cfh.Command = command
+
+	buf := make([]byte, 0)
+	for _, p := range params {
+		cfh.ParameterCount++
+		buf = append(buf, p.Bytes()...)
+	}
+	buf = append(cfh.Bytes(), buf...)
+
+	putmd := NewMQMD()
+	putmd.Format = MQFMT_ADMIN
+	putmd.MsgType = MQMT_REQUEST
+	putmd.Report = MQRO_PASS_DISCARD_AND_EXPIRY | MQRO_DISCARD_MSG
+	putmd.Expiry = pcfReplyWaitTime / 100
+	putmd.ReplyToQ = replyQObj.Name
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_NO_SYNCPOINT | MQPMO_NEW_MSG_ID | MQPMO_NEW_CORREL_ID | MQPMO_FAIL_IF_QUIESCING
+
+	err = cmdQObj.Put(putmd, pmo, buf)
+	if err != nil {
+		return responses, err
+	}
+
+	replyBuf := make([]byte, 64*1024)
+	for last := false; !last; {
+		getmd := NewMQMD()
+		getmd.CorrelId = putmd.MsgId
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_NO_SYNCPOINT | MQGMO_WAIT | MQGMO_CONVERT | MQGMO_FAIL_IF_QUIESCING
+		gmo.MatchOptions = MQMO_MATCH_CORREL_ID
+		gmo.WaitInterval = pcfReplyWaitTime
+
+		data, datalen, err := replyQObj.GetSlice(getmd, gmo, replyBuf)
+		if err != nil {
+			// The reply is still on the queue, so get it again with a big enough buffer
+			if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED && datalen > len(replyBuf) {
+				replyBuf = make([]byte, datalen)
+				continue
+			}
+			return responses, err
+		}
+
+		rcfh, _ := ReadPCFHeader(data)
+		if rcfh == nil {
+			break
+		}
+		responses = append(responses, append([]byte(nil), data...))
+		if rcfh.CompCode != MQCC_OK && firstErr == nil {
+			firstErr = &MQReturn{MQCC: rcfh.CompCode, MQRC: rcfh.Reason, verb: verb}
+		}
+		last = rcfh.Control == MQCFC_LAST
+	}
+
+	return responses, firstErr
+}
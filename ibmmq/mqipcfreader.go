@@ -0,0 +1,227 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has a PCF parser for programs that read large numbers of PCF messages, such as
+a monitor catching up with thousands of publications after a pause.
+
+ReadPCFParameter creates new structures for every parameter, and uses the binary package
+to decode the fields, which is convenient but makes a lot of garbage. A PCFReader
+decodes into structures that it keeps between calls, so once it has seen a few messages
+the only allocations are for the string values. The price is that a parameter given to
+the caller is only valid until the next one is read: anything that needs to be kept,
+such as the Int64Value or GroupList slices, must be copied.
+
+The decoded parameters have the same contents as those from ReadPCFParameter.
+*/
+
+import (
+	"encoding/hex"
+)
+
+/*
+PCFReader decodes PCF parameters into reused structures
+*/
+type PCFReader struct {
+	parm  PCFParameter
+	group []PCFParameter
+}
+
+/*
+NewPCFReader creates a parser. It is not safe to use the same reader from more
+than one goroutine.
+*/
+func NewPCFReader() *PCFReader {
+	return &PCFReader{}
+}
+
+/*
+Visit decodes the parameters that follow the MQCFH in buf, calling the function for
+each one in turn. It stops early if the function returns false. The header's
+ParameterCount says how many parameters there are. The return value is the number
+of bytes used.
+*/
+func (r *PCFReader) Visit(buf []byte, count int32, f func(*PCFParameter) bool) int {
+	offset := 0
+	for i := int32(0); i < count && offset < len(buf); i++ {
+		p, l := r.Read(buf[offset:])
+		if l <= 0 {
+			break
+		}
+		offset += l
+		if !f(p) {
+			break
+		}
+	}
+	return offset
+}
+
+/*
+Read decodes a single parameter, returning it and the number of bytes used. The
+parameter is only valid until the next call to Read or Visit. A length of 0 means
+that the buffer does not contain a complete parameter.
+*/
+func (r *PCFReader) Read(buf []byte) (*PCFParameter, int) {
+	p := &r.parm
+	l := decodePCFParameter(buf, p)
+	if l > 0 && p.Type == MQCFT_GROUP {
+		l = r.readGroup(buf, p)
+	}
+	return p, l
+}
+
+// Decode the elements of a group into the reader's pool of structures. Groups
+// are not nested in anything MQ generates, so a nested group is given to the
+// normal parser.
+func (r *PCFReader) readGroup(buf []byte, p *PCFParameter) int {
+	n := int(p.ParameterCount)
+	for len(r.group) < n {
+		r.group = append(r.group, PCFParameter{})
+	}
+	p.GroupList = p.GroupList[:0]
+
+	offset := 16
+	for i := 0; i < n && offset < len(buf); i++ {
+		gp := &r.group[i]
+		l := decodePCFParameter(buf[offset:], gp)
+		if l <= 0 {
+			return 0
+		}
+		if gp.Type == MQCFT_GROUP {
+			var nested *PCFParameter
+			nested, l = ReadPCFParameter(buf[offset:])
+			*gp = *nested
+		}
+		p.GroupList = append(p.GroupList, gp)
+		offset += l
+	}
+	return offset
+}
+
+// Fill in the parameter from the buffer, reusing its slices. For a group, only the
+// group header is decoded and the returned length does not include the elements.
+func decodePCFParameter(buf []byte, p *PCFParameter) int {
+	if len(buf) < 12 {
+		return 0
+	}
+
+	p.Type = int32(endian.Uint32(buf[0:]))
+	p.strucLength = int32(endian.Uint32(buf[4:]))
+	p.Parameter = int32(endian.Uint32(buf[8:]))
+	p.Int64Value = p.Int64Value[:0]
+	p.String = p.String[:0]
+	p.GroupList = p.GroupList[:0]
+	p.CodedCharSetId = 0
+	p.ParameterCount = 0
+	p.Operator = 0
+	p.stringLength = 0
+
+	// Every PCF structure has at least 4 fields
+	l := int(p.strucLength)
+	if l < 16 || l > len(buf) {
+		return 0
+	}
+
+	i32 := func(offset int) int32 {
+		return int32(endian.Uint32(buf[offset:]))
+	}
+
+	switch p.Type {
+	case MQCFT_INTEGER:
+		p.Int64Value = append(p.Int64Value, int64(i32(12)))
+
+	case MQCFT_INTEGER_LIST:
+		count := int(i32(12))
+		if 16+count*4 > l {
+			return 0
+		}
+		for i := 0; i < count; i++ {
+			p.Int64Value = append(p.Int64Value, int64(i32(16+i*4)))
+		}
+
+	case MQCFT_INTEGER64:
+		if l < 24 {
+			return 0
+		}
+		p.Int64Value = append(p.Int64Value, int64(endian.Uint64(buf[16:])))
+
+	case MQCFT_INTEGER64_LIST:
+		count := int(i32(12))
+		if 16+count*8 > l {
+			return 0
+		}
+		for i := 0; i < count; i++ {
+			p.Int64Value = append(p.Int64Value, int64(endian.Uint64(buf[16+i*8:])))
+		}
+
+	case MQCFT_STRING:
+		offset := int(MQCFST_STRUC_LENGTH_FIXED)
+		p.CodedCharSetId = i32(12)
+		p.stringLength = i32(16)
+		if offset+int(p.stringLength) > l {
+			return 0
+		}
+		p.String = append(p.String, trimToNull(string(buf[offset:offset+int(p.stringLength)])))
+
+	case MQCFT_STRING_LIST:
+		p.CodedCharSetId = i32(12)
+		count := int(i32(16))
+		p.stringLength = i32(20)
+		if int(MQCFSL_STRUC_LENGTH_FIXED)+count*int(p.stringLength) > l {
+			return 0
+		}
+		for i := 0; i < count; i++ {
+			offset := int(MQCFSL_STRUC_LENGTH_FIXED) + i*int(p.stringLength)
+			p.String = append(p.String, trimToNull(string(buf[offset:offset+int(p.stringLength)])))
+		}
+
+	case MQCFT_GROUP:
+		p.ParameterCount = i32(12)
+
+	case MQCFT_BYTE_STRING:
+		offset := int(MQCFBS_STRUC_LENGTH_FIXED)
+		p.stringLength = i32(12)
+		if offset+int(p.stringLength) > l {
+			return 0
+		}
+		p.String = append(p.String, hex.EncodeToString(buf[offset:offset+int(p.stringLength)]))
+
+	case MQCFT_INTEGER_FILTER:
+		if l < int(MQCFIF_STRUC_LENGTH) {
+			return 0
+		}
+		p.Operator = i32(12)
+		p.Int64Value = append(p.Int64Value, int64(i32(16)))
+
+	case MQCFT_STRING_FILTER:
+		offset := int(MQCFSF_STRUC_LENGTH_FIXED)
+		p.Operator = i32(12)
+		p.CodedCharSetId = i32(16)
+		p.stringLength = i32(20)
+		if offset+int(p.stringLength) > l {
+			return 0
+		}
+		p.String = append(p.String, trimToNull(string(buf[offset:offset+int(p.stringLength)])))
+	}
+
+	// Unknown types are skipped using their length
+	return l
+}
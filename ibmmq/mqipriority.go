@@ -0,0 +1,248 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides a consumer that reads from several queues in order of importance,
+such as a command queue that must be served before a bulk work queue. Within each
+queue the order is set by the queue's MSGDLVSQ attribute: with PRIORITY, the default,
+higher priority messages are returned first; with FIFO they are returned in arrival
+order whatever their priority. The consumer reports which is in use.
+
+Each PrioritySource can have a Weight, the number of messages taken from it in a row
+while lower queues are waiting. Once a queue has used its weight, the queues below it
+get a message each before it is served again. A Weight of 0 means the queue is always
+served first, which can starve the queues below it. A source can also ignore messages
+below a MinPriority, using a selection string on the open.
+
+When all the queues are empty, the consumer waits on the first queue and checks the
+others at each PollInterval, so messages on the first queue are returned at once and
+those on other queues within a PollInterval.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+DefaultPriorityPollInterval is how often the lower queues are checked while waiting,
+unless the PollInterval is changed
+*/
+const DefaultPriorityPollInterval = 1 * time.Second
+
+/*
+PrioritySource is one of the queues read by a PriorityConsumer. They are given to
+NewPriorityConsumer with the most important first.
+*/
+type PrioritySource struct {
+	QName       string
+	Weight      int   // Messages taken in a row while lower queues are waiting. 0 means no limit
+	MinPriority int32 // Messages with a lower MQMD.Priority are left on the queue
+}
+
+/*
+PriorityMessage is a message returned by a PriorityConsumer. The Data is only valid
+until the next call to Get.
+*/
+type PriorityMessage struct {
+	QName string
+	MD    *MQMD
+	Data  []byte
+}
+
+/*
+PriorityConsumer reads messages from several queues, most important first
+*/
+type PriorityConsumer struct {
+	PollInterval time.Duration
+	GetOptions   int32 // Used on each MQGET with the wait options. The default is MQGMO_NO_SYNCPOINT
+
+	sources []*prioritySource
+	buffer  []byte
+}
+
+type prioritySource struct {
+	PrioritySource
+	object   Object
+	sequence int32
+	credits  int
+	served   bool // Has had its message while a queue above it is waiting
+}
+
+/*
+NewPriorityConsumer opens each of the queues for input
+*/
+func NewPriorityConsumer(conn QMgrConnection, sources ...PrioritySource) (*PriorityConsumer, error) {
+	c := &PriorityConsumer{PollInterval: DefaultPriorityPollInterval, GetOptions: MQGMO_NO_SYNCPOINT, buffer: make([]byte, 0, 1024*1024)}
+	if len(sources) == 0 {
+		return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_OBJECT_NAME_ERROR, verb: "PRIORITY"}
+	}
+
+	for _, src := range sources {
+		mqod := NewMQOD()
+		mqod.ObjectType = MQOT_Q
+		mqod.ObjectName = src.QName
+		if src.MinPriority > 0 {
+			mqod.SelectionString = fmt.Sprintf("Root.MQMD.Priority >= %d", src.MinPriority)
+		}
+		object, err := conn.Open(mqod, MQOO_INPUT_SHARED|MQOO_INQUIRE|MQOO_FAIL_IF_QUIESCING)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		s := &prioritySource{PrioritySource: src, object: object, sequence: MQMDS_PRIORITY, credits: src.Weight}
+		c.sources = append(c.sources, s)
+
+		values, err := object.Inq([]int32{MQIA_MSG_DELIVERY_SEQUENCE})
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if v, ok := values[MQIA_MSG_DELIVERY_SEQUENCE].(int32); ok {
+			s.sequence = v
+		}
+	}
+	return c, nil
+}
+
+/*
+Close all the queues
+*/
+func (c *PriorityConsumer) Close() error {
+	var err error
+	for _, s := range c.sources {
+		if e := s.object.Close(0); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/*
+DeliverySequence returns MQMDS_PRIORITY or MQMDS_FIFO for one of the queues, as
+read when it was opened. It returns -1 if the queue is not one of the sources.
+*/
+func (c *PriorityConsumer) DeliverySequence(qName string) int32 {
+	for _, s := range c.sources {
+		if s.QName == qName {
+			return s.sequence
+		}
+	}
+	return -1
+}
+
+/*
+Get returns the next message, waiting up to the given time for one to arrive. If no
+message arrives, the error has reason MQRC_NO_MSG_AVAILABLE.
+*/
+func (c *PriorityConsumer) Get(wait time.Duration) (*PriorityMessage, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		m, err := c.scan()
+		if m != nil || err != nil {
+			return m, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_NO_MSG_AVAILABLE, verb: "PRIORITY"}
+		}
+		if c.PollInterval > 0 && remaining > c.PollInterval {
+			remaining = c.PollInterval
+		}
+
+		m, err = c.get(c.sources[0], remaining)
+		if m != nil || err != nil {
+			return m, err
+		}
+	}
+}
+
+// Look at each queue once. Queues that have used their weight are skipped, and so are
+// the queues below them that have already had a message while they wait. When none of
+// the others has a message, every queue gets its weight back.
+func (c *PriorityConsumer) scan() (*PriorityMessage, error) {
+	for pass := 0; pass < 2; pass++ {
+		skipped := false
+		waiting := false
+		for _, s := range c.sources {
+			if pass == 0 && s.Weight > 0 && s.credits <= 0 {
+				skipped = true
+				waiting = true
+				continue
+			}
+			if pass == 0 && waiting && s.served {
+				skipped = true
+				continue
+			}
+			m, err := c.get(s, 0)
+			if m != nil || err != nil {
+				if m != nil && waiting {
+					s.served = true
+				}
+				return m, err
+			}
+		}
+		if !skipped {
+			break
+		}
+		for _, s := range c.sources {
+			s.credits = s.Weight
+			s.served = false
+		}
+	}
+	return nil, nil
+}
+
+// Get a message from one queue, returning nil if there is none
+func (c *PriorityConsumer) get(s *prioritySource, wait time.Duration) (*PriorityMessage, error) {
+	for {
+		md := NewMQMD()
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_FAIL_IF_QUIESCING | c.GetOptions
+		if wait > 0 {
+			gmo.Options |= MQGMO_WAIT
+			gmo.WaitInterval = int32(wait / time.Millisecond)
+		} else {
+			gmo.Options |= MQGMO_NO_WAIT
+		}
+
+		data, datalen, err := s.object.GetSlice(md, gmo, c.buffer[:cap(c.buffer)])
+		if err != nil {
+			mqreturn, ok := err.(*MQReturn)
+			if ok && mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE {
+				return nil, nil
+			}
+			// The message is still on the queue, so get it again with a bigger buffer
+			if ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED && datalen > cap(c.buffer) {
+				c.buffer = make([]byte, 0, datalen)
+				continue
+			}
+			return nil, err
+		}
+
+		if s.credits > 0 {
+			s.credits--
+		}
+		return &PriorityMessage{QName: s.QName, MD: md, Data: data}, nil
+	}
+}
@@ -0,0 +1,136 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file helps with the choice between MQPUT1 and MQOPEN+MQPUT.
+
+An MQPUT1 does the same work as an MQOPEN, MQPUT and MQCLOSE, but in a single call and
+so with a single network flow for a client. It is the best choice for a destination that
+is only used once. Once a destination is used a second time, keeping it open is cheaper:
+each further message then needs only the MQPUT. The BenchmarkPut1 and BenchmarkCachedPut
+tests can be run against a real queue manager, by setting MQ_BENCH_QMGR and MQ_BENCH_QUEUE,
+to see the difference on a particular system.
+
+PutAuto makes that choice for each message. Destinations that have been used often enough
+within a time window (by default, twice in a minute) are opened and kept in the ObjectCache;
+others are sent with MQPUT1.
+*/
+
+import (
+	"time"
+)
+
+// Defaults for the automatic choice
+const (
+	DefaultPut1Threshold = 2
+	DefaultPut1Window    = 60 * time.Second
+)
+
+type put1Usage struct {
+	count int
+	first time.Time
+}
+
+/*
+Put1ToQueue puts a single message to a queue without the application needing to
+build an MQOD. The queue manager name may be empty.
+*/
+func Put1ToQueue(conn QMgrConnection, qName string, qMgrName string, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = qName
+	mqod.ObjectQMgrName = qMgrName
+	return conn.Put1(mqod, gomd, gopmo, buffer)
+}
+
+/*
+SetPut1Threshold changes how often a destination has to be used, within the window,
+before PutAuto keeps it open. A threshold of 1 means that every destination is opened.
+*/
+func (c *ObjectCache) SetPut1Threshold(threshold int, window time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.put1Threshold = threshold
+	c.put1Window = window
+}
+
+/*
+PutAuto puts a message using an already-open handle if there is one. Otherwise it
+decides between MQPUT1 and opening the queue, based on how recently and how often
+the destination has been used.
+*/
+func (c *ObjectCache) PutAuto(qName string, qMgrName string, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	if c.usePut1(objectCacheKey{qName: qName, qMgrName: qMgrName}) {
+		err := Put1ToQueue(c.conn, qName, qMgrName, gomd, gopmo, buffer)
+		c.mutex.Lock()
+		c.stats.Put1s++
+		c.mutex.Unlock()
+		return err
+	}
+	return c.Put(qName, qMgrName, gomd, gopmo, buffer)
+}
+
+// Count this use of the destination, and say whether it should be sent with MQPUT1
+func (c *ObjectCache) usePut1(key objectCacheKey) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+
+	threshold := c.put1Threshold
+	if threshold <= 0 {
+		threshold = DefaultPut1Threshold
+	}
+	window := c.put1Window
+	if window <= 0 {
+		window = DefaultPut1Window
+	}
+
+	if c.put1Usage == nil {
+		c.put1Usage = make(map[objectCacheKey]*put1Usage)
+	}
+
+	now := time.Now()
+	u, ok := c.put1Usage[key]
+	if !ok || now.Sub(u.first) > window {
+		// Tidy up old entries so that the map does not keep growing
+		// with destinations that have only been used once
+		for k, old := range c.put1Usage {
+			if now.Sub(old.first) > window {
+				delete(c.put1Usage, k)
+			}
+		}
+		u = &put1Usage{first: now}
+		c.put1Usage[key] = u
+	}
+	u.count++
+
+	if u.count >= threshold {
+		delete(c.put1Usage, key)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file contains some helper methods for the MQMD, dealing with message expiry and
+report messages.
+
+The Expiry field is given in tenths of a second, which is easy to get wrong when
+working with time.Duration values. The report options need a reply queue to be set,
+and report messages then have to be matched back to the original message - which
+depends on whether the MsgId or CorrelId is copied into the report.
+
+A typical request/reply with timeout sets an expiry on the request along with
+MQRO_EXPIRATION and MQRO_DISCARD_MSG, then waits on the reply queue for either the
+reply or the expiration report, using ReportCorrelId to match them.
+*/
+
+import (
+	"bytes"
+	"time"
+)
+
+/*
+SetExpiry sets the Expiry field of the MQMD from a Duration. Any fraction of a
+tenth of a second is rounded up, so that a message never expires sooner than
+requested. A zero or negative duration means the message never expires.
+*/
+func (md *MQMD) SetExpiry(d time.Duration) {
+	if d <= 0 {
+		md.Expiry = MQEI_UNLIMITED
+		return
+	}
+
+	tenths := d / (100 * time.Millisecond)
+	if d%(100*time.Millisecond) != 0 {
+		tenths++
+	}
+	if tenths > 0x7FFFFFFF {
+		md.Expiry = MQEI_UNLIMITED
+	} else {
+		md.Expiry = int32(tenths)
+	}
+}
+
+/*
+GetExpiry returns the Expiry field as a Duration. For a message that has been retrieved,
+this is the remaining time before the message would have expired. The boolean return is
+false if the message has no expiry.
+*/
+func (md *MQMD) GetExpiry() (time.Duration, bool) {
+	if md.Expiry == MQEI_UNLIMITED || md.Expiry < 0 {
+		return 0, false
+	}
+	return time.Duration(md.Expiry) * 100 * time.Millisecond, true
+}
+
+/*
+RequestReports adds the report options to the MQMD, and sets the queue where
+the reports are to be sent. The reportOptions are MQRO_* values such as
+MQRO_COA, MQRO_COD, MQRO_EXPIRATION or MQRO_EXCEPTION, with any of the
+modifiers like MQRO_PASS_CORREL_ID.
+*/
+func (md *MQMD) RequestReports(reportOptions int32, replyToQ string, replyToQMgr string) {
+	md.Report |= reportOptions
+	md.ReplyToQ = replyToQ
+	md.ReplyToQMgr = replyToQMgr
+}
+
+/*
+IsReport returns true if this is a report message
+*/
+func (md *MQMD) IsReport() bool {
+	return md.MsgType == MQMT_REPORT
+}
+
+/*
+ReportCorrelId returns the CorrelId that report messages about this message will
+contain. After the message has been put, it can be used with MQMO_MATCH_CORREL_ID
+to get only the reports for this message. By default, the MsgId of the original
+message is copied into the report; MQRO_PASS_CORREL_ID passes the CorrelId instead.
+*/
+func (md *MQMD) ReportCorrelId() []byte {
+	if md.Report&MQRO_PASS_CORREL_ID != 0 {
+		return md.CorrelId
+	}
+	return md.MsgId
+}
+
+/*
+IsReportFor returns true if the report MQMD refers to the original message. The
+original MQMD should be the one returned from the MQPUT, so that the generated
+MsgId is available.
+*/
+func (md *MQMD) IsReportFor(original *MQMD) bool {
+	if !md.IsReport() {
+		return false
+	}
+	return bytes.Equal(md.CorrelId, original.ReportCorrelId())
+}
+
+/*
+ReportFeedback returns a short description of what kind of report this is, based on
+the Feedback field. Any feedback value not recognised here is treated as an exception.
+*/
+func (md *MQMD) ReportFeedback() string {
+	if !md.IsReport() {
+		return ""
+	}
+
+	switch md.Feedback {
+	case MQFB_COA:
+		return "COA"
+	case MQFB_COD:
+		return "COD"
+	case MQFB_EXPIRATION:
+		return "EXPIRATION"
+	case MQFB_PAN:
+		return "PAN"
+	case MQFB_NAN:
+		return "NAN"
+	case MQFB_ACTIVITY:
+		return "ACTIVITY"
+	default:
+		return "EXCEPTION"
+	}
+}
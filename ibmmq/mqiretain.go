@@ -0,0 +1,104 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has helpers for working with retained publications. The queue manager keeps
+the most recent retained publication on each topic, and gives it to new subscribers
+when they subscribe, or later when they ask for it.
+
+  - Publishers use PutRetained instead of Put
+  - Subscribers can choose how retained publications are delivered through
+    the RetainedPubs field in the SubscriptionConfig
+  - A subscription made with RETAINED_ON_REQUEST uses RequestRetained to ask for the publication
+  - IsRetained tells a subscriber whether the message it got was a retained publication
+  - ClearRetained removes the retained publication from a topic, as the
+    MQSC CLEAR TOPICSTR command does
+*/
+
+// Values for the RetainedPubs field in a SubscriptionConfig
+const (
+	RETAINED_ON_SUBSCRIBE = 0 // The default MQ behaviour
+	RETAINED_NEVER        = 1 // MQSO_NEW_PUBLICATIONS_ONLY
+	RETAINED_ON_REQUEST   = 2 // MQSO_PUBLICATIONS_ON_REQUEST
+)
+
+/*
+PutRetained publishes a message that the queue manager keeps as the retained publication
+for the topic. The object must have been opened as a topic, for example with OpenTopic.
+*/
+func (object MQObject) PutRetained(gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	lpmo := *gopmo
+	lpmo.Options |= MQPMO_RETAIN
+	err := object.Put(gomd, &lpmo, buffer)
+	gopmo.ResolvedQName = lpmo.ResolvedQName
+	gopmo.ResolvedQMgrName = lpmo.ResolvedQMgrName
+	return err
+}
+
+/*
+RequestRetained asks for the retained publication on the subscription's topic to be
+sent again. It returns the number of publications that were sent, which is 0 if
+there is nothing retained.
+*/
+func (subObject *MQObject) RequestRetained() (int32, error) {
+	sro := NewMQSRO()
+	err := subObject.Subrq(sro, MQSR_ACTION_PUBLICATION)
+	return sro.NumPubs, err
+}
+
+/*
+IsRetained checks the MQIsRetained property of a message. The message must have
+been retrieved with MQGMO_PROPERTIES_IN_HANDLE using this message handle.
+*/
+func (handle *MQMessageHandle) IsRetained() bool {
+	impo := NewMQIMPO()
+	pd := NewMQPD()
+	_, v, err := handle.InqMP(impo, pd, "MQIsRetained")
+	if err != nil {
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return false
+}
+
+/*
+ClearRetained removes the retained publication for a topic string on this queue manager.
+It is not an error if there is no retained publication. This uses a PCF command, so the
+application needs to be authorised to use the command server.
+*/
+func (x *MQQueueManager) ClearRetained(topicString string) error {
+	params := []*PCFParameter{
+		{Type: MQCFT_STRING, Parameter: MQCA_TOPIC_STRING, String: []string{topicString}},
+		{Type: MQCFT_INTEGER, Parameter: MQIACF_CLEAR_TYPE, Int64Value: []int64{int64(MQCLRT_RETAINED)}},
+		{Type: MQCFT_INTEGER, Parameter: MQIACF_CLEAR_SCOPE, Int64Value: []int64{int64(MQCLRS_LOCAL)}},
+	}
+
+	_, err := x.pcfCommand("CLEAR TOPICSTR", MQCMD_CLEAR_TOPIC_STRING, params)
+	if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRCCF_NO_RETAINED_MSG {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,486 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file is a small framework for bridges that read messages from a queue and pass
+them on: to another queue, to an HTTP service, or to anything else that a handler
+function can reach. Each Route names a source queue and a handler. The handler returns
+the messages to send on, which go to the Route's destination, to a queue chosen by the
+handler, or to the ReplyToQ of the message.
+
+Every message is handled in its own unit of work. The get from the source queue and the
+puts of the handler's output are committed together, so a message is never lost or sent
+on twice because of a failure in the bridge. If the handler fails, it is retried a few
+times and then the unit of work is backed out. The source queue's BOTHRESH and BOQNAME
+then apply as usual, through a BackoutHandler, so a message that can never be handled is
+eventually moved out of the way.
+
+Each worker of a Route has its own connection, made with the Router's Connect function,
+as a unit of work cannot be shared between goroutines on one connection. If a worker's
+connection fails, it makes a new one after the ReconnectDelay.
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+RouteMessage is a message read from the source queue. The Data is only valid until the
+handler returns.
+*/
+type RouteMessage struct {
+	Route string
+	MD    *MQMD
+	Data  []byte
+}
+
+/*
+RouteOutput is a message for a handler to send on. If the QName is empty, it goes to the
+Route's Destination, or to the ReplyToQ of the source message if the Route has no
+Destination. If the MD is nil, a copy of the source message's MQMD is used, with a new MsgId.
+*/
+type RouteOutput struct {
+	QName    string
+	QMgrName string
+	MD       *MQMD
+	Data     []byte
+}
+
+/*
+RouteHandler processes one message. An error means that the message could not be
+handled, and it is retried or backed out. Returning no outputs is not an error.
+*/
+type RouteHandler func(msg *RouteMessage) ([]RouteOutput, error)
+
+/*
+Route connects a source queue to a handler
+*/
+type Route struct {
+	Name            string
+	Source          string
+	Destination     string
+	DestinationQMgr string
+	Handler         RouteHandler
+	Concurrency     int           // The number of workers, each with its own connection. Default 1
+	Retries         int           // How many more times the handler is called after an error
+	RetryDelay      time.Duration // Between the handler calls
+	WaitInterval    time.Duration // How long each MQGET waits. Default 5 seconds
+}
+
+/*
+RouteStats contains the counters for a Route
+*/
+type RouteStats struct {
+	Received  int64 // Messages read from the source queue
+	Succeeded int64 // Messages handled and committed
+	Forwarded int64 // Output messages committed
+	Retried   int64 // Handler calls repeated after an error
+	Failed    int64 // Messages backed out because the handler or a put failed
+	Poisoned  int64 // Messages moved to the backout queue
+	Workers   int   // Workers that are connected now
+	LastError error
+}
+
+/*
+Router runs the workers for a set of routes
+*/
+type Router struct {
+	Connect        func() (*MQQueueManager, error)
+	ReconnectDelay time.Duration
+
+	mutex  sync.Mutex
+	routes []*routeState
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type routeState struct {
+	Route
+	stats RouteStats
+}
+
+type routeWorker struct {
+	router  *Router
+	route   *routeState
+	qMgr    *MQQueueManager
+	source  MQObject
+	tx      *Transaction
+	cache   *ObjectCache
+	backout *BackoutHandler
+	buffer  []byte
+}
+
+const (
+	defaultRouteWait      = 5 * time.Second
+	defaultReconnectDelay = 10 * time.Second
+
+	routeUTF8CCSID = 1208 // Messages are converted to UTF-8 for the handlers
+)
+
+/*
+NewRouter returns a Router that uses the connect function to make a connection for each
+worker
+*/
+func NewRouter(connect func() (*MQQueueManager, error)) *Router {
+	return &Router{Connect: connect, ReconnectDelay: defaultReconnectDelay}
+}
+
+/*
+AddRoute adds a route. Routes cannot be added once the Router has started.
+*/
+func (r *Router) AddRoute(route Route) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stop != nil {
+		return fmt.Errorf("Router is already running")
+	}
+	if route.Source == "" || route.Handler == nil {
+		return fmt.Errorf("Route %s needs a source queue and a handler", route.Name)
+	}
+	if route.Name == "" {
+		route.Name = route.Source
+	}
+	for _, rs := range r.routes {
+		if rs.Name == route.Name {
+			return fmt.Errorf("Route %s is already defined", route.Name)
+		}
+	}
+	if route.Concurrency <= 0 {
+		route.Concurrency = 1
+	}
+	if route.WaitInterval <= 0 {
+		route.WaitInterval = defaultRouteWait
+	}
+	r.routes = append(r.routes, &routeState{Route: route})
+	return nil
+}
+
+/*
+Start runs the workers for all the routes. Connection failures are not returned, but
+are retried and shown in the Stats.
+*/
+func (r *Router) Start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	for _, rs := range r.routes {
+		for i := 0; i < rs.Concurrency; i++ {
+			w := &routeWorker{router: r, route: rs, buffer: make([]byte, 0, 1024*1024)}
+			r.wg.Add(1)
+			go w.run(r.stop)
+		}
+	}
+}
+
+/*
+Stop ends the workers, waiting for the messages being handled to be finished
+*/
+func (r *Router) Stop() {
+	r.mutex.Lock()
+	stop := r.stop
+	r.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	r.wg.Wait()
+
+	r.mutex.Lock()
+	r.stop = nil
+	r.mutex.Unlock()
+}
+
+/*
+Stats returns a copy of the counters for each route, keyed by the route name
+*/
+func (r *Router) Stats() map[string]RouteStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rc := make(map[string]RouteStats, len(r.routes))
+	for _, rs := range r.routes {
+		rc[rs.Name] = rs.stats
+	}
+	return rc
+}
+
+func (r *Router) update(rs *routeState, f func(s *RouteStats)) {
+	r.mutex.Lock()
+	f(&rs.stats)
+	r.mutex.Unlock()
+}
+
+// Wait for the delay, returning false if the router is being stopped
+func sleepUnlessStopped(stop chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (w *routeWorker) run(stop chan struct{}) {
+	defer w.router.wg.Done()
+
+	for {
+		err := w.connect()
+		if err == nil {
+			w.router.update(w.route, func(s *RouteStats) { s.Workers++ })
+			err = w.process(stop)
+			w.router.update(w.route, func(s *RouteStats) { s.Workers-- })
+		}
+		w.disconnect()
+		if err == nil {
+			return
+		}
+		w.router.update(w.route, func(s *RouteStats) { s.LastError = err })
+		if !sleepUnlessStopped(stop, w.router.ReconnectDelay) {
+			return
+		}
+	}
+}
+
+func (w *routeWorker) connect() error {
+	qMgr, err := w.router.Connect()
+	if err != nil {
+		return err
+	}
+	w.qMgr = qMgr
+
+	mqod := NewMQOD()
+	mqod.ObjectType = MQOT_Q
+	mqod.ObjectName = w.route.Source
+	w.source, err = qMgr.Open(mqod, MQOO_INPUT_SHARED|MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return err
+	}
+	if w.backout, err = NewBackoutHandler(NewQMgrConnection(qMgr), w.route.Source); err != nil {
+		return err
+	}
+	w.tx = qMgr.NewTransaction()
+	w.cache = NewObjectCache(NewQMgrConnection(qMgr), MQOO_OUTPUT|MQOO_FAIL_IF_QUIESCING, time.Minute, 0)
+	return nil
+}
+
+func (w *routeWorker) disconnect() {
+	if w.qMgr == nil {
+		return
+	}
+	if w.tx != nil {
+		w.tx.Close()
+	}
+	if w.cache != nil {
+		w.cache.Close()
+	}
+	if w.source.qMgr != nil {
+		w.source.Close(0)
+	}
+	w.qMgr.Disc()
+	w.qMgr = nil
+	w.source = MQObject{}
+	w.tx = nil
+	w.cache = nil
+	w.backout = nil
+}
+
+// Handle messages until the router is stopped, which returns nil, or until there is
+// an error from MQ
+func (w *routeWorker) process(stop chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		md := NewMQMD()
+		md.CodedCharSetId = routeUTF8CCSID
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_WAIT | MQGMO_CONVERT | MQGMO_FAIL_IF_QUIESCING
+		gmo.WaitInterval = int32(w.route.WaitInterval / time.Millisecond)
+
+		data, datalen, err := w.tx.GetSlice(w.source, md, gmo, w.buffer[:cap(w.buffer)])
+		if err != nil {
+			mqreturn, _ := err.(*MQReturn)
+			if mqreturn != nil && mqreturn.MQRC == MQRC_NO_MSG_AVAILABLE {
+				continue
+			}
+			if mqreturn != nil && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED {
+				w.buffer = make([]byte, 0, datalen)
+				continue
+			}
+			if mqreturn == nil || mqreturn.MQCC == MQCC_FAILED {
+				return err
+			}
+		}
+		w.router.update(w.route, func(s *RouteStats) { s.Received++ })
+
+		if err = w.handle(stop, md, data); err != nil {
+			w.tx.Backout()
+			w.router.update(w.route, func(s *RouteStats) {
+				s.Failed++
+				s.LastError = err
+			})
+		}
+	}
+}
+
+// Handle one message, which is committed if there is no error
+func (w *routeWorker) handle(stop chan struct{}, md *MQMD, data []byte) error {
+	poisoned, err := w.backout.Check(md, data)
+	if err != nil {
+		return err
+	}
+	if poisoned {
+		if err = w.tx.Commit(); err == nil {
+			w.router.update(w.route, func(s *RouteStats) { s.Poisoned++ })
+		}
+		return err
+	}
+
+	msg := &RouteMessage{Route: w.route.Name, MD: md, Data: data}
+	var outputs []RouteOutput
+	for attempt := 0; ; attempt++ {
+		outputs, err = w.route.Handler(msg)
+		if err == nil || attempt >= w.route.Retries {
+			break
+		}
+		w.router.update(w.route, func(s *RouteStats) { s.Retried++ })
+		if !sleepUnlessStopped(stop, w.route.RetryDelay) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range outputs {
+		if err = w.put(&outputs[i], md); err != nil {
+			return err
+		}
+	}
+	if err = w.tx.Commit(); err == nil {
+		w.router.update(w.route, func(s *RouteStats) {
+			s.Succeeded++
+			s.Forwarded += int64(len(outputs))
+		})
+	}
+	return err
+}
+
+func (w *routeWorker) put(out *RouteOutput, srcmd *MQMD) error {
+	qName, qMgrName := out.QName, out.QMgrName
+	if qName == "" {
+		qName, qMgrName = w.route.Destination, w.route.DestinationQMgr
+	}
+	if qName == "" {
+		qName, qMgrName = strings.TrimSpace(srcmd.ReplyToQ), strings.TrimSpace(srcmd.ReplyToQMgr)
+	}
+	if qName == "" {
+		return fmt.Errorf("Route %s has no destination for the output message", w.route.Name)
+	}
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_SYNCPOINT | MQPMO_FAIL_IF_QUIESCING
+	md := out.MD
+	if md == nil {
+		lmd := *srcmd
+		md = &lmd
+		pmo.Options |= MQPMO_NEW_MSG_ID
+	}
+	return w.cache.Put(qName, qMgrName, md, pmo, out.Data)
+}
+
+/*
+ForwardRouteHandler returns a handler that sends each message on unchanged, for an
+MQ-to-MQ bridge
+*/
+func ForwardRouteHandler() RouteHandler {
+	return func(msg *RouteMessage) ([]RouteOutput, error) {
+		return []RouteOutput{{Data: msg.Data}}, nil
+	}
+}
+
+/*
+HTTPRouteHandler returns a handler that POSTs each message body to the URL. Any response
+other than 2xx is an error. If reply is set, the response body is sent back as a reply
+message, with the CorrelId set to the MsgId of the request. A nil client means
+http.DefaultClient, which has no timeout, so a client with a Timeout should usually be given.
+*/
+func HTTPRouteHandler(client *http.Client, url string, reply bool) RouteHandler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return func(msg *RouteMessage) ([]RouteOutput, error) {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(msg.Data))
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(msg.MD.Format) == strings.TrimSpace(MQFMT_STRING) {
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		req.Header.Set("X-MQ-MsgId", hex.EncodeToString(msg.MD.MsgId))
+		req.Header.Set("X-MQ-CorrelId", hex.EncodeToString(msg.MD.CorrelId))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("HTTP request to %s returned %s", url, resp.Status)
+		}
+		if !reply {
+			return nil, nil
+		}
+
+		md := NewMQMD()
+		md.MsgType = MQMT_REPLY
+		md.CorrelId = msg.MD.MsgId
+		md.Persistence = msg.MD.Persistence
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/") {
+			md.Format = MQFMT_STRING
+			md.CodedCharSetId = routeUTF8CCSID
+		}
+		return []RouteOutput{{MD: md, Data: body}}, nil
+	}
+}
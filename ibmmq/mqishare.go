@@ -0,0 +1,196 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file deals with using a single connection from several goroutines.
+
+A goroutine may run on any OS thread, so Connx always makes the hConn shareable between
+threads. The MQCNO handle-sharing option then decides what happens if two MQI calls are
+made at the same time on the same hConn:
+
+  - MQCNO_HANDLE_SHARE_NO_BLOCK (the default here) fails the second call with
+    MQRC_CALL_IN_PROGRESS (2219)
+  - MQCNO_HANDLE_SHARE_BLOCK makes the second call wait until the first one completes
+
+MQCNO_HANDLE_SHARE_NONE cannot be used safely from Go, and Connx fails with MQRC_OPTIONS_ERROR
+if it is set.
+
+Even with blocking, a sequence of calls such as a Get followed by a Cmit is not
+atomic, and other goroutines' work can join the same unit of work. The simplest
+rule is still to use one connection per goroutine. Where that is not possible,
+NewSerializedConnection wraps a connection so that each verb holds a lock for its
+duration. A Get with MQGMO_WAIT holds that lock for the whole wait interval.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+NewHandleShareCNO returns an MQCNO with the handle-sharing option set. Use
+MQCNO_HANDLE_SHARE_BLOCK or MQCNO_HANDLE_SHARE_NO_BLOCK.
+*/
+func NewHandleShareCNO(shareOption int32) *MQCNO {
+	cno := NewMQCNO()
+	cno.Options = shareOption
+	return cno
+}
+
+type serialConnection struct {
+	conn  QMgrConnection
+	mutex *sync.Mutex
+}
+
+type serialObject struct {
+	object Object
+	mutex  *sync.Mutex
+}
+
+/*
+NewSerializedConnection wraps a connection so that only one MQI verb at a time is
+run on it, whichever goroutine calls it. Objects opened through the wrapper share the
+same lock. Existing objects can be added with SerializeObject.
+*/
+func NewSerializedConnection(conn QMgrConnection) QMgrConnection {
+	return &serialConnection{conn: conn, mutex: new(sync.Mutex)}
+}
+
+/*
+SerializeObject wraps an object that was opened on the underlying connection, so that
+it uses the same lock as the serialized connection
+*/
+func SerializeObject(conn QMgrConnection, object Object) Object {
+	if sc, ok := conn.(*serialConnection); ok {
+		return &serialObject{object: object, mutex: sc.mutex}
+	}
+	return object
+}
+
+func (c *serialConnection) Disc() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.Disc()
+}
+
+func (c *serialConnection) Open(good *MQOD, goOpenOptions int32) (Object, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	object, err := c.conn.Open(good, goOpenOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &serialObject{object: object, mutex: c.mutex}, nil
+}
+
+func (c *serialConnection) Sub(gosd *MQSD, qObject Object) (Object, Object, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if so, ok := qObject.(*serialObject); ok {
+		qObject = so.object
+	}
+	subObject, q, err := c.conn.Sub(gosd, qObject)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &serialObject{object: subObject, mutex: c.mutex}, &serialObject{object: q, mutex: c.mutex}, nil
+}
+
+func (c *serialConnection) Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.Put1(good, gomd, gopmo, buffer)
+}
+
+func (c *serialConnection) CrtMH(gocmho *MQCMHO) (MQMessageHandle, error) {
+	creator, ok := c.conn.(MessageHandleCreator)
+	if !ok {
+		return MQMessageHandle{}, &MQReturn{MQCC: MQCC_FAILED, MQRC: MQRC_FUNCTION_NOT_SUPPORTED, verb: "MQCRTMH"}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return creator.CrtMH(gocmho)
+}
+
+func (c *serialConnection) Cmit() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.Cmit()
+}
+
+func (c *serialConnection) Back() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.Back()
+}
+
+func (o *serialObject) Close(goCloseOptions int32) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.Close(goCloseOptions)
+}
+
+func (o *serialObject) Put(gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.Put(gomd, gopmo, buffer)
+}
+
+func (o *serialObject) Get(gomd *MQMD, gogmo *MQGMO, buffer []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.Get(gomd, gogmo, buffer)
+}
+
+func (o *serialObject) GetSlice(gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.GetSlice(gomd, gogmo, buffer)
+}
+
+func (o *serialObject) GetBatch(n int, maxWait time.Duration, gogmo *MQGMO, buffer []byte) ([]BatchMessage, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.GetBatch(n, maxWait, gogmo, buffer)
+}
+
+func (o *serialObject) Inq(goSelectors []int32) (map[int32]interface{}, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.Inq(goSelectors)
+}
+
+func (o *serialObject) Set(goSelectors map[int32]interface{}) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.object.Set(goSelectors)
+}
+
+func (o *serialObject) ObjectName() string {
+	return o.object.ObjectName()
+}
+
+func (o *serialObject) usable() bool {
+	return IsUsableObject(o.object)
+}
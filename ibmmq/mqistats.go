@@ -0,0 +1,148 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file keeps some simple counters for each connection, maintained in this layer
+rather than by the queue manager. They are always available to the application,
+whether or not accounting and statistics are enabled on the queue manager.
+
+The latency is the time spent in the MQI for puts and gets. A get that waits for
+a message includes the time spent waiting.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ConnectionStats contains the counters for a connection since it was
+made, or since ResetStats was last called
+*/
+type ConnectionStats struct {
+	Puts       int64 // Successful MQPUT and MQPUT1 calls
+	Gets       int64 // Successful MQGET calls, including browses
+	BytesPut   int64
+	BytesGot   int64
+	Errors     int64 // Failed puts and gets, not counting MQRC_NO_MSG_AVAILABLE
+	Reconnects int64 // Only counted when an event handler has been registered
+	AvgLatency time.Duration
+	Since      time.Time
+}
+
+type connStats struct {
+	sync.Mutex
+	stats        ConnectionStats
+	calls        int64
+	totalLatency time.Duration
+}
+
+func newConnStats() *connStats {
+	s := new(connStats)
+	s.stats.Since = time.Now()
+	return s
+}
+
+// The methods here allow for a nil receiver, in case an MQQueueManager has been
+// created without going through Connx.
+func (s *connStats) recordPut(bytes int, start time.Time, mqcc int32) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if mqcc == MQCC_FAILED {
+		s.stats.Errors++
+	} else {
+		s.stats.Puts++
+		s.stats.BytesPut += int64(bytes)
+	}
+	s.addLatency(start)
+	s.Unlock()
+}
+
+func (s *connStats) recordGet(bytes int, start time.Time, mqcc int32, mqrc int32) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if mqcc == MQCC_FAILED {
+		if mqrc != MQRC_NO_MSG_AVAILABLE {
+			s.stats.Errors++
+		}
+	} else {
+		s.stats.Gets++
+		s.stats.BytesGot += int64(bytes)
+	}
+	s.addLatency(start)
+	s.Unlock()
+}
+
+func (s *connStats) recordReconnect() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.stats.Reconnects++
+	s.Unlock()
+}
+
+// Must be called with the lock held
+func (s *connStats) addLatency(start time.Time) {
+	s.calls++
+	s.totalLatency += time.Since(start)
+}
+
+/*
+Stats returns a copy of the counters for this connection
+*/
+func (x *MQQueueManager) Stats() ConnectionStats {
+	s := x.stats
+	if s == nil {
+		return ConnectionStats{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	stats := s.stats
+	if s.calls > 0 {
+		stats.AvgLatency = s.totalLatency / time.Duration(s.calls)
+	}
+	return stats
+}
+
+/*
+ResetStats sets all the counters for this connection back to zero
+*/
+func (x *MQQueueManager) ResetStats() {
+	s := x.stats
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	s.stats = ConnectionStats{Since: time.Now()}
+	s.calls = 0
+	s.totalLatency = 0
+	s.Unlock()
+}
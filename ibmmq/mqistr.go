@@ -54,24 +54,6 @@ func mqstrerror(verb string, mqcc C.MQLONG, mqrc C.MQLONG) string {
 		C.GoString(C.MQRC_STR(mqrc)), mqrc)
 }
 
-func MQItoStringStripPrefix(class string, value int) string {
-	s := MQItoString(class, value)
-	c := ""
-	if strings.HasPrefix(class, "MQ") {
-		c = class
-	} else {
-		c = "MQ" + class
-	}
-	if strings.HasPrefix(s, c) {
-		l := strings.IndexRune(s, '_')
-		if l > 0 {
-			s = s[l:]
-		}
-
-	}
-	return s
-}
-
 /*
 MQItoString returns a string representation of the MQI #define.
 Some of the sets are aggregated, so that "RC" will return something from either the MQRC
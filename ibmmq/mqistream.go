@@ -0,0 +1,420 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides io.Writer and io.Reader implementations that move a stream of data
+through a queue as a message group. Each chunk of the stream is one message in the group,
+so a very large payload never has to be held in memory at once, and it never exceeds the
+MAXMSGL of the queue or channels as long as the chunk size is small enough.
+
+A SHA-256 checksum of the whole stream is sent as a property on the last message, and
+checked by the reader when it gets there. The messages are matched explicitly by GroupId
+and MsgSeqNumber rather than using MQPMO_LOGICAL_ORDER and MQGMO_LOGICAL_ORDER, so that
+a transfer can be resumed from a saved StreamPosition on a new connection.
+
+If Syncpoint is set, each chunk is put or got under syncpoint. The application is then
+responsible for committing, and should save the Position at the same time, so
+that a restart continues from the last committed chunk. Without syncpoint, a failure
+between an MQI call and saving the Position can lose or repeat one chunk.
+
+The checksum property needs a message handle. If the connection cannot create one,
+such as the FakeQueueManager, the checksum is neither sent nor checked.
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+/*
+DefaultStreamChunkSize is the size of each message written by a MessageWriter
+unless the ChunkSize is changed
+*/
+const DefaultStreamChunkSize = 1024 * 1024
+
+const streamChecksumProperty = "mqgo.stream.sha256"
+
+/*
+ErrStreamChecksum is returned by a MessageReader when the data read does not match the
+checksum sent with the stream
+*/
+var ErrStreamChecksum = errors.New("stream checksum does not match")
+
+/*
+StreamPosition records how far through a stream a MessageReader or MessageWriter has got.
+All the fields should be saved if the transfer is to be resumed.
+*/
+type StreamPosition struct {
+	GroupId   []byte
+	NextSeq   int32
+	Offset    int64
+	HashState []byte
+}
+
+/*
+MessageWriter writes a stream of data to a queue as a message group
+*/
+type MessageWriter struct {
+	ChunkSize int
+	Syncpoint bool
+
+	creator MessageHandleCreator
+	object  Object
+	md      MQMD
+	pos     StreamPosition
+	hash    hash.Hash
+	buf     []byte
+	err     error
+	closed  bool
+}
+
+/*
+NewMessageWriter creates a writer for a new stream. The MQMD is used as a template for each
+message; its group and segmentation fields are replaced.
+*/
+func NewMessageWriter(conn QMgrConnection, object Object, gomd *MQMD) (*MessageWriter, error) {
+	groupId := make([]byte, MQ_GROUP_ID_LENGTH)
+	if _, err := rand.Read(groupId); err != nil {
+		return nil, err
+	}
+	pos := &StreamPosition{GroupId: groupId, NextSeq: 1}
+	return ResumeMessageWriter(conn, object, gomd, pos)
+}
+
+/*
+ResumeMessageWriter creates a writer that continues a stream from a saved position
+*/
+func ResumeMessageWriter(conn QMgrConnection, object Object, gomd *MQMD, pos *StreamPosition) (*MessageWriter, error) {
+	h, err := restoreStreamHash(pos.HashState)
+	if err != nil {
+		return nil, err
+	}
+	creator, _ := conn.(MessageHandleCreator)
+	w := &MessageWriter{
+		ChunkSize: DefaultStreamChunkSize,
+		creator:   creator,
+		object:    object,
+		md:        *gomd,
+		pos:       *pos,
+		hash:      h,
+	}
+	return w, nil
+}
+
+/*
+Write implements io.Writer. Data is buffered until there is enough for a complete chunk.
+*/
+func (w *MessageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	n := 0
+	for len(p) > 0 {
+		space := w.ChunkSize - len(w.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		w.buf = append(w.buf, p[:space]...)
+		p = p[space:]
+		n += space
+
+		if len(w.buf) >= w.ChunkSize {
+			if err := w.putChunk(w.buf, false); err != nil {
+				return n, err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+/*
+Close writes any buffered data as the last message in the group, along with the checksum
+*/
+func (w *MessageWriter) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+	return w.putChunk(w.buf, true)
+}
+
+/*
+Position returns how much of the stream has been put. Data that has been written but not
+yet put as a complete chunk is not included.
+*/
+func (w *MessageWriter) Position() StreamPosition {
+	return w.pos
+}
+
+func (w *MessageWriter) putChunk(data []byte, last bool) error {
+	md := w.md
+	md.Version = MQMD_VERSION_2
+	md.MsgId = make([]byte, MQ_MSG_ID_LENGTH)
+	md.GroupId = w.pos.GroupId
+	md.MsgSeqNumber = w.pos.NextSeq
+	md.Offset = 0
+	md.MsgFlags = MQMF_MSG_IN_GROUP
+
+	pmo := NewMQPMO()
+	pmo.Options = MQPMO_NEW_MSG_ID | MQPMO_FAIL_IF_QUIESCING
+	if w.Syncpoint {
+		pmo.Options |= MQPMO_SYNCPOINT
+	} else {
+		pmo.Options |= MQPMO_NO_SYNCPOINT
+	}
+
+	w.hash.Write(data)
+
+	if last {
+		md.MsgFlags = MQMF_LAST_MSG_IN_GROUP
+	}
+	if last && w.creator != nil {
+		handle, err := w.creator.CrtMH(NewMQCMHO())
+		if err != nil {
+			w.err = err
+			return err
+		}
+		defer handle.DltMH(NewMQDMHO())
+
+		sum := hex.EncodeToString(w.hash.Sum(nil))
+		if err = handle.SetMP(NewMQSMPO(), streamChecksumProperty, NewMQPD(), sum); err != nil {
+			w.err = err
+			return err
+		}
+		pmo.OriginalMsgHandle = handle
+	}
+
+	if err := w.object.Put(&md, pmo, data); err != nil {
+		w.err = err
+		return err
+	}
+
+	w.pos.NextSeq++
+	w.pos.Offset += int64(len(data))
+	w.pos.HashState = saveStreamHash(w.hash)
+	return nil
+}
+
+/*
+MessageReader reads a stream of data from a message group written by a MessageWriter.
+The WaitInterval, in milliseconds, is used for each message in the group.
+*/
+type MessageReader struct {
+	Syncpoint    bool
+	WaitInterval int32
+
+	creator  MessageHandleCreator
+	object   Object
+	pos      StreamPosition
+	hash     hash.Hash
+	buf      []byte
+	data     []byte
+	handle   MQMessageHandle
+	haveMH   bool
+	checksum string
+	done     bool
+	err      error
+}
+
+/*
+NewMessageReader creates a reader for the next stream on the queue. The first message of
+any group is used.
+*/
+func NewMessageReader(conn QMgrConnection, object Object, waitInterval int32) (*MessageReader, error) {
+	return ResumeMessageReader(conn, object, &StreamPosition{NextSeq: 1}, waitInterval)
+}
+
+/*
+ResumeMessageReader creates a reader that continues from a saved position
+*/
+func ResumeMessageReader(conn QMgrConnection, object Object, pos *StreamPosition, waitInterval int32) (*MessageReader, error) {
+	h, err := restoreStreamHash(pos.HashState)
+	if err != nil {
+		return nil, err
+	}
+	creator, _ := conn.(MessageHandleCreator)
+	r := &MessageReader{
+		WaitInterval: waitInterval,
+		creator:      creator,
+		object:       object,
+		pos:          *pos,
+		hash:         h,
+		buf:          make([]byte, 0, DefaultStreamChunkSize),
+	}
+	return r, nil
+}
+
+/*
+Read implements io.Reader. It returns io.EOF after the last message in the group has
+been read and the checksum verified.
+*/
+func (r *MessageReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.getChunk()
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+/*
+Position returns how much of the stream has been got from the queue. This may include
+data that has been got but not yet returned by Read.
+*/
+func (r *MessageReader) Position() StreamPosition {
+	return r.pos
+}
+
+/*
+Close releases the resources used by the reader. It does not close the queue.
+*/
+func (r *MessageReader) Close() error {
+	if r.haveMH {
+		r.haveMH = false
+		return r.handle.DltMH(NewMQDMHO())
+	}
+	return nil
+}
+
+func (r *MessageReader) getChunk() error {
+	var err error
+
+	if !r.haveMH && r.creator != nil {
+		r.handle, err = r.creator.CrtMH(NewMQCMHO())
+		if err != nil {
+			return err
+		}
+		r.haveMH = true
+	}
+
+	for {
+		md := NewMQMD()
+		md.Version = MQMD_VERSION_2
+		md.MsgSeqNumber = r.pos.NextSeq
+
+		gmo := NewMQGMO()
+		gmo.Options = MQGMO_WAIT | MQGMO_FAIL_IF_QUIESCING
+		if r.Syncpoint {
+			gmo.Options |= MQGMO_SYNCPOINT
+		} else {
+			gmo.Options |= MQGMO_NO_SYNCPOINT
+		}
+		gmo.WaitInterval = r.WaitInterval
+		if r.haveMH {
+			gmo.Options |= MQGMO_PROPERTIES_IN_HANDLE
+			gmo.MsgHandle = r.handle
+		}
+		gmo.MatchOptions = MQMO_MATCH_MSG_SEQ_NUMBER
+		if r.pos.GroupId != nil {
+			md.GroupId = r.pos.GroupId
+			gmo.MatchOptions |= MQMO_MATCH_GROUP_ID
+		}
+
+		var datalen int
+		r.buf, datalen, err = r.object.GetSlice(md, gmo, r.buf[:0])
+		if err != nil {
+			if mqreturn, ok := err.(*MQReturn); ok && mqreturn.MQRC == MQRC_TRUNCATED_MSG_FAILED {
+				r.buf = make([]byte, 0, datalen)
+				continue
+			}
+			return err
+		}
+
+		if r.pos.GroupId == nil {
+			r.pos.GroupId = md.GroupId
+		}
+		r.hash.Write(r.buf)
+		r.data = r.buf
+		r.pos.NextSeq++
+		r.pos.Offset += int64(len(r.buf))
+		r.pos.HashState = saveStreamHash(r.hash)
+
+		if md.MsgFlags&MQMF_LAST_MSG_IN_GROUP != 0 {
+			r.done = true
+			return r.verifyChecksum()
+		}
+		return nil
+	}
+}
+
+// A stream that was not written by a MessageWriter may not have a checksum,
+// and that is not treated as an error.
+func (r *MessageReader) verifyChecksum() error {
+	if !r.haveMH {
+		return nil
+	}
+	_, v, err := r.handle.InqMP(NewMQIMPO(), NewMQPD(), streamChecksumProperty)
+	if err != nil {
+		return nil
+	}
+	sum, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	return checkStreamChecksum(sum, r.hash)
+}
+
+func checkStreamChecksum(sum string, h hash.Hash) error {
+	expected, err := hex.DecodeString(sum)
+	if err != nil || !bytes.Equal(expected, h.Sum(nil)) {
+		return ErrStreamChecksum
+	}
+	return nil
+}
+
+// The standard hash implementations can save and restore their
+// intermediate state, which lets a checksum continue across a restart
+func saveStreamHash(h hash.Hash) []byte {
+	if m, ok := h.(encoding.BinaryMarshaler); ok {
+		if b, err := m.MarshalBinary(); err == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func restoreStreamHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if len(state) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
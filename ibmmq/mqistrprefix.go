@@ -0,0 +1,46 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2018, 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"strings"
+)
+
+/*
+MQItoStringStripPrefix returns the name of the MQI #define without its prefix. It does not
+use cgo itself, so it is kept apart from MQItoString in mqistr.go.
+*/
+func MQItoStringStripPrefix(class string, value int) string {
+	s := MQItoString(class, value)
+	c := ""
+	if strings.HasPrefix(class, "MQ") {
+		c = class
+	} else {
+		c = "MQ" + class
+	}
+	if strings.HasPrefix(s, c) {
+		l := strings.IndexRune(s, '_')
+		if l > 0 {
+			s = s[l:]
+		}
+
+	}
+	return s
+}
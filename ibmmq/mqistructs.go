@@ -0,0 +1,230 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file reports which versions of the MQI structures can be used, to help explain
+why a field that has been set appears to make no difference. There are three things
+that must all be new enough for a field to reach the queue manager:
+
+  - These Go bindings, which may not know about the latest fields
+  - The MQ header files used for the build. Fields from later structure versions than
+    the headers know about are silently dropped when the structure is built.
+  - The MQ library that the program is running with. It rejects a structure version
+    that it does not understand, with an error such as MQRC_CNO_ERROR.
+
+The MQI has no call that reports the level of the client library, so what it supports is
+learnt from the structures it accepts or rejects on MQCONNX in this process. Only the
+connection structures are reported, as they have the optional fields.
+*/
+
+/*
+
+#include <cmqc.h>
+#include <cmqxc.h>
+
+MQLONG getCompiledBNOVersion() {
+#if defined(MQBNO_CURRENT_VERSION)
+  return MQBNO_CURRENT_VERSION;
+#else
+  return 0;
+#endif
+}
+
+*/
+import "C"
+
+import (
+	"sync"
+)
+
+/*
+StructField is one of the optional fields in an MQI structure
+*/
+type StructField struct {
+	Name     string // Such as "CCDTUrl"
+	Version  int32  // The structure version that added the field
+	Compiled bool   // The MQ headers used for the build include the field
+	Ignored  bool   // The field has been set in this process, but could not be passed to MQ
+}
+
+/*
+StructSupport shows the versions of one MQI structure that can be used. Accepted and
+Rejected are 0 until a connection has been attempted with the structure.
+*/
+type StructSupport struct {
+	Name     string // Such as "MQCNO"
+	Bindings int32  // The highest version these Go bindings can use
+	Compiled int32  // The current version in the MQ headers used for the build. 0 if they do not have it
+	Accepted int32  // The highest version the MQ library has accepted
+	Rejected int32  // The lowest version the MQ library has rejected, which may also be for a bad value
+	Fields   []StructField
+}
+
+type auditField struct {
+	name    string
+	version int32
+}
+
+// The versions and fields used by the bindings. MQCD version 12 has only a
+// field that does not apply to client channels, so the bindings stop at 11.
+var auditStructs = []struct {
+	name     string
+	bindings int32
+	fields   []auditField
+}{
+	{"MQCNO", 8, []auditField{{"ClientConn", 2}, {"SSLConfig", 4}, {"SecurityParms", 5},
+		{"CCDTUrl", 6}, {"ApplName", 7}, {"BalanceParms", 8}}},
+	{"MQCD", 11, []auditField{{"DefReconnect", 10}, {"CertificateLabel", 11}}},
+	{"MQSCO", 6, []auditField{{"CertificateLabel", 5}, {"KeyRepoPassword", 6}}},
+	{"MQCSP", 3, []auditField{{"InitialKey", 2}, {"Token", 3}}},
+	{"MQBNO", 1, nil},
+}
+
+// The structures whose errors show that the library did not accept them
+var auditReasons = map[int32]string{
+	MQRC_CNO_ERROR: "MQCNO",
+	MQRC_CD_ERROR:  "MQCD",
+	MQRC_SCO_ERROR: "MQSCO",
+	MQRC_CSP_ERROR: "MQCSP",
+	MQRC_BNO_ERROR: "MQBNO",
+}
+
+var structAudit = struct {
+	sync.Mutex
+	accepted map[string]int32
+	rejected map[string]int32
+	ignored  map[string]bool // Keyed by "structure.field"
+}{accepted: make(map[string]int32), rejected: make(map[string]int32), ignored: make(map[string]bool)}
+
+func compiledVersion(name string) int32 {
+	switch name {
+	case "MQCNO":
+		return int32(C.MQCNO_CURRENT_VERSION)
+	case "MQCD":
+		return int32(C.MQCD_CURRENT_VERSION)
+	case "MQSCO":
+		return int32(C.MQSCO_CURRENT_VERSION)
+	case "MQCSP":
+		return int32(C.MQCSP_CURRENT_VERSION)
+	case "MQBNO":
+		return int32(C.getCompiledBNOVersion())
+	}
+	return 0
+}
+
+func fieldCompiled(name string, field string) bool {
+	for _, s := range auditStructs {
+		if s.name != name {
+			continue
+		}
+		for _, f := range s.fields {
+			if f.name == field {
+				return compiledVersion(name) >= f.version
+			}
+		}
+	}
+	return true
+}
+
+/*
+GetStructSupport reports, for each of the connection structures, the versions supported
+by these bindings, by the MQ headers they were built with, and by the MQ library as far as
+it has been seen so far. Fields that were set by the application but had to be dropped
+because the headers do not have them are marked as Ignored.
+*/
+func GetStructSupport() []StructSupport {
+	structAudit.Lock()
+	defer structAudit.Unlock()
+
+	rc := make([]StructSupport, 0, len(auditStructs))
+	for _, s := range auditStructs {
+		ss := StructSupport{Name: s.name,
+			Bindings: s.bindings,
+			Compiled: compiledVersion(s.name),
+			Accepted: structAudit.accepted[s.name],
+			Rejected: structAudit.rejected[s.name],
+		}
+		for _, f := range s.fields {
+			ss.Fields = append(ss.Fields, StructField{Name: f.name,
+				Version:  f.version,
+				Compiled: ss.Compiled >= f.version,
+				Ignored:  structAudit.ignored[s.name+"."+f.name],
+			})
+		}
+		rc = append(rc, ss)
+	}
+	return rc
+}
+
+// Remember any fields in the MQCNO, and the structures it points to, that are
+// set but cannot be passed on with the headers used for the build
+func auditCNOFields(gocno *MQCNO) {
+	set := map[string]map[string]bool{
+		"MQCNO": {"CCDTUrl": gocno.CCDTUrl != "", "ApplName": gocno.ApplName != "", "BalanceParms": gocno.BalanceParms != nil},
+	}
+	if gocno.SecurityParms != nil {
+		set["MQCSP"] = map[string]bool{"InitialKey": gocno.SecurityParms.InitialKey != "", "Token": gocno.SecurityParms.Token != ""}
+	}
+	if gocno.SSLConfig != nil {
+		set["MQSCO"] = map[string]bool{"KeyRepoPassword": gocno.SSLConfig.KeyRepoPassword != ""}
+	}
+
+	structAudit.Lock()
+	defer structAudit.Unlock()
+	for name, fields := range set {
+		for field, isSet := range fields {
+			if isSet && !fieldCompiled(name, field) {
+				structAudit.ignored[name+"."+field] = true
+			}
+		}
+	}
+}
+
+// Record the structure versions that the MQ library accepted or rejected on an MQCONNX
+func auditConnx(mqcno *C.MQCNO, mqcc C.MQLONG, mqrc C.MQLONG) {
+	versions := map[string]int32{"MQCNO": int32(mqcno.Version)}
+	if mqcno.Version >= C.MQCNO_VERSION_2 && mqcno.ClientConnPtr != nil {
+		versions["MQCD"] = int32(C.PMQCD(mqcno.ClientConnPtr).Version)
+	}
+	if mqcno.Version >= C.MQCNO_VERSION_4 && mqcno.SSLConfigPtr != nil {
+		versions["MQSCO"] = int32(mqcno.SSLConfigPtr.Version)
+	}
+	if mqcno.Version >= C.MQCNO_VERSION_5 && mqcno.SecurityParmsPtr != nil {
+		versions["MQCSP"] = int32(mqcno.SecurityParmsPtr.Version)
+	}
+
+	structAudit.Lock()
+	defer structAudit.Unlock()
+	if mqcc != C.MQCC_FAILED {
+		for name, v := range versions {
+			if v > structAudit.accepted[name] {
+				structAudit.accepted[name] = v
+			}
+		}
+		return
+	}
+	name, ok := auditReasons[int32(mqrc)]
+	if v, found := versions[name]; ok && found {
+		if r := structAudit.rejected[name]; r == 0 || v < r {
+			structAudit.rejected[name] = v
+		}
+	}
+}
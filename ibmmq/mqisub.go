@@ -0,0 +1,170 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides a simpler way of creating a subscription than filling in the
+MQSD directly. In particular, it deals with the choice of where publications are sent:
+
+  - A managed queue created by the queue manager from its model queues
+  - A queue that the application has already opened
+  - A queue named here, which is opened for input on behalf of the application
+
+Some environments prohibit the use of model queues, so managed subscriptions cannot be
+used there and publications have to be sent to a predefined queue instead.
+*/
+
+/*
+SubscriptionConfig describes a subscription to be made by the Subscribe function.
+Use NewSubscriptionConfig to get the default values.
+
+The topic is given by TopicObject, TopicString or both, in the same way as for OpenTopic.
+If neither Destination nor DestinationQName is set, a managed subscription is created.
+Expiry is in tenths of a second. Options can add further MQSO_* values such
+as MQSO_ALTER or MQSO_RESUME; MQSO_CREATE is used if none of those is given.
+*/
+type SubscriptionConfig struct {
+	TopicObject string
+	TopicString string
+	SubName     string
+	Durable     bool
+	Selector    string
+	UserData    string
+
+	Destination      *MQObject
+	DestinationQName string
+
+	Expiry       int32
+	Priority     int32
+	SubLevel     int32
+	RetainedPubs int // One of the RETAINED_* values
+	Options      int32
+}
+
+/*
+NewSubscriptionConfig fills in default values for the SubscriptionConfig structure
+*/
+func NewSubscriptionConfig() *SubscriptionConfig {
+	sc := new(SubscriptionConfig)
+	sc.Expiry = MQEI_UNLIMITED
+	sc.Priority = MQPRI_PRIORITY_AS_PUBLISHED
+	sc.SubLevel = 1
+	return sc
+}
+
+/*
+Subscribe creates a subscription from the SubscriptionConfig. It returns the subscription
+object and the queue from which publications can be read. If the queue was opened by this
+function, and the subscription fails, the queue is closed again. The MQSD that was used is
+also returned, so the application can see values such as the ResObjectString and SubCorrelId.
+*/
+func (x *MQQueueManager) Subscribe(sc *SubscriptionConfig) (MQObject, MQObject, *MQSD, error) {
+	var qObject MQObject
+	var err error
+
+	openedHere := false
+
+	mqsd := NewMQSD()
+	mqsd.ObjectName = sc.TopicObject
+	mqsd.ObjectString = sc.TopicString
+	mqsd.SubName = sc.SubName
+	mqsd.SubExpiry = sc.Expiry
+	mqsd.PubPriority = sc.Priority
+	mqsd.SubLevel = sc.SubLevel
+	mqsd.SelectionString = sc.Selector
+	mqsd.SubUserData = sc.UserData
+
+	mqsd.Options = sc.Options
+	if mqsd.Options&(MQSO_ALTER|MQSO_RESUME) == 0 {
+		mqsd.Options |= MQSO_CREATE
+	}
+	switch sc.RetainedPubs {
+	case RETAINED_NEVER:
+		mqsd.Options |= MQSO_NEW_PUBLICATIONS_ONLY
+	case RETAINED_ON_REQUEST:
+		mqsd.Options |= MQSO_PUBLICATIONS_ON_REQUEST
+	}
+	if sc.Durable {
+		mqsd.Options |= MQSO_DURABLE
+	} else {
+		mqsd.Options |= MQSO_NON_DURABLE
+	}
+
+	if sc.Destination != nil {
+		qObject = *sc.Destination
+	} else if sc.DestinationQName != "" {
+		mqod := NewMQOD()
+		mqod.ObjectType = MQOT_Q
+		mqod.ObjectName = sc.DestinationQName
+		qObject, err = x.Open(mqod, MQOO_INPUT_AS_Q_DEF)
+		if err != nil {
+			return MQObject{}, qObject, mqsd, err
+		}
+		openedHere = true
+	} else if mqsd.Options&MQSO_RESUME == 0 {
+		// When resuming, the caller says whether the subscription is managed. If not,
+		// the queue manager opens the destination queue named in the subscription.
+		mqsd.Options |= MQSO_MANAGED
+	}
+
+	subObject, err := x.Sub(mqsd, &qObject)
+	if err != nil && openedHere {
+		qObject.Close(0)
+	}
+
+	return subObject, qObject, mqsd, err
+}
+
+/*
+ResumeSubscription reconnects to an existing durable subscription, for example after an
+application restart. The managed flag must match the way the subscription was created.
+For a subscription that is not managed, the queue manager opens the destination queue.
+
+The returned MQSD contains the current properties of the subscription, including
+the topic, selector, expiry and user data.
+*/
+func (x *MQQueueManager) ResumeSubscription(subName string, managed bool) (MQObject, MQObject, *MQSD, error) {
+	sc := NewSubscriptionConfig()
+	sc.SubName = subName
+	sc.Durable = true
+	sc.Options = MQSO_RESUME
+	if managed {
+		sc.Options |= MQSO_MANAGED
+	}
+	return x.Subscribe(sc)
+}
+
+/*
+AlterSubscription changes the properties of an existing subscription, creating it
+if it does not already exist. The subscription is identified by the SubName.
+
+Not everything can be altered. In particular the topic, the selector and the durability
+are fixed when the subscription is created; attempting to change them gives errors such as
+MQRC_TOPIC_NOT_ALTERABLE and MQRC_SELECTOR_NOT_ALTERABLE. In those cases, the subscription
+has to be removed and created again.
+*/
+func (x *MQQueueManager) AlterSubscription(sc *SubscriptionConfig) (MQObject, MQObject, *MQSD, error) {
+	lsc := *sc
+	lsc.Options |= MQSO_ALTER | MQSO_CREATE
+	return x.Subscribe(&lsc)
+}
@@ -0,0 +1,182 @@
+//go:build cgo
+// +build cgo
+
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file contains a wrapper for a local unit of work. Each Get and Put made through
+the Transaction is forced to be under syncpoint, so the application does not have to
+manage the MQGMO_SYNCPOINT and MQPMO_SYNCPOINT flags itself. The Transaction also
+counts the messages that are part of the current unit of work.
+
+MQ has a single unit of work per connection, so there should only be one Transaction
+in use on a connection at a time. Operations done directly on the queue manager or
+object handles with syncpoint options are also part of the same unit of work, but are
+not counted here.
+*/
+
+import (
+	"errors"
+	"sync"
+)
+
+/*
+ErrUncommittedWork is returned from Transaction.Close when there were still
+messages in the unit of work
+*/
+var ErrUncommittedWork = errors.New("transaction closed with uncommitted messages")
+
+/*
+Transaction is a local unit of work on a queue manager connection. If AutoBackout
+is set, which is the default, then closing the Transaction with uncommitted messages
+backs them out.
+*/
+type Transaction struct {
+	AutoBackout bool
+
+	qMgr    *MQQueueManager
+	pending int
+	mutex   sync.Mutex
+}
+
+/*
+NewTransaction returns a Transaction that uses this connection
+*/
+func (x *MQQueueManager) NewTransaction() *Transaction {
+	return &Transaction{qMgr: x, AutoBackout: true}
+}
+
+/*
+Get retrieves a message under syncpoint. The syncpoint options in the MQGMO
+are replaced by MQGMO_SYNCPOINT, so this cannot be used for browsing.
+*/
+func (tx *Transaction) Get(object MQObject, gomd *MQMD, gogmo *MQGMO, buffer []byte) (int, error) {
+	gogmo.Options &^= MQGMO_NO_SYNCPOINT | MQGMO_SYNCPOINT_IF_PERSISTENT
+	gogmo.Options |= MQGMO_SYNCPOINT
+	datalen, err := object.Get(gomd, gogmo, buffer)
+	tx.count(err)
+	return datalen, err
+}
+
+/*
+GetSlice is the same as Get, but returns the buffer as a slice in the same way
+as MQObject.GetSlice
+*/
+func (tx *Transaction) GetSlice(object MQObject, gomd *MQMD, gogmo *MQGMO, buffer []byte) ([]byte, int, error) {
+	gogmo.Options &^= MQGMO_NO_SYNCPOINT | MQGMO_SYNCPOINT_IF_PERSISTENT
+	gogmo.Options |= MQGMO_SYNCPOINT
+	data, datalen, err := object.GetSlice(gomd, gogmo, buffer)
+	tx.count(err)
+	return data, datalen, err
+}
+
+/*
+Put sends a message under syncpoint. The syncpoint options in the MQPMO are
+replaced by MQPMO_SYNCPOINT.
+*/
+func (tx *Transaction) Put(object MQObject, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	gopmo.Options &^= MQPMO_NO_SYNCPOINT
+	gopmo.Options |= MQPMO_SYNCPOINT
+	err := object.Put(gomd, gopmo, buffer)
+	tx.count(err)
+	return err
+}
+
+/*
+Put1 sends a single message under syncpoint, without the application needing
+to open the queue
+*/
+func (tx *Transaction) Put1(good *MQOD, gomd *MQMD, gopmo *MQPMO, buffer []byte) error {
+	gopmo.Options &^= MQPMO_NO_SYNCPOINT
+	gopmo.Options |= MQPMO_SYNCPOINT
+	err := tx.qMgr.Put1(good, gomd, gopmo, buffer)
+	tx.count(err)
+	return err
+}
+
+/*
+Commit makes all the work in the unit of work permanent
+*/
+func (tx *Transaction) Commit() error {
+	err := tx.qMgr.Cmit()
+	tx.reset()
+	return err
+}
+
+/*
+Backout undoes all the work in the unit of work. Messages that were retrieved are
+put back on their queues, with their BackoutCount increased.
+*/
+func (tx *Transaction) Backout() error {
+	err := tx.qMgr.Back()
+	tx.reset()
+	return err
+}
+
+/*
+Pending returns the number of messages that have been got or put since the
+last Commit or Backout
+*/
+func (tx *Transaction) Pending() int {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	return tx.pending
+}
+
+/*
+Close checks that there is no outstanding work. If there is, ErrUncommittedWork is returned;
+when AutoBackout is set, the work has also been backed out. Any error from
+the backout itself is returned instead.
+*/
+func (tx *Transaction) Close() error {
+	if tx.Pending() == 0 {
+		return nil
+	}
+	if tx.AutoBackout {
+		if err := tx.Backout(); err != nil {
+			return err
+		}
+	}
+	return ErrUncommittedWork
+}
+
+// A message is part of the unit of work if the verb did not fail. Warnings
+// such as MQRC_TRUNCATED_MSG_ACCEPTED still transfer the message.
+func (tx *Transaction) count(err error) {
+	if err != nil {
+		if mqreturn, ok := err.(*MQReturn); !ok || mqreturn.MQCC == MQCC_FAILED {
+			return
+		}
+	}
+	tx.mutex.Lock()
+	tx.pending++
+	tx.mutex.Unlock()
+}
+
+// Commit and backout end the unit of work even when they fail. For example,
+// MQRC_BACKED_OUT from a commit means the work has been backed out, and if the
+// connection has been broken then the queue manager backs out the work itself.
+func (tx *Transaction) reset() {
+	tx.mutex.Lock()
+	tx.pending = 0
+	tx.mutex.Unlock()
+}
@@ -0,0 +1,621 @@
+package ibmmq
+
+/*
+  Copyright (c) IBM Corporation 2016, 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has the MQI structures that are used by the QMgrConnection and Object interfaces
+and by the mqmetric package, along with the functions that fill in their default values.
+None of it depends on the C library, so that these types, the PCF structures in mqiPCF.go,
+the interfaces and the in-memory queue manager in mqifake.go can all be built without cgo.
+The conversions to and from the C structures are in the mqiMQ*.go, mqiMHO.go and mqiMPO.go
+files.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+const space8 = "        "
+
+var endian binary.ByteOrder // Used by structure formatters such as MQCFH
+
+// This function is executed once before any other code in the package
+func init() {
+	if MQENC_NATIVE%2 == 0 {
+		endian = binary.LittleEndian
+	} else {
+		endian = binary.BigEndian
+	}
+}
+
+/*
+MQReturn holds the MQRC and MQCC values returned from an MQI verb. It
+implements the Error() function so is returned as the specific error
+from the verbs. See the sample programs for how to access the
+MQRC/MQCC values in this returned error.
+*/
+type MQReturn struct {
+	MQCC int32
+	MQRC int32
+	verb string
+}
+
+/*
+MQMD is a structure containing the MQ Message Descriptor (MQMD)
+*/
+type MQMD struct {
+	Version          int32
+	Report           int32
+	MsgType          int32
+	Expiry           int32
+	Feedback         int32
+	Encoding         int32
+	CodedCharSetId   int32
+	Format           string
+	Priority         int32
+	Persistence      int32
+	MsgId            []byte
+	CorrelId         []byte
+	BackoutCount     int32
+	ReplyToQ         string
+	ReplyToQMgr      string
+	UserIdentifier   string
+	AccountingToken  []byte
+	ApplIdentityData string
+	PutApplType      int32
+	PutApplName      string
+	PutDate          string    // Deprecated
+	PutTime          string    // Deprecated
+	PutDateTime      time.Time // Combines the PutDate and PutTime fields - takes precedence if both styles are used
+	ApplOriginData   string
+	GroupId          []byte
+	MsgSeqNumber     int32
+	Offset           int32
+	MsgFlags         int32
+	OriginalLength   int32
+}
+
+/*
+NewMQMD fills in default values for the MQMD structure
+*/
+func NewMQMD() *MQMD {
+	md := new(MQMD)
+	md.Version = MQMD_VERSION_1
+	md.Report = MQRO_NONE
+	md.MsgType = MQMT_DATAGRAM
+	md.Expiry = MQEI_UNLIMITED
+	md.Feedback = MQFB_NONE
+	md.Encoding = MQENC_NATIVE
+	md.CodedCharSetId = MQCCSI_Q_MGR
+	md.Format = space8
+	md.Priority = MQPRI_PRIORITY_AS_Q_DEF
+	md.Persistence = MQPER_PERSISTENCE_AS_Q_DEF
+	md.MsgId = bytes.Repeat([]byte{0}, int(MQ_MSG_ID_LENGTH))
+	md.CorrelId = bytes.Repeat([]byte{0}, int(MQ_CORREL_ID_LENGTH))
+	md.BackoutCount = 0
+	md.ReplyToQ = ""
+	md.ReplyToQMgr = ""
+	md.UserIdentifier = ""
+	md.AccountingToken = bytes.Repeat([]byte{0}, int(MQ_ACCOUNTING_TOKEN_LENGTH))
+	md.ApplIdentityData = ""
+	md.PutApplType = MQAT_NO_CONTEXT
+	md.PutApplName = ""
+	md.PutDate = ""
+	md.PutTime = ""
+	md.ApplOriginData = ""
+	md.GroupId = bytes.Repeat([]byte{0}, int(MQ_GROUP_ID_LENGTH))
+	md.MsgSeqNumber = 1
+	md.Offset = 0
+	md.MsgFlags = MQMF_NONE
+	md.OriginalLength = MQOL_UNDEFINED
+
+	return md
+}
+
+/*
+MQOD is a structure containing the MQ Object Descriptor (MQOD)
+*/
+type MQOD struct {
+	Version         int32
+	ObjectType      int32
+	ObjectName      string
+	ObjectQMgrName  string
+	DynamicQName    string
+	AlternateUserId string
+
+	// TODO: These fields are not currently mapped. The Dist List feature is not
+	// really supported here as Pub/Sub is the recommended approach.
+	//RecsPresent       int32
+	//KnownDestCount    int32
+	//UnknownDestCount  int32
+	//InvalidDestCount  int32
+	//ObjectRec     []MQOR
+	//ResponseRec   []MQOR
+
+	AlternateSecurityId []byte
+	ResolvedQName       string
+	ResolvedQMgrName    string
+
+	ObjectString    string
+	SelectionString string
+	ResObjectString string
+	ResolvedType    int32
+}
+
+/*
+NewMQOD fills in default values for the MQOD structure
+*/
+func NewMQOD() *MQOD {
+
+	od := new(MQOD)
+	od.Version = 1
+	od.ObjectType = MQOT_Q
+	od.ObjectName = ""
+	od.ObjectQMgrName = ""
+	od.DynamicQName = "AMQ.*"
+	od.AlternateUserId = ""
+
+	od.AlternateSecurityId = bytes.Repeat([]byte{0}, int(MQ_SECURITY_ID_LENGTH))
+	od.ResolvedQName = ""
+	od.ResolvedQMgrName = ""
+
+	od.ObjectString = ""
+	od.SelectionString = ""
+	od.ResObjectString = ""
+	od.ResolvedType = MQOT_NONE
+	return od
+}
+
+/*
+MQGMO is a structure containing the MQ Get Message Options (MQGMO)
+*/
+type MQGMO struct {
+	Version        int32
+	Options        int32
+	WaitInterval   int32
+	Signal1        int32
+	Signal2        int32
+	ResolvedQName  string
+	MatchOptions   int32
+	GroupStatus    rune
+	SegmentStatus  rune
+	Segmentation   rune
+	Reserved1      rune
+	MsgToken       []byte
+	ReturnedLength int32
+	Reserved2      int32
+	MsgHandle      MQMessageHandle
+}
+
+/*
+NewMQGMO fills in default values for the MQGMO structure
+*/
+func NewMQGMO() *MQGMO {
+
+	gmo := new(MQGMO)
+	gmo.Version = MQGMO_VERSION_1
+	gmo.Options = MQGMO_NO_WAIT + MQGMO_PROPERTIES_AS_Q_DEF
+	gmo.WaitInterval = MQWI_UNLIMITED
+	gmo.Signal1 = 0
+	gmo.Signal2 = 0
+	gmo.ResolvedQName = ""
+	gmo.MatchOptions = MQMO_MATCH_MSG_ID + MQMO_MATCH_CORREL_ID
+	gmo.GroupStatus = MQGS_NOT_IN_GROUP
+	gmo.SegmentStatus = MQSS_NOT_A_SEGMENT
+	gmo.Segmentation = MQSEG_INHIBITED
+	gmo.Reserved1 = ' '
+	gmo.MsgToken = bytes.Repeat([]byte{0}, int(MQ_MSG_TOKEN_LENGTH))
+	gmo.ReturnedLength = MQRL_UNDEFINED
+	gmo.Reserved2 = 0
+	// The zero value of a message handle is MQHM_NONE
+
+	return gmo
+}
+
+/*
+MQPMO is a structure containing the MQ Put MessageOptions (MQPMO)
+*/
+type MQPMO struct {
+	Version          int32
+	Options          int32
+	Timeout          int32
+	Context          *MQObject
+	KnownDestCount   int32
+	UnknownDestCount int32
+	InvalidDestCount int32
+	ResolvedQName    string
+	ResolvedQMgrName string
+
+	// TODO: These fields are not currently mapped. The Dist List feature is not
+	// fully supported as Pub/Sub is the recommended approach.
+	//RecsPresent       int32
+	//PutMsgRec   []MQPMR
+	//ResponseRec []MQRR
+
+	OriginalMsgHandle MQMessageHandle
+	NewMsgHandle      MQMessageHandle
+	Action            int32
+	PubLevel          int32
+}
+
+/*
+NewMQPMO fills in default values for the MQPMO structure
+*/
+func NewMQPMO() *MQPMO {
+
+	pmo := new(MQPMO)
+
+	pmo.Version = MQPMO_VERSION_1
+	pmo.Options = MQPMO_NONE
+	pmo.Timeout = -1
+	pmo.Context = nil
+	pmo.KnownDestCount = 0
+	pmo.UnknownDestCount = 0
+	pmo.InvalidDestCount = 0
+	pmo.ResolvedQName = ""
+	pmo.ResolvedQMgrName = ""
+
+	// The zero value of a message handle is MQHM_NONE
+	pmo.Action = MQACTP_NEW
+	pmo.PubLevel = 9
+
+	return pmo
+}
+
+/*
+MQSD is a structure containing the MQ Subscription Descriptor (MQSD)
+*/
+type MQSD struct {
+	Version int32
+	Options int32
+
+	ObjectName          string
+	AlternateUserId     string
+	AlternateSecurityId []byte
+	SubExpiry           int32
+	ObjectString        string
+	SubName             string
+	SubUserData         string
+	SubCorrelId         []byte
+
+	PubPriority        int32
+	PubAccountingToken []byte
+
+	PubApplIdentityData string
+
+	SelectionString string
+	SubLevel        int32 // 0-9. Subscribers at a higher level can intercept publications
+	ResObjectString string
+}
+
+/*
+NewMQSD fills in default values for the MQSD structure
+*/
+func NewMQSD() *MQSD {
+
+	sd := new(MQSD)
+
+	sd.Version = MQSD_VERSION_1
+	sd.Options = 0
+
+	sd.ObjectName = ""
+	sd.AlternateUserId = ""
+	sd.AlternateSecurityId = bytes.Repeat([]byte{0}, int(MQ_SECURITY_ID_LENGTH))
+	sd.SubExpiry = MQEI_UNLIMITED
+	sd.ObjectString = ""
+	sd.SubName = ""
+	sd.SubUserData = ""
+	sd.SubCorrelId = bytes.Repeat([]byte{0}, int(MQ_CORREL_ID_LENGTH))
+
+	sd.PubPriority = MQPRI_PRIORITY_AS_PUBLISHED
+	sd.PubAccountingToken = bytes.Repeat([]byte{0}, int(MQ_ACCOUNTING_TOKEN_LENGTH))
+
+	sd.PubApplIdentityData = ""
+
+	sd.SelectionString = ""
+	sd.SubLevel = 1
+	sd.ResObjectString = ""
+
+	return sd
+}
+
+/*
+BatchMessage is one of the messages returned by GetBatch. The Data slice
+refers to part of the buffer given to GetBatch, so it is only valid until
+that buffer is reused.
+*/
+type BatchMessage struct {
+	MD   *MQMD
+	Data []byte
+}
+
+/*
+MQCD is a structure containing the MQ Channel Definition (MQCD)
+Only fields relevant to a client connection are included in the
+Go version of this structure.
+*/
+type MQCD struct {
+	ChannelName          string
+	ConnectionName       string
+	DiscInterval         int32
+	SecurityExit         string
+	SecurityUserData     string
+	MaxMsgLength         int32
+	HeartbeatInterval    int32
+	SSLCipherSpec        string
+	SSLPeerName          string
+	SSLClientAuth        int32 // Not used by client, but leave field for compatibility
+	KeepAliveInterval    int32
+	SharingConversations int32
+	PropertyControl      int32
+	ClientChannelWeight  int32
+	ConnectionAffinity   int32
+	DefReconnect         int32
+	CertificateLabel     string
+	HdrCompList          [2]int32
+	MsgCompList          [16]int32
+	BatchHeartbeat       int32
+	BatchInterval        int32
+}
+
+/*
+NewMQCD fills in default values for the MQCD structure, based on the
+MQCD_CLIENT_CONN_DEFAULT
+*/
+func NewMQCD() *MQCD {
+
+	cd := new(MQCD)
+
+	cd.ChannelName = ""
+	cd.DiscInterval = 6000
+	cd.SecurityExit = ""
+	cd.SecurityUserData = ""
+	cd.MaxMsgLength = 4194304
+	cd.ConnectionName = ""
+	cd.HeartbeatInterval = 1
+	cd.SSLCipherSpec = ""
+	cd.SSLPeerName = ""
+	cd.SSLClientAuth = int32(MQSCA_REQUIRED)
+	cd.KeepAliveInterval = -1
+	cd.SharingConversations = 10
+	cd.PropertyControl = int32(MQPROP_COMPATIBILITY)
+	cd.ClientChannelWeight = 0
+	cd.ConnectionAffinity = int32(MQCAFTY_PREFERRED)
+	cd.DefReconnect = int32(MQRCN_NO)
+	cd.CertificateLabel = ""
+	cd.BatchHeartbeat = 0
+	cd.BatchInterval = 0
+
+	cd.HdrCompList[0] = int32(MQCOMPRESS_NONE)
+	for i := 1; i < 2; i++ {
+		cd.HdrCompList[i] = int32(MQCOMPRESS_NOT_AVAILABLE)
+	}
+	cd.MsgCompList[0] = int32(MQCOMPRESS_NONE)
+	for i := 1; i < 16; i++ {
+		cd.MsgCompList[i] = int32(MQCOMPRESS_NOT_AVAILABLE)
+	}
+
+	return cd
+}
+
+/*
+MQCNO is a structure containing the MQ Connection Options (MQCNO)
+Note that only a subset of the real structure is exposed in this
+version.
+*/
+type MQCNO struct {
+	Version       int32
+	Options       int32
+	SecurityParms *MQCSP
+	CCDTUrl       string
+	ClientConn    *MQCD
+	SSLConfig     *MQSCO
+	ApplName      string
+	BalanceParms  *MQBNO
+}
+
+/*
+MQCSP is a structure containing the MQ Security Parameters (MQCSP)
+*/
+type MQCSP struct {
+	AuthenticationType int32
+	UserId             string
+	Password           string
+	InitialKey         string
+	Token              string
+}
+
+/*
+MQBNO is a structure to allow an application provision of balancing options
+*/
+type MQBNO struct {
+	ApplType int32
+	Timeout  int32
+	Options  int32
+}
+
+/*
+NewMQCNO fills in default values for the MQCNO structure
+*/
+func NewMQCNO() *MQCNO {
+
+	cno := new(MQCNO)
+	cno.Version = int32(MQCNO_VERSION_1)
+	cno.Options = int32(MQCNO_NONE)
+	cno.SecurityParms = nil
+	cno.ClientConn = nil
+	cno.CCDTUrl = ""
+	cno.ApplName = ""
+
+	return cno
+}
+
+/*
+NewMQCSP fills in default values for the MQCSP structure
+*/
+func NewMQCSP() *MQCSP {
+
+	csp := new(MQCSP)
+	csp.AuthenticationType = int32(MQCSP_AUTH_NONE)
+	csp.UserId = ""
+	csp.Password = ""
+	csp.InitialKey = ""
+	csp.Token = ""
+
+	return csp
+}
+
+/*
+NewMQBNO fills in default values for the MQBNO structure. We
+use the constant values directly as the #define macros may not be
+available when building against older levels of the MQ client code.
+*/
+func NewMQBNO() *MQBNO {
+	bno := new(MQBNO)
+	bno.ApplType = 0 /* MQBNO_BALTYPE_SIMPLE */
+	bno.Timeout = -1 /* MQBNO_TIMEOUT_AS_DEFAULT */
+	bno.Options = 0  /* MQBNO_OPTIONS_NONE */
+
+	return bno
+}
+
+/*
+MQSCO is a structure containing the MQ SSL/TLS Configuration (MQSCO)
+options.
+*/
+type MQSCO struct {
+	KeyRepository          string
+	CryptoHardware         string
+	KeyResetCount          int32
+	FipsRequired           bool
+	EncryptionPolicySuiteB [4]int32
+	CertificateValPolicy   int32
+	CertificateLabel       string
+	KeyRepoPassword        string
+}
+
+/*
+NewMQSCO fills in default values for the MQSCO structure
+*/
+func NewMQSCO() *MQSCO {
+
+	sco := new(MQSCO)
+
+	sco.KeyRepository = ""
+	sco.CryptoHardware = ""
+	sco.KeyResetCount = int32(MQSCO_RESET_COUNT_DEFAULT)
+	sco.FipsRequired = false
+	sco.EncryptionPolicySuiteB[0] = int32(MQ_SUITE_B_NONE)
+	for i := 1; i < 4; i++ {
+		sco.EncryptionPolicySuiteB[i] = int32(MQ_SUITE_B_NOT_AVAILABLE)
+	}
+	sco.CertificateValPolicy = int32(MQ_CERT_VAL_POLICY_DEFAULT)
+	sco.CertificateLabel = ""
+	sco.KeyRepoPassword = ""
+
+	return sco
+}
+
+/*
+MQCMHO is a structure containing the MQ Create Message Handle Options
+*/
+type MQCMHO struct {
+	Options int32
+}
+
+/*
+MQDMHO is a structure containing the MQ Delete Message Handle Options
+*/
+type MQDMHO struct {
+	Options int32
+}
+
+/*
+NewMQCMHO fills in default values for the MQCMHO structure
+*/
+func NewMQCMHO() *MQCMHO {
+
+	cmho := new(MQCMHO)
+	cmho.Options = int32(MQCMHO_DEFAULT_VALIDATION)
+
+	return cmho
+}
+
+/*
+NewMQDMHO fills in default values for the MQDMHO structure
+*/
+func NewMQDMHO() *MQDMHO {
+	dmho := new(MQDMHO)
+	dmho.Options = int32(MQDMHO_NONE)
+	return dmho
+}
+
+type MQIMPO struct {
+	Options      int32
+	ReturnedName string
+	TypeString   string
+}
+
+type MQSMPO struct {
+	Options int32
+}
+
+type MQDMPO struct {
+	Options int32
+}
+
+type MQPD struct {
+	Options     int32
+	Support     int32
+	Context     int32
+	CopyOptions int32
+}
+
+func NewMQIMPO() *MQIMPO {
+	impo := new(MQIMPO)
+	impo.Options = int32(MQIMPO_NONE)
+	impo.ReturnedName = ""
+	impo.TypeString = ""
+
+	return impo
+}
+
+func NewMQDMPO() *MQDMPO {
+	dmpo := new(MQDMPO)
+	dmpo.Options = int32(MQDMPO_DEL_FIRST)
+	return dmpo
+}
+
+func NewMQSMPO() *MQSMPO {
+	smpo := new(MQSMPO)
+	smpo.Options = int32(MQSMPO_SET_FIRST)
+	return smpo
+}
+
+func NewMQPD() *MQPD {
+	pd := new(MQPD)
+	pd.Options = int32(MQPD_NONE)
+	pd.Support = int32(MQPD_SUPPORT_OPTIONAL)
+	pd.Context = int32(MQPD_NO_CONTEXT)
+	pd.CopyOptions = int32(MQCOPY_DEFAULT)
+	return pd
+}
@@ -0,0 +1,260 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has AlertSenders for the threshold alerts from thresholds.go:
+
+  - SyslogSender writes RFC 5424 messages to a syslog server over UDP or TCP
+  - SNMPTrapSender sends SNMPv2c traps over UDP
+
+The trap OIDs are under an enterprise OID that must be given, usually one already
+assigned by the site for its own monitoring. A breach is sent with the trap OID
+<enterprise>.0.1 and a clear with <enterprise>.0.2, and each trap has these variables:
+
+	<enterprise>.1  Rule name
+	<enterprise>.2  Queue manager name
+	<enterprise>.3  Object
+	<enterprise>.4  Metric name
+	<enterprise>.5  Value, as a string
+	<enterprise>.6  Description, as used in the syslog message
+*/
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syslog severities used for breaches and clears
+const (
+	syslogWarning = 4
+	syslogNotice  = 5
+)
+
+// The syslog facility used when none is given: local0
+const SYSLOG_FACILITY_DEFAULT = 16
+
+// How long to wait when sending a trap or syslog message
+const alertSendTimeout = 5 * time.Second
+
+// The sysUpTime in each trap is the time since the program started
+var alertStartTime = time.Now()
+
+var (
+	snmpSysUpTimeOID = []int{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	snmpTrapOIDOID   = []int{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+)
+
+// Text used for both kinds of alert
+func (a *ThresholdAlert) String() string {
+	if a.Breached {
+		return fmt.Sprintf("Rule %s breached for %s: %s is %g (%s %g)", a.Rule, a.Object, a.Metric, a.Value, a.Op, a.Limit)
+	}
+	return fmt.Sprintf("Rule %s cleared for %s: %s is %g", a.Rule, a.Object, a.Metric, a.Value)
+}
+
+/*
+SyslogSender writes alerts to a syslog server. The network is "udp" or "tcp".
+*/
+type SyslogSender struct {
+	Network  string
+	Address  string // Such as "loghost:514"
+	Facility int    // 0 uses SYSLOG_FACILITY_DEFAULT
+	AppName  string // Defaults to "mqmetric"
+}
+
+// SendAlert formats the alert as an RFC 5424 message
+func (s *SyslogSender) SendAlert(a *ThresholdAlert) error {
+	facility := s.Facility
+	if facility == 0 {
+		facility = SYSLOG_FACILITY_DEFAULT
+	}
+	severity := syslogNotice
+	if a.Breached {
+		severity = syslogWarning
+	}
+	appName := s.AppName
+	if appName == "" {
+		appName = "mqmetric"
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s %s", facility*8+severity, a.Time.Format(time.RFC3339), host, appName, os.Getpid(), a.QMgrName, a.String())
+	if s.Network == "tcp" {
+		// RFC 6587 octet counting
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	return sendAlertMessage(s.Network, s.Address, []byte(msg))
+}
+
+/*
+SNMPTrapSender sends alerts as SNMPv2c traps
+*/
+type SNMPTrapSender struct {
+	Address       string // Such as "nms:162"
+	Community     string // Defaults to "public"
+	EnterpriseOID string // Such as "1.3.6.1.4.1.99999.1"
+}
+
+// SendAlert builds and sends one trap
+func (s *SNMPTrapSender) SendAlert(a *ThresholdAlert) error {
+	b, err := s.trap(a, time.Since(alertStartTime))
+	if err != nil {
+		return err
+	}
+	return sendAlertMessage("udp", s.Address, b)
+}
+
+// Build the trap message
+func (s *SNMPTrapSender) trap(a *ThresholdAlert, upTime time.Duration) ([]byte, error) {
+	ent, err := parseOID(s.EnterpriseOID)
+	if err != nil {
+		return nil, err
+	}
+	community := s.Community
+	if community == "" {
+		community = "public"
+	}
+
+	trapOID := appendOID(ent, 0, 2)
+	if a.Breached {
+		trapOID = appendOID(ent, 0, 1)
+	}
+
+	vbs := berVarBind(snmpSysUpTimeOID, berTLV(0x43, berUint(uint64(upTime/(10*time.Millisecond))&0xffffffff)))
+	vbs = append(vbs, berVarBind(snmpTrapOIDOID, berOID(trapOID))...)
+	for i, v := range []string{a.Rule, a.QMgrName, a.Object, a.Metric, strconv.FormatFloat(a.Value, 'g', -1, 64), a.String()} {
+		vbs = append(vbs, berVarBind(appendOID(ent, i+1), berTLV(0x04, []byte(v)))...)
+	}
+
+	pdu := berInt(int64(rand.Int31()))
+	pdu = append(pdu, berInt(0)...) // error-status
+	pdu = append(pdu, berInt(0)...) // error-index
+	pdu = append(pdu, berTLV(0x30, vbs)...)
+
+	msg := berInt(1) // SNMPv2c
+	msg = append(msg, berTLV(0x04, []byte(community))...)
+	msg = append(msg, berTLV(0xA7, pdu)...)
+	return berTLV(0x30, msg), nil
+}
+
+func sendAlertMessage(network string, address string, b []byte) error {
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := net.DialTimeout(network, address, alertSendTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(alertSendTimeout))
+	_, err = conn.Write(b)
+	return err
+}
+
+// Convert "1.3.6.1..." to its numbers
+func parseOID(s string) ([]int, error) {
+	var oid []int
+	for _, p := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("OID '%s' is not valid", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 || oid[0] > 2 {
+		return nil, fmt.Errorf("OID '%s' is not valid", s)
+	}
+	return oid, nil
+}
+
+func appendOID(oid []int, l ...int) []int {
+	n := make([]int, 0, len(oid)+len(l))
+	n = append(n, oid...)
+	return append(n, l...)
+}
+
+// The BER encoding of the few ASN.1 types that a trap uses
+func berTLV(tag byte, v []byte) []byte {
+	b := []byte{tag}
+	l := len(v)
+	switch {
+	case l < 0x80:
+		b = append(b, byte(l))
+	case l < 0x100:
+		b = append(b, 0x81, byte(l))
+	default:
+		b = append(b, 0x82, byte(l>>8), byte(l))
+	}
+	return append(b, v...)
+}
+
+func berInt(n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berTLV(0x02, b)
+}
+
+// Unsigned values such as TimeTicks, without the tag
+func berUint(n uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func berOID(oid []int) []byte {
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var sub []byte
+		sub = append(sub, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			sub = append([]byte{byte(n&0x7f) | 0x80}, sub...)
+		}
+		b = append(b, sub...)
+	}
+	return berTLV(0x06, b)
+}
+
+func berVarBind(oid []int, value []byte) []byte {
+	return berTLV(0x30, append(berOID(oid), value...))
+}
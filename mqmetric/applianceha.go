@@ -0,0 +1,288 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Functions in this file report the state of a queue manager in an MQ Appliance HA group:
+its role on this appliance, whether the pair is in sync, the preferred and the actual
+appliance, and how far a resynchronisation has got. The appliance does not give this
+through PCF, so it is read from the output of the appliance "status <qmgr>" command,
+which has lines such as
+
+	HA role:                       Primary
+	HA status:                     Synchronization in progress
+	HA preferred location:         This appliance
+	HA synchronization progress:   54.6%
+
+How the output is obtained - for example over ssh, or through a file written by a
+script on the appliance - is left to the caller, in the ApplianceHAConfig. The
+collection is optional, and does nothing until SetApplianceHAConfig has been called.
+The results are in the OT_APPLIANCE_HA status set, keyed by queue manager name and
+labelled with the HA group name.
+*/
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	ATTR_APPLIANCE_HA_NAME  = "name"
+	ATTR_APPLIANCE_HA_GROUP = "group"
+
+	ATTR_APPLIANCE_HA_ROLE               = "role"
+	ATTR_APPLIANCE_HA_STATUS             = "status"
+	ATTR_APPLIANCE_HA_PREFERRED_LOCATION = "preferred_location"
+	ATTR_APPLIANCE_HA_CURRENT_LOCATION   = "current_location"
+	ATTR_APPLIANCE_HA_ON_PREFERRED       = "on_preferred"
+	ATTR_APPLIANCE_HA_SYNC_PROGRESS      = "sync_progress"
+)
+
+// Values for the role attribute
+const (
+	APPLIANCE_HA_ROLE_UNKNOWN   = 0
+	APPLIANCE_HA_ROLE_PRIMARY   = 1
+	APPLIANCE_HA_ROLE_SECONDARY = 2
+)
+
+// Values for the status attribute
+const (
+	APPLIANCE_HA_STATUS_UNKNOWN            = 0
+	APPLIANCE_HA_STATUS_NORMAL             = 1
+	APPLIANCE_HA_STATUS_SYNC_IN_PROGRESS   = 2
+	APPLIANCE_HA_STATUS_PARTITIONED        = 3
+	APPLIANCE_HA_STATUS_REMOTE_UNAVAILABLE = 4
+	APPLIANCE_HA_STATUS_INACTIVE           = 5
+	APPLIANCE_HA_STATUS_INCONSISTENT       = 6
+)
+
+// The names used by the appliance for this appliance and its partner
+const (
+	applianceHAThisAppliance  = "This appliance"
+	applianceHAOtherAppliance = "Other appliance"
+)
+
+// ApplianceHAConfig says where the HA status of the queue manager comes from
+type ApplianceHAConfig struct {
+	Group string // The HA group name, used as a label
+
+	// Returns the output of the appliance "status" command for the queue manager
+	Status func(qMgrName string) (string, error)
+}
+
+/*
+SetApplianceHAConfig sets how CollectApplianceHAStatus gets the status for the
+current connection.
+*/
+func SetApplianceHAConfig(cfg ApplianceHAConfig) {
+	traceEntry("SetApplianceHAConfig")
+
+	ci := getConnection(GetConnectionKey())
+	c := cfg
+	ci.applianceHA = &c
+
+	traceExit("SetApplianceHAConfig", 0)
+}
+
+/*
+Unlike the statistics produced via a topic, there is no discovery
+of the attributes available in object STATUS queries. So this function
+hardcodes the attributes we are going to look for and gives the associated
+descriptive text.
+*/
+func ApplianceHAInitAttributes() {
+	traceEntry("ApplianceHAInitAttributes")
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_APPLIANCE_HA]
+	st := GetObjectStatus(GetConnectionKey(), OT_APPLIANCE_HA)
+
+	if os.init {
+		traceExit("ApplianceHAInitAttributes", 1)
+		return
+	}
+	st.Attributes = make(map[string]*StatusAttribute)
+
+	// These fields are used to construct the key to the per-queue manager map values and
+	// as tags to identify the HA group
+	attr := ATTR_APPLIANCE_HA_NAME
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Queue Manager Name")
+	attr = ATTR_APPLIANCE_HA_GROUP
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "HA Group")
+	attr = ATTR_APPLIANCE_HA_PREFERRED_LOCATION
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Preferred Appliance")
+	attr = ATTR_APPLIANCE_HA_CURRENT_LOCATION
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Current Appliance")
+
+	attr = ATTR_APPLIANCE_HA_ROLE
+	st.Attributes[attr] = newStatusAttribute(attr, "HA Role", -1)
+	attr = ATTR_APPLIANCE_HA_STATUS
+	st.Attributes[attr] = newStatusAttribute(attr, "HA Status", -1)
+	attr = ATTR_APPLIANCE_HA_ON_PREFERRED
+	st.Attributes[attr] = newStatusAttribute(attr, "Running on Preferred Appliance", -1)
+	attr = ATTR_APPLIANCE_HA_SYNC_PROGRESS
+	st.Attributes[attr] = newStatusAttribute(attr, "Synchronization Progress (%)", -1)
+
+	os.init = true
+	traceExit("ApplianceHAInitAttributes", 0)
+}
+
+/*
+CollectApplianceHAStatus gets the status output for the queue manager and extracts the
+HA fields. It does nothing if SetApplianceHAConfig has not been called. A queue manager
+that is not in an HA group has no HA fields in its status, so nothing is reported for it.
+*/
+func CollectApplianceHAStatus() error {
+	traceEntry("CollectApplianceHAStatus")
+
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_APPLIANCE_HA]
+	st := GetObjectStatus(GetConnectionKey(), OT_APPLIANCE_HA)
+	ApplianceHAInitAttributes()
+
+	// Empty any collected values
+	statusClearValues(st)
+	os.objectSeen = make(map[string]bool)
+
+	cfg := ci.applianceHA
+	if cfg == nil || cfg.Status == nil {
+		traceExit("CollectApplianceHAStatus", 1)
+		return nil
+	}
+
+	out, err := cfg.Status(ci.si.resolvedQMgrName)
+	if err != nil {
+		logError("Cannot get the HA status of queue manager %s: %v", ci.si.resolvedQMgrName, err)
+		traceExitErr("CollectApplianceHAStatus", 2, err)
+		return err
+	}
+
+	key := parseApplianceHAStatus(st, out, cfg.Group, ci.si.resolvedQMgrName)
+	if key != "" {
+		os.objectSeen[key] = true
+	}
+
+	statusPostCollect(OT_APPLIANCE_HA)
+	traceExitF("CollectApplianceHAStatus", 0, "Key: %s", key)
+	return nil
+}
+
+// Store the HA fields from the status output, returning the key used for them. The
+// key is empty if there are no HA fields.
+func parseApplianceHAStatus(st *StatusSet, out string, group string, qMgrName string) string {
+	fields := make(map[string]string)
+	for _, l := range strings.Split(out, "\n") {
+		idx := strings.Index(l, ":")
+		if idx < 0 {
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(l[:idx]))] = strings.TrimSpace(l[idx+1:])
+	}
+
+	role, ok := fields["ha role"]
+	if !ok {
+		return ""
+	}
+	if n := fields["queue manager name"]; n != "" {
+		qMgrName = n
+	}
+	if group == "" {
+		group = DUMMY_STRING
+	}
+	key := qMgrName
+
+	st.Attributes[ATTR_APPLIANCE_HA_NAME].Values[key] = newStatusValueString(qMgrName)
+	st.Attributes[ATTR_APPLIANCE_HA_GROUP].Values[key] = newStatusValueString(group)
+
+	roleValue := int64(APPLIANCE_HA_ROLE_UNKNOWN)
+	switch strings.ToLower(role) {
+	case "primary":
+		roleValue = APPLIANCE_HA_ROLE_PRIMARY
+	case "secondary":
+		roleValue = APPLIANCE_HA_ROLE_SECONDARY
+	}
+	st.Attributes[ATTR_APPLIANCE_HA_ROLE].Values[key] = newStatusValueInt64(roleValue)
+
+	status := applianceHAStatusValue(fields["ha status"])
+	st.Attributes[ATTR_APPLIANCE_HA_STATUS].Values[key] = newStatusValueInt64(status)
+
+	// Older levels do not show the current location, but the role on this appliance says where
+	// the queue manager is running
+	current := fields["ha current location"]
+	if current == "" {
+		switch roleValue {
+		case APPLIANCE_HA_ROLE_PRIMARY:
+			current = applianceHAThisAppliance
+		case APPLIANCE_HA_ROLE_SECONDARY:
+			current = applianceHAOtherAppliance
+		}
+	}
+	preferred := fields["ha preferred location"]
+	if current != "" {
+		st.Attributes[ATTR_APPLIANCE_HA_CURRENT_LOCATION].Values[key] = newStatusValueString(current)
+	}
+	if preferred != "" {
+		st.Attributes[ATTR_APPLIANCE_HA_PREFERRED_LOCATION].Values[key] = newStatusValueString(preferred)
+	}
+	// There may be no preferred location, shown as "None"
+	if current != "" && preferred != "" && !strings.EqualFold(preferred, "none") {
+		onPreferred := int64(0)
+		if strings.EqualFold(current, preferred) {
+			onPreferred = 1
+		}
+		st.Attributes[ATTR_APPLIANCE_HA_ON_PREFERRED].Values[key] = newStatusValueInt64(onPreferred)
+	}
+
+	// The progress is only shown while synchronising. When the pair is in sync, it is complete.
+	if p, ok := fields["ha synchronization progress"]; ok {
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64); err == nil {
+			st.Attributes[ATTR_APPLIANCE_HA_SYNC_PROGRESS].Values[key] = newStatusValueFloat64(f)
+		}
+	} else if status == APPLIANCE_HA_STATUS_NORMAL {
+		st.Attributes[ATTR_APPLIANCE_HA_SYNC_PROGRESS].Values[key] = newStatusValueFloat64(100)
+	}
+
+	return key
+}
+
+func applianceHAStatusValue(s string) int64 {
+	s = strings.ToLower(s)
+	switch {
+	case s == "normal":
+		return APPLIANCE_HA_STATUS_NORMAL
+	case strings.HasPrefix(s, "synchronization in progress"):
+		return APPLIANCE_HA_STATUS_SYNC_IN_PROGRESS
+	case s == "partitioned":
+		return APPLIANCE_HA_STATUS_PARTITIONED
+	case strings.HasPrefix(s, "remote appliance"):
+		return APPLIANCE_HA_STATUS_REMOTE_UNAVAILABLE
+	case s == "inactive":
+		return APPLIANCE_HA_STATUS_INACTIVE
+	case s == "inconsistent":
+		return APPLIANCE_HA_STATUS_INCONSISTENT
+	}
+	return APPLIANCE_HA_STATUS_UNKNOWN
+}
+
+// Return a standardised value. If the attribute indicates that something
+// special has to be done, then do that. Otherwise just make sure it's a non-negative
+// value of the correct datatype
+func ApplianceHANormalise(attr *StatusAttribute, v int64) float64 {
+	return statusNormalise(attr, v)
+}
@@ -0,0 +1,226 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file keeps a copy of every publication that ProcessPublications reads. It is
+useful when the numbers reported by a collector do not seem to match what MQ says,
+as the raw data can be looked at afterwards.
+
+The archive can be a file or a queue. A file uses the same JSON format as a recording
+(see replay.go), with the topic and the time of each publication, and markers for each
+call to ProcessPublications. It does not contain the metadata, so it cannot be given
+to InitReplay by itself. On a queue, each message is the original PCF data with the
+topic in the "mqmetric.topic" message property.
+
+Unlike a recording, the archive is kept going while the collector runs, so it should
+be used for limited periods, or the file or queue emptied regularly.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// The message property that holds the topic when archiving to a queue
+const ArchiveTopicProperty = "mqmetric.topic"
+
+type pubArchive struct {
+	sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	qObj  ibmmq.Object
+	hMsg  ibmmq.MQMessageHandle
+	queue bool
+}
+
+/*
+StartArchive creates the named file and writes every publication to it. The
+connection must already have been made.
+*/
+func StartArchive(fileName string) error {
+	traceEntryF("StartArchive", "File: %s", fileName)
+
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || !ci.si.qmgrConnected {
+		err := fmt.Errorf("Not connected to a queue manager")
+		traceExitErr("StartArchive", 1, err)
+		return err
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		traceExitErr("StartArchive", 2, err)
+		return err
+	}
+
+	a := &pubArchive{f: f, enc: json.NewEncoder(f)}
+	err = a.enc.Encode(&ReplayRecord{Kind: REPLAY_HEADER,
+		Time:         time.Now(),
+		QMgrName:     ci.si.resolvedQMgrName,
+		Platform:     ci.si.platform,
+		CommandLevel: ci.si.commandLevel})
+	if err != nil {
+		f.Close()
+		traceExitErr("StartArchive", 3, err)
+		return err
+	}
+	ci.archive = a
+
+	traceExit("StartArchive", 0)
+	return nil
+}
+
+/*
+StartArchiveQueue puts a copy of every publication to the named queue. The
+connection must already have been made.
+*/
+func StartArchiveQueue(qName string) error {
+	traceEntryF("StartArchiveQueue", "Queue: %s", qName)
+
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || !ci.si.qmgrConnected {
+		err := fmt.Errorf("Not connected to a queue manager")
+		traceExitErr("StartArchiveQueue", 1, err)
+		return err
+	}
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = qName
+	qObj, err := ci.si.qMgr.Open(mqod, ibmmq.MQOO_OUTPUT|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		traceExitErr("StartArchiveQueue", 2, err)
+		return err
+	}
+
+	hMsg, err := crtMH(ci.si.qMgr)
+	if err != nil {
+		qObj.Close(0)
+		traceExitErr("StartArchiveQueue", 3, err)
+		return err
+	}
+
+	ci.archive = &pubArchive{qObj: qObj, hMsg: hMsg, queue: true}
+
+	traceExit("StartArchiveQueue", 0)
+	return nil
+}
+
+/*
+StopArchive closes the file or queue used for the archive. It is not an
+error to call it when there is no active archive.
+*/
+func StopArchive() error {
+	var err error
+
+	traceEntry("StopArchive")
+
+	ci := getConnection(GetConnectionKey())
+	if ci != nil && ci.archive != nil {
+		a := ci.archive
+		a.Lock()
+		if a.queue {
+			a.hMsg.DltMH(ibmmq.NewMQDMHO())
+			err = a.qObj.Close(0)
+		} else {
+			err = a.f.Close()
+		}
+		a.Unlock()
+		ci.archive = nil
+	}
+
+	traceExitErr("StopArchive", 0, err)
+	return err
+}
+
+// Mark the start of a ProcessPublications call in an archive file
+func (a *pubArchive) interval() {
+	if a == nil || a.queue {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if err := a.enc.Encode(&ReplayRecord{Kind: REPLAY_INTERVAL, Time: time.Now()}); err != nil {
+		logError("Cannot write to archive file %s: %v", a.f.Name(), err)
+	}
+}
+
+// Save a publication. As with recordings, failures are logged but do not
+// stop the collection.
+func (a *pubArchive) publication(topic string, data []byte) {
+	var err error
+
+	if a == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.queue {
+		putmqmd := ibmmq.NewMQMD()
+		putmqmd.Format = ibmmq.MQFMT_ADMIN
+		pmo := ibmmq.NewMQPMO()
+		pmo.Options = ibmmq.MQPMO_NO_SYNCPOINT | ibmmq.MQPMO_FAIL_IF_QUIESCING
+		pmo.OriginalMsgHandle = a.hMsg
+
+		err = a.hMsg.SetMP(ibmmq.NewMQSMPO(), ArchiveTopicProperty, ibmmq.NewMQPD(), topic)
+		if err == nil {
+			err = a.qObj.Put(putmqmd, pmo, data)
+		}
+		if err != nil {
+			logError("Cannot put to archive queue %s: %v", a.qObj.ObjectName(), err)
+		}
+		return
+	}
+
+	// Take a copy as the data is in a reused buffer
+	d := make([]byte, len(data))
+	copy(d, data)
+	err = a.enc.Encode(&ReplayRecord{Kind: REPLAY_PUB, Time: time.Now(), Topic: topic, Data: d})
+	if err != nil {
+		logError("Cannot write to archive file %s: %v", a.f.Name(), err)
+	}
+}
+
+// Work out which topic a publication came from, using the class and type
+// in the message
+func publicationTopic(metrics *AllMetrics, classidx int, typeidx int, objName string) string {
+	cl, ok := metrics.Classes[classidx]
+	if !ok {
+		return ""
+	}
+	ty, ok := cl.Types[typeidx]
+	if !ok {
+		return ""
+	}
+	if strings.Contains(ty.ObjectTopic, "%s") {
+		return fmt.Sprintf(ty.ObjectTopic, objName)
+	}
+	return ty.ObjectTopic
+}
@@ -0,0 +1,324 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Functions in this file count the authentication and authorisation failures that the
+queue manager reports, so that trends can be seen alongside the other metrics. There are
+two sources:
+
+  - The collector's own MQI calls. Whenever a connect, open, subscribe, put, get or
+    command fails with one of the security-related reason codes such as 2035
+    (MQRC_NOT_AUTHORIZED), 2393 (MQRC_SSL_INITIALIZATION_ERROR) or 2538
+    (MQRC_HOST_NOT_AVAILABLE), it is counted against the operation.
+  - Authority events from the queue manager's event queue, when AUTHOREV is enabled and
+    SetAuthEventQueue has been called. These cover every application, not just the
+    collector, and are counted against the operation given by the event's reason
+    qualifier.
+
+The counts are cumulative from the start of the program, and are not reset when the
+collector reconnects. The results are in the OT_AUTH status set, keyed by
+source/operation/reason.
+
+Events are read from SYSTEM.ADMIN.QMGR.EVENT unless another queue is named. As with the
+command events, messages are removed from the queue by default. Other queue manager events
+on the same queue are also removed and ignored, so browsing may be more suitable if another
+tool processes them. A browsed queue is read from the start after each reconnection, which
+may count some events twice.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const (
+	ATTR_AUTH_SOURCE      = "source"
+	ATTR_AUTH_OPERATION   = "operation"
+	ATTR_AUTH_REASON      = "reason"
+	ATTR_AUTH_REASON_CODE = "reason_code"
+	ATTR_AUTH_FAILURES    = "failures"
+
+	// Values of the source attribute
+	AUTH_SOURCE_COLLECTOR = "collector"
+	AUTH_SOURCE_QMGR      = "qmgr"
+
+	// Operations for the collector's own calls. Events can also
+	// report "close", "system_connect" and "connect_authentication".
+	AUTH_OP_CONNECT   = "connect"
+	AUTH_OP_OPEN      = "open"
+	AUTH_OP_SUBSCRIBE = "subscribe"
+	AUTH_OP_PUT       = "put"
+	AUTH_OP_GET       = "get"
+	AUTH_OP_COMMAND   = "command"
+)
+
+// DefaultAuthEventQueue is the queue that the queue manager puts authority events to
+const DefaultAuthEventQueue = "SYSTEM.ADMIN.QMGR.EVENT"
+
+// The reason codes that are counted. The names are held here rather than
+// using MQItoString as this is a short fixed list.
+var authReasonNames = map[int32]string{
+	ibmmq.MQRC_NOT_AUTHORIZED:           "MQRC_NOT_AUTHORIZED",
+	ibmmq.MQRC_SECURITY_ERROR:           "MQRC_SECURITY_ERROR",
+	ibmmq.MQRC_KEY_REPOSITORY_ERROR:     "MQRC_KEY_REPOSITORY_ERROR",
+	ibmmq.MQRC_SSL_INITIALIZATION_ERROR: "MQRC_SSL_INITIALIZATION_ERROR",
+	ibmmq.MQRC_SSL_NOT_ALLOWED:          "MQRC_SSL_NOT_ALLOWED",
+	ibmmq.MQRC_SSL_PEER_NAME_MISMATCH:   "MQRC_SSL_PEER_NAME_MISMATCH",
+	ibmmq.MQRC_SSL_CERTIFICATE_REVOKED:  "MQRC_SSL_CERTIFICATE_REVOKED",
+	ibmmq.MQRC_HOST_NOT_AVAILABLE:       "MQRC_HOST_NOT_AVAILABLE",
+}
+
+// Map the reason qualifier in a not-authorized event to an operation
+var authQualifierOps = map[int32]string{
+	ibmmq.MQRQ_CONN_NOT_AUTHORIZED:     AUTH_OP_CONNECT,
+	ibmmq.MQRQ_OPEN_NOT_AUTHORIZED:     AUTH_OP_OPEN,
+	ibmmq.MQRQ_CLOSE_NOT_AUTHORIZED:    "close",
+	ibmmq.MQRQ_CMD_NOT_AUTHORIZED:      AUTH_OP_COMMAND,
+	ibmmq.MQRQ_SUB_NOT_AUTHORIZED:      AUTH_OP_SUBSCRIBE,
+	ibmmq.MQRQ_SUB_DEST_NOT_AUTHORIZED: AUTH_OP_SUBSCRIBE,
+	ibmmq.MQRQ_SYS_CONN_NOT_AUTHORIZED: "system_connect",
+	ibmmq.MQRQ_CSP_NOT_AUTHORIZED:      "connect_authentication",
+}
+
+type authFailureKey struct {
+	source    string
+	operation string
+	reason    int32
+}
+
+// The counts are held outside the connectionInfo, which is replaced on each reconnection
+var authFailures = struct {
+	sync.Mutex
+	counts map[string]map[authFailureKey]int64
+}{counts: make(map[string]map[authFailureKey]int64)}
+
+type authEventState struct {
+	qName  string
+	browse bool
+	qObj   ibmmq.Object
+	opened bool
+}
+
+/*
+SetAuthEventQueue enables counting of authority events for the current connection.
+An empty qName means that the DefaultAuthEventQueue is used. The queue is opened on the
+next call to CollectAuthStatus, and stays open until EndConnection.
+*/
+func SetAuthEventQueue(qName string, browse bool) {
+	traceEntry("SetAuthEventQueue")
+	ci := getConnection(GetConnectionKey())
+	if qName == "" {
+		qName = DefaultAuthEventQueue
+	}
+	closeAuthEventQueue(ci)
+	ci.authEvents = &authEventState{qName: qName, browse: browse}
+	traceExit("SetAuthEventQueue", 0)
+}
+
+// Count a failed MQI call made by the collector, if the error is security-related
+func recordAuthFailure(operation string, err error) {
+	var mqreturn *ibmmq.MQReturn
+
+	switch e := err.(type) {
+	case *ibmmq.MQReturn:
+		mqreturn = e
+	case MQMetricError:
+		mqreturn = e.MQReturn
+	}
+	if mqreturn == nil {
+		return
+	}
+	countAuthFailure(GetConnectionKey(), AUTH_SOURCE_COLLECTOR, operation, mqreturn.MQRC)
+}
+
+func countAuthFailure(key string, source string, operation string, reason int32) {
+	if _, ok := authReasonNames[reason]; !ok {
+		return
+	}
+	authFailures.Lock()
+	defer authFailures.Unlock()
+	if authFailures.counts[key] == nil {
+		authFailures.counts[key] = make(map[authFailureKey]int64)
+	}
+	authFailures.counts[key][authFailureKey{source, operation, reason}]++
+}
+
+func authFailureCounts(key string) map[authFailureKey]int64 {
+	authFailures.Lock()
+	defer authFailures.Unlock()
+	counts := make(map[authFailureKey]int64)
+	for k, v := range authFailures.counts[key] {
+		counts[k] = v
+	}
+	return counts
+}
+
+/*
+Unlike the statistics produced via a topic, there is no discovery
+of the attributes available in object STATUS queries. So this function
+hardcodes the attributes we are going to look for and gives the associated
+descriptive text.
+*/
+func AuthInitAttributes() {
+	traceEntry("AuthInitAttributes")
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_AUTH]
+	st := GetObjectStatus(GetConnectionKey(), OT_AUTH)
+
+	if os.init {
+		traceExit("AuthInitAttributes", 1)
+		return
+	}
+	st.Attributes = make(map[string]*StatusAttribute)
+
+	attr := ATTR_AUTH_SOURCE
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Source")
+	attr = ATTR_AUTH_OPERATION
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Operation")
+	attr = ATTR_AUTH_REASON
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Reason")
+
+	attr = ATTR_AUTH_REASON_CODE
+	st.Attributes[attr] = newStatusAttribute(attr, "Reason Code", -1)
+	attr = ATTR_AUTH_FAILURES
+	st.Attributes[attr] = newStatusAttribute(attr, "Failures", -1)
+
+	os.init = true
+	traceExit("AuthInitAttributes", 0)
+}
+
+/*
+CollectAuthStatus reads any new authority events if SetAuthEventQueue has been called,
+and then reports the failure counts for the current connection. An error reading the
+event queue is returned, but the counts are still reported.
+*/
+func CollectAuthStatus() error {
+	var err error
+	traceEntry("CollectAuthStatus")
+
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), OT_AUTH)
+	AuthInitAttributes()
+
+	// Empty any collected values
+	statusClearValues(st)
+
+	if ci.authEvents != nil && ci.replay == nil {
+		err = readAuthEvents(ci)
+		if err != nil {
+			logError("Cannot read authority events: %v", err)
+		}
+	}
+
+	for k, count := range authFailureCounts(GetConnectionKey()) {
+		key := k.source + "/" + k.operation + "/" + strconv.Itoa(int(k.reason))
+		st.Attributes[ATTR_AUTH_SOURCE].Values[key] = newStatusValueString(k.source)
+		st.Attributes[ATTR_AUTH_OPERATION].Values[key] = newStatusValueString(k.operation)
+		st.Attributes[ATTR_AUTH_REASON].Values[key] = newStatusValueString(authReasonNames[k.reason])
+		st.Attributes[ATTR_AUTH_REASON_CODE].Values[key] = newStatusValueInt64(int64(k.reason))
+		st.Attributes[ATTR_AUTH_FAILURES].Values[key] = newStatusValueInt64(count)
+	}
+
+	statusPostCollect(OT_AUTH)
+	traceExitErr("CollectAuthStatus", 0, err)
+	return err
+}
+
+// Read everything currently on the event queue, counting the not-authorized events
+func readAuthEvents(ci *connectionInfo) error {
+	var err error
+	s := ci.authEvents
+
+	if !s.opened {
+		mqod := ibmmq.NewMQOD()
+		mqod.ObjectType = ibmmq.MQOT_Q
+		mqod.ObjectName = s.qName
+		openOptions := ibmmq.MQOO_FAIL_IF_QUIESCING
+		if s.browse {
+			openOptions |= ibmmq.MQOO_BROWSE
+		} else {
+			openOptions |= ibmmq.MQOO_INPUT_SHARED
+		}
+		s.qObj, err = ci.si.qMgr.Open(mqod, openOptions)
+		if err != nil {
+			return MQMetricError{Err: fmt.Sprintf("Cannot open queue %s", s.qName), MQReturn: err.(*ibmmq.MQReturn)}
+		}
+		s.opened = true
+	}
+
+	buf := make([]byte, 0, 32768)
+	for {
+		md := ibmmq.NewMQMD()
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_FAIL_IF_QUIESCING | ibmmq.MQGMO_CONVERT
+		if s.browse {
+			gmo.Options |= ibmmq.MQGMO_BROWSE_NEXT
+		}
+
+		var datalen int
+		buf, datalen, err = s.qObj.GetSlice(md, gmo, buf[:0])
+		if err != nil {
+			mqreturn := err.(*ibmmq.MQReturn)
+			if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				return nil
+			}
+			if mqreturn.MQRC == ibmmq.MQRC_TRUNCATED_MSG_FAILED {
+				buf = make([]byte, 0, datalen)
+				continue
+			}
+			return MQMetricError{Err: "Cannot get authority event", MQReturn: mqreturn}
+		}
+
+		if op, ok := parseAuthEvent(buf); ok {
+			countAuthFailure(GetConnectionKey(), AUTH_SOURCE_QMGR, op, ibmmq.MQRC_NOT_AUTHORIZED)
+		}
+	}
+}
+
+// Return the operation for a not-authorized event. Other messages return false.
+func parseAuthEvent(buf []byte) (string, bool) {
+	cfh, offset := ibmmq.ReadPCFHeader(buf)
+	if cfh == nil || cfh.Type != ibmmq.MQCFT_EVENT || cfh.Command != ibmmq.MQCMD_Q_MGR_EVENT || cfh.Reason != ibmmq.MQRC_NOT_AUTHORIZED {
+		return "", false
+	}
+
+	op := "unknown"
+	for i := 0; i < int(cfh.ParameterCount) && offset < len(buf); i++ {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		if elem.Parameter == ibmmq.MQIACF_REASON_QUALIFIER && len(elem.Int64Value) > 0 {
+			if o, ok := authQualifierOps[int32(elem.Int64Value[0])]; ok {
+				op = o
+			}
+		}
+	}
+	return op, true
+}
+
+func closeAuthEventQueue(ci *connectionInfo) {
+	if ci.authEvents != nil && ci.authEvents.opened {
+		ci.authEvents.qObj.Close(0)
+		ci.authEvents.opened = false
+	}
+}
@@ -0,0 +1,106 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lets a locally-bound collector choose how it is connected to the queue manager.
+
+STANDARD and ISOLATED run the application in a separate process from the queue manager
+agent; SHARED lets them share resources. FASTPATH runs the MQI code in the application's
+own process, without an agent, which has the lowest overhead. But a fastpath application
+is trusted: a program bug, or a signal that stops it at the wrong moment, can damage the
+queue manager's memory and stop the queue manager. So FASTPATH is only accepted after the
+program has called AllowFastpathBindings, which should be a deliberate decision rather
+than something turned on from a configuration file alone. A fastpath application must
+also not be stopped with "kill -9", and must disconnect before exiting.
+
+The option is ignored by the queue manager unless MQ_CONNECT_TYPE is unset or set to
+FASTPATH, and it cannot be used with client connections.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// Values for the Bindings in the ConnectionConfig
+const (
+	BINDINGS_DEFAULT  = ""
+	BINDINGS_STANDARD = "STANDARD"
+	BINDINGS_SHARED   = "SHARED"
+	BINDINGS_ISOLATED = "ISOLATED"
+	BINDINGS_FASTPATH = "FASTPATH"
+)
+
+var bindingNames = map[string]int32{
+	BINDINGS_STANDARD: ibmmq.MQCNO_STANDARD_BINDING,
+	BINDINGS_SHARED:   ibmmq.MQCNO_SHARED_BINDING,
+	BINDINGS_ISOLATED: ibmmq.MQCNO_ISOLATED_BINDING,
+	BINDINGS_FASTPATH: ibmmq.MQCNO_FASTPATH_BINDING,
+}
+
+var fastpath struct {
+	sync.Mutex
+	allowed bool
+}
+
+/*
+AllowFastpathBindings must be called before a connection can use BINDINGS_FASTPATH.
+It applies to all connections made by the program.
+*/
+func AllowFastpathBindings() {
+	fastpath.Lock()
+	fastpath.allowed = true
+	fastpath.Unlock()
+	logInfo("Fastpath bindings have been allowed")
+}
+
+// Is this configuration going to make a client connection
+func isClientConfig(cc *ConnectionConfig) bool {
+	return cc.ClientMode || cc.CcdtUrl != "" || len(cc.Endpoints) > 0 || cc.ConnName != "" || cc.Channel != ""
+}
+
+// Convert the option to the MQCNO value, checking that it can be used
+func checkBindings(cc *ConnectionConfig) (int32, error) {
+	b := strings.ToUpper(strings.TrimSpace(cc.Bindings))
+	if b == BINDINGS_DEFAULT {
+		return ibmmq.MQCNO_STANDARD_BINDING, nil
+	}
+
+	v, ok := bindingNames[b]
+	if !ok {
+		return 0, fmt.Errorf("Bindings '%s' is not valid. Use STANDARD, SHARED, ISOLATED or FASTPATH", cc.Bindings)
+	}
+	if isClientConfig(cc) {
+		return 0, fmt.Errorf("Bindings cannot be set for a client connection")
+	}
+	if b == BINDINGS_FASTPATH {
+		fastpath.Lock()
+		allowed := fastpath.allowed
+		fastpath.Unlock()
+		if !allowed {
+			return 0, fmt.Errorf("Fastpath bindings can only be used after calling AllowFastpathBindings")
+		}
+	}
+	return v, nil
+}
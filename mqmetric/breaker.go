@@ -0,0 +1,154 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file stops the status collection from waiting on a command server that is not
+answering. Each PCF command normally waits for up to the WaitInterval for its reply, and a
+collection can send many commands, so a stopped or overloaded command server turns every
+scrape into a long series of timeouts.
+
+With CommandTimeoutLimit set in the ConnectionConfig, that many commands in a row without
+any reply trips the breaker. Status collection is then reported as degraded, and for
+CommandCoolDown seconds (default 60) statusPutCommand fails at once with
+MQRC_CMD_SERVER_NOT_AVAILABLE instead of sending the command. The published metrics do not
+need the command server, so ProcessPublications carries on as normal.
+
+After the cool-down, commands are sent again. The first reply closes the breaker; a timeout
+opens it for another cool-down without waiting for the full count.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const defaultCommandCoolDown = 60 * time.Second
+
+type commandBreaker struct {
+	sync.Mutex
+	limit    int
+	coolDown time.Duration
+	timeouts int       // Consecutive commands without a reply
+	open     bool      // Commands are being refused
+	trial    bool      // The cool-down has ended but no reply has been seen yet
+	since    time.Time // When the breaker last opened
+	retryAt  time.Time
+	trips    int64
+}
+
+/*
+CommandServerHealth shows whether the status collection is being skipped because the
+command server has stopped replying
+*/
+type CommandServerHealth struct {
+	Degraded bool      // Commands are not being sent
+	Since    time.Time // When the breaker last opened
+	RetryAt  time.Time // When commands will be tried again
+	Timeouts int       // Consecutive commands that have had no reply
+	Trips    int64     // How many times the breaker has opened on this connection
+}
+
+// Returns nil if the breaker is not configured
+func newCommandBreaker(limit int, coolDownSecs int) *commandBreaker {
+	if limit <= 0 {
+		return nil
+	}
+	b := &commandBreaker{limit: limit, coolDown: defaultCommandCoolDown}
+	if coolDownSecs > 0 {
+		b.coolDown = time.Duration(coolDownSecs) * time.Second
+	}
+	return b
+}
+
+// Whether a command can be sent now
+func (b *commandBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if now.Before(b.retryAt) {
+		return false
+	}
+	logInfo("Command server cool-down has ended. Trying status commands again")
+	b.open = false
+	b.trial = true
+	return true
+}
+
+// Record whether a command got a reply
+func (b *commandBreaker) result(replied bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if replied {
+		if b.trial || b.timeouts > 0 {
+			logDebug("Command server is replying")
+		}
+		b.timeouts = 0
+		b.trial = false
+		return
+	}
+
+	b.timeouts++
+	if b.open || (!b.trial && b.timeouts < b.limit) {
+		return
+	}
+	b.open = true
+	b.trial = false
+	b.since = now
+	b.retryAt = now.Add(b.coolDown)
+	b.trips++
+	logWarn("Command server has not replied to %d commands. Status collection is suspended until %s",
+		b.timeouts, b.retryAt.Format(time.RFC3339))
+}
+
+func (b *commandBreaker) health() CommandServerHealth {
+	b.Lock()
+	defer b.Unlock()
+	return CommandServerHealth{Degraded: b.open || b.trial, Since: b.since, RetryAt: b.retryAt, Timeouts: b.timeouts, Trips: b.trips}
+}
+
+// The error returned in place of sending a command while the breaker is open
+func errCommandServerSuspended() error {
+	return &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CMD_SERVER_NOT_AVAILABLE}
+}
+
+/*
+GetCommandServerHealth returns the state of the command server breaker for the current
+connection. The second return value is false if CommandTimeoutLimit was not set.
+*/
+func GetCommandServerHealth() (CommandServerHealth, bool) {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || ci.cmdBreaker == nil {
+		return CommandServerHealth{}, false
+	}
+	return ci.cmdBreaker.health(), true
+}
@@ -32,6 +32,7 @@ about running MQ channels
 import (
 	_ "fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -56,6 +57,8 @@ const (
 	ATTR_CHL_TYPE          = "type"
 	ATTR_CHL_INSTANCE_TYPE = "instance_type"
 	ATTR_CHL_SINCE_MSG     = "time_since_msg"
+	ATTR_CHL_LAST_MSG_TIME = "last_msg_time"
+	ATTR_CHL_SUBSTATE_TEXT = "substate_text"
 
 	ATTR_CHL_NETTIME_SHORT = "nettime_short"
 	ATTR_CHL_NETTIME_LONG  = "nettime_long"
@@ -66,8 +69,19 @@ const (
 
 	ATTR_CHL_MAX_INSTC = "attribute_max_instc"
 	ATTR_CHL_MAX_INST  = "attribute_max_inst"
+	ATTR_CHL_MAX_MSGL  = "attribute_max_msg_length"
 	ATTR_CHL_CUR_INST  = "cur_inst"
 
+	// Label names for the channel definition attributes returned by GetChannelInfo
+	CHL_INFO_MCAUSER  = "mcauser"
+	CHL_INFO_SSLCAUTH = "sslcauth"
+	CHL_INFO_SSLCIPH  = "sslciph"
+	CHL_INFO_SCYEXIT  = "scyexit"
+	CHL_INFO_SENDEXIT = "sendexit"
+	CHL_INFO_RCVEXIT  = "rcvexit"
+	CHL_INFO_MSGEXIT  = "msgexit"
+	CHL_INFO_MAXMSGL  = "maxmsgl"
+
 	SQUASH_CHL_STATUS_STOPPED    = 0
 	SQUASH_CHL_STATUS_TRANSITION = 1
 	SQUASH_CHL_STATUS_RUNNING    = 2
@@ -128,9 +142,11 @@ func ChannelInitAttributes() {
 	// This is decoded by MQCHS_* values
 	attr = ATTR_CHL_STATUS
 	st.Attributes[attr] = newStatusAttribute(attr, "Channel Status", ibmmq.MQIACH_CHANNEL_STATUS)
+	st.Attributes[attr].enum = ENUM_CHL_STATUS
 	// The next value can be decoded from the MQCHSSTATE_* values
 	attr = ATTR_CHL_SUBSTATE
 	st.Attributes[attr] = newStatusAttribute(attr, "Channel Substate", ibmmq.MQIACH_CHANNEL_SUBSTATE)
+	st.Attributes[attr].enum = ENUM_CHL_SUBSTATE
 	attr = ATTR_CHL_TYPE
 	st.Attributes[attr] = newStatusAttribute(attr, "Channel Type", ibmmq.MQIACH_CHANNEL_TYPE)
 	attr = ATTR_CHL_INSTANCE_TYPE
@@ -141,6 +157,7 @@ func ChannelInitAttributes() {
 	attr = ATTR_CHL_STATUS_SQUASH
 	st.Attributes[attr] = newStatusAttribute(attr, "Channel Status - Simplified", ibmmq.MQIACH_CHANNEL_STATUS)
 	st.Attributes[attr].squash = true
+	st.Attributes[attr].enum = ENUM_CHL_STATUS_SIMPLIFIED
 	os.init = true
 
 	attr = ATTR_CHL_NETTIME_SHORT
@@ -154,19 +171,25 @@ func ChannelInitAttributes() {
 	st.Attributes[attr] = newStatusAttribute(attr, "Batch Size Average Short", ibmmq.MQIACH_BATCH_SIZE_INDICATOR)
 	st.Attributes[attr].index = 0
 	attr = ATTR_CHL_BATCHSZ_LONG
-	st.Attributes[attr] = newStatusAttribute(attr, "Batch Size Average Short", ibmmq.MQIACH_BATCH_SIZE_INDICATOR)
+	st.Attributes[attr] = newStatusAttribute(attr, "Batch Size Average Long", ibmmq.MQIACH_BATCH_SIZE_INDICATOR)
 	st.Attributes[attr].index = 1
 
 	attr = ATTR_CHL_XQTIME_SHORT
 	st.Attributes[attr] = newStatusAttribute(attr, "XmitQ Time Average Short", ibmmq.MQIACH_XMITQ_TIME_INDICATOR)
 	st.Attributes[attr].index = 0
 	attr = ATTR_CHL_XQTIME_LONG
-	st.Attributes[attr] = newStatusAttribute(attr, "XmitQ Time Average Short", ibmmq.MQIACH_XMITQ_TIME_INDICATOR)
+	st.Attributes[attr] = newStatusAttribute(attr, "XmitQ Time Average Long", ibmmq.MQIACH_XMITQ_TIME_INDICATOR)
 	st.Attributes[attr].index = 1
 
 	attr = ATTR_CHL_SINCE_MSG
 	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg", -1)
 
+	// String values that can be used as information labels
+	attr = ATTR_CHL_LAST_MSG_TIME
+	st.Attributes[attr] = newStatusAttribute(attr, "Last Msg Time", -1)
+	attr = ATTR_CHL_SUBSTATE_TEXT
+	st.Attributes[attr] = newStatusAttribute(attr, "Channel Substate Text", -1)
+
 	// These are not really monitoring metrics but it may enable calculations to be made such as %used for
 	// the channel instance availability. It's extracted at startup of the program via INQUIRE_CHL and not updated later
 	// until rediscovery is done based on a separate schedule.
@@ -174,6 +197,8 @@ func ChannelInitAttributes() {
 	st.Attributes[attr] = newStatusAttribute(attr, "MaxInst", -1)
 	attr = ATTR_CHL_MAX_INSTC
 	st.Attributes[attr] = newStatusAttribute(attr, "MaxInstC", -1)
+	attr = ATTR_CHL_MAX_MSGL
+	st.Attributes[attr] = newStatusAttribute(attr, "Max Message Length", -1)
 	// Current Instances is treated a bit oddly. Although reported on each channel status,
 	// it actually refers to the total number of instances of the same name.
 	attr = ATTR_CHL_CUR_INST
@@ -195,25 +220,27 @@ func InquireChannels(patterns string) ([]string, error) {
 }
 
 func CollectChannelStatus(patterns string) error {
+	k := GetConnectionKey()
+	return collectChannelStatusFor(k, getConnection(k), patterns)
+}
+
+func collectChannelStatusFor(k string, ci *connectionInfo, patterns string) error {
 	var err error
 
 	traceEntry("CollectChannelStatus")
 
-	ci := getConnection(GetConnectionKey())
 	os := &ci.objectStatus[OT_CHANNEL]
-	st := GetObjectStatus(GetConnectionKey(), OT_CHANNEL)
+	st := GetObjectStatus(k, OT_CHANNEL)
 
 	os.objectSeen = make(map[string]bool) // Record which channels have been seen in this period
 
 	ChannelInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
-	for k := range chlInfoMap {
-		chlInfoMap[k].AttrCurInst = 0
+	for k := range ci.chlInfoMap {
+		ci.chlInfoMap[k].AttrCurInst = 0
 	}
 
 	channelPatterns := strings.Split(patterns, ",")
@@ -242,6 +269,7 @@ func CollectChannelStatus(patterns string) error {
 
 	// Need to clean out the prevValues elements to stop short-lived channels
 	// building up in the map
+	statusTidyEpochs(st, os.objectSeen)
 	for a, _ := range st.Attributes {
 		if st.Attributes[a].delta {
 			m := st.Attributes[a].prevValues
@@ -262,7 +290,7 @@ func CollectChannelStatus(patterns string) error {
 	// is not already there. Some of the fields do need to be faked up as we don't know anything about
 	// the "partner"
 	if err == nil && ci.showInactiveChannels {
-		for chlName, v := range chlInfoMap {
+		for chlName, v := range ci.chlInfoMap {
 			found := false
 			chlPrefix := chlName + "/"
 			for k, _ := range st.Attributes[ATTR_CHL_STATUS].Values {
@@ -294,16 +322,23 @@ func CollectChannelStatus(patterns string) error {
 	// are given the same instance count so it could be extracted.
 	for key, _ := range st.Attributes[ATTR_CHL_NAME].Values {
 		chlName := st.Attributes[ATTR_CHL_NAME].Values[key].ValueString
-		if s, ok := chlInfoMap[chlName]; ok {
+		if s, ok := ci.chlInfoMap[chlName]; ok {
 			maxInstC := s.AttrMaxInstC
 			st.Attributes[ATTR_CHL_MAX_INSTC].Values[key] = newStatusValueInt64(maxInstC)
 			maxInst := s.AttrMaxInst
 			st.Attributes[ATTR_CHL_MAX_INST].Values[key] = newStatusValueInt64(maxInst)
+			if s.AttrMaxMsgLength > 0 {
+				st.Attributes[ATTR_CHL_MAX_MSGL].Values[key] = newStatusValueInt64(s.AttrMaxMsgLength)
+			}
 			curInst := s.AttrCurInst
 			st.Attributes[ATTR_CHL_CUR_INST].Values[key] = newStatusValueInt64(curInst)
 		}
 	}
 
+	// Optionally replace the individual instances by a single entry per channel
+	aggregateChannelInstances(st, ci.chlAggregation)
+
+	statusPostCollect(OT_CHANNEL)
 	traceExitErr("CollectChannelStatus", 0, err)
 	return err
 
@@ -346,7 +381,7 @@ func collectChannelStatus(pattern string, instanceType int32) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectChannelStatus", 1, err)
 		return err
@@ -429,12 +464,11 @@ func parseChlData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	// So if there is no remote qmgr, force a non-empty string value in there. Similarly, the jobname for
 	// inactive channels often arrives looking like "00000" but not filling the entire length
 	// allowed. So reset that one too.
-	if rqmName == "" {
-		rqmName = DUMMY_STRING
-	}
-	if jobName == "" || allZero(jobName) {
+	rqmName = labelValue(rqmName)
+	if allZero(jobName) {
 		jobName = DUMMY_STRING
 	}
+	jobName = labelValue(jobName)
 
 	if chlType == ibmmq.MQCHT_SVRCONN {
 		if ci.hideSvrConnJobname {
@@ -470,6 +504,12 @@ func parseChlData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	st.Attributes[ATTR_CHL_RQMNAME].Values[key] = newStatusValueString(rqmName)
 	st.Attributes[ATTR_CHL_JOBNAME].Values[key] = newStatusValueString(jobName)
 
+	// A channel instance that has restarted with the same key has new counters
+	statusCheckEpoch(st, key, startDate+" "+startTime)
+
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_CHANNEL), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -494,10 +534,16 @@ func parseChlData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	now := time.Now()
 	diff := statusTimeDiff(now, lastMsgDate, lastMsgTime)
 	st.Attributes[ATTR_CHL_SINCE_MSG].Values[key] = newStatusValueInt64(diff)
+	if lastMsgDate != "" {
+		st.Attributes[ATTR_CHL_LAST_MSG_TIME].Values[key] = newStatusValueString(lastMsgDate + " " + lastMsgTime)
+	}
+	if v, ok := st.Attributes[ATTR_CHL_SUBSTATE].Values[key]; ok {
+		st.Attributes[ATTR_CHL_SUBSTATE_TEXT].Values[key] = newStatusValueString(ibmmq.MQItoStringStripPrefix("MQCHSSTATE", int(v.ValueInt64)))
+	}
 
 	// Bump the number of active instances of the channel, treating it a bit like a
 	// regular config attribute.
-	if s, ok := chlInfoMap[chlName]; ok {
+	if s, ok := ci.chlInfoMap[chlName]; ok {
 		if instanceType != ibmmq.MQOT_SAVED_CHANNEL {
 			s.AttrCurInst++
 		}
@@ -554,7 +600,7 @@ func ChannelNormalise(attr *StatusAttribute, v int64) float64 {
 
 	traceExit("ChannelNormalise", 0)
 
-	return f
+	return attr.applyTransform(f)
 }
 
 // Issue the INQUIRE_CHANNEL call for wildcarded channel names and
@@ -596,7 +642,9 @@ func inquireChannelAttributes(objectPatternsList string, infoMap map[string]*Obj
 		pcfparm = new(ibmmq.PCFParameter)
 		pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
 		pcfparm.Parameter = ibmmq.MQIACF_CHANNEL_ATTRS
-		pcfparm.Int64Value = []int64{int64(ibmmq.MQIACH_MAX_INSTANCES), int64(ibmmq.MQIACH_MAX_INSTS_PER_CLIENT), int64(ibmmq.MQCACH_DESC), int64(ibmmq.MQIACH_CHANNEL_TYPE)}
+		pcfparm.Int64Value = []int64{int64(ibmmq.MQIACH_MAX_INSTANCES), int64(ibmmq.MQIACH_MAX_INSTS_PER_CLIENT), int64(ibmmq.MQCACH_DESC), int64(ibmmq.MQIACH_CHANNEL_TYPE), int64(ibmmq.MQIACH_MAX_MSG_LENGTH),
+			int64(ibmmq.MQCACH_MCA_USER_ID), int64(ibmmq.MQIACH_SSL_CLIENT_AUTH), int64(ibmmq.MQCACH_SSL_CIPHER_SPEC),
+			int64(ibmmq.MQCACH_SEC_EXIT_NAME), int64(ibmmq.MQCACH_SEND_EXIT_NAME), int64(ibmmq.MQCACH_RCV_EXIT_NAME), int64(ibmmq.MQCACH_MSG_EXIT_NAME)}
 		cfh.ParameterCount++
 		buf = append(buf, pcfparm.Bytes()...)
 
@@ -605,7 +653,7 @@ func inquireChannelAttributes(objectPatternsList string, infoMap map[string]*Obj
 		buf = append(cfh.Bytes(), buf...)
 
 		// And now put the command to the queue
-		err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+		err = statusPutCommand(ci, putmqmd, pmo, buf)
 		if err != nil {
 			traceExitErr("inquireChannelAttributes", 2, err)
 			return err
@@ -702,6 +750,16 @@ func parseChannelAttrData(cfh *ibmmq.MQCFH, buf []byte, infoMap map[string]*ObjI
 				ci.exists = true
 
 			}
+		case ibmmq.MQIACH_MAX_MSG_LENGTH:
+			v := elem.Int64Value[0]
+			if v > 0 {
+				if ci, ok = infoMap[chlName]; !ok {
+					ci = new(ObjInfo)
+					infoMap[chlName] = ci
+				}
+				ci.AttrMaxMsgLength = v
+				ci.exists = true
+			}
 
 		case ibmmq.MQCACH_DESC:
 			v := elem.String[0]
@@ -713,6 +771,25 @@ func parseChannelAttrData(cfh *ibmmq.MQCFH, buf []byte, infoMap map[string]*ObjI
 				ci.Description = printableStringUTF8(v)
 				ci.exists = true
 			}
+
+		// The channel definition attributes that are only used as labels. Not every
+		// channel type has all of them, so only the ones that are returned are set.
+		case ibmmq.MQCACH_MCA_USER_ID, ibmmq.MQCACH_SSL_CIPHER_SPEC,
+			ibmmq.MQCACH_SEC_EXIT_NAME, ibmmq.MQCACH_SEND_EXIT_NAME, ibmmq.MQCACH_RCV_EXIT_NAME, ibmmq.MQCACH_MSG_EXIT_NAME:
+			// Exits can be a list
+			var l []string
+			for _, v := range elem.String {
+				if v = strings.TrimSpace(v); v != "" {
+					l = append(l, printableStringUTF8(v))
+				}
+			}
+			setChannelInfo(infoMap, chlName, channelInfoLabels[elem.Parameter], strings.Join(l, ","))
+		case ibmmq.MQIACH_SSL_CLIENT_AUTH:
+			v := "OPTIONAL"
+			if int32(elem.Int64Value[0]) == ibmmq.MQSCA_REQUIRED {
+				v = "REQUIRED"
+			}
+			setChannelInfo(infoMap, chlName, CHL_INFO_SSLCAUTH, v)
 		}
 	}
 
@@ -720,6 +797,51 @@ func parseChannelAttrData(cfh *ibmmq.MQCFH, buf []byte, infoMap map[string]*ObjI
 	return
 }
 
+// Map the string attributes to their labels
+var channelInfoLabels = map[int32]string{
+	ibmmq.MQCACH_MCA_USER_ID:     CHL_INFO_MCAUSER,
+	ibmmq.MQCACH_SSL_CIPHER_SPEC: CHL_INFO_SSLCIPH,
+	ibmmq.MQCACH_SEC_EXIT_NAME:   CHL_INFO_SCYEXIT,
+	ibmmq.MQCACH_SEND_EXIT_NAME:  CHL_INFO_SENDEXIT,
+	ibmmq.MQCACH_RCV_EXIT_NAME:   CHL_INFO_RCVEXIT,
+	ibmmq.MQCACH_MSG_EXIT_NAME:   CHL_INFO_MSGEXIT,
+}
+
+func setChannelInfo(infoMap map[string]*ObjInfo, chlName string, label string, v string) {
+	ci, ok := infoMap[chlName]
+	if !ok {
+		ci = new(ObjInfo)
+		infoMap[chlName] = ci
+	}
+	if ci.ChannelInfo == nil {
+		ci.ChannelInfo = make(map[string]string)
+	}
+	ci.ChannelInfo[label] = intern(v)
+	ci.exists = true
+}
+
+/*
+GetChannelInfo returns the channel definition attributes found during discovery, such as
+the MCAUSER, SSLCAUTH and exit names, keyed by the CHL_INFO_* label names. It is intended
+for an info-style series with a constant value of 1 and these as its labels, so that
+dashboards can check the configuration of the channels. Empty attributes are returned
+as DUMMY_STRING. The result is nil if the channel is not known.
+*/
+func GetChannelInfo(chlName string) map[string]string {
+	ci := getConnection(GetConnectionKey())
+	s, ok := ci.chlInfoMap[chlName]
+	if !ok || s.ChannelInfo == nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(s.ChannelInfo)+1)
+	for k, v := range s.ChannelInfo {
+		m[k] = labelValue(v)
+	}
+	m[CHL_INFO_MAXMSGL] = strconv.FormatInt(s.AttrMaxMsgLength, 10)
+	return m
+}
+
 func allZero(s string) bool {
 	rc := true
 	for i := 0; i < len(s); i++ {
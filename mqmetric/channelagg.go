@@ -0,0 +1,159 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file combines the status of multiple instances of a channel.
+
+A channel can have many instances running at the same time, and each one is
+reported separately using a key made from the channel name, connection name, remote
+queue manager and job name. For SVRCONN channels in particular, the job name changes
+as clients reconnect, so a time-series database sees a stream of short-lived series.
+
+With the ChannelAggregation option, the instances are replaced by a single entry for each
+channel name, using the same key format as an inactive channel. With SUM, the counters
+(messages, bytes, buffers, batches) are added together; with MAX, the largest value is used.
+Other values always use the maximum, except for the time since the last message, which
+uses the minimum. The connection name, job name and remote queue manager are kept if
+all the instances agree, and otherwise replaced by DUMMY_STRING.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How channel instances are combined
+const (
+	CHL_AGGREGATE_NONE = 0
+	CHL_AGGREGATE_SUM  = 1
+	CHL_AGGREGATE_MAX  = 2
+)
+
+// Convert the configuration option
+func parseChannelAggregation(s string) (int, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "", "NONE":
+		return CHL_AGGREGATE_NONE, nil
+	case "SUM":
+		return CHL_AGGREGATE_SUM, nil
+	case "MAX":
+		return CHL_AGGREGATE_MAX, nil
+	}
+	return CHL_AGGREGATE_NONE, fmt.Errorf("Channel aggregation '%s' is not valid. Use NONE, SUM or MAX", s)
+}
+
+// Numeric attributes that use the minimum instead of the maximum
+var chlAggregateMin = map[string]bool{
+	ATTR_CHL_SINCE_MSG: true,
+}
+
+var chlAggregateLabels = []string{ATTR_CHL_CONNNAME, ATTR_CHL_JOBNAME, ATTR_CHL_RQMNAME}
+
+// Replace the per-instance values in the status set with one entry per channel name
+func aggregateChannelInstances(st *StatusSet, mode int) {
+	if mode == CHL_AGGREGATE_NONE {
+		return
+	}
+
+	traceEntry("aggregateChannelInstances")
+
+	// Work out the new key for each instance. If any instance has reset its
+	// counters, then so has the combined entry.
+	newKeys := make(map[string]string)
+	for key, v := range st.Attributes[ATTR_CHL_NAME].Values {
+		newKeys[key] = v.ValueString + "/" + DUMMY_STRING + "/" + DUMMY_STRING + "/" + DUMMY_STRING
+		if st.resetKeys[key] {
+			statusMarkReset(st, newKeys[key])
+		}
+	}
+
+	for attrName, attr := range st.Attributes {
+		if attrName == ATTR_CHL_NAME {
+			m := make(map[string]*StatusValue)
+			for key, v := range attr.Values {
+				m[newKeys[key]] = v
+			}
+			attr.Values = m
+			continue
+		}
+
+		isLabel := false
+		for _, l := range chlAggregateLabels {
+			if attrName == l {
+				isLabel = true
+			}
+		}
+
+		m := make(map[string]*StatusValue)
+		for key, v := range attr.Values {
+			newKey, ok := newKeys[key]
+			if !ok {
+				continue
+			}
+			cur, ok := m[newKey]
+			if !ok {
+				// Take a copy as the original value may still be referenced elsewhere
+				c := *v
+				m[newKey] = &c
+				continue
+			}
+
+			if isLabel || v.Type() == STATUS_TYPE_STRING {
+				if cur.ValueString != v.ValueString {
+					cur.ValueString = DUMMY_STRING
+				}
+				continue
+			}
+
+			if v.IsFloat64 {
+				switch {
+				case mode == CHL_AGGREGATE_SUM && attr.delta:
+					cur.ValueFloat64 += v.ValueFloat64
+				case chlAggregateMin[attrName]:
+					if v.ValueFloat64 < cur.ValueFloat64 {
+						cur.ValueFloat64 = v.ValueFloat64
+					}
+				default:
+					if v.ValueFloat64 > cur.ValueFloat64 {
+						cur.ValueFloat64 = v.ValueFloat64
+					}
+				}
+				continue
+			}
+
+			switch {
+			case chlAggregateMin[attrName]:
+				if v.ValueInt64 < cur.ValueInt64 {
+					cur.ValueInt64 = v.ValueInt64
+				}
+			case mode == CHL_AGGREGATE_SUM && attr.delta:
+				cur.ValueInt64 += v.ValueInt64
+			default:
+				if v.ValueInt64 > cur.ValueInt64 {
+					cur.ValueInt64 = v.ValueInt64
+				}
+			}
+		}
+		attr.Values = m
+	}
+
+	traceExit("aggregateChannelInstances", 0)
+}
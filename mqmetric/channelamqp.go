@@ -91,6 +91,7 @@ func ChannelAMQPInitAttributes() {
 	// This is decoded by MQCHS_* values
 	attr = ATTR_CHL_STATUS
 	st.Attributes[attr] = newStatusAttribute(attr, "Channel Status", ibmmq.MQIACH_CHANNEL_STATUS)
+	st.Attributes[attr].enum = ENUM_CHL_STATUS
 
 	attr = ATTR_CHL_SINCE_MSG
 	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg", -1)
@@ -138,12 +139,10 @@ func CollectAMQPChannelStatus(patterns string) error {
 	ChannelAMQPInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
-	for k := range amqpInfoMap {
-		amqpInfoMap[k].AttrCurInst = 0
+	for k := range ci.amqpInfoMap {
+		ci.amqpInfoMap[k].AttrCurInst = 0
 	}
 
 	channelPatterns := strings.Split(patterns, ",")
@@ -186,7 +185,7 @@ func CollectAMQPChannelStatus(patterns string) error {
 	// are given the same instance count so it could be extracted.
 	for key, _ := range st.Attributes[ATTR_CHL_NAME].Values {
 		chlName := st.Attributes[ATTR_CHL_NAME].Values[key].ValueString
-		if s, ok := amqpInfoMap[chlName]; ok {
+		if s, ok := ci.amqpInfoMap[chlName]; ok {
 			maxInstC := s.AttrMaxInstC
 			st.Attributes[ATTR_CHL_MAX_INSTC].Values[key] = newStatusValueInt64(maxInstC)
 			maxInst := s.AttrMaxInst
@@ -196,6 +195,7 @@ func CollectAMQPChannelStatus(patterns string) error {
 		}
 	}
 
+	statusPostCollect(OT_CHANNEL_AMQP)
 	traceExitErr("CollectAMQPChannelStatus", 0, err)
 	return err
 
@@ -246,7 +246,7 @@ func collectAMQPChannelStatus(pattern string, instanceType int32) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectAMQPChannelStatus", 1, err)
 		return err
@@ -330,6 +330,9 @@ func parseAMQPChlData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	st.Attributes[ATTR_CHL_CONNNAME].Values[key] = newStatusValueString(connName)
 	st.Attributes[ATTR_CHL_AMQP_CLIENT_ID].Values[key] = newStatusValueString(clientId)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_CHANNEL_AMQP), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -357,7 +360,7 @@ func parseAMQPChlData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 
 	// Bump the number of active instances of the channel, treating it a bit like a
 	// regular config attribute.
-	if s, ok := amqpInfoMap[chlName]; ok {
+	if s, ok := ci.amqpInfoMap[chlName]; ok {
 		s.AttrCurInst++
 	}
 
@@ -420,7 +423,7 @@ func inquireAMQPChannelAttributes(objectPatternsList string, infoMap map[string]
 		buf = append(cfh.Bytes(), buf...)
 
 		// And now put the command to the queue
-		err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+		err = statusPutCommand(ci, putmqmd, pmo, buf)
 		if err != nil {
 			traceExitErr("inquireAMQPChannelAttributes", 2, err)
 			return err
@@ -0,0 +1,126 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file applies tuning options to the client channel definition that is built from
+the ConnName/Channel or Endpoints in the ConnectionConfig. A collector that is a long way
+from the queue manager can ask for compression of the PCF responses and publications,
+which are often very compressible, without needing a CCDT.
+
+Compression lists are given in order of preference, separated by commas. The values
+actually used are negotiated with the SVRCONN channel, which must also allow them.
+Header compression can be NONE or SYSTEM; message compression can also be RLE, ZLIBFAST,
+ZLIBHIGH or ANY.
+
+The settings are ignored when a CCDT is used, as the channel definition then comes
+from there.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+var hdrCompressionNames = map[string]int32{
+	"NONE":   ibmmq.MQCOMPRESS_NONE,
+	"SYSTEM": ibmmq.MQCOMPRESS_SYSTEM,
+}
+
+var msgCompressionNames = map[string]int32{
+	"NONE":     ibmmq.MQCOMPRESS_NONE,
+	"RLE":      ibmmq.MQCOMPRESS_RLE,
+	"ZLIBFAST": ibmmq.MQCOMPRESS_ZLIBFAST,
+	"ZLIBHIGH": ibmmq.MQCOMPRESS_ZLIBHIGH,
+	"ANY":      ibmmq.MQCOMPRESS_ANY,
+}
+
+// Convert a list of compression names into the values for the MQCD. Unused
+// entries are filled with MQCOMPRESS_NOT_AVAILABLE.
+func compressionList(s string, names map[string]int32, max int) ([]int32, error) {
+	list := make([]int32, 0, max)
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		v, ok := names[c]
+		if !ok {
+			return nil, fmt.Errorf("Compression type '%s' is not valid", c)
+		}
+		list = append(list, v)
+	}
+	if len(list) > max {
+		return nil, fmt.Errorf("Too many compression types in '%s'. The maximum is %d", s, max)
+	}
+	for len(list) < max {
+		list = append(list, ibmmq.MQCOMPRESS_NOT_AVAILABLE)
+	}
+	return list, nil
+}
+
+// Make sure the tuning options are valid before trying to connect
+func checkChannelTuning(cc *ConnectionConfig) error {
+	var err error
+
+	if cc.HeaderCompression != "" {
+		_, err = compressionList(cc.HeaderCompression, hdrCompressionNames, 2)
+	}
+	if err == nil && cc.MessageCompression != "" {
+		_, err = compressionList(cc.MessageCompression, msgCompressionNames, 16)
+	}
+	if err == nil {
+		if cc.ChannelHeartbeat < 0 || cc.BatchHeartbeat < 0 || cc.BatchInterval < 0 {
+			err = fmt.Errorf("Channel heartbeat and batch intervals cannot be negative")
+		}
+	}
+	return err
+}
+
+// Update a channel definition with the options. They have already been checked, so
+// errors can be ignored here. Zero values leave the defaults in place.
+func applyChannelTuning(gocd *ibmmq.MQCD, cc *ConnectionConfig) {
+	if gocd == nil {
+		return
+	}
+
+	if cc.HeaderCompression != "" {
+		if list, err := compressionList(cc.HeaderCompression, hdrCompressionNames, 2); err == nil {
+			copy(gocd.HdrCompList[:], list)
+		}
+	}
+	if cc.MessageCompression != "" {
+		if list, err := compressionList(cc.MessageCompression, msgCompressionNames, 16); err == nil {
+			copy(gocd.MsgCompList[:], list)
+		}
+	}
+
+	if cc.ChannelHeartbeat > 0 {
+		gocd.HeartbeatInterval = int32(cc.ChannelHeartbeat)
+	}
+	if cc.BatchHeartbeat > 0 {
+		gocd.BatchHeartbeat = int32(cc.BatchHeartbeat)
+	}
+	if cc.BatchInterval > 0 {
+		gocd.BatchInterval = int32(cc.BatchInterval)
+	}
+}
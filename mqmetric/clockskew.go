@@ -0,0 +1,120 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file estimates the difference between the queue manager's clock and the local
+clock, using the put time that the queue manager sets on each publication. A skewed
+clock does not stop anything from working, but it distorts rates that are calculated
+from timestamps, and can make a time-series database reject or misplace data points.
+
+Every publication has spent some time on the queue before it is read, so the difference
+between the local time and its put time is the skew plus that delay. The smallest
+difference over the last few intervals is used as the estimate, as the delay is then
+shortest. A queue manager clock that is ahead of the local clock is always detected;
+one that is behind is only reported when it is further behind than the time publications
+wait to be read, which depends on the monitoring and collection intervals.
+*/
+
+import (
+	"time"
+)
+
+// How many calls to ProcessPublications the estimate covers
+const clockSkewIntervals = 10
+
+var clockSkewThreshold = 30 * time.Second
+
+type clockSkew struct {
+	intervals []time.Duration // The smallest difference seen in each recent interval
+	current   time.Duration
+	seen      bool
+	warned    bool
+}
+
+/*
+SetClockSkewThreshold sets how large the estimated skew can be before a warning
+is logged. The default is 30 seconds.
+*/
+func SetClockSkewThreshold(d time.Duration) {
+	clockSkewThreshold = d
+}
+
+/*
+GetClockSkew returns the estimated amount by which the queue manager's clock is behind
+the local clock. It is negative if the queue manager's clock is ahead. The second return
+value is false if no publications have been read yet.
+*/
+func GetClockSkew() (time.Duration, bool) {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || ci.clockSkew == nil {
+		return 0, false
+	}
+	return ci.clockSkew.estimate()
+}
+
+func (c *clockSkew) observe(putTime time.Time, now time.Time) {
+	if putTime.IsZero() {
+		return
+	}
+	d := now.Sub(putTime)
+	if !c.seen || d < c.current {
+		c.current = d
+		c.seen = true
+	}
+}
+
+// Called at the end of ProcessPublications
+func (c *clockSkew) endInterval() {
+	if !c.seen {
+		return
+	}
+	c.intervals = append(c.intervals, c.current)
+	if len(c.intervals) > clockSkewIntervals {
+		c.intervals = c.intervals[1:]
+	}
+	c.seen = false
+
+	skew, _ := c.estimate()
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs > clockSkewThreshold && !c.warned {
+		logError("Warning: The queue manager clock appears to differ from the local clock by %v", skew)
+		c.warned = true
+	} else if abs <= clockSkewThreshold && c.warned {
+		logInfo("The queue manager clock now appears to match the local clock")
+		c.warned = false
+	}
+}
+
+func (c *clockSkew) estimate() (time.Duration, bool) {
+	if len(c.intervals) == 0 {
+		return 0, false
+	}
+	min := c.intervals[0]
+	for _, d := range c.intervals[1:] {
+		if d < min {
+			min = d
+		}
+	}
+	return min, true
+}
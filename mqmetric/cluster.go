@@ -65,6 +65,7 @@ func ClusterInitAttributes() {
 	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Cluster Name")
 	attr = ATTR_CLUSTER_STATUS
 	st.Attributes[attr] = newStatusAttribute(attr, "Cluster Status", ibmmq.MQIACH_CHANNEL_STATUS)
+	st.Attributes[attr].enum = ENUM_CHL_STATUS
 	attr = ATTR_CLUSTER_SUSPEND
 	st.Attributes[attr] = newStatusAttribute(attr, "Cluster Suspend", ibmmq.MQIACF_SUSPEND)
 	attr = ATTR_CLUSTER_QMTYPE
@@ -81,12 +82,11 @@ func CollectClusterStatus() error {
 	ClusterInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
 	err = collectClusterStatus()
 
+	statusPostCollect(OT_CLUSTER)
 	traceExitErr("CollectClusterStatus", 0, err)
 
 	return err
@@ -120,7 +120,7 @@ func collectClusterStatus() error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectClusterStatus", 1, err)
 
@@ -179,6 +179,9 @@ func parseClusterData(cfh *ibmmq.MQCFH, buf []byte) string {
 
 	st.Attributes[ATTR_CLUSTER_NAME].Values[key] = newStatusValueString(ClusterName)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_CLUSTER), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -0,0 +1,275 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Functions in this file use the DISPLAY CHSTATUS command for cluster-sender channels
+to show the cluster backlog for each destination queue manager.
+
+When all cluster channels share SYSTEM.CLUSTER.TRANSMIT.QUEUE, the depth of that queue says
+nothing about which destination is falling behind. But the channel status of each
+cluster-sender includes the number of messages on its transmission queue that are waiting
+for that channel (XQMSGSA), and the time messages spend on the queue before that channel
+sends them. The same values work when DEFCLXQ(CHANNEL) gives each channel its own
+transmission queue; the "xmitq" label then shows which queue is being used.
+
+The queue manager reports XQMSGSA up to a maximum of 999.
+*/
+
+import (
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const (
+	ATTR_CLUSXQ_XMITQ         = "xmitq"
+	ATTR_CLUSXQ_MSGS          = "xmitq_msgs_available"
+	ATTR_CLUSXQ_TIME_SHORT    = "xmitq_time_short"
+	ATTR_CLUSXQ_TIME_LONG     = "xmitq_time_long"
+	ATTR_CLUSXQ_STATUS        = "status"
+	ATTR_CLUSXQ_RQMNAME       = "rqmname"
+	ATTR_CLUSXQ_CHANNEL       = "channel"
+	ATTR_CLUSXQ_NETTIME_SHORT = "nettime_short"
+)
+
+/*
+Unlike the statistics produced via a topic, there is no discovery
+of the attributes available in object STATUS queries. So this function
+hardcodes the attributes we are going to look for and gives the associated
+descriptive text.
+*/
+func ClusterXmitQInitAttributes() {
+	traceEntry("ClusterXmitQInitAttributes")
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_CLUSTER_XMITQ]
+	st := GetObjectStatus(GetConnectionKey(), OT_CLUSTER_XMITQ)
+
+	if os.init {
+		traceExit("ClusterXmitQInitAttributes", 1)
+		return
+	}
+	st.Attributes = make(map[string]*StatusAttribute)
+
+	// These fields are used to construct the key and as tags for the metrics
+	attr := ATTR_CLUSXQ_CHANNEL
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Cluster-sender Channel Name")
+	attr = ATTR_CLUSXQ_RQMNAME
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Destination Queue Manager Name")
+	attr = ATTR_CLUSXQ_XMITQ
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Transmission Queue Name")
+
+	attr = ATTR_CLUSXQ_MSGS
+	st.Attributes[attr] = newStatusAttribute(attr, "Messages available to the channel", ibmmq.MQIACH_XMITQ_MSGS_AVAILABLE)
+	attr = ATTR_CLUSXQ_TIME_SHORT
+	st.Attributes[attr] = newStatusAttribute(attr, "XmitQ Time Average Short", ibmmq.MQIACH_XMITQ_TIME_INDICATOR)
+	st.Attributes[attr].index = 0
+	attr = ATTR_CLUSXQ_TIME_LONG
+	st.Attributes[attr] = newStatusAttribute(attr, "XmitQ Time Average Long", ibmmq.MQIACH_XMITQ_TIME_INDICATOR)
+	st.Attributes[attr].index = 1
+	attr = ATTR_CLUSXQ_NETTIME_SHORT
+	st.Attributes[attr] = newStatusAttribute(attr, "Network Time Short", ibmmq.MQIACH_NETWORK_TIME_INDICATOR)
+	st.Attributes[attr].index = 0
+	attr = ATTR_CLUSXQ_STATUS
+	st.Attributes[attr] = newStatusAttribute(attr, "Channel Status", ibmmq.MQIACH_CHANNEL_STATUS)
+	st.Attributes[attr].enum = ENUM_CHL_STATUS
+
+	os.init = true
+	traceExit("ClusterXmitQInitAttributes", 0)
+}
+
+/*
+CollectClusterXmitQStatus finds the backlog for each running cluster-sender channel.
+An empty pattern means all cluster-sender channels.
+*/
+func CollectClusterXmitQStatus(patterns string) error {
+	var err error
+	traceEntry("CollectClusterXmitQStatus")
+
+	st := GetObjectStatus(GetConnectionKey(), OT_CLUSTER_XMITQ)
+	ClusterXmitQInitAttributes()
+
+	// Empty any collected values
+	statusClearValues(st)
+
+	if strings.TrimSpace(patterns) == "" {
+		patterns = "*"
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		err = collectClusterXmitQStatus(pattern)
+	}
+
+	statusPostCollect(OT_CLUSTER_XMITQ)
+	traceExitErr("CollectClusterXmitQStatus", 0, err)
+	return err
+}
+
+// Issue the INQUIRE_CHANNEL_STATUS command for a channel or wildcarded channel name.
+// Responses for channels that are not cluster-senders are ignored.
+func collectClusterXmitQStatus(pattern string) error {
+	var err error
+
+	traceEntryF("collectClusterXmitQStatus", "Pattern: %s", pattern)
+	ci := getConnection(GetConnectionKey())
+
+	statusClearReplyQ()
+
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+
+	// Can allow all the other fields to default
+	cfh.Command = ibmmq.MQCMD_INQUIRE_CHANNEL_STATUS
+
+	// Add the parameters one at a time into a buffer
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = ibmmq.MQCACH_CHANNEL_NAME
+	pcfparm.String = []string{pattern}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = ibmmq.MQIACH_CHANNEL_INSTANCE_TYPE
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQOT_CURRENT_CHANNEL)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	// XQMSGSA is not in the summary set of attributes
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
+	pcfparm.Parameter = ibmmq.MQIACH_CHANNEL_INSTANCE_ATTRS
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQIACF_ALL)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	// Once we know the total number of parameters, put the
+	// CFH header on the front of the buffer.
+	buf = append(cfh.Bytes(), buf...)
+
+	// And now put the command to the queue
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("collectClusterXmitQStatus", 1, err)
+		return err
+	}
+
+	// Now get the responses - loop until all have been received (one
+	// per channel) or we run out of time
+	for allReceived := false; !allReceived; {
+		cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if buf != nil {
+			parseClusterXmitQData(cfh, buf)
+		}
+	}
+
+	traceExitErr("collectClusterXmitQStatus", 0, err)
+	return err
+}
+
+// Given a PCF response message, parse it to extract the desired statistics
+func parseClusterXmitQData(cfh *ibmmq.MQCFH, buf []byte) string {
+	var elem *ibmmq.PCFParameter
+
+	traceEntry("parseClusterXmitQData")
+
+	st := GetObjectStatus(GetConnectionKey(), OT_CLUSTER_XMITQ)
+	chlType := ibmmq.MQCHT_ALL
+	chlName := ""
+	rqmName := ""
+	xmitQName := ""
+
+	parmAvail := true
+	bytesRead := 0
+	offset := 0
+	datalen := len(buf)
+	if cfh == nil || cfh.ParameterCount == 0 {
+		traceExit("parseClusterXmitQData", 1)
+		return ""
+	}
+
+	// Parse it once to extract the fields that are needed for the map key
+	for parmAvail && cfh.CompCode != ibmmq.MQCC_FAILED {
+		elem, bytesRead = ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		// Have we now reached the end of the message
+		if offset >= datalen {
+			parmAvail = false
+		}
+
+		switch elem.Parameter {
+		case ibmmq.MQCACH_CHANNEL_NAME:
+			chlName = trimToNull(elem.String[0])
+		case ibmmq.MQCA_REMOTE_Q_MGR_NAME:
+			rqmName = trimToNull(elem.String[0])
+		case ibmmq.MQCACH_XMIT_Q_NAME:
+			xmitQName = trimToNull(elem.String[0])
+		case ibmmq.MQIACH_CHANNEL_TYPE:
+			chlType = int32(elem.Int64Value[0])
+		}
+	}
+
+	if chlType != ibmmq.MQCHT_CLUSSDR {
+		traceExit("parseClusterXmitQData", 2)
+		return ""
+	}
+
+	// The remote qmgr name is not known until the channel has started
+	if rqmName == "" {
+		rqmName = DUMMY_STRING
+	}
+
+	// Create a unique key for this instance
+	key := chlName + "/" + rqmName
+
+	st.Attributes[ATTR_CLUSXQ_CHANNEL].Values[key] = newStatusValueString(chlName)
+	st.Attributes[ATTR_CLUSXQ_RQMNAME].Values[key] = newStatusValueString(rqmName)
+	st.Attributes[ATTR_CLUSXQ_XMITQ].Values[key] = newStatusValueString(xmitQName)
+
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(st, cfh, buf, key)
+
+	// And then re-parse the message so we can store the metrics now knowing the map key
+	parmAvail = true
+	offset = 0
+	for parmAvail && cfh.CompCode != ibmmq.MQCC_FAILED {
+		elem, bytesRead = ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		// Have we now reached the end of the message
+		if offset >= datalen {
+			parmAvail = false
+		}
+
+		statusGetIntAttributes(st, elem, key)
+	}
+
+	traceExitF("parseClusterXmitQData", 0, "Key : %s", key)
+	return key
+}
+
+// Return a standardised value. If the attribute indicates that something
+// special has to be done, then do that. Otherwise just make sure it's a non-negative
+// value of the correct datatype
+func ClusterXmitQNormalise(attr *StatusAttribute, v int64) float64 {
+	return statusNormalise(attr, v)
+}
@@ -0,0 +1,288 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file reads the command events that a queue manager generates when CMDEV is
+enabled. Each event says who issued an MQSC or PCF command, from which application,
+and what the command was. They can be used to build an audit trail of administrative
+changes, for example by forwarding them to a logging system.
+
+The events are read from SYSTEM.ADMIN.COMMAND.EVENT unless another queue is named.
+By default the messages are removed from the queue as they are read. If some other tool
+also needs to see them, the queue can be browsed instead, but then the messages
+are never removed and the queue needs to be cleared some other way. Messages are got
+outside syncpoint, so an event can be lost if the collector fails after getting it.
+
+There should only be one reader of command events for each connection.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// DefaultCommandEventQueue is the queue that the queue manager puts command events to
+const DefaultCommandEventQueue = "SYSTEM.ADMIN.COMMAND.EVENT"
+
+/*
+CommandEvent describes one command that was run on the queue manager. For MQSC
+commands, the MQSC field has the command text. For PCF commands, the Parameters
+field has the elements of the original command.
+*/
+type CommandEvent struct {
+	Time            time.Time
+	QMgrName        string
+	UserId          string
+	ApplName        string
+	ApplType        int32
+	ApplIdentity    string
+	ApplOrigin      string
+	AccountingToken string
+	Origin          int32 // One of the MQEVO_* values
+	Command         int32 // One of the MQCMD_* values
+	MQSC            string
+	Parameters      []*ibmmq.PCFParameter
+	mqsc            bool
+}
+
+/*
+IsMQSC returns true if the command was issued as MQSC, rather than PCF
+*/
+func (e *CommandEvent) IsMQSC() bool {
+	return e.mqsc
+}
+
+/*
+String formats the event as a single line, suitable for writing to a log
+*/
+func (e *CommandEvent) String() string {
+	cmd := e.MQSC
+	if !e.IsMQSC() {
+		cmd = ibmmq.MQItoString("CMD", int(e.Command))
+	}
+	return fmt.Sprintf("%s qmgr=%s user=%s appl=%q origin=%s command=%q",
+		e.Time.Format(time.RFC3339),
+		e.QMgrName,
+		e.UserId,
+		e.ApplName,
+		ibmmq.MQItoString("EVO", int(e.Origin)),
+		cmd)
+}
+
+/*
+ParseCommandEvent builds a CommandEvent from a message got from the command event queue
+*/
+func ParseCommandEvent(md *ibmmq.MQMD, buf []byte) (*CommandEvent, error) {
+	traceEntry("ParseCommandEvent")
+
+	cfh, offset := ibmmq.ReadPCFHeader(buf)
+	if cfh == nil || cfh.Type != ibmmq.MQCFT_EVENT || cfh.Command != ibmmq.MQCMD_COMMAND_EVENT {
+		err := MQMetricError{Err: "Message is not a command event",
+			MQReturn: &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_FORMAT_ERROR}}
+		traceExitErr("ParseCommandEvent", 1, err)
+		return nil, err
+	}
+
+	e := &CommandEvent{}
+	if md != nil {
+		e.Time = md.PutDateTime
+	}
+
+	for i := 0; i < int(cfh.ParameterCount) && offset < len(buf); i++ {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+
+		switch elem.Parameter {
+		case ibmmq.MQGACF_COMMAND_CONTEXT:
+			for _, ge := range elem.GroupList {
+				parseCommandContext(e, ge)
+			}
+		case ibmmq.MQGACF_COMMAND_DATA:
+			e.Parameters = make([]*ibmmq.PCFParameter, 0)
+			for _, ge := range elem.GroupList {
+				if ge.Parameter == ibmmq.MQCACF_COMMAND_MQSC && len(ge.String) > 0 {
+					e.MQSC = strings.TrimSpace(ge.String[0])
+				} else {
+					e.Parameters = append(e.Parameters, ge)
+				}
+			}
+		}
+	}
+
+	if cfh.Reason == ibmmq.MQRC_COMMAND_MQSC {
+		e.mqsc = true
+		e.Parameters = nil
+	}
+
+	traceExit("ParseCommandEvent", 0)
+	return e, nil
+}
+
+func parseCommandContext(e *CommandEvent, elem *ibmmq.PCFParameter) {
+	switch elem.Parameter {
+	case ibmmq.MQCACF_EVENT_USER_ID:
+		e.UserId = trimToNull(elem.String[0])
+	case ibmmq.MQCACF_EVENT_Q_MGR:
+		e.QMgrName = trimToNull(elem.String[0])
+	case ibmmq.MQCACF_EVENT_APPL_NAME:
+		e.ApplName = trimToNull(elem.String[0])
+	case ibmmq.MQCACF_EVENT_APPL_IDENTITY:
+		e.ApplIdentity = trimToNull(elem.String[0])
+	case ibmmq.MQCACF_EVENT_APPL_ORIGIN:
+		e.ApplOrigin = trimToNull(elem.String[0])
+	case ibmmq.MQBACF_EVENT_ACCOUNTING_TOKEN:
+		e.AccountingToken = elem.String[0]
+	case ibmmq.MQIACF_EVENT_APPL_TYPE:
+		e.ApplType = int32(elem.Int64Value[0])
+	case ibmmq.MQIACF_EVENT_ORIGIN:
+		e.Origin = int32(elem.Int64Value[0])
+	case ibmmq.MQIACF_COMMAND:
+		e.Command = int32(elem.Int64Value[0])
+	}
+}
+
+/*
+ReadCommandEvents returns the command events currently on the queue, up to a maximum
+of max events. It does not wait for new events to arrive. An empty qName means
+that the DefaultCommandEventQueue is used. The queue stays open until EndConnection.
+*/
+func ReadCommandEvents(qName string, browse bool, max int) ([]*CommandEvent, error) {
+	traceEntry("ReadCommandEvents")
+	ci := getConnection(GetConnectionKey())
+	events, err := readCommandEvents(ci, qName, browse, max)
+	traceExitErr("ReadCommandEvents", 0, err)
+	return events, err
+}
+
+/*
+StreamCommandEvents starts a goroutine that checks for command events at the given
+interval and sends them on the returned channel. The channel is closed when the stop
+channel is closed, or if reading from the queue fails. The error is logged.
+*/
+func StreamCommandEvents(qName string, browse bool, interval time.Duration, stop <-chan struct{}) <-chan *CommandEvent {
+	traceEntry("StreamCommandEvents")
+
+	ci := getConnection(GetConnectionKey())
+	c := make(chan *CommandEvent)
+
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			events, err := readCommandEvents(ci, qName, browse, 0)
+			if err != nil {
+				logError("Cannot read command events: %v", err)
+				return
+			}
+			for _, e := range events {
+				select {
+				case c <- e:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	traceExit("StreamCommandEvents", 0)
+	return c
+}
+
+func readCommandEvents(ci *connectionInfo, qName string, browse bool, max int) ([]*CommandEvent, error) {
+	var err error
+
+	events := make([]*CommandEvent, 0)
+	if ci.replay != nil {
+		return events, nil
+	}
+
+	if !ci.si.cmdEventQOpened {
+		if qName == "" {
+			qName = DefaultCommandEventQueue
+		}
+		mqod := ibmmq.NewMQOD()
+		mqod.ObjectType = ibmmq.MQOT_Q
+		mqod.ObjectName = qName
+		openOptions := ibmmq.MQOO_FAIL_IF_QUIESCING
+		if browse {
+			openOptions |= ibmmq.MQOO_BROWSE
+		} else {
+			openOptions |= ibmmq.MQOO_INPUT_SHARED
+		}
+		ci.si.cmdEventQObj, err = ci.si.qMgr.Open(mqod, openOptions)
+		if err != nil {
+			return events, MQMetricError{Err: fmt.Sprintf("Cannot open queue %s", qName), MQReturn: err.(*ibmmq.MQReturn)}
+		}
+		ci.si.cmdEventQOpened = true
+		ci.si.cmdEventBrowse = browse
+	}
+
+	buf := make([]byte, 0, 32768)
+	for max <= 0 || len(events) < max {
+		md := ibmmq.NewMQMD()
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_FAIL_IF_QUIESCING | ibmmq.MQGMO_CONVERT
+		if ci.si.cmdEventBrowse {
+			gmo.Options |= ibmmq.MQGMO_BROWSE_NEXT
+		}
+
+		var datalen int
+		buf, datalen, err = ci.si.cmdEventQObj.GetSlice(md, gmo, buf[:0])
+		if err != nil {
+			mqreturn := err.(*ibmmq.MQReturn)
+			if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				err = nil
+				break
+			}
+			if mqreturn.MQRC == ibmmq.MQRC_TRUNCATED_MSG_FAILED {
+				buf = make([]byte, 0, datalen)
+				continue
+			}
+			return events, MQMetricError{Err: "Cannot get command event", MQReturn: mqreturn}
+		}
+
+		e, perr := ParseCommandEvent(md, buf)
+		if perr != nil {
+			// Something else has been put to the queue. Skip it.
+			logDebug("Ignoring message on command event queue: %v", perr)
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return events, err
+}
+
+func closeCommandEventQueue(ci *connectionInfo) {
+	if ci.si.cmdEventQOpened {
+		ci.si.cmdEventQObj.Close(0)
+		ci.si.cmdEventQOpened = false
+	}
+}
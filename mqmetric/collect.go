@@ -0,0 +1,170 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file runs one complete collection cycle - the publications and each type of status.
+The steps run one after the other, as they share the connection's status sets and the
+discovered object maps, but a failing step does not stop the later ones from running.
+
+The errors from all the steps are returned together. A budget can be given for the whole
+cycle: when it runs out, the steps that have not been started are reported as timed out.
+MQ calls cannot be interrupted, so a step that is running at that point is allowed to
+finish before CollectOnce returns. The budget therefore bounds when the last step
+starts, and a slow step can take the cycle past it by up to its own wait interval.
+
+The connection is the one for the current key when CollectOnce is called. Only one
+cycle can run at a time on each connection; a second call while the first is running
+returns ErrCollectBusy.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The names of the steps, used in the CollectError
+const (
+	COLLECT_PUBLICATIONS = "publications"
+	COLLECT_QUEUES       = "queues"
+	COLLECT_CHANNELS     = "channels"
+	COLLECT_QMGR         = "qmgr"
+)
+
+// ErrCollectBusy is returned when the steps of an earlier cycle are still running
+var ErrCollectBusy = errors.New("Previous collection cycle has not finished")
+
+/*
+CollectOptions says which steps CollectOnce runs. A step is skipped when its patterns
+are empty or its flag is false.
+*/
+type CollectOptions struct {
+	Publications    bool
+	QueuePatterns   string
+	ChannelPatterns string
+	QMgrStatus      bool
+	Budget          time.Duration // Most time for the whole cycle. 0 means only the context's deadline applies
+}
+
+/*
+CollectError has the errors from each step of a cycle that failed, keyed by the
+COLLECT_* names. Steps that ran out of time have the context's error.
+*/
+type CollectError struct {
+	Errors map[string]error
+}
+
+func (e *CollectError) Error() string {
+	steps := make([]string, 0, len(e.Errors))
+	for step := range e.Errors {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+
+	l := make([]string, len(steps))
+	for i, step := range steps {
+		l[i] = fmt.Sprintf("%s: %v", step, e.Errors[step])
+	}
+	return "Collection failed for " + strings.Join(l, "; ")
+}
+
+type collectStep struct {
+	name string
+	f    func(k string, ci *connectionInfo) error
+}
+
+/*
+CollectOnce runs the steps given in the options in turn, until they have all finished or
+the budget runs out or the context is cancelled. It returns nil when every step worked,
+otherwise a *CollectError.
+*/
+func CollectOnce(ctx context.Context, opts CollectOptions) error {
+	traceEntry("CollectOnce")
+
+	k := GetConnectionKey()
+	ci := getConnection(k)
+
+	var steps []collectStep
+	if opts.Publications {
+		steps = append(steps, collectStep{COLLECT_PUBLICATIONS, processPublicationsFor})
+	}
+	if opts.QueuePatterns != "" {
+		steps = append(steps, collectStep{COLLECT_QUEUES, func(k string, ci *connectionInfo) error {
+			return collectQueueStatusFor(k, ci, opts.QueuePatterns)
+		}})
+	}
+	if opts.ChannelPatterns != "" {
+		steps = append(steps, collectStep{COLLECT_CHANNELS, func(k string, ci *connectionInfo) error {
+			return collectChannelStatusFor(k, ci, opts.ChannelPatterns)
+		}})
+	}
+	if opts.QMgrStatus {
+		steps = append(steps, collectStep{COLLECT_QMGR, collectQueueManagerStatusFor})
+	}
+
+	if err := ctx.Err(); err != nil {
+		traceExitErr("CollectOnce", 1, err)
+		return err
+	}
+	if !atomic.CompareAndSwapInt32(&ci.cycle, 0, 1) {
+		traceExitErr("CollectOnce", 2, ErrCollectBusy)
+		return ErrCollectBusy
+	}
+	defer atomic.StoreInt32(&ci.cycle, 0)
+
+	if opts.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Budget)
+		defer cancel()
+	}
+
+	err := runCollectSteps(ctx, k, ci, steps)
+
+	traceExitErr("CollectOnce", 0, err)
+	return err
+}
+
+// Run the steps in order and gather the errors. No step is started once the context is
+// done, and those steps are reported as timed out. A step that is already running is
+// left to finish, so nothing is still updating the connection's data when this returns.
+func runCollectSteps(ctx context.Context, k string, ci *connectionInfo, steps []collectStep) error {
+	errs := make(map[string]error)
+
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			logError("Collection of %s did not finish in time", step.name)
+			errs[step.name] = err
+			continue
+		}
+		if err := step.f(k, ci); err != nil {
+			errs[step.name] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &CollectError{Errors: errs}
+	}
+	return nil
+}
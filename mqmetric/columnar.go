@@ -0,0 +1,183 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has an alternative way of storing the values of published metrics, for
+collectors that monitor very large numbers of queues.
+
+Normally each MonElement has a Values map, keyed by the object name. With tens of thousands
+of queues, and around a hundred queue-level elements, those maps take most of the memory
+used by a collector and a lot of its garbage collection time, as every entry has its own
+copy of the key. When ColumnarValues is set in the DiscoverConfig, each MonType instead has
+a table of the object names it has seen, and each element has an array of values indexed
+by the position of the name in that table. The Values maps are then nil.
+
+Collectors that want to use this mode must read the values through the MonElement
+methods (Value, Range, Len and ClearValues) instead of the Values map. The methods
+work in either mode, so a collector can be changed first and the option turned on later.
+
+Names are not removed from a type's table when an object is deleted, as that would move
+the other entries. The table is rebuilt on the next rediscovery.
+*/
+
+// The object names that a type has values for
+type objectTable struct {
+	names []string
+	index map[string]int
+}
+
+// The values for one element, with a bit set for each entry that has a value
+type valueColumn struct {
+	objects *objectTable
+	values  []int64
+	present []uint64
+	count   int
+}
+
+func newObjectTable() *objectTable {
+	return &objectTable{names: make([]string, 0), index: make(map[string]int)}
+}
+
+// Return the position of the name, adding it if necessary
+func (t *objectTable) add(name string) int {
+	if i, ok := t.index[name]; ok {
+		return i
+	}
+	i := len(t.names)
+	t.names = append(t.names, name)
+	t.index[name] = i
+	return i
+}
+
+// Switch every element in the metrics to columnar storage. Elements of the same type
+// share a table of object names.
+func useColumnarValues(metrics *AllMetrics) {
+	for _, cl := range metrics.Classes {
+		for _, ty := range cl.Types {
+			t := newObjectTable()
+			for _, elem := range ty.Elements {
+				elem.Values = nil
+				elem.column = &valueColumn{objects: t}
+			}
+		}
+	}
+}
+
+func (c *valueColumn) has(i int) bool {
+	return i < len(c.values) && c.present[i/64]&(1<<uint(i%64)) != 0
+}
+
+/*
+Value returns the value for an object, and whether there is one
+*/
+func (elem *MonElement) Value(key string) (int64, bool) {
+	if elem.column == nil {
+		v, ok := elem.Values[key]
+		return v, ok
+	}
+
+	c := elem.column
+	i, ok := c.objects.index[key]
+	if !ok || !c.has(i) {
+		return 0, false
+	}
+	return c.values[i], true
+}
+
+/*
+Range calls the function for each object that has a value. The order is not defined.
+*/
+func (elem *MonElement) Range(f func(key string, value int64)) {
+	if elem.column == nil {
+		for k, v := range elem.Values {
+			f(k, v)
+		}
+		return
+	}
+
+	c := elem.column
+	for i := range c.values {
+		if c.has(i) {
+			f(c.objects.names[i], c.values[i])
+		}
+	}
+}
+
+/*
+Len returns the number of objects that have a value
+*/
+func (elem *MonElement) Len() int {
+	if elem.column == nil {
+		return len(elem.Values)
+	}
+	return elem.column.count
+}
+
+/*
+ClearValues removes all the values, typically after a collector has processed them.
+In columnar mode, the storage is kept for the next collection.
+*/
+func (elem *MonElement) ClearValues() {
+	if elem.column == nil {
+		elem.Values = make(map[string]int64)
+		return
+	}
+
+	c := elem.column
+	for i := range c.present {
+		c.present[i] = 0
+	}
+	c.count = 0
+}
+
+func (elem *MonElement) setValue(key string, value int64) {
+	if elem.column == nil {
+		elem.Values[key] = value
+		return
+	}
+
+	c := elem.column
+	i := c.objects.add(key)
+	for i >= len(c.values) {
+		c.values = append(c.values, 0)
+	}
+	for i/64 >= len(c.present) {
+		c.present = append(c.present, 0)
+	}
+	if !c.has(i) {
+		c.present[i/64] |= 1 << uint(i%64)
+		c.count++
+	}
+	c.values[i] = value
+}
+
+func (elem *MonElement) deleteValue(key string) {
+	if elem.column == nil {
+		delete(elem.Values, key)
+		return
+	}
+
+	c := elem.column
+	if i, ok := c.objects.index[key]; ok && c.has(i) {
+		c.present[i/64] &^= 1 << uint(i%64)
+		c.count--
+	}
+}
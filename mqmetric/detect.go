@@ -0,0 +1,188 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2016, 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides an alternative to InitConnection where the collector does not
+need to know in advance what kind of queue manager it is talking to. After connecting,
+we look at the platform, command level and pub/sub configuration, and try to read the
+list of monitoring classes that the queue manager publishes. From that we can decide
+whether to use the published resource statistics or to fall back to polling with
+the status commands.
+*/
+
+import (
+	"fmt"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const (
+	STRATEGY_PUBLICATIONS = "publications"
+	STRATEGY_POLLING      = "polling"
+)
+
+// The Environment structure describes what was found about the queue manager, and
+// the choices that have been made as a result. A collector can use the MetaPrefix
+// and UsePublications fields directly when building its DiscoverConfig, and report
+// the Strategy and Reason through its own health or information endpoints.
+type Environment struct {
+	QMgrName        string
+	Platform        int32
+	CommandLevel    int32
+	PubSubEnabled   bool
+	MonitorClasses  []string
+	UsePublications bool
+	UseStatus       bool
+	MetaPrefix      string
+	Strategy        string
+	Reason          string
+}
+
+/*
+ConnectAndDetect connects to the queue manager in the same way as InitConnection, but
+ignores the UsePublications and UseStatus settings in the ConnectionConfig. Instead,
+those values are chosen based on what the queue manager is able to provide. The
+resulting Environment is also available later from GetEnvironment.
+*/
+func ConnectAndDetect(qMgrName string, replyQ string, replyQ2 string, cc *ConnectionConfig) (*Environment, error) {
+	return connectAndDetect("", qMgrName, replyQ, replyQ2, cc)
+}
+func ConnectAndDetectKey(key string, qMgrName string, replyQ string, replyQ2 string, cc *ConnectionConfig) (*Environment, error) {
+	return connectAndDetect(key, qMgrName, replyQ, replyQ2, cc)
+}
+
+func connectAndDetect(key string, qMgrName string, replyQ string, replyQ2 string, cc *ConnectionConfig) (*Environment, error) {
+	traceEntryF("connectAndDetect", "QMgrName %s", qMgrName)
+
+	// Start by connecting without publications, as that always succeeds
+	// regardless of the queue manager version or platform. We can turn
+	// them on later if it turns out they are usable.
+	localcc := *cc
+	localcc.UsePublications = false
+	localcc.UseStatus = true
+
+	err := initConnectionKey(key, qMgrName, replyQ, replyQ2, &localcc)
+	if err != nil {
+		traceExitErr("connectAndDetect", 1, err)
+		return nil, err
+	}
+
+	ci := getConnection(GetConnectionKey())
+
+	env := new(Environment)
+	env.QMgrName = ci.si.resolvedQMgrName
+	env.Platform = ci.si.platform
+	env.CommandLevel = ci.si.commandLevel
+	env.MonitorClasses = make([]string, 0)
+
+	v, err := ci.si.qMgrObject.Inq([]int32{ibmmq.MQIA_PUBSUB_MODE})
+	if err == nil {
+		env.PubSubEnabled = v[ibmmq.MQIA_PUBSUB_MODE].(int32) != ibmmq.MQPSM_DISABLED
+	} else {
+		// Not fatal - just means we can't use the publications
+		logDebug("Cannot inquire PSMODE: %v", err)
+		err = nil
+	}
+
+	if ci.si.targetQMgrName != "" {
+		env.Reason = "Published statistics cannot be collected through a gateway queue manager"
+	} else if env.Platform == ibmmq.MQPL_ZOS {
+		env.Reason = "Resource statistics are not published by z/OS queue managers"
+	} else if env.CommandLevel < 900 && env.Platform != ibmmq.MQPL_APPLIANCE {
+		env.Reason = fmt.Sprintf("Queue manager command level %d is below the minimum of 900 for published statistics", env.CommandLevel)
+	} else if !env.PubSubEnabled {
+		env.Reason = "Publish/subscribe engine is disabled on the queue manager (PSMODE)"
+	} else {
+		classes, e2 := detectMonitorClasses()
+		if e2 != nil {
+			env.Reason = fmt.Sprintf("Cannot read monitoring metadata: %v", e2)
+		} else if len(classes) == 0 {
+			env.Reason = "Queue manager does not publish any monitoring classes"
+		} else {
+			env.MonitorClasses = classes
+			env.UsePublications = true
+			env.Reason = "Queue manager publishes resource statistics"
+		}
+	}
+
+	// The status queries are always available as a fallback, and are still useful for
+	// channels and other object types even when publications are being used.
+	env.UseStatus = true
+	if env.UsePublications {
+		env.Strategy = STRATEGY_PUBLICATIONS
+		env.MetaPrefix = ""
+		ci.usePublications = true
+		// Start from a clean set of subscriptions, as initConnectionKey would
+		// have done if publications had been requested there.
+		if ci.durableSubPrefix != "" && ci.leader == nil {
+			clearDurableSubscriptions(ci.durableSubPrefix, ci.si.cmdQObj, ci.si.statusReplyQObj)
+		}
+	} else {
+		env.Strategy = STRATEGY_POLLING
+		ci.usePublications = false
+	}
+	ci.useStatus = env.UseStatus
+	ci.environment = env
+
+	logInfo("Collection strategy for %s: %s (%s)", env.QMgrName, env.Strategy, env.Reason)
+
+	traceExitF("connectAndDetect", 0, "Strategy: %s", env.Strategy)
+	return env, err
+}
+
+// Subscribe to the root of the metadata tree to see which classes of
+// metric are available. This is the same topic that is used at the start of
+// the discovery process, but we only need the class names here.
+func detectMonitorClasses() ([]string, error) {
+	traceEntry("detectMonitorClasses")
+
+	ci := getConnection(GetConnectionKey())
+	classes := make([]string, 0)
+
+	rootTopic := "$SYS/MQ/INFO/QMGR/" + ci.si.resolvedQMgrName + "/Monitor/METADATA/CLASSES"
+	data, err := getMetadata(rootTopic, true)
+	if err == nil {
+		elemList, _ := parsePCFResponse(data)
+		for i := 0; i < len(elemList); i++ {
+			if elemList[i].Type != ibmmq.MQCFT_GROUP {
+				continue
+			}
+			for _, elem := range elemList[i].GroupList {
+				if elem.Parameter == ibmmq.MQCAMO_MONITOR_CLASS {
+					classes = append(classes, elem.String[0])
+				}
+			}
+		}
+	}
+
+	traceExitErr("detectMonitorClasses", 0, err)
+	return classes, err
+}
+
+// GetEnvironment returns the information discovered by ConnectAndDetect for
+// the current connection. It returns nil if the connection was made in some other way.
+func GetEnvironment() *Environment {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil {
+		return nil
+	}
+	return ci.environment
+}
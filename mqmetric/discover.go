@@ -38,6 +38,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
@@ -51,6 +52,7 @@ type MonElement struct {
 	MetricName     string // Reformatted description suitable as label
 	Datatype       int32
 	Values         map[string]int64
+	column         *valueColumn // Used instead of Values with ColumnarValues
 }
 
 // MonType describes the "types" of data generated by MQ. Each class generates
@@ -74,6 +76,7 @@ type MonClass struct {
 	typesTopic  string
 	flags       int
 	Types       map[int]*MonType
+	subHobj     *MQTopicDescriptor // Used when there is one wildcard subscription for the class
 }
 
 // The AllMetrics structure is the top of the tree, holding the set of classes.
@@ -89,17 +92,36 @@ type ObjInfo struct {
 	firstCollection bool // To indicate discard needed of first stat
 	Description     string
 	// Qmgr attributes
-	QMgrName string
-	HostName string
+	QMgrName         string
+	HostName         string
+	Version          string // Such as "09040000"
+	InstallationName string
 	// These are used for queue information
 	AttrMaxDepth int64  // The queue attribute value. Not the max depth reported by RESET QSTATS
 	AttrUsage    int64  // Normal or XMITQ
 	Cluster      string // The name of a single cluster in which the queue is shared (CLUSTERNL not supported here)
+	// 1 when the queue is PUT(DISABLED) or GET(DISABLED), or has TRIGGER set
+	AttrInhibitPut     int64
+	AttrInhibitGet     int64
+	AttrTriggerControl int64
+	inhibitKnown       bool // The three values above have been inquired
+	// The MONQ and NPMCLASS attributes. Use EnumString to convert them to names.
+	AttrMonitoring  int64
+	AttrNPMClass    int64
+	monitoringKnown bool
+	npmClassKnown   bool
+	// The MONQ, STATQ and ACCTQ values, and which have been warned about. See qmonitoring.go
+	monitoringValues map[int32]int64
+	monitoringWarned map[int32]bool
 	// Some channel information
 	AttrMaxInst  int64
 	AttrMaxInstC int64
 	AttrCurInst  int64 // Currently active instances of this channel - would only work if "jobname" disabled
 	AttrChlType  int64
+	ChannelInfo  map[string]string // Definition attributes reported as labels. See GetChannelInfo
+
+	// Queues and channels
+	AttrMaxMsgLength int64
 }
 
 // QMgrMapKey can never be a real object name and is therefore useful in
@@ -112,18 +134,13 @@ const maxBufSize = 100 * 1024 * 1024 // 100 MB
 
 const defaultMaxQDepth = 5000
 
-var qInfoMap map[string]*ObjInfo   // These maps probably need to be moved into the ci.si structures but at least they
-var chlInfoMap map[string]*ObjInfo // are not public interface elements
-var amqpInfoMap map[string]*ObjInfo
-var qMgrInfo = new(ObjInfo)
-var nhaInfoMap map[string]*ObjInfo
-
 var locale string
 
 func GetDiscoveredQueues() []string {
 	traceEntry("GetDiscoveredQueues")
+	ci := getConnection(GetConnectionKey())
 	keys := make([]string, 0)
-	for key := range qInfoMap {
+	for key := range ci.qInfoMap {
 		keys = append(keys, key)
 	}
 	traceExit("GetDiscoveredQueues", 0)
@@ -163,7 +180,7 @@ func VerifyConfig() (int32, error) {
 
 	if err == nil {
 		selectors := []int32{ibmmq.MQIA_MAX_Q_DEPTH, ibmmq.MQIA_DEFINITION_TYPE}
-		v, err = ci.si.replyQObj.InqMap(selectors)
+		v, err = ci.si.replyQObj.Inq(selectors)
 		if err == nil {
 			maxQDepth := v[ibmmq.MQIA_MAX_Q_DEPTH].(int32)
 			// Function has tuning based on number of queues to be monitored
@@ -174,7 +191,7 @@ func VerifyConfig() (int32, error) {
 			// as MQ publications are at 10 second interval by default (and no public tuning)
 			// and assume monitor collection interval is one minute
 			// Since we don't do pubsub-based collection on z/OS, this qdepth doesn't matter
-			recommendedDepth := (20 + len(qInfoMap)*5) * 6
+			recommendedDepth := (20 + len(ci.qInfoMap)*5) * 6
 			if maxQDepth < int32(recommendedDepth) && ci.usePublications {
 				err = fmt.Errorf("Warning: Maximum queue depth on %s may be too low. Current value = %d. Suggested depth based on queue count is at least %d", ci.si.replyQBaseName, maxQDepth, recommendedDepth)
 				compCode = ibmmq.MQCC_WARNING
@@ -185,8 +202,8 @@ func VerifyConfig() (int32, error) {
 			// exactly the number of responses to match the number of actual channels. Of course, that number may change in the
 			// lifetime of the system but we only check what's possible at startup.If the channels are being named via a set of
 			// separate patterns, then this will overestimate what's needed. Hence it's a warning, not an error.
-			recommendedDepth = len(chlInfoMap) + 20
-			if maxQDepth < int32(recommendedDepth) && len(chlInfoMap) > 0 {
+			recommendedDepth = len(ci.chlInfoMap) + 20
+			if maxQDepth < int32(recommendedDepth) && len(ci.chlInfoMap) > 0 {
 				err = fmt.Errorf("Warning: Maximum queue depth on %s may be too low. Current value = %d. Suggested depth based on channel count is at least %d\n", ci.si.replyQBaseName, maxQDepth, recommendedDepth)
 				compCode = ibmmq.MQCC_WARNING
 			}
@@ -219,15 +236,19 @@ func DiscoverAndSubscribe(dc DiscoverConfig) error {
 	traceEntry("DiscoverAndSubscribe")
 	ci := getConnection(GetConnectionKey())
 	ci.discoveryDone = true
+	ci.discoverConfig = &dc
 	redo := false
 
-	qInfoMap = make(map[string]*ObjInfo)
-	nhaInfoMap = make(map[string]*ObjInfo)
+	ci.qInfoMap = make(map[string]*ObjInfo)
+	ci.nhaInfoMap = make(map[string]*ObjInfo)
 	nhaInfoElem := new(ObjInfo)
 	nhaInfoElem.exists = true
-	nhaInfoMap["#"] = nhaInfoElem
+	ci.nhaInfoMap["#"] = nhaInfoElem
 
 	err := discoverAndSubscribe(dc, redo)
+	if err == nil {
+		recordDiscoveryChanges(ci)
+	}
 
 	traceExitErr("DiscoverAndSubscribe", 0, err)
 	return err
@@ -237,22 +258,29 @@ func RediscoverAndSubscribe(dc DiscoverConfig) error {
 
 	ci := getConnection(GetConnectionKey())
 	ci.discoveryDone = true
+	ci.discoverConfig = &dc
 	redo := true
 
 	// Assume queues have been deleted and we will tidy up later.
 	// The flag is reset to true during the discovery process if the queue still exists
-	for _, qi := range qInfoMap {
+	for _, qi := range ci.qInfoMap {
 		qi.exists = false
 	}
 
 	err := discoverAndSubscribe(dc, redo)
 
 	// We now know if an object still exists; remove it from the map if not.
-	for key, qi := range qInfoMap {
+	for key, qi := range ci.qInfoMap {
 		if !qi.exists {
-			delete(qInfoMap, key)
+			delete(ci.qInfoMap, key)
+			if err == nil {
+				queueRemoved(ci, key, dc.MonitoredQueues.StaleMarkers)
+			}
 		}
 	}
+	if err == nil {
+		recordDiscoveryChanges(ci)
+	}
 
 	traceExitErr("RediscoverAndSubscribe", 0, err)
 
@@ -265,19 +293,24 @@ func RediscoverAttributes(objectType int32, objectPatterns string) error {
 	var fn func(string, map[string](*ObjInfo)) error
 
 	traceEntry("RediscoverAttributes")
+	ci := getConnection(GetConnectionKey())
+	var oldInfoMap map[string](*ObjInfo)
+
 	switch objectType {
 	case ibmmq.MQOT_CHANNEL:
 		// Always start with a clean slate for these maps
-		chlInfoMap = make(map[string]*ObjInfo)
-		infoMap = chlInfoMap
+		oldInfoMap = ci.chlInfoMap
+		ci.chlInfoMap = make(map[string]*ObjInfo)
+		infoMap = ci.chlInfoMap
 		fn = inquireChannelAttributes
 	case OT_CHANNEL_AMQP:
 		// Always start with a clean slate for these maps
-		amqpInfoMap = make(map[string]*ObjInfo)
-		infoMap = amqpInfoMap
+		oldInfoMap = ci.amqpInfoMap
+		ci.amqpInfoMap = make(map[string]*ObjInfo)
+		infoMap = ci.amqpInfoMap
 		fn = inquireAMQPChannelAttributes
 	default:
-		err = fmt.Errorf("Unsupported object type: ", objectType)
+		err = fmt.Errorf("Unsupported object type: %d", objectType)
 	}
 
 	if err == nil {
@@ -288,6 +321,15 @@ func RediscoverAttributes(objectType int32, objectPatterns string) error {
 				delete(infoMap, key)
 			}
 		}
+
+		// Only report removals if we got a complete answer
+		if err == nil {
+			for key := range oldInfoMap {
+				if _, ok := infoMap[key]; !ok {
+					addRemovedObject(ci, objectType, key)
+				}
+			}
+		}
 	}
 
 	traceExitErr("RediscoverAttributes", 0, err)
@@ -295,6 +337,55 @@ func RediscoverAttributes(objectType int32, objectPatterns string) error {
 	return err
 }
 
+/*
+GetRemovedObjects returns the names of objects of the given type that have been
+removed by rediscovery since the previous call, and then forgets them. The supported
+types are queues (from RediscoverAndSubscribe), and channels and AMQP channels (from
+RediscoverAttributes).
+
+A collector can use this list to tell a backend that the series for these objects have
+ended, instead of them continuing to show the last known value. If the StaleMarkers option
+was set for the monitored queues, the published metrics for each removed queue are set to
+zero at the time of the rediscovery, so that one final value is reported before they are
+cleared in the normal way.
+*/
+func GetRemovedObjects(objectType int32) []string {
+	ci := getConnection(GetConnectionKey())
+	rc := ci.removedObjects[objectType]
+	delete(ci.removedObjects, objectType)
+	if rc == nil {
+		rc = make([]string, 0)
+	}
+	return rc
+}
+
+func addRemovedObject(ci *connectionInfo, objectType int32, name string) {
+	logDebug("Object %s of type %d has been removed", name, objectType)
+	if ci.removedObjects == nil {
+		ci.removedObjects = make(map[int32][]string)
+	}
+	ci.removedObjects[objectType] = append(ci.removedObjects[objectType], name)
+}
+
+// A queue is no longer being monitored. Its last published values are either removed
+// or replaced by a zero.
+func queueRemoved(ci *connectionInfo, qName string, staleMarkers bool) {
+	addRemovedObject(ci, ibmmq.MQOT_Q, qName)
+	for _, cl := range ci.publishedMetrics.Classes {
+		for _, ty := range cl.Types {
+			for _, elem := range ty.Elements {
+				if _, ok := elem.Value(qName); ok {
+					if staleMarkers {
+						elem.setValue(qName, 0)
+					} else {
+						elem.deleteValue(qName)
+					}
+				}
+			}
+		}
+	}
+}
+
 func discoverAndSubscribe(dc DiscoverConfig, redo bool) error {
 	var err error
 
@@ -310,13 +401,17 @@ func discoverAndSubscribe(dc DiscoverConfig, redo bool) error {
 	// to explicit names so that subscriptions work.
 	if err == nil {
 		if dc.MonitoredQueues.UseWildcard {
-			err = discoverQueues(dc.MonitoredQueues.ObjectNames)
+			if ci.replay != nil {
+				err = ci.replay.discoverQueues(dc.MonitoredQueues.ObjectNames)
+			} else {
+				err = discoverQueues(dc.MonitoredQueues.ObjectNames)
+			}
 		} else {
 			qList := strings.Split(dc.MonitoredQueues.ObjectNames, ",")
 			// Make sure the names are reasonably valid
 			for i := 0; i < len(qList); i++ {
 				key := strings.TrimSpace(qList[i])
-				qInfoMap[key] = new(ObjInfo)
+				ci.qInfoMap[key] = new(ObjInfo)
 			}
 		}
 
@@ -328,16 +423,17 @@ func discoverAndSubscribe(dc DiscoverConfig, redo bool) error {
 		// in the amqsrua-style of resource subscriptions. Add a few extra just in case.
 		// We can ignore this check when we're using durable subscriptions for the queue info - the default of 256 will
 		// be plenty.
-		if ci.durableSubPrefix == "" {
-			recommendedHandles := 20 + len(qInfoMap)*5 + 10
+		if ci.durableSubPrefix == "" && ci.replay == nil {
+			recommendedHandles := 20 + len(ci.qInfoMap)*5 + 10
 			if ci.si.maxHandles < int32(recommendedHandles) && ci.usePublications {
 				err = fmt.Errorf("MAXHANDS attribute on queue manager needs increasing. Current value = %d. Recommended minimum based on number of monitored queues = %d", ci.si.maxHandles, recommendedHandles)
 			}
 		}
 	}
 
-	// Subscribe to all of the various topics
-	if err == nil {
+	// Subscribe to all of the various topics. There is nothing to subscribe
+	// to when replaying a recording.
+	if err == nil && ci.replay == nil {
 		err = createSubscriptions()
 	}
 
@@ -360,9 +456,6 @@ func discoverAndSubscribe(dc DiscoverConfig, redo bool) error {
 
 func discoverClasses(dc DiscoverConfig, metaPrefix string) error {
 	var data []byte
-	//var sub ibmmq.MQObject
-	var mqtd *MQTopicDescriptor
-	var metaReplyQObj ibmmq.MQObject
 	var err error
 	var rootTopic string
 
@@ -376,12 +469,8 @@ func discoverClasses(dc DiscoverConfig, metaPrefix string) error {
 	} else {
 		rootTopic = metaPrefix + "/INFO/QMGR/" + ci.si.resolvedQMgrName + "/Monitor/METADATA/CLASSES"
 	}
-	mqtd, err = subscribeManaged(rootTopic, &metaReplyQObj)
+	data, err = getMetadata(rootTopic, true)
 	if err == nil {
-		data, err = getMessageWithHObj(true, metaReplyQObj)
-		defer metaReplyQObj.Close(0)
-		defer mqtd.unsubscribe()
-
 		elemList, _ := parsePCFResponse(data)
 
 		for i := 0; i < len(elemList); i++ {
@@ -437,18 +526,12 @@ func discoverClasses(dc DiscoverConfig, metaPrefix string) error {
 
 func discoverTypes(dc DiscoverConfig, cl *MonClass) error {
 	var data []byte
-	var mqtd *MQTopicDescriptor
-	var metaReplyQObj ibmmq.MQObject
 	var err error
 
 	traceEntry("discoverTypes")
 
-	mqtd, err = subscribeManaged(cl.typesTopic, &metaReplyQObj)
+	data, err = getMetadata(cl.typesTopic, true)
 	if err == nil {
-		data, err = getMessageWithHObj(true, metaReplyQObj)
-		defer metaReplyQObj.Close(0)
-		defer mqtd.unsubscribe()
-
 		elemList, _ := parsePCFResponse(data)
 
 		for i := 0; i < len(elemList); i++ {
@@ -501,17 +584,11 @@ func discoverTypes(dc DiscoverConfig, cl *MonClass) error {
 func discoverElements(dc DiscoverConfig, ty *MonType) error {
 	var err error
 	var data []byte
-	var mqtd *MQTopicDescriptor
-	var metaReplyQObj ibmmq.MQObject
 	var elem *MonElement
 
 	traceEntry("discoverElements")
-	mqtd, err = subscribeManaged(ty.elementTopic, &metaReplyQObj)
+	data, err = getMetadata(ty.elementTopic, true)
 	if err == nil {
-		data, err = getMessageWithHObj(true, metaReplyQObj)
-		defer metaReplyQObj.Close(0)
-		defer mqtd.unsubscribe()
-
 		elemList, _ := parsePCFResponse(data)
 
 		for i := 0; i < len(elemList); i++ {
@@ -565,8 +642,6 @@ func discoverElements(dc DiscoverConfig, ty *MonType) error {
 func discoverElementsNLS(dc DiscoverConfig, ty *MonType, locale string) error {
 	var err error
 	var data []byte
-	var mqtd *MQTopicDescriptor
-	var metaReplyQObj ibmmq.MQObject
 
 	traceEntry("discoverElementsNLS")
 	if locale == "" {
@@ -574,20 +649,14 @@ func discoverElementsNLS(dc DiscoverConfig, ty *MonType, locale string) error {
 		return nil
 	}
 
-	mqtd, err = subscribeManaged(ty.elementTopic+"/"+locale, &metaReplyQObj)
-	if err == nil {
-		// Don't wait - if there's nothing on that topic, then get out fast
-		data, err = getMessageWithHObj(false, metaReplyQObj)
-		metaReplyQObj.Close(0)
-		mqtd.unsubscribe()
-
-		if err != nil {
-			mqreturn := err.(*ibmmq.MQReturn)
-			if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
-				err = nil
-			}
+	// Don't wait - if there's nothing on that topic, then get out fast
+	data, err = getMetadata(ty.elementTopic+"/"+locale, false)
+	if err != nil {
+		if mqreturn, ok := err.(*ibmmq.MQReturn); ok && mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+			err = nil
 		}
-
+	}
+	if err == nil {
 		elemList, _ := parsePCFResponse(data)
 
 		for i := 0; i < len(elemList); i++ {
@@ -652,11 +721,22 @@ func discoverStats(dc DiscoverConfig) error {
 		return nil
 	}
 
+	// A previous run may have saved everything that the discovery would find
+	cached := false
+	cacheKey := newMetadataCache(ci, dc)
+	if useMetadataCache(ci, dc) {
+		cached = loadMetadataCache(dc.MetadataCacheFile, cacheKey, metrics)
+	}
+
 	// Then get the list of CLASSES
-	err = discoverClasses(dc, metaPrefix)
+	if cached {
+		ci.si.subsOpened = true
+	} else {
+		err = discoverClasses(dc, metaPrefix)
+	}
 
 	// For each CLASS, discover the TYPEs of data available
-	if err == nil {
+	if err == nil && !cached {
 		for _, cl := range metrics.Classes {
 			err = discoverTypes(dc, cl)
 			// And for each CLASS, discover the actual statistics elements
@@ -670,6 +750,25 @@ func discoverStats(dc DiscoverConfig) error {
 			}
 		}
 
+		// Save the complete set before any elements are filtered out
+		if err == nil && useMetadataCache(ci, dc) {
+			if e2 := saveMetadataCache(dc.MetadataCacheFile, cacheKey, metrics); e2 != nil {
+				logError("Cannot write metadata cache %s: %v", dc.MetadataCacheFile, e2)
+			}
+		}
+	}
+
+	if err == nil {
+		var count int
+		count, err = filterElements(dc, metrics)
+		if count > 0 {
+			logInfo("Removed %d elements from the published metrics", count)
+		}
+
+		if err == nil && dc.ColumnarValues {
+			useColumnarValues(metrics)
+		}
+
 		// Validate all discovered metric names are unique
 		// Need to add in if it's qmgr or q level
 		nameSet := make(map[string]struct{})
@@ -734,7 +833,19 @@ func discoverQueues(monitoredQueuePatterns string) error {
 	//    !A*, !SYSTEM*, B*, DEV.QUEUE.1
 	// If we know there are no exclusion patterns, then use the
 	// set directly as it is more efficient
-	if usingRegExp {
+	//
+	// Presets such as @XMITQ also need the full list of queues
+	if hasPresets(monitoredQueuePatterns) {
+		var xmitQueues []string
+		usingRegExp = true
+		allQueues, err = inquireObjects("*", ibmmq.MQOT_Q)
+		if err == nil {
+			xmitQueues, err = inquireXmitQueues()
+		}
+		if err == nil {
+			qList, err = filterWithPresets(monitoredQueuePatterns, allQueues, xmitQueues)
+		}
+	} else if usingRegExp {
 		allQueues, err = inquireObjects("*", ibmmq.MQOT_Q)
 		if err == nil {
 			qList = FilterRegExp(monitoredQueuePatterns, allQueues)
@@ -765,24 +876,21 @@ func discoverQueues(monitoredQueuePatterns string) error {
 				continue
 			}
 
-			if qInfoElem, ok = qInfoMap[qName]; !ok {
+			if qInfoElem, ok = ci.qInfoMap[qName]; !ok {
 				qInfoElem = new(ObjInfo)
 			}
 			qInfoElem.AttrMaxDepth = defaultMaxQDepth
 			qInfoElem.exists = true
-			qInfoMap[qName] = qInfoElem
+			ci.qInfoMap[intern(qName)] = qInfoElem
 		}
 
 		if ci.useStatus {
-			if usingRegExp {
-				for qName, _ := range qInfoMap {
-					if len(qName) > 0 {
-						inquireQueueAttributes(qName)
-					}
-				}
-			} else {
-				inquireQueueAttributes(monitoredQueuePatterns)
+			attrErr := inquireQueueAttributes(queueAttrRequests(monitoredQueuePatterns))
+			// The queues are still monitored, but some of their attributes may be missing
+			if attrErr != nil {
+				logError("Cannot inquire queue attributes: %v", attrErr)
 			}
+			checkQueueMonitoring(ci)
 		}
 
 		if ci.localSlashWarning {
@@ -850,6 +958,10 @@ func inquireObjectsWithFilter(objectPatternsList string, objectType int32, filte
 			command = ibmmq.MQCMD_INQUIRE_CHANNEL_NAMES
 			attribute = ibmmq.MQCACH_CHANNEL_NAME
 			returnedAttribute = ibmmq.MQCACH_CHANNEL_NAMES
+		case ibmmq.MQOT_TOPIC:
+			command = ibmmq.MQCMD_INQUIRE_TOPIC_NAMES
+			attribute = ibmmq.MQCA_TOPIC_NAME
+			returnedAttribute = ibmmq.MQCACF_TOPIC_NAMES
 		default:
 			e2 := fmt.Errorf("Object type %d is not valid", objectType)
 			traceExitErr("inquireObjects", 2, e2)
@@ -865,7 +977,7 @@ func inquireObjectsWithFilter(objectPatternsList string, objectType int32, filte
 		pmo.Options |= ibmmq.MQPMO_FAIL_IF_QUIESCING
 
 		putmqmd.Format = "MQADMIN"
-		putmqmd.ReplyToQ = ci.si.statusReplyQObj.Name
+		putmqmd.ReplyToQ = ci.si.statusReplyQObj.ObjectName()
 		putmqmd.MsgType = ibmmq.MQMT_REQUEST
 		putmqmd.Report = ibmmq.MQRO_PASS_DISCARD_AND_EXPIRY
 
@@ -920,7 +1032,7 @@ func inquireObjectsWithFilter(objectPatternsList string, objectType int32, filte
 		buf = append(cfh.Bytes(), buf...)
 
 		// And put the command to the queue
-		err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+		err = statusPutCommand(ci, putmqmd, pmo, buf)
 
 		if err != nil {
 			traceExitErr("inquireObjects", 3, err)
@@ -1009,17 +1121,35 @@ func createSubscriptions() error {
 	if ci.durableSubPrefix != "" {
 		usingDurableSubs = true
 	}
+	// With a leader lock, every subscription is shared between the copies of the collector
+	sharedSubs := usingDurableSubs && ci.leader != nil
 
 	for _, cl := range metrics.Classes {
+		// Queue manager-level classes can be covered by a single subscription
+		if ci.useWildcardSubs {
+			if topic := classWildcardTopic(cl); topic != "" {
+				if cl.subHobj == nil {
+					mqtd, err = subscribeWithSubOptions(topic, &ci.si.replyQObj, false, sharedSubs, ibmmq.MQSO_WILDCARD_TOPIC)
+					if err != nil {
+						e2 := fmt.Errorf("Error subscribing to %s: %v", topic, err)
+						traceExitErr("createSubscriptions", 2, e2)
+						return e2
+					}
+					cl.subHobj = mqtd
+				}
+				continue
+			}
+		}
+
 		for _, ty := range cl.Types {
 			// For queues, we use the list of discovered objects to
 			// create the subscriptions. For other object types, the list
 			// is allowed to be a wildcard. In particular, the NativeHA instances
 			if strings.Contains(ty.ObjectTopic, "%s") {
-				im := qInfoMap
+				im := ci.qInfoMap
 				switch cl.Name {
 				case "NHAREPLICA":
-					im = nhaInfoMap
+					im = ci.nhaInfoMap
 				}
 				for key, _ := range im {
 					if len(key) == 0 {
@@ -1063,7 +1193,7 @@ func createSubscriptions() error {
 
 					// Don't have a qmgr-level subscription to this topic. Should
 					// only do this subscription once at startup
-					mqtd, err = subscribe(ty.ObjectTopic, &ci.si.replyQObj)
+					mqtd, err = subscribeWithOptions(ty.ObjectTopic, &ci.si.replyQObj, false, sharedSubs)
 					ty.subHobj[QMgrMapKey] = mqtd
 				}
 			}
@@ -1081,6 +1211,32 @@ func createSubscriptions() error {
 	return err
 }
 
+// If all the types in a class are for the queue manager rather than for individual
+// objects, and they share a parent topic, then return a wildcard topic that covers them all.
+// Otherwise return an empty string.
+func classWildcardTopic(cl *MonClass) string {
+	parent := ""
+	for _, ty := range cl.Types {
+		if strings.Contains(ty.ObjectTopic, "%s") {
+			return ""
+		}
+		idx := strings.LastIndex(ty.ObjectTopic, "/")
+		if idx <= 0 {
+			return ""
+		}
+		p := ty.ObjectTopic[0:idx]
+		if parent == "" {
+			parent = p
+		} else if parent != p {
+			return ""
+		}
+	}
+	if parent == "" {
+		return ""
+	}
+	return parent + "/#"
+}
+
 /*
 ProcessPublications has to read all of the messages since the last scrape
 and update the values for every relevant gauge.
@@ -1092,6 +1248,11 @@ gauge. Conversely, there may be times when this is called but there
 are no metrics to update.
 */
 func ProcessPublications() error {
+	k := GetConnectionKey()
+	return processPublicationsFor(k, getConnection(k))
+}
+
+func processPublicationsFor(k string, ci *connectionInfo) error {
 	var err error
 	var data []byte
 
@@ -1101,11 +1262,11 @@ func ProcessPublications() error {
 	var typeidx int
 	var elementidx int
 	var value int64
+	var putTime time.Time
+	var interval int64
 
 	traceEntry("ProcessPublications")
 
-	k := GetConnectionKey()
-	ci := getConnection(k)
 	metrics := GetPublishedMetrics(k)
 	ci.publicationCount = 0
 
@@ -1114,55 +1275,98 @@ func ProcessPublications() error {
 		return nil
 	}
 
+	// Leave the publications on the queue for the leader
+	if !checkLeader(ci) {
+		traceExit("ProcessPublications", 4)
+		return nil
+	}
+
+	if ci.checkConnection {
+		if err = CheckConnection(); err != nil {
+			traceExitErr("ProcessPublications", 3, err)
+			return err
+		}
+	}
+
+	startPublicationInterval(ci)
+	ci.archive.interval()
+	if ci.pubWindow != nil {
+		ci.pubWindow.start()
+	}
+	cutoff := pubWindowCutoff(ci, time.Now())
+
+	if ci.pcfReader == nil {
+		ci.pcfReader = ibmmq.NewPCFReader()
+	}
+	values := make(map[int]int64)
+
 	// Keep reading all available messages until queue is empty. Don't
 	// do a GET-WAIT; just immediate removals.
 	for err == nil {
-		data, err = getMessage(ci, false)
+		data, putTime, err = getPublication(ci)
+
+		// Keep the start of an interval that is still arriving for the next collection
+		if err == nil && ci.pubWindow != nil && ci.pubWindow.hold(data, putTime, cutoff) {
+			continue
+		}
 
 		// Most common error will be MQRC_NO_MESSAGE_AVAILABLE
 		// which will end the loop.
 		if err == nil {
 			ci.publicationCount++
-			elemList, _ := parsePCFResponse(data)
 
 			// A typical publication contains some fixed
 			// headers (qmgrName, objectName, class, type etc)
 			// followed by a list of index/values.
 			// Start with an empty map for each message
-			values := make(map[int]int64)
+			for k := range values {
+				delete(values, k)
+			}
 
 			objName = ""
 
-			for i := 0; i < len(elemList); i++ {
-				switch elemList[i].Parameter {
-				case ibmmq.MQCA_Q_MGR_NAME:
-					_ = strings.TrimSpace(elemList[i].String[0])
-				case ibmmq.MQCA_Q_NAME:
-					objName = strings.TrimSpace(elemList[i].String[0])
-					objType = ibmmq.MQOT_Q
-				case ibmmq.MQCA_TOPIC_NAME:
-					objName = strings.TrimSpace(elemList[i].String[0])
-					objType = ibmmq.MQOT_TOPIC
-				case ibmmq.MQIACF_OBJECT_TYPE:
-					// May need to use this as part of the object key and
-					// labelling But for now we can ignore it.
-					_ = ibmmq.MQItoString("OT", int(elemList[i].Int64Value[0]))
-				case ibmmq.MQCACF_NHA_INSTANCE_NAME:
-					objName = strings.TrimSpace(elemList[i].String[0])
-					objType = OT_NHA
-				case ibmmq.MQIAMO_MONITOR_CLASS:
-					classidx = int(elemList[i].Int64Value[0])
-				case ibmmq.MQIAMO_MONITOR_TYPE:
-					typeidx = int(elemList[i].Int64Value[0])
-				case ibmmq.MQIAMO64_MONITOR_INTERVAL:
-					_ = elemList[i].Int64Value[0]
-				case ibmmq.MQIAMO_MONITOR_FLAGS:
-					_ = int(elemList[i].Int64Value[0])
-				default:
-					value = elemList[i].Int64Value[0]
-					elementidx = int(elemList[i].Parameter)
-					values[elementidx] = value
-				}
+			// The parameters are decoded into reused structures, as there can be
+			// thousands of publications waiting after a pause
+			cfh, offset := ibmmq.ReadPCFHeader(data)
+			if cfh != nil {
+				ci.pcfReader.Visit(data[offset:], cfh.ParameterCount, func(elem *ibmmq.PCFParameter) bool {
+					switch elem.Parameter {
+					case ibmmq.MQCA_Q_MGR_NAME:
+						// Not needed
+					case ibmmq.MQCA_Q_NAME:
+						objName = intern(strings.TrimSpace(elem.String[0]))
+						objType = ibmmq.MQOT_Q
+					case ibmmq.MQCA_TOPIC_NAME:
+						objName = intern(strings.TrimSpace(elem.String[0]))
+						objType = ibmmq.MQOT_TOPIC
+					case ibmmq.MQIACF_OBJECT_TYPE:
+						// May need to use this as part of the object key and
+						// labelling But for now we can ignore it.
+					case ibmmq.MQCACF_NHA_INSTANCE_NAME:
+						objName = intern(strings.TrimSpace(elem.String[0]))
+						objType = OT_NHA
+					case ibmmq.MQIAMO_MONITOR_CLASS:
+						classidx = int(elem.Int64Value[0])
+					case ibmmq.MQIAMO_MONITOR_TYPE:
+						typeidx = int(elem.Int64Value[0])
+					case ibmmq.MQIAMO64_MONITOR_INTERVAL:
+						interval = elem.Int64Value[0]
+					case ibmmq.MQIAMO_MONITOR_FLAGS:
+						// Not needed
+					default:
+						value = elem.Int64Value[0]
+						elementidx = int(elem.Parameter)
+						values[elementidx] = value
+					}
+					return true
+				})
+			}
+
+			if ci.archive != nil {
+				ci.archive.publication(publicationTopic(metrics, classidx, typeidx, objName), data)
+			}
+			if ci.pubWindow != nil {
+				ci.pubWindow.used(putTime, interval)
 			}
 
 			// Now have all the values in this particular message
@@ -1196,12 +1400,12 @@ func ProcessPublications() error {
 							// If we've unsubscribed and resubscribed to the same queue (unusual
 							// but a dynamic resub nature may permit that) then discard the first metric
 							// from a queue in case it's got a running total instead of the last interval.
-							objectInfoMap := qInfoMap
+							objectInfoMap := ci.qInfoMap
 							if objType == ibmmq.MQOT_Q {
-								objectInfoMap = qInfoMap
+								objectInfoMap = ci.qInfoMap
 								elemKey = objectName
 							} else if objType == OT_NHA {
-								objectInfoMap = nhaInfoMap
+								objectInfoMap = ci.nhaInfoMap
 								elemKey = NativeHAKeyPrefix + objectName
 							}
 							if qi, ok := objectInfoMap[objName]; ok {
@@ -1222,7 +1426,7 @@ func ProcessPublications() error {
 							}
 						}
 
-						if oldValue, ok := elem.Values[elemKey]; ok {
+						if oldValue, ok := elem.Value(elemKey); ok {
 							if elem.Datatype == ibmmq.MQIAMO_MONITOR_DELTA {
 								//logDebug("Metric with delta flag on  - %s", elem.MetricName)
 								value = oldValue + newValue
@@ -1233,7 +1437,7 @@ func ProcessPublications() error {
 						} else {
 							value = newValue
 						}
-						elem.Values[elemKey] = value
+						elem.setValue(elemKey, value)
 					}
 				}
 			}
@@ -1249,9 +1453,13 @@ func ProcessPublications() error {
 	}
 
 	// Ensure that all known queues are marked as having had at least one collection cycle
-	for _, qi := range qInfoMap {
+	for _, qi := range ci.qInfoMap {
 		qi.firstCollection = false
 	}
+	ci.clockSkew.endInterval()
+	if ci.pubWindow != nil {
+		ci.pubWindow.end()
+	}
 
 	traceExit("ProcessPublications", 0)
 	return nil
@@ -1426,7 +1634,7 @@ func Normalise(elem *MonElement, key string, value int64) float64 {
 		f = f / 1000000
 	}
 
-	return f
+	return elementTransform(elem, f)
 }
 
 func VerifyPatterns(patternList string) error {
@@ -1448,6 +1656,15 @@ func verifyObjectPatterns(patternList string, allowNegatives bool) error {
 			(strings.Count(pattern, "*") == 1 && !strings.HasSuffix(pattern, "*")) {
 			err = fmt.Errorf("Object pattern '%s' is not valid. '*' must be last character in a pattern", pattern)
 		}
+		// Presets are only used for queues
+		if isPreset(pattern) {
+			if !allowNegatives {
+				err = fmt.Errorf("Object pattern '%s' is not valid. Presets can only be used for queues", pattern)
+			} else {
+				_, _, err = splitPresets(pattern)
+			}
+			continue
+		}
 		// Will allow ! to be at the start of a pattern.
 		if allowNegatives {
 			if strings.Count(pattern, "!") > 1 ||
@@ -1584,17 +1801,18 @@ func patternMatch(s string, r string) bool {
 }
 
 func GetObjectDescription(key string, objectType int32) string {
+	ci := getConnection(GetConnectionKey())
 	var o *ObjInfo
 	ok := false
 	switch objectType {
 	case ibmmq.MQOT_Q:
-		o, ok = qInfoMap[key]
+		o, ok = ci.qInfoMap[key]
 	case ibmmq.MQOT_CHANNEL:
-		o, ok = chlInfoMap[key]
+		o, ok = ci.chlInfoMap[key]
 	case OT_CHANNEL_AMQP:
-		o, ok = amqpInfoMap[key]
+		o, ok = ci.amqpInfoMap[key]
 	case OT_Q_MGR:
-		o = qMgrInfo
+		o = ci.qMgrInfo
 		ok = true
 	}
 
@@ -1619,7 +1837,7 @@ func trimToNull(s string) string {
 
 // Return a complete message - if the default buffer is too small, iterate until
 // we no longer get the MQRC_TRUNCATED_MSG_FAILED
-func getWithoutTruncation(hObj ibmmq.MQObject) ([]byte, int, error) {
+func getWithoutTruncation(hObj ibmmq.Object) ([]byte, int, error) {
 	var err error
 	datalen := 0
 	traceEntry("getWithoutTruncation")
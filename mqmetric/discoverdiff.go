@@ -0,0 +1,171 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file compares the results of consecutive discoveries. A collector can then log
+changes to the set of monitored queues, or notice new metrics that have appeared after
+the queue manager has been upgraded. An exporter can use the changes to register new
+series before they are first collected.
+
+A snapshot is taken at the end of each DiscoverAndSubscribe or RediscoverAndSubscribe, and
+compared with the previous one for the same connection. The first discovery reports everything
+as added.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The kinds of object that are compared
+const (
+	DISCOVERY_QUEUE   = "queue"
+	DISCOVERY_CLASS   = "class"
+	DISCOVERY_TYPE    = "type"
+	DISCOVERY_ELEMENT = "element"
+)
+
+// What happened to the object
+const (
+	DISCOVERY_ADDED   = "added"
+	DISCOVERY_DELETED = "deleted"
+)
+
+/*
+DiscoverySnapshot holds the names of everything found by a discovery. Types are
+named as "CLASS/TYPE", and elements as "CLASS/TYPE/metricname".
+*/
+type DiscoverySnapshot struct {
+	QMgrName string
+	Time     time.Time
+	Queues   []string
+	Classes  []string
+	Types    []string
+	Elements []string
+}
+
+/*
+DiscoveryChange describes one difference between two snapshots
+*/
+type DiscoveryChange struct {
+	Kind   string
+	Action string
+	Name   string
+}
+
+func (c DiscoveryChange) String() string {
+	return fmt.Sprintf("%s %s %s", c.Kind, c.Name, c.Action)
+}
+
+/*
+TakeDiscoverySnapshot records the current discovery results for the current connection
+*/
+func TakeDiscoverySnapshot() *DiscoverySnapshot {
+	traceEntry("TakeDiscoverySnapshot")
+
+	ci := getConnection(GetConnectionKey())
+	s := &DiscoverySnapshot{QMgrName: ci.si.resolvedQMgrName, Time: time.Now()}
+
+	s.Queues = GetDiscoveredQueues()
+
+	m := GetPublishedMetrics(GetConnectionKey())
+	for _, cl := range m.Classes {
+		s.Classes = append(s.Classes, cl.Name)
+		for _, ty := range cl.Types {
+			tyName := cl.Name + "/" + ty.Name
+			s.Types = append(s.Types, tyName)
+			for _, elem := range ty.Elements {
+				s.Elements = append(s.Elements, tyName+"/"+elem.MetricName)
+			}
+		}
+	}
+
+	sort.Strings(s.Queues)
+	sort.Strings(s.Classes)
+	sort.Strings(s.Types)
+	sort.Strings(s.Elements)
+
+	traceExit("TakeDiscoverySnapshot", 0)
+	return s
+}
+
+/*
+DiffDiscovery returns the changes between two snapshots. If the old snapshot is nil,
+everything in the new one is reported as added.
+*/
+func DiffDiscovery(old *DiscoverySnapshot, new *DiscoverySnapshot) []DiscoveryChange {
+	changes := make([]DiscoveryChange, 0)
+	if new == nil {
+		return changes
+	}
+	if old == nil {
+		old = &DiscoverySnapshot{}
+	}
+
+	changes = diffNames(changes, DISCOVERY_QUEUE, old.Queues, new.Queues)
+	changes = diffNames(changes, DISCOVERY_CLASS, old.Classes, new.Classes)
+	changes = diffNames(changes, DISCOVERY_TYPE, old.Types, new.Types)
+	changes = diffNames(changes, DISCOVERY_ELEMENT, old.Elements, new.Elements)
+	return changes
+}
+
+func diffNames(changes []DiscoveryChange, kind string, old []string, new []string) []DiscoveryChange {
+	oldSet := make(map[string]bool)
+	for _, s := range old {
+		oldSet[s] = true
+	}
+	newSet := make(map[string]bool)
+	for _, s := range new {
+		newSet[s] = true
+		if !oldSet[s] {
+			changes = append(changes, DiscoveryChange{Kind: kind, Action: DISCOVERY_ADDED, Name: s})
+		}
+	}
+	for _, s := range old {
+		if !newSet[s] {
+			changes = append(changes, DiscoveryChange{Kind: kind, Action: DISCOVERY_DELETED, Name: s})
+		}
+	}
+	return changes
+}
+
+/*
+GetDiscoveryChanges returns the changes found by the most recent discovery on the
+current connection
+*/
+func GetDiscoveryChanges() []DiscoveryChange {
+	ci := getConnection(GetConnectionKey())
+	return ci.discoveryChanges
+}
+
+// Called at the end of each discovery
+func recordDiscoveryChanges(ci *connectionInfo) {
+	snapshot := TakeDiscoverySnapshot()
+	ci.discoveryChanges = DiffDiscovery(ci.discoverySnapshot, snapshot)
+	// Everything is new on the first discovery, so there is nothing worth logging
+	if ci.discoverySnapshot != nil {
+		for _, c := range ci.discoveryChanges {
+			logInfo("Discovery change: %s", c)
+		}
+	}
+	ci.discoverySnapshot = snapshot
+}
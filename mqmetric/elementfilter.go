@@ -0,0 +1,138 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lets the published elements be filtered before they are used, so that
+metrics which are not wanted never reach the collectors. For example, the CPU class
+is not very meaningful inside a container.
+
+The subscriptions are not changed. Publications still arrive for every type, but the
+filtered elements are not in the type's Elements map, so ProcessPublications ignores
+them in the same way as elements it does not know about.
+
+Both lists are comma-separated patterns. A pattern without any "/" is compared with
+the metric name and the class name, so "CPU" removes the whole class and
+"log_write_latency" removes a single element. Otherwise the pattern is
+CLASS[/TYPE[/ELEMENT]] where each part is a name or a number, the ELEMENT part can
+also be a metric name, and "*" can be used at the end of any part. If there is an
+allow list, only elements that match it are kept; elements that match the deny list
+are then removed.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type elementPattern struct {
+	parts []string
+}
+
+// Split a comma-separated list into patterns, checking the format of each one
+func parseElementPatterns(list string) ([]elementPattern, error) {
+	var patterns []elementPattern
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.Split(s, "/")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("Element pattern '%s' has too many parts", s)
+		}
+		for _, p := range parts {
+			if p == "" || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+				return nil, fmt.Errorf("Element pattern '%s' is not valid", s)
+			}
+		}
+		patterns = append(patterns, elementPattern{parts: parts})
+	}
+	return patterns, nil
+}
+
+// Compare one part of a pattern with a name and index. Names are not case-sensitive.
+func matchElementPart(p string, name string, idx int) bool {
+	if strings.HasSuffix(p, "*") {
+		return strings.HasPrefix(strings.ToUpper(name), strings.ToUpper(p[:len(p)-1]))
+	}
+	return strings.EqualFold(p, name) || (idx >= 0 && p == strconv.Itoa(idx))
+}
+
+func (p elementPattern) matches(clIdx int, cl *MonClass, tyIdx int, ty *MonType, elemIdx int, elem *MonElement) bool {
+	switch len(p.parts) {
+	case 1:
+		return matchElementPart(p.parts[0], elem.MetricName, -1) || matchElementPart(p.parts[0], cl.Name, -1)
+	case 2:
+		return matchElementPart(p.parts[0], cl.Name, clIdx) && matchElementPart(p.parts[1], ty.Name, tyIdx)
+	default:
+		return matchElementPart(p.parts[0], cl.Name, clIdx) && matchElementPart(p.parts[1], ty.Name, tyIdx) &&
+			matchElementPart(p.parts[2], elem.MetricName, elemIdx)
+	}
+}
+
+func matchAnyElementPattern(patterns []elementPattern, clIdx int, cl *MonClass, tyIdx int, ty *MonType, elemIdx int, elem *MonElement) bool {
+	for _, p := range patterns {
+		if p.matches(clIdx, cl, tyIdx, ty, elemIdx, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the elements that have been excluded by the configuration. Returns
+// the number of elements that were removed.
+func filterElements(dc DiscoverConfig, metrics *AllMetrics) (int, error) {
+	count := 0
+
+	if dc.ElementAllowList == "" && dc.ElementDenyList == "" {
+		return 0, nil
+	}
+
+	traceEntry("filterElements")
+
+	allow, err := parseElementPatterns(dc.ElementAllowList)
+	if err == nil {
+		var deny []elementPattern
+		deny, err = parseElementPatterns(dc.ElementDenyList)
+		if err == nil {
+			for clIdx, cl := range metrics.Classes {
+				for tyIdx, ty := range cl.Types {
+					for elemIdx, elem := range ty.Elements {
+						keep := len(allow) == 0 || matchAnyElementPattern(allow, clIdx, cl, tyIdx, ty, elemIdx, elem)
+						if keep && matchAnyElementPattern(deny, clIdx, cl, tyIdx, ty, elemIdx, elem) {
+							keep = false
+						}
+						if !keep {
+							logDebug("Removing element %s/%s/%s", cl.Name, ty.Name, elem.MetricName)
+							delete(ty.Elements, elemIdx)
+							count++
+						}
+					}
+				}
+			}
+		}
+	}
+
+	traceExitErr("filterElements", 0, err)
+	return count, err
+}
@@ -0,0 +1,147 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file allows a list of client endpoints to be given in the ConnectionConfig, as
+a simpler alternative to a CCDT for active/standby setups.
+
+When all the endpoints use the same channel and TLS settings, they are combined
+into a single comma-separated ConnName in the MQCD. The MQ client then tries each in
+turn, and can also use the list for automatic reconnection. If the settings differ,
+each endpoint needs its own MQCD, so they are tried one at a time here. Reconnection is
+then only possible to the endpoint that was originally connected.
+*/
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// Values for the EndpointPolicy in the ConnectionConfig
+const (
+	ENDPOINT_ORDERED = "ordered"
+	ENDPOINT_RANDOM  = "random"
+)
+
+// Endpoint describes one way of reaching the queue manager. An empty Channel
+// means that the Channel from the ConnectionConfig is used.
+type Endpoint struct {
+	ConnName         string
+	Channel          string
+	CipherSpec       string
+	KeyRepository    string
+	CertificateLabel string
+}
+
+var endpointRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Return a copy of the endpoints in the order they should be tried
+func orderEndpoints(endpoints []Endpoint, policy string, defaultChannel string) []Endpoint {
+	eps := make([]Endpoint, len(endpoints))
+	copy(eps, endpoints)
+	for i := range eps {
+		if eps[i].Channel == "" {
+			eps[i].Channel = defaultChannel
+		}
+	}
+
+	if strings.EqualFold(policy, ENDPOINT_RANDOM) {
+		endpointRand.Shuffle(len(eps), func(i, j int) {
+			eps[i], eps[j] = eps[j], eps[i]
+		})
+	}
+	return eps
+}
+
+// Can all the endpoints be described by a single MQCD
+func canShareCD(eps []Endpoint) bool {
+	for i := 1; i < len(eps); i++ {
+		e := eps[i]
+		e.ConnName = eps[0].ConnName
+		if e != eps[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// Build the channel definition for a group of endpoints that share their settings
+func endpointCD(eps []Endpoint) *ibmmq.MQCD {
+	names := make([]string, 0, len(eps))
+	for _, e := range eps {
+		names = append(names, e.ConnName)
+	}
+
+	gocd := ibmmq.NewMQCD()
+	gocd.ChannelName = eps[0].Channel
+	gocd.ConnectionName = strings.Join(names, ",")
+	gocd.SSLCipherSpec = eps[0].CipherSpec
+	return gocd
+}
+
+func endpointSCO(e Endpoint) *ibmmq.MQSCO {
+	if e.KeyRepository == "" && e.CertificateLabel == "" {
+		return nil
+	}
+	gosco := ibmmq.NewMQSCO()
+	gosco.KeyRepository = e.KeyRepository
+	gosco.CertificateLabel = e.CertificateLabel
+	return gosco
+}
+
+// Try to connect to each group of endpoints in turn, returning the first success
+// or the last error
+func connectEndpoints(qMgrName string, gocno *ibmmq.MQCNO, cc *ConnectionConfig) (ibmmq.MQQueueManager, error) {
+	var qMgr ibmmq.MQQueueManager
+	var err error
+
+	traceEntry("connectEndpoints")
+
+	eps := orderEndpoints(cc.Endpoints, cc.EndpointPolicy, cc.Channel)
+
+	groups := make([][]Endpoint, 0)
+	if canShareCD(eps) {
+		groups = append(groups, eps)
+	} else {
+		for i := range eps {
+			groups = append(groups, eps[i:i+1])
+		}
+	}
+
+	for _, g := range groups {
+		cno := *gocno
+		cno.ClientConn = endpointCD(g)
+		applyChannelTuning(cno.ClientConn, cc)
+		cno.SSLConfig = endpointSCO(g[0])
+		logInfo("Trying to connect as client using ConnName: %s, Channel: %s", cno.ClientConn.ConnectionName, cno.ClientConn.ChannelName)
+		qMgr, err = ibmmq.Connx(qMgrName, &cno)
+		if err == nil {
+			break
+		}
+		logError("Connection to %s failed: %v", cno.ClientConn.ConnectionName, err)
+	}
+
+	traceExitErr("connectEndpoints", 0, err)
+	return qMgr, err
+}
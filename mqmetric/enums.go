@@ -0,0 +1,168 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file converts the status values that MQ reports as numbers, such as a channel's
+status or a queue's monitoring level, into fixed lowercase strings. Exporters can use
+them as label values or to build state-set metrics, so that every collector shows the
+same names for the same states instead of the raw numbers.
+
+The strings are the MQ constant names without their prefix, in lower case. For example
+MQCHS_RUNNING is "running" and MQCHSSTATE_IN_MQGET is "in_mqget". A value that is not
+in the table, perhaps from a newer queue manager, is returned as the number.
+*/
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// The enumerations that can be converted
+const (
+	ENUM_CHL_STATUS            = "channel_status"
+	ENUM_CHL_STATUS_SIMPLIFIED = "channel_status_simplified"
+	ENUM_CHL_SUBSTATE          = "channel_substate"
+	ENUM_Q_MONITORING          = "queue_monitoring"
+	ENUM_Q_NPM_CLASS           = "npm_class"
+)
+
+var enumTables = map[string]map[int64]string{
+	ENUM_CHL_STATUS: {
+		int64(ibmmq.MQCHS_INACTIVE):     "inactive",
+		int64(ibmmq.MQCHS_BINDING):      "binding",
+		int64(ibmmq.MQCHS_STARTING):     "starting",
+		int64(ibmmq.MQCHS_RUNNING):      "running",
+		int64(ibmmq.MQCHS_STOPPING):     "stopping",
+		int64(ibmmq.MQCHS_RETRYING):     "retrying",
+		int64(ibmmq.MQCHS_STOPPED):      "stopped",
+		int64(ibmmq.MQCHS_REQUESTING):   "requesting",
+		int64(ibmmq.MQCHS_PAUSED):       "paused",
+		int64(ibmmq.MQCHS_DISCONNECTED): "disconnected",
+		int64(ibmmq.MQCHS_INITIALIZING): "initializing",
+		int64(ibmmq.MQCHS_SWITCHING):    "switching",
+	},
+	ENUM_CHL_STATUS_SIMPLIFIED: {
+		SQUASH_CHL_STATUS_STOPPED:    "stopped",
+		SQUASH_CHL_STATUS_TRANSITION: "transition",
+		SQUASH_CHL_STATUS_RUNNING:    "running",
+	},
+	ENUM_CHL_SUBSTATE: {
+		int64(ibmmq.MQCHSSTATE_OTHER):           "other",
+		int64(ibmmq.MQCHSSTATE_END_OF_BATCH):    "end_of_batch",
+		int64(ibmmq.MQCHSSTATE_SENDING):         "sending",
+		int64(ibmmq.MQCHSSTATE_RECEIVING):       "receiving",
+		int64(ibmmq.MQCHSSTATE_SERIALIZING):     "serializing",
+		int64(ibmmq.MQCHSSTATE_RESYNCHING):      "resynching",
+		int64(ibmmq.MQCHSSTATE_HEARTBEATING):    "heartbeating",
+		int64(ibmmq.MQCHSSTATE_IN_SCYEXIT):      "in_scyexit",
+		int64(ibmmq.MQCHSSTATE_IN_RCVEXIT):      "in_rcvexit",
+		int64(ibmmq.MQCHSSTATE_IN_SENDEXIT):     "in_sendexit",
+		int64(ibmmq.MQCHSSTATE_IN_MSGEXIT):      "in_msgexit",
+		int64(ibmmq.MQCHSSTATE_IN_MREXIT):       "in_mrexit",
+		int64(ibmmq.MQCHSSTATE_IN_CHADEXIT):     "in_chadexit",
+		int64(ibmmq.MQCHSSTATE_NET_CONNECTING):  "net_connecting",
+		int64(ibmmq.MQCHSSTATE_SSL_HANDSHAKING): "ssl_handshaking",
+		int64(ibmmq.MQCHSSTATE_NAME_SERVER):     "name_server",
+		int64(ibmmq.MQCHSSTATE_IN_MQPUT):        "in_mqput",
+		int64(ibmmq.MQCHSSTATE_IN_MQGET):        "in_mqget",
+		int64(ibmmq.MQCHSSTATE_IN_MQI_CALL):     "in_mqi_call",
+		int64(ibmmq.MQCHSSTATE_COMPRESSING):     "compressing",
+	},
+	ENUM_Q_MONITORING: {
+		int64(ibmmq.MQMON_Q_MGR):  "q_mgr",
+		int64(ibmmq.MQMON_OFF):    "off",
+		int64(ibmmq.MQMON_LOW):    "low",
+		int64(ibmmq.MQMON_MEDIUM): "medium",
+		int64(ibmmq.MQMON_HIGH):   "high",
+	},
+	ENUM_Q_NPM_CLASS: {
+		int64(ibmmq.MQNPM_CLASS_NORMAL): "normal",
+		int64(ibmmq.MQNPM_CLASS_HIGH):   "high",
+	},
+}
+
+// The MQ constant prefixes that EnumValue also accepts
+var enumPrefixes = map[string]string{
+	ENUM_CHL_STATUS:   "MQCHS_",
+	ENUM_CHL_SUBSTATE: "MQCHSSTATE_",
+	ENUM_Q_MONITORING: "MQMON_",
+	ENUM_Q_NPM_CLASS:  "MQNPM_CLASS_",
+}
+
+/*
+EnumString returns the lowercase name for a value of one of the ENUM_* enumerations.
+Values that are not known are returned as a decimal string.
+*/
+func EnumString(enum string, v int64) string {
+	if s, ok := enumTables[enum][v]; ok {
+		return s
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+/*
+EnumValue is the reverse of EnumString. The name is not case-sensitive, and can
+also be given as the full MQ constant name such as MQCHS_RUNNING.
+*/
+func EnumValue(enum string, name string) (int64, bool) {
+	s := strings.ToUpper(strings.TrimSpace(name))
+	s = strings.ToLower(strings.TrimPrefix(s, enumPrefixes[enum]))
+	for v, n := range enumTables[enum] {
+		if n == s {
+			return v, true
+		}
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, true
+	}
+	return 0, false
+}
+
+/*
+EnumValues returns all the known values of an enumeration and their names, for
+example to create one state-set series for each possible state. The map is a copy
+and can be modified.
+*/
+func EnumValues(enum string) map[int64]string {
+	m := make(map[int64]string)
+	for v, n := range enumTables[enum] {
+		m[v] = n
+	}
+	return m
+}
+
+/*
+Enum returns which ENUM_* enumeration the attribute's values come from, or an empty
+string if it is not an enumerated value
+*/
+func (attr *StatusAttribute) Enum() string {
+	return attr.enum
+}
+
+/*
+EnumString returns the lowercase name for one of the attribute's values. For
+attributes that are not enumerated values, the number is returned as a string.
+*/
+func (attr *StatusAttribute) EnumString(v int64) string {
+	return EnumString(attr.enum, v)
+}
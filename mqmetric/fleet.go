@@ -0,0 +1,459 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file manages a fleet of queue manager connections from a single collector. It builds
+on the connection keys described in NOTES-API.md: each member of the fleet has its own key,
+and so its own connection and set of metrics.
+
+The Fleet takes care of connecting to each queue manager, reconnecting after failures,
+and keeping a record of the health of each one. The collector supplies a function that
+is called for each queue manager in turn, with the right connection key already set.
+That function does the real work, such as DiscoverAndSubscribe and CollectOnce. After it
+returns, the Fleet takes the member's metrics with GetModel, and Fleet.GetModel merges
+the latest metrics from all the members into one snapshot, with each value labelled by
+its queue manager. That can be called from any goroutine.
+
+The package is not designed for collections to run in parallel, so the members are
+processed one at a time. Everything that a member's collection started has finished
+when the function returns - CollectOnce waits for a step that is still running when its
+budget runs out - so nothing is left writing to one member's data once the key has been
+changed for the next. The collect function must not start goroutines of its own that use
+the package after it returns. To avoid a burst of work at the start of each interval, the
+collections can be staggered across the interval.
+
+The list of queue managers can come from a JSON or YAML file, from a JSON-format CCDT, or
+be built by the collector in any other way. See fleetyaml.go for the YAML that is understood.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+FleetMember describes one queue manager in the fleet. If the Key is empty, the
+QMgrName is used as the key.
+*/
+type FleetMember struct {
+	Key        string
+	QMgrName   string
+	ReplyQ     string
+	ReplyQ2    string
+	Connection ConnectionConfig
+}
+
+/*
+FleetConfig describes the whole fleet. The intervals are in seconds. If the
+ReconnectInterval is not set, reconnection is tried once per collection interval.
+*/
+type FleetConfig struct {
+	Members           []FleetMember
+	Interval          int
+	ReconnectInterval int
+	Stagger           bool
+}
+
+/*
+FleetHealth is the current state of one member of the fleet
+*/
+type FleetHealth struct {
+	Key              string
+	QMgrName         string
+	Connected        bool
+	LastCollection   time.Time
+	LastError        error
+	ConsecutiveFails int
+	StatusDegraded   bool // The command server is not replying. See breaker.go
+}
+
+/*
+FleetCollectFunc is called for each member at each interval. The newConnection parameter
+is set the first time the function is called after a connection has been made, so that
+discovery can be done.
+*/
+type FleetCollectFunc func(key string, qMgrName string, newConnection bool) error
+
+/*
+Fleet controls the connections to all the members
+*/
+type Fleet struct {
+	config  FleetConfig
+	members []*fleetMember
+	mutex   sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type fleetMember struct {
+	FleetMember
+	health        FleetHealth
+	nextConnect   time.Time
+	newConnection bool
+	model         *ModelSnapshot // From the last collection
+}
+
+/*
+LoadFleetConfig reads a FleetConfig from a file. Files ending in .yaml or .yml are
+read as YAML; anything else as JSON.
+*/
+func LoadFleetConfig(file string) (*FleetConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == ".yaml" || ext == ".yml" {
+		if b, err = fleetYAMLToJSON(b); err != nil {
+			return nil, fmt.Errorf("Cannot parse fleet configuration %s: %v", file, err)
+		}
+	}
+	fc := new(FleetConfig)
+	if err = json.Unmarshal(b, fc); err != nil {
+		return nil, fmt.Errorf("Cannot parse fleet configuration %s: %v", file, err)
+	}
+	return fc, nil
+}
+
+// The parts of a JSON CCDT that we need
+type ccdtFile struct {
+	Channel []struct {
+		Name             string
+		Type             string
+		ClientConnection struct {
+			Connection []struct {
+				Host string
+				Port int
+			}
+			QueueManager string
+		}
+	}
+}
+
+/*
+FleetMembersFromCCDT creates a member for each client connection channel in a JSON-format
+CCDT. The template is copied for each member, with the ConnName and Channel filled in.
+If several channels name the same queue manager, only the first is used.
+*/
+func FleetMembersFromCCDT(file string, template ConnectionConfig) ([]FleetMember, error) {
+	var ccdt ccdtFile
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &ccdt); err != nil {
+		return nil, fmt.Errorf("Cannot parse CCDT %s: %v", file, err)
+	}
+
+	seen := make(map[string]bool)
+	members := make([]FleetMember, 0)
+	for _, c := range ccdt.Channel {
+		if c.Type != "" && c.Type != "clientConnection" {
+			continue
+		}
+		qMgrName := c.ClientConnection.QueueManager
+		if qMgrName == "" || seen[qMgrName] {
+			continue
+		}
+		seen[qMgrName] = true
+
+		connNames := make([]string, 0)
+		for _, conn := range c.ClientConnection.Connection {
+			port := conn.Port
+			if port == 0 {
+				port = 1414
+			}
+			connNames = append(connNames, fmt.Sprintf("%s(%d)", conn.Host, port))
+		}
+
+		cc := template
+		cc.CcdtUrl = ""
+		cc.Channel = c.Name
+		cc.ConnName = strings.Join(connNames, ",")
+		members = append(members, FleetMember{QMgrName: qMgrName, Connection: cc})
+	}
+	return members, nil
+}
+
+/*
+NewFleet checks the configuration and prepares the members. No connections
+are made until Run is called.
+*/
+func NewFleet(fc FleetConfig) (*Fleet, error) {
+	if fc.Interval <= 0 {
+		return nil, fmt.Errorf("Fleet collection interval must be greater than 0")
+	}
+	if fc.ReconnectInterval <= 0 {
+		fc.ReconnectInterval = fc.Interval
+	}
+
+	f := &Fleet{config: fc}
+	keys := make(map[string]bool)
+	for _, m := range fc.Members {
+		if m.Key == "" {
+			m.Key = m.QMgrName
+		}
+		if m.Key == "" || m.Key == DEFAULT_CONNECTION_KEY {
+			return nil, fmt.Errorf("Fleet member for queue manager '%s' needs a key", m.QMgrName)
+		}
+		if keys[m.Key] {
+			return nil, fmt.Errorf("Fleet member key '%s' is used more than once", m.Key)
+		}
+		keys[m.Key] = true
+		fm := &fleetMember{FleetMember: m}
+		fm.health.Key = m.Key
+		fm.health.QMgrName = m.QMgrName
+		f.members = append(f.members, fm)
+	}
+	return f, nil
+}
+
+// How far into each interval the collection for member i should start
+func staggerOffset(i int, n int, interval time.Duration) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+	return interval * time.Duration(i) / time.Duration(n)
+}
+
+/*
+Run processes the members until Stop is called. It does not return until then,
+so it is usually run in its own goroutine.
+*/
+func (f *Fleet) Run(collect FleetCollectFunc) {
+	traceEntry("FleetRun")
+
+	f.mutex.Lock()
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	stop := f.stop
+	f.mutex.Unlock()
+	defer close(f.done)
+
+	interval := time.Duration(f.config.Interval) * time.Second
+	for {
+		start := time.Now()
+		for i, m := range f.members {
+			if f.config.Stagger {
+				wait := time.Until(start.Add(staggerOffset(i, len(f.members), interval)))
+				if wait > 0 {
+					select {
+					case <-stop:
+						f.disconnectAll()
+						traceExit("FleetRun", 1)
+						return
+					case <-time.After(wait):
+					}
+				}
+			}
+			f.collectMember(m, collect)
+		}
+
+		select {
+		case <-stop:
+			f.disconnectAll()
+			traceExit("FleetRun", 0)
+			return
+		case <-time.After(time.Until(start.Add(interval))):
+		}
+	}
+}
+
+/*
+Stop ends the Run loop and disconnects from all the queue managers
+*/
+func (f *Fleet) Stop() {
+	f.mutex.Lock()
+	stop, done := f.stop, f.done
+	f.stop = nil
+	f.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+/*
+Health returns the current state of each member
+*/
+func (f *Fleet) Health() []FleetHealth {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	rc := make([]FleetHealth, 0, len(f.members))
+	for _, m := range f.members {
+		rc = append(rc, m.health)
+	}
+	return rc
+}
+
+func (f *Fleet) collectMember(m *fleetMember, collect FleetCollectFunc) {
+	var err error
+
+	SetConnectionKey(m.Key)
+
+	if !m.health.Connected {
+		if time.Now().Before(m.nextConnect) {
+			return
+		}
+		cc := m.Connection
+		err = InitConnectionKey(m.Key, m.QMgrName, m.ReplyQ, m.ReplyQ2, &cc)
+		if err != nil {
+			logError("Fleet: cannot connect to %s: %v", m.QMgrName, err)
+			f.recordResult(m, false, err)
+			m.nextConnect = time.Now().Add(time.Duration(f.config.ReconnectInterval) * time.Second)
+			return
+		}
+		m.newConnection = true
+		f.mutex.Lock()
+		m.health.Connected = true
+		if ci := getConnection(m.Key); ci != nil && ci.si.resolvedQMgrName != "" {
+			m.health.QMgrName = ci.si.resolvedQMgrName
+		}
+		f.mutex.Unlock()
+	}
+
+	err = collect(m.Key, m.health.QMgrName, m.newConnection)
+	m.newConnection = false
+
+	model := GetModel()
+	f.mutex.Lock()
+	m.model = model
+	f.mutex.Unlock()
+
+	if err != nil {
+		// Find out whether the connection itself has gone, or if it was
+		// some other problem that might not happen next time
+		if CheckConnection() != nil {
+			logError("Fleet: connection to %s has failed: %v", m.QMgrName, err)
+			EndConnection()
+			f.recordResult(m, false, err)
+			m.nextConnect = time.Now().Add(time.Duration(f.config.ReconnectInterval) * time.Second)
+			return
+		}
+	}
+	f.recordResult(m, true, err)
+}
+
+func (f *Fleet) recordResult(m *fleetMember, connected bool, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	m.health.Connected = connected
+	if !connected {
+		m.model = nil
+	}
+	m.health.LastError = err
+	h, _ := GetCommandServerHealth()
+	m.health.StatusDegraded = connected && h.Degraded
+	if err == nil {
+		m.health.LastCollection = time.Now()
+		m.health.ConsecutiveFails = 0
+	} else {
+		m.health.ConsecutiveFails++
+	}
+}
+
+func (f *Fleet) disconnectAll() {
+	for _, m := range f.members {
+		if m.health.Connected {
+			SetConnectionKey(m.Key)
+			EndConnection()
+			f.mutex.Lock()
+			m.health.Connected = false
+			m.model = nil
+			f.mutex.Unlock()
+		}
+	}
+}
+
+/*
+GetModel merges the metrics from the most recent collection of each connected member.
+Values for the same metric from all the members go into one ModelMetric, with the QMgr
+of each ModelValue set to the member's queue manager name. The QMgrName of the merged
+snapshot is empty, and its Time is when the merge was done.
+*/
+func (f *Fleet) GetModel() *ModelSnapshot {
+	f.mutex.Lock()
+	models := make([]*ModelSnapshot, 0, len(f.members))
+	qMgrNames := make([]string, 0, len(f.members))
+	for _, m := range f.members {
+		if m.model != nil {
+			models = append(models, m.model)
+			qMgrNames = append(qMgrNames, m.health.QMgrName)
+		}
+	}
+	f.mutex.Unlock()
+
+	return mergeModels(models, qMgrNames)
+}
+
+// Combine several snapshots, labelling each value with the queue manager it came from
+func mergeModels(models []*ModelSnapshot, qMgrNames []string) *ModelSnapshot {
+	type metricKey struct {
+		source, class, typ, name string
+	}
+
+	merged := &ModelSnapshot{Version: ModelVersion, Time: time.Now()}
+	index := make(map[metricKey]int)
+
+	for i, model := range models {
+		qMgrName := qMgrNames[i]
+		if qMgrName == "" {
+			qMgrName = model.QMgrName
+		}
+		for _, mm := range model.Metrics {
+			k := metricKey{mm.Source, mm.Class, mm.Type, mm.Name}
+			idx, ok := index[k]
+			if !ok {
+				idx = len(merged.Metrics)
+				index[k] = idx
+				mc := mm
+				mc.Values = nil
+				merged.Metrics = append(merged.Metrics, mc)
+			}
+			for _, v := range mm.Values {
+				v.QMgr = qMgrName
+				merged.Metrics[idx].Values = append(merged.Metrics[idx].Values, v)
+			}
+		}
+	}
+
+	for _, mm := range merged.Metrics {
+		l := mm.Values
+		sort.Slice(l, func(i, j int) bool {
+			if l[i].QMgr != l[j].QMgr {
+				return l[i].QMgr < l[j].QMgr
+			}
+			return l[i].Object < l[j].Object
+		})
+	}
+	sortModelMetrics(merged.Metrics)
+	return merged
+}
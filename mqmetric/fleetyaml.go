@@ -0,0 +1,277 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file reads a fleet configuration written in YAML, without needing a YAML package.
+Only the block style used for configuration files is understood: mappings, sequences
+("- " items, which may hold mappings) and scalars, with "#" comments. Scalars may be
+plain, 'single-quoted' or "double-quoted". Flow style ([a, b] or {a: b}), anchors,
+tags and multi-line strings are rejected.
+
+The YAML is converted into JSON, and then read in the same way as a JSON file, so the
+names are matched to the FleetConfig fields without regard to case. For example
+
+	interval: 60
+	stagger: true
+	members:
+	  - qMgrName: QM1
+	    connection:
+	      connName: host1(1414)
+	      channel: SYSTEM.DEF.SVRCONN
+	      useStatus: true
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+func fleetYAMLToJSON(b []byte) ([]byte, error) {
+	var lines []yamlLine
+
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(stripYAMLComment(l), " \t\r")
+		text := strings.TrimLeft(l, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(l) - len(text), text: text})
+	}
+	if len(lines) == 0 {
+		return []byte("{}"), nil
+	}
+
+	v, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	return json.Marshal(v)
+}
+
+// A "#" starts a comment at the beginning of a line or after a space, unless it is quoted
+func stripYAMLComment(l string) string {
+	var quote rune
+	for i, c := range l {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// Parse the mapping or sequence whose entries start at the given indent
+func parseYAMLBlock(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	if isYAMLSeqItem(lines[i].text) {
+		return parseYAMLSeq(lines, i, indent)
+	}
+	return parseYAMLMap(lines, i, indent)
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseYAMLSeq(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	seq := make([]interface{}, 0)
+
+	for i < len(lines) && lines[i].indent == indent && isYAMLSeqItem(lines[i].text) {
+		rest := strings.TrimLeft(strings.TrimPrefix(lines[i].text, "-"), " ")
+		if rest == "" {
+			if i+1 < len(lines) && lines[i+1].indent > indent {
+				v, next, err := parseYAMLBlock(lines, i+1, lines[i+1].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				seq = append(seq, v)
+				i = next
+			} else {
+				seq = append(seq, nil)
+				i++
+			}
+			continue
+		}
+
+		if _, _, isKey := splitYAMLKey(rest); isKey || isYAMLSeqItem(rest) {
+			// The item is a block that starts on the same line as the "-". Its
+			// later lines are indented to line up with the first.
+			itemIndent := indent + len(lines[i].text) - len(rest)
+			lines[i] = yamlLine{number: lines[i].number, indent: itemIndent, text: rest}
+			v, next, err := parseYAMLBlock(lines, i, itemIndent)
+			if err != nil {
+				return nil, 0, err
+			}
+			seq = append(seq, v)
+			i = next
+			continue
+		}
+
+		v, err := parseYAMLScalar(rest, lines[i].number)
+		if err != nil {
+			return nil, 0, err
+		}
+		seq = append(seq, v)
+		i++
+	}
+	return seq, i, nil
+}
+
+func parseYAMLMap(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	m := make(map[string]interface{})
+
+	for i < len(lines) && lines[i].indent == indent {
+		l := lines[i]
+		if isYAMLSeqItem(l.text) {
+			return nil, 0, fmt.Errorf("line %d: sequence item found where a key was expected", l.number)
+		}
+		key, value, isKey := splitYAMLKey(l.text)
+		if !isKey {
+			return nil, 0, fmt.Errorf("line %d: expected \"key: value\"", l.number)
+		}
+		if _, ok := m[key]; ok {
+			return nil, 0, fmt.Errorf("line %d: duplicate key %s", l.number, key)
+		}
+		i++
+
+		if value != "" {
+			v, err := parseYAMLScalar(value, l.number)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			continue
+		}
+
+		// A nested block is indented further, except that a sequence may also be
+		// at the same indent as its key
+		switch {
+		case i < len(lines) && lines[i].indent > indent:
+			v, next, err := parseYAMLBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			i = next
+		case i < len(lines) && lines[i].indent == indent && isYAMLSeqItem(lines[i].text):
+			v, next, err := parseYAMLSeq(lines, i, indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			i = next
+		default:
+			m[key] = nil
+		}
+	}
+
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return m, i, nil
+}
+
+// Split "key: value" or "key:". The key may be quoted.
+func splitYAMLKey(text string) (string, string, bool) {
+	var key, rest string
+
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key = text[1 : end+1]
+		rest = text[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		idx := strings.Index(text, ": ")
+		if idx < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			idx = len(text) - 1
+		}
+		key = strings.TrimRight(text[:idx], " ")
+		rest = text[idx+1:]
+	}
+
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+func parseYAMLScalar(s string, number int) (interface{}, error) {
+	switch s[0] {
+	case '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad quoted string %s", number, s)
+		}
+		return v, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("line %d: bad quoted string %s", number, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case '[', '{', '&', '*', '!', '|', '>':
+		return nil, fmt.Errorf("line %d: this form of YAML is not supported: %s", number, s)
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f, nil
+	}
+	return s, nil
+}
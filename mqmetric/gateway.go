@@ -0,0 +1,156 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file allows a collector to monitor a queue manager that it is not directly
+connected to. The collector connects to a gateway queue manager, and the PCF commands
+are sent to the command queue of the TargetQMgr named in the ConnectionConfig. The
+normal MQ routing, through transmission queues and channels, takes the commands there
+and brings the replies back to the reply queue on the gateway.
+
+This needs the routing to be set up in both directions - for example, transmission
+queues named after each queue manager, or a cluster. The replies take longer than
+for a local command server, so the WaitInterval may need to be increased.
+
+Only the status polling is possible in this mode, as subscriptions for the published
+statistics can only be made on the connected queue manager. Attributes of the queue
+manager that would normally come from an MQINQ are instead found with an INQUIRE_Q_MGR
+PCF command. The heartbeat, if used, only checks the connection to the gateway.
+*/
+
+import (
+	"fmt"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+/*
+inqQMgrAttrs returns the same map of values as an MQINQ on the queue manager
+object. When working through a gateway, the values come from the remote queue
+manager instead.
+*/
+func inqQMgrAttrs(ci *connectionInfo, selectors []int32) (map[int32]interface{}, error) {
+	var err error
+
+	if ci.si.targetQMgrName == "" {
+		return ci.si.qMgrObject.Inq(selectors)
+	}
+
+	traceEntry("inqQMgrAttrs")
+
+	statusClearReplyQ()
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = ibmmq.MQCMD_INQUIRE_Q_MGR
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
+	pcfparm.Parameter = ibmmq.MQIACF_Q_MGR_ATTRS
+	for _, s := range selectors {
+		pcfparm.Int64Value = append(pcfparm.Int64Value, int64(s))
+	}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("inqQMgrAttrs", 1, err)
+		return nil, err
+	}
+
+	var cmdErr error
+	v := make(map[int32]interface{})
+	for allReceived := false; !allReceived; {
+		cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if cfh != nil && cfh.Reason != ibmmq.MQRC_NONE {
+			cmdErr = &ibmmq.MQReturn{MQCC: cfh.CompCode, MQRC: cfh.Reason}
+		}
+		if buf != nil {
+			parseQMgrAttrs(buf, v)
+		}
+	}
+	if err == nil {
+		err = cmdErr
+	}
+
+	// Make sure every requested value is present so that callers can use
+	// type assertions in the same way as for the MQINQ results
+	if err == nil {
+		for _, s := range selectors {
+			if _, ok := v[s]; !ok {
+				err = fmt.Errorf("Queue manager attribute %d not returned by %s", s, ci.si.targetQMgrName)
+				break
+			}
+		}
+	}
+
+	traceExitErr("inqQMgrAttrs", 0, err)
+	return v, err
+}
+
+func parseQMgrAttrs(buf []byte, v map[int32]interface{}) {
+	offset := 0
+	for offset < len(buf) {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		if bytesRead <= 0 {
+			break
+		}
+		offset += bytesRead
+
+		switch elem.Type {
+		case ibmmq.MQCFT_INTEGER:
+			v[elem.Parameter] = int32(elem.Int64Value[0])
+		case ibmmq.MQCFT_STRING:
+			v[elem.Parameter] = trimToNull(elem.String[0])
+		}
+	}
+}
+
+// Find out about the queue manager being monitored through the gateway. This is
+// called once the command and reply queues are open.
+func initTargetQMgr(ci *connectionInfo, cc *ConnectionConfig) error {
+	traceEntryF("initTargetQMgr", "Target: %s", ci.si.targetQMgrName)
+
+	selectors := []int32{ibmmq.MQCA_Q_MGR_NAME,
+		ibmmq.MQIA_COMMAND_LEVEL,
+		ibmmq.MQIA_PERFORMANCE_EVENT,
+		ibmmq.MQIA_PLATFORM}
+
+	v, err := inqQMgrAttrs(ci, selectors)
+	if err == nil {
+		ci.si.resolvedQMgrName = v[ibmmq.MQCA_Q_MGR_NAME].(string)
+		ci.si.platform = v[ibmmq.MQIA_PLATFORM].(int32)
+		ci.si.commandLevel = v[ibmmq.MQIA_COMMAND_LEVEL].(int32)
+		ci.usePublications = false
+		ci.useResetQStats = false
+		if ci.si.platform == ibmmq.MQPL_ZOS && cc.UseResetQStats {
+			if v[ibmmq.MQIA_PERFORMANCE_EVENT].(int32) == 0 {
+				err = fmt.Errorf("Requested use of RESET QSTATS but queue manager has PERFMEV(DISABLED)")
+			} else {
+				ci.useResetQStats = true
+			}
+		}
+	}
+
+	traceExitErr("initTargetQMgr", 0, err)
+	return err
+}
@@ -20,23 +20,34 @@ package mqmetric
 */
 
 import (
+	"io"
+	"time"
+
 	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
 )
 
 type sessionInfo struct {
-	qMgr            ibmmq.MQQueueManager
-	cmdQObj         ibmmq.MQObject
-	replyQObj       ibmmq.MQObject
-	qMgrObject      ibmmq.MQObject
+	qMgr            ibmmq.QMgrConnection
+	cmdQObj         ibmmq.Object
+	replyQObj       ibmmq.Object
+	qMgrObject      ibmmq.Object
 	replyQBaseName  string
 	replyQ2BaseName string
-	statusReplyQObj ibmmq.MQObject
+	statusReplyQObj ibmmq.Object
 	statusReplyBuf  []byte
 
+	metadataQObj      ibmmq.Object
+	metadataQBaseName string
+
+	cmdEventQObj    ibmmq.Object
+	cmdEventQOpened bool
+	cmdEventBrowse  bool
+
 	platform         int32
 	commandLevel     int32
 	maxHandles       int32
 	resolvedQMgrName string
+	targetQMgrName   string // Set when monitoring through a gateway
 
 	qmgrConnected bool
 	queuesOpened  bool
@@ -54,8 +65,12 @@ type connectionInfo struct {
 	showInactiveChannels bool
 	hideSvrConnJobname   bool
 	hideAMQPClientId     bool
+	chlAggregation       int
+	browseMsgAgeQueues   string
 
 	durableSubPrefix string
+	useWildcardSubs  bool
+	subExpiry        int32
 
 	// Only issue the warning about a '/' in an object name once.
 	globalSlashWarning bool
@@ -64,7 +79,65 @@ type connectionInfo struct {
 	discoveryDone    bool
 	publicationCount int
 
+	discoverySnapshot *DiscoverySnapshot
+	discoveryChanges  []DiscoveryChange
+	removedObjects    map[int32][]string
+	discoverConfig    *DiscoverConfig
+
+	// The objects found by discovery for this connection
+	qInfoMap    map[string]*ObjInfo
+	chlInfoMap  map[string]*ObjInfo
+	amqpInfoMap map[string]*ObjInfo
+	nhaInfoMap  map[string]*ObjInfo
+	qMgrInfo    *ObjInfo
+
+	qMgrStartTime string
+	qMgrRestarted bool
+
 	waitInterval int
+	cmdWait      *adaptiveWait // Waits for command responses, publications and metadata. See waits.go
+	pubWait      *adaptiveWait
+	metaWait     time.Duration
+	pubWaitDue   bool // The first read of a ProcessPublications call can wait
+
+	commands   *commandTracker // PCF commands waiting for replies
+	limiter    *commandLimiter // Paces the PCF commands. Nil if not configured
+	cmdBreaker *commandBreaker // Stops the commands when there are no replies. See breaker.go
+	cycle      int32           // Set while a CollectOnce cycle is running. See collect.go
+
+	clockSkew *clockSkew
+	pubWindow *pubWindow
+
+	leader   LeaderLock
+	isLeader bool
+
+	heartbeat       *heartbeat
+	checkConnection bool
+
+	environment *Environment
+
+	thresholds *thresholds // Alert rules. See thresholds.go
+	ruleAudit  io.Writer   // See remediation.go
+
+	transforms map[string]TransformFunc
+	derived    map[string]*derivedMetric
+
+	recorder *replayRecorder
+	replay   *replayPlayer
+
+	pcfReader *ibmmq.PCFReader // Reused by ProcessPublications
+
+	archive *pubArchive
+
+	mqipt       *mqiptState
+	authEvents  *authEventState
+	applianceHA *ApplianceHAConfig
+
+	manageMonitoring map[int32]bool
+
+	// Publications that have been read but not yet processed
+	pubBatch  []ibmmq.BatchMessage
+	pubBuffer []byte
 
 	objectStatus     [OT_LAST_USED + 1]objectStatus
 	publishedMetrics AllMetrics
@@ -100,7 +173,11 @@ const (
 	OT_PS            = 18
 	OT_CLUSTER       = 19
 	OT_CHANNEL_AMQP  = 20
-	OT_LAST_USED     = OT_CHANNEL_AMQP
+	OT_CLUSTER_XMITQ = 21
+	OT_MQIPT         = 22
+	OT_AUTH          = 23
+	OT_APPLIANCE_HA  = 24
+	OT_LAST_USED     = OT_APPLIANCE_HA
 )
 
 var connectionMap = make(map[string]*connectionInfo)
@@ -124,6 +201,10 @@ var (
 	UsagePsStatus      StatusSet
 	UsageBpStatus      StatusSet
 	ClusterStatus      StatusSet
+	ClusterXmitQStatus StatusSet
+	MQIPTStatus        StatusSet
+	AuthStatus         StatusSet
+	ApplianceHAStatus  StatusSet
 )
 
 func newConnectionInfo(key string) *connectionInfo {
@@ -146,6 +227,9 @@ func newConnectionInfo(key string) *connectionInfo {
 	ci.localSlashWarning = false
 	ci.discoveryDone = false
 	ci.publicationCount = 0
+	ci.commands = newCommandTracker()
+	ci.clockSkew = new(clockSkew)
+	ci.qMgrInfo = new(ObjInfo)
 
 	for i := 1; i <= OT_LAST_USED; i++ {
 		ci.objectStatus[i].init = false
@@ -197,6 +281,14 @@ func GetObjectStatus(key string, objectType int) *StatusSet {
 			return &UsageBpStatus
 		case OT_CLUSTER:
 			return &ClusterStatus
+		case OT_CLUSTER_XMITQ:
+			return &ClusterXmitQStatus
+		case OT_MQIPT:
+			return &MQIPTStatus
+		case OT_AUTH:
+			return &AuthStatus
+		case OT_APPLIANCE_HA:
+			return &ApplianceHAStatus
 		default:
 			return nil
 		}
@@ -0,0 +1,114 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file keeps a client connection active when the collection interval is long.
+Firewalls and load balancers often drop idle TCP sessions without telling either end,
+and the collector then only finds out when the next collection fails.
+
+If the HeartbeatInterval is set in the ConnectionConfig, a goroutine issues an MQINQ
+on the queue manager object at that interval. The same check is made at the
+start of ProcessPublications so that a dead connection is reported
+immediately, instead of appearing as a lack of data.
+
+The connection is made with MQCNO_HANDLE_SHARE_BLOCK, so the heartbeat's MQINQ simply
+waits if a collection is running at the same time.
+*/
+
+import (
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+type heartbeat struct {
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func startHeartbeat(ci *connectionInfo, seconds int) {
+	traceEntry("startHeartbeat")
+
+	if seconds <= 0 || ci.heartbeat != nil {
+		traceExit("startHeartbeat", 1)
+		return
+	}
+
+	hb := &heartbeat{interval: time.Duration(seconds) * time.Second, stop: make(chan struct{})}
+	ci.heartbeat = hb
+
+	go func() {
+		ticker := time.NewTicker(hb.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hb.stop:
+				return
+			case <-ticker.C:
+				if err := inqQMgr(ci); err != nil {
+					logError("Heartbeat to queue manager failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	traceExit("startHeartbeat", 0)
+}
+
+func stopHeartbeat(ci *connectionInfo) {
+	if ci.heartbeat != nil {
+		close(ci.heartbeat.stop)
+		ci.heartbeat = nil
+	}
+}
+
+// A simple MQI call that goes to the queue manager
+func inqQMgr(ci *connectionInfo) error {
+	_, err := ci.si.qMgrObject.Inq([]int32{ibmmq.MQCA_Q_MGR_NAME})
+	return err
+}
+
+/*
+CheckConnection verifies that the connection to the queue manager is still usable. It
+returns an MQMetricError if not, and the application should then call EndConnection and
+start again.
+*/
+func CheckConnection() error {
+	traceEntry("CheckConnection")
+
+	ci := getConnection(GetConnectionKey())
+	if ci.replay != nil {
+		traceExit("CheckConnection", 1)
+		return nil
+	}
+
+	err := inqQMgr(ci)
+	if err != nil {
+		mqreturn, ok := err.(*ibmmq.MQReturn)
+		if !ok {
+			mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN}
+		}
+		err = MQMetricError{Err: "Connection to queue manager is not usable", MQReturn: mqreturn}
+	}
+
+	traceExitErr("CheckConnection", 0, err)
+	return err
+}
@@ -0,0 +1,93 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file keeps a single copy of strings that are seen over and over again, such as queue
+names, channel names and other label values. Each publication and each status response
+is parsed into new strings, and those strings are then kept as map keys and values until
+the object disappears. On a large system the same names end up being held many times.
+Passing them through intern means that the maps all refer to one copy, and the copies
+made by parsing can be garbage-collected straight away.
+
+The table is shared by all connections. It has a limit on its size so that names which
+keep changing, such as the job names of client channels, cannot make it grow forever.
+When the limit is reached, the table is emptied and starts again; strings that are
+already in use are not affected.
+*/
+
+import (
+	"sync"
+)
+
+const defaultInternLimit = 100000
+
+var internTable = struct {
+	sync.Mutex
+	m     map[string]string
+	limit int
+	hits  int64
+}{m: make(map[string]string), limit: defaultInternLimit}
+
+/*
+SetInternLimit sets the maximum number of different strings that are kept. 0 turns
+off interning. The table and its statistics are cleared.
+*/
+func SetInternLimit(n int) {
+	internTable.Lock()
+	internTable.limit = n
+	internTable.m = make(map[string]string)
+	internTable.hits = 0
+	internTable.Unlock()
+}
+
+/*
+GetInternStats returns the number of strings in the table and the number of times
+an existing copy has been used
+*/
+func GetInternStats() (int, int64) {
+	internTable.Lock()
+	defer internTable.Unlock()
+	return len(internTable.m), internTable.hits
+}
+
+// Return the shared copy of a string
+func intern(s string) string {
+	if s == "" {
+		return s
+	}
+
+	internTable.Lock()
+	defer internTable.Unlock()
+
+	if internTable.limit <= 0 {
+		return s
+	}
+	if is, ok := internTable.m[s]; ok {
+		internTable.hits++
+		return is
+	}
+	if len(internTable.m) >= internTable.limit {
+		logDebug("Intern table has reached %d entries. Starting again.", internTable.limit)
+		internTable.m = make(map[string]string)
+	}
+	internTable.m[s] = s
+	return s
+}
@@ -0,0 +1,145 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has helpers for walking the tree of published metrics in a fixed order. The
+tree is built from maps, so a plain range over it gives a different order on every run,
+which makes the output of a collector hard to compare between runs or check in a test.
+
+Classes and types are sorted by name, elements by metric name and object values by key.
+With Go 1.23 or later, the same traversals are also available as iterators. See iterseq.go
+*/
+
+import (
+	"sort"
+)
+
+/*
+SortedClasses returns the classes in order of their names
+*/
+func (m *AllMetrics) SortedClasses() []*MonClass {
+	return m.classList(true)
+}
+
+/*
+SortedTypes returns the types of a class in order of their names
+*/
+func (c *MonClass) SortedTypes() []*MonType {
+	return c.typeList(true)
+}
+
+/*
+SortedElements returns the elements of a type in order of their metric names
+*/
+func (t *MonType) SortedElements() []*MonElement {
+	return t.elementList(true)
+}
+
+/*
+SortedKeys returns the keys of the objects that have a value, in order
+*/
+func (elem *MonElement) SortedKeys() []string {
+	keys := make([]string, 0, elem.Len())
+	elem.Range(func(key string, value int64) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+RangeSorted is the same as Range, but calls the function in order of the object keys
+*/
+func (elem *MonElement) RangeSorted(f func(key string, value int64)) {
+	for _, k := range elem.SortedKeys() {
+		v, _ := elem.Value(k)
+		f(k, v)
+	}
+}
+
+/*
+SortedKeys returns the keys of the objects that have a value for the attribute, in order
+*/
+func (attr *StatusAttribute) SortedKeys() []string {
+	keys := make([]string, 0, len(attr.Values))
+	for k := range attr.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+SortedAttributes returns the attributes of a status set in order of their metric names
+*/
+func (st *StatusSet) SortedAttributes() []*StatusAttribute {
+	return st.attributeList(true)
+}
+
+func (m *AllMetrics) classList(sorted bool) []*MonClass {
+	l := make([]*MonClass, 0, len(m.Classes))
+	for _, c := range m.Classes {
+		l = append(l, c)
+	}
+	if sorted {
+		sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	}
+	return l
+}
+
+func (c *MonClass) typeList(sorted bool) []*MonType {
+	l := make([]*MonType, 0, len(c.Types))
+	for _, t := range c.Types {
+		l = append(l, t)
+	}
+	if sorted {
+		sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	}
+	return l
+}
+
+func (t *MonType) elementList(sorted bool) []*MonElement {
+	l := make([]*MonElement, 0, len(t.Elements))
+	for _, e := range t.Elements {
+		l = append(l, e)
+	}
+	if sorted {
+		// Names are normally unique within a type, but the description decides if not
+		sort.Slice(l, func(i, j int) bool {
+			if l[i].MetricName != l[j].MetricName {
+				return l[i].MetricName < l[j].MetricName
+			}
+			return l[i].Description < l[j].Description
+		})
+	}
+	return l
+}
+
+func (st *StatusSet) attributeList(sorted bool) []*StatusAttribute {
+	l := make([]*StatusAttribute, 0, len(st.Attributes))
+	for _, a := range st.Attributes {
+		l = append(l, a)
+	}
+	if sorted {
+		sort.Slice(l, func(i, j int) bool { return l[i].MetricName < l[j].MetricName })
+	}
+	return l
+}
@@ -0,0 +1,162 @@
+//go:build go1.23
+// +build go1.23
+
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Iterators over the published metrics and status values, for use with range-over-func
+in Go 1.23 or later. For example, to print every published value in a stable order:
+
+	for elem := range metrics.AllElements(true) {
+		for key, value := range elem.All(true) {
+			fmt.Printf("%s %s %d\n", elem.MetricName, key, value)
+		}
+	}
+
+When sorted is false, the order is not defined but the iteration is a little cheaper.
+The tree must not be changed, for example by ProcessPublications, during an iteration.
+*/
+
+import (
+	"iter"
+)
+
+/*
+AllClasses returns an iterator over the classes
+*/
+func (m *AllMetrics) AllClasses(sorted bool) iter.Seq[*MonClass] {
+	return func(yield func(*MonClass) bool) {
+		for _, c := range m.classList(sorted) {
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+/*
+AllElements returns an iterator over every element of every type in every class
+*/
+func (m *AllMetrics) AllElements(sorted bool) iter.Seq[*MonElement] {
+	return func(yield func(*MonElement) bool) {
+		for _, c := range m.classList(sorted) {
+			for _, t := range c.typeList(sorted) {
+				for _, e := range t.elementList(sorted) {
+					if !yield(e) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+/*
+AllTypes returns an iterator over the types in a class
+*/
+func (c *MonClass) AllTypes(sorted bool) iter.Seq[*MonType] {
+	return func(yield func(*MonType) bool) {
+		for _, t := range c.typeList(sorted) {
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+/*
+AllElements returns an iterator over the elements in a type
+*/
+func (t *MonType) AllElements(sorted bool) iter.Seq[*MonElement] {
+	return func(yield func(*MonElement) bool) {
+		for _, e := range t.elementList(sorted) {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+/*
+All returns an iterator over the object keys and values of an element
+*/
+func (elem *MonElement) All(sorted bool) iter.Seq2[string, int64] {
+	return func(yield func(string, int64) bool) {
+		if sorted {
+			for _, k := range elem.SortedKeys() {
+				v, _ := elem.Value(k)
+				if !yield(k, v) {
+					return
+				}
+			}
+			return
+		}
+		if elem.column == nil {
+			for k, v := range elem.Values {
+				if !yield(k, v) {
+					return
+				}
+			}
+			return
+		}
+		c := elem.column
+		for i := range c.values {
+			if c.has(i) && !yield(c.objects.names[i], c.values[i]) {
+				return
+			}
+		}
+	}
+}
+
+/*
+AllAttributes returns an iterator over the attributes of a status set
+*/
+func (st *StatusSet) AllAttributes(sorted bool) iter.Seq[*StatusAttribute] {
+	return func(yield func(*StatusAttribute) bool) {
+		for _, a := range st.attributeList(sorted) {
+			if !yield(a) {
+				return
+			}
+		}
+	}
+}
+
+/*
+All returns an iterator over the object keys and values of a status attribute
+*/
+func (attr *StatusAttribute) All(sorted bool) iter.Seq2[string, *StatusValue] {
+	return func(yield func(string, *StatusValue) bool) {
+		if sorted {
+			for _, k := range attr.SortedKeys() {
+				if !yield(k, attr.Values[k]) {
+					return
+				}
+			}
+			return
+		}
+		for k, v := range attr.Values {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
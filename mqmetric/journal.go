@@ -0,0 +1,331 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file provides a local journal that a collector can use when the database it sends
+metrics to cannot be reached. Instead of dropping the values, the collector writes them
+to the journal with their original timestamps. When the database is available again,
+Backfill passes everything back to the collector, oldest first, and deletes each journal
+file once it has been sent.
+
+The journal is a directory of gzip-compressed files, each holding one JSON sample per
+line. A new file is started when the current one has MaxFileSize bytes of uncompressed
+data, and the oldest files are deleted if the directory grows beyond MaxTotalSize.
+
+A typical use is
+
+	if err := send(samples); err != nil {
+		journal.Write(samples)
+	} else if journal.Pending() > 0 {
+		journal.Backfill(send)
+	}
+*/
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	journalPrefix = "mqmetric-"
+	journalSuffix = ".jsonl.gz"
+
+	defaultJournalFileSize  = 16 * 1024 * 1024
+	defaultJournalTotalSize = 1024 * 1024 * 1024
+
+	// How many samples are given to the send function in each call during Backfill
+	journalBatchSize = 1000
+)
+
+// JournalSample is a single metric value
+type JournalSample struct {
+	Time   time.Time         `json:"t"`
+	Name   string            `json:"n"`
+	Labels map[string]string `json:"l,omitempty"`
+	Value  float64           `json:"v"`
+}
+
+// JournalConfig says where the journal is kept, and how large it can grow
+type JournalConfig struct {
+	Directory    string
+	MaxFileSize  int64 // Uncompressed bytes in each file. Default 16MB
+	MaxTotalSize int64 // Compressed bytes in all files. Default 1GB
+}
+
+// Journal holds samples that could not be sent
+type Journal struct {
+	sync.Mutex
+	cfg     JournalConfig
+	f       *os.File
+	gz      *gzip.Writer
+	written int64
+	seq     int // Keeps the file names unique and in order even if the clock is coarse
+}
+
+/*
+OpenJournal creates the directory if necessary. Files left from an earlier run are
+kept, and are included in the next Backfill.
+*/
+func OpenJournal(cfg JournalConfig) (*Journal, error) {
+	traceEntryF("OpenJournal", "Directory: %s", cfg.Directory)
+
+	if cfg.Directory == "" {
+		err := fmt.Errorf("No journal directory given")
+		traceExitErr("OpenJournal", 1, err)
+		return nil, err
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = defaultJournalFileSize
+	}
+	if cfg.MaxTotalSize <= 0 {
+		cfg.MaxTotalSize = defaultJournalTotalSize
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		traceExitErr("OpenJournal", 2, err)
+		return nil, err
+	}
+
+	traceExit("OpenJournal", 0)
+	return &Journal{cfg: cfg}, nil
+}
+
+/*
+Write adds the samples to the current journal file. The data is flushed before
+returning, so little is lost if the collector stops.
+*/
+func (j *Journal) Write(samples []JournalSample) error {
+	j.Lock()
+	defer j.Unlock()
+
+	for i := range samples {
+		if j.gz == nil {
+			if err := j.create(); err != nil {
+				return err
+			}
+		}
+		b, err := json.Marshal(&samples[i])
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		if _, err = j.gz.Write(b); err != nil {
+			return err
+		}
+		j.written += int64(len(b))
+		if j.written >= j.cfg.MaxFileSize {
+			if err = j.roll(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.gz != nil {
+		return j.gz.Flush()
+	}
+	return nil
+}
+
+/*
+Backfill gives every journalled sample to the send function, oldest first, in
+batches. Each file is deleted once all of its samples have been sent. If send returns
+an error, Backfill stops and returns it, and the remaining files are kept for the next
+attempt. A file that has been only partly sent will be sent again from the start, so
+the send function must cope with duplicate samples. The number of samples sent is
+returned.
+*/
+func (j *Journal) Backfill(send func([]JournalSample) error) (int, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	traceEntry("Backfill")
+
+	if err := j.roll(); err != nil {
+		traceExitErr("Backfill", 1, err)
+		return 0, err
+	}
+
+	files, err := j.files()
+	if err != nil {
+		traceExitErr("Backfill", 2, err)
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		n, err := j.sendFile(file, send)
+		count += n
+		if err != nil {
+			traceExitErr("Backfill", 3, err)
+			return count, err
+		}
+		os.Remove(file)
+	}
+
+	traceExitF("Backfill", 0, "Sent: %d", count)
+	return count, nil
+}
+
+// Pending returns how many journal files are waiting to be sent
+func (j *Journal) Pending() int {
+	j.Lock()
+	defer j.Unlock()
+
+	files, _ := j.files()
+	return len(files)
+}
+
+// Close finishes the current journal file. The files stay in the directory.
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.roll()
+}
+
+func (j *Journal) create() error {
+	j.seq++
+	name := fmt.Sprintf("%s%020d-%06d%s", journalPrefix, time.Now().UnixNano(), j.seq%1000000, journalSuffix)
+	f, err := os.OpenFile(filepath.Join(j.cfg.Directory, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	j.gz = gzip.NewWriter(f)
+	j.written = 0
+	return nil
+}
+
+// Close the current file, if there is one, and then keep the journal within its size limit
+func (j *Journal) roll() error {
+	if j.gz == nil {
+		return nil
+	}
+
+	err := j.gz.Close()
+	if e2 := j.f.Close(); err == nil {
+		err = e2
+	}
+	j.gz = nil
+	j.f = nil
+
+	if err == nil {
+		j.trim()
+	}
+	return err
+}
+
+// Delete the oldest files until the total size is below the limit
+func (j *Journal) trim() {
+	files, err := j.files()
+	if err != nil {
+		return
+	}
+
+	sizes := make([]int64, len(files))
+	total := int64(0)
+	for i, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; i < len(files)-1 && total > j.cfg.MaxTotalSize; i++ {
+		logError("Journal is too large. Discarding %s", files[i])
+		os.Remove(files[i])
+		total -= sizes[i]
+	}
+}
+
+// The completed journal files, oldest first
+func (j *Journal) files() ([]string, error) {
+	entries, err := os.ReadDir(j.cfg.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, journalPrefix) && strings.HasSuffix(name, journalSuffix) {
+			files = append(files, filepath.Join(j.cfg.Directory, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+/*
+Send the contents of one file. A file that was not completed, perhaps because the
+collector was killed, ends with a damaged record; everything before that is still sent.
+*/
+func (j *Journal) sendFile(file string, send func([]JournalSample) error) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	batch := make([]JournalSample, 0, journalBatchSize)
+
+	gz, err := gzip.NewReader(f)
+	if err == nil {
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var s JournalSample
+			if json.Unmarshal(scanner.Bytes(), &s) != nil {
+				break
+			}
+			batch = append(batch, s)
+			if len(batch) == journalBatchSize {
+				if err = send(batch); err != nil {
+					return count, err
+				}
+				count += len(batch)
+				batch = batch[:0]
+			}
+		}
+		if e2 := scanner.Err(); e2 != nil && e2 != io.ErrUnexpectedEOF {
+			logError("Journal file %s is damaged: %v", file, e2)
+		}
+	} else {
+		logError("Journal file %s is damaged: %v", file, err)
+	}
+
+	if len(batch) > 0 {
+		if err = send(batch); err != nil {
+			return count, err
+		}
+		count += len(batch)
+	}
+	return count, nil
+}
@@ -0,0 +1,131 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has the common handling of the strings that collectors use as labels, tags
+or parts of metric names. Names returned by MQ are padded with spaces, and values such
+as connection names and job names can contain characters that a monitoring system does
+not allow.
+
+Values stored in the status maps have the MQ padding removed, but are otherwise
+unchanged. Collectors then call SanitiseLabelName or SanitiseLabelValue with the style
+for their backend, so that every collector treats the same string in the same way.
+*/
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ibm-messaging/mq-golang/v5/mqutil"
+)
+
+// The backends that have different rules for names and values
+const (
+	LABEL_STYLE_PROMETHEUS = iota
+	LABEL_STYLE_INFLUX
+	LABEL_STYLE_STATSD
+)
+
+// Characters that statsd and its variants use as separators
+const statsdReserved = ":|@#, \t\r\n"
+
+// Remove the padding from a fixed-length MQ field. Some fields can also have
+// trailing nulls.
+func trimMQString(s string) string {
+	return mqutil.TrimName(s)
+}
+
+// Trim an MQ value and make sure that it is not empty, as some backends
+// ignore or reject empty values
+func labelValue(s string) string {
+	s = trimMQString(s)
+	if s == "" {
+		s = DUMMY_STRING
+	}
+	return s
+}
+
+/*
+SanitiseLabelName converts a string into something that can be used as the name
+of a label, tag or metric component in the given style
+*/
+func SanitiseLabelName(name string, style int) string {
+	name = trimMQString(name)
+
+	switch style {
+	case LABEL_STYLE_PROMETHEUS:
+		// Names must match [a-zA-Z_][a-zA-Z0-9_]*
+		b := []byte(name)
+		for i, c := range b {
+			if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+				b[i] = '_'
+			}
+		}
+		name = string(b)
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+	case LABEL_STYLE_INFLUX:
+		name = escapeInflux(name)
+	case LABEL_STYLE_STATSD:
+		name = replaceChars(name, statsdReserved+".", '_')
+	}
+	return name
+}
+
+/*
+SanitiseLabelValue converts a string into something that can be used as the value
+of a label or tag in the given style. Empty values are replaced by DUMMY_STRING.
+*/
+func SanitiseLabelValue(value string, style int) string {
+	value = strings.ToValidUTF8(labelValue(value), "?")
+
+	switch style {
+	case LABEL_STYLE_PROMETHEUS:
+		// Any UTF-8 is allowed; the exporter library does any quoting
+	case LABEL_STYLE_INFLUX:
+		value = escapeInflux(replaceChars(value, "\r\n", ' '))
+	case LABEL_STYLE_STATSD:
+		value = replaceChars(value, statsdReserved, '_')
+	}
+	return value
+}
+
+// The line protocol needs commas, equals signs and spaces in tags to be escaped
+func escapeInflux(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == ',' || r == '=' || r == ' ' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func replaceChars(s string, chars string, with rune) string {
+	return strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || strings.ContainsRune(chars, r) {
+			return with
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,248 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file supports running two or more copies of a collector for the same queue manager,
+with only one of them, the leader, reporting the published metrics at any time. Which
+copy is the leader is decided outside this package, through the LeaderLock interface. A
+lock file on a shared filesystem is provided here. Other mechanisms such as a Kubernetes
+lease can be plugged in with LeaderCallbacks.
+
+A leader lock needs durable subscriptions, with the same DurableSubPrefix and the same
+predefined reply queue in every copy. All of the subscriptions are then durable, including
+the queue manager-level ones, and have the same names whichever copy creates them, so
+there is only ever one copy of each publication. While the leader is running it is the
+only copy that reads the reply queue. If it stops, the publications wait on the queue until
+another copy takes over and reads them, so nothing is counted twice or lost.
+
+Because the subscriptions are shared, they are not deleted when a connection starts or ends.
+Subscriptions left behind by a change of configuration have to be removed by an administrator.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+LeaderLock decides whether this copy of the collector is the leader. Acquire is called
+at the start of each ProcessPublications, so it must also renew the lock if it is
+already held. Release is called from EndConnection.
+*/
+type LeaderLock interface {
+	Acquire() (bool, error)
+	Release() error
+}
+
+// LeaderCallbacks is a LeaderLock made from functions, such as ones that use a Kubernetes lease
+type LeaderCallbacks struct {
+	AcquireFunc func() (bool, error)
+	ReleaseFunc func() error // Optional
+}
+
+func (l LeaderCallbacks) Acquire() (bool, error) {
+	return l.AcquireFunc()
+}
+
+func (l LeaderCallbacks) Release() error {
+	if l.ReleaseFunc == nil {
+		return nil
+	}
+	return l.ReleaseFunc()
+}
+
+/*
+FileLeaderLock is held by the copy whose owner name is in the file. The owner refreshes
+the file's modification time on every Acquire, and another copy takes over if the file
+has not been refreshed for the TTL. The TTL must be longer than the collection interval.
+
+Every change to the lock file is made while holding a second file, the lock path with
+".takeover" added, which is created with O_EXCL so that only one copy can hold it. Two
+copies that both see an expired lock cannot then both take it over. If a copy stops while
+holding the takeover file, the file is removed once it is older than the TTL.
+*/
+type FileLeaderLock struct {
+	Path  string
+	Owner string // Unique for each copy, such as the hostname and process id
+	TTL   time.Duration
+}
+
+/*
+NewFileLeaderLock returns a lock using the named file. The owner defaults to the
+hostname and process id.
+*/
+func NewFileLeaderLock(path string, owner string, ttl time.Duration) *FileLeaderLock {
+	if owner == "" {
+		host, _ := os.Hostname()
+		owner = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return &FileLeaderLock{Path: path, Owner: owner, TTL: ttl}
+}
+
+func (l *FileLeaderLock) Acquire() (bool, error) {
+	// Another copy holding a current lock is the usual case for a standby, and
+	// does not need the takeover file
+	owner, expired, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	if owner != "" && owner != l.Owner && !expired {
+		return false, nil
+	}
+
+	held, err := l.lockTakeover()
+	if !held || err != nil {
+		return false, err
+	}
+	defer os.Remove(l.takeoverPath())
+
+	// Look again, as the lock may have changed before the takeover file was created
+	owner, expired, err = l.read()
+	switch {
+	case err != nil:
+		return false, err
+	case owner == l.Owner:
+		now := time.Now()
+		return true, os.Chtimes(l.Path, now, now)
+	case owner != "" && !expired:
+		return false, nil
+	case owner != "":
+		logInfo("Leader lock %s held by %s has expired", l.Path, owner)
+		if err = os.Remove(l.Path); err != nil {
+			return false, err
+		}
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.WriteString(l.Owner + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(l.Path)
+		return false, err
+	}
+	return true, nil
+}
+
+// Release removes the lock file if this copy holds it, so another can take over at once
+func (l *FileLeaderLock) Release() error {
+	held, err := l.lockTakeover()
+	if !held || err != nil {
+		return err
+	}
+	defer os.Remove(l.takeoverPath())
+
+	owner, _, err := l.read()
+	if err != nil || owner != l.Owner {
+		return err
+	}
+	return os.Remove(l.Path)
+}
+
+// Return the owner of the lock, or "" if there is no lock file, and whether it has expired
+func (l *FileLeaderLock) read() (string, bool, error) {
+	b, err := ioutil.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	fi, err := os.Stat(l.Path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(b)), time.Since(fi.ModTime()) >= l.TTL, nil
+}
+
+func (l *FileLeaderLock) takeoverPath() string {
+	return l.Path + ".takeover"
+}
+
+// Create the takeover file. It returns false if another copy has it.
+func (l *FileLeaderLock) lockTakeover() (bool, error) {
+	f, err := os.OpenFile(l.takeoverPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		f.Close()
+		return true, nil
+	}
+	if !os.IsExist(err) {
+		return false, err
+	}
+	// Left behind by a copy that stopped. It can be used again at the next attempt.
+	if fi, err := os.Stat(l.takeoverPath()); err == nil && time.Since(fi.ModTime()) >= l.TTL {
+		logInfo("Removing leader lock takeover file %s", l.takeoverPath())
+		os.Remove(l.takeoverPath())
+	}
+	return false, nil
+}
+
+/*
+IsLeader says whether this copy of the collector was the leader at the last collection.
+It is always true if there is no leader lock. Collectors can use it to decide whether to
+report the status metrics as well.
+*/
+func IsLeader() bool {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil {
+		return false
+	}
+	return ci.leader == nil || ci.isLeader
+}
+
+// Try to become or remain the leader. Errors from the lock mean that this copy is not the leader.
+func checkLeader(ci *connectionInfo) bool {
+	if ci.leader == nil {
+		return true
+	}
+
+	leader, err := ci.leader.Acquire()
+	if err != nil {
+		logError("Cannot check leader lock: %v", err)
+		leader = false
+	}
+	if leader != ci.isLeader {
+		if leader {
+			logInfo("This collector is now the leader")
+		} else {
+			logInfo("This collector is no longer the leader")
+		}
+	}
+	ci.isLeader = leader
+	return leader
+}
+
+func releaseLeader(ci *connectionInfo) {
+	if ci.leader != nil && ci.isLeader {
+		if err := ci.leader.Release(); err != nil {
+			logError("Cannot release leader lock: %v", err)
+		}
+		ci.isLeader = false
+	}
+}
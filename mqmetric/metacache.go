@@ -0,0 +1,223 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file saves the discovered metadata - the classes, types and elements that the
+queue manager publishes, with their descriptions - so that a collector that is restarted
+against the same queue manager does not need to subscribe to all of the METADATA topics
+again. Set the MetadataCacheFile in the DiscoverConfig to use it.
+
+The cache is only used if it was written for the same queue manager name, command level,
+locale, metadata prefix and STATQ subscription selector. Applying maintenance that adds new
+elements without changing the command level is not detected, so the file should be deleted
+after upgrading a queue manager. Any problem reading the file causes a normal discovery,
+and the file is then rewritten.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Change this if the format of the file changes, so that older files are ignored
+const metadataCacheVersion = 1
+
+type metadataCache struct {
+	Version      int
+	QMgrName     string
+	CommandLevel int32
+	Locale       string
+	MetaPrefix   string
+	Selector     string
+	Classes      []cachedClass
+}
+
+type cachedClass struct {
+	Index       int
+	Name        string
+	Description string
+	TypesTopic  string
+	Flags       int
+	Types       []cachedType
+}
+
+type cachedType struct {
+	Index        int
+	Name         string
+	Description  string
+	ObjectTopic  string
+	ElementTopic string
+	Elements     []cachedElement
+}
+
+type cachedElement struct {
+	Index          int
+	Description    string
+	DescriptionNLS string `json:",omitempty"`
+	Datatype       int32
+}
+
+func newMetadataCache(ci *connectionInfo, dc DiscoverConfig) *metadataCache {
+	return &metadataCache{
+		Version:      metadataCacheVersion,
+		QMgrName:     ci.si.resolvedQMgrName,
+		CommandLevel: ci.si.commandLevel,
+		Locale:       locale,
+		MetaPrefix:   dc.MetaPrefix,
+		Selector:     dc.MonitoredQueues.SubscriptionSelector,
+	}
+}
+
+// Does the cache file describe the queue manager we are connected to
+func (c *metadataCache) matches(key *metadataCache) bool {
+	return c.Version == key.Version &&
+		c.QMgrName == key.QMgrName &&
+		c.CommandLevel == key.CommandLevel &&
+		c.Locale == key.Locale &&
+		c.MetaPrefix == key.MetaPrefix &&
+		c.Selector == key.Selector &&
+		len(c.Classes) > 0
+}
+
+/*
+Fill in the metrics from the cache file, returning false if the file does not
+exist or does not match the current connection
+*/
+func loadMetadataCache(fileName string, key *metadataCache, metrics *AllMetrics) bool {
+	traceEntryF("loadMetadataCache", "File: %s", fileName)
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		traceExitErr("loadMetadataCache", 1, err)
+		return false
+	}
+
+	c := new(metadataCache)
+	if err = json.Unmarshal(b, c); err != nil {
+		logError("Ignoring metadata cache %s: %v", fileName, err)
+		traceExitErr("loadMetadataCache", 2, err)
+		return false
+	}
+	if !c.matches(key) {
+		logInfo("Metadata cache %s is for a different queue manager or configuration", fileName)
+		traceExit("loadMetadataCache", 3)
+		return false
+	}
+
+	for _, cc := range c.Classes {
+		cl := &MonClass{Parent: metrics,
+			Name:        cc.Name,
+			Description: cc.Description,
+			typesTopic:  cc.TypesTopic,
+			flags:       cc.Flags,
+			Types:       make(map[int]*MonType)}
+
+		for _, ct := range cc.Types {
+			ty := &MonType{Parent: cl,
+				Name:         ct.Name,
+				Description:  ct.Description,
+				ObjectTopic:  ct.ObjectTopic,
+				elementTopic: ct.ElementTopic,
+				Elements:     make(map[int]*MonElement),
+				subHobj:      make(map[string]*MQTopicDescriptor)}
+
+			for _, ce := range ct.Elements {
+				elem := &MonElement{Parent: ty,
+					Description:    ce.Description,
+					DescriptionNLS: ce.DescriptionNLS,
+					Datatype:       ce.Datatype,
+					Values:         make(map[string]int64)}
+				elem.MetricName = formatDescription(elem)
+				ty.Elements[ce.Index] = elem
+			}
+			cl.Types[ct.Index] = ty
+		}
+		metrics.Classes[cc.Index] = cl
+	}
+
+	logInfo("Using metadata from cache %s", fileName)
+	traceExit("loadMetadataCache", 0)
+	return true
+}
+
+/*
+Write the discovered metrics to the cache file. The file is written under a
+temporary name and then renamed, so a collector that is stopped part way through
+does not leave a damaged cache behind.
+*/
+func saveMetadataCache(fileName string, c *metadataCache, metrics *AllMetrics) error {
+	traceEntryF("saveMetadataCache", "File: %s", fileName)
+
+	for ci, cl := range metrics.Classes {
+		cc := cachedClass{Index: ci,
+			Name:        cl.Name,
+			Description: cl.Description,
+			TypesTopic:  cl.typesTopic,
+			Flags:       cl.flags}
+		for ti, ty := range cl.Types {
+			ct := cachedType{Index: ti,
+				Name:         ty.Name,
+				Description:  ty.Description,
+				ObjectTopic:  ty.ObjectTopic,
+				ElementTopic: ty.elementTopic}
+			for ei, elem := range ty.Elements {
+				ct.Elements = append(ct.Elements, cachedElement{Index: ei,
+					Description:    elem.Description,
+					DescriptionNLS: elem.DescriptionNLS,
+					Datatype:       elem.Datatype})
+			}
+			cc.Types = append(cc.Types, ct)
+		}
+		c.Classes = append(c.Classes, cc)
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		traceExitErr("saveMetadataCache", 1, err)
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*")
+	if err != nil {
+		traceExitErr("saveMetadataCache", 2, err)
+		return err
+	}
+	_, err = f.Write(b)
+	if e2 := f.Close(); err == nil {
+		err = e2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	traceExitErr("saveMetadataCache", 0, err)
+	return err
+}
+
+// The metadata is only worth caching when it came from a real queue manager
+func useMetadataCache(ci *connectionInfo, dc DiscoverConfig) bool {
+	return dc.MetadataCacheFile != "" && ci.replay == nil
+}
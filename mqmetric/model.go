@@ -0,0 +1,236 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file defines a stable form of everything that this package collects, for
+exporters that should not depend on the internal structures such as MonType or
+StatusSet. Those can change as the package develops; the types here only change in
+ways that existing programs can ignore, such as new fields. An incompatible change
+would increase ModelVersion. The same structure is described by model.schema.json,
+for programs that read the JSON form.
+
+GetModel converts the most recent values for the current connection. Call it after
+ProcessPublications and the Collect*Status functions.
+*/
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelVersion is the version of the ModelSnapshot structure
+const ModelVersion = 1
+
+// Sources of the metrics in a ModelSnapshot
+const (
+	MODEL_SOURCE_PUBLICATION = "publication"
+	MODEL_SOURCE_STATUS      = "status"
+)
+
+// ModelSnapshot holds all of the current metrics for a queue manager
+type ModelSnapshot struct {
+	Version  int           `json:"version"`
+	QMgrName string        `json:"queueManager"`
+	Time     time.Time     `json:"time"`
+	Metrics  []ModelMetric `json:"metrics"`
+}
+
+/*
+ModelMetric is one metric with its values for each object. The Class and Type are the
+resource publication class and type, such as "CPU" and "SystemSummary", for published
+metrics. For status metrics the Class is the object type and the Type is empty.
+*/
+type ModelMetric struct {
+	Name        string       `json:"name"`
+	Source      string       `json:"source"`
+	Class       string       `json:"class"`
+	Type        string       `json:"type,omitempty"`
+	ObjectType  string       `json:"objectType"`
+	Description string       `json:"description"`
+	Values      []ModelValue `json:"values"`
+}
+
+/*
+ModelValue is the value for one object. The Value has been normalised to base units,
+and had any transforms applied, in the same way as by Normalise. A few status metrics
+are strings, held in Text instead. The QMgr is only set in the merged snapshot
+from a Fleet, to say which queue manager the value came from.
+*/
+type ModelValue struct {
+	Object string  `json:"object"`
+	QMgr   string  `json:"qmgr,omitempty"`
+	Value  float64 `json:"value"`
+	Text   string  `json:"text,omitempty"`
+}
+
+// The names used for the ObjectType of status metrics
+var modelObjectTypes = map[int]string{
+	OT_Q_MGR:         "qmgr",
+	OT_Q:             "queue",
+	OT_CHANNEL:       "channel",
+	OT_CHANNEL_AMQP:  "channel_amqp",
+	OT_TOPIC:         "topic",
+	OT_SUB:           "subscription",
+	OT_CLUSTER:       "cluster",
+	OT_CLUSTER_XMITQ: "cluster_xmitq",
+	OT_MQIPT:         "mqipt",
+	OT_AUTH:          "auth",
+	OT_APPLIANCE_HA:  "applianceha",
+	OT_NHA:           "nha",
+	OT_BP:            "bufferpool",
+	OT_PS:            "pageset",
+}
+
+/*
+GetModel returns the current metrics for the current connection. It returns nil if
+there is no connection.
+*/
+func GetModel() *ModelSnapshot {
+	traceEntry("GetModel")
+
+	k := GetConnectionKey()
+	ci := getConnection(k)
+	if ci == nil {
+		traceExit("GetModel", 1)
+		return nil
+	}
+
+	m := &ModelSnapshot{Version: ModelVersion,
+		QMgrName: ci.si.resolvedQMgrName,
+		Time:     time.Now()}
+
+	m.Metrics = append(m.Metrics, modelPublished(GetPublishedMetrics(k))...)
+	for ot, name := range modelObjectTypes {
+		if ci.objectStatus[ot].init {
+			m.Metrics = append(m.Metrics, modelStatus(name, GetObjectStatus(k, ot))...)
+		}
+	}
+
+	sortModelMetrics(m.Metrics)
+
+	traceExit("GetModel", 0)
+	return m
+}
+
+func sortModelMetrics(l []ModelMetric) {
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+}
+
+func modelPublished(metrics *AllMetrics) []ModelMetric {
+	var l []ModelMetric
+	if metrics == nil {
+		return l
+	}
+	aliasing := objectAliasing()
+
+	for _, cl := range metrics.Classes {
+		for _, ty := range cl.Types {
+			objectType := "qmgr"
+			if strings.Contains(ty.ObjectTopic, "%s") {
+				switch cl.Name {
+				case "NHAREPLICA":
+					objectType = "nha"
+				default:
+					objectType = "queue"
+				}
+			}
+
+			for _, elem := range ty.Elements {
+				mm := ModelMetric{Name: elem.MetricName,
+					Source:      MODEL_SOURCE_PUBLICATION,
+					Class:       cl.Name,
+					Type:        ty.Name,
+					ObjectType:  objectType,
+					Description: elem.Description}
+				elem.Range(func(key string, value int64) {
+					object := key
+					if key == QMgrMapKey {
+						object = ""
+					} else if strings.HasPrefix(key, NativeHAKeyPrefix) {
+						object = key[len(NativeHAKeyPrefix):]
+					}
+					if aliasing {
+						object = AliasObjectName(object)
+					}
+					mm.Values = append(mm.Values, ModelValue{Object: object, Value: Normalise(elem, key, value)})
+				})
+				sortModelValues(mm.Values)
+				l = append(l, mm)
+			}
+		}
+	}
+	return l
+}
+
+func modelStatus(objectType string, st *StatusSet) []ModelMetric {
+	var l []ModelMetric
+	if st == nil {
+		return l
+	}
+	aliasing := objectAliasing()
+
+	for _, attr := range st.Attributes {
+		mm := ModelMetric{Name: attr.MetricName,
+			Source:      MODEL_SOURCE_STATUS,
+			Class:       objectType,
+			ObjectType:  objectType,
+			Description: attr.Description}
+		for key, v := range attr.Values {
+			mv := ModelValue{Object: key}
+			switch {
+			case v.IsInt64:
+				mv.Value = statusNormalise(attr, v.ValueInt64)
+			case v.IsFloat64:
+				mv.Value = v.ValueFloat64
+			default:
+				mv.Text = v.ValueString
+			}
+			// The pseudo attributes are the names and other labels for the object
+			if aliasing {
+				mv.Object = AliasObjectName(mv.Object)
+				if attr.Pseudo {
+					mv.Text = AliasObjectName(mv.Text)
+				}
+			}
+			mm.Values = append(mm.Values, mv)
+		}
+		sortModelValues(mm.Values)
+		l = append(l, mm)
+	}
+	return l
+}
+
+func sortModelValues(l []ModelValue) {
+	sort.Slice(l, func(i, j int) bool { return l[i].Object < l[j].Object })
+}
@@ -26,6 +26,7 @@ don't need to repeat common setups eg of MQMD or MQSD structures.
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
 )
@@ -34,6 +35,9 @@ var (
 	getBuffer = make([]byte, 32768)
 )
 
+// How many publications to read in each call to GetBatch
+const pubBatchSize = 32
+
 type ConnectionConfig struct {
 	ClientMode    bool
 	UserId        string
@@ -47,13 +51,95 @@ type ConnectionConfig struct {
 	ShowInactiveChannels bool
 	HideSvrConnJobname   bool
 	HideAMQPClientId     bool
-	WaitInterval         int
+	WaitInterval         int // Seconds to wait for command responses
+
+	// Waits for the other MQGETs, and whether to shorten the waits while nothing
+	// is arriving. See waits.go
+	MetadataWaitInterval    int // Seconds
+	PublicationWaitInterval int // Milliseconds
+	AdaptiveWait            bool
+
+	// Keep publications for an interval that is still arriving until the next
+	// collection, so that each one has whole intervals. See pubwindow.go
+	AlignPublications bool
+
+	// Pace the status commands: the most per second (0 is unlimited), and the
+	// largest random delay in milliseconds at the start of a collection. See ratelimit.go
+	CommandRate   float64
+	CommandJitter int
+
+	// Stop sending status commands for CommandCoolDown seconds after this many in
+	// a row have had no reply. 0 never stops them. See breaker.go
+	CommandTimeoutLimit int
+	CommandCoolDown     int
+
+	// Share the published metrics between several copies of the collector, with
+	// only the holder of this lock reading them. Needs DurableSubPrefix. See leader.go
+	LeaderLock LeaderLock
+
+	// Queue patterns where the oldest message age is found by browsing the first
+	// message, if the queue manager does not report it. See msgage.go
+	BrowseMsgAgeQueues string
+
+	// Combine the status of all instances of a channel into one entry
+	// for each channel name. Can be NONE (the default), SUM or MAX.
+	ChannelAggregation string
 
 	CcdtUrl  string
 	ConnName string
 	Channel  string
 
+	// A list of endpoints can be used instead of the ConnName. They are tried
+	// in the order given, or in a random order if the policy is ENDPOINT_RANDOM.
+	Endpoints      []Endpoint
+	EndpointPolicy string
+
 	DurableSubPrefix string
+	// Use a single wildcard subscription for each class of queue manager
+	// metrics, instead of one for each type. This reduces the number
+	// of subscriptions, but publications for types that have not been
+	// discovered are then read and ignored.
+	UseWildcardSubscriptions bool
+
+	// Subscriptions used to discover the available metrics normally send the
+	// metadata to a managed queue. If model queues cannot be used, a predefined
+	// queue can be named here instead.
+	MetadataQueue string
+	// How long a subscription should last, in seconds, if the collector
+	// does not remove it. Mostly useful with durable subscriptions. 0 means unlimited.
+	SubExpiry int32
+	// How often, in seconds, to check the connection between collections. 0 means never.
+	HeartbeatInterval int
+
+	// Monitor a different queue manager by sending the commands through the
+	// connected one. The command queue on that queue manager can also be
+	// given; the default is SYSTEM.ADMIN.COMMAND.QUEUE.
+	TargetQMgr         string
+	TargetCommandQueue string
+
+	// Tuning for the client channel when it is built from the ConnName/Channel or
+	// Endpoints. Compression is a list in order of preference such as "ZLIBFAST,NONE".
+	// ChannelHeartbeat is in seconds; BatchHeartbeat and BatchInterval are in
+	// milliseconds. 0 leaves the channel default.
+	HeaderCompression  string
+	MessageCompression string
+	ChannelHeartbeat   int
+	BatchHeartbeat     int
+	BatchInterval      int
+
+	// How a local connection is made: STANDARD, SHARED, ISOLATED or FASTPATH. Empty
+	// leaves the choice to the queue manager. See bindings.go before using FASTPATH.
+	Bindings string
+
+	// Monitored queues that have these settings OFF are changed so that their data is
+	// produced. A list such as "MONQ,STATQ". Empty means only a warning is logged.
+	// See qmonitoring.go
+	ManageMonitoring string
+
+	// An existing connection to use instead of connecting with the settings above.
+	// This can be the in-memory queue manager from the ibmmq package, for testing.
+	// EndConnection disconnects it.
+	Connection ibmmq.QMgrConnection
 }
 
 // Which objects are available for subscription. How
@@ -64,6 +150,7 @@ type DiscoverObject struct {
 	ObjectNames          string
 	UseWildcard          bool
 	SubscriptionSelector string
+	StaleMarkers         bool // Leave a final zero value for objects removed by rediscovery
 }
 
 // For now, only queues are subscribable through this interface
@@ -72,6 +159,14 @@ type DiscoverObject struct {
 type DiscoverConfig struct {
 	MetaPrefix      string // Root of all meta-data discovery
 	MonitoredQueues DiscoverObject
+	// Patterns for published elements to keep or remove. See elementfilter.go
+	ElementAllowList string
+	ElementDenyList  string
+	// Store the published values in arrays instead of the Values maps, to save
+	// memory with large numbers of queues. See columnar.go
+	ColumnarValues bool
+	// Save the discovered metadata here, and reuse it after a restart. See metacache.go
+	MetadataCacheFile string
 }
 
 type MQMetricError struct {
@@ -80,10 +175,11 @@ type MQMetricError struct {
 }
 
 type MQTopicDescriptor struct {
-	hObj    ibmmq.MQObject
-	topic   string
-	durable bool
-	managed bool
+	hObj     ibmmq.Object
+	topic    string
+	durable  bool
+	managed  bool
+	correlId []byte
 }
 
 func (e MQMetricError) Error() string { return e.Err + " : " + e.MQReturn.Error() }
@@ -102,7 +198,6 @@ func InitConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 }
 func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 string, cc *ConnectionConfig) error {
 	var err error
-	var gocd *ibmmq.MQCD
 	var mqreturn *ibmmq.MQReturn
 	var errorString = ""
 
@@ -110,65 +205,57 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 
 	initConnection(key)
 
-	gocno := ibmmq.NewMQCNO()
-	gocsp := ibmmq.NewMQCSP()
-
 	// Copy initialisation configuraton information to local structure
 	ci := getConnection(GetConnectionKey())
 
-	ci.tzOffsetSecs = cc.TZOffsetSecs
-	ci.showInactiveChannels = cc.ShowInactiveChannels
-	ci.hideSvrConnJobname = cc.HideSvrConnJobname
-	ci.hideAMQPClientId = cc.HideAMQPClientId
+	// Check any client channel tuning before trying to connect
+	if err = checkChannelTuning(cc); err != nil {
+		mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CD_ERROR}
+		traceExitErr("initConnectionKey", 2, mqreturn)
+		return MQMetricError{Err: err.Error(), MQReturn: mqreturn}
+	}
 
-	ci.durableSubPrefix = cc.DurableSubPrefix
+	if ci.chlAggregation, err = parseChannelAggregation(cc.ChannelAggregation); err != nil {
+		mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_UNEXPECTED_ERROR}
+		traceExitErr("initConnectionKey", 3, mqreturn)
+		return MQMetricError{Err: err.Error(), MQReturn: mqreturn}
+	}
 
-	// Explicitly force client mode if requested. Otherwise use the "default"
-	// Client mode can be come from a simple boolean, or from having
-	// common configurations with the CCDT or ConnName/Channel being set.
-	if cc.CcdtUrl != "" {
-		cc.ClientMode = true
-	} else if cc.ConnName != "" || cc.Channel != "" {
-		cc.ClientMode = true
-		gocd = ibmmq.NewMQCD()
-		gocd.ChannelName = cc.Channel
-		gocd.ConnectionName = cc.ConnName
+	if cc.LeaderLock != nil && cc.DurableSubPrefix == "" {
+		mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_OPTIONS_ERROR}
+		traceExitErr("initConnectionKey", 5, mqreturn)
+		return MQMetricError{Err: "A leader lock needs durable subscriptions", MQReturn: mqreturn}
 	}
 
-	// connection mechanism depending on what is installed or configured.
-	if cc.ClientMode {
-		gocno.Options = ibmmq.MQCNO_CLIENT_BINDING
-		// Force reconnection to only be to the same qmgr. Cannot do this with externally
-		// configured (eg MQ_CONNECT_TYPE or client-only installation) connections. But
-		// it is a bad idea to try to reconnect to a different queue manager.
-		// If the collector is managing its own reconnect, then don't use the MQ automatic mode
-		if cc.SingleConnect {
-			gocno.Options |= ibmmq.MQCNO_RECONNECT_DISABLED
-		} else {
-			gocno.Options |= ibmmq.MQCNO_RECONNECT_Q_MGR
-		}
-		if cc.CcdtUrl != "" {
-			gocno.CCDTUrl = cc.CcdtUrl
-			logInfo("Trying to connect as client using CCDT: %s", gocno.CCDTUrl)
-		} else if gocd != nil {
-			gocno.ClientConn = gocd
-			logInfo("Trying to connect as client using ConnName: %s, Channel: %s", gocd.ConnectionName, gocd.ChannelName)
-		} else {
-			logInfo("Trying to connect as client with external configuration")
-		}
+	if ci.manageMonitoring, err = parseManageMonitoring(cc.ManageMonitoring); err != nil {
+		mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_OPTIONS_ERROR}
+		traceExitErr("initConnectionKey", 6, mqreturn)
+		return MQMetricError{Err: err.Error(), MQReturn: mqreturn}
 	}
-	gocno.Options |= ibmmq.MQCNO_HANDLE_SHARE_BLOCK
 
-	if cc.Password != "" {
-		gocsp.Password = cc.Password
+	if _, err = checkBindings(cc); err != nil {
+		mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_OPTIONS_ERROR}
+		traceExitErr("initConnectionKey", 4, mqreturn)
+		return MQMetricError{Err: err.Error(), MQReturn: mqreturn}
 	}
-	if cc.UserId != "" {
-		gocsp.UserId = cc.UserId
-		gocno.SecurityParms = gocsp
+
+	ci.tzOffsetSecs = cc.TZOffsetSecs
+	ci.showInactiveChannels = cc.ShowInactiveChannels
+	ci.hideSvrConnJobname = cc.HideSvrConnJobname
+	ci.hideAMQPClientId = cc.HideAMQPClientId
+	ci.limiter = newCommandLimiter(cc.CommandRate, cc.CommandJitter)
+	ci.cmdBreaker = newCommandBreaker(cc.CommandTimeoutLimit, cc.CommandCoolDown)
+	ci.browseMsgAgeQueues = cc.BrowseMsgAgeQueues
+	if cc.AlignPublications {
+		ci.pubWindow = new(pubWindow)
 	}
 
-	logDebug("Connecting to queue manager %s", qMgrName)
-	ci.si.qMgr, err = ibmmq.Connx(qMgrName, gocno)
+	ci.durableSubPrefix = cc.DurableSubPrefix
+	ci.useWildcardSubs = cc.UseWildcardSubscriptions
+	ci.subExpiry = cc.SubExpiry
+	ci.leader = cc.LeaderLock
+
+	ci.si.qMgr, err = connectQMgr(qMgrName, cc)
 	if err == nil {
 		ci.si.qmgrConnected = true
 	} else {
@@ -183,7 +270,7 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 		var v map[int32]interface{}
 
 		ci.useStatus = cc.UseStatus
-		ci.waitInterval = cc.WaitInterval
+		setWaitIntervals(ci, cc)
 
 		mqod := ibmmq.NewMQOD()
 		openOptions := ibmmq.MQOO_INQUIRE + ibmmq.MQOO_FAIL_IF_QUIESCING
@@ -200,7 +287,7 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 				ibmmq.MQIA_MAX_HANDLES,
 				ibmmq.MQIA_PLATFORM}
 
-			v, err = ci.si.qMgrObject.InqMap(selectors)
+			v, err = ci.si.qMgrObject.Inq(selectors)
 			if err == nil {
 				ci.si.resolvedQMgrName = v[ibmmq.MQCA_Q_MGR_NAME].(string)
 				ci.si.platform = v[ibmmq.MQIA_PLATFORM].(int32)
@@ -242,7 +329,13 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 
 		mqod.ObjectType = ibmmq.MQOT_Q
 		mqod.ObjectName = "SYSTEM.ADMIN.COMMAND.QUEUE"
-		if ci.si.platform == ibmmq.MQPL_ZOS {
+		if cc.TargetQMgr != "" {
+			// The platform of the target is not known yet
+			mqod.ObjectQMgrName = cc.TargetQMgr
+			if cc.TargetCommandQueue != "" {
+				mqod.ObjectName = cc.TargetCommandQueue
+			}
+		} else if ci.si.platform == ibmmq.MQPL_ZOS {
 			mqod.ObjectName = "SYSTEM.COMMAND.INPUT"
 		}
 
@@ -292,8 +385,38 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 		}
 	}
 
+	// MQOPEN of an optional predefined queue for the metadata publications
+	if err == nil && cc.MetadataQueue != "" {
+		mqod := ibmmq.NewMQOD()
+		openOptions := ibmmq.MQOO_INPUT_EXCLUSIVE | ibmmq.MQOO_FAIL_IF_QUIESCING
+		mqod.ObjectType = ibmmq.MQOT_Q
+		mqod.ObjectName = cc.MetadataQueue
+		ci.si.metadataQObj, err = ci.si.qMgr.Open(mqod, openOptions)
+		if err != nil {
+			errorString = "Cannot open queue " + mqod.ObjectName
+			mqreturn = err.(*ibmmq.MQReturn)
+		} else {
+			ci.si.metadataQBaseName = cc.MetadataQueue
+			clearQ(ci.si.metadataQObj)
+		}
+	}
+
+	// When going through a gateway, the information about the queue manager
+	// has to come from the target instead of the one we are connected to
+	if err == nil && cc.TargetQMgr != "" {
+		ci.si.targetQMgrName = cc.TargetQMgr
+		err = initTargetQMgr(ci, cc)
+		if err != nil {
+			errorString = "Cannot inquire target queue manager " + cc.TargetQMgr
+			if mqe, ok := err.(*ibmmq.MQReturn); ok {
+				mqreturn = mqe
+			}
+		}
+	}
+
 	// Start from a clean set of subscriptions. Errors from this can be ignored.
-	if err == nil && ci.durableSubPrefix != "" && ci.usePublications {
+	// Shared subscriptions might be in use by the leader, so are left alone.
+	if err == nil && ci.durableSubPrefix != "" && ci.usePublications && ci.leader == nil {
 		clearDurableSubscriptions(ci.durableSubPrefix, ci.si.cmdQObj, ci.si.statusReplyQObj)
 	}
 
@@ -301,16 +424,118 @@ func initConnectionKey(key string, qMgrName string, replyQ string, replyQ2 strin
 		if mqreturn == nil {
 			mqreturn = &ibmmq.MQReturn{MQCC: ibmmq.MQCC_WARNING, MQRC: ibmmq.MQRC_ENVIRONMENT_ERROR}
 		}
+		if ci.si.qmgrConnected {
+			recordAuthFailure(AUTH_OP_OPEN, mqreturn)
+		} else {
+			recordAuthFailure(AUTH_OP_CONNECT, mqreturn)
+		}
 		traceExitErr("initConnectionKey", 1, mqreturn)
 		return MQMetricError{Err: errorString, MQReturn: mqreturn}
 	}
 
+	ci.checkConnection = cc.HeartbeatInterval > 0
+	startHeartbeat(ci, cc.HeartbeatInterval)
+
 	logTrace("initConnectionKey: Queue manager resolved info - %+v", ci /*.si*/)
 	traceExitErr("initConnectionKey", 0, mqreturn)
 
 	return err
 }
 
+/*
+Message handles can only be created on a real connection, not on an alternative
+implementation of the interface such as the in-memory queue manager
+*/
+func crtMH(qMgr ibmmq.QMgrConnection) (ibmmq.MQMessageHandle, error) {
+	creator, ok := qMgr.(ibmmq.MessageHandleCreator)
+	if !ok {
+		return ibmmq.MQMessageHandle{}, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_FUNCTION_NOT_SUPPORTED}
+	}
+	return creator.CrtMH(ibmmq.NewMQCMHO())
+}
+
+/*
+Build the connection options from the configuration and connect. This does not touch
+the connectionInfo, so it can also be used for short-lived connections such as Probe.
+*/
+func connectQMgr(qMgrName string, cc *ConnectionConfig) (ibmmq.QMgrConnection, error) {
+	var gocd *ibmmq.MQCD
+
+	if cc.Connection != nil {
+		logDebug("Using the supplied connection for queue manager %s", qMgrName)
+		return cc.Connection, nil
+	}
+
+	gocno := ibmmq.NewMQCNO()
+	gocsp := ibmmq.NewMQCSP()
+
+	// Explicitly force client mode if requested. Otherwise use the "default"
+	// Client mode can be come from a simple boolean, or from having
+	// common configurations with the CCDT or ConnName/Channel being set.
+	if cc.CcdtUrl != "" || len(cc.Endpoints) > 0 {
+		cc.ClientMode = true
+	} else if cc.ConnName != "" || cc.Channel != "" {
+		cc.ClientMode = true
+		gocd = ibmmq.NewMQCD()
+		gocd.ChannelName = cc.Channel
+		gocd.ConnectionName = cc.ConnName
+		applyChannelTuning(gocd, cc)
+	}
+
+	// connection mechanism depending on what is installed or configured.
+	if cc.ClientMode {
+		gocno.Options = ibmmq.MQCNO_CLIENT_BINDING
+		// Force reconnection to only be to the same qmgr. Cannot do this with externally
+		// configured (eg MQ_CONNECT_TYPE or client-only installation) connections. But
+		// it is a bad idea to try to reconnect to a different queue manager.
+		// If the collector is managing its own reconnect, then don't use the MQ automatic mode
+		if cc.SingleConnect {
+			gocno.Options |= ibmmq.MQCNO_RECONNECT_DISABLED
+		} else {
+			gocno.Options |= ibmmq.MQCNO_RECONNECT_Q_MGR
+		}
+		if cc.CcdtUrl != "" {
+			gocno.CCDTUrl = cc.CcdtUrl
+			logInfo("Trying to connect as client using CCDT: %s", gocno.CCDTUrl)
+		} else if gocd != nil {
+			gocno.ClientConn = gocd
+			logInfo("Trying to connect as client using ConnName: %s, Channel: %s", gocd.ConnectionName, gocd.ChannelName)
+		} else if len(cc.Endpoints) > 0 {
+			logInfo("Trying to connect as client using %d endpoints", len(cc.Endpoints))
+		} else {
+			logInfo("Trying to connect as client with external configuration")
+		}
+	}
+	gocno.Options |= ibmmq.MQCNO_HANDLE_SHARE_BLOCK
+	if !cc.ClientMode {
+		// The bindings have already been checked
+		if b, err := checkBindings(cc); err == nil {
+			gocno.Options |= b
+		}
+	}
+
+	if cc.Password != "" {
+		gocsp.Password = cc.Password
+	}
+	if cc.UserId != "" {
+		gocsp.UserId = cc.UserId
+		gocno.SecurityParms = gocsp
+	}
+
+	logDebug("Connecting to queue manager %s", qMgrName)
+	var qMgr ibmmq.MQQueueManager
+	var err error
+	if cc.CcdtUrl == "" && len(cc.Endpoints) > 0 {
+		qMgr, err = connectEndpoints(qMgrName, gocno, cc)
+	} else {
+		qMgr, err = ibmmq.Connx(qMgrName, gocno)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ibmmq.NewQMgrConnection(&qMgr), nil
+}
+
 /*
 EndConnection tidies up by closing the queues and disconnecting.
 */
@@ -322,25 +547,37 @@ func EndConnection() {
 		traceExit("EndConnection", 1)
 		return
 	}
+	stopHeartbeat(ci)
+	StopArchive()
+	releaseLeader(ci)
+
 	m := GetPublishedMetrics(GetConnectionKey())
-	// MQCLOSE all subscriptions
-	if ci.si.subsOpened {
+	// MQCLOSE all subscriptions, unless they are shared with other copies of the collector
+	if ci.si.subsOpened && ci.leader == nil {
 		for _, cl := range m.Classes {
 			for _, ty := range cl.Types {
 				for _, hObj := range ty.subHobj {
 					hObj.unsubscribe()
 				}
 			}
+			if cl.subHobj != nil {
+				cl.subHobj.unsubscribe()
+			}
 		}
 	}
 
 	// MQCLOSE the queues
 	if ci.si.queuesOpened {
-		ci.si.cmdQObj.Close(0)
-		ci.si.replyQObj.Close(0)
-		ci.si.statusReplyQObj.Close(0)
-		ci.si.qMgrObject.Close(0)
+		closeObject(ci.si.cmdQObj)
+		closeObject(ci.si.replyQObj)
+		closeObject(ci.si.statusReplyQObj)
+		closeObject(ci.si.qMgrObject)
+		if ci.si.metadataQBaseName != "" {
+			closeObject(ci.si.metadataQObj)
+		}
 	}
+	closeCommandEventQueue(ci)
+	closeAuthEventQueue(ci)
 
 	// MQDISC regardless of other errors
 	if ci.si.qmgrConnected {
@@ -350,10 +587,17 @@ func EndConnection() {
 	traceExit("EndConnection", 0)
 }
 
+// Objects that failed to open are nil
+func closeObject(o ibmmq.Object) {
+	if o != nil {
+		o.Close(0)
+	}
+}
+
 /*
 getMessage returns a message from the replyQ. The "wait"
 parameter to the function says whether this should block
-for 30 seconds or return immediately if there is no message
+for the metadata wait interval or return immediately if there is no message
 available. When working with the command queue, blocking is
 required; when getting publications, non-blocking is better.
 
@@ -368,11 +612,17 @@ func getMessage(ci *connectionInfo, wait bool) ([]byte, error) {
 	return rc, err
 }
 
-func getMessageWithHObj(wait bool, hObj ibmmq.MQObject) ([]byte, error) {
+func getMessageWithHObj(wait bool, hObj ibmmq.Object) ([]byte, error) {
+	return getMessageWithCorrelId(wait, hObj, nil)
+}
+
+// When several subscriptions share a queue, the CorrelId set by the
+// queue manager tells us which subscription a publication belongs to.
+func getMessageWithCorrelId(wait bool, hObj ibmmq.Object, correlId []byte) ([]byte, error) {
 	var err error
 	var datalen int
 
-	traceEntry("getMessageWithHObj")
+	traceEntry("getMessageWithCorrelId")
 	getmqmd := ibmmq.NewMQMD()
 	gmo := ibmmq.NewMQGMO()
 	gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT
@@ -380,30 +630,83 @@ func getMessageWithHObj(wait bool, hObj ibmmq.MQObject) ([]byte, error) {
 	gmo.Options |= ibmmq.MQGMO_CONVERT
 
 	gmo.MatchOptions = ibmmq.MQMO_NONE
+	if correlId != nil {
+		gmo.MatchOptions = ibmmq.MQMO_MATCH_CORREL_ID
+		copy(getmqmd.CorrelId, correlId)
+	}
 
 	if wait {
+		metaWait := defaultMetadataWait
+		if ci := getConnection(GetConnectionKey()); ci != nil && ci.metaWait > 0 {
+			metaWait = ci.metaWait
+		}
 		gmo.Options |= ibmmq.MQGMO_WAIT
-		gmo.WaitInterval = 30 * 1000
+		gmo.WaitInterval = int32(metaWait / time.Millisecond)
 	}
 
 	datalen, err = hObj.Get(getmqmd, gmo, getBuffer)
+	if err != nil {
+		recordAuthFailure(AUTH_OP_GET, err)
+	}
 
-	traceExitErr("getMessageWithHObj", 0, err)
+	traceExitErr("getMessageWithCorrelId", 0, err)
 
 	return getBuffer[0:datalen], err
 }
 
+// Read several publications from the reply queue at once, without waiting. The
+// data refers to the connection's batch buffer, so must be used before the next call.
+func getMessageBatch(ci *connectionInfo) ([]ibmmq.BatchMessage, error) {
+	traceEntry("getMessageBatch")
+
+	if ci.pubBuffer == nil {
+		ci.pubBuffer = make([]byte, pubBatchSize*len(getBuffer))
+	}
+	gmo := ibmmq.NewMQGMO()
+	gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT
+	gmo.Options |= ibmmq.MQGMO_FAIL_IF_QUIESCING
+	gmo.Options |= ibmmq.MQGMO_CONVERT
+	gmo.MatchOptions = ibmmq.MQMO_NONE
+
+	// Only the first read in each collection waits
+	var wait time.Duration
+	if ci.pubWaitDue {
+		wait = ci.pubWait.interval()
+		ci.pubWaitDue = false
+	}
+	msgs, err := ci.si.replyQObj.GetBatch(pubBatchSize, wait, gmo, ci.pubBuffer)
+
+	// A publication that does not fit in the whole buffer is left on the queue, so
+	// read it again with a bigger buffer
+	for len(msgs) == 0 && len(ci.pubBuffer) < maxBufSize {
+		mqreturn, ok := err.(*ibmmq.MQReturn)
+		if !ok || mqreturn.MQRC != ibmmq.MQRC_TRUNCATED_MSG_FAILED {
+			break
+		}
+		ci.pubBuffer = make([]byte, len(ci.pubBuffer)*2)
+		logDebug("Publication batch buffer increased to %d bytes", len(ci.pubBuffer))
+		msgs, err = ci.si.replyQObj.GetBatch(pubBatchSize, 0, gmo, ci.pubBuffer)
+	}
+
+	if wait > 0 {
+		ci.pubWait.result(len(msgs) > 0)
+	}
+
+	traceExitErr("getMessageBatch", 0, err)
+	return msgs, err
+}
+
 /*
 subscribe to the nominated topic. The previously-opened
 replyQ is used for publications; we do not use a managed queue here,
 so that everything can be read from one queue. The object handle for the
 subscription is returned so we can close it when it's no longer needed.
 */
-func subscribe(topic string, pubQObj *ibmmq.MQObject) (*MQTopicDescriptor, error) {
+func subscribe(topic string, pubQObj *ibmmq.Object) (*MQTopicDescriptor, error) {
 	return subscribeWithOptions(topic, pubQObj, false, false)
 }
 
-func subscribeDurable(topic string, pubQObj *ibmmq.MQObject) (*MQTopicDescriptor, error) {
+func subscribeDurable(topic string, pubQObj *ibmmq.Object) (*MQTopicDescriptor, error) {
 	return subscribeWithOptions(topic, pubQObj, false, true)
 }
 
@@ -411,11 +714,23 @@ func subscribeDurable(topic string, pubQObj *ibmmq.MQObject) (*MQTopicDescriptor
 subscribe to the nominated topic, but ask the queue manager to
 allocate the replyQ for us
 */
-func subscribeManaged(topic string, pubQObj *ibmmq.MQObject) (*MQTopicDescriptor, error) {
+func subscribeManaged(topic string, pubQObj *ibmmq.Object) (*MQTopicDescriptor, error) {
 	return subscribeWithOptions(topic, pubQObj, true, false)
 }
 
-func subscribeWithOptions(topic string, pubQObj *ibmmq.MQObject, managed bool, durable bool) (*MQTopicDescriptor, error) {
+func subscribeWithOptions(topic string, pubQObj *ibmmq.Object, managed bool, durable bool) (*MQTopicDescriptor, error) {
+	return subscribeWithSubOptions(topic, pubQObj, managed, durable, 0)
+}
+
+/*
+subscribe to a topic string containing wildcards, using the topic-based
+scheme where "#" and "+" match complete levels of the topic tree.
+*/
+func subscribeWildcard(topic string, pubQObj *ibmmq.Object) (*MQTopicDescriptor, error) {
+	return subscribeWithSubOptions(topic, pubQObj, false, false, ibmmq.MQSO_WILDCARD_TOPIC)
+}
+
+func subscribeWithSubOptions(topic string, pubQObj *ibmmq.Object, managed bool, durable bool, extraOptions int32) (*MQTopicDescriptor, error) {
 	var err error
 
 	traceEntry("subscribeWithOptions")
@@ -433,14 +748,19 @@ func subscribeWithOptions(topic string, pubQObj *ibmmq.MQObject, managed bool, d
 		mqsd.Options |= ibmmq.MQSO_NON_DURABLE
 	}
 	mqsd.Options |= ibmmq.MQSO_FAIL_IF_QUIESCING
+	mqsd.Options |= extraOptions
 	if managed {
 		mqsd.Options |= ibmmq.MQSO_MANAGED
 	}
 
 	mqsd.ObjectString = topic
+	if ci.subExpiry > 0 {
+		mqsd.SubExpiry = ci.subExpiry * 10 // Convert to tenths of a second
+	}
 
-	hObj, err := ci.si.qMgr.Sub(mqsd, pubQObj)
+	hObj, managedQObj, err := ci.si.qMgr.Sub(mqsd, *pubQObj)
 	if err != nil {
+		recordAuthFailure(AUTH_OP_SUBSCRIBE, err)
 		extraInfo := ""
 		mqrc := err.(*ibmmq.MQReturn).MQRC
 		switch mqrc {
@@ -455,10 +775,14 @@ func subscribeWithOptions(topic string, pubQObj *ibmmq.MQObject, managed bool, d
 		return mqtd, e2
 	}
 
+	if managed {
+		*pubQObj = managedQObj
+	}
 	mqtd.hObj = hObj
 	mqtd.durable = durable
-	mqtd.topic = topic
+	mqtd.topic = intern(topic)
 	mqtd.managed = managed
+	mqtd.correlId = mqsd.SubCorrelId
 
 	if durable {
 		// The subscription can be closed immediately, but still left to
@@ -484,7 +808,7 @@ func (mqtd *MQTopicDescriptor) unsubscribe() {
 	topic := mqtd.topic
 	logTrace("Removing subscription for %+v ", mqtd)
 	if mqtd.durable {
-		if ibmmq.IsUsableHObj(mqtd.hObj) {
+		if ibmmq.IsUsableObject(mqtd.hObj) {
 			mqtd.hObj.Close(ibmmq.MQCO_REMOVE_SUB)
 		} else {
 
@@ -495,7 +819,7 @@ func (mqtd *MQTopicDescriptor) unsubscribe() {
 			mqsd.SubName = ci.durableSubPrefix + "_" + topic
 			mqsd.ObjectString = topic
 
-			subObj, err := ci.si.qMgr.Sub(mqsd, &ci.si.replyQObj)
+			subObj, _, err := ci.si.qMgr.Sub(mqsd, ci.si.replyQObj)
 			if err == nil {
 				err = subObj.Close(ibmmq.MQCO_REMOVE_SUB)
 			} else {
@@ -521,7 +845,7 @@ We can't use the resubscribe/close technique here because a) we don't know in ad
 subscription names are and b) we don't know which queue is attached - the collector configuration
 might have changed. So we do this cleanup using the PCF commands.
 */
-func clearDurableSubscriptions(prefix string, cmdQObj ibmmq.MQObject, replyQObj ibmmq.MQObject) {
+func clearDurableSubscriptions(prefix string, cmdQObj ibmmq.Object, replyQObj ibmmq.Object) {
 	var err error
 
 	subNameList := make(map[string]string)
@@ -553,6 +877,7 @@ func clearDurableSubscriptions(prefix string, cmdQObj ibmmq.MQObject, replyQObj
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
+	tracePCF("command", buf)
 	err = cmdQObj.Put(putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("clearDurableSubscriptions", 1, err)
@@ -593,6 +918,7 @@ func clearDurableSubscriptions(prefix string, cmdQObj ibmmq.MQObject, replyQObj
 		buf = append(cfh.Bytes(), buf...)
 
 		// And now put the command to the queue
+		tracePCF("command", buf)
 		err = cmdQObj.Put(putmqmd, pmo, buf)
 		if err != nil {
 			traceExitErr("clearDurableSubscriptions", 2, err)
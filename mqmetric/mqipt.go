@@ -0,0 +1,271 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Functions in this file monitor the routes of an MQ Internet Pass-Thru (MQIPT) instance, so
+that the client channel hops through it can be seen alongside the queue manager's own
+metrics. MQIPT does not have a PCF interface, so two other sources are used:
+
+  - Each route's listener is checked with a TCP connection, giving an up/down status and
+    the time taken to connect. MQIPT makes its onward connection when the probe arrives, so
+    this also shows when the destination behind the route is unreachable. The probe closes
+    without sending any data, which the queue manager may log as a failed connection if
+    MQIPT passes it on.
+  - Optionally, MQIPT's connection log is followed, and the lines that match a pattern are
+    counted for each route to give the number of new connections in the interval. The
+    pattern must have a group named "port" that matches the route's listener port, as
+    the log format varies with the MQIPT version and its logging options.
+
+The routes are given explicitly in the configuration, usually mirroring the ListenerPort
+values in mqipt.conf. The results are in the OT_MQIPT status set, keyed by route name.
+*/
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ATTR_MQIPT_ROUTE        = "route"
+	ATTR_MQIPT_ADDRESS      = "address"
+	ATTR_MQIPT_STATUS       = "status"
+	ATTR_MQIPT_CONNECT_TIME = "connect_time"
+	ATTR_MQIPT_CONNECTIONS  = "connections"
+
+	defaultMQIPTConnectTimeout = 5 * time.Second
+)
+
+// MQIPTRoute is one route to be monitored
+type MQIPTRoute struct {
+	Name    string
+	Address string // The host:port of the route's listener
+}
+
+// MQIPTConfig says which routes to monitor, and how
+type MQIPTConfig struct {
+	Routes         []MQIPTRoute
+	ConnectTimeout time.Duration // Default 5 seconds
+
+	// The connection log to follow, and a regular expression for the lines
+	// that report a new connection. Both are optional.
+	ConnectionLog     string
+	ConnectionPattern string
+}
+
+type mqiptState struct {
+	cfg       MQIPTConfig
+	pattern   *regexp.Regexp
+	portRoute map[string]string // Listener port to route name
+	logOffset int64
+}
+
+/*
+SetMQIPTConfig sets the routes to be monitored by CollectMQIPTStatus for the current
+connection. An error is returned if a route address or the pattern is not valid.
+*/
+func SetMQIPTConfig(cfg MQIPTConfig) error {
+	traceEntry("SetMQIPTConfig")
+
+	ci := getConnection(GetConnectionKey())
+
+	s := &mqiptState{cfg: cfg, portRoute: make(map[string]string)}
+	if s.cfg.ConnectTimeout <= 0 {
+		s.cfg.ConnectTimeout = defaultMQIPTConnectTimeout
+	}
+
+	for _, r := range cfg.Routes {
+		if r.Name == "" {
+			err := fmt.Errorf("MQIPT route for %s has no name", r.Address)
+			traceExitErr("SetMQIPTConfig", 1, err)
+			return err
+		}
+		_, port, err := net.SplitHostPort(r.Address)
+		if err != nil {
+			err = fmt.Errorf("MQIPT route %s: %v", r.Name, err)
+			traceExitErr("SetMQIPTConfig", 2, err)
+			return err
+		}
+		s.portRoute[port] = r.Name
+	}
+
+	if cfg.ConnectionLog != "" {
+		var err error
+		s.pattern, err = regexp.Compile(cfg.ConnectionPattern)
+		if err == nil && s.pattern.SubexpIndex("port") < 0 {
+			err = fmt.Errorf("Pattern must have a group named \"port\"")
+		}
+		if err != nil {
+			err = fmt.Errorf("MQIPT connection pattern: %v", err)
+			traceExitErr("SetMQIPTConfig", 3, err)
+			return err
+		}
+		// Only count connections made after monitoring starts
+		if fi, err := os.Stat(cfg.ConnectionLog); err == nil {
+			s.logOffset = fi.Size()
+		}
+	}
+
+	ci.mqipt = s
+	traceExit("SetMQIPTConfig", 0)
+	return nil
+}
+
+/*
+Unlike the statistics produced via a topic, there is no discovery
+of the attributes available in object STATUS queries. So this function
+hardcodes the attributes we are going to look for and gives the associated
+descriptive text.
+*/
+func MQIPTInitAttributes() {
+	traceEntry("MQIPTInitAttributes")
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_MQIPT]
+	st := GetObjectStatus(GetConnectionKey(), OT_MQIPT)
+
+	if os.init {
+		traceExit("MQIPTInitAttributes", 1)
+		return
+	}
+	st.Attributes = make(map[string]*StatusAttribute)
+
+	attr := ATTR_MQIPT_ROUTE
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Route Name")
+	attr = ATTR_MQIPT_ADDRESS
+	st.Attributes[attr] = newPseudoStatusAttribute(attr, "Listener Address")
+
+	attr = ATTR_MQIPT_STATUS
+	st.Attributes[attr] = newStatusAttribute(attr, "Route Available", -1)
+	attr = ATTR_MQIPT_CONNECT_TIME
+	st.Attributes[attr] = newStatusAttribute(attr, "Connect Time (ms)", -1)
+	attr = ATTR_MQIPT_CONNECTIONS
+	st.Attributes[attr] = newStatusAttribute(attr, "New Connections", -1)
+
+	os.init = true
+	traceExit("MQIPTInitAttributes", 0)
+}
+
+/*
+CollectMQIPTStatus checks each configured route, and counts the connections in the log
+since the previous collection. It does nothing if SetMQIPTConfig has not been called.
+Routes that cannot be reached are reported with a status of 0, not as an error.
+*/
+func CollectMQIPTStatus() error {
+	var err error
+	traceEntry("CollectMQIPTStatus")
+
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), OT_MQIPT)
+	MQIPTInitAttributes()
+
+	// Empty any collected values
+	statusClearValues(st)
+
+	s := ci.mqipt
+	if s == nil {
+		traceExit("CollectMQIPTStatus", 1)
+		return nil
+	}
+
+	for _, r := range s.cfg.Routes {
+		st.Attributes[ATTR_MQIPT_ROUTE].Values[r.Name] = newStatusValueString(r.Name)
+		st.Attributes[ATTR_MQIPT_ADDRESS].Values[r.Name] = newStatusValueString(r.Address)
+
+		start := time.Now()
+		conn, cerr := net.DialTimeout("tcp", r.Address, s.cfg.ConnectTimeout)
+		if cerr != nil {
+			logDebug("MQIPT route %s is not available: %v", r.Name, cerr)
+			st.Attributes[ATTR_MQIPT_STATUS].Values[r.Name] = newStatusValueInt64(0)
+			continue
+		}
+		elapsed := time.Since(start)
+		conn.Close()
+		st.Attributes[ATTR_MQIPT_STATUS].Values[r.Name] = newStatusValueInt64(1)
+		st.Attributes[ATTR_MQIPT_CONNECT_TIME].Values[r.Name] = newStatusValueInt64(elapsed.Milliseconds())
+	}
+
+	if s.pattern != nil {
+		var counts map[string]int64
+		counts, err = s.readConnectionLog()
+		if err == nil {
+			for _, r := range s.cfg.Routes {
+				st.Attributes[ATTR_MQIPT_CONNECTIONS].Values[r.Name] = newStatusValueInt64(counts[r.Name])
+			}
+		} else {
+			logError("Cannot read MQIPT connection log %s: %v", s.cfg.ConnectionLog, err)
+		}
+	}
+
+	statusPostCollect(OT_MQIPT)
+	traceExitErr("CollectMQIPTStatus", 0, err)
+	return err
+}
+
+// Read the lines added to the log since the last call, and count the matching
+// ones for each route. If the log is now smaller, it has been replaced so it is read
+// from the start.
+func (s *mqiptState) readConnectionLog() (map[string]int64, error) {
+	f, err := os.Open(s.cfg.ConnectionLog)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < s.logOffset {
+		s.logOffset = 0
+	}
+	if _, err = f.Seek(s.logOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(f, fi.Size()-s.logOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	// A partial last line is left for the next call
+	end := strings.LastIndexByte(string(b), '\n') + 1
+	s.logOffset += int64(end)
+	return s.countConnections(string(b[:end])), nil
+}
+
+func (s *mqiptState) countConnections(lines string) map[string]int64 {
+	counts := make(map[string]int64)
+	port := s.pattern.SubexpIndex("port")
+	for _, line := range strings.Split(lines, "\n") {
+		m := s.pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if route, ok := s.portRoute[m[port]]; ok {
+			counts[route]++
+		}
+	}
+	return counts
+}
@@ -16,10 +16,22 @@ limitations under the License.
 package mqmetric
 
 import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
 )
@@ -284,3 +296,2406 @@ func checkParamsMatch(returned *ibmmq.PCFParameter, expected *ibmmq.PCFParameter
 		}
 	}
 }
+
+func TestReplay(t *testing.T) {
+	const filename = "testReplay"
+	const qMgrName = "QM1"
+
+	pcf := func(params ...*ibmmq.PCFParameter) []byte {
+		cfh := ibmmq.NewMQCFH()
+		cfh.Type = ibmmq.MQCFT_STATISTICS
+		cfh.ParameterCount = int32(len(params))
+		buf := cfh.Bytes()
+		for _, p := range params {
+			buf = append(buf, p.Bytes()...)
+		}
+		return buf
+	}
+	intParm := func(parm int32, v int64) *ibmmq.PCFParameter {
+		return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER, Parameter: parm, Int64Value: []int64{v}}
+	}
+	strParm := func(parm int32, s string) *ibmmq.PCFParameter {
+		return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_STRING, Parameter: parm, String: []string{s}}
+	}
+	group := func(parms ...*ibmmq.PCFParameter) *ibmmq.PCFParameter {
+		return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_GROUP, Parameter: ibmmq.MQGACF_MONITOR_CLASS, GroupList: parms}
+	}
+
+	classesTopic := "$SYS/MQ/INFO/QMGR/" + qMgrName + "/Monitor/METADATA/CLASSES"
+	records := []ReplayRecord{
+		{Kind: REPLAY_HEADER, QMgrName: qMgrName, Platform: ibmmq.MQPL_UNIX, CommandLevel: 930},
+		{Kind: REPLAY_META, Topic: classesTopic, Data: pcf(group(
+			intParm(ibmmq.MQIAMO_MONITOR_CLASS, 0),
+			strParm(ibmmq.MQCAMO_MONITOR_CLASS, "CPU"),
+			strParm(ibmmq.MQCA_TOPIC_STRING, "types")))},
+		{Kind: REPLAY_META, Topic: "types", Data: pcf(group(
+			intParm(ibmmq.MQIAMO_MONITOR_TYPE, 0),
+			strParm(ibmmq.MQCAMO_MONITOR_TYPE, "SystemSummary"),
+			strParm(ibmmq.MQCA_TOPIC_STRING, "elements")))},
+		{Kind: REPLAY_META, Topic: "elements", Data: pcf(
+			strParm(ibmmq.MQCA_TOPIC_STRING, "data"),
+			group(intParm(ibmmq.MQIAMO_MONITOR_ELEMENT, 3),
+				intParm(ibmmq.MQIAMO_MONITOR_DATATYPE, int64(ibmmq.MQIAMO_MONITOR_UNIT)),
+				strParm(ibmmq.MQCAMO_MONITOR_DESC, "Test value")))},
+		{Kind: REPLAY_INTERVAL},
+		{Kind: REPLAY_PUB, Data: pcf(intParm(ibmmq.MQIAMO_MONITOR_CLASS, 0), intParm(ibmmq.MQIAMO_MONITOR_TYPE, 0), intParm(3, 42))},
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Cannot create %s: %v", filename, err)
+	}
+	defer os.Remove(filename)
+	enc := json.NewEncoder(f)
+	for i := range records {
+		enc.Encode(&records[i])
+	}
+	f.Close()
+
+	SetConnectionKey("replay")
+	defer SetConnectionKey("")
+	if err = InitReplayKey("replay", filename); err != nil {
+		t.Fatalf("InitReplay failed: %v", err)
+	}
+	if err = DiscoverAndSubscribe(DiscoverConfig{}); err != nil {
+		t.Fatalf("DiscoverAndSubscribe failed: %v", err)
+	}
+	if err = ProcessPublications(); err != nil {
+		t.Fatalf("ProcessPublications failed: %v", err)
+	}
+
+	elem := GetPublishedMetrics("replay").Classes[0].Types[0].Elements[3]
+	if v := elem.Values[QMgrMapKey]; v != 42 {
+		t.Logf("Replayed value. Expected: 42, Got: %d", v)
+		t.Fail()
+	}
+	if GetProcessPublicationCount() != 1 {
+		t.Logf("Publication count. Expected: 1, Got: %d", GetProcessPublicationCount())
+		t.Fail()
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	eps := []Endpoint{{ConnName: "host1(1414)"}, {ConnName: "host2(1414)"}}
+	ordered := orderEndpoints(eps, ENDPOINT_ORDERED, "SYSTEM.DEF.SVRCONN")
+	if !canShareCD(ordered) {
+		t.Logf("Endpoints with the same settings should share an MQCD")
+		t.Fail()
+	}
+	cd := endpointCD(ordered)
+	if cd.ConnectionName != "host1(1414),host2(1414)" || cd.ChannelName != "SYSTEM.DEF.SVRCONN" {
+		t.Logf("MQCD. Got: %s %s", cd.ConnectionName, cd.ChannelName)
+		t.Fail()
+	}
+
+	eps[1].CipherSpec = "ANY_TLS12"
+	if canShareCD(orderEndpoints(eps, ENDPOINT_RANDOM, "")) {
+		t.Logf("Endpoints with different TLS settings should not share an MQCD")
+		t.Fail()
+	}
+}
+
+func TestParseCommandEvent(t *testing.T) {
+	cfh := ibmmq.NewMQCFH()
+	cfh.Type = ibmmq.MQCFT_EVENT
+	cfh.Command = ibmmq.MQCMD_COMMAND_EVENT
+	cfh.Reason = ibmmq.MQRC_COMMAND_MQSC
+	cfh.ParameterCount = 2
+
+	ctx := &ibmmq.PCFParameter{Type: ibmmq.MQCFT_GROUP, Parameter: ibmmq.MQGACF_COMMAND_CONTEXT}
+	ctx.GroupList = []*ibmmq.PCFParameter{
+		{Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCACF_EVENT_USER_ID, String: []string{"admin"}},
+		{Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIACF_EVENT_ORIGIN, Int64Value: []int64{int64(ibmmq.MQEVO_CONSOLE)}},
+	}
+	data := &ibmmq.PCFParameter{Type: ibmmq.MQCFT_GROUP, Parameter: ibmmq.MQGACF_COMMAND_DATA}
+	data.GroupList = []*ibmmq.PCFParameter{
+		{Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCACF_COMMAND_MQSC, String: []string{"DEFINE QLOCAL(X)"}},
+	}
+
+	buf := append(cfh.Bytes(), ctx.Bytes()...)
+	buf = append(buf, data.Bytes()...)
+
+	e, err := ParseCommandEvent(nil, buf)
+	if err != nil {
+		t.Fatalf("ParseCommandEvent failed: %v", err)
+	}
+	if !e.IsMQSC() || e.MQSC != "DEFINE QLOCAL(X)" || e.UserId != "admin" || e.Origin != ibmmq.MQEVO_CONSOLE {
+		t.Logf("Command event. Got: %s", e)
+		t.Fail()
+	}
+
+	cfh.Command = ibmmq.MQCMD_CONFIG_EVENT
+	if _, err = ParseCommandEvent(nil, cfh.Bytes()); err == nil {
+		t.Logf("Expected an error for a non-command event")
+		t.Fail()
+	}
+}
+
+func TestQueuePresets(t *testing.T) {
+	allQueues := []string{"APP.1", "APP.2", "DEV.1", "SYSTEM.DEF.LOCAL", "SYSTEM.CLUSTER.TRANSMIT.QUEUE", "SYSTEM.CLUSTER.COMMAND.QUEUE", "XMIT.TO.QM2"}
+	xmitQueues := []string{"SYSTEM.CLUSTER.TRANSMIT.QUEUE", "XMIT.TO.QM2"}
+
+	qList, err := filterWithPresets("APP*,@NOSYSTEM,@XMITQ", allQueues, xmitQueues)
+	expected := "APP.1,APP.2,SYSTEM.CLUSTER.TRANSMIT.QUEUE,XMIT.TO.QM2"
+	if err != nil || strings.Join(qList, ",") != expected {
+		t.Logf("Presets. Expected: %s, Got: %v %v", expected, qList, err)
+		t.Fail()
+	}
+
+	qList, _ = filterWithPresets("@NOSYSTEM,@CLUSTERQ", allQueues, nil)
+	expected = "APP.1,APP.2,DEV.1,SYSTEM.CLUSTER.COMMAND.QUEUE,SYSTEM.CLUSTER.TRANSMIT.QUEUE,XMIT.TO.QM2"
+	if strings.Join(qList, ",") != expected {
+		t.Logf("Presets. Expected: %s, Got: %v", expected, qList)
+		t.Fail()
+	}
+
+	if VerifyQueuePatterns("APP*,@UNKNOWN") == nil || VerifyPatterns("@XMITQ") == nil {
+		t.Logf("Invalid presets were accepted")
+		t.Fail()
+	}
+	if err = VerifyQueuePatterns("APP*,!DEV*,@NOSYSTEM"); err != nil {
+		t.Logf("Valid presets were rejected: %v", err)
+		t.Fail()
+	}
+}
+
+func TestFleet(t *testing.T) {
+	ccdt := `{"channel":[
+	  {"name":"QM1.SVRCONN","type":"clientConnection","clientConnection":{"connection":[{"host":"host1","port":1414},{"host":"host2"}],"queueManager":"QM1"}},
+	  {"name":"QM2.SVRCONN","type":"clientConnection","clientConnection":{"connection":[{"host":"host3","port":1415}],"queueManager":"QM2"}},
+	  {"name":"QM1.OTHER","type":"clientConnection","clientConnection":{"connection":[{"host":"host9"}],"queueManager":"QM1"}}]}`
+
+	filename := os.TempDir() + "/mqmetric_ccdt.json"
+	if err := ioutil.WriteFile(filename, []byte(ccdt), 0600); err != nil {
+		t.Fatalf("Cannot write CCDT: %v", err)
+	}
+	defer os.Remove(filename)
+
+	members, err := FleetMembersFromCCDT(filename, ConnectionConfig{UseStatus: true})
+	if err != nil || len(members) != 2 {
+		t.Fatalf("FleetMembersFromCCDT. Expected 2 members, Got: %v %v", members, err)
+	}
+	if members[0].Connection.ConnName != "host1(1414),host2(1414)" || members[0].Connection.Channel != "QM1.SVRCONN" || !members[0].Connection.UseStatus {
+		t.Logf("Member 0. Got: %+v", members[0].Connection)
+		t.Fail()
+	}
+
+	members = append(members, FleetMember{Key: "QM2", QMgrName: "QM2"})
+	if _, err = NewFleet(FleetConfig{Members: members, Interval: 60}); err == nil {
+		t.Logf("Duplicate keys were accepted")
+		t.Fail()
+	}
+
+	if o := staggerOffset(1, 4, 60*time.Second); o != 15*time.Second {
+		t.Logf("Stagger offset. Expected: 15s, Got: %v", o)
+		t.Fail()
+	}
+
+	yaml := `# Two queue managers
+interval: 60
+stagger: true
+members:
+  - qMgrName: QM1
+    replyQ: "MQMON.REPLY # not a comment"
+    connection:
+      connName: host1(1414)
+      channel: 'QM1.SVRCONN'
+      useStatus: true
+  -
+    key: second
+    qMgrName: QM2
+    connection:
+      endpoints:
+      - connName: host2(1414)
+      - connName: host3(1414)
+`
+	filename = os.TempDir() + "/mqmetric_fleet.yaml"
+	if err = ioutil.WriteFile(filename, []byte(yaml), 0600); err != nil {
+		t.Fatalf("Cannot write fleet configuration: %v", err)
+	}
+	defer os.Remove(filename)
+
+	fc, err := LoadFleetConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadFleetConfig: %v", err)
+	}
+	if fc.Interval != 60 || !fc.Stagger || len(fc.Members) != 2 {
+		t.Fatalf("Fleet configuration. Got: %+v", fc)
+	}
+	m0, m1 := fc.Members[0], fc.Members[1]
+	if m0.QMgrName != "QM1" || m0.ReplyQ != "MQMON.REPLY # not a comment" || m0.Connection.ConnName != "host1(1414)" ||
+		m0.Connection.Channel != "QM1.SVRCONN" || !m0.Connection.UseStatus {
+		t.Logf("Member 0. Got: %+v", m0)
+		t.Fail()
+	}
+	if m1.Key != "second" || len(m1.Connection.Endpoints) != 2 || m1.Connection.Endpoints[1].ConnName != "host3(1414)" {
+		t.Logf("Member 1. Got: %+v", m1)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"members: [QM1, QM2]", "interval: 60\n  stagger: true", "- a\nb: c", "a: 1\na: 2"} {
+		if _, err = fleetYAMLToJSON([]byte(bad)); err == nil {
+			t.Logf("Bad YAML was accepted: %s", bad)
+			t.Fail()
+		}
+	}
+
+	qm1 := &ModelSnapshot{QMgrName: "QM1", Metrics: []ModelMetric{
+		{Name: "depth", Source: MODEL_SOURCE_STATUS, Class: "queue", Values: []ModelValue{{Object: "APP.Q", Value: 3}}},
+		{Name: "cpu", Source: MODEL_SOURCE_PUBLICATION, Class: "CPU", Values: []ModelValue{{Value: 10}}}}}
+	qm2 := &ModelSnapshot{QMgrName: "QM2", Metrics: []ModelMetric{
+		{Name: "depth", Source: MODEL_SOURCE_STATUS, Class: "queue", Values: []ModelValue{{Object: "APP.Q", Value: 5}, {Object: "A.Q", Value: 1}}}}}
+	merged := mergeModels([]*ModelSnapshot{qm2, qm1}, []string{"QM2", ""})
+	if len(merged.Metrics) != 2 || merged.Metrics[0].Name != "cpu" || merged.QMgrName != "" {
+		t.Fatalf("Merged metrics. Got: %+v", merged.Metrics)
+	}
+	depth := merged.Metrics[1].Values
+	if len(depth) != 3 || depth[0].QMgr != "QM1" || depth[0].Value != 3 || depth[1].QMgr != "QM2" || depth[1].Object != "A.Q" ||
+		depth[2].Object != "APP.Q" || depth[2].Value != 5 {
+		t.Logf("Merged depth values. Got: %+v", depth)
+		t.Fail()
+	}
+	if len(qm2.Metrics[0].Values) != 2 || qm2.Metrics[0].Values[0].QMgr != "" {
+		t.Logf("Merge changed a member's snapshot: %+v", qm2.Metrics[0].Values)
+		t.Fail()
+	}
+}
+
+func TestDiffDiscovery(t *testing.T) {
+	old := &DiscoverySnapshot{Queues: []string{"APP.1", "APP.2"}, Classes: []string{"CPU"}}
+	new := &DiscoverySnapshot{Queues: []string{"APP.2", "APP.3"}, Classes: []string{"CPU", "DISK"}}
+
+	changes := DiffDiscovery(old, new)
+	expected := []string{"queue APP.3 added", "queue APP.1 deleted", "class DISK added"}
+	if len(changes) != len(expected) {
+		t.Fatalf("DiffDiscovery. Expected: %v, Got: %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i].String() != expected[i] {
+			t.Logf("Change %d. Expected: %s, Got: %s", i, expected[i], changes[i])
+			t.Fail()
+		}
+	}
+
+	if changes = DiffDiscovery(nil, new); len(changes) != 4 {
+		t.Logf("DiffDiscovery with no previous snapshot. Expected 4 changes, Got: %v", changes)
+		t.Fail()
+	}
+}
+
+func TestTopicExclusions(t *testing.T) {
+	includes, excludes := splitExclusions(" #, !$SYS*,APP/+ ,!APP/TEST*")
+	if len(includes) != 2 || includes[0] != "#" || includes[1] != "APP/+" {
+		t.Logf("Includes. Got: %v", includes)
+		t.Fail()
+	}
+	if excludes != "!$SYS*,!APP/TEST*" {
+		t.Logf("Excludes. Got: %s", excludes)
+		t.Fail()
+	}
+
+	topics := []string{"$SYS/MQ/INFO", "APP/PRICES", "APP/TEST/1"}
+	if l := FilterRegExp(excludes, topics); len(l) != 1 || l[0] != "APP/PRICES" {
+		t.Logf("Filtered topics. Got: %v", l)
+		t.Fail()
+	}
+}
+
+func TestQMgrRestart(t *testing.T) {
+	ci := new(connectionInfo)
+	if n := checkQMgrRestart(ci, "QMTEST", "2023-01-01", "10.00.00"); n != 0 || ci.qMgrRestarted {
+		t.Logf("First check. Expected 0 restarts, Got: %d", n)
+		t.Fail()
+	}
+	if n := checkQMgrRestart(ci, "QMTEST", "2023-01-01", "10.00.00"); n != 0 || ci.qMgrRestarted {
+		t.Logf("Same start time. Expected 0 restarts, Got: %d", n)
+		t.Fail()
+	}
+	if n := checkQMgrRestart(ci, "QMTEST", "2023-01-02", "09.30.00"); n != 1 || !ci.qMgrRestarted {
+		t.Logf("New start time. Expected 1 restart, Got: %d %v", n, ci.qMgrRestarted)
+		t.Fail()
+	}
+
+	// A new connection continues the count, but does not need to redo anything itself
+	ci = new(connectionInfo)
+	if n := checkQMgrRestart(ci, "QMTEST", "2023-01-03", "08.00.00"); n != 2 || ci.qMgrRestarted {
+		t.Logf("New connection. Expected 2 restarts, Got: %d %v", n, ci.qMgrRestarted)
+		t.Fail()
+	}
+}
+
+func TestChannelTuning(t *testing.T) {
+	l, err := compressionList("zlibfast, RLE", msgCompressionNames, 16)
+	if err != nil || l[0] != ibmmq.MQCOMPRESS_ZLIBFAST || l[1] != ibmmq.MQCOMPRESS_RLE || l[2] != ibmmq.MQCOMPRESS_NOT_AVAILABLE {
+		t.Logf("Message compression list. Got: %v %v", l, err)
+		t.Fail()
+	}
+
+	if err = checkChannelTuning(&ConnectionConfig{HeaderCompression: "ZLIBFAST"}); err == nil {
+		t.Logf("Header compression with ZLIBFAST was accepted")
+		t.Fail()
+	}
+	if err = checkChannelTuning(&ConnectionConfig{HeaderCompression: "SYSTEM,NONE", BatchInterval: 50}); err != nil {
+		t.Logf("Valid tuning was rejected: %v", err)
+		t.Fail()
+	}
+}
+
+func TestBindings(t *testing.T) {
+	if _, err := checkBindings(&ConnectionConfig{Bindings: "shared"}); err != nil {
+		t.Logf("Shared bindings were rejected: %v", err)
+		t.Fail()
+	}
+	if _, err := checkBindings(&ConnectionConfig{Bindings: "SHARED", ConnName: "localhost(1414)"}); err == nil {
+		t.Logf("Bindings were accepted for a client connection")
+		t.Fail()
+	}
+	if _, err := checkBindings(&ConnectionConfig{Bindings: BINDINGS_FASTPATH}); err == nil {
+		t.Logf("Fastpath bindings were accepted without AllowFastpathBindings")
+		t.Fail()
+	}
+
+	AllowFastpathBindings()
+	defer func() { fastpath.allowed = false }()
+	if b, err := checkBindings(&ConnectionConfig{Bindings: BINDINGS_FASTPATH}); err != nil || b != ibmmq.MQCNO_FASTPATH_BINDING {
+		t.Logf("Fastpath bindings. Got: %d %v", b, err)
+		t.Fail()
+	}
+}
+
+func TestQueueAttrRequests(t *testing.T) {
+	// The requests are written as the generic names, with "+X" for the transmission queue filter
+	tests := map[string]string{
+		"APP.*,!APP.TEMP*,DEV.Q1,APP.*": "APP.*,DEV.Q1",
+		"!SYSTEM.*":                     "*",
+		"APP.*,@XMITQ":                  "APP.*,*+X",
+		"APP.*,@NOSYSTEM,@CLUSTERQ":     "APP.*,SYSTEM.CLUSTER.*",
+		"@NOSYSTEM,@XMITQ":              "*",
+		"APP.*,*":                       "*",
+	}
+	for in, expected := range tests {
+		var got []string
+		for _, r := range queueAttrRequests(in) {
+			s := r.pattern
+			if r.filter != nil {
+				if r.filter.Type != ibmmq.MQCFT_INTEGER_FILTER || r.filter.Parameter != ibmmq.MQIA_USAGE ||
+					r.filter.Operator != ibmmq.MQCFOP_EQUAL || r.filter.Int64Value[0] != int64(ibmmq.MQUS_TRANSMISSION) {
+					t.Logf("Patterns %s. Filter: %+v", in, r.filter)
+					t.Fail()
+				}
+				s += "+X"
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, ",") != expected {
+			t.Logf("Patterns %s. Expected: %s, Got: %v", in, expected, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestInquireQueueAttributes(t *testing.T) {
+	key := "QATTRS"
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	qm := ibmmq.NewFakeQueueManager("FAKEQM")
+	qm.DefineQueue("SYSTEM.ADMIN.COMMAND.QUEUE")
+	qm.DefineModelQueue("SYSTEM.DEFAULT.MODEL.QUEUE")
+	qm.SetQMgrAttr(ibmmq.MQIA_PERFORMANCE_EVENT, int32(ibmmq.MQEVR_DISABLED))
+
+	cc := ConnectionConfig{Connection: qm, WaitInterval: 1}
+	if err := InitConnectionKey(key, "FAKEQM", "SYSTEM.DEFAULT.MODEL.QUEUE", "", &cc); err != nil {
+		t.Fatalf("InitConnection failed: %v", err)
+	}
+	defer EndConnection()
+	ci := getConnection(key)
+	ci.qInfoMap = make(map[string]*ObjInfo)
+	ci.qInfoMap["APP.Q1"] = &ObjInfo{exists: true}
+	ci.qInfoMap["XMITQ1"] = &ObjInfo{exists: true}
+
+	// A command server that answers the first command only, as if it were then too busy
+	type command struct {
+		pattern string
+		filter  *ibmmq.PCFParameter
+	}
+	var commands []command
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		od := ibmmq.NewMQOD()
+		od.ObjectName = "SYSTEM.ADMIN.COMMAND.QUEUE"
+		cmdQ, err := qm.Open(od, ibmmq.MQOO_INPUT_SHARED)
+		if err != nil {
+			return
+		}
+		defer cmdQ.Close(0)
+		buf := make([]byte, 10240)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			md := ibmmq.NewMQMD()
+			gmo := ibmmq.NewMQGMO()
+			gmo.Options = ibmmq.MQGMO_WAIT
+			gmo.WaitInterval = 50
+			l, err := cmdQ.Get(md, gmo, buf)
+			if err != nil {
+				continue
+			}
+			cfh, offset := ibmmq.ReadPCFHeader(buf[:l])
+			var c command
+			for i := 0; i < int(cfh.ParameterCount); i++ {
+				p, n := ibmmq.ReadPCFParameter(buf[offset:l])
+				offset += n
+				switch {
+				case p.Parameter == ibmmq.MQCA_Q_NAME:
+					c.pattern = p.String[0]
+				case p.Type == ibmmq.MQCFT_INTEGER_FILTER:
+					c.filter = p
+				}
+			}
+			commands = append(commands, c)
+			if len(commands) > 1 {
+				continue
+			}
+
+			reply := ibmmq.NewMQCFH()
+			reply.Type = ibmmq.MQCFT_RESPONSE
+			reply.Command = ibmmq.MQCMD_INQUIRE_Q
+			reply.Control = ibmmq.MQCFC_LAST
+			reply.ParameterCount = 2
+			r := reply.Bytes()
+			r = append(r, (&ibmmq.PCFParameter{Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCA_Q_NAME, String: []string{"APP.Q1"}}).Bytes()...)
+			r = append(r, (&ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIA_MAX_Q_DEPTH, Int64Value: []int64{1234}}).Bytes()...)
+			rmd := ibmmq.NewMQMD()
+			rmd.CorrelId = md.MsgId
+			rmd.Format = "MQADMIN"
+			rod := ibmmq.NewMQOD()
+			rod.ObjectName = md.ReplyToQ
+			qm.Put1(rod, rmd, ibmmq.NewMQPMO(), r)
+		}
+	}()
+
+	start := time.Now()
+	err := inquireQueueAttributes(queueAttrRequests("APP.*,@XMITQ,@CLUSTERQ"))
+	elapsed := time.Since(start)
+	close(stop)
+	<-finished
+
+	if mqreturn, ok := err.(*ibmmq.MQReturn); !ok || mqreturn.MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE {
+		t.Logf("Missing replies. Expected: 2033, Got: %v", err)
+		t.Fail()
+	}
+	// Only one wait for the unanswered commands, not one for each of them
+	if elapsed > 1800*time.Millisecond {
+		t.Logf("Waited %v for the replies", elapsed)
+		t.Fail()
+	}
+	if ci.qInfoMap["APP.Q1"].AttrMaxDepth != 1234 {
+		t.Logf("Reply was not parsed. Got: %+v", ci.qInfoMap["APP.Q1"])
+		t.Fail()
+	}
+	if ci.commands.outstanding() != 0 {
+		t.Logf("Commands still outstanding: %d", ci.commands.outstanding())
+		t.Fail()
+	}
+
+	// All of the commands are sent before the replies are read
+	if len(commands) != 3 {
+		t.Fatalf("Commands. Expected: 3, Got: %d", len(commands))
+	}
+	if commands[0].pattern != "APP.*" || commands[0].filter != nil || commands[1].pattern != "SYSTEM.CLUSTER.*" {
+		t.Logf("Commands. Got: %+v", commands)
+		t.Fail()
+	}
+	if f := commands[2].filter; commands[2].pattern != "*" || f == nil || f.Parameter != ibmmq.MQIA_USAGE ||
+		f.Operator != ibmmq.MQCFOP_EQUAL || f.Int64Value[0] != int64(ibmmq.MQUS_TRANSMISSION) {
+		t.Logf("Transmission queue command. Got: %+v", commands[2])
+		t.Fail()
+	}
+}
+
+func TestCommandTracker(t *testing.T) {
+	tr := newCommandTracker()
+	tr.touch([]byte("CMD1"), time.Minute)
+	tr.touch([]byte("CMD2"), -time.Second)
+	if n := tr.outstanding(); n != 1 {
+		t.Logf("Expired command. Expected 1 outstanding, Got: %d", n)
+		t.Fail()
+	}
+	tr.done([]byte("CMD1"))
+	if n := tr.outstanding(); n != 0 {
+		t.Logf("Completed command. Expected 0 outstanding, Got: %d", n)
+		t.Fail()
+	}
+}
+
+func TestCollectSteps(t *testing.T) {
+	key := "TestCollectSteps"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	order := ""
+	finished := false
+	steps := []collectStep{
+		{COLLECT_QUEUES, func(k string, c *connectionInfo) error {
+			if k != key || c != ci {
+				t.Logf("Step given the wrong connection: %s", k)
+				t.Fail()
+			}
+			order += "Q"
+			return nil
+		}},
+		{COLLECT_CHANNELS, func(k string, c *connectionInfo) error { order += "C"; return fmt.Errorf("failed") }},
+		{COLLECT_QMGR, func(k string, c *connectionInfo) error {
+			order += "M"
+			time.Sleep(150 * time.Millisecond)
+			finished = true
+			return nil
+		}},
+		{COLLECT_PUBLICATIONS, func(k string, c *connectionInfo) error { order += "P"; return nil }},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := runCollectSteps(ctx, key, ci, steps)
+
+	// The running step is waited for, and keeps its own result
+	if !finished {
+		t.Logf("Returned before the running step finished")
+		t.Fail()
+	}
+	ce, ok := err.(*CollectError)
+	if !ok || len(ce.Errors) != 2 || ce.Errors[COLLECT_CHANNELS] == nil || ce.Errors[COLLECT_PUBLICATIONS] != context.DeadlineExceeded {
+		t.Logf("Unexpected errors: %v", err)
+		t.Fail()
+	}
+	// The steps ran in turn, and none was started after the budget ran out
+	if order != "QCM" {
+		t.Logf("Step order. Expected QCM, Got: %s", order)
+		t.Fail()
+	}
+
+	// A second cycle on the same connection is refused while one is running
+	ci.cycle = 1
+	if err = CollectOnce(context.Background(), CollectOptions{}); err != ErrCollectBusy {
+		t.Logf("Expected busy error, Got: %v", err)
+		t.Fail()
+	}
+	ci.cycle = 0
+	if err = CollectOnce(context.Background(), CollectOptions{}); err != nil || atomic.LoadInt32(&ci.cycle) != 0 {
+		t.Logf("Empty cycle failed: %v", err)
+		t.Fail()
+	}
+}
+
+func TestCommandLimiter(t *testing.T) {
+	if newCommandLimiter(0, 0) != nil {
+		t.Logf("Limiter created with no limits")
+		t.Fail()
+	}
+	// A nil limiter does nothing
+	var nl *commandLimiter
+	nl.wait()
+
+	now := time.Now()
+	l := newCommandLimiter(10, 0)
+	for i := 0; i < 5; i++ {
+		if d := l.reserve(now); d != time.Duration(i)*100*time.Millisecond {
+			t.Logf("Delay for command %d. Got: %v", i, d)
+			t.Fail()
+		}
+	}
+	// After a pause, commands are not delayed
+	if d := l.reserve(now.Add(2 * time.Second)); d != 0 {
+		t.Logf("Delay after pause. Got: %v", d)
+		t.Fail()
+	}
+
+	l = newCommandLimiter(0, 500)
+	first := l.reserve(now)
+	second := l.reserve(now.Add(first))
+	if first < 0 || first >= 500*time.Millisecond || second != 0 {
+		t.Logf("Jitter. Got: %v %v", first, second)
+		t.Fail()
+	}
+}
+
+func TestCommandBreaker(t *testing.T) {
+	if newCommandBreaker(0, 0) != nil {
+		t.Logf("Breaker created with no limit")
+		t.Fail()
+	}
+	var nb *commandBreaker
+	if !nb.allow(time.Now()) {
+		t.Logf("Nil breaker refused a command")
+		t.Fail()
+	}
+
+	now := time.Now()
+	b := newCommandBreaker(3, 10)
+	b.result(false, now)
+	b.result(false, now)
+	b.result(true, now)
+	b.result(false, now)
+	b.result(false, now)
+	if !b.allow(now) || b.health().Degraded {
+		t.Logf("Breaker opened before the limit. Got: %+v", b.health())
+		t.Fail()
+	}
+	b.result(false, now)
+	h := b.health()
+	if b.allow(now.Add(5*time.Second)) || !h.Degraded || h.Trips != 1 || !h.RetryAt.Equal(now.Add(10*time.Second)) {
+		t.Logf("Breaker not open after the limit. Got: %+v", h)
+		t.Fail()
+	}
+
+	// After the cool-down, a single timeout opens it again
+	later := now.Add(10 * time.Second)
+	if !b.allow(later) {
+		t.Logf("Breaker still open after the cool-down")
+		t.Fail()
+	}
+	b.result(false, later)
+	if b.allow(later) || b.health().Trips != 2 {
+		t.Logf("Breaker not reopened by a failed trial. Got: %+v", b.health())
+		t.Fail()
+	}
+
+	// And a reply closes it
+	later = later.Add(10 * time.Second)
+	b.allow(later)
+	b.result(true, later)
+	if !b.allow(later) || b.health().Degraded {
+		t.Logf("Breaker not closed by a reply. Got: %+v", b.health())
+		t.Fail()
+	}
+}
+
+func TestAdaptiveWait(t *testing.T) {
+	w := newAdaptiveWait(time.Second, true)
+	for i := 0; i < adaptiveEmptyLimit; i++ {
+		w.result(false)
+	}
+	if w.interval() != 500*time.Millisecond {
+		t.Logf("Wait after empty gets. Got: %v", w.interval())
+		t.Fail()
+	}
+	for i := 0; i < 20*adaptiveEmptyLimit; i++ {
+		w.result(false)
+	}
+	if w.interval() != 100*time.Millisecond {
+		t.Logf("Shortest wait. Got: %v", w.interval())
+		t.Fail()
+	}
+	w.result(true)
+	if w.interval() != time.Second {
+		t.Logf("Wait after a message. Got: %v", w.interval())
+		t.Fail()
+	}
+
+	// Without the adaptive option, the wait never changes
+	w = newAdaptiveWait(time.Second, false)
+	for i := 0; i < 2*adaptiveEmptyLimit; i++ {
+		w.result(false)
+	}
+	if w.interval() != time.Second {
+		t.Logf("Fixed wait. Got: %v", w.interval())
+		t.Fail()
+	}
+	var nw *adaptiveWait
+	if nw.interval() != 0 {
+		t.Logf("Nil wait. Got: %v", nw.interval())
+		t.Fail()
+	}
+}
+
+func TestLeaderLock(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "leader")
+	defer os.RemoveAll(dir)
+	path := dir + "/collector.lock"
+
+	a := NewFileLeaderLock(path, "a", time.Minute)
+	b := NewFileLeaderLock(path, "b", time.Minute)
+	if ok, err := a.Acquire(); !ok || err != nil {
+		t.Logf("First lock. Got: %v %v", ok, err)
+		t.Fail()
+	}
+	if ok, _ := b.Acquire(); ok {
+		t.Logf("Second copy took a held lock")
+		t.Fail()
+	}
+	if ok, _ := a.Acquire(); !ok {
+		t.Logf("Owner could not renew the lock")
+		t.Fail()
+	}
+
+	// An expired lock can be taken over
+	old := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(path, old, old)
+	if ok, _ := b.Acquire(); !ok {
+		t.Logf("Expired lock was not taken over")
+		t.Fail()
+	}
+	a.Release()
+	if ok, _ := a.Acquire(); ok {
+		t.Logf("Release by a non-owner removed the lock")
+		t.Fail()
+	}
+	b.Release()
+	if ok, _ := a.Acquire(); !ok {
+		t.Logf("Lock was not available after release")
+		t.Fail()
+	}
+
+	// Only one of several copies racing for an expired lock gets it
+	for round := 0; round < 20; round++ {
+		os.Chtimes(path, old, old)
+		var wg sync.WaitGroup
+		var winners int32
+		start := make(chan struct{})
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(l *FileLeaderLock) {
+				defer wg.Done()
+				<-start
+				if ok, _ := l.Acquire(); ok {
+					atomic.AddInt32(&winners, 1)
+				}
+			}(NewFileLeaderLock(path, fmt.Sprintf("racer%d", i), time.Minute))
+		}
+		close(start)
+		wg.Wait()
+		if winners != 1 {
+			t.Logf("Round %d: expired lock taken over by %d copies", round, winners)
+			t.Fail()
+			break
+		}
+	}
+
+	// A takeover file left by a copy that stopped is cleared once it has expired
+	c := NewFileLeaderLock(path, "c", time.Minute)
+	os.Chtimes(path, old, old)
+	ioutil.WriteFile(path+".takeover", nil, 0644)
+	if ok, _ := c.Acquire(); ok {
+		t.Logf("Lock taken over while another copy had the takeover file")
+		t.Fail()
+	}
+	os.Chtimes(path+".takeover", old, old)
+	c.Acquire()
+	if ok, _ := c.Acquire(); !ok {
+		t.Logf("Expired takeover file was not cleared")
+		t.Fail()
+	}
+
+	key := "leader"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	leader := false
+	ci.leader = LeaderCallbacks{AcquireFunc: func() (bool, error) { return leader, nil }}
+	if checkLeader(ci) || IsLeader() {
+		t.Logf("Callback lock reported leader")
+		t.Fail()
+	}
+	leader = true
+	if !checkLeader(ci) || !IsLeader() {
+		t.Logf("Callback lock did not report leader")
+		t.Fail()
+	}
+}
+
+func TestClassWildcardTopic(t *testing.T) {
+	prefix := "$SYS/MQ/INFO/QMGR/QM1/Monitor/"
+	cl := &MonClass{Name: "CPU", Types: map[int]*MonType{
+		0: {ObjectTopic: prefix + "CPU/SystemSummary"},
+		1: {ObjectTopic: prefix + "CPU/QMgrSummary"},
+	}}
+	if topic := classWildcardTopic(cl); topic != prefix+"CPU/#" {
+		t.Logf("CPU class. Expected: %s, Got: %s", prefix+"CPU/#", topic)
+		t.Fail()
+	}
+
+	cl = &MonClass{Name: "STATQ", Types: map[int]*MonType{
+		0: {ObjectTopic: prefix + "STATQ/%s/OPENCLOSE"},
+	}}
+	if topic := classWildcardTopic(cl); topic != "" {
+		t.Logf("STATQ class should not use a wildcard. Got: %s", topic)
+		t.Fail()
+	}
+}
+
+func TestSanitiseLabels(t *testing.T) {
+	tests := []struct {
+		in    string
+		style int
+		name  string
+		value string
+	}{
+		{"QM1   ", LABEL_STYLE_PROMETHEUS, "QM1", "QM1"},
+		{"9.20.1.1(1414)", LABEL_STYLE_PROMETHEUS, "_9_20_1_1_1414_", "9.20.1.1(1414)"},
+		{"a b,c=d\x00", LABEL_STYLE_INFLUX, `a\ b\,c\=d`, `a\ b\,c\=d`},
+		{"app:1|x.y", LABEL_STYLE_STATSD, "app_1_x_y", "app_1_x.y"},
+		{"   ", LABEL_STYLE_STATSD, "", DUMMY_STRING},
+	}
+
+	for _, tc := range tests {
+		if n := SanitiseLabelName(tc.in, tc.style); n != tc.name {
+			t.Logf("Name for %q style %d. Expected: %s, Got: %s", tc.in, tc.style, tc.name, n)
+			t.Fail()
+		}
+		if v := SanitiseLabelValue(tc.in, tc.style); v != tc.value {
+			t.Logf("Value for %q style %d. Expected: %s, Got: %s", tc.in, tc.style, tc.value, v)
+			t.Fail()
+		}
+	}
+}
+
+func TestChannelAggregation(t *testing.T) {
+	if _, err := parseChannelAggregation("avg"); err == nil {
+		t.Logf("Invalid aggregation was accepted")
+		t.Fail()
+	}
+
+	st := new(StatusSet)
+	st.Attributes = make(map[string]*StatusAttribute)
+	for _, a := range []string{ATTR_CHL_NAME, ATTR_CHL_CONNNAME, ATTR_CHL_JOBNAME, ATTR_CHL_RQMNAME} {
+		st.Attributes[a] = newPseudoStatusAttribute(a, a)
+	}
+	st.Attributes[ATTR_CHL_MESSAGES] = newStatusAttribute(ATTR_CHL_MESSAGES, "", 0)
+	st.Attributes[ATTR_CHL_MESSAGES].delta = true
+	st.Attributes[ATTR_CHL_SINCE_MSG] = newStatusAttribute(ATTR_CHL_SINCE_MSG, "", 0)
+
+	for i, job := range []string{"0001", "0002"} {
+		key := "SVR/host(1414)/-/" + job
+		st.Attributes[ATTR_CHL_NAME].Values[key] = newStatusValueString("SVR")
+		st.Attributes[ATTR_CHL_CONNNAME].Values[key] = newStatusValueString("host(1414)")
+		st.Attributes[ATTR_CHL_JOBNAME].Values[key] = newStatusValueString(job)
+		st.Attributes[ATTR_CHL_RQMNAME].Values[key] = newStatusValueString(DUMMY_STRING)
+		st.Attributes[ATTR_CHL_MESSAGES].Values[key] = newStatusValueInt64(int64(10 * (i + 1)))
+		st.Attributes[ATTR_CHL_SINCE_MSG].Values[key] = newStatusValueInt64(int64(5 - i))
+	}
+
+	aggregateChannelInstances(st, CHL_AGGREGATE_SUM)
+	key := "SVR/-/-/-"
+	if len(st.Attributes[ATTR_CHL_NAME].Values) != 1 {
+		t.Fatalf("Expected one channel entry, Got: %v", st.Attributes[ATTR_CHL_NAME].Values)
+	}
+	if v := st.Attributes[ATTR_CHL_MESSAGES].Values[key]; v == nil || v.ValueInt64 != 30 {
+		t.Logf("Messages. Expected 30, Got: %+v", v)
+		t.Fail()
+	}
+	if v := st.Attributes[ATTR_CHL_SINCE_MSG].Values[key]; v == nil || v.ValueInt64 != 4 {
+		t.Logf("Time since message. Expected 4, Got: %+v", v)
+		t.Fail()
+	}
+	if c, j := st.Attributes[ATTR_CHL_CONNNAME].Values[key], st.Attributes[ATTR_CHL_JOBNAME].Values[key]; c.ValueString != "host(1414)" || j.ValueString != DUMMY_STRING {
+		t.Logf("Labels. Got: %s %s", c.ValueString, j.ValueString)
+		t.Fail()
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	st := new(StatusSet)
+	st.Attributes = make(map[string]*StatusAttribute)
+	st.Attributes[ATTR_CHL_MESSAGES] = newStatusAttribute(ATTR_CHL_MESSAGES, "", ibmmq.MQIACH_MSGS)
+	st.Attributes[ATTR_CHL_MESSAGES].delta = true
+	st.Attributes[ATTR_CHL_BYTES_SENT] = newStatusAttribute(ATTR_CHL_BYTES_SENT, "", ibmmq.MQIACH_BYTES_SENT)
+	st.Attributes[ATTR_CHL_BYTES_SENT].delta = true
+
+	// Returns the deltas for the messages and bytes counters
+	collect := func(start string, msgs int64, bytes int64) (int64, int64) {
+		statusClearValues(st)
+		statusCheckEpoch(st, "CHL", start)
+		elems := []*ibmmq.PCFParameter{
+			{Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIACH_MSGS, Int64Value: []int64{msgs}},
+			{Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIACH_BYTES_SENT, Int64Value: []int64{bytes}},
+		}
+		cfh := ibmmq.NewMQCFH()
+		buf := []byte{}
+		for _, elem := range elems {
+			buf = append(buf, elem.Bytes()...)
+		}
+		statusCheckCounters(st, cfh, buf, "CHL")
+		for _, elem := range elems {
+			statusGetIntAttributes(st, elem, "CHL")
+		}
+		return st.Attributes[ATTR_CHL_MESSAGES].Values["CHL"].ValueInt64, st.Attributes[ATTR_CHL_BYTES_SENT].Values["CHL"].ValueInt64
+	}
+
+	collect("2023-01-01 10.00.00", 100, 1000)
+	if d, _ := collect("2023-01-01 10.00.00", 150, 1500); d != 50 || st.CounterReset("CHL") {
+		t.Logf("Same start time. Expected delta 50, Got: %d %v", d, st.CounterReset("CHL"))
+		t.Fail()
+	}
+	// Restarted, and has already done more work than before
+	if d, _ := collect("2023-01-01 11.00.00", 200, 2000); d != 200 || !st.CounterReset("CHL") {
+		t.Logf("Restart. Expected delta 200, Got: %d %v", d, st.CounterReset("CHL"))
+		t.Fail()
+	}
+	// A decreasing total is also treated as a reset, even when it is not the first
+	// counter in the message. The earlier counter is then reported from zero too.
+	if d, b := collect("", 250, 20); d != 250 || b != 20 || !st.CounterReset("CHL") {
+		t.Logf("Decrease. Expected deltas 250 20, Got: %d %d %v", d, b, st.CounterReset("CHL"))
+		t.Fail()
+	}
+}
+
+func TestPublicationArchive(t *testing.T) {
+	metrics := &AllMetrics{Classes: map[int]*MonClass{
+		1: {Types: map[int]*MonType{
+			2: {ObjectTopic: "$SYS/MQ/INFO/QMGR/QM1/Monitor/STATQ/%s/GENERAL"},
+		}},
+	}}
+	topic := publicationTopic(metrics, 1, 2, "APP.Q")
+	if topic != "$SYS/MQ/INFO/QMGR/QM1/Monitor/STATQ/APP.Q/GENERAL" || publicationTopic(metrics, 1, 3, "") != "" {
+		t.Logf("Topic. Got: %s", topic)
+		t.Fail()
+	}
+
+	f, err := ioutil.TempFile("", "archive")
+	if err != nil {
+		t.Fatalf("Cannot create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	a := &pubArchive{f: f, enc: json.NewEncoder(f)}
+	a.interval()
+	a.publication(topic, []byte{1, 2, 3})
+	f.Close()
+
+	b, _ := ioutil.ReadFile(f.Name())
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var r ReplayRecord
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &r) != nil || r.Kind != REPLAY_PUB || r.Topic != topic || len(r.Data) != 3 {
+		t.Logf("Archive contents. Got: %v", lines)
+		t.Fail()
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	metrics := &AllMetrics{Classes: map[int]*MonClass{
+		1: {Name: "STATQ", typesTopic: "types", Types: map[int]*MonType{
+			2: {Name: "GENERAL", ObjectTopic: "$SYS/MQ/INFO/QMGR/QM1/Monitor/STATQ/%s/GENERAL", Elements: map[int]*MonElement{
+				3: {Description: "Queue depth", Datatype: ibmmq.MQIAMO_MONITOR_UNIT},
+			}},
+		}},
+	}}
+
+	f, err := ioutil.TempFile("", "metacache")
+	if err != nil {
+		t.Fatalf("Cannot create file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	key := &metadataCache{Version: metadataCacheVersion, QMgrName: "QM1", CommandLevel: 930}
+	if err = saveMetadataCache(f.Name(), key, metrics); err != nil {
+		t.Fatalf("Cannot save cache: %v", err)
+	}
+
+	loaded := &AllMetrics{Classes: make(map[int]*MonClass)}
+	if !loadMetadataCache(f.Name(), &metadataCache{Version: metadataCacheVersion, QMgrName: "QM1", CommandLevel: 930}, loaded) {
+		t.Fatalf("Cache was not loaded")
+	}
+	elem := loaded.Classes[1].Types[2].Elements[3]
+	if elem.MetricName != "queue_depth" || elem.Parent.ObjectTopic != metrics.Classes[1].Types[2].ObjectTopic || elem.Values == nil {
+		t.Logf("Loaded element. Got: %+v", elem)
+		t.Fail()
+	}
+
+	loaded = &AllMetrics{Classes: make(map[int]*MonClass)}
+	if loadMetadataCache(f.Name(), &metadataCache{Version: metadataCacheVersion, QMgrName: "QM1", CommandLevel: 940}, loaded) {
+		t.Logf("Cache was loaded for a different command level")
+		t.Fail()
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := OpenJournal(JournalConfig{Directory: dir, MaxFileSize: 200})
+	if err != nil {
+		t.Fatalf("Cannot open journal: %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	var samples []JournalSample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, JournalSample{Time: now.Add(time.Duration(i) * time.Second), Name: "qdepth", Labels: map[string]string{"queue": "APP.Q"}, Value: float64(i)})
+	}
+	if err = j.Write(samples); err != nil {
+		t.Fatalf("Cannot write journal: %v", err)
+	}
+	j.Close()
+	if j.Pending() < 2 {
+		t.Logf("Expected the journal to roll. Got %d files", j.Pending())
+		t.Fail()
+	}
+
+	// A failed send keeps everything for the next attempt
+	if _, err = j.Backfill(func([]JournalSample) error { return fmt.Errorf("Unavailable") }); err == nil {
+		t.Logf("Backfill did not return the send error")
+		t.Fail()
+	}
+
+	var got []JournalSample
+	n, err := j.Backfill(func(b []JournalSample) error { got = append(got, b...); return nil })
+	if err != nil || n != len(samples) || j.Pending() != 0 {
+		t.Fatalf("Backfill. Got: %d %v, Pending: %d", n, err, j.Pending())
+	}
+	for i := range got {
+		if !got[i].Time.Equal(samples[i].Time) || got[i].Value != samples[i].Value || got[i].Labels["queue"] != "APP.Q" {
+			t.Logf("Sample %d. Expected: %+v, Got: %+v", i, samples[i], got[i])
+			t.Fail()
+		}
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	c := new(clockSkew)
+	if _, ok := c.estimate(); ok {
+		t.Logf("Estimate returned before any publications")
+		t.Fail()
+	}
+
+	now := time.Now()
+	c.observe(now.Add(-8*time.Second), now)
+	c.observe(now.Add(-3*time.Second), now)
+	c.endInterval()
+	c.observe(now.Add(-5*time.Second), now)
+	c.endInterval()
+	if skew, ok := c.estimate(); !ok || skew != 3*time.Second {
+		t.Logf("Skew. Expected: 3s, Got: %v", skew)
+		t.Fail()
+	}
+
+	// The queue manager clock is ahead
+	c.observe(now.Add(40*time.Second), now)
+	c.endInterval()
+	if skew, _ := c.estimate(); skew != -40*time.Second || !c.warned {
+		t.Logf("Skew. Expected: -40s with a warning, Got: %v %v", skew, c.warned)
+		t.Fail()
+	}
+}
+
+func TestModel(t *testing.T) {
+	key := "modeltest"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	ci.si.resolvedQMgrName = "QM1"
+
+	elem := &MonElement{MetricName: "cpu_load", Datatype: ibmmq.MQIAMO_MONITOR_PERCENT, Values: map[string]int64{QMgrMapKey: 250}}
+	ci.publishedMetrics.Classes = map[int]*MonClass{
+		0: {Name: "CPU", Types: map[int]*MonType{0: {Name: "SystemSummary", Elements: map[int]*MonElement{0: elem}}}},
+	}
+	st := GetObjectStatus(key, OT_Q)
+	st.Attributes = map[string]*StatusAttribute{"depth": newStatusAttribute("depth", "Queue Depth", -1)}
+	st.Attributes["depth"].Values["APP.Q"] = newStatusValueInt64(7)
+	ci.objectStatus[OT_Q].init = true
+
+	m := GetModel()
+	if m == nil || m.Version != ModelVersion || m.QMgrName != "QM1" || len(m.Metrics) != 2 {
+		t.Fatalf("Model. Got: %+v", m)
+	}
+	p := m.Metrics[0]
+	if p.Source != MODEL_SOURCE_PUBLICATION || p.ObjectType != "qmgr" || len(p.Values) != 1 || p.Values[0].Object != "" || p.Values[0].Value != 2.5 {
+		t.Logf("Published metric. Got: %+v", p)
+		t.Fail()
+	}
+	q := m.Metrics[1]
+	if q.Source != MODEL_SOURCE_STATUS || q.ObjectType != "queue" || len(q.Values) != 1 || q.Values[0].Object != "APP.Q" || q.Values[0].Value != 7 {
+		t.Logf("Status metric. Got: %+v", q)
+		t.Fail()
+	}
+}
+
+func TestPropertyCounts(t *testing.T) {
+	pc := &PropertyCounts{QName: "APP.Q", Counts: make(map[string]int)}
+	for i := 0; i < maxPropertyValues+10; i++ {
+		pc.add(propertyValueString(fmt.Sprintf("unit%d ", i)))
+	}
+	pc.add(propertyValueString("unit0"))
+	pc.add(PROPERTY_NOT_SET)
+
+	if pc.Browsed != maxPropertyValues+12 {
+		t.Logf("Browsed count. Got: %d", pc.Browsed)
+		t.Fail()
+	}
+	if pc.Counts["unit0"] != 2 || pc.Counts[PROPERTY_OTHER] != 11 {
+		t.Logf("Counts. Got: unit0=%d other=%d", pc.Counts["unit0"], pc.Counts[PROPERTY_OTHER])
+		t.Fail()
+	}
+	if len(pc.Counts) != maxPropertyValues+1 {
+		t.Logf("Distinct values. Got: %d", len(pc.Counts))
+		t.Fail()
+	}
+	if s := propertyValueString([]byte{0x01, 0xab}); s != "01ab" {
+		t.Logf("Byte value. Got: %s", s)
+		t.Fail()
+	}
+	if s := propertyValueString(int64(42)); s != "42" {
+		t.Logf("Integer value. Got: %s", s)
+		t.Fail()
+	}
+}
+
+func TestMsgAgeSecs(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	if age := msgAgeSecs(now, now.Add(-90*time.Second)); age != 90 {
+		t.Logf("Age. Got: %d Expected: 90", age)
+		t.Fail()
+	}
+	if age := msgAgeSecs(now, now.Add(5*time.Second)); age != 0 {
+		t.Logf("Future put time. Got: %d Expected: 0", age)
+		t.Fail()
+	}
+	if age := msgAgeSecs(now, time.Time{}); age != 0 {
+		t.Logf("No put time. Got: %d Expected: 0", age)
+		t.Fail()
+	}
+}
+
+func TestQueueInhibitAttributes(t *testing.T) {
+	key := "inhibit"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	QueueInitAttributes()
+
+	ci.qInfoMap = map[string]*ObjInfo{"APP.Q": new(ObjInfo), "OTHER.Q": new(ObjInfo)}
+
+	r := benchPCF(ibmmq.MQCFT_RESPONSE,
+		benchString(ibmmq.MQCA_Q_NAME, "APP.Q"),
+		benchInt(ibmmq.MQIA_INHIBIT_PUT, int64(ibmmq.MQQA_PUT_INHIBITED)),
+		benchInt(ibmmq.MQIA_INHIBIT_GET, int64(ibmmq.MQQA_GET_ALLOWED)),
+		benchInt(ibmmq.MQIA_TRIGGER_CONTROL, int64(ibmmq.MQTC_ON)))
+	cfh, offset := ibmmq.ReadPCFHeader(r)
+	parseQAttrData(cfh, r[offset:])
+
+	for _, qName := range []string{"APP.Q", "OTHER.Q"} {
+		r = benchPCF(ibmmq.MQCFT_RESPONSE, benchString(ibmmq.MQCA_Q_NAME, qName))
+		cfh, offset = ibmmq.ReadPCFHeader(r)
+		parseQData(ibmmq.MQOT_Q, cfh, r[offset:])
+	}
+
+	st := GetObjectStatus(key, OT_Q)
+	put := st.Attributes[ATTR_Q_PUT_INHIBITED].Values["APP.Q"]
+	get := st.Attributes[ATTR_Q_GET_INHIBITED].Values["APP.Q"]
+	trig := st.Attributes[ATTR_Q_TRIGGER_CONTROL].Values["APP.Q"]
+	if put == nil || put.ValueInt64 != 1 || get == nil || get.ValueInt64 != 0 || trig == nil || trig.ValueInt64 != 1 {
+		t.Logf("Inhibit values. Got: %v %v %v", put, get, trig)
+		t.Fail()
+	}
+	// Nothing is reported for a queue whose attributes were not inquired
+	if _, ok := st.Attributes[ATTR_Q_PUT_INHIBITED].Values["OTHER.Q"]; ok {
+		t.Logf("Unexpected value for OTHER.Q")
+		t.Fail()
+	}
+}
+
+func TestChannelInfo(t *testing.T) {
+	key := "chlinfo"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	ci.chlInfoMap = make(map[string]*ObjInfo)
+
+	r := benchPCF(ibmmq.MQCFT_RESPONSE,
+		benchString(ibmmq.MQCACH_CHANNEL_NAME, "APP.SVRCONN"),
+		benchInt(ibmmq.MQIACH_MAX_MSG_LENGTH, 4194304),
+		benchString(ibmmq.MQCACH_MCA_USER_ID, "appuser     "),
+		benchInt(ibmmq.MQIACH_SSL_CLIENT_AUTH, int64(ibmmq.MQSCA_REQUIRED)),
+		benchString(ibmmq.MQCACH_SSL_CIPHER_SPEC, ""),
+		benchString(ibmmq.MQCACH_MSG_EXIT_NAME, "exit1(fn)   "))
+	cfh, offset := ibmmq.ReadPCFHeader(r)
+	parseChannelAttrData(cfh, r[offset:], ci.chlInfoMap)
+
+	m := GetChannelInfo("APP.SVRCONN")
+	expected := map[string]string{
+		CHL_INFO_MCAUSER:  "appuser",
+		CHL_INFO_SSLCAUTH: "REQUIRED",
+		CHL_INFO_SSLCIPH:  DUMMY_STRING,
+		CHL_INFO_MSGEXIT:  "exit1(fn)",
+		CHL_INFO_MAXMSGL:  "4194304",
+	}
+	if len(m) != len(expected) {
+		t.Logf("Channel info. Got: %v", m)
+		t.Fail()
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Logf("Channel info %s. Got: %s Expected: %s", k, m[k], v)
+			t.Fail()
+		}
+	}
+	if GetChannelInfo("UNKNOWN") != nil {
+		t.Logf("Unknown channel returned info")
+		t.Fail()
+	}
+
+	// Each connection has its own discovered objects
+	newConnectionInfo("chlinfo2")
+	SetConnectionKey("chlinfo2")
+	if GetChannelInfo("APP.SVRCONN") != nil {
+		t.Logf("Channel info seen from another connection")
+		t.Fail()
+	}
+}
+
+func TestMQIPTStatus(t *testing.T) {
+	key := "mqipt"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// Find a port that is not in use for the second route
+	l2, _ := net.Listen("tcp", "127.0.0.1:0")
+	downAddr := l2.Addr().String()
+	l2.Close()
+	_, downPort, _ := net.SplitHostPort(downAddr)
+
+	f, _ := ioutil.TempFile("", "mqipt")
+	defer os.Remove(f.Name())
+	f.WriteString("old connection on port " + port + "\n")
+
+	err = SetMQIPTConfig(MQIPTConfig{
+		Routes:            []MQIPTRoute{{"UP", l.Addr().String()}, {"DOWN", downAddr}},
+		ConnectTimeout:    time.Second,
+		ConnectionLog:     f.Name(),
+		ConnectionPattern: `connection on port (?P<port>\d+)`,
+	})
+	if err != nil {
+		t.Fatalf("SetMQIPTConfig: %v", err)
+	}
+	f.WriteString("connection on port " + port + "\nconnection on port " + downPort + "\nconnection on port " + port + "\nconnection on port " + port)
+	f.Close()
+
+	if err = CollectMQIPTStatus(); err != nil {
+		t.Fatalf("CollectMQIPTStatus: %v", err)
+	}
+	st := GetObjectStatus(key, OT_MQIPT)
+	if v := st.Attributes[ATTR_MQIPT_STATUS].Values["UP"]; v == nil || v.ValueInt64 != 1 {
+		t.Logf("UP route status. Got: %v", v)
+		t.Fail()
+	}
+	if v := st.Attributes[ATTR_MQIPT_STATUS].Values["DOWN"]; v == nil || v.ValueInt64 != 0 {
+		t.Logf("DOWN route status. Got: %v", v)
+		t.Fail()
+	}
+	// The old line and the incomplete last line are not counted
+	if v := st.Attributes[ATTR_MQIPT_CONNECTIONS].Values["UP"]; v == nil || v.ValueInt64 != 2 {
+		t.Logf("UP route connections. Got: %v", v)
+		t.Fail()
+	}
+	if v := st.Attributes[ATTR_MQIPT_CONNECTIONS].Values["DOWN"]; v == nil || v.ValueInt64 != 1 {
+		t.Logf("DOWN route connections. Got: %v", v)
+		t.Fail()
+	}
+
+	if SetMQIPTConfig(MQIPTConfig{Routes: []MQIPTRoute{{"R", "noport"}}}) == nil {
+		t.Logf("Address without a port was accepted")
+		t.Fail()
+	}
+	if SetMQIPTConfig(MQIPTConfig{ConnectionLog: f.Name(), ConnectionPattern: "connection"}) == nil {
+		t.Logf("Pattern without a port group was accepted")
+		t.Fail()
+	}
+}
+
+func TestApplianceHAStatus(t *testing.T) {
+	key := "applianceha"
+	ci := newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	ci.si.resolvedQMgrName = "HAQM1"
+
+	// Nothing is collected until there is a configuration
+	if err := CollectApplianceHAStatus(); err != nil {
+		t.Fatalf("CollectApplianceHAStatus: %v", err)
+	}
+	st := GetObjectStatus(key, OT_APPLIANCE_HA)
+	if len(st.Attributes[ATTR_APPLIANCE_HA_ROLE].Values) != 0 {
+		t.Logf("Values collected without a configuration")
+		t.Fail()
+	}
+
+	out := ""
+	asked := ""
+	SetApplianceHAConfig(ApplianceHAConfig{Group: "HAGRP", Status: func(qMgrName string) (string, error) {
+		asked = qMgrName
+		if out == "" {
+			return "", fmt.Errorf("status failed")
+		}
+		return out, nil
+	}})
+
+	testCases := []struct {
+		name       string
+		out        string
+		role       int64
+		status     int64
+		current    string
+		onPref     int64 // -1 for no value
+		progress   float64
+		noProgress bool
+	}{
+		{"in sync", `Queue manager name:             HAQM1
+Queue manager status:           Running
+HA role:                        Primary
+HA status:                      Normal
+HA control:                     Enabled
+HA preferred location:          This appliance`,
+			APPLIANCE_HA_ROLE_PRIMARY, APPLIANCE_HA_STATUS_NORMAL, "This appliance", 1, 100, false},
+		{"synchronizing on the other appliance", `Queue manager name:             HAQM1
+Queue manager status:           Running elsewhere
+HA role:                        Secondary
+HA status:                      Synchronization in progress
+HA synchronization progress:    54.6%
+HA preferred location:          This appliance`,
+			APPLIANCE_HA_ROLE_SECONDARY, APPLIANCE_HA_STATUS_SYNC_IN_PROGRESS, "Other appliance", 0, 54.6, false},
+		{"no preferred location", `HA role:                        Primary
+HA status:                      Remote appliance(s) unavailable
+HA current location:            This appliance
+HA preferred location:          None`,
+			APPLIANCE_HA_ROLE_PRIMARY, APPLIANCE_HA_STATUS_REMOTE_UNAVAILABLE, "This appliance", -1, 0, true},
+	}
+
+	for _, tc := range testCases {
+		out = tc.out
+		if err := CollectApplianceHAStatus(); err != nil {
+			t.Fatalf("%s: CollectApplianceHAStatus: %v", tc.name, err)
+		}
+		if asked != "HAQM1" {
+			t.Logf("%s: status asked for %q", tc.name, asked)
+			t.Fail()
+		}
+		if v := st.Attributes[ATTR_APPLIANCE_HA_GROUP].Values["HAQM1"]; v == nil || v.ValueString != "HAGRP" {
+			t.Logf("%s: group. Got: %v", tc.name, v)
+			t.Fail()
+		}
+		if v := st.Attributes[ATTR_APPLIANCE_HA_ROLE].Values["HAQM1"]; v == nil || v.ValueInt64 != tc.role {
+			t.Logf("%s: role. Expected: %d Got: %v", tc.name, tc.role, v)
+			t.Fail()
+		}
+		if v := st.Attributes[ATTR_APPLIANCE_HA_STATUS].Values["HAQM1"]; v == nil || v.ValueInt64 != tc.status {
+			t.Logf("%s: status. Expected: %d Got: %v", tc.name, tc.status, v)
+			t.Fail()
+		}
+		if v := st.Attributes[ATTR_APPLIANCE_HA_CURRENT_LOCATION].Values["HAQM1"]; v == nil || v.ValueString != tc.current {
+			t.Logf("%s: current location. Expected: %s Got: %v", tc.name, tc.current, v)
+			t.Fail()
+		}
+		v := st.Attributes[ATTR_APPLIANCE_HA_ON_PREFERRED].Values["HAQM1"]
+		if (tc.onPref < 0 && v != nil) || (tc.onPref >= 0 && (v == nil || v.ValueInt64 != tc.onPref)) {
+			t.Logf("%s: on preferred. Expected: %d Got: %v", tc.name, tc.onPref, v)
+			t.Fail()
+		}
+		v = st.Attributes[ATTR_APPLIANCE_HA_SYNC_PROGRESS].Values["HAQM1"]
+		if (tc.noProgress && v != nil) || (!tc.noProgress && (v == nil || v.ValueFloat64 != tc.progress)) {
+			t.Logf("%s: progress. Expected: %f Got: %v", tc.name, tc.progress, v)
+			t.Fail()
+		}
+	}
+
+	// A queue manager that is not in an HA group has no HA fields
+	out = "Queue manager name:             QM2\nQueue manager status:           Running\n"
+	if err := CollectApplianceHAStatus(); err != nil || len(st.Attributes[ATTR_APPLIANCE_HA_ROLE].Values) != 0 {
+		t.Logf("Queue manager without HA. Got: %v %v", err, st.Attributes[ATTR_APPLIANCE_HA_ROLE].Values)
+		t.Fail()
+	}
+
+	out = ""
+	if err := CollectApplianceHAStatus(); err == nil {
+		t.Logf("Error from the status command was not returned")
+		t.Fail()
+	}
+}
+
+func TestPCFTrace(t *testing.T) {
+	names := map[int32]string{ibmmq.MQCMD_CHANGE_CHANNEL: "MQCMD_CHANGE_CHANNEL", ibmmq.MQCACH_CHANNEL_NAME: "MQCACH_CHANNEL_NAME"}
+	defer func(f func(string, int32) string) { pcfTraceName = f }(pcfTraceName)
+	pcfTraceName = func(class string, v int32) string { return names[v] }
+
+	var traced []string
+	SetLogger(&Logger{Trace: func(format string, v ...interface{}) { traced = append(traced, fmt.Sprintf(format, v...)) }})
+	defer SetLogger(nil)
+
+	cfh := ibmmq.NewMQCFH()
+	cfh.Command = ibmmq.MQCMD_CHANGE_CHANNEL
+	var buf []byte
+	for _, p := range []*ibmmq.PCFParameter{
+		{Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCACH_CHANNEL_NAME, String: []string{"TO.QM2"}},
+		{Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCACH_PASSWORD, String: []string{"secret"}},
+		{Type: ibmmq.MQCFT_INTEGER_LIST, Parameter: ibmmq.MQIACF_Q_ATTRS, Int64Value: []int64{1, 2}},
+	} {
+		cfh.ParameterCount++
+		buf = append(buf, p.Bytes()...)
+	}
+	buf = append(cfh.Bytes(), buf...)
+
+	// Nothing is logged until the trace is turned on
+	tracePCF("command", buf)
+	SetPCFTrace(true)
+	defer SetPCFTrace(false)
+	tracePCF("command", buf)
+
+	expected := fmt.Sprintf(`PCF command: MQCMD_CHANGE_CHANNEL MQCACH_CHANNEL_NAME="TO.QM2" %d=*** %d=[1,2]`, ibmmq.MQCACH_PASSWORD, ibmmq.MQIACF_Q_ATTRS)
+	if len(traced) != 1 || traced[0] != expected {
+		t.Logf("PCF trace. Expected: %s Got: %v", expected, traced)
+		t.Fail()
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	key := "auth"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	recordAuthFailure(AUTH_OP_PUT, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_NOT_AUTHORIZED})
+	recordAuthFailure(AUTH_OP_PUT, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_NOT_AUTHORIZED})
+	recordAuthFailure(AUTH_OP_CONNECT, MQMetricError{Err: "connect", MQReturn: &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_SSL_INITIALIZATION_ERROR}})
+	// Not security-related, so not counted
+	recordAuthFailure(AUTH_OP_GET, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_NO_MSG_AVAILABLE})
+
+	cfh := ibmmq.NewMQCFH()
+	cfh.Type = ibmmq.MQCFT_EVENT
+	cfh.Command = ibmmq.MQCMD_Q_MGR_EVENT
+	cfh.Reason = ibmmq.MQRC_NOT_AUTHORIZED
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = ibmmq.MQIACF_REASON_QUALIFIER
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQRQ_CSP_NOT_AUTHORIZED)}
+	cfh.ParameterCount = 1
+	if op, ok := parseAuthEvent(append(cfh.Bytes(), pcfparm.Bytes()...)); !ok || op != "connect_authentication" {
+		t.Logf("Authority event operation. Got: %s %v", op, ok)
+		t.Fail()
+	}
+	cfh.Reason = ibmmq.MQRC_UNKNOWN_OBJECT_NAME
+	if _, ok := parseAuthEvent(append(cfh.Bytes(), pcfparm.Bytes()...)); ok {
+		t.Logf("Other queue manager event was counted")
+		t.Fail()
+	}
+
+	if err := CollectAuthStatus(); err != nil {
+		t.Fatalf("CollectAuthStatus: %v", err)
+	}
+	st := GetObjectStatus(key, OT_AUTH)
+	if len(st.Attributes[ATTR_AUTH_FAILURES].Values) != 2 {
+		t.Logf("Expected 2 failure counts. Got: %d", len(st.Attributes[ATTR_AUTH_FAILURES].Values))
+		t.Fail()
+	}
+	if v := st.Attributes[ATTR_AUTH_FAILURES].Values["collector/put/2035"]; v == nil || v.ValueInt64 != 2 {
+		t.Logf("Put failures. Got: %v", v)
+		t.Fail()
+	}
+	if v := st.Attributes[ATTR_AUTH_REASON].Values["collector/connect/2393"]; v == nil || v.ValueString != "MQRC_SSL_INITIALIZATION_ERROR" {
+		t.Logf("Connect failure reason. Got: %v", v)
+		t.Fail()
+	}
+}
+func TestEnums(t *testing.T) {
+	if s := EnumString(ENUM_CHL_STATUS, int64(ibmmq.MQCHS_RUNNING)); s != "running" {
+		t.Logf("Channel status name. Got: %s", s)
+		t.Fail()
+	}
+	if s := EnumString(ENUM_CHL_SUBSTATE, 99999); s != "99999" {
+		t.Logf("Unknown substate name. Got: %s", s)
+		t.Fail()
+	}
+	for _, name := range []string{"q_mgr", "MQMON_Q_MGR", " Q_MGR "} {
+		if v, ok := EnumValue(ENUM_Q_MONITORING, name); !ok || v != int64(ibmmq.MQMON_Q_MGR) {
+			t.Logf("Monitoring level value for %s. Got: %d %v", name, v, ok)
+			t.Fail()
+		}
+	}
+	if _, ok := EnumValue(ENUM_Q_NPM_CLASS, "medium"); ok {
+		t.Logf("Unknown NPMCLASS name was accepted")
+		t.Fail()
+	}
+	if len(EnumValues(ENUM_CHL_STATUS)) != 12 {
+		t.Logf("Expected 12 channel status values. Got: %d", len(EnumValues(ENUM_CHL_STATUS)))
+		t.Fail()
+	}
+
+	// A negative enumerated value is not changed to 0
+	attr := newStatusAttribute(ATTR_Q_MONITORING, "Queue Monitoring Level", -1)
+	attr.enum = ENUM_Q_MONITORING
+	if f := QueueNormalise(attr, int64(ibmmq.MQMON_Q_MGR)); f != float64(ibmmq.MQMON_Q_MGR) {
+		t.Logf("Normalised monitoring level. Got: %f", f)
+		t.Fail()
+	}
+	if s := attr.EnumString(int64(ibmmq.MQMON_HIGH)); s != "high" {
+		t.Logf("Attribute enum name. Got: %s", s)
+		t.Fail()
+	}
+}
+
+func TestQueueMonitoring(t *testing.T) {
+	off := int64(ibmmq.MQMON_OFF)
+	none := int64(ibmmq.MQMON_NONE)
+	medium := int64(ibmmq.MQMON_MEDIUM)
+
+	tests := []struct {
+		queue   int64
+		qmgr    *int64
+		off     bool
+		fixable bool
+	}{
+		{int64(ibmmq.MQMON_OFF), nil, true, true},
+		{int64(ibmmq.MQMON_Q_MGR), nil, false, false},
+		{int64(ibmmq.MQMON_Q_MGR), &off, true, true},
+		{int64(ibmmq.MQMON_Q_MGR), &medium, false, false},
+		{int64(ibmmq.MQMON_HIGH), &off, false, false},
+		{int64(ibmmq.MQMON_HIGH), &none, true, false},
+	}
+	for i, tc := range tests {
+		o, f := monitoringOff(tc.queue, tc.qmgr)
+		if o != tc.off || f != tc.fixable {
+			t.Logf("Test %d: Expected %v %v. Got: %v %v", i, tc.off, tc.fixable, o, f)
+			t.Fail()
+		}
+	}
+
+	m, err := parseManageMonitoring(" monq, STATQ")
+	if err != nil || len(m) != 2 || !m[ibmmq.MQIA_MONITORING_Q] || !m[ibmmq.MQIA_STATISTICS_Q] {
+		t.Logf("ManageMonitoring list. Got: %v %v", m, err)
+		t.Fail()
+	}
+	if _, err = parseManageMonitoring("MONQ,MONCHL"); err == nil {
+		t.Logf("Unknown ManageMonitoring value was accepted")
+		t.Fail()
+	}
+
+	// STATQ and NPMCLASS are not asked for on z/OS
+	if len(queueMonitoringSelectors(ibmmq.MQPL_ZOS)) != 2 || len(queueMonitoringSelectors(ibmmq.MQPL_UNIX)) != 4 {
+		t.Logf("Queue monitoring selectors. Got: %v %v", queueMonitoringSelectors(ibmmq.MQPL_ZOS), queueMonitoringSelectors(ibmmq.MQPL_UNIX))
+		t.Fail()
+	}
+}
+
+func TestPublicationWindow(t *testing.T) {
+	w := new(pubWindow)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := base.Add(15 * time.Second)
+
+	// Two whole intervals, and the start of a third that is still arriving
+	w.start()
+	for _, d := range []time.Duration{0, 100 * time.Millisecond, 10 * time.Second, 10*time.Second + 200*time.Millisecond, 20 * time.Second} {
+		putTime := base.Add(d)
+		if !w.hold([]byte("pub"), putTime, cutoff) {
+			w.used(putTime, 10000000)
+		}
+	}
+	w.end()
+
+	pw := w.window
+	if pw.Intervals != 2 || pw.Carried != 1 || !pw.Start.Equal(base.Add(-10*time.Second)) || !pw.End.Equal(base.Add(10*time.Second+200*time.Millisecond)) {
+		t.Logf("First window. Got: %+v", pw)
+		t.Fail()
+	}
+
+	// The kept publication comes back first in the next collection
+	w.start()
+	data, putTime, ok := w.next()
+	if !ok || string(data) != "pub" || !putTime.Equal(base.Add(20*time.Second)) {
+		t.Logf("Carried publication. Got: %s %v %v", data, putTime, ok)
+		t.Fail()
+	}
+	if _, _, ok = w.next(); ok {
+		t.Logf("Only one publication should have been carried")
+		t.Fail()
+	}
+
+	// Publications without a put time are never kept back
+	if w.hold([]byte("pub"), time.Time{}, cutoff) {
+		t.Logf("Publication without a put time was kept back")
+		t.Fail()
+	}
+}
+
+func TestObjectAliasing(t *testing.T) {
+	f, _ := ioutil.TempFile("", "aliases")
+	f.Close()
+	defer os.Remove(f.Name())
+	defer SetObjectAliasing(nil)
+
+	if SetObjectAliasing(&ObjectAliasConfig{}) == nil {
+		t.Logf("Aliasing without a salt was accepted")
+		t.Fail()
+	}
+
+	cfg := &ObjectAliasConfig{Salt: "secret", MappingFile: f.Name(), Prefix: "q_", Length: 8}
+	if err := SetObjectAliasing(cfg); err != nil {
+		t.Fatalf("SetObjectAliasing: %v", err)
+	}
+	a1 := AliasObjectName("PAYROLL.IN  ")
+	a2 := AliasObjectName("PAYROLL.OUT")
+	if !strings.HasPrefix(a1, "q_") || len(a1) != 10 || a1 == a2 || strings.Contains(a1, "PAYROLL") {
+		t.Logf("Aliases. Got: %s %s", a1, a2)
+		t.Fail()
+	}
+	if AliasObjectName("PAYROLL.IN") != a1 || AliasObjectName("") != "" {
+		t.Logf("Aliases are not stable")
+		t.Fail()
+	}
+
+	// A different salt gives different aliases, but names already in the mapping file keep theirs
+	cfg.Salt = "other"
+	if err := SetObjectAliasing(cfg); err != nil {
+		t.Fatalf("SetObjectAliasing: %v", err)
+	}
+	if AliasObjectName("PAYROLL.IN") != a1 {
+		t.Logf("Alias from the mapping file was not used")
+		t.Fail()
+	}
+	b, _ := ioutil.ReadFile(f.Name())
+	if string(b) != a1+"\tPAYROLL.IN\n"+a2+"\tPAYROLL.OUT\n" {
+		t.Logf("Mapping file. Got: %q", string(b))
+		t.Fail()
+	}
+
+	SetObjectAliasing(nil)
+	if AliasObjectName("PAYROLL.IN") != "PAYROLL.IN" {
+		t.Logf("Name was aliased after aliasing was turned off")
+		t.Fail()
+	}
+}
+
+func TestElementFilter(t *testing.T) {
+	newMetrics := func() *AllMetrics {
+		m := &AllMetrics{Classes: map[int]*MonClass{
+			0: {Name: "CPU", Types: map[int]*MonType{
+				0: {Name: "SystemSummary", Elements: map[int]*MonElement{
+					0: {MetricName: "user_cpu_time_percentage"},
+					1: {MetricName: "system_cpu_time_percentage"},
+				}},
+			}},
+			1: {Name: "DISK", Types: map[int]*MonType{
+				0: {Name: "Log", Elements: map[int]*MonElement{
+					0: {MetricName: "log_write_latency_seconds"},
+					1: {MetricName: "log_in_use_bytes"},
+				}},
+			}},
+		}}
+		return m
+	}
+	count := func(m *AllMetrics) int {
+		c := 0
+		for _, cl := range m.Classes {
+			for _, ty := range cl.Types {
+				c += len(ty.Elements)
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		allow string
+		deny  string
+		left  int
+	}{
+		{"", "CPU", 2},
+		{"", "log_in_use_bytes", 3},
+		{"", "1/0/0", 3},
+		{"", "disk/log/log_w*", 3},
+		{"", "*", 0},
+		{"DISK", "", 2},
+		{"DISK", "1/Log/1", 1},
+		{"", "", 4},
+	}
+	for _, tc := range tests {
+		m := newMetrics()
+		n, err := filterElements(DiscoverConfig{ElementAllowList: tc.allow, ElementDenyList: tc.deny}, m)
+		if err != nil || count(m) != tc.left || n != 4-tc.left {
+			t.Logf("Allow '%s' Deny '%s'. Got: %d removed %d err %v", tc.allow, tc.deny, count(m), n, err)
+			t.Fail()
+		}
+	}
+
+	if _, err := filterElements(DiscoverConfig{ElementDenyList: "A/B/C/D"}, newMetrics()); err == nil {
+		t.Logf("Too many parts should fail")
+		t.Fail()
+	}
+	if _, err := filterElements(DiscoverConfig{ElementDenyList: "A*B"}, newMetrics()); err == nil {
+		t.Logf("Embedded wildcard should fail")
+		t.Fail()
+	}
+}
+
+func TestStatusValueTypes(t *testing.T) {
+	i := newStatusValueInt64(42)
+	f := newStatusValueFloat64(1.5)
+	s := newStatusValueString("RECEIVE  ")
+
+	if i.Type() != STATUS_TYPE_INT64 || f.Type() != STATUS_TYPE_FLOAT64 || s.Type() != STATUS_TYPE_STRING {
+		t.Logf("Types. Got: %d %d %d", i.Type(), f.Type(), s.Type())
+		t.Fail()
+	}
+	if v, ok := f.Int64(); !ok || v != 1 {
+		t.Logf("Float as int. Got: %d %v", v, ok)
+		t.Fail()
+	}
+	if v, ok := i.Float64(); !ok || v != 42 {
+		t.Logf("Int as float. Got: %f %v", v, ok)
+		t.Fail()
+	}
+	if _, ok := s.Float64(); ok {
+		t.Logf("String should not convert to a number")
+		t.Fail()
+	}
+	if i.String() != "42" || f.String() != "1.5" || s.String() != "RECEIVE" {
+		t.Logf("Strings. Got: '%s' '%s' '%s'", i.String(), f.String(), s.String())
+		t.Fail()
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	newConnectionInfo("transforms")
+	SetConnectionKey("transforms")
+	defer SetConnectionKey("")
+
+	f, err := ioutil.TempFile("", "transforms")
+	if err != nil {
+		t.Fatalf("Cannot create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# Test\nqueue/depth bucket 0, 10,100\nCPU/Summary/ram_free unit percent\nqueue/depth_ratio ratio depth attribute_max_depth\n")
+	f.Close()
+
+	if err = LoadTransforms(f.Name()); err != nil {
+		t.Fatalf("LoadTransforms: %v", err)
+	}
+
+	elem := &MonElement{MetricName: "ram_free", Datatype: ibmmq.MQIAMO_MONITOR_PERCENT,
+		Parent: &MonType{Name: "Summary", Parent: &MonClass{Name: "CPU"}}}
+	if v := Normalise(elem, "", 1234); v != 1234 {
+		t.Logf("Published transform. Got: %f", v)
+		t.Fail()
+	}
+
+	st := GetObjectStatus(GetConnectionKey(), OT_Q)
+	st.Attributes = map[string]*StatusAttribute{
+		ATTR_Q_DEPTH:     newStatusAttribute(ATTR_Q_DEPTH, "Depth", ibmmq.MQIA_CURRENT_Q_DEPTH),
+		ATTR_Q_MAX_DEPTH: newStatusAttribute(ATTR_Q_MAX_DEPTH, "MaxDepth", -1),
+	}
+	st.Attributes[ATTR_Q_DEPTH].Values["Q1"] = newStatusValueInt64(50)
+	st.Attributes[ATTR_Q_MAX_DEPTH].Values["Q1"] = newStatusValueInt64(200)
+	statusPostCollect(OT_Q)
+
+	if v := QueueNormalise(st.Attributes[ATTR_Q_DEPTH], 50); v != 10 {
+		t.Logf("Status transform. Got: %f", v)
+		t.Fail()
+	}
+	r, ok := st.Attributes["depth_ratio"]
+	if !ok || r.Values["Q1"] == nil || r.Values["Q1"].ValueFloat64 != 0.25 {
+		t.Logf("Derived metric. Got: %+v", r)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"x/y/z/w scale 2", "nosuch/depth scale 2", "queue/depth scale x", "queue/depth frobnicate 1"} {
+		if parseTransform(strings.Fields(bad)) == nil {
+			t.Logf("'%s' should fail", bad)
+			t.Fail()
+		}
+	}
+}
+
+type testAlertSender struct {
+	alerts []*ThresholdAlert
+}
+
+func (s *testAlertSender) SendAlert(a *ThresholdAlert) error {
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+func TestThresholdRules(t *testing.T) {
+	key := "thresholds"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	st := GetObjectStatus(key, OT_Q)
+	st.Attributes = map[string]*StatusAttribute{
+		ATTR_Q_DEPTH: newStatusAttribute(ATTR_Q_DEPTH, "Depth", ibmmq.MQIA_CURRENT_Q_DEPTH),
+	}
+
+	r, err := parseThresholdRule(strings.Fields("depth_high queue/depth > 100 2 APP.*"))
+	if err != nil {
+		t.Fatalf("parseThresholdRule: %v", err)
+	}
+	sender := &testAlertSender{}
+	if err = SetThresholdRules([]ThresholdRule{r}, sender); err != nil {
+		t.Fatalf("SetThresholdRules: %v", err)
+	}
+
+	// The rule is breached on the second high value, and cleared by a low one.
+	// OTHER.Q does not match the patterns.
+	expected := []int{0, 1, 0, 1}
+	for i, depth := range []int64{150, 200, 300, 10} {
+		st.Attributes[ATTR_Q_DEPTH].Values["APP.Q"] = newStatusValueInt64(depth)
+		st.Attributes[ATTR_Q_DEPTH].Values["OTHER.Q"] = newStatusValueInt64(depth)
+		alerts, _ := EvaluateThresholds()
+		if len(alerts) != expected[i] {
+			t.Logf("Evaluation %d. Expected %d alerts, Got: %d", i, expected[i], len(alerts))
+			t.Fail()
+		}
+	}
+	if len(sender.alerts) != 2 || !sender.alerts[0].Breached || sender.alerts[0].Object != "APP.Q" || sender.alerts[1].Breached {
+		t.Logf("Unexpected alerts: %v", sender.alerts)
+		t.Fail()
+	}
+
+	for _, bad := range []string{"x queue/depth >> 1", "x nosuch/depth > 1", "x queue/depth > y", "x queue/depth > 1 0"} {
+		r, err = parseThresholdRule(strings.Fields(bad))
+		if err == nil {
+			err = SetThresholdRules([]ThresholdRule{r})
+		}
+		if err == nil {
+			t.Logf("'%s' should fail", bad)
+			t.Fail()
+		}
+	}
+
+	// Check the outer layers of a trap
+	ts := &SNMPTrapSender{Community: "mon", EnterpriseOID: "1.3.6.1.4.1.99999"}
+	b, err := ts.trap(sender.alerts[0], time.Second)
+	var msg struct {
+		Version   int
+		Community []byte
+		PDU       asn1.RawValue
+	}
+	if err == nil {
+		_, err = asn1.Unmarshal(b, &msg)
+	}
+	if err != nil || msg.Version != 1 || string(msg.Community) != "mon" || msg.PDU.Tag != 7 {
+		t.Logf("Trap: %+v %v", msg, err)
+		t.Fail()
+	}
+}
+
+func TestRuleActions(t *testing.T) {
+	key := "ruleactions"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	st := GetObjectStatus(key, OT_CHANNEL)
+	st.Attributes = map[string]*StatusAttribute{
+		ATTR_CHL_STATUS: newStatusAttribute(ATTR_CHL_STATUS, "Status", ibmmq.MQIACH_CHANNEL_STATUS),
+	}
+
+	var started []string
+	RegisterRuleAction("test_start", func(a *ThresholdAlert) error {
+		started = append(started, a.Object)
+		return nil
+	})
+	defer RegisterRuleAction("test_start", nil)
+
+	r, err := parseThresholdRule(strings.Fields("chl_down channel/status != 3 1 TO.* dedup=1h action=test_start"))
+	if err != nil || r.Dedup != time.Hour || len(r.Actions) != 1 {
+		t.Fatalf("parseThresholdRule: %+v %v", r, err)
+	}
+	if err = SetThresholdRules([]ThresholdRule{r}); err != nil {
+		t.Fatalf("SetThresholdRules: %v", err)
+	}
+	var audit bytes.Buffer
+	SetRuleAuditWriter(&audit)
+
+	// The second breach is within the dedup interval, so only the first runs the action
+	chl := "TO.QM2/host(1414)/QM2/-"
+	for _, status := range []int32{ibmmq.MQCHS_STOPPED, ibmmq.MQCHS_RUNNING, ibmmq.MQCHS_RETRYING, ibmmq.MQCHS_RUNNING} {
+		st.Attributes[ATTR_CHL_STATUS].Values[chl] = newStatusValueInt64(int64(status))
+		EvaluateThresholds()
+	}
+	if len(started) != 1 || started[0] != chl {
+		t.Logf("Actions called for: %v", started)
+		t.Fail()
+	}
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var rec RuleAuditRecord
+		if json.Unmarshal([]byte(line), &rec) == nil {
+			events = append(events, rec.Event)
+		}
+	}
+	expected := []string{RULE_EVENT_BREACHED, RULE_EVENT_ACTION, RULE_EVENT_CLEARED, RULE_EVENT_SUPPRESSED}
+	if !reflect.DeepEqual(events, expected) {
+		t.Logf("Audit events. Expected: %v, Got: %v", expected, events)
+		t.Fail()
+	}
+
+	r.Actions = []string{"no_such_action"}
+	if SetThresholdRules([]ThresholdRule{r}) == nil {
+		t.Logf("Unknown action accepted")
+		t.Fail()
+	}
+}
+
+// A type with many queue-level elements, like STATQ
+func newValuesTestMetrics(elements int, columnar bool) *AllMetrics {
+	ty := &MonType{Name: "GET", Elements: make(map[int]*MonElement)}
+	for i := 0; i < elements; i++ {
+		ty.Elements[i] = &MonElement{Parent: ty, MetricName: fmt.Sprintf("elem_%d", i), Values: make(map[string]int64)}
+	}
+	m := &AllMetrics{Classes: map[int]*MonClass{0: {Name: "STATQ", Types: map[int]*MonType{0: ty}}}}
+	if columnar {
+		useColumnarValues(m)
+	}
+	return m
+}
+
+func TestColumnarValues(t *testing.T) {
+	for _, columnar := range []bool{false, true} {
+		elem := newValuesTestMetrics(1, columnar).Classes[0].Types[0].Elements[0]
+		for i := 0; i < 100; i++ {
+			elem.setValue(fmt.Sprintf("Q%d", i), int64(i))
+		}
+		elem.deleteValue("Q10")
+		elem.setValue("Q20", 200)
+
+		if v, ok := elem.Value("Q20"); !ok || v != 200 {
+			t.Logf("Columnar %v. Value for Q20. Got: %d %v", columnar, v, ok)
+			t.Fail()
+		}
+		if _, ok := elem.Value("Q10"); ok {
+			t.Logf("Columnar %v. Deleted value was found", columnar)
+			t.Fail()
+		}
+		sum := int64(0)
+		elem.Range(func(key string, value int64) { sum += value })
+		if elem.Len() != 99 || sum != 4950-10+180 {
+			t.Logf("Columnar %v. Expected: 99 values summing to %d, Got: %d summing to %d", columnar, 4950-10+180, elem.Len(), sum)
+			t.Fail()
+		}
+
+		elem.ClearValues()
+		if _, ok := elem.Value("Q20"); ok || elem.Len() != 0 {
+			t.Logf("Columnar %v. Values were not cleared", columnar)
+			t.Fail()
+		}
+	}
+}
+
+func TestSortedMetrics(t *testing.T) {
+	for _, columnar := range []bool{false, true} {
+		m := newValuesTestMetrics(3, columnar)
+		m.Classes[1] = &MonClass{Name: "CPU", Types: map[int]*MonType{}}
+		ty := m.Classes[0].Types[0]
+		ty.Elements[0].MetricName = "z"
+		ty.Elements[2].MetricName = "a"
+		for _, k := range []string{"Q3", "Q1", "Q2"} {
+			ty.Elements[0].setValue(k, 1)
+		}
+
+		if c := m.SortedClasses(); len(c) != 2 || c[0].Name != "CPU" || c[1].Name != "STATQ" {
+			t.Logf("Columnar %v. Sorted classes. Got: %v", columnar, c)
+			t.Fail()
+		}
+		names := ""
+		for _, e := range ty.SortedElements() {
+			names += e.MetricName + " "
+		}
+		if names != "a elem_1 z " {
+			t.Logf("Columnar %v. Sorted elements. Got: %s", columnar, names)
+			t.Fail()
+		}
+		keys := ""
+		ty.Elements[0].RangeSorted(func(key string, value int64) { keys += key })
+		if keys != "Q1Q2Q3" {
+			t.Logf("Columnar %v. Sorted keys. Got: %s", columnar, keys)
+			t.Fail()
+		}
+	}
+}
+
+// Each iteration is one collection for a large number of queues: every element gets
+// a value for every queue, and the values are then cleared by the collector. The
+// memory held by the values after a collection is reported as heap-bytes.
+func benchmarkValues(b *testing.B, columnar bool) {
+	const queues = 20000
+	const elements = 40
+
+	names := make([]string, queues)
+	for i := range names {
+		names[i] = fmt.Sprintf("APP.QUEUE.%06d", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	m := newValuesTestMetrics(elements, columnar)
+	ty := m.Classes[0].Types[0]
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, elem := range ty.Elements {
+			elem.ClearValues()
+			for i, name := range names {
+				elem.setValue(name, int64(i))
+			}
+		}
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc)-float64(before.HeapAlloc), "heap-bytes")
+	runtime.KeepAlive(m)
+}
+
+func BenchmarkValuesMap(b *testing.B) {
+	benchmarkValues(b, false)
+}
+
+func BenchmarkValuesColumnar(b *testing.B) {
+	benchmarkValues(b, true)
+}
+
+func TestIntern(t *testing.T) {
+	defer SetInternLimit(defaultInternLimit)
+	SetInternLimit(2)
+
+	a := intern(strings.ToUpper("app.queue"))
+	b := intern(strings.ToUpper("app.queue"))
+	if a != b || stringData(a) != stringData(b) {
+		t.Logf("Interned strings do not share memory")
+		t.Fail()
+	}
+	if n, hits := GetInternStats(); n != 1 || hits != 1 {
+		t.Logf("Intern stats. Expected: 1 1, Got: %d %d", n, hits)
+		t.Fail()
+	}
+
+	// Reaching the limit empties the table
+	intern("Q2")
+	intern("Q3")
+	if n, _ := GetInternStats(); n != 1 {
+		t.Logf("Intern table size after limit. Expected: 1, Got: %d", n)
+		t.Fail()
+	}
+
+	SetInternLimit(0)
+	if n, _ := GetInternStats(); intern("Q4") != "Q4" || n != 0 {
+		t.Logf("Interning was not turned off")
+		t.Fail()
+	}
+}
+
+// The address of the bytes in a string
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+/*
+Benchmarks for the collection path. They use a generated recording, so no queue manager
+is needed. Run them with something like
+
+	go test -run XXX -bench 'Discovery|ProcessPublications|QueueStatus' -benchmem ./mqmetric
+
+The publication benchmark also reports messages/sec and allocs/msg, which are easier
+to compare between changes than the per-iteration figures.
+*/
+const (
+	benchQueues   = 2000
+	benchElements = 40
+)
+
+func benchPCF(cmdType int32, params ...*ibmmq.PCFParameter) []byte {
+	cfh := ibmmq.NewMQCFH()
+	cfh.Type = cmdType
+	cfh.ParameterCount = int32(len(params))
+	buf := cfh.Bytes()
+	for _, p := range params {
+		buf = append(buf, p.Bytes()...)
+	}
+	return buf
+}
+
+func benchInt(parm int32, v int64) *ibmmq.PCFParameter {
+	return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER, Parameter: parm, Int64Value: []int64{v}}
+}
+
+func benchString(parm int32, s string) *ibmmq.PCFParameter {
+	return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_STRING, Parameter: parm, String: []string{s}}
+}
+
+func benchGroup(parm int32, parms ...*ibmmq.PCFParameter) *ibmmq.PCFParameter {
+	return &ibmmq.PCFParameter{Type: ibmmq.MQCFT_GROUP, Parameter: parm, GroupList: parms, ParameterCount: int32(len(parms))}
+}
+
+// Write a recording with a queue manager class and a queue class, and one interval
+// of publications for every queue. Returns the number of publications.
+func writeBenchRecording(b *testing.B, fileName string, queues int, elements int) int {
+	const qMgrName = "QM1"
+	prefix := "$SYS/MQ/INFO/QMGR/" + qMgrName + "/Monitor/"
+
+	elemGroups := func(topic string) []byte {
+		parms := []*ibmmq.PCFParameter{benchString(ibmmq.MQCA_TOPIC_STRING, topic)}
+		for i := 0; i < elements; i++ {
+			parms = append(parms, benchGroup(ibmmq.MQGACF_MONITOR_ELEMENT,
+				benchInt(ibmmq.MQIAMO_MONITOR_ELEMENT, int64(i)),
+				benchInt(ibmmq.MQIAMO_MONITOR_DATATYPE, int64(ibmmq.MQIAMO_MONITOR_UNIT)),
+				benchString(ibmmq.MQCAMO_MONITOR_DESC, fmt.Sprintf("Element number %d", i))))
+		}
+		return benchPCF(ibmmq.MQCFT_STATISTICS, parms...)
+	}
+
+	records := []ReplayRecord{
+		{Kind: REPLAY_HEADER, QMgrName: qMgrName, Platform: ibmmq.MQPL_UNIX, CommandLevel: 930},
+		{Kind: REPLAY_META, Topic: prefix + "METADATA/CLASSES", Data: benchPCF(ibmmq.MQCFT_STATISTICS,
+			benchGroup(ibmmq.MQGACF_MONITOR_CLASS,
+				benchInt(ibmmq.MQIAMO_MONITOR_CLASS, 0),
+				benchString(ibmmq.MQCAMO_MONITOR_CLASS, "CPU"),
+				benchString(ibmmq.MQCA_TOPIC_STRING, "cpu/types")),
+			benchGroup(ibmmq.MQGACF_MONITOR_CLASS,
+				benchInt(ibmmq.MQIAMO_MONITOR_CLASS, 1),
+				benchString(ibmmq.MQCAMO_MONITOR_CLASS, "STATQ"),
+				benchString(ibmmq.MQCA_TOPIC_STRING, "statq/types")))},
+		{Kind: REPLAY_META, Topic: "cpu/types", Data: benchPCF(ibmmq.MQCFT_STATISTICS,
+			benchGroup(ibmmq.MQGACF_MONITOR_TYPE,
+				benchInt(ibmmq.MQIAMO_MONITOR_TYPE, 0),
+				benchString(ibmmq.MQCAMO_MONITOR_TYPE, "SystemSummary"),
+				benchString(ibmmq.MQCA_TOPIC_STRING, "cpu/elements")))},
+		{Kind: REPLAY_META, Topic: "statq/types", Data: benchPCF(ibmmq.MQCFT_STATISTICS,
+			benchGroup(ibmmq.MQGACF_MONITOR_TYPE,
+				benchInt(ibmmq.MQIAMO_MONITOR_TYPE, 0),
+				benchString(ibmmq.MQCAMO_MONITOR_TYPE, "GET"),
+				benchString(ibmmq.MQCA_TOPIC_STRING, "statq/elements")))},
+		{Kind: REPLAY_META, Topic: "cpu/elements", Data: elemGroups(prefix + "CPU/SystemSummary")},
+		{Kind: REPLAY_META, Topic: "statq/elements", Data: elemGroups(prefix + "STATQ/%s/GET")},
+		{Kind: REPLAY_INTERVAL},
+	}
+
+	values := func() []*ibmmq.PCFParameter {
+		parms := make([]*ibmmq.PCFParameter, elements)
+		for i := range parms {
+			parms[i] = benchInt(int32(i), int64(i*10))
+		}
+		return parms
+	}
+	records = append(records, ReplayRecord{Kind: REPLAY_PUB, Data: benchPCF(ibmmq.MQCFT_STATISTICS,
+		append([]*ibmmq.PCFParameter{benchInt(ibmmq.MQIAMO_MONITOR_CLASS, 0), benchInt(ibmmq.MQIAMO_MONITOR_TYPE, 0)}, values()...)...)})
+	for q := 0; q < queues; q++ {
+		records = append(records, ReplayRecord{Kind: REPLAY_PUB, Data: benchPCF(ibmmq.MQCFT_STATISTICS,
+			append([]*ibmmq.PCFParameter{benchString(ibmmq.MQCA_Q_NAME, fmt.Sprintf("APP.QUEUE.%06d", q)),
+				benchInt(ibmmq.MQIAMO_MONITOR_CLASS, 1), benchInt(ibmmq.MQIAMO_MONITOR_TYPE, 0)}, values()...)...)})
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		b.Fatalf("Cannot create %s: %v", fileName, err)
+	}
+	enc := json.NewEncoder(f)
+	for i := range records {
+		enc.Encode(&records[i])
+	}
+	f.Close()
+	return queues + 1
+}
+
+// Start a replay of the generated recording using its own connection key
+func startBenchReplay(b *testing.B, key string) int {
+	fileName := "bench" + key
+	pubs := writeBenchRecording(b, fileName, benchQueues, benchElements)
+	defer os.Remove(fileName)
+
+	SetConnectionKey(key)
+	if err := InitReplayKey(key, fileName); err != nil {
+		b.Fatalf("InitReplay failed: %v", err)
+	}
+	return pubs
+}
+
+var benchDiscoverConfig = DiscoverConfig{MonitoredQueues: DiscoverObject{ObjectNames: "APP.*", UseWildcard: true}}
+
+func BenchmarkDiscovery(b *testing.B) {
+	startBenchReplay(b, "benchdiscovery")
+	defer SetConnectionKey("")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := DiscoverAndSubscribe(benchDiscoverConfig); err != nil {
+			b.Fatalf("DiscoverAndSubscribe failed: %v", err)
+		}
+	}
+}
+
+func benchmarkProcessPublications(b *testing.B, columnar bool) {
+	key := "benchpubs"
+	pubs := startBenchReplay(b, key)
+	defer SetConnectionKey("")
+	ci := getConnection(key)
+
+	dc := benchDiscoverConfig
+	dc.ColumnarValues = columnar
+	if err := DiscoverAndSubscribe(dc); err != nil {
+		b.Fatalf("DiscoverAndSubscribe failed: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// Replay the same interval each time, and clear the values as a collector would
+		ci.replay.next = 0
+		if err := ProcessPublications(); err != nil {
+			b.Fatalf("ProcessPublications failed: %v", err)
+		}
+		for _, cl := range ci.publishedMetrics.Classes {
+			for _, ty := range cl.Types {
+				for _, elem := range ty.Elements {
+					elem.ClearValues()
+				}
+			}
+		}
+	}
+	b.StopTimer()
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	msgs := float64(b.N * pubs)
+	b.ReportMetric(msgs/elapsed.Seconds(), "msgs/sec")
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/msgs, "allocs/msg")
+}
+
+func BenchmarkProcessPublications(b *testing.B) {
+	b.Run("maps", func(b *testing.B) { benchmarkProcessPublications(b, false) })
+	b.Run("columnar", func(b *testing.B) { benchmarkProcessPublications(b, true) })
+}
+
+// Parse the responses to an INQUIRE_Q_STATUS for every queue, as a status collection does
+func BenchmarkQueueStatus(b *testing.B) {
+	key := "benchstatus"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	QueueInitAttributes()
+
+	replies := make([][]byte, benchQueues)
+	for q := range replies {
+		replies[q] = benchPCF(ibmmq.MQCFT_RESPONSE,
+			benchString(ibmmq.MQCA_Q_NAME, fmt.Sprintf("APP.QUEUE.%06d", q)),
+			benchInt(ibmmq.MQIA_CURRENT_Q_DEPTH, int64(q)),
+			benchInt(ibmmq.MQIA_OPEN_INPUT_COUNT, 1),
+			benchInt(ibmmq.MQIA_OPEN_OUTPUT_COUNT, 2),
+			benchInt(ibmmq.MQIACF_UNCOMMITTED_MSGS, 0),
+			benchString(ibmmq.MQCACF_LAST_PUT_DATE, "2023-01-01"),
+			benchString(ibmmq.MQCACF_LAST_PUT_TIME, "10.00.00"),
+			benchString(ibmmq.MQCACF_LAST_GET_DATE, "2023-01-01"),
+			benchString(ibmmq.MQCACF_LAST_GET_TIME, "10.00.01"))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, r := range replies {
+			cfh, offset := ibmmq.ReadPCFHeader(r)
+			parseQData(ibmmq.MQOT_Q, cfh, r[offset:])
+		}
+	}
+}
+
+func TestSubStatusData(t *testing.T) {
+	key := "substatus"
+	newConnectionInfo(key)
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+	SubInitAttributes()
+
+	r := benchPCF(ibmmq.MQCFT_RESPONSE,
+		benchString(ibmmq.MQCACF_SUB_NAME, "APP.SUB   "),
+		benchString(ibmmq.MQCA_TOPIC_STRING, ""),
+		benchInt(ibmmq.MQIACF_DURABLE_SUBSCRIPTION, int64(ibmmq.MQSUB_DURABLE_YES)))
+	cfh, offset := ibmmq.ReadPCFHeader(r)
+	subId := parseSubData(cfh, r[offset:])
+
+	st := GetObjectStatus(key, OT_SUB)
+	name := st.Attributes[ATTR_SUB_NAME].Values[subId]
+	topic := st.Attributes[ATTR_SUB_TOPIC_STRING].Values[subId]
+	durable := st.Attributes[ATTR_SUB_DURABLE].Values[subId]
+	active := st.Attributes[ATTR_SUB_ACTIVE].Values[subId]
+	if name == nil || name.ValueString != "APP.SUB" || topic == nil || topic.ValueString != DUMMY_STRING {
+		t.Logf("Labels. Got: %v %v", name, topic)
+		t.Fail()
+	}
+	if durable == nil || durable.ValueInt64 != 1 || active == nil || active.ValueInt64 != 0 {
+		t.Logf("Flags. Got: %v %v", durable, active)
+		t.Fail()
+	}
+
+	r = benchPCF(ibmmq.MQCFT_RESPONSE,
+		benchString(ibmmq.MQCACF_DESTINATION, "SYSTEM.MANAGED.DURABLE.1"),
+		benchString(ibmmq.MQCACF_DESTINATION_Q_MGR, "QM1"))
+	cfh, offset = ibmmq.ReadPCFHeader(r)
+	if _, qName, qMgrName := parseSubDestination(cfh, r[offset:]); qName != "SYSTEM.MANAGED.DURABLE.1" || qMgrName != "QM1" {
+		t.Logf("Destination. Got: %s %s", qName, qMgrName)
+		t.Fail()
+	}
+}
+
+func TestInitConnectionWithFake(t *testing.T) {
+	key := "FAKECONN"
+	SetConnectionKey(key)
+	defer SetConnectionKey("")
+
+	qm := ibmmq.NewFakeQueueManager("FAKEQM")
+	qm.DefineQueue("SYSTEM.ADMIN.COMMAND.QUEUE")
+	qm.DefineModelQueue("SYSTEM.DEFAULT.MODEL.QUEUE")
+	qm.SetQMgrAttr(ibmmq.MQIA_PERFORMANCE_EVENT, int32(ibmmq.MQEVR_DISABLED))
+
+	cc := ConnectionConfig{Connection: qm}
+	err := InitConnectionKey(key, "FAKEQM", "SYSTEM.DEFAULT.MODEL.QUEUE", "", &cc)
+	if err != nil {
+		t.Logf("InitConnection failed: %v", err)
+		t.Fail()
+		return
+	}
+
+	ci := getConnection(key)
+	if ci.si.resolvedQMgrName != "FAKEQM" || !ibmmq.IsUsableObject(ci.si.replyQObj) {
+		t.Logf("Connection. Got: %s %v", ci.si.resolvedQMgrName, ci.si.replyQObj)
+		t.Fail()
+	}
+
+	replyQObj := ci.si.replyQObj
+	EndConnection()
+	if ibmmq.IsUsableObject(replyQObj) {
+		t.Logf("Reply queue still open after EndConnection")
+		t.Fail()
+	}
+}
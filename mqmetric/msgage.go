@@ -0,0 +1,116 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+The oldest_message_age value in the queue status is only reported when the queue
+manager has queue monitoring enabled (MONQ) for the queue. Otherwise the command
+server returns -1. For queues matching the BrowseMsgAgeQueues patterns in the
+ConnectionConfig, the collector instead browses the first message on the queue and
+uses its put time.
+
+This is more expensive than the status query, as each queue is opened and a message
+read on every collection, so the patterns should only name the queues where the
+value matters. It is also an approximation: the first message in browse order is the
+oldest only if all messages have the same priority, and a message that is not yet
+committed is not seen. The queues cannot be browsed when monitoring through a gateway
+queue manager.
+*/
+
+import (
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// Browse for the oldest message on the selected queues where the status did not include it
+func collectBrowsedMsgAge(ci *connectionInfo, st *StatusSet) {
+	traceEntry("collectBrowsedMsgAge")
+
+	if ci.browseMsgAgeQueues == "" || ci.si.targetQMgrName != "" {
+		traceExit("collectBrowsedMsgAge", 1)
+		return
+	}
+
+	ages := st.Attributes[ATTR_Q_MSGAGE].Values
+	var qNames []string
+	for key := range st.Attributes[ATTR_Q_NAME].Values {
+		if v, ok := ages[key]; !ok || v.ValueInt64 < 0 {
+			qNames = append(qNames, key)
+		}
+	}
+
+	now := time.Now()
+	count := 0
+	for _, qName := range FilterRegExp(ci.browseMsgAgeQueues, qNames) {
+		age, err := browseMsgAge(ci, qName, now)
+		if err != nil {
+			logDebug("Cannot browse queue %s for the oldest message: %v", qName, err)
+			continue
+		}
+		ages[qName] = newStatusValueInt64(age)
+		count++
+	}
+
+	traceExitF("collectBrowsedMsgAge", 0, "Queues: %d", count)
+}
+
+// Return the age in seconds of the first message on the queue, or 0 if it is empty
+func browseMsgAge(ci *connectionInfo, qName string, now time.Time) (int64, error) {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = qName
+	qObj, err := ci.si.qMgr.Open(mqod, ibmmq.MQOO_BROWSE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return 0, err
+	}
+	defer qObj.Close(0)
+
+	md := ibmmq.NewMQMD()
+	gmo := ibmmq.NewMQGMO()
+	gmo.Options = ibmmq.MQGMO_BROWSE_FIRST | ibmmq.MQGMO_NO_WAIT | ibmmq.MQGMO_ACCEPT_TRUNCATED_MSG | ibmmq.MQGMO_FAIL_IF_QUIESCING
+
+	// Only the MQMD is needed so the body is always truncated
+	_, err = qObj.Get(md, gmo, nil)
+	if err != nil {
+		mqreturn := err.(*ibmmq.MQReturn)
+		if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+			return 0, nil
+		}
+		if mqreturn.MQRC != ibmmq.MQRC_TRUNCATED_MSG_ACCEPTED {
+			return 0, err
+		}
+	}
+
+	return msgAgeSecs(now, md.PutDateTime), nil
+}
+
+// The put time comes from the queue manager's clock, so a small negative age is
+// possible if the clocks are not in step
+func msgAgeSecs(now time.Time, putTime time.Time) int64 {
+	if putTime.IsZero() {
+		return 0
+	}
+	age := int64(now.Sub(putTime) / time.Second)
+	if age < 0 {
+		age = 0
+	}
+	return age
+}
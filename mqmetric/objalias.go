@@ -0,0 +1,171 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file replaces object names with aliases, for sites that cannot send real queue and
+channel names to a monitoring service run by someone else, but still need a separate
+series for each object.
+
+An alias is a keyed hash (HMAC-SHA256) of the name using a salt that stays on the local
+system, so that the names cannot be recovered by hashing a list of likely ones. The same
+name always gets the same alias, and the alias is appended with its real name to a
+mapping file when it is first used, so that an administrator can find which object an
+alert is about. The mapping file must be protected in the same way as the salt.
+
+Collectors call AliasObjectName, or SanitiseObjectName, for labels that hold object
+names. The objects and name-like values in GetModel are aliased automatically. Object
+descriptions are not aliased, so they should not be exported while this is in use.
+*/
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const defaultAliasLength = 16
+
+// ObjectAliasConfig controls how object names are replaced
+type ObjectAliasConfig struct {
+	Salt        string // Required. Keep it secret, and do not change it or the series will change
+	MappingFile string // Where the alias of each name is recorded. Optional
+	Prefix      string // Put in front of each alias, such as "obj_"
+	Length      int    // Number of hex characters from the hash. Default 16
+}
+
+var objectAliases = struct {
+	sync.Mutex
+	cfg     *ObjectAliasConfig
+	aliases map[string]string
+	mapping *os.File
+}{}
+
+/*
+SetObjectAliasing turns on aliasing of object names, reading any aliases that are already
+in the mapping file. A nil config turns it off.
+*/
+func SetObjectAliasing(cfg *ObjectAliasConfig) error {
+	traceEntry("SetObjectAliasing")
+
+	objectAliases.Lock()
+	defer objectAliases.Unlock()
+
+	if objectAliases.mapping != nil {
+		objectAliases.mapping.Close()
+		objectAliases.mapping = nil
+	}
+	objectAliases.cfg = nil
+	objectAliases.aliases = nil
+
+	if cfg == nil {
+		traceExit("SetObjectAliasing", 1)
+		return nil
+	}
+
+	c := *cfg
+	if c.Salt == "" {
+		err := fmt.Errorf("A salt is needed for object name aliases")
+		traceExitErr("SetObjectAliasing", 2, err)
+		return err
+	}
+	if c.Length <= 0 || c.Length > sha256.Size*2 {
+		c.Length = defaultAliasLength
+	}
+
+	aliases := make(map[string]string)
+	if c.MappingFile != "" {
+		f, err := os.OpenFile(c.MappingFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			traceExitErr("SetObjectAliasing", 3, err)
+			return err
+		}
+		// Each line is the alias and the real name, separated by a tab
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), "\t", 2)
+			if len(fields) == 2 {
+				aliases[fields[1]] = fields[0]
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			f.Close()
+			traceExitErr("SetObjectAliasing", 4, err)
+			return err
+		}
+		objectAliases.mapping = f
+	}
+
+	objectAliases.cfg = &c
+	objectAliases.aliases = aliases
+	traceExit("SetObjectAliasing", 0)
+	return nil
+}
+
+/*
+AliasObjectName returns the alias for an object name, or the name itself if aliasing
+is not on. Empty names are not changed.
+*/
+func AliasObjectName(name string) string {
+	name = trimMQString(name)
+
+	objectAliases.Lock()
+	defer objectAliases.Unlock()
+
+	cfg := objectAliases.cfg
+	if cfg == nil || name == "" {
+		return name
+	}
+	if alias, ok := objectAliases.aliases[name]; ok {
+		return alias
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.Salt))
+	mac.Write([]byte(name))
+	alias := intern(cfg.Prefix + hex.EncodeToString(mac.Sum(nil))[:cfg.Length])
+	objectAliases.aliases[name] = alias
+
+	if objectAliases.mapping != nil {
+		if _, err := fmt.Fprintf(objectAliases.mapping, "%s\t%s\n", alias, name); err != nil {
+			logError("Cannot write to object alias mapping file: %v", err)
+		}
+	}
+	return alias
+}
+
+/*
+SanitiseObjectName is SanitiseLabelValue for a label that holds an object name, which
+is replaced by its alias if aliasing is on
+*/
+func SanitiseObjectName(name string, style int) string {
+	return SanitiseLabelValue(AliasObjectName(name), style)
+}
+
+// Whether GetModel needs to alias its values
+func objectAliasing() bool {
+	objectAliases.Lock()
+	defer objectAliases.Unlock()
+	return objectAliases.cfg != nil
+}
@@ -0,0 +1,185 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file can log every PCF command that the collector sends and every reply that it
+gets, decoded into parameter names and values. It helps when discovery or a status
+query does not return what was expected, without needing an MQ trace on the
+queue manager.
+
+The output goes to the Trace function of the Logger, and is only produced when
+SetPCFTrace has turned it on, as decoding every message is expensive. Parameters that
+can hold passwords are shown as "***". Other parameters can be added to that list,
+for example if user IDs should not appear in the logs.
+
+Publications are not included, only the command server conversation.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const pcfTraceRedacted = "***"
+
+var pcfTrace = struct {
+	sync.RWMutex
+	enabled bool
+	redact  map[int32]bool
+}{redact: map[int32]bool{
+	ibmmq.MQCACH_PASSWORD:            true,
+	ibmmq.MQCACH_SSL_KEY_PASSPHRASE:  true,
+	ibmmq.MQCA_LDAP_PASSWORD:         true,
+	ibmmq.MQCA_SSL_KEY_REPO_PASSWORD: true,
+}}
+
+// How the names of values are found. Replaced in the tests.
+var pcfTraceName = func(class string, v int32) string {
+	return ibmmq.MQItoString(class, int(v))
+}
+
+/*
+SetPCFTrace turns the logging of PCF commands and replies on or off
+*/
+func SetPCFTrace(enabled bool) {
+	pcfTrace.Lock()
+	pcfTrace.enabled = enabled
+	pcfTrace.Unlock()
+}
+
+/*
+AddPCFTraceRedaction adds to the parameters whose values are hidden in the trace,
+such as ibmmq.MQCACF_USER_IDENTIFIER
+*/
+func AddPCFTraceRedaction(params ...int32) {
+	pcfTrace.Lock()
+	for _, p := range params {
+		pcfTrace.redact[p] = true
+	}
+	pcfTrace.Unlock()
+}
+
+func pcfTraceOn() bool {
+	pcfTrace.RLock()
+	defer pcfTrace.RUnlock()
+	return pcfTrace.enabled && logger != nil && logger.Trace != nil
+}
+
+// Log a command or reply. The direction is "command" or "reply".
+func tracePCF(direction string, buf []byte) {
+	if !pcfTraceOn() {
+		return
+	}
+	logTrace("PCF %s: %s", direction, formatPCF(buf))
+}
+
+func formatPCF(buf []byte) string {
+	cfh, offset := ibmmq.ReadPCFHeader(buf)
+	if cfh == nil {
+		return fmt.Sprintf("Not a PCF message (%d bytes)", len(buf))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(pcfTraceValue("CMD", cfh.Command))
+	if cfh.Type != ibmmq.MQCFT_COMMAND && cfh.Type != ibmmq.MQCFT_COMMAND_XR {
+		fmt.Fprintf(&sb, " Type=%d CompCode=%d Reason=%s Control=%d", cfh.Type, cfh.CompCode, pcfTraceValue("RC", cfh.Reason), cfh.Control)
+	}
+
+	pcfTrace.RLock()
+	defer pcfTrace.RUnlock()
+	for i := 0; i < int(cfh.ParameterCount) && offset < len(buf); i++ {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		if bytesRead <= 0 {
+			break
+		}
+		offset += bytesRead
+		sb.WriteString(" ")
+		formatPCFParameter(&sb, elem)
+	}
+	return sb.String()
+}
+
+func formatPCFParameter(sb *strings.Builder, elem *ibmmq.PCFParameter) {
+	var class string
+	switch elem.Type {
+	case ibmmq.MQCFT_STRING, ibmmq.MQCFT_STRING_LIST:
+		class = "CA"
+	case ibmmq.MQCFT_BYTE_STRING:
+		class = "BACF"
+	case ibmmq.MQCFT_GROUP:
+		class = "GACF"
+	default:
+		class = "IA"
+	}
+	sb.WriteString(pcfTraceValue(class, elem.Parameter))
+	sb.WriteString("=")
+
+	if pcfTrace.redact[elem.Parameter] {
+		sb.WriteString(pcfTraceRedacted)
+		return
+	}
+
+	switch elem.Type {
+	case ibmmq.MQCFT_STRING, ibmmq.MQCFT_BYTE_STRING:
+		if len(elem.String) > 0 {
+			sb.WriteString(strconv.Quote(elem.String[0]))
+		}
+	case ibmmq.MQCFT_STRING_LIST:
+		q := make([]string, len(elem.String))
+		for i, s := range elem.String {
+			q[i] = strconv.Quote(s)
+		}
+		sb.WriteString("[" + strings.Join(q, ",") + "]")
+	case ibmmq.MQCFT_INTEGER, ibmmq.MQCFT_INTEGER64:
+		if len(elem.Int64Value) > 0 {
+			sb.WriteString(strconv.FormatInt(elem.Int64Value[0], 10))
+		}
+	case ibmmq.MQCFT_INTEGER_LIST, ibmmq.MQCFT_INTEGER64_LIST:
+		v := make([]string, len(elem.Int64Value))
+		for i, n := range elem.Int64Value {
+			v[i] = strconv.FormatInt(n, 10)
+		}
+		sb.WriteString("[" + strings.Join(v, ",") + "]")
+	case ibmmq.MQCFT_GROUP:
+		sb.WriteString("{")
+		for i, ge := range elem.GroupList {
+			if i > 0 {
+				sb.WriteString(" ")
+			}
+			formatPCFParameter(sb, ge)
+		}
+		sb.WriteString("}")
+	default:
+		fmt.Fprintf(sb, "(type %d)", elem.Type)
+	}
+}
+
+// The name of a value, or the number if there is no name
+func pcfTraceValue(class string, v int32) string {
+	if s := pcfTraceName(class, v); s != "" {
+		return s
+	}
+	return strconv.Itoa(int(v))
+}
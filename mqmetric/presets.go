@@ -0,0 +1,235 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file handles presets that can be given in the list of monitored queues alongside
+the normal patterns. They deal with the SYSTEM queues that people most often want to
+treat differently. A common mistake is to write "APP*,!SYSTEM*,SYSTEM.CLUSTER.TRANSMIT.QUEUE"
+and expect the cluster transmission queue to be monitored; but exclusions always take
+priority over inclusions in FilterRegExp, so it is not.
+
+The presets are
+	@NOSYSTEM - exclude all queues beginning "SYSTEM."
+	@XMITQ    - include all transmission queues
+	@CLUSTERQ - include the SYSTEM.CLUSTER.* queues
+So the example above could be written "APP*,@NOSYSTEM,@XMITQ".
+
+The normal patterns are applied first, and then @NOSYSTEM. Queues selected by @XMITQ and
+@CLUSTERQ are added at the end, so they are never removed by an exclusion. If there are
+no normal patterns, @NOSYSTEM starts from the full set of queues.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// Presets that can be used in the list of monitored queues
+const (
+	PRESET_NOSYSTEM = "@NOSYSTEM"
+	PRESET_XMITQ    = "@XMITQ"
+	PRESET_CLUSTERQ = "@CLUSTERQ"
+)
+
+type queuePresets struct {
+	noSystem bool
+	xmitQ    bool
+	clusterQ bool
+}
+
+func isPreset(pattern string) bool {
+	return strings.HasPrefix(pattern, "@")
+}
+
+func hasPresets(patterns string) bool {
+	return strings.Contains(patterns, "@")
+}
+
+// Separate the presets from the ordinary patterns
+func splitPresets(patterns string) (string, queuePresets, error) {
+	var p queuePresets
+	var others []string
+
+	for _, s := range strings.Split(patterns, ",") {
+		s = strings.TrimSpace(s)
+		if !isPreset(s) {
+			if s != "" {
+				others = append(others, s)
+			}
+			continue
+		}
+		switch strings.ToUpper(s) {
+		case PRESET_NOSYSTEM:
+			p.noSystem = true
+		case PRESET_XMITQ:
+			p.xmitQ = true
+		case PRESET_CLUSTERQ:
+			p.clusterQ = true
+		default:
+			return "", p, fmt.Errorf("Object pattern '%s' is not a known preset", s)
+		}
+	}
+	return strings.Join(others, ","), p, nil
+}
+
+/*
+Apply the patterns and presets to the full list of queues. The xmitQueues are the
+transmission queues on the queue manager; if that is not known, then the cluster
+transmission queues are recognised by their names.
+*/
+func filterWithPresets(patterns string, allQueues []string, xmitQueues []string) ([]string, error) {
+	others, p, err := splitPresets(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var qList []string
+	if others != "" {
+		qList = FilterRegExp(others, allQueues)
+	} else if p.noSystem {
+		qList = allQueues
+	}
+
+	selected := make(map[string]bool)
+	for _, q := range qList {
+		q = strings.TrimSpace(q)
+		if p.noSystem && strings.HasPrefix(q, "SYSTEM.") {
+			continue
+		}
+		selected[q] = true
+	}
+
+	if xmitQueues == nil {
+		xmitQueues = FilterRegExp("SYSTEM.CLUSTER.TRANSMIT.*", allQueues)
+	}
+	if p.xmitQ {
+		for _, q := range xmitQueues {
+			selected[strings.TrimSpace(q)] = true
+		}
+	}
+	if p.clusterQ {
+		for _, q := range FilterRegExp("SYSTEM.CLUSTER.*", allQueues) {
+			selected[strings.TrimSpace(q)] = true
+		}
+	}
+
+	rc := make([]string, 0, len(selected))
+	for q := range selected {
+		if q != "" {
+			rc = append(rc, q)
+		}
+	}
+	sort.Strings(rc)
+	return rc, nil
+}
+
+// Find the names of all the local queues with USAGE(XMITQ)
+func inquireXmitQueues() ([]string, error) {
+	var err error
+
+	traceEntry("inquireXmitQueues")
+
+	ci := getConnection(GetConnectionKey())
+	statusClearReplyQ()
+
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = ibmmq.MQCMD_INQUIRE_Q
+	cfh.ParameterCount = 0
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = ibmmq.MQCA_Q_NAME
+	pcfparm.String = []string{"*"}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = ibmmq.MQIA_Q_TYPE
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQQT_LOCAL)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
+	pcfparm.Parameter = ibmmq.MQIACF_Q_ATTRS
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQIA_USAGE)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	// Let the command server leave out the other queues
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER_FILTER
+	pcfparm.Parameter = ibmmq.MQIA_USAGE
+	pcfparm.Operator = ibmmq.MQCFOP_EQUAL
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQUS_TRANSMISSION)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("inquireXmitQueues", 1, err)
+		return nil, err
+	}
+
+	xmitQueues := make([]string, 0)
+	for allReceived := false; !allReceived; {
+		cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if buf != nil {
+			if qName, usage := parseQUsage(cfh, buf); usage == int64(ibmmq.MQUS_TRANSMISSION) {
+				xmitQueues = append(xmitQueues, qName)
+			}
+		}
+	}
+
+	traceExitErr("inquireXmitQueues", 0, err)
+	return xmitQueues, err
+}
+
+func parseQUsage(cfh *ibmmq.MQCFH, buf []byte) (string, int64) {
+	qName := ""
+	usage := int64(-1)
+
+	if cfh == nil || cfh.ParameterCount == 0 || cfh.CompCode == ibmmq.MQCC_FAILED {
+		return qName, usage
+	}
+
+	offset := 0
+	for offset < len(buf) {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		if bytesRead <= 0 {
+			break
+		}
+		offset += bytesRead
+		switch elem.Parameter {
+		case ibmmq.MQCA_Q_NAME:
+			qName = strings.TrimSpace(elem.String[0])
+		case ibmmq.MQIA_USAGE:
+			usage = elem.Int64Value[0]
+		}
+	}
+	return qName, usage
+}
@@ -0,0 +1,125 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file has a quick check that a queue manager is working, intended for liveness
+and readiness probes in containers. For example, a Kubernetes exec probe can run a small
+program that calls Probe and sets its exit code from the result.
+
+Probe makes its own connection using the same ConnectionConfig as InitConnection,
+inquires on the queue manager, and disconnects. It does not use or change the
+connection that the rest of the package uses, so it can be called at any time. The
+whole sequence is bounded by a timeout; if that expires, the MQI calls carry on in
+the background and the connection is dropped once they finish.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+/*
+ProbeResult contains what was learnt about the queue manager
+*/
+type ProbeResult struct {
+	QMgrName     string
+	CommandLevel int32
+	Platform     int32
+	Elapsed      time.Duration
+}
+
+/*
+Probe connects to the queue manager, inquires on it, and disconnects within the timeout.
+An error means that the queue manager is not usable, including when it is quiescing.
+*/
+func Probe(qMgrName string, cc *ConnectionConfig, timeout time.Duration) (*ProbeResult, error) {
+	traceEntryF("Probe", "QMgrName %s", qMgrName)
+
+	type probeDone struct {
+		res *ProbeResult
+		err error
+	}
+
+	if _, err := checkBindings(cc); err != nil {
+		mqreturn := &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_OPTIONS_ERROR}
+		err = MQMetricError{Err: err.Error(), MQReturn: mqreturn}
+		traceExitErr("Probe", 1, err)
+		return nil, err
+	}
+
+	// Take a copy as connectQMgr can change the config
+	lcc := *cc
+	// A probe must never wait for an automatic reconnection
+	lcc.SingleConnect = true
+
+	start := time.Now()
+	done := make(chan probeDone, 1)
+	go func() {
+		res, err := probe(qMgrName, &lcc)
+		done <- probeDone{res, err}
+	}()
+
+	var res *ProbeResult
+	var err error
+	select {
+	case d := <-done:
+		res, err = d.res, d.err
+		if res != nil {
+			res.Elapsed = time.Since(start)
+		}
+	case <-time.After(timeout):
+		mqreturn := &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN}
+		err = MQMetricError{Err: fmt.Sprintf("Probe of queue manager %s did not finish within %v", qMgrName, timeout), MQReturn: mqreturn}
+	}
+
+	traceExitErr("Probe", 0, err)
+	return res, err
+}
+
+func probe(qMgrName string, cc *ConnectionConfig) (*ProbeResult, error) {
+	qMgr, err := connectQMgr(qMgrName, cc)
+	if err != nil {
+		return nil, MQMetricError{Err: "Cannot connect to queue manager " + qMgrName, MQReturn: err.(*ibmmq.MQReturn)}
+	}
+	defer qMgr.Disc()
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q_MGR
+	qMgrObject, err := qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return nil, MQMetricError{Err: "Cannot open queue manager object", MQReturn: err.(*ibmmq.MQReturn)}
+	}
+	defer qMgrObject.Close(0)
+
+	v, err := qMgrObject.Inq([]int32{ibmmq.MQCA_Q_MGR_NAME, ibmmq.MQIA_COMMAND_LEVEL, ibmmq.MQIA_PLATFORM})
+	if err != nil {
+		return nil, MQMetricError{Err: "Cannot inquire on queue manager", MQReturn: err.(*ibmmq.MQReturn)}
+	}
+
+	res := &ProbeResult{
+		QMgrName:     v[ibmmq.MQCA_Q_MGR_NAME].(string),
+		CommandLevel: v[ibmmq.MQIA_COMMAND_LEVEL].(int32),
+		Platform:     v[ibmmq.MQIA_PLATFORM].(int32),
+	}
+	return res, nil
+}
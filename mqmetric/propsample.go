@@ -0,0 +1,190 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file gives a breakdown of the messages on a queue by the value of one of their
+message properties, such as a "businessUnit" property set by the applications. MQ's own
+statistics only count messages per queue, so this can show, for example, which part of
+the business the backlog on a shared queue belongs to.
+
+The first MaxMessages messages on each selected queue are browsed, without their bodies.
+On a deep queue this is a sample rather than a complete count, and the Complete field
+says which it is. Browsing costs the queue manager some work, so the queues and the sample
+size should be kept small, and the sampling done less often than normal collection.
+*/
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const (
+	defaultPropertySampleSize = 100
+
+	// Limit the number of distinct values reported for each queue, as each
+	// one is likely to become a separate time series
+	maxPropertyValues = 100
+
+	// Where messages are counted when they do not have the property, or have
+	// a value beyond the limit
+	PROPERTY_NOT_SET = DUMMY_STRING
+	PROPERTY_OTHER   = "@other"
+)
+
+// PropertySampleConfig says which queues to browse, and the property to count
+type PropertySampleConfig struct {
+	Queues      string // Patterns for the queues, applied to the monitored queues
+	Property    string
+	MaxMessages int // Messages to browse on each queue. Default 100
+}
+
+// PropertyCounts is the result of sampling one queue
+type PropertyCounts struct {
+	QName    string
+	Browsed  int
+	Counts   map[string]int // Keyed by the property value
+	Complete bool           // True if every message on the queue was browsed
+}
+
+/*
+SamplePropertyCounts browses the selected queues and counts the messages for each
+value of the property. Queues that cannot be opened are skipped.
+*/
+func SamplePropertyCounts(cfg PropertySampleConfig) ([]*PropertyCounts, error) {
+	var results []*PropertyCounts
+
+	traceEntryF("SamplePropertyCounts", "Queues: %s Property: %s", cfg.Queues, cfg.Property)
+
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || !ci.si.qmgrConnected {
+		err := fmt.Errorf("Not connected to a queue manager")
+		traceExitErr("SamplePropertyCounts", 1, err)
+		return nil, err
+	}
+	if cfg.Property == "" {
+		err := fmt.Errorf("No property name given")
+		traceExitErr("SamplePropertyCounts", 2, err)
+		return nil, err
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = defaultPropertySampleSize
+	}
+
+	hMsg, err := crtMH(ci.si.qMgr)
+	if err != nil {
+		traceExitErr("SamplePropertyCounts", 3, err)
+		return nil, err
+	}
+	defer hMsg.DltMH(ibmmq.NewMQDMHO())
+
+	for _, qName := range FilterRegExp(cfg.Queues, GetDiscoveredQueues()) {
+		pc, err := samplePropertyCounts(ci, qName, cfg, &hMsg)
+		if err != nil {
+			// Give up if the connection has gone, otherwise try the next queue
+			if mqreturn, ok := err.(*ibmmq.MQReturn); ok && (mqreturn.MQRC == ibmmq.MQRC_CONNECTION_BROKEN || mqreturn.MQRC == ibmmq.MQRC_HCONN_ERROR) {
+				traceExitErr("SamplePropertyCounts", 4, err)
+				return results, err
+			}
+			logDebug("Cannot sample properties on queue %s: %v", qName, err)
+			continue
+		}
+		results = append(results, pc)
+	}
+
+	traceExitF("SamplePropertyCounts", 0, "Queues: %d", len(results))
+	return results, nil
+}
+
+func samplePropertyCounts(ci *connectionInfo, qName string, cfg PropertySampleConfig, hMsg *ibmmq.MQMessageHandle) (*PropertyCounts, error) {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = qName
+	qObj, err := ci.si.qMgr.Open(mqod, ibmmq.MQOO_BROWSE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return nil, err
+	}
+	defer qObj.Close(0)
+
+	pc := &PropertyCounts{QName: qName, Counts: make(map[string]int)}
+	for pc.Browsed < cfg.MaxMessages {
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_BROWSE_NEXT | ibmmq.MQGMO_NO_WAIT | ibmmq.MQGMO_ACCEPT_TRUNCATED_MSG
+		gmo.Options |= ibmmq.MQGMO_PROPERTIES_IN_HANDLE | ibmmq.MQGMO_FAIL_IF_QUIESCING
+		gmo.MsgHandle = *hMsg
+
+		// Only the properties are needed, so the body is always truncated
+		_, err = qObj.Get(ibmmq.NewMQMD(), gmo, nil)
+		if err != nil {
+			mqreturn := err.(*ibmmq.MQReturn)
+			if mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				pc.Complete = true
+				break
+			}
+			if mqreturn.MQRC != ibmmq.MQRC_TRUNCATED_MSG_ACCEPTED {
+				return nil, err
+			}
+		}
+
+		value := PROPERTY_NOT_SET
+		impo := ibmmq.NewMQIMPO()
+		impo.Options = ibmmq.MQIMPO_CONVERT_VALUE | ibmmq.MQIMPO_INQ_FIRST
+		if _, v, err := hMsg.InqMP(impo, ibmmq.NewMQPD(), cfg.Property); err == nil {
+			value = propertyValueString(v)
+		}
+		pc.add(value)
+	}
+
+	// There may be exactly MaxMessages messages; one more browse tells us
+	if !pc.Complete {
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_BROWSE_NEXT | ibmmq.MQGMO_NO_WAIT | ibmmq.MQGMO_ACCEPT_TRUNCATED_MSG | ibmmq.MQGMO_FAIL_IF_QUIESCING
+		_, err = qObj.Get(ibmmq.NewMQMD(), gmo, nil)
+		if mqreturn, ok := err.(*ibmmq.MQReturn); ok && mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+			pc.Complete = true
+		}
+	}
+
+	return pc, nil
+}
+
+// Count a message, putting it under PROPERTY_OTHER once there are too many distinct values
+func (pc *PropertyCounts) add(value string) {
+	pc.Browsed++
+	if _, ok := pc.Counts[value]; !ok && len(pc.Counts) >= maxPropertyValues {
+		value = PROPERTY_OTHER
+	}
+	pc.Counts[value]++
+}
+
+func propertyValueString(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case []byte:
+		s = hex.EncodeToString(v)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	return intern(labelValue(s))
+}
@@ -0,0 +1,152 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lines up the published metrics with the queue manager's monitoring intervals.
+The queue manager publishes the statistics for every object at the end of each interval,
+usually every 10 seconds, in a burst that takes a short time to arrive. Without alignment,
+a collection that happens during a burst reports some objects for an interval and leaves
+the others for the next collection, and the number of intervals in a collection varies.
+That does not matter for a rough view, but it does for capacity calculations that divide
+the totals by the time they cover.
+
+With the AlignPublications option, publications that were put less than a settling
+time ago, allowing for the estimated clock skew, are kept back and used in the next
+collection instead. Each collection then has whole intervals only, and
+GetPublicationWindow says which intervals those were. The settling time should be
+longer than a burst of publications takes to arrive; the default is 2 seconds.
+
+Publications that are replayed from a recording have no put time, so are never kept back.
+*/
+
+import (
+	"sort"
+	"time"
+)
+
+// Publications with put times closer than this are part of the same interval
+const pubBurstGap = time.Second
+
+var pubWindowSettle = 2 * time.Second
+
+/*
+PublicationWindow describes the monitoring intervals that the last call to
+ProcessPublications used. The times are from the queue manager's clock.
+*/
+type PublicationWindow struct {
+	Start     time.Time // The start of the earliest interval
+	End       time.Time // The end of the latest interval
+	Intervals int       // How many separate intervals were used
+	Carried   int       // Publications kept back for the next collection
+}
+
+type heldPublication struct {
+	data    []byte
+	putTime time.Time
+}
+
+type pubWindow struct {
+	carried []heldPublication // Kept back by this collection
+	pending []heldPublication // Kept back by the previous collection, to be used first
+	times   []time.Time       // Put times of the publications used in this collection
+	window  PublicationWindow
+}
+
+/*
+SetPublicationSettleTime sets how recent a publication can be before it is kept back for
+the next collection, when the AlignPublications option is used
+*/
+func SetPublicationSettleTime(d time.Duration) {
+	pubWindowSettle = d
+}
+
+/*
+GetPublicationWindow returns the intervals covered by the last call to ProcessPublications.
+The second return value is false if the AlignPublications option is not being used.
+*/
+func GetPublicationWindow() (PublicationWindow, bool) {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || ci.pubWindow == nil {
+		return PublicationWindow{}, false
+	}
+	return ci.pubWindow.window, true
+}
+
+// Called at the start of ProcessPublications
+func (w *pubWindow) start() {
+	w.pending = append(w.pending, w.carried...)
+	w.carried = nil
+	w.times = w.times[:0]
+	w.window = PublicationWindow{}
+}
+
+// Return the next publication that was kept back by the previous collection
+func (w *pubWindow) next() ([]byte, time.Time, bool) {
+	if len(w.pending) == 0 {
+		return nil, time.Time{}, false
+	}
+	p := w.pending[0]
+	w.pending = w.pending[1:]
+	return p.data, p.putTime, true
+}
+
+// Keep the publication for the next collection if it is too recent. The data is copied,
+// as it refers to a buffer that is reused.
+func (w *pubWindow) hold(data []byte, putTime time.Time, cutoff time.Time) bool {
+	if putTime.IsZero() || !putTime.After(cutoff) {
+		return false
+	}
+	w.carried = append(w.carried, heldPublication{data: append([]byte(nil), data...), putTime: putTime})
+	return true
+}
+
+// Record a publication that has been used. The interval is in microseconds, as
+// given in the publication.
+func (w *pubWindow) used(putTime time.Time, interval int64) {
+	if putTime.IsZero() {
+		return
+	}
+	w.times = append(w.times, putTime)
+	start := putTime.Add(-time.Duration(interval) * time.Microsecond)
+	if w.window.Start.IsZero() || start.Before(w.window.Start) {
+		w.window.Start = start
+	}
+	if putTime.After(w.window.End) {
+		w.window.End = putTime
+	}
+}
+
+// Called at the end of ProcessPublications to count the intervals
+func (w *pubWindow) end() {
+	sort.Slice(w.times, func(i, j int) bool { return w.times[i].Before(w.times[j]) })
+	for i, t := range w.times {
+		if i == 0 || t.Sub(w.times[i-1]) > pubBurstGap {
+			w.window.Intervals++
+		}
+	}
+	w.window.Carried = len(w.carried)
+}
+
+// The put time, on the queue manager's clock, after which publications are kept back
+func pubWindowCutoff(ci *connectionInfo, now time.Time) time.Time {
+	skew, _ := ci.clockSkew.estimate()
+	return now.Add(-skew).Add(-pubWindowSettle)
+}
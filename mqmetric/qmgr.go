@@ -44,10 +44,14 @@ const (
 	ATTR_QMGR_CMD_SERVER_STATUS   = "command_server_status"
 	ATTR_QMGR_STATUS              = "status"
 	ATTR_QMGR_UPTIME              = "uptime"
+	ATTR_QMGR_RESTART_COUNT       = "restart_count"
+	ATTR_QMGR_START_TIME          = "start_time"
 	ATTR_QMGR_MAX_CHANNELS        = "max_channels"
 	ATTR_QMGR_MAX_ACTIVE_CHANNELS = "max_active_channels"
 	ATTR_QMGR_MAX_TCP_CHANNELS    = "max_tcp_channels"
+	ATTR_QMGR_MAX_MSGL            = "max_msg_length"
 	ATTR_QMGR_ACTIVE_LISTENERS    = "active_listeners"
+	ATTR_QMGR_ADVANCED_CAPABILITY = "advanced_capability"
 
 	// Some of the log-related metrics are effectively duplicated between QMSTATUS and
 	// published resources eg LOGUTIL. We prefer the publication versions so do not
@@ -90,6 +94,10 @@ func QueueManagerInitAttributes() {
 	if GetPlatform() != ibmmq.MQPL_ZOS {
 		attr = ATTR_QMGR_UPTIME
 		st.Attributes[attr] = newStatusAttribute(attr, "Up time", -1)
+		attr = ATTR_QMGR_RESTART_COUNT
+		st.Attributes[attr] = newStatusAttribute(attr, "Restarts seen by this collector", -1)
+		attr = ATTR_QMGR_START_TIME
+		st.Attributes[attr] = newStatusAttribute(attr, "Start time (seconds since the Unix epoch)", -1)
 
 		// These are the integer status fields that are of interest
 		attr = ATTR_QMGR_CONNECTION_COUNT
@@ -135,6 +143,14 @@ func QueueManagerInitAttributes() {
 	attr = ATTR_QMGR_STATUS
 	st.Attributes[attr] = newStatusAttribute(attr, "Queue Manager Status", ibmmq.MQIACF_Q_MGR_STATUS)
 
+	// A configuration value, but one that often explains why messages are being rejected
+	attr = ATTR_QMGR_MAX_MSGL
+	st.Attributes[attr] = newStatusAttribute(attr, "Max Message Length", -1)
+
+	// Whether the queue manager is entitled to use the Advanced features, for reconciling licences
+	attr = ATTR_QMGR_ADVANCED_CAPABILITY
+	st.Attributes[attr] = newStatusAttribute(attr, "Advanced Capability", -1)
+
 	os.init = true
 
 	traceExit("QueueManagerInitAttributes", 0)
@@ -142,30 +158,41 @@ func QueueManagerInitAttributes() {
 }
 
 func CollectQueueManagerStatus() error {
+	k := GetConnectionKey()
+	return collectQueueManagerStatusFor(k, getConnection(k))
+}
+
+func collectQueueManagerStatusFor(k string, ci *connectionInfo) error {
 	var err error
 
 	traceEntry("CollectQueueManagerStatus")
 	//os := &ci.objectStatus[OT_Q_MGR]
-	st := GetObjectStatus(GetConnectionKey(), OT_Q_MGR)
+	st := GetObjectStatus(k, OT_Q_MGR)
 
 	// Empty any collected values
 	QueueManagerInitAttributes()
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
 	if GetPlatform() == ibmmq.MQPL_ZOS {
 		err = collectQueueManagerAttrsZOS()
+		if err == nil {
+			collectQueueManagerEntitlement()
+		}
 	} else {
 		err = collectQueueManagerAttrsDist()
 		if err == nil {
+			collectQueueManagerEntitlement()
 			err = collectQueueManagerListeners()
 		}
 		if err == nil {
 			err = collectQueueManagerStatus(ibmmq.MQOT_Q_MGR)
 		}
+		if err == nil && ci.qMgrRestarted {
+			err = qMgrRestarted(ci)
+		}
 	}
 
+	statusPostCollect(OT_Q_MGR)
 	traceExitErr("CollectQueueManagerStatus", 0, err)
 
 	return err
@@ -185,9 +212,10 @@ func collectQueueManagerAttrsZOS() error {
 		ibmmq.MQCA_Q_MGR_DESC,
 		ibmmq.MQIA_ACTIVE_CHANNELS,
 		ibmmq.MQIA_TCP_CHANNELS,
-		ibmmq.MQIA_MAX_CHANNELS}
+		ibmmq.MQIA_MAX_CHANNELS,
+		ibmmq.MQIA_MAX_MSG_LENGTH}
 
-	v, err := ci.si.qMgrObject.Inq(selectors)
+	v, err := inqQMgrAttrs(ci, selectors)
 	if err == nil {
 		maxchls := v[ibmmq.MQIA_MAX_CHANNELS].(int32)
 		maxact := v[ibmmq.MQIA_ACTIVE_CHANNELS].(int32)
@@ -198,12 +226,13 @@ func collectQueueManagerAttrsZOS() error {
 		st.Attributes[ATTR_QMGR_MAX_ACTIVE_CHANNELS].Values[key] = newStatusValueInt64(int64(maxact))
 		st.Attributes[ATTR_QMGR_MAX_CHANNELS].Values[key] = newStatusValueInt64(int64(maxchls))
 		st.Attributes[ATTR_QMGR_MAX_TCP_CHANNELS].Values[key] = newStatusValueInt64(int64(maxtcp))
+		st.Attributes[ATTR_QMGR_MAX_MSGL].Values[key] = newStatusValueInt64(int64(v[ibmmq.MQIA_MAX_MSG_LENGTH].(int32)))
 		st.Attributes[ATTR_QMGR_NAME].Values[key] = newStatusValueString(key)
 		// This pseudo-value will always get filled in for a z/OS qmgr - we know it's running because
 		// we've been able to connect!
 		st.Attributes[ATTR_QMGR_STATUS].Values[key] = newStatusValueInt64(int64(ibmmq.MQQMSTA_RUNNING))
-		qMgrInfo.Description = desc
-		qMgrInfo.QMgrName = key
+		ci.qMgrInfo.Description = desc
+		ci.qMgrInfo.QMgrName = key
 	}
 	traceExitErr("collectQueueManagerAttrsZOS", 0, err)
 
@@ -217,16 +246,18 @@ func collectQueueManagerAttrsDist() error {
 	st := GetObjectStatus(GetConnectionKey(), OT_Q_MGR)
 
 	selectors := []int32{ibmmq.MQCA_Q_MGR_NAME,
-		ibmmq.MQCA_Q_MGR_DESC}
+		ibmmq.MQCA_Q_MGR_DESC,
+		ibmmq.MQIA_MAX_MSG_LENGTH}
 
-	v, err := ci.si.qMgrObject.Inq(selectors)
+	v, err := inqQMgrAttrs(ci, selectors)
 	desc := DUMMY_STRING
 	if err == nil {
 		key := v[ibmmq.MQCA_Q_MGR_NAME].(string)
 		desc = v[ibmmq.MQCA_Q_MGR_DESC].(string)
 		st.Attributes[ATTR_QMGR_NAME].Values[key] = newStatusValueString(key)
-		qMgrInfo.Description = desc
-		qMgrInfo.QMgrName = key
+		st.Attributes[ATTR_QMGR_MAX_MSGL].Values[key] = newStatusValueInt64(int64(v[ibmmq.MQIA_MAX_MSG_LENGTH].(int32)))
+		ci.qMgrInfo.Description = desc
+		ci.qMgrInfo.QMgrName = key
 	}
 
 	traceExitErr("collectQueueManagerAttrsDist", 0, err)
@@ -234,6 +265,43 @@ func collectQueueManagerAttrsDist() error {
 	return err
 }
 
+/*
+Licensing teams need to know which edition and version each queue manager is running.
+The queue manager does not report anything about processor cores, so VUE and PVU counts
+still have to come from elsewhere, but the Advanced capability and the version are
+enough to match a queue manager to an entitlement. These attributes do not exist
+before 9.1, and a failure to read them is not treated as a collection error.
+*/
+func collectQueueManagerEntitlement() {
+	traceEntry("collectQueueManagerEntitlement")
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), OT_Q_MGR)
+
+	if GetCommandLevel() < ibmmq.MQCMDL_LEVEL_910 || ci.qMgrInfo.QMgrName == "" {
+		traceExit("collectQueueManagerEntitlement", 1)
+		return
+	}
+
+	selectors := []int32{ibmmq.MQCA_VERSION,
+		ibmmq.MQIA_ADVANCED_CAPABILITY}
+
+	v, err := inqQMgrAttrs(ci, selectors)
+	if err != nil {
+		logDebug("Cannot inquire queue manager entitlement: %v", err)
+		traceExitErr("collectQueueManagerEntitlement", 2, err)
+		return
+	}
+
+	if version, ok := v[ibmmq.MQCA_VERSION].(string); ok {
+		ci.qMgrInfo.Version = strings.TrimSpace(version)
+	}
+	if advcap, ok := v[ibmmq.MQIA_ADVANCED_CAPABILITY].(int32); ok {
+		st.Attributes[ATTR_QMGR_ADVANCED_CAPABILITY].Values[ci.qMgrInfo.QMgrName] = newStatusValueInt64(int64(advcap))
+	}
+
+	traceExit("collectQueueManagerEntitlement", 0)
+}
+
 // We collect the number of active listeners, rather than
 // enumerating the status of all of the configured objects. In most
 // systems, the listener count will be "1". And getting all of the information
@@ -268,7 +336,7 @@ func collectQueueManagerListeners() error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectQueueManagerListeners", 1, err)
 		return err
@@ -285,10 +353,10 @@ func collectQueueManagerListeners() error {
 		}
 	}
 
-	logDebug("Getting listener count for %s as %d", qMgrInfo.QMgrName, listenerCount)
+	logDebug("Getting listener count for %s as %d", ci.qMgrInfo.QMgrName, listenerCount)
 
-	if qMgrInfo.QMgrName != "" {
-		st.Attributes[ATTR_QMGR_ACTIVE_LISTENERS].Values[qMgrInfo.QMgrName] = newStatusValueInt64(int64(listenerCount))
+	if ci.qMgrInfo.QMgrName != "" {
+		st.Attributes[ATTR_QMGR_ACTIVE_LISTENERS].Values[ci.qMgrInfo.QMgrName] = newStatusValueInt64(int64(listenerCount))
 	}
 
 	traceExitErr("collectQueueManagerListeners", 0, err)
@@ -315,7 +383,7 @@ func collectQueueManagerStatus(instanceType int32) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectQueueManagerStatus", 1, err)
 		return err
@@ -341,6 +409,7 @@ func parseQMgrData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	traceEntry("parseQMgrData")
 
 	st := GetObjectStatus(GetConnectionKey(), OT_Q_MGR)
+	ci := getConnection(GetConnectionKey())
 
 	qMgrName := ""
 	key := ""
@@ -377,6 +446,9 @@ func parseQMgrData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 
 	st.Attributes[ATTR_QMGR_NAME].Values[key] = newStatusValueString(qMgrName)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_Q_MGR), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -397,6 +469,8 @@ func parseQMgrData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 				startDate = strings.TrimSpace(elem.String[0])
 			case ibmmq.MQCACF_HOST_NAME: // This started to be available from 9.3.2
 				hostname = strings.TrimSpace(elem.String[0])
+			case ibmmq.MQCA_INSTALLATION_NAME:
+				ci.qMgrInfo.InstallationName = strings.TrimSpace(elem.String[0])
 
 			// Log-related attributes naming an extent will need conversion from a string to an integer
 			case ibmmq.MQCACF_CURRENT_LOG_EXTENT_NAME:
@@ -412,8 +486,17 @@ func parseQMgrData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	}
 
 	now := time.Now()
-	st.Attributes[ATTR_QMGR_UPTIME].Values[key] = newStatusValueInt64(statusTimeDiff(now, startDate, startTime))
-	qMgrInfo.HostName = hostname
+	uptime := statusTimeDiff(now, startDate, startTime)
+	st.Attributes[ATTR_QMGR_UPTIME].Values[key] = newStatusValueInt64(uptime)
+	if startDate != "" {
+		// Derived from the uptime so that it has the same timezone correction
+		if uptime > 0 {
+			st.Attributes[ATTR_QMGR_START_TIME].Values[key] = newStatusValueInt64(now.Unix() - uptime)
+		}
+		restarts := checkQMgrRestart(getConnection(GetConnectionKey()), qMgrName, startDate, startTime)
+		st.Attributes[ATTR_QMGR_RESTART_COUNT].Values[key] = newStatusValueInt64(restarts)
+	}
+	ci.qMgrInfo.HostName = hostname
 
 	traceExitF("parseQMgrData", 0, "Key: %s", key)
 	return key
@@ -480,7 +563,7 @@ func QueueManagerNormalise(attr *StatusAttribute, v int64) float64 {
 		if f < 0 {
 			f = 0
 		}
-		return f
+		return attr.applyTransform(f)
 	default:
 		return statusNormalise(attr, v)
 	}
@@ -491,18 +574,18 @@ func QueueManagerNormalise(attr *StatusAttribute, v int64) float64 {
 // if we do a version that supports connections to multiple qmgrs. And it keeps
 // the function looking like the equivalent for the Queue query.
 func GetQueueManagerAttribute(key string, attribute int32) string {
+	ci := getConnection(GetConnectionKey())
 	v := DUMMY_STRING
 
 	switch attribute {
 	case ibmmq.MQCACF_HOST_NAME:
-		v = qMgrInfo.HostName
+		v = ci.qMgrInfo.HostName
+	case ibmmq.MQCA_VERSION:
+		v = ci.qMgrInfo.Version
+	case ibmmq.MQCA_INSTALLATION_NAME:
+		v = ci.qMgrInfo.InstallationName
 	default:
 		v = DUMMY_STRING
 	}
-	v = strings.TrimSpace(v)
-
-	if v == "" {
-		v = DUMMY_STRING
-	}
-	return v
+	return labelValue(v)
 }
@@ -0,0 +1,126 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file notices when the queue manager has been restarted, by comparing the start
+date and time reported by DISPLAY QMSTATUS in each call to CollectQueueManagerStatus.
+
+A client using automatic reconnection may not see any error when the queue manager
+restarts, but its non-durable subscriptions have gone and the cumulative counters
+that are used to calculate deltas in the status queries have been reset. So when a
+restart is seen on the same connection, the previous values for the deltas are thrown
+away and, if DiscoverAndSubscribe has been used, the discovery and subscriptions are
+redone with the same configuration.
+
+The number of restarts is remembered for each connection key and queue manager even
+when the collector itself reconnects, so it can be reported as a counter.
+*/
+
+import (
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+type qMgrRestartInfo struct {
+	startTime    string
+	restartCount int64
+}
+
+// Indexed by connection key and queue manager name, so that it survives reconnection
+var qMgrRestartMap = make(map[string]*qMgrRestartInfo)
+
+/*
+GetQueueManagerRestartCount returns the number of times that the queue manager has been
+seen to restart since the collector first connected to it
+*/
+func GetQueueManagerRestartCount() int64 {
+	ci := getConnection(GetConnectionKey())
+	if ri, ok := qMgrRestartMap[GetConnectionKey()+"/"+ci.si.resolvedQMgrName]; ok {
+		return ri.restartCount
+	}
+	return 0
+}
+
+// Compare the start time with the previous one for this queue manager. Returns
+// the current restart count.
+func checkQMgrRestart(ci *connectionInfo, qMgrName string, startDate string, startTime string) int64 {
+	start := startDate + " " + startTime
+	mapKey := GetConnectionKey() + "/" + qMgrName
+
+	ri, ok := qMgrRestartMap[mapKey]
+	if !ok {
+		ri = &qMgrRestartInfo{startTime: start}
+		qMgrRestartMap[mapKey] = ri
+	} else if ri.startTime != start {
+		logInfo("Queue manager %s has restarted. Previous start: %s Current start: %s", qMgrName, ri.startTime, start)
+		ri.startTime = start
+		ri.restartCount++
+		// Only need to redo things if this connection was there before the restart.
+		// A new connection has already started from scratch.
+		if ci.qMgrStartTime != "" {
+			ci.qMgrRestarted = true
+		}
+	}
+	ci.qMgrStartTime = start
+	return ri.restartCount
+}
+
+// Called after the status has been collected, when a restart has been seen
+func qMgrRestarted(ci *connectionInfo) error {
+	var err error
+
+	traceEntry("qMgrRestarted")
+	ci.qMgrRestarted = false
+
+	// The cumulative values from the queue manager have started again from zero
+	for i := 1; i <= OT_LAST_USED; i++ {
+		st := GetObjectStatus(GetConnectionKey(), i)
+		if st == nil {
+			continue
+		}
+		for _, attr := range st.Attributes {
+			if attr.delta {
+				attr.prevValues = make(map[string]int64)
+			}
+		}
+	}
+
+	if ci.discoverConfig != nil && ci.usePublications {
+		// Durable subscriptions survive the restart and are picked up again
+		// during the discovery. Non-durable ones have to be recreated.
+		m := GetPublishedMetrics(GetConnectionKey())
+		for _, cl := range m.Classes {
+			for _, ty := range cl.Types {
+				for _, s := range ty.subHobj {
+					if !s.durable && ibmmq.IsUsableObject(s.hObj) {
+						s.hObj.Close(0)
+					}
+				}
+			}
+			if cl.subHobj != nil && ibmmq.IsUsableObject(cl.subHobj.hObj) {
+				cl.subHobj.hObj.Close(0)
+			}
+		}
+		err = DiscoverAndSubscribe(*ci.discoverConfig)
+	}
+
+	traceExitErr("qMgrRestarted", 0, err)
+	return err
+}
@@ -0,0 +1,231 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file checks the MONQ, STATQ and ACCTQ settings of the monitored queues when they
+are discovered. When one of them is OFF, either on the queue or through the queue
+manager's setting, some data is never produced:
+
+  - MONQ: the queue time and oldest message age in the queue status
+  - STATQ: the queue statistics messages written to SYSTEM.ADMIN.STATISTICS.QUEUE
+  - ACCTQ: the queue accounting messages written to SYSTEM.ADMIN.ACCOUNTING.QUEUE
+
+A warning is logged once for each queue and setting. If the ManageMonitoring option
+lists the setting, the collector also changes the queue with a CHANGE_Q command so that
+the data is produced: MONQ is set to MEDIUM, and STATQ or ACCTQ to ON. This needs
+authority to change the queues, and overrides any choice made by the queue's owner, so
+it is never done unless asked for. Nothing can be done for a queue when the queue
+manager's setting is NONE, as that cannot be overridden.
+
+STATQ and NPMCLASS are not available on z/OS, so only MONQ and ACCTQ are checked there.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// The settings, in the order they are reported
+var monitoringSettings = []struct {
+	name    string
+	attr    int32
+	enabled int64 // The value set by ManageMonitoring
+}{
+	{"MONQ", ibmmq.MQIA_MONITORING_Q, int64(ibmmq.MQMON_MEDIUM)},
+	{"STATQ", ibmmq.MQIA_STATISTICS_Q, int64(ibmmq.MQMON_ON)},
+	{"ACCTQ", ibmmq.MQIA_ACCOUNTING_Q, int64(ibmmq.MQMON_ON)},
+}
+
+// Only a few queue names are shown in each warning
+const monitoringWarnNames = 10
+
+// Check the list of settings that can be changed
+func parseManageMonitoring(s string) (map[int32]bool, error) {
+	m := make(map[int32]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, ms := range monitoringSettings {
+			if ms.name == name {
+				m[ms.attr] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("ManageMonitoring value %s must be one of MONQ, STATQ or ACCTQ", name)
+		}
+	}
+	return m, nil
+}
+
+// The queue attributes to ask for in INQUIRE_Q, depending on the platform
+func queueMonitoringSelectors(platform int32) []int64 {
+	if platform == ibmmq.MQPL_ZOS {
+		return []int64{int64(ibmmq.MQIA_MONITORING_Q), int64(ibmmq.MQIA_ACCOUNTING_Q)}
+	}
+	return []int64{int64(ibmmq.MQIA_MONITORING_Q), int64(ibmmq.MQIA_STATISTICS_Q), int64(ibmmq.MQIA_ACCOUNTING_Q), int64(ibmmq.MQIA_NPM_CLASS)}
+}
+
+/*
+Work out whether a setting stops the data being produced. The qmgr value is nil if it
+is not known. The second return value says whether changing the queue would help.
+*/
+func monitoringOff(queueValue int64, qmgrValue *int64) (bool, bool) {
+	if qmgrValue != nil && *qmgrValue == int64(ibmmq.MQMON_NONE) {
+		return true, false
+	}
+	if queueValue == int64(ibmmq.MQMON_OFF) {
+		return true, true
+	}
+	if queueValue == int64(ibmmq.MQMON_Q_MGR) && qmgrValue != nil && *qmgrValue == int64(ibmmq.MQMON_OFF) {
+		return true, true
+	}
+	return false, false
+}
+
+/*
+checkQueueMonitoring is called after the queue attributes have been inquired during
+discovery. Queues that have already been warned about are not reported again, unless
+a setting has changed back to OFF since.
+*/
+func checkQueueMonitoring(ci *connectionInfo) {
+	traceEntry("checkQueueMonitoring")
+
+	// The queue manager's own settings, for queues that use them. They are
+	// not known if the inquiry fails, and then only the queue's setting counts.
+	qmgrValues := make(map[int32]*int64)
+	selectors := []int32{ibmmq.MQIA_MONITORING_Q, ibmmq.MQIA_ACCOUNTING_Q}
+	if ci.si.platform != ibmmq.MQPL_ZOS {
+		selectors = append(selectors, ibmmq.MQIA_STATISTICS_Q)
+	}
+	if v, err := inqQMgrAttrs(ci, selectors); err == nil {
+		for _, s := range selectors {
+			if i, ok := v[s].(int32); ok {
+				i64 := int64(i)
+				qmgrValues[s] = &i64
+			}
+		}
+	} else {
+		logDebug("Cannot inquire queue manager monitoring settings: %v", err)
+	}
+
+	for _, ms := range monitoringSettings {
+		var warn []string
+		for qName, qInfo := range ci.qInfoMap {
+			v, ok := qInfo.monitoringValues[ms.attr]
+			if !ok || !qInfo.exists {
+				continue
+			}
+			off, fixable := monitoringOff(v, qmgrValues[ms.attr])
+			if !off {
+				delete(qInfo.monitoringWarned, ms.attr)
+				continue
+			}
+			if fixable && ci.manageMonitoring[ms.attr] {
+				if err := changeQueueMonitoring(ci, qName, ms.attr, ms.enabled); err != nil {
+					logError("Cannot set %s(%s) for queue %s: %v", ms.name, strings.ToUpper(EnumString(ENUM_Q_MONITORING, ms.enabled)), qName, err)
+				} else {
+					logInfo("Set %s(%s) for queue %s", ms.name, strings.ToUpper(EnumString(ENUM_Q_MONITORING, ms.enabled)), qName)
+					qInfo.monitoringValues[ms.attr] = ms.enabled
+					continue
+				}
+			}
+			if !qInfo.monitoringWarned[ms.attr] {
+				if qInfo.monitoringWarned == nil {
+					qInfo.monitoringWarned = make(map[int32]bool)
+				}
+				qInfo.monitoringWarned[ms.attr] = true
+				warn = append(warn, qName)
+			}
+		}
+
+		if len(warn) > 0 {
+			sort.Strings(warn)
+			more := ""
+			if len(warn) > monitoringWarnNames {
+				more = fmt.Sprintf(" and %d more", len(warn)-monitoringWarnNames)
+				warn = warn[:monitoringWarnNames]
+			}
+			logWarn("Warning: %s is OFF for queues %s%s. Some data will not be available for them.", ms.name, strings.Join(warn, ","), more)
+		}
+	}
+
+	traceExit("checkQueueMonitoring", 0)
+}
+
+// Issue a CHANGE_Q command to set one of the monitoring attributes
+func changeQueueMonitoring(ci *connectionInfo, qName string, attr int32, value int64) error {
+	var err error
+	traceEntryF("changeQueueMonitoring", "Queue: %s", qName)
+
+	statusClearReplyQ()
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = ibmmq.MQCMD_CHANGE_Q
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = ibmmq.MQCA_Q_NAME
+	pcfparm.String = []string{qName}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = ibmmq.MQIA_Q_TYPE
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQQT_LOCAL)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = attr
+	pcfparm.Int64Value = []int64{value}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("changeQueueMonitoring", 1, err)
+		return err
+	}
+
+	var cmdErr error
+	for allReceived := false; !allReceived; {
+		cfh, _, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if cfh != nil && cfh.Reason != ibmmq.MQRC_NONE && cmdErr == nil {
+			cmdErr = &ibmmq.MQReturn{MQCC: cfh.CompCode, MQRC: cfh.Reason}
+		}
+	}
+	if err == nil {
+		err = cmdErr
+	}
+
+	traceExitErr("changeQueueMonitoring", 0, err)
+	return err
+}
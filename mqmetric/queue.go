@@ -48,8 +48,15 @@ const (
 	ATTR_Q_SINCE_PUT   = "time_since_put"
 	ATTR_Q_SINCE_GET   = "time_since_get"
 	ATTR_Q_MAX_DEPTH   = "attribute_max_depth"
+	ATTR_Q_MAX_MSGL    = "attribute_max_msg_length"
 	ATTR_Q_USAGE       = "attribute_usage"
 	ATTR_Q_CURMAXFSIZE = "qfile_max_size"
+	// Queue definitions that stop applications working are 1, otherwise 0
+	ATTR_Q_PUT_INHIBITED   = "attribute_put_inhibited"
+	ATTR_Q_GET_INHIBITED   = "attribute_get_inhibited"
+	ATTR_Q_TRIGGER_CONTROL = "attribute_trigger_control"
+	ATTR_Q_MONITORING      = "attribute_monitoring"
+	ATTR_Q_NPM_CLASS       = "attribute_npm_class"
 	// Uncommitted messages - on Distributed platforms, this is any integer;
 	// but on z/OS it only indicates 0/1 (MQQSUM_NO/YES)
 	ATTR_Q_UNCOM = "uncommitted_messages"
@@ -142,9 +149,25 @@ func QueueInitAttributes() {
 	// Recording the MaxDepth allows Prometheus etc to do the calculation regardless of how the CurDepth was obtained.
 	attr = ATTR_Q_MAX_DEPTH
 	st.Attributes[attr] = newStatusAttribute(attr, "Queue Max Depth", -1)
+	attr = ATTR_Q_MAX_MSGL
+	st.Attributes[attr] = newStatusAttribute(attr, "Queue Max Message Length", -1)
 	attr = ATTR_Q_USAGE
 	st.Attributes[attr] = newStatusAttribute(attr, "Queue Usage", -1)
 
+	// The same applies to these, so a change made with ALTER QLOCAL is only seen after rediscovery
+	attr = ATTR_Q_PUT_INHIBITED
+	st.Attributes[attr] = newStatusAttribute(attr, "Put Inhibited", -1)
+	attr = ATTR_Q_GET_INHIBITED
+	st.Attributes[attr] = newStatusAttribute(attr, "Get Inhibited", -1)
+	attr = ATTR_Q_TRIGGER_CONTROL
+	st.Attributes[attr] = newStatusAttribute(attr, "Trigger Control", -1)
+	attr = ATTR_Q_MONITORING
+	st.Attributes[attr] = newStatusAttribute(attr, "Queue Monitoring Level", -1)
+	st.Attributes[attr].enum = ENUM_Q_MONITORING
+	attr = ATTR_Q_NPM_CLASS
+	st.Attributes[attr] = newStatusAttribute(attr, "Nonpersistent Message Class", -1)
+	st.Attributes[attr].enum = ENUM_Q_NPM_CLASS
+
 	attr = ATTR_Q_QTIME_SHORT
 	st.Attributes[attr] = newStatusAttribute(attr, "Queue Time Short", ibmmq.MQIACF_Q_TIME_INDICATOR)
 	st.Attributes[attr].index = 0
@@ -170,17 +193,19 @@ func InquireQueues(patterns string) ([]string, error) {
 }
 
 func CollectQueueStatus(patterns string) error {
+	k := GetConnectionKey()
+	return collectQueueStatusFor(k, getConnection(k), patterns)
+}
+
+func collectQueueStatusFor(k string, ci *connectionInfo, patterns string) error {
 	var err error
 	traceEntry("CollectQueueStatus")
 
-	ci := getConnection(GetConnectionKey())
-	st := GetObjectStatus(GetConnectionKey(), OT_Q)
+	st := GetObjectStatus(k, OT_Q)
 	QueueInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
 	queuePatterns := strings.Split(patterns, ",")
 	if len(queuePatterns) == 0 {
@@ -192,7 +217,7 @@ func CollectQueueStatus(patterns string) error {
 	// list of queues and query status individually. Otherwise we can
 	// use regular MQ patterns to query queues in a batch.
 	if strings.Contains(patterns, "!") {
-		for qName, qi := range qInfoMap {
+		for qName, qi := range ci.qInfoMap {
 			if len(qName) == 0 || !qi.exists {
 				continue
 			}
@@ -214,6 +239,10 @@ func CollectQueueStatus(patterns string) error {
 			}
 		}
 	}
+	if err == nil {
+		collectBrowsedMsgAge(ci, st)
+	}
+	statusPostCollect(OT_Q)
 	traceExitErr("CollectQueueStatus", 0, err)
 	return err
 }
@@ -253,7 +282,7 @@ func collectQueueStatus(pattern string, instanceType int32) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExit("collectQueueStatus", 1)
 		return err
@@ -298,7 +327,7 @@ func collectResetQStats(pattern string) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectResetQueueStats", 1, err)
 		return err
@@ -316,9 +345,20 @@ func collectResetQStats(pattern string) error {
 	return err
 }
 
+// A generic queue name for an INQUIRE_Q command, with an optional WHERE filter
+type queueAttrRequest struct {
+	pattern string
+	filter  *ibmmq.PCFParameter
+}
+
 // Issue the INQUIRE_Q call for wildcarded queue names and
-// extract the required attributes
-func inquireQueueAttributes(objectPatternsList string) error {
+// extract the required attributes.
+//
+// All of the commands are put before any replies are read so the command server can
+// work on them together, and the replies are parsed on a separate goroutine while the
+// next one is being read. Only queues that are already in the qInfoMap are updated, so
+// the requests can be more generous than the list of monitored queues.
+func inquireQueueAttributes(requests []queueAttrRequest) error {
 	var err error
 
 	traceEntry("inquireQueueAttributes")
@@ -326,18 +366,14 @@ func inquireQueueAttributes(objectPatternsList string) error {
 	ci := getConnection(GetConnectionKey())
 	statusClearReplyQ()
 
-	if objectPatternsList == "" {
+	if len(requests) == 0 {
 		traceExitErr("inquireQueueAttributes", 1, err)
 		return err
 	}
 
-	objectPatterns := strings.Split(strings.TrimSpace(objectPatternsList), ",")
-	for i := 0; i < len(objectPatterns) && err == nil; i++ {
+	var msgIds [][]byte
+	for _, req := range requests {
 		var buf []byte
-		pattern := strings.TrimSpace(objectPatterns[i])
-		if len(pattern) == 0 {
-			continue
-		}
 
 		putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
 
@@ -349,39 +385,155 @@ func inquireQueueAttributes(objectPatternsList string) error {
 		pcfparm := new(ibmmq.PCFParameter)
 		pcfparm.Type = ibmmq.MQCFT_STRING
 		pcfparm.Parameter = ibmmq.MQCA_Q_NAME
-		pcfparm.String = []string{pattern}
+		pcfparm.String = []string{req.pattern}
+		cfh.ParameterCount++
+		buf = append(buf, pcfparm.Bytes()...)
+
+		// Only local queues are monitored, so the command server does not need to
+		// return anything else when given a generic name
+		pcfparm = new(ibmmq.PCFParameter)
+		pcfparm.Type = ibmmq.MQCFT_INTEGER
+		pcfparm.Parameter = ibmmq.MQIA_Q_TYPE
+		pcfparm.Int64Value = []int64{int64(ibmmq.MQQT_LOCAL)}
 		cfh.ParameterCount++
 		buf = append(buf, pcfparm.Bytes()...)
 
 		pcfparm = new(ibmmq.PCFParameter)
 		pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
 		pcfparm.Parameter = ibmmq.MQIACF_Q_ATTRS
-		pcfparm.Int64Value = []int64{int64(ibmmq.MQIA_MAX_Q_DEPTH), int64(ibmmq.MQIA_USAGE), int64(ibmmq.MQCA_Q_DESC), int64(ibmmq.MQCA_CLUSTER_NAME)}
+		pcfparm.Int64Value = []int64{int64(ibmmq.MQIA_MAX_Q_DEPTH), int64(ibmmq.MQIA_USAGE), int64(ibmmq.MQCA_Q_DESC), int64(ibmmq.MQCA_CLUSTER_NAME), int64(ibmmq.MQIA_MAX_MSG_LENGTH),
+			int64(ibmmq.MQIA_INHIBIT_PUT), int64(ibmmq.MQIA_INHIBIT_GET), int64(ibmmq.MQIA_TRIGGER_CONTROL)}
+		pcfparm.Int64Value = append(pcfparm.Int64Value, queueMonitoringSelectors(ci.si.platform)...)
 		cfh.ParameterCount++
 		buf = append(buf, pcfparm.Bytes()...)
 
+		if req.filter != nil {
+			cfh.ParameterCount++
+			buf = append(buf, req.filter.Bytes()...)
+		}
+
 		// Once we know the total number of parameters, put the
 		// CFH header on the front of the buffer.
 		buf = append(cfh.Bytes(), buf...)
 
 		// And now put the command to the queue
-		err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+		err = statusPutCommand(ci, putmqmd, pmo, buf)
 		if err != nil {
-			traceExitErr("inquireQueueAttributes", 2, err)
-			return err
+			break
 		}
+		msgIds = append(msgIds, putmqmd.MsgId)
+	}
 
+	// Each reply has its own buffer so it can be handed over to the parser
+	type qAttrReply struct {
+		cfh *ibmmq.MQCFH
+		buf []byte
+	}
+	replies := make(chan qAttrReply, 100)
+	done := make(chan struct{})
+	go func() {
+		for r := range replies {
+			parseQAttrData(r.cfh, r.buf)
+		}
+		close(done)
+	}()
+
+	for i, msgId := range msgIds {
+		noReply := false
 		for allReceived := false; !allReceived; {
-			cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+			var cfh *ibmmq.MQCFH
+			var buf []byte
+			var replyErr error
+			cfh, buf, allReceived, replyErr = statusGetReply(msgId)
+			// Keep the first error, but still look for the replies to the other commands
+			if replyErr != nil && err == nil {
+				err = replyErr
+			}
+			if mqreturn, ok := replyErr.(*ibmmq.MQReturn); ok && mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+				noReply = true
+			}
 			if buf != nil {
-				parseQAttrData(cfh, buf)
+				replies <- qAttrReply{cfh, buf}
+			}
+		}
+		// The command server works through the commands in order, so if it has not answered
+		// this one in time, it will not have answered the later ones either. Any replies that
+		// arrive afterwards are removed before the next command.
+		if noReply {
+			for _, m := range msgIds[i+1:] {
+				ci.commands.done(m)
 			}
+			break
 		}
 	}
+	close(replies)
+	<-done
+
+	if err != nil {
+		traceExitErr("inquireQueueAttributes", 2, err)
+		return err
+	}
 	traceExit("inquireQueueAttributes", 0)
 	return nil
 }
 
+/*
+Reduce the monitored queue patterns to the generic names that cover every queue they
+could select, so that attributes can be requested with a few commands instead of one
+for each queue. Exclusions are not needed as parseQAttrData ignores unmonitored queues,
+and generic names cannot be excluded in a WHERE filter anyway. The presets use a WHERE
+filter or a generic name where they can, and @NOSYSTEM on its own needs every queue.
+*/
+func queueAttrRequests(monitoredQueuePatterns string) []queueAttrRequest {
+	var requests []queueAttrRequest
+	all := []queueAttrRequest{{pattern: "*"}}
+	seen := make(map[string]bool)
+
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			requests = append(requests, queueAttrRequest{pattern: p})
+		}
+	}
+
+	others, presets, err := splitPresets(monitoredQueuePatterns)
+	if err != nil {
+		return all
+	}
+	for _, p := range strings.Split(others, ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "" || strings.HasPrefix(p, "!"):
+			continue
+		case p == "*":
+			return all
+		default:
+			add(p)
+		}
+	}
+
+	if presets.noSystem && len(requests) == 0 {
+		return all
+	}
+	if presets.clusterQ {
+		add("SYSTEM.CLUSTER.*")
+	}
+	if presets.xmitQ {
+		filter := new(ibmmq.PCFParameter)
+		filter.Type = ibmmq.MQCFT_INTEGER_FILTER
+		filter.Parameter = ibmmq.MQIA_USAGE
+		filter.Operator = ibmmq.MQCFOP_EQUAL
+		filter.Int64Value = []int64{int64(ibmmq.MQUS_TRANSMISSION)}
+		requests = append(requests, queueAttrRequest{pattern: "*", filter: filter})
+	}
+
+	// Only exclusions were given
+	if len(requests) == 0 {
+		return all
+	}
+	return requests
+}
+
 // Given a PCF response message, parse it to extract the desired statistics
 func parseQData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	var elem *ibmmq.PCFParameter
@@ -389,6 +541,7 @@ func parseQData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	traceEntry("parseQData")
 
 	st := GetObjectStatus(GetConnectionKey(), OT_Q)
+	ci := getConnection(GetConnectionKey())
 
 	qName := ""
 	key := ""
@@ -427,6 +580,9 @@ func parseQData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	key = qName
 	st.Attributes[ATTR_Q_NAME].Values[key] = newStatusValueString(qName)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_Q), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -455,11 +611,25 @@ func parseQData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	now := time.Now()
 	st.Attributes[ATTR_Q_SINCE_PUT].Values[key] = newStatusValueInt64(statusTimeDiff(now, lastPutDate, lastPutTime))
 	st.Attributes[ATTR_Q_SINCE_GET].Values[key] = newStatusValueInt64(statusTimeDiff(now, lastGetDate, lastGetTime))
-	if s, ok := qInfoMap[key]; ok {
+	if s, ok := ci.qInfoMap[key]; ok {
 		maxDepth := s.AttrMaxDepth
 		st.Attributes[ATTR_Q_MAX_DEPTH].Values[key] = newStatusValueInt64(maxDepth)
+		if s.AttrMaxMsgLength > 0 {
+			st.Attributes[ATTR_Q_MAX_MSGL].Values[key] = newStatusValueInt64(s.AttrMaxMsgLength)
+		}
 		usage := s.AttrUsage
 		st.Attributes[ATTR_Q_USAGE].Values[key] = newStatusValueInt64(usage)
+		if s.inhibitKnown {
+			st.Attributes[ATTR_Q_PUT_INHIBITED].Values[key] = newStatusValueInt64(s.AttrInhibitPut)
+			st.Attributes[ATTR_Q_GET_INHIBITED].Values[key] = newStatusValueInt64(s.AttrInhibitGet)
+			st.Attributes[ATTR_Q_TRIGGER_CONTROL].Values[key] = newStatusValueInt64(s.AttrTriggerControl)
+		}
+		if s.monitoringKnown {
+			st.Attributes[ATTR_Q_MONITORING].Values[key] = newStatusValueInt64(s.AttrMonitoring)
+		}
+		if s.npmClassKnown {
+			st.Attributes[ATTR_Q_NPM_CLASS].Values[key] = newStatusValueInt64(s.AttrNPMClass)
+		}
 	}
 	traceExitF("parseQData", 0, "Key: %s", key)
 	return key
@@ -506,6 +676,9 @@ func parseResetQStatsData(cfh *ibmmq.MQCFH, buf []byte) string {
 
 	st.Attributes[ATTR_Q_NAME].Values[key] = newStatusValueString(qName)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_Q), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -527,6 +700,7 @@ func parseResetQStatsData(cfh *ibmmq.MQCFH, buf []byte) string {
 func parseQAttrData(cfh *ibmmq.MQCFH, buf []byte) {
 	var elem *ibmmq.PCFParameter
 	traceEntry("parseQAttrData")
+	ci := getConnection(GetConnectionKey())
 	qName := ""
 
 	parmAvail := true
@@ -568,7 +742,7 @@ func parseQAttrData(cfh *ibmmq.MQCFH, buf []byte) {
 		case ibmmq.MQIA_MAX_Q_DEPTH:
 			v := elem.Int64Value[0]
 			if v > 0 {
-				if qInfo, ok := qInfoMap[qName]; ok {
+				if qInfo, ok := ci.qInfoMap[qName]; ok {
 					qInfo.AttrMaxDepth = v
 				}
 			}
@@ -576,14 +750,52 @@ func parseQAttrData(cfh *ibmmq.MQCFH, buf []byte) {
 		case ibmmq.MQIA_USAGE:
 			v := elem.Int64Value[0]
 			if v > 0 {
-				if qInfo, ok := qInfoMap[qName]; ok {
+				if qInfo, ok := ci.qInfoMap[qName]; ok {
 					qInfo.AttrUsage = v
 				}
 			}
+		case ibmmq.MQIA_MAX_MSG_LENGTH:
+			v := elem.Int64Value[0]
+			if v > 0 {
+				if qInfo, ok := ci.qInfoMap[qName]; ok {
+					qInfo.AttrMaxMsgLength = v
+				}
+			}
+		case ibmmq.MQIA_INHIBIT_PUT, ibmmq.MQIA_INHIBIT_GET, ibmmq.MQIA_TRIGGER_CONTROL:
+			// The attribute values are already 0 or 1
+			if qInfo, ok := ci.qInfoMap[qName]; ok {
+				v := elem.Int64Value[0]
+				switch elem.Parameter {
+				case ibmmq.MQIA_INHIBIT_PUT:
+					qInfo.AttrInhibitPut = v
+				case ibmmq.MQIA_INHIBIT_GET:
+					qInfo.AttrInhibitGet = v
+				default:
+					qInfo.AttrTriggerControl = v
+				}
+				qInfo.inhibitKnown = true
+			}
+		case ibmmq.MQIA_MONITORING_Q, ibmmq.MQIA_STATISTICS_Q, ibmmq.MQIA_ACCOUNTING_Q:
+			if qInfo, ok := ci.qInfoMap[qName]; ok {
+				v := elem.Int64Value[0]
+				if qInfo.monitoringValues == nil {
+					qInfo.monitoringValues = make(map[int32]int64)
+				}
+				qInfo.monitoringValues[elem.Parameter] = v
+				if elem.Parameter == ibmmq.MQIA_MONITORING_Q {
+					qInfo.AttrMonitoring = v
+					qInfo.monitoringKnown = true
+				}
+			}
+		case ibmmq.MQIA_NPM_CLASS:
+			if qInfo, ok := ci.qInfoMap[qName]; ok {
+				qInfo.AttrNPMClass = elem.Int64Value[0]
+				qInfo.npmClassKnown = true
+			}
 		case ibmmq.MQCA_Q_DESC:
 			v := elem.String[0]
 			if v != "" {
-				if qInfo, ok := qInfoMap[qName]; ok {
+				if qInfo, ok := ci.qInfoMap[qName]; ok {
 					qInfo.Description = printableStringUTF8(v)
 				}
 			}
@@ -591,7 +803,7 @@ func parseQAttrData(cfh *ibmmq.MQCFH, buf []byte) {
 		case ibmmq.MQCA_CLUSTER_NAME:
 			v := elem.String[0]
 			if v != "" {
-				if qInfo, ok := qInfoMap[qName]; ok {
+				if qInfo, ok := ci.qInfoMap[qName]; ok {
 					qInfo.Cluster = printableStringUTF8(v)
 				}
 			}
@@ -611,11 +823,12 @@ func QueueNormalise(attr *StatusAttribute, v int64) float64 {
 // Return the nominated MQCA* attribute from the object's attributes
 // stored in the map
 func GetQueueAttribute(key string, attribute int32) string {
+	ci := getConnection(GetConnectionKey())
 	var o *ObjInfo
 	v := "-"
 	ok := false
 
-	o, ok = qInfoMap[key]
+	o, ok = ci.qInfoMap[key]
 
 	if !ok {
 		// return something so Prometheus doesn't turn it into "0.0"
@@ -626,12 +839,7 @@ func GetQueueAttribute(key string, attribute int32) string {
 	case ibmmq.MQCA_CLUSTER_NAME:
 		v = o.Cluster
 	default:
-		v = "-"
-	}
-	v = strings.TrimSpace(v)
-
-	if v == "" {
-		v = "-"
+		v = DUMMY_STRING
 	}
-	return v
+	return labelValue(v)
 }
@@ -0,0 +1,98 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file paces the PCF commands sent by the status collection functions. A collector
+monitoring thousands of channels or queues can otherwise send a burst of commands at the
+start of every scrape, and if several collectors are scraped at the same moment the
+command server's queue fills up.
+
+Two settings in the ConnectionConfig control it:
+
+  - CommandRate is the most commands per second. Commands beyond that are delayed, not
+    dropped, so a collection takes longer but asks for the same data.
+  - CommandJitter, in milliseconds, adds a random delay to the first command after a
+    quiet period, so that collectors that are scraped together do not all start at once.
+
+Only the commands from the status and attribute inquiries are paced. The publications for
+resource statistics do not need commands once the subscriptions have been made.
+*/
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A command after this long without any is treated as the start of a new collection
+const commandQuietPeriod = time.Second
+
+type commandLimiter struct {
+	sync.Mutex
+	interval time.Duration // Between commands. 0 is unlimited
+	jitter   time.Duration
+	next     time.Time // Earliest time for the next command
+	last     time.Time
+	rand     *rand.Rand
+}
+
+// Returns nil if there is nothing to limit
+func newCommandLimiter(rate float64, jitterMs int) *commandLimiter {
+	if rate <= 0 && jitterMs <= 0 {
+		return nil
+	}
+	l := &commandLimiter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	if jitterMs > 0 {
+		l.jitter = time.Duration(jitterMs) * time.Millisecond
+	}
+	return l
+}
+
+// Reserve the time for the next command, returning how long to wait for it
+func (l *commandLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	slot := now
+	if l.jitter > 0 && now.Sub(l.last) >= commandQuietPeriod {
+		slot = slot.Add(time.Duration(l.rand.Int63n(int64(l.jitter))))
+	}
+	if slot.Before(l.next) {
+		slot = l.next
+	}
+	l.next = slot.Add(l.interval)
+	l.last = slot
+	return slot.Sub(now)
+}
+
+// Wait until the next command can be sent
+func (l *commandLimiter) wait() {
+	if l == nil {
+		return
+	}
+	if d := l.reserve(time.Now()); d > 0 {
+		logDebug("Delaying PCF command for %v", d)
+		time.Sleep(d)
+	}
+}
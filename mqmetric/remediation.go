@@ -0,0 +1,212 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lets threshold rules do something about a problem as well as report it. A
+rule can name actions, which are Go functions called when the rule becomes breached
+for an object. They run after the alerts have been sent, on the same goroutine as
+EvaluateThresholds, so an action that issues PCF commands uses the collector's own
+connection.
+
+Actions are registered by name so that they can be used in a rules file. The
+"start_channel" action is always available; PCFCommandAction builds others that
+send a command naming the object.
+
+Every breach, clear, suppressed duplicate and action is written to the audit writer
+set by SetRuleAuditWriter, as one JSON record per line, and to the log.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+/*
+RuleAction is called when a rule becomes breached for an object. A returned error
+is audited and logged but does not stop any other actions.
+*/
+type RuleAction func(a *ThresholdAlert) error
+
+// The events in the audit records
+const (
+	RULE_EVENT_BREACHED   = "breached"
+	RULE_EVENT_CLEARED    = "cleared"
+	RULE_EVENT_SUPPRESSED = "suppressed"
+	RULE_EVENT_ACTION     = "action"
+)
+
+/*
+RuleAuditRecord is one line of the audit log
+*/
+type RuleAuditRecord struct {
+	Time     time.Time `json:"time"`
+	QMgrName string    `json:"queueManager"`
+	Rule     string    `json:"rule"`
+	Object   string    `json:"object"`
+	Metric   string    `json:"metric"`
+	Value    float64   `json:"value"`
+	Event    string    `json:"event"`
+	Action   string    `json:"action,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var ruleActions = struct {
+	sync.Mutex
+	m map[string]RuleAction
+}{m: map[string]RuleAction{
+	"start_channel": PCFCommandAction(ibmmq.MQCMD_START_CHANNEL, ibmmq.MQCACH_CHANNEL_NAME),
+}}
+
+/*
+RegisterRuleAction makes an action available to rules by name. A nil function
+removes it.
+*/
+func RegisterRuleAction(name string, f RuleAction) {
+	ruleActions.Lock()
+	defer ruleActions.Unlock()
+	if f == nil {
+		delete(ruleActions.m, name)
+	} else {
+		ruleActions.m[name] = f
+	}
+}
+
+func getRuleAction(name string) (RuleAction, bool) {
+	ruleActions.Lock()
+	defer ruleActions.Unlock()
+	f, ok := ruleActions.m[name]
+	return f, ok
+}
+
+/*
+SetRuleAuditWriter sets where the audit records for the current connection are
+written. A nil writer stops them; the events are still logged.
+*/
+func SetRuleAuditWriter(w io.Writer) {
+	ci := getConnection(GetConnectionKey())
+	ci.ruleAudit = w
+}
+
+/*
+PCFCommandAction returns an action that sends a PCF command to the queue manager
+with the object name as its only parameter, and waits for the reply. For status
+metrics with several parts in the object key, such as channels, the first part
+is used.
+*/
+func PCFCommandAction(command int32, nameParameter int32) RuleAction {
+	return func(a *ThresholdAlert) error {
+		return objectCommand(command, nameParameter, strings.SplitN(a.Object, "/", 2)[0])
+	}
+}
+
+func objectCommand(command int32, nameParameter int32, name string) error {
+	var err error
+
+	traceEntryF("objectCommand", "Command: %d Name: %s", command, name)
+
+	ci := getConnection(GetConnectionKey())
+	statusClearReplyQ()
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = command
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = nameParameter
+	pcfparm.String = []string{name}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("objectCommand", 1, err)
+		return err
+	}
+
+	var cmdErr error
+	for allReceived := false; !allReceived; {
+		cfh, _, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if cfh != nil && cfh.Reason != ibmmq.MQRC_NONE && cmdErr == nil {
+			cmdErr = &ibmmq.MQReturn{MQCC: cfh.CompCode, MQRC: cfh.Reason}
+		}
+	}
+	if err == nil {
+		err = cmdErr
+	}
+
+	traceExitErr("objectCommand", 0, err)
+	return err
+}
+
+// Call the rule's actions for a breach
+func runRuleActions(ci *connectionInfo, r *thresholdRule, a *ThresholdAlert) {
+	for _, name := range r.Actions {
+		f, ok := getRuleAction(name)
+		var err error
+		if !ok {
+			err = fmt.Errorf("Action '%s' is not registered", name)
+		} else {
+			err = f(a)
+		}
+		auditRuleEvent(ci, a, RULE_EVENT_ACTION, name, err)
+	}
+}
+
+// Write an audit record and log the event
+func auditRuleEvent(ci *connectionInfo, a *ThresholdAlert, event string, action string, err error) {
+	rec := RuleAuditRecord{
+		Time:     a.Time,
+		QMgrName: a.QMgrName,
+		Rule:     a.Rule,
+		Object:   a.Object,
+		Metric:   a.Metric,
+		Value:    a.Value,
+		Event:    event,
+		Action:   action,
+	}
+	desc := event
+	if action != "" {
+		desc = event + " " + action
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		logError("Rule %s %s for %s failed: %v", a.Rule, desc, a.Object, err)
+	} else {
+		logInfo("Rule %s %s for %s", a.Rule, desc, a.Object)
+	}
+
+	if ci.ruleAudit != nil {
+		b, e := json.Marshal(rec)
+		if e == nil {
+			_, e = ci.ruleAudit.Write(append(b, '\n'))
+		}
+		if e != nil {
+			logError("Cannot write rule audit record: %v", e)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2016, 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+Functions in this file allow the raw PCF messages used for metadata discovery and
+the published resource statistics to be saved to a file from a live system, and then
+replayed later without needing a queue manager.
+
+A recording is started with StartRecording after the connection has been made. Each
+metadata message is stored along with the topic it came from, and each publication
+is stored along with a marker showing which call to ProcessPublications read it.
+
+A replay is started with InitReplay instead of InitConnection. The normal DiscoverAndSubscribe
+and ProcessPublications calls can then be used, with each ProcessPublications call
+returning the same set of messages as the corresponding call in the recording. This
+makes it possible to build regression tests using data from different versions of MQ,
+and to attach the data needed to reproduce a problem to a bug report.
+
+The file is a sequence of JSON records, one per line. The message bodies are base64-encoded
+by the JSON encoder, so the files are not especially compact, but they can easily be
+inspected and edited with standard tools.
+
+Only the publication-based metrics are recorded. Replies to the status commands
+are not saved, and the CollectXXXStatus functions cannot be used during a replay.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+const (
+	REPLAY_HEADER   = "header"
+	REPLAY_META     = "meta"
+	REPLAY_INTERVAL = "interval"
+	REPLAY_PUB      = "pub"
+)
+
+// ReplayRecord is a single entry in a recording. Which fields are
+// filled in depends on the Kind of the record.
+type ReplayRecord struct {
+	Kind         string
+	Time         time.Time
+	QMgrName     string `json:",omitempty"`
+	Platform     int32  `json:",omitempty"`
+	CommandLevel int32  `json:",omitempty"`
+	Topic        string `json:",omitempty"`
+	Data         []byte `json:",omitempty"`
+}
+
+type replayRecorder struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+type replayPlayer struct {
+	meta       map[string][]byte
+	intervals  [][][]byte
+	next       int // Which interval is to be returned by the next ProcessPublications
+	current    [][]byte
+	queueNames []string
+}
+
+/*
+StartRecording creates the named file and begins writing the metadata and
+publication messages to it. The connection must already have been made.
+It is usually called before DiscoverAndSubscribe so that the recording contains
+everything needed for a replay.
+*/
+func StartRecording(fileName string) error {
+	traceEntryF("StartRecording", "File: %s", fileName)
+
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || !ci.si.qmgrConnected {
+		err := fmt.Errorf("Not connected to a queue manager")
+		traceExitErr("StartRecording", 1, err)
+		return err
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		traceExitErr("StartRecording", 2, err)
+		return err
+	}
+
+	r := &replayRecorder{f: f, enc: json.NewEncoder(f)}
+	err = r.enc.Encode(&ReplayRecord{Kind: REPLAY_HEADER,
+		Time:         time.Now(),
+		QMgrName:     ci.si.resolvedQMgrName,
+		Platform:     ci.si.platform,
+		CommandLevel: ci.si.commandLevel})
+	if err != nil {
+		f.Close()
+		traceExitErr("StartRecording", 3, err)
+		return err
+	}
+	ci.recorder = r
+
+	traceExit("StartRecording", 0)
+	return nil
+}
+
+/*
+StopRecording closes the file created by StartRecording. It is not
+an error to call it when there is no active recording.
+*/
+func StopRecording() error {
+	var err error
+
+	traceEntry("StopRecording")
+
+	ci := getConnection(GetConnectionKey())
+	if ci != nil && ci.recorder != nil {
+		r := ci.recorder
+		r.Lock()
+		err = r.f.Close()
+		r.Unlock()
+		ci.recorder = nil
+	}
+
+	traceExitErr("StopRecording", 0, err)
+	return err
+}
+
+// Write a message to the recording. Failures are logged but are otherwise
+// ignored as we don't want the recording to stop the real collection.
+func (r *replayRecorder) record(kind string, topic string, data []byte) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// Take a copy as the data will usually be in a reused buffer
+	d := make([]byte, len(data))
+	copy(d, data)
+	err := r.enc.Encode(&ReplayRecord{Kind: kind, Time: time.Now(), Topic: topic, Data: d})
+	if err != nil {
+		logError("Cannot write to recording file %s: %v", r.f.Name(), err)
+	}
+}
+
+/*
+InitReplay is used instead of InitConnection to load a recording made by StartRecording.
+No connection is made to a queue manager. The queue manager name, platform and command
+level are taken from the recording.
+*/
+func InitReplay(fileName string) error {
+	return initReplayKey("", fileName)
+}
+func InitReplayKey(key string, fileName string) error {
+	return initReplayKey(key, fileName)
+}
+
+func initReplayKey(key string, fileName string) error {
+	traceEntryF("initReplayKey", "File: %s", fileName)
+
+	p, hdr, err := readRecording(fileName)
+	if err != nil {
+		traceExitErr("initReplayKey", 1, err)
+		return err
+	}
+
+	initConnection(key)
+	ci := getConnection(GetConnectionKey())
+
+	ci.si.resolvedQMgrName = hdr.QMgrName
+	ci.si.platform = hdr.Platform
+	ci.si.commandLevel = hdr.CommandLevel
+	ci.usePublications = true
+	ci.useStatus = false
+	ci.replay = p
+
+	ci.qMgrInfo.QMgrName = hdr.QMgrName
+
+	logInfo("Replaying %d intervals from %s for queue manager %s", len(p.intervals), fileName, hdr.QMgrName)
+
+	traceExit("initReplayKey", 0)
+	return nil
+}
+
+// Load the complete file into memory. The header must be the first record.
+func readRecording(fileName string) (*replayPlayer, *ReplayRecord, error) {
+	var hdr *ReplayRecord
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	p := new(replayPlayer)
+	p.meta = make(map[string][]byte)
+	p.intervals = make([][][]byte, 0)
+	queues := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxBufSize)
+	for line := 1; scanner.Scan(); line++ {
+		r := new(ReplayRecord)
+		if err = json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, nil, fmt.Errorf("Cannot parse %s line %d: %v", fileName, line, err)
+		}
+
+		if hdr == nil && r.Kind != REPLAY_HEADER {
+			return nil, nil, fmt.Errorf("File %s is not a recording: missing header", fileName)
+		}
+
+		switch r.Kind {
+		case REPLAY_HEADER:
+			hdr = r
+		case REPLAY_META:
+			p.meta[r.Topic] = r.Data
+		case REPLAY_INTERVAL:
+			p.intervals = append(p.intervals, make([][]byte, 0))
+		case REPLAY_PUB:
+			if len(p.intervals) == 0 {
+				p.intervals = append(p.intervals, make([][]byte, 0))
+			}
+			p.intervals[len(p.intervals)-1] = append(p.intervals[len(p.intervals)-1], r.Data)
+			// Remember the queue names so that wildcard patterns can be resolved
+			// without having to ask a queue manager
+			if qName := publicationQueueName(r.Data); qName != "" {
+				queues[qName] = true
+			}
+		default:
+			logDebug("Ignoring unknown record type %s in %s", r.Kind, fileName)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if hdr == nil {
+		return nil, nil, fmt.Errorf("File %s is not a recording: missing header", fileName)
+	}
+
+	for q := range queues {
+		p.queueNames = append(p.queueNames, q)
+	}
+
+	return p, hdr, nil
+}
+
+func publicationQueueName(data []byte) string {
+	elemList, _ := parsePCFResponse(data)
+	for _, elem := range elemList {
+		if elem.Parameter == ibmmq.MQCA_Q_NAME && len(elem.String) > 0 {
+			return strings.TrimSpace(elem.String[0])
+		}
+	}
+	return ""
+}
+
+// Return the recorded metadata for a topic, or the error that a real subscription
+// would have given if nothing was published there.
+func (p *replayPlayer) metadata(topic string) ([]byte, error) {
+	if data, ok := p.meta[topic]; ok {
+		return data, nil
+	}
+	return nil, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_NO_MSG_AVAILABLE}
+}
+
+// Move on to the set of publications read by the next ProcessPublications call
+// in the recording. Once they have all been used, there are no more publications.
+func (p *replayPlayer) startInterval() {
+	if p.next < len(p.intervals) {
+		p.current = p.intervals[p.next]
+		p.next++
+	} else {
+		p.current = nil
+	}
+}
+
+func (p *replayPlayer) publication() ([]byte, error) {
+	if len(p.current) == 0 {
+		return nil, &ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_NO_MSG_AVAILABLE}
+	}
+	data := p.current[0]
+	p.current = p.current[1:]
+	return data, nil
+}
+
+// Equivalent of discoverQueues, but using the names found in the recorded publications
+func (p *replayPlayer) discoverQueues(monitoredQueuePatterns string) error {
+	var err error
+	ci := getConnection(GetConnectionKey())
+
+	qList := p.queueNames
+	if hasPresets(monitoredQueuePatterns) {
+		// The recording does not say which queues are transmission queues
+		qList, err = filterWithPresets(monitoredQueuePatterns, qList, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		qList = FilterRegExp(monitoredQueuePatterns, qList)
+	}
+	for _, qName := range qList {
+		qInfoElem, ok := ci.qInfoMap[qName]
+		if !ok {
+			qInfoElem = new(ObjInfo)
+		}
+		qInfoElem.AttrMaxDepth = defaultMaxQDepth
+		qInfoElem.exists = true
+		ci.qInfoMap[qName] = qInfoElem
+	}
+	return nil
+}
+
+// IsReplay says whether the current connection is using a recording instead
+// of a queue manager
+func IsReplay() bool {
+	ci := getConnection(GetConnectionKey())
+	return ci != nil && ci.replay != nil
+}
+
+/*
+getMetadata subscribes to a metadata topic and returns the single retained
+publication from it. When replaying, the data comes from the recording instead.
+If a predefined queue has been configured for the metadata, the subscription
+sends the publication there, and we use the CorrelId to pick out the right message.
+*/
+func getMetadata(topic string, wait bool) ([]byte, error) {
+	var metaReplyQObj ibmmq.Object
+
+	traceEntryF("getMetadata", "Topic: %s", topic)
+
+	ci := getConnection(GetConnectionKey())
+	if ci.replay != nil {
+		data, err := ci.replay.metadata(topic)
+		traceExitErr("getMetadata", 1, err)
+		return data, err
+	}
+
+	var data []byte
+	var mqtd *MQTopicDescriptor
+	var err error
+
+	if ci.si.metadataQBaseName != "" {
+		mqtd, err = subscribe(topic, &ci.si.metadataQObj)
+		if err != nil {
+			traceExitErr("getMetadata", 2, err)
+			return nil, err
+		}
+		data, err = getMessageWithCorrelId(wait, ci.si.metadataQObj, mqtd.correlId)
+		mqtd.unsubscribe()
+	} else {
+		mqtd, err = subscribeManaged(topic, &metaReplyQObj)
+		if err != nil {
+			traceExitErr("getMetadata", 3, err)
+			return nil, err
+		}
+		data, err = getMessageWithHObj(wait, metaReplyQObj)
+		metaReplyQObj.Close(0)
+		mqtd.unsubscribe()
+	}
+
+	if err == nil {
+		ci.recorder.record(REPLAY_META, topic, data)
+	}
+
+	traceExitErr("getMetadata", 0, err)
+	return data, err
+}
+
+// Get the next publication, either from the real reply queue or from a recording. Any
+// publications kept back by the previous collection come first. The put time is
+// not known for a recording.
+func getPublication(ci *connectionInfo) ([]byte, time.Time, error) {
+	if ci.replay != nil {
+		data, err := ci.replay.publication()
+		return data, time.Time{}, err
+	}
+	if ci.pubWindow != nil {
+		if data, putTime, ok := ci.pubWindow.next(); ok {
+			return data, putTime, nil
+		}
+	}
+
+	// Publications are read from the queue in batches. Any error is returned
+	// once the messages already read have been used.
+	if len(ci.pubBatch) == 0 {
+		msgs, err := getMessageBatch(ci)
+		if len(msgs) == 0 {
+			return nil, time.Time{}, err
+		}
+		ci.pubBatch = msgs
+	}
+
+	data := ci.pubBatch[0].Data
+	putTime := ci.pubBatch[0].MD.PutDateTime
+	ci.clockSkew.observe(putTime, time.Now())
+	ci.pubBatch = ci.pubBatch[1:]
+	ci.recorder.record(REPLAY_PUB, "", data)
+	return data, putTime, nil
+}
+
+// Mark the start of a ProcessPublications call
+func startPublicationInterval(ci *connectionInfo) {
+	ci.pubWaitDue = true
+	if ci.replay != nil {
+		ci.replay.startInterval()
+	} else {
+		ci.recorder.record(REPLAY_INTERVAL, "", nil)
+	}
+}
@@ -0,0 +1,160 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lets several PCF commands be outstanding at the same time on the status
+reply queue. Every command is put with a new MsgId, and the command server uses that as
+the CorrelId of each of its replies, so statusGetReply only ever sees its own replies.
+What has to be coordinated is:
+
+  - Clearing the reply queue before a command, which must not throw away replies
+    for another command that is still in progress
+  - Waiting for a reply, as only one MQGET at a time can be made on the connection
+
+A command is counted as outstanding from when it is put until its last reply is read, or
+until nothing has been heard for it for the normal wait interval.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/v5/ibmmq"
+)
+
+// How long a waiting MQGET holds the connection when other commands are outstanding
+const replyWaitSlice = 100 * time.Millisecond
+
+type commandTracker struct {
+	sync.Mutex
+	getLock  sync.Mutex
+	inFlight map[string]time.Time // Keyed by MsgId, with the time after which the command is abandoned
+}
+
+func newCommandTracker() *commandTracker {
+	return &commandTracker{inFlight: make(map[string]time.Time)}
+}
+
+// Record activity for a command, extending its expiry time
+func (t *commandTracker) touch(msgId []byte, wait time.Duration) {
+	t.Lock()
+	t.inFlight[string(msgId)] = time.Now().Add(wait)
+	t.Unlock()
+}
+
+func (t *commandTracker) done(msgId []byte) {
+	t.Lock()
+	delete(t.inFlight, string(msgId))
+	t.Unlock()
+}
+
+// Returns how many commands are outstanding, after dropping any that have expired
+func (t *commandTracker) outstanding() int {
+	now := time.Now()
+	t.Lock()
+	defer t.Unlock()
+	for k, expiry := range t.inFlight {
+		if now.After(expiry) {
+			delete(t.inFlight, k)
+		}
+	}
+	return len(t.inFlight)
+}
+
+// Put a command message to the command server and record it as outstanding
+func statusPutCommand(ci *connectionInfo, putmqmd *ibmmq.MQMD, pmo *ibmmq.MQPMO, buf []byte) error {
+	traceEntry("statusPutCommand")
+
+	if !ci.cmdBreaker.allow(time.Now()) {
+		err := errCommandServerSuspended()
+		traceExitErr("statusPutCommand", 1, err)
+		return err
+	}
+
+	ci.limiter.wait()
+	tracePCF("command", buf)
+	err := ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	if err == nil {
+		ci.commands.touch(putmqmd.MsgId, time.Duration(ci.waitInterval)*time.Second)
+	} else {
+		recordAuthFailure(AUTH_OP_PUT, err)
+	}
+
+	traceExitErr("statusPutCommand", 0, err)
+	return err
+}
+
+/*
+Get the next reply for a command. When this is the only outstanding command, it is a
+single MQGET with the full wait interval. Otherwise the wait is split into short slices
+so that other goroutines can read their own replies in between.
+*/
+func statusGetReplyMessage(ci *connectionInfo, correlId []byte, replyBuf []byte) (int, error) {
+	var datalen int
+	var err error
+
+	wait := ci.cmdWait.interval()
+	sliced := ci.commands.outstanding() > 1
+	deadline := time.Now().Add(wait)
+
+	for {
+		getmqmd := ibmmq.NewMQMD()
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT
+		gmo.Options |= ibmmq.MQGMO_FAIL_IF_QUIESCING
+		gmo.Options |= ibmmq.MQGMO_WAIT
+		gmo.Options |= ibmmq.MQGMO_CONVERT
+		gmo.WaitInterval = int32(wait / time.Millisecond) // 3 seconds by default
+
+		getmqmd.CorrelId = correlId
+		gmo.MatchOptions = ibmmq.MQMO_MATCH_CORREL_ID
+		gmo.Version = ibmmq.MQGMO_VERSION_2
+
+		if sliced {
+			remaining := time.Until(deadline)
+			if remaining > replyWaitSlice {
+				remaining = replyWaitSlice
+			}
+			if remaining < 0 {
+				remaining = 0
+			}
+			gmo.WaitInterval = int32(remaining / time.Millisecond)
+		}
+
+		ci.commands.getLock.Lock()
+		datalen, err = ci.si.statusReplyQObj.Get(getmqmd, gmo, replyBuf)
+		ci.commands.getLock.Unlock()
+
+		if !sliced || err == nil || err.(*ibmmq.MQReturn).MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE || !time.Now().Before(deadline) {
+			break
+		}
+	}
+
+	if err == nil {
+		ci.commands.touch(correlId, wait)
+	} else {
+		recordAuthFailure(AUTH_OP_GET, err)
+	}
+	replied := err == nil || err.(*ibmmq.MQReturn).MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE
+	ci.cmdWait.result(replied)
+	ci.cmdBreaker.result(replied, time.Now())
+	return datalen, err
+}
@@ -26,10 +26,11 @@ package mqmetric
 
 import (
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	ibmmq "github.com/ibm-messaging/mq-golang/v5/ibmmq"
+	"github.com/ibm-messaging/mq-golang/v5/mqutil"
 )
 
 var statusDummy = fmt.Sprintf("dummy")
@@ -50,20 +51,38 @@ type StatusAttribute struct {
 	index       int
 	Values      map[string]*StatusValue
 	prevValues  map[string]int64
+	transform   TransformFunc
+	derive      DeriveFunc
+	enum        string // One of the ENUM_* values if this is an enumerated value
 }
 
 type StatusSet struct {
 	Attributes map[string]*StatusAttribute
+
+	// When each object's counters started, if known, and which objects
+	// have been seen to reset their counters in the current collection
+	epochs    map[string]string
+	resetKeys map[string]bool
 }
 
-// All we care about for attributes are ints and strings. Other complex
-// PCF datatypes are not currently going to be returned through this mechanism
+// Values can be ints, floats or strings. Most come straight from the PCF responses,
+// but floats are used for values that are calculated. Other complex PCF datatypes
+// are not currently going to be returned through this mechanism.
 type StatusValue struct {
-	IsInt64     bool
-	ValueInt64  int64
-	ValueString string
+	IsInt64      bool
+	ValueInt64   int64
+	IsFloat64    bool
+	ValueFloat64 float64
+	ValueString  string
 }
 
+// The types of data held in a StatusValue
+const (
+	STATUS_TYPE_STRING = iota
+	STATUS_TYPE_INT64
+	STATUS_TYPE_FLOAT64
+)
+
 // Initialise with default values.
 func newStatusAttribute(n string, d string, p int32) *StatusAttribute {
 	s := new(StatusAttribute)
@@ -94,20 +113,181 @@ func newStatusValueInt64(v int64) *StatusValue {
 
 func newStatusValueString(v string) *StatusValue {
 	s := new(StatusValue)
-	s.ValueString = v
+	s.ValueString = intern(trimMQString(v))
 	s.IsInt64 = false
 	return s
 }
 
-// Go uses an example-based method for formatting and parsing timestamps
-// This layout matches the MQ PutDate and PutTime strings. An additional TZ
-// may eventually have to be turned into a config parm. Note the "15" to indicate
-// a 24-hour timestamp. There also seems to be two formats for the time layout coming
-// from MQ - TPSTATUS uses a colon format time, QSTATUS uses the dots.
-const timeStampLayoutDot = "2006-01-02 15.04.05"
-const timeStampLayoutColon = "2006-01-02 15:04:05"
+func newStatusValueFloat64(v float64) *StatusValue {
+	s := new(StatusValue)
+	s.ValueFloat64 = v
+	s.IsFloat64 = true
+	return s
+}
+
+/*
+Type returns one of the STATUS_TYPE values
+*/
+func (v *StatusValue) Type() int {
+	switch {
+	case v.IsInt64:
+		return STATUS_TYPE_INT64
+	case v.IsFloat64:
+		return STATUS_TYPE_FLOAT64
+	}
+	return STATUS_TYPE_STRING
+}
+
+/*
+Int64 returns the value as an integer, with floats being truncated. The
+boolean is false for a string value.
+*/
+func (v *StatusValue) Int64() (int64, bool) {
+	switch {
+	case v.IsInt64:
+		return v.ValueInt64, true
+	case v.IsFloat64:
+		return int64(v.ValueFloat64), true
+	}
+	return 0, false
+}
 
-// Convert the MQ Time and Date formats
+/*
+Float64 returns the value as a float. The boolean is false for a string value.
+*/
+func (v *StatusValue) Float64() (float64, bool) {
+	switch {
+	case v.IsInt64:
+		return float64(v.ValueInt64), true
+	case v.IsFloat64:
+		return v.ValueFloat64, true
+	}
+	return 0, false
+}
+
+/*
+String returns the value formatted as a string, so that any value can be
+used as a label
+*/
+func (v *StatusValue) String() string {
+	switch {
+	case v.IsInt64:
+		return strconv.FormatInt(v.ValueInt64, 10)
+	case v.IsFloat64:
+		return strconv.FormatFloat(v.ValueFloat64, 'f', -1, 64)
+	}
+	return v.ValueString
+}
+
+// Empty the values before a new collection
+func statusClearValues(s *StatusSet) {
+	for k := range s.Attributes {
+		s.Attributes[k].Values = make(map[string]*StatusValue)
+	}
+	s.resetKeys = make(map[string]bool)
+}
+
+/*
+Record when the cumulative counters for an object started, such as a channel's start
+date and time. If that changes, the object has been restarted and MQ has reset its
+counters, so the values in this collection are used as they are instead of being
+compared with the previous ones. Must be called before statusGetIntAttributes for the key.
+*/
+func statusCheckEpoch(s *StatusSet, key string, epoch string) {
+	if epoch == "" || epoch == " " {
+		return
+	}
+	if s.epochs == nil {
+		s.epochs = make(map[string]string)
+	}
+	if prev, ok := s.epochs[key]; ok && prev != epoch {
+		logDebug("Counters for %s have been reset. Previous start: %s Current start: %s", key, prev, epoch)
+		statusMarkReset(s, key)
+	}
+	s.epochs[key] = epoch
+}
+
+/*
+Look at all of the cumulative counters for an object before any deltas are calculated.
+If any of them is lower than its previous value then MQ has reset them, and every
+counter for the object is then treated as starting again. Checking each counter as it
+is converted would give deltas for the earlier attributes that mix the old and new totals.
+Must be called before statusGetIntAttributes for the key.
+*/
+func statusCheckCounters(s *StatusSet, cfh *ibmmq.MQCFH, buf []byte, key string) {
+	var elem *ibmmq.PCFParameter
+
+	if s.resetKeys[key] {
+		return
+	}
+
+	parmAvail := true
+	bytesRead := 0
+	offset := 0
+	datalen := len(buf)
+	for parmAvail && cfh.CompCode != ibmmq.MQCC_FAILED {
+		elem, bytesRead = ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		// Have we now reached the end of the message
+		if offset >= datalen {
+			parmAvail = false
+		}
+
+		if elem.Type != ibmmq.MQCFT_INTEGER && elem.Type != ibmmq.MQCFT_INTEGER64 &&
+			elem.Type != ibmmq.MQCFT_INTEGER_LIST && elem.Type != ibmmq.MQCFT_INTEGER64_LIST {
+			continue
+		}
+
+		for _, a := range s.Attributes {
+			if !a.delta || a.pcfAttr != elem.Parameter {
+				continue
+			}
+			index := a.index
+			if index == -1 {
+				index = 0
+			}
+			if index >= len(elem.Int64Value) {
+				continue
+			}
+			if prevVal, ok := a.prevValues[key]; ok && elem.Int64Value[index] < prevVal {
+				logDebug("Counters for %s have been reset. Attribute: %s Previous: %d Current: %d", key, a.MetricName, prevVal, elem.Int64Value[index])
+				statusMarkReset(s, key)
+				return
+			}
+		}
+	}
+}
+
+func statusMarkReset(s *StatusSet, key string) {
+	if s.resetKeys == nil {
+		s.resetKeys = make(map[string]bool)
+	}
+	s.resetKeys[key] = true
+}
+
+/*
+CounterReset says whether the object's cumulative counters were seen to go back
+to zero during the most recent collection, either because it restarted or because
+a total was lower than before. The deltas reported for the object in that collection
+are its values since the reset. Collectors that keep running totals may want to
+treat this as the start of a new series.
+*/
+func (s *StatusSet) CounterReset(key string) bool {
+	return s.resetKeys[key]
+}
+
+// Drop the start times for objects that have gone, so that the map does not grow
+func statusTidyEpochs(s *StatusSet, seen map[string]bool) {
+	for key := range s.epochs {
+		if _, ok := seen[key]; !ok {
+			delete(s.epochs, key)
+		}
+	}
+}
+
+// Convert the MQ Time and Date formats. There are two formats for the time
+// coming from MQ - TPSTATUS uses a colon format time, QSTATUS uses the dots - and
+// mqutil handles both.
 func statusTimeDiff(now time.Time, d string, t string) int64 {
 	var rc int64
 	var err error
@@ -120,12 +300,8 @@ func statusTimeDiff(now time.Time, d string, t string) int64 {
 	// the value has not been set yet - then just return 0
 	rc = 0
 
-	timeStampLayout := timeStampLayoutDot
 	if len(d) == 10 && len(t) == 8 {
-		if strings.Contains(t, ":") {
-			timeStampLayout = timeStampLayoutColon
-		}
-		parsedT, err = time.ParseInLocation(timeStampLayout, d+" "+t, now.Location())
+		parsedT, err = mqutil.ParseDateTime(d, t, now.Location())
 		if err == nil {
 			diff := now.Sub(parsedT).Seconds() + ci.tzOffsetSecs
 
@@ -147,7 +323,7 @@ func statusTimeDiff(now time.Time, d string, t string) int64 {
 	return rc
 }
 
-func clearQ(hObj ibmmq.MQObject) {
+func clearQ(hObj ibmmq.Object) {
 	buf := make([]byte, 0)
 	// Empty replyQ in case any left over from previous errors
 	for ok := true; ok; {
@@ -171,6 +347,11 @@ func statusClearReplyQ() {
 	traceEntry("statusClearReplyQ")
 	ci := getConnection(GetConnectionKey())
 
+	// Replies for other outstanding commands must be left alone
+	if ci.commands.outstanding() > 0 {
+		traceExit("statusClearReplyQ", 1)
+		return
+	}
 	clearQ(ci.si.statusReplyQObj)
 
 	traceExit("statusClearReplyQ", 0)
@@ -197,7 +378,7 @@ func statusSetCommandHeaders() (*ibmmq.MQMD, *ibmmq.MQPMO, *ibmmq.MQCFH, []byte)
 	pmo.Options |= ibmmq.MQPMO_FAIL_IF_QUIESCING
 
 	putmqmd.Format = "MQADMIN"
-	putmqmd.ReplyToQ = ci.si.statusReplyQObj.Name
+	putmqmd.ReplyToQ = ci.si.statusReplyQObj.ObjectName()
 	putmqmd.MsgType = ibmmq.MQMT_REQUEST
 	putmqmd.Report = ibmmq.MQRO_PASS_DISCARD_AND_EXPIRY
 
@@ -222,28 +403,19 @@ func statusGetReply(correlId []byte) (*ibmmq.MQCFH, []byte, bool, error) {
 
 	replyBuf := make([]byte, 10240)
 
-	getmqmd := ibmmq.NewMQMD()
-	gmo := ibmmq.NewMQGMO()
-	gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT
-	gmo.Options |= ibmmq.MQGMO_FAIL_IF_QUIESCING
-	gmo.Options |= ibmmq.MQGMO_WAIT
-	gmo.Options |= ibmmq.MQGMO_CONVERT
-	gmo.WaitInterval = int32(ci.waitInterval) * 1000 // 3 seconds by default
-
-	getmqmd.CorrelId = correlId
-	gmo.MatchOptions = ibmmq.MQMO_MATCH_CORREL_ID
-	gmo.Version = ibmmq.MQGMO_VERSION_2
-
 	allDone := false
-	datalen, err := ci.si.statusReplyQObj.Get(getmqmd, gmo, replyBuf)
+	datalen, err := statusGetReplyMessage(ci, correlId, replyBuf)
 	if err == nil {
+		tracePCF("reply", replyBuf[:datalen])
 		cfh, offset = ibmmq.ReadPCFHeader(replyBuf)
 
 		if cfh.Control == ibmmq.MQCFC_LAST {
 			allDone = true
+			ci.commands.done(correlId)
 		}
 
 		if cfh.Reason != ibmmq.MQRC_NONE {
+			recordAuthFailure(AUTH_OP_COMMAND, &ibmmq.MQReturn{MQCC: cfh.CompCode, MQRC: cfh.Reason})
 			// A "normal" error might come back in 2 messages so we do not
 			// force allDone here. For example, issuing an INQUIRE_CHL_STATUS
 			// might get first response with Reason=STATUS_NOT_FOUND followed by
@@ -262,6 +434,7 @@ func statusGetReply(correlId []byte) (*ibmmq.MQCFH, []byte, bool, error) {
 		// If further messages do show up later, they should be discarded before the next
 		// command tries to use this replyQ.
 		allDone = true
+		ci.commands.done(correlId)
 		if err.(*ibmmq.MQReturn).MQRC != ibmmq.MQRC_NO_MSG_AVAILABLE {
 			logError("StatusGetReply error : %v\n", err)
 		}
@@ -306,9 +479,8 @@ func statusGetIntAttributes(s *StatusSet, elem *ibmmq.PCFParameter, key string)
 					// then use it to create the delta. Otherwise make the initial
 					// value 0.
 					if prevVal, ok := s.Attributes[attr].prevValues[key]; ok {
-						if v-prevVal < 0 {
-							// Value might have wrapped. This number may be temporarily "wrong" but it's
-							// sorted out on the next iteration
+						if s.resetKeys[key] || v-prevVal < 0 {
+							// The object has restarted or the value has wrapped, so this is the count since then
 							s.Attributes[attr].Values[key] = newStatusValueInt64(v)
 						} else {
 							s.Attributes[attr].Values[key] = newStatusValueInt64(v - prevVal)
@@ -328,7 +500,7 @@ func statusGetIntAttributes(s *StatusSet, elem *ibmmq.PCFParameter, key string)
 					// then use it to create the delta. Otherwise make the initial
 					// value 0.
 					if prevVal, ok := s.Attributes[attr].prevValues[key]; ok {
-						if v[index]-prevVal < 0 {
+						if s.resetKeys[key] || v[index]-prevVal < 0 {
 							s.Attributes[attr].Values[key] = newStatusValueInt64(v[index])
 						} else {
 							s.Attributes[attr].Values[key] = newStatusValueInt64(v[index] - prevVal)
@@ -353,8 +525,9 @@ func statusGetIntAttributes(s *StatusSet, elem *ibmmq.PCFParameter, key string)
 // be overridden in specific object types where special processing may be needed.
 func statusNormalise(attr *StatusAttribute, v int64) float64 {
 	f := float64(v)
-	if f < 0 {
+	// Enumerated values such as MQMON_Q_MGR can be negative
+	if f < 0 && attr.enum == "" {
 		f = 0
 	}
-	return f
+	return attr.applyTransform(f)
 }
@@ -44,6 +44,9 @@ const (
 	ATTR_SUB_TYPE          = "type"
 	ATTR_SUB_SINCE_PUB_MSG = "time_since_message_published"
 	ATTR_SUB_MESSAGES      = "messsages_received"
+	ATTR_SUB_DURABLE       = "durable"
+	ATTR_SUB_ACTIVE        = "active"
+	ATTR_SUB_BACKLOG       = "backlog"
 )
 
 /*
@@ -84,6 +87,18 @@ func SubInitAttributes() {
 	st.Attributes[attr] = newStatusAttribute(attr, "Messages Received", ibmmq.MQIACF_MESSAGE_COUNT)
 	st.Attributes[attr].delta = true
 
+	// These are 1 or 0
+	attr = ATTR_SUB_DURABLE
+	st.Attributes[attr] = newStatusAttribute(attr, "Durable Subscription", -1)
+	attr = ATTR_SUB_ACTIVE
+	st.Attributes[attr] = newStatusAttribute(attr, "Subscriber Connected", -1)
+
+	// The depth of the destination queue, found with a separate inquiry. It is only set for
+	// durable subscriptions whose destination is on this queue manager. Subscriptions that
+	// share a destination queue all report its whole depth.
+	attr = ATTR_SUB_BACKLOG
+	st.Attributes[attr] = newStatusAttribute(attr, "Messages Waiting", -1)
+
 	os.init = true
 	traceExit("SubInitAttributes", 0)
 }
@@ -96,9 +111,7 @@ func CollectSubStatus(patterns string) error {
 	SubInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
 	subPatterns := strings.Split(patterns, ",")
 	if len(subPatterns) == 0 {
@@ -113,9 +126,12 @@ func CollectSubStatus(patterns string) error {
 		}
 
 		err = collectSubStatus(pattern)
-
+		if err == nil {
+			err = collectSubBacklog(pattern)
+		}
 	}
 
+	statusPostCollect(OT_SUB)
 	traceExitErr("CollectSubStatus", 0, err)
 
 	return err
@@ -149,7 +165,7 @@ func collectSubStatus(pattern string) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectSubStatus", 1, err)
 
@@ -184,6 +200,8 @@ func parseSubData(cfh *ibmmq.MQCFH, buf []byte) string {
 
 	lastTime := ""
 	lastDate := ""
+	active := false
+	durable := false
 
 	parmAvail := true
 	bytesRead := 0
@@ -208,6 +226,10 @@ func parseSubData(cfh *ibmmq.MQCFH, buf []byte) string {
 			subId = trimToNull(elem.String[0])
 		case ibmmq.MQCA_TOPIC_STRING:
 			topicString = trimToNull(elem.String[0])
+		case ibmmq.MQBACF_CONNECTION_ID:
+			active = !allZero(elem.String[0])
+		case ibmmq.MQIACF_DURABLE_SUBSCRIPTION:
+			durable = elem.Int64Value[0] == int64(ibmmq.MQSUB_DURABLE_YES)
 		}
 	}
 
@@ -216,6 +238,9 @@ func parseSubData(cfh *ibmmq.MQCFH, buf []byte) string {
 
 	st.Attributes[ATTR_SUB_ID].Values[key] = newStatusValueString(subId)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_SUB), cfh, buf, key)
+
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	parmAvail = true
 	offset = 0
@@ -243,14 +268,149 @@ func parseSubData(cfh *ibmmq.MQCFH, buf []byte) string {
 
 	now := time.Now()
 	st.Attributes[ATTR_SUB_SINCE_PUB_MSG].Values[key] = newStatusValueInt64(statusTimeDiff(now, lastDate, lastTime))
-	st.Attributes[ATTR_SUB_TOPIC_STRING].Values[key] = newStatusValueString(topicString)
-	st.Attributes[ATTR_SUB_NAME].Values[key] = newStatusValueString(subName)
+	st.Attributes[ATTR_SUB_TOPIC_STRING].Values[key] = newStatusValueString(labelValue(topicString))
+	st.Attributes[ATTR_SUB_NAME].Values[key] = newStatusValueString(labelValue(subName))
+	st.Attributes[ATTR_SUB_ACTIVE].Values[key] = newStatusValueInt64(subFlag(active))
+	st.Attributes[ATTR_SUB_DURABLE].Values[key] = newStatusValueInt64(subFlag(durable))
 
 	traceExitF("parseSubData", 0, "Key : %s", key)
 
 	return key
 }
 
+func subFlag(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// The status does not say where a subscription's messages go, so issue INQUIRE_SUBSCRIPTION
+// for the durable subscriptions matching the pattern, and then look at the depth of each
+// destination queue
+func collectSubBacklog(pattern string) error {
+	var err error
+
+	traceEntryF("collectSubBacklog", "Pattern: %s", pattern)
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), OT_SUB)
+
+	statusClearReplyQ()
+
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = ibmmq.MQCMD_INQUIRE_SUBSCRIPTION
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = ibmmq.MQCACF_SUB_NAME
+	pcfparm.String = []string{pattern}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER
+	pcfparm.Parameter = ibmmq.MQIACF_DURABLE_SUBSCRIPTION
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQSUB_DURABLE_YES)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("collectSubBacklog", 1, err)
+		return err
+	}
+
+	destinations := make(map[string]string)
+	for allReceived := false; !allReceived; {
+		cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if buf != nil {
+			subId, qName, qMgrName := parseSubDestination(cfh, buf)
+			if _, ok := st.Attributes[ATTR_SUB_ID].Values[subId]; !ok || qName == "" {
+				continue
+			}
+			if qMgrName == "" || qMgrName == ci.si.resolvedQMgrName {
+				destinations[subId] = qName
+			}
+		}
+	}
+
+	// Several subscriptions can share a queue, so each depth is only asked for once
+	depths := make(map[string]int64)
+	for subId, qName := range destinations {
+		depth, ok := depths[qName]
+		if !ok {
+			depth, ok = inquireSubQueueDepth(ci, qName)
+			if !ok {
+				continue
+			}
+			depths[qName] = depth
+		}
+		st.Attributes[ATTR_SUB_BACKLOG].Values[subId] = newStatusValueInt64(depth)
+	}
+
+	traceExitErr("collectSubBacklog", 0, err)
+	return err
+}
+
+// Given an INQUIRE_SUBSCRIPTION response, return the subscription id and its destination
+func parseSubDestination(cfh *ibmmq.MQCFH, buf []byte) (string, string, string) {
+	var elem *ibmmq.PCFParameter
+
+	subId := ""
+	qName := ""
+	qMgrName := ""
+
+	parmAvail := true
+	bytesRead := 0
+	offset := 0
+	datalen := len(buf)
+	if cfh == nil || cfh.ParameterCount == 0 {
+		return "", "", ""
+	}
+
+	for parmAvail && cfh.CompCode != ibmmq.MQCC_FAILED {
+		elem, bytesRead = ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		if offset >= datalen {
+			parmAvail = false
+		}
+
+		switch elem.Parameter {
+		case ibmmq.MQBACF_SUB_ID:
+			subId = trimToNull(elem.String[0])
+		case ibmmq.MQCACF_DESTINATION:
+			qName = trimToNull(elem.String[0])
+		case ibmmq.MQCACF_DESTINATION_Q_MGR:
+			qMgrName = trimToNull(elem.String[0])
+		}
+	}
+	return subId, qName, qMgrName
+}
+
+// Errors are not reported, as the queue might have been deleted since the inquiry. The
+// subscription then has no backlog value.
+func inquireSubQueueDepth(ci *connectionInfo, qName string) (int64, bool) {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = qName
+	qObj, err := ci.si.qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		logDebug("Cannot open subscription destination %s: %v", qName, err)
+		return 0, false
+	}
+	defer qObj.Close(0)
+
+	v, err := qObj.Inq([]int32{ibmmq.MQIA_CURRENT_Q_DEPTH})
+	if err != nil {
+		logDebug("Cannot inquire subscription destination %s: %v", qName, err)
+		return 0, false
+	}
+	depth, ok := v[ibmmq.MQIA_CURRENT_Q_DEPTH].(int32)
+	return int64(depth), ok
+}
+
 // Return a standardised value. If the attribute indicates that something
 // special has to be done, then do that. Otherwise just make sure it's a non-negative
 // value of the correct datatype
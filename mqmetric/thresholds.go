@@ -0,0 +1,425 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file checks simple threshold rules against the collected metrics, so that a
+collector can raise alerts itself - for example as SNMP traps or syslog messages, see
+alerts.go - for sites whose operations tools are driven by those instead of by a
+time-series database.
+
+A rule names a metric in the same way as transform.go: CLASS/ATTRIBUTE for status
+metrics, using the raw status values, and CLASS/TYPE/METRIC for published metrics,
+using the normalised values. The rule is breached for an object when the comparison
+has been true for the given number of evaluations in a row, and for at least the
+given duration. An alert is sent when the rule becomes breached, and another when it
+clears. Objects that are missing from a collection keep their state, so a channel
+that has stopped and disappeared from the status does not clear a "not running" rule.
+
+A flapping object can breach and clear a rule many times. When a rule has a dedup
+interval, a breach within that time of the last one that was reported is suppressed,
+along with the clear that follows it. A breach that is reported also runs the rule's
+actions. See remediation.go.
+
+Rules can also be read from a file given to LoadThresholdRules. Each line has the
+rule name, metric, operator, value, and optionally the number of evaluations and the
+object patterns. Options can follow, as for=DURATION, dedup=DURATION and action=NAME,
+which can be repeated:
+
+	# Name          Metric        Op  Value  Intervals  Objects           Options
+	depth_high      queue/depth   >   1000   1          APP.*,!APP.TEMP*  for=5m dedup=1h
+	chl_not_running channel/status !=  3      3          TO.*              action=start_channel
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ThresholdRule describes one condition to check for each object
+*/
+type ThresholdRule struct {
+	Name      string
+	Metric    string  // CLASS/ATTRIBUTE or CLASS/TYPE/METRIC
+	Objects   string  // Patterns for the object names, as for the monitored queues. Empty for all objects
+	Op        string  // One of > >= < <= == !=
+	Value     float64 // The value compared with the metric
+	Intervals int     // How many evaluations in a row the condition must hold. 0 is the same as 1
+
+	For     time.Duration // How long the condition must hold, as well as the Intervals
+	Dedup   time.Duration // Suppress a breach within this time of the last one reported for the object
+	Actions []string      // Names of the RuleActions to call when the rule is breached
+}
+
+/*
+ThresholdAlert is sent when a rule becomes breached for an object, and again with
+Breached false when it clears
+*/
+type ThresholdAlert struct {
+	Rule     string
+	Metric   string
+	QMgrName string
+	Object   string
+	Value    float64
+	Limit    float64
+	Op       string
+	Breached bool
+	Time     time.Time
+
+	rule *thresholdRule
+}
+
+/*
+AlertSender delivers alerts, for example as SNMP traps or syslog messages
+*/
+type AlertSender interface {
+	SendAlert(a *ThresholdAlert) error
+}
+
+type thresholdState struct {
+	count      int
+	since      time.Time // When the condition became true
+	breached   bool
+	suppressed bool      // The last breach was a duplicate
+	lastFired  time.Time // When a breach was last reported
+}
+
+type thresholdRule struct {
+	ThresholdRule
+	parts  []string
+	states map[string]*thresholdState // Keyed by the object
+}
+
+type thresholds struct {
+	rules   []*thresholdRule
+	senders []AlertSender
+}
+
+/*
+SetThresholdRules replaces the rules for the current connection, and sets where the
+alerts are sent. The state of any previous rules is discarded.
+*/
+func SetThresholdRules(rules []ThresholdRule, senders ...AlertSender) error {
+	traceEntry("SetThresholdRules")
+
+	t := &thresholds{senders: senders}
+	for _, r := range rules {
+		parts, err := splitTransformName(r.Metric)
+		if err == nil {
+			err = checkThresholdOp(r.Op)
+		}
+		if err != nil {
+			traceExitErr("SetThresholdRules", 1, err)
+			return fmt.Errorf("Rule '%s': %v", r.Name, err)
+		}
+		if r.Objects != "" {
+			if strings.Contains(r.Objects, "@") {
+				err = fmt.Errorf("Presets cannot be used in threshold rules")
+			} else {
+				err = VerifyQueuePatterns(r.Objects)
+			}
+			if err != nil {
+				traceExitErr("SetThresholdRules", 2, err)
+				return fmt.Errorf("Rule '%s': %v", r.Name, err)
+			}
+		}
+		for _, a := range r.Actions {
+			if _, ok := getRuleAction(a); !ok {
+				err = fmt.Errorf("Rule '%s': Action '%s' is not registered", r.Name, a)
+				traceExitErr("SetThresholdRules", 3, err)
+				return err
+			}
+		}
+		if r.Intervals < 1 {
+			r.Intervals = 1
+		}
+		t.rules = append(t.rules, &thresholdRule{ThresholdRule: r, parts: parts, states: make(map[string]*thresholdState)})
+	}
+
+	ci := getConnection(GetConnectionKey())
+	ci.thresholds = t
+
+	traceExit("SetThresholdRules", 0)
+	return nil
+}
+
+func checkThresholdOp(op string) error {
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return nil
+	}
+	return fmt.Errorf("Operator '%s' is not known", op)
+}
+
+func thresholdCompare(v float64, op string, limit float64) bool {
+	switch op {
+	case ">":
+		return v > limit
+	case ">=":
+		return v >= limit
+	case "<":
+		return v < limit
+	case "<=":
+		return v <= limit
+	case "==":
+		return v == limit
+	case "!=":
+		return v != limit
+	}
+	return false
+}
+
+/*
+EvaluateThresholds checks the rules against the most recently collected values. It
+is called after each collection. The alerts for rules that have become breached or
+cleared are given to each AlertSender and returned. The error is the first one
+returned by a sender; all the alerts are still sent.
+*/
+func EvaluateThresholds() ([]*ThresholdAlert, error) {
+	var alerts []*ThresholdAlert
+	var err error
+
+	traceEntry("EvaluateThresholds")
+
+	ci := getConnection(GetConnectionKey())
+	if ci.thresholds == nil {
+		traceExit("EvaluateThresholds", 1)
+		return nil, nil
+	}
+
+	now := time.Now()
+	for _, r := range ci.thresholds.rules {
+		for object, v := range thresholdValues(r) {
+			a, suppressed := r.evaluate(object, v, now)
+			if a == nil {
+				continue
+			}
+			a.QMgrName = ci.si.resolvedQMgrName
+			a.Time = now
+			if suppressed {
+				if a.Breached {
+					auditRuleEvent(ci, a, RULE_EVENT_SUPPRESSED, "", nil)
+				}
+				continue
+			}
+			alerts = append(alerts, a)
+		}
+	}
+
+	for _, a := range alerts {
+		for _, s := range ci.thresholds.senders {
+			if e := s.SendAlert(a); e != nil {
+				logError("Cannot send alert for rule %s: %v", a.Rule, e)
+				if err == nil {
+					err = e
+				}
+			}
+		}
+	}
+
+	// The actions run once all the alerts have gone, as they may be slow
+	for _, a := range alerts {
+		if a.Breached {
+			auditRuleEvent(ci, a, RULE_EVENT_BREACHED, "", nil)
+			runRuleActions(ci, a.rule, a)
+		} else {
+			auditRuleEvent(ci, a, RULE_EVENT_CLEARED, "", nil)
+		}
+	}
+
+	traceExitErr("EvaluateThresholds", 0, err)
+	return alerts, err
+}
+
+/*
+Update the state of the rule for one object, returning an alert if it has changed. The
+boolean is true when the alert is a duplicate that should not be reported.
+*/
+func (r *thresholdRule) evaluate(object string, v float64, now time.Time) (*ThresholdAlert, bool) {
+	st, ok := r.states[object]
+	if !ok {
+		st = &thresholdState{}
+		r.states[object] = st
+	}
+
+	if thresholdCompare(v, r.Op, r.Value) {
+		if st.count == 0 {
+			st.since = now
+		}
+		st.count++
+	} else {
+		st.count = 0
+	}
+
+	breached := st.count >= r.Intervals && now.Sub(st.since) >= r.For
+	if breached == st.breached {
+		return nil, false
+	}
+	st.breached = breached
+
+	suppressed := false
+	if breached {
+		suppressed = r.Dedup > 0 && !st.lastFired.IsZero() && now.Sub(st.lastFired) < r.Dedup
+		if !suppressed {
+			st.lastFired = now
+		}
+		st.suppressed = suppressed
+	} else {
+		suppressed = st.suppressed
+		st.suppressed = false
+	}
+	a := &ThresholdAlert{Rule: r.Name, Metric: r.Metric, Object: object, Value: v, Limit: r.Value, Op: r.Op, Breached: breached, rule: r}
+	return a, suppressed
+}
+
+// Find the current value of the rule's metric for each object that matches its patterns
+func thresholdValues(r *thresholdRule) map[string]float64 {
+	values := make(map[string]float64)
+	key := GetConnectionKey()
+
+	// Status keys such as channels have several parts, and the patterns
+	// are only applied to the first
+	matches := func(object string) bool {
+		if r.Objects == "" {
+			return true
+		}
+		name := strings.SplitN(object, "/", 2)[0]
+		return len(FilterRegExp(r.Objects, []string{name})) > 0
+	}
+
+	if len(r.parts) == 2 {
+		st := GetObjectStatus(key, statusClassNames[r.parts[0]])
+		if attr, ok := st.Attributes[r.parts[1]]; ok {
+			for object, sv := range attr.Values {
+				if f, ok := sv.Float64(); ok && matches(object) {
+					values[object] = f
+				}
+			}
+		}
+		return values
+	}
+
+	for _, cl := range GetPublishedMetrics(key).Classes {
+		if !strings.EqualFold(cl.Name, r.parts[0]) {
+			continue
+		}
+		for _, ty := range cl.Types {
+			if !strings.EqualFold(ty.Name, r.parts[1]) {
+				continue
+			}
+			for _, elem := range ty.Elements {
+				if !strings.EqualFold(elem.MetricName, r.parts[2]) {
+					continue
+				}
+				elem.Range(func(object string, v int64) {
+					if matches(object) {
+						values[object] = Normalise(elem, object, v)
+					}
+				})
+			}
+		}
+	}
+	return values
+}
+
+/*
+LoadThresholdRules reads rules from a file, in the format shown at the top of this file
+*/
+func LoadThresholdRules(fileName string) ([]ThresholdRule, error) {
+	var rules []ThresholdRule
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error Opening file %s: %v", fileName, err)
+	}
+	defer file.Close()
+
+	lineNo := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseThresholdRule(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("Error in %s line %d: %v", fileName, lineNo, err)
+		}
+		rules = append(rules, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error Reading from %s: %v", fileName, err)
+	}
+	return rules, nil
+}
+
+func parseThresholdRule(fields []string) (ThresholdRule, error) {
+	var r ThresholdRule
+	var err error
+
+	// The options are at the end
+	var options []string
+	for len(fields) > 0 && strings.Contains(fields[len(fields)-1], "=") {
+		options = append([]string{fields[len(fields)-1]}, options...)
+		fields = fields[:len(fields)-1]
+	}
+
+	if len(fields) < 4 || len(fields) > 6 {
+		return r, fmt.Errorf("Need a name, metric, operator and value, then optionally the intervals, object patterns and options")
+	}
+	r.Name = fields[0]
+	r.Metric = fields[1]
+	r.Op = fields[2]
+	if r.Value, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return r, fmt.Errorf("Value '%s' is not a number", fields[3])
+	}
+	if len(fields) > 4 {
+		if r.Intervals, err = strconv.Atoi(fields[4]); err != nil || r.Intervals < 1 {
+			return r, fmt.Errorf("Intervals '%s' is not a positive number", fields[4])
+		}
+	}
+	if len(fields) > 5 {
+		r.Objects = fields[5]
+	}
+
+	for _, o := range options {
+		kv := strings.SplitN(o, "=", 2)
+		switch strings.ToLower(kv[0]) {
+		case "for":
+			r.For, err = time.ParseDuration(kv[1])
+		case "dedup":
+			r.Dedup, err = time.ParseDuration(kv[1])
+		case "action":
+			r.Actions = append(r.Actions, kv[1])
+		default:
+			err = fmt.Errorf("Option '%s' is not known", kv[0])
+		}
+		if err != nil {
+			return r, fmt.Errorf("Option '%s': %v", o, err)
+		}
+	}
+	return r, nil
+}
@@ -26,7 +26,16 @@ package mqmetric
 
 /*
 Functions in this file use the DISPLAY TPSTATUS command to extract metrics
-about MQ topics
+about MQ topics.
+
+The patterns given to CollectTopicStatus are topic strings, which can use the
+MQ topic wildcards "#" and "+" as they are passed directly to the command. A pattern
+can also start with "!" to exclude topics from the results, with the same rules as
+for queue names in FilterRegExp. For example, "#,!$SYS*" shows all topic strings
+other than the system ones. If there are only exclusions, "#" is assumed.
+
+InquireTopicStrings finds the topic strings for administered topic objects, so
+that a collector can be configured with object names instead.
 */
 
 import (
@@ -89,26 +98,170 @@ func TopicInitAttributes() {
 	st.Attributes[attr] = newStatusAttribute(attr, "Number of subscribers", ibmmq.MQIA_SUB_COUNT)
 
 	attr = ATTR_TOPIC_SINCE_PUB_MSG
-	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg", -1)
+	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg Published", -1)
 	attr = ATTR_TOPIC_SINCE_SUB_MSG
-	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg", -1)
+	st.Attributes[attr] = newStatusAttribute(attr, "Time Since Msg Received", -1)
 
 	os.init = true
 	traceExit("TopicInitAttributes", 0)
 
 }
 
-// If we need to list the topics that match a pattern. Not needed for
+// If we need to list the topic objects that match a pattern. Not needed for
 // the status queries as they (unlike the pub/sub resource stats) accept
 // patterns in the PCF command
 func InquireTopics(patterns string) ([]string, error) {
 	traceEntry("InquireTopics")
 	TopicInitAttributes()
-	rc, err := inquireObjects(patterns, ibmmq.MQOT_TOPIC)
+	includes, excludes := splitExclusions(patterns)
+	if len(includes) == 0 {
+		includes = []string{"*"}
+	}
+	rc, err := inquireObjects(strings.Join(includes, ","), ibmmq.MQOT_TOPIC)
+	if err == nil && excludes != "" {
+		rc = FilterRegExp(excludes, rc)
+	}
 	traceExitErr("InquireTopics", 0, err)
 	return rc, err
 }
 
+/*
+InquireTopicStrings returns the topic strings for the administered topic objects
+that match the patterns. Patterns starting with "!" exclude objects by name, so
+"*,!SYSTEM*" gives the topic strings of all the user-defined topic objects.
+*/
+func InquireTopicStrings(patterns string) ([]string, error) {
+	var err error
+	traceEntry("InquireTopicStrings")
+
+	includes, excludes := splitExclusions(patterns)
+	if len(includes) == 0 {
+		includes = []string{"*"}
+	}
+
+	topics := make(map[string]string)
+	for _, pattern := range includes {
+		err = inquireTopicStrings(pattern, topics)
+		if err != nil {
+			break
+		}
+	}
+
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	if excludes != "" {
+		names = FilterRegExp(excludes, names)
+	}
+
+	rc := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		tpString := topics[name]
+		if tpString != "" && !seen[tpString] {
+			seen[tpString] = true
+			rc = append(rc, tpString)
+		}
+	}
+
+	traceExitErr("InquireTopicStrings", 0, err)
+	return rc, err
+}
+
+// Issue the INQUIRE_TOPIC command for a topic object name or pattern, and
+// add the topic string for each returned object to the map
+func inquireTopicStrings(pattern string, topics map[string]string) error {
+	var err error
+	traceEntryF("inquireTopicStrings", "Pattern: %s", pattern)
+
+	ci := getConnection(GetConnectionKey())
+	statusClearReplyQ()
+
+	putmqmd, pmo, cfh, buf := statusSetCommandHeaders()
+	cfh.Command = ibmmq.MQCMD_INQUIRE_TOPIC
+
+	pcfparm := new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_STRING
+	pcfparm.Parameter = ibmmq.MQCA_TOPIC_NAME
+	pcfparm.String = []string{pattern}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	pcfparm = new(ibmmq.PCFParameter)
+	pcfparm.Type = ibmmq.MQCFT_INTEGER_LIST
+	pcfparm.Parameter = ibmmq.MQIACF_TOPIC_ATTRS
+	pcfparm.Int64Value = []int64{int64(ibmmq.MQCA_TOPIC_NAME), int64(ibmmq.MQCA_TOPIC_STRING)}
+	cfh.ParameterCount++
+	buf = append(buf, pcfparm.Bytes()...)
+
+	buf = append(cfh.Bytes(), buf...)
+
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
+	if err != nil {
+		traceExitErr("inquireTopicStrings", 1, err)
+		return err
+	}
+
+	for allReceived := false; !allReceived; {
+		cfh, buf, allReceived, err = statusGetReply(putmqmd.MsgId)
+		if buf != nil {
+			name, tpString := parseTopicObject(cfh, buf)
+			if name != "" {
+				topics[name] = tpString
+			}
+		}
+	}
+
+	traceExitErr("inquireTopicStrings", 0, err)
+	return err
+}
+
+func parseTopicObject(cfh *ibmmq.MQCFH, buf []byte) (string, string) {
+	name := ""
+	tpString := ""
+
+	if cfh == nil || cfh.ParameterCount == 0 || cfh.CompCode == ibmmq.MQCC_FAILED {
+		return name, tpString
+	}
+
+	offset := 0
+	for offset < len(buf) {
+		elem, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		if bytesRead <= 0 {
+			break
+		}
+		offset += bytesRead
+		switch elem.Parameter {
+		case ibmmq.MQCA_TOPIC_NAME:
+			name = trimToNull(elem.String[0])
+		case ibmmq.MQCA_TOPIC_STRING:
+			tpString = trimToNull(elem.String[0])
+		}
+	}
+	return name, tpString
+}
+
+// Separate the patterns into the ones that are sent to the queue manager,
+// and a comma-separated list of exclusions (still with their "!" prefix)
+// that can be given to FilterRegExp
+func splitExclusions(patterns string) ([]string, string) {
+	includes := make([]string, 0)
+	excludes := make([]string, 0)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			excludes = append(excludes, pattern)
+		} else {
+			includes = append(includes, pattern)
+		}
+	}
+	return includes, strings.Join(excludes, ",")
+}
+
 func CollectTopicStatus(patterns string) error {
 	var err error
 	traceEntry("CollectTopicStatus")
@@ -120,21 +273,18 @@ func CollectTopicStatus(patterns string) error {
 	TopicInitAttributes()
 
 	// Empty any collected values
-	for k := range st.Attributes {
-		st.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(st)
 
-	topicPatterns := strings.Split(patterns, ",")
+	topicPatterns, excludes := splitExclusions(patterns)
 	if len(topicPatterns) == 0 {
-		traceExit("CollectTopicStatus", 1)
-		return nil
+		if excludes == "" {
+			traceExit("CollectTopicStatus", 1)
+			return nil
+		}
+		topicPatterns = []string{"#"}
 	}
 
 	for _, pattern := range topicPatterns {
-		pattern = strings.TrimSpace(pattern)
-		if len(pattern) == 0 {
-			continue
-		}
 
 		// Collect 3 types of status for the topics
 		err1 := collectTopicStatus(pattern, ibmmq.MQIACF_TOPIC_SUB)
@@ -152,6 +302,10 @@ func CollectTopicStatus(patterns string) error {
 
 	}
 
+	if excludes != "" {
+		removeExcludedTopics(excludes)
+	}
+
 	// Need to clean out the prevValues elements to stop short-lived topics
 	// building up in the map
 	for a, _ := range st.Attributes {
@@ -168,11 +322,31 @@ func CollectTopicStatus(patterns string) error {
 		}
 	}
 
+	statusPostCollect(OT_TOPIC)
 	traceExitErr("CollectTopicStatus", 0, err)
 
 	return err
 }
 
+// Remove the collected values for any topic strings that match the exclusions. They are
+// also removed from the set of topics seen in this period so that their previous values
+// are not kept.
+func removeExcludedTopics(excludes string) {
+	ci := getConnection(GetConnectionKey())
+	os := &ci.objectStatus[OT_TOPIC]
+	st := GetObjectStatus(GetConnectionKey(), OT_TOPIC)
+
+	for key, v := range st.Attributes[ATTR_TOPIC_STRING].Values {
+		if len(FilterRegExp(excludes, []string{v.ValueString})) == 0 {
+			logTrace("Excluding topic %s", v.ValueString)
+			for a := range st.Attributes {
+				delete(st.Attributes[a].Values, key)
+			}
+			delete(os.objectSeen, key)
+		}
+	}
+}
+
 // Issue the INQUIRE_TOPIC_STATUS command for a topic or wildcarded topic name
 // Collect the responses and build up the statistics
 func collectTopicStatus(pattern string, instanceType int32) error {
@@ -208,7 +382,7 @@ func collectTopicStatus(pattern string, instanceType int32) error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectTopicStatus", 1, err)
 		return err
@@ -285,6 +459,9 @@ func parseTopicData(instanceType int32, cfh *ibmmq.MQCFH, buf []byte) string {
 	st.Attributes[ATTR_TOPIC_STRING].Values[key] = newStatusValueString(tpName)
 	st.Attributes[ATTR_TOPIC_STATUS_TYPE].Values[key] = newStatusValueString(instanceTypeString)
 
+	// Look for counters that have gone back to zero before working out the deltas
+	statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_TOPIC), cfh, buf, key)
+
 	parmAvail = true
 	// And then re-parse the message so we can store the metrics now knowing the map key
 	offset = 0
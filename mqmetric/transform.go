@@ -0,0 +1,361 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file lets a collector change the values of metrics, or add new ones, without
+changing the code that reads them from MQ.
+
+A transformation is applied to a single metric after the normal conversion to base
+units, so it runs at the end of Normalise for published metrics and at the end of
+the xxNormalise functions for status metrics. It can scale a value, put it into a
+bucket, or change the units. A derived metric is calculated from the other values
+for the same object at the end of each status collection, such as the ratio of a
+queue's depth to its maximum depth. Derived values are floats.
+
+Metrics are named as CLASS/TYPE/METRIC for the published metrics, using the names
+that come from the discovery, and as CLASS/ATTRIBUTE for the status metrics, where
+the class is one of the names shown in metrics.txt such as "queue" or "channel".
+Names are not case-sensitive.
+
+The same things can be configured in a file given to LoadTransforms. Each line has
+the metric name, an operation and its arguments:
+
+	# Comments start with '#'
+	queue/depth                          bucket 0,10,100,1000
+	CPU/SystemSummary/ram_free_percentage unit percent
+	STATMQI/PUT/mqput_byte_count         scale 0.001
+	queue/depth_ratio                    ratio depth attribute_max_depth
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+TransformFunc converts one value of a metric
+*/
+type TransformFunc func(v float64) float64
+
+/*
+DeriveFunc calculates a new value for an object from its other status values,
+indexed by the attribute name. It returns false if there is no value for the object.
+*/
+type DeriveFunc func(values map[string]*StatusValue) (float64, bool)
+
+type derivedMetric struct {
+	description string
+	f           DeriveFunc
+}
+
+// The classes used in the names of status metrics
+var statusClassNames = map[string]int{
+	"amqp":          OT_CHANNEL_AMQP,
+	"applianceha":   OT_APPLIANCE_HA,
+	"bufferpool":    OT_BP,
+	"channel":       OT_CHANNEL,
+	"cluster":       OT_CLUSTER,
+	"cluster_xmitq": OT_CLUSTER_XMITQ,
+	"mqipt":         OT_MQIPT,
+	"auth":          OT_AUTH,
+	"nha":           OT_NHA,
+	"pageset":       OT_PS,
+	"qmgr":          OT_Q_MGR,
+	"queue":         OT_Q,
+	"sub":           OT_SUB,
+	"topic":         OT_TOPIC,
+}
+
+// Multipliers from the base units that Normalise produces
+var transformUnits = map[string]float64{
+	"percent": 100,
+	"ms":      1000,
+	"us":      1000000,
+	"kb":      1.0 / 1024,
+	"mb":      1.0 / (1024 * 1024),
+	"gb":      1.0 / (1024 * 1024 * 1024),
+}
+
+// Check the format of a metric name, returning its parts
+func splitTransformName(name string) ([]string, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(name)), "/")
+	switch len(parts) {
+	case 2:
+		if _, ok := statusClassNames[parts[0]]; !ok {
+			return nil, fmt.Errorf("Status class '%s' in '%s' is not known", parts[0], name)
+		}
+	case 3:
+	default:
+		return nil, fmt.Errorf("Metric name '%s' must be CLASS/TYPE/METRIC or CLASS/ATTRIBUTE", name)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("Metric name '%s' is not valid", name)
+		}
+	}
+	return parts, nil
+}
+
+/*
+AddTransform sets the function that is applied to a metric after it has been
+normalised. Only one function is used for each metric; a nil function removes it.
+*/
+func AddTransform(name string, f TransformFunc) error {
+	parts, err := splitTransformName(name)
+	if err != nil {
+		return err
+	}
+
+	ci := getConnection(GetConnectionKey())
+	if ci.transforms == nil {
+		ci.transforms = make(map[string]TransformFunc)
+	}
+	key := strings.Join(parts, "/")
+	if f == nil {
+		delete(ci.transforms, key)
+	} else {
+		ci.transforms[key] = f
+	}
+	return nil
+}
+
+/*
+AddDerivedMetric adds a status metric that is calculated from the other values
+of the same object. The name must be CLASS/ATTRIBUTE, and the attribute must not
+already exist.
+*/
+func AddDerivedMetric(name string, description string, f DeriveFunc) error {
+	parts, err := splitTransformName(name)
+	if err != nil {
+		return err
+	}
+	if len(parts) != 2 {
+		return fmt.Errorf("Derived metric '%s' must be CLASS/ATTRIBUTE", name)
+	}
+
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), statusClassNames[parts[0]])
+	if attr, ok := st.Attributes[parts[1]]; ok && attr.derive == nil {
+		return fmt.Errorf("Derived metric '%s' is already a status attribute", name)
+	}
+	if ci.derived == nil {
+		ci.derived = make(map[string]*derivedMetric)
+	}
+	ci.derived[strings.Join(parts, "/")] = &derivedMetric{description: description, f: f}
+	return nil
+}
+
+/*
+ScaleTransform multiplies values by a fixed factor
+*/
+func ScaleTransform(factor float64) TransformFunc {
+	return func(v float64) float64 {
+		return v * factor
+	}
+}
+
+/*
+BucketTransform replaces a value by the largest of the boundaries that is not
+greater than it. Values below the first boundary become 0.
+*/
+func BucketTransform(bounds []float64) TransformFunc {
+	b := make([]float64, len(bounds))
+	copy(b, bounds)
+	sort.Float64s(b)
+	return func(v float64) float64 {
+		i := sort.Search(len(b), func(i int) bool { return b[i] > v })
+		if i == 0 {
+			return 0
+		}
+		return b[i-1]
+	}
+}
+
+/*
+RatioDerive divides one status attribute by another. There is no value when
+the denominator is zero.
+*/
+func RatioDerive(numerator string, denominator string) DeriveFunc {
+	return func(values map[string]*StatusValue) (float64, bool) {
+		n, ok1 := values[numerator]
+		d, ok2 := values[denominator]
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		nf, ok1 := n.Float64()
+		df, ok2 := d.Float64()
+		if !ok1 || !ok2 || df == 0 {
+			return 0, false
+		}
+		return nf / df, true
+	}
+}
+
+/*
+LoadTransforms reads the transformations and derived metrics from a file
+*/
+func LoadTransforms(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("Error Opening file %s: %v", fileName, err)
+	}
+	defer file.Close()
+
+	lineNo := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err = parseTransform(strings.Fields(line)); err != nil {
+			return fmt.Errorf("Error in %s line %d: %v", fileName, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error Reading from %s: %v", fileName, err)
+	}
+	return nil
+}
+
+func parseTransform(fields []string) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("Need a metric name, an operation and its arguments")
+	}
+	name := fields[0]
+	op := strings.ToLower(fields[1])
+	args := fields[2:]
+
+	switch op {
+	case "scale":
+		f, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || len(args) != 1 {
+			return fmt.Errorf("Scale needs a single number")
+		}
+		return AddTransform(name, ScaleTransform(f))
+	case "unit":
+		f, ok := transformUnits[strings.ToLower(args[0])]
+		if !ok || len(args) != 1 {
+			return fmt.Errorf("Unit '%s' is not known", args[0])
+		}
+		return AddTransform(name, ScaleTransform(f))
+	case "bucket":
+		var bounds []float64
+		for _, s := range strings.Split(strings.Join(args, ""), ",") {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("Bucket boundary '%s' is not a number", s)
+			}
+			bounds = append(bounds, f)
+		}
+		return AddTransform(name, BucketTransform(bounds))
+	case "ratio":
+		if len(args) != 2 {
+			return fmt.Errorf("Ratio needs two attribute names")
+		}
+		return AddDerivedMetric(name, "Ratio of "+args[0]+" to "+args[1], RatioDerive(strings.ToLower(args[0]), strings.ToLower(args[1])))
+	}
+	return fmt.Errorf("Operation '%s' is not known", fields[1])
+}
+
+// Apply any transformation for a published metric
+func elementTransform(elem *MonElement, f float64) float64 {
+	ci := getConnection(GetConnectionKey())
+	if ci == nil || len(ci.transforms) == 0 || elem.Parent == nil || elem.Parent.Parent == nil {
+		return f
+	}
+	ty := elem.Parent
+	key := strings.ToLower(ty.Parent.Name + "/" + ty.Name + "/" + elem.MetricName)
+	if t, ok := ci.transforms[key]; ok {
+		f = t(f)
+	}
+	return f
+}
+
+// Apply any transformation for a status metric
+func (attr *StatusAttribute) applyTransform(f float64) float64 {
+	if attr.transform != nil {
+		f = attr.transform(f)
+	}
+	return f
+}
+
+/*
+Called at the end of each status collection. The transformations are attached to
+the attributes here so that they can be configured before or after the attributes
+are initialised, and the derived metrics are calculated from the collected values.
+*/
+func statusPostCollect(ot int) {
+	var className string
+
+	ci := getConnection(GetConnectionKey())
+	st := GetObjectStatus(GetConnectionKey(), ot)
+	for n, t := range statusClassNames {
+		if t == ot {
+			className = n
+		}
+	}
+
+	for attrName, attr := range st.Attributes {
+		attr.transform = ci.transforms[className+"/"+attrName]
+	}
+
+	if len(ci.derived) == 0 {
+		return
+	}
+
+	// Gather the values for each object
+	objects := make(map[string]map[string]*StatusValue)
+	for attrName, attr := range st.Attributes {
+		if attr.derive != nil {
+			continue
+		}
+		for key, v := range attr.Values {
+			if _, ok := objects[key]; !ok {
+				objects[key] = make(map[string]*StatusValue)
+			}
+			objects[key][attrName] = v
+		}
+	}
+
+	for name, d := range ci.derived {
+		parts := strings.Split(name, "/")
+		if parts[0] != className {
+			continue
+		}
+		attr, ok := st.Attributes[parts[1]]
+		if !ok {
+			attr = newStatusAttribute(parts[1], d.description, -1)
+			st.Attributes[parts[1]] = attr
+		}
+		attr.derive = d.f
+		for key, values := range objects {
+			if f, ok := d.f(values); ok {
+				attr.Values[key] = newStatusValueFloat64(f)
+			}
+		}
+	}
+}
@@ -118,13 +118,11 @@ func CollectUsageStatus() error {
 	UsageInitAttributes()
 
 	// Empty any collected values
-	for k := range stbp.Attributes {
-		stbp.Attributes[k].Values = make(map[string]*StatusValue)
-	}
-	for k := range stps.Attributes {
-		stps.Attributes[k].Values = make(map[string]*StatusValue)
-	}
+	statusClearValues(stbp)
+	statusClearValues(stps)
 	err = collectUsageStatus()
+	statusPostCollect(OT_BP)
+	statusPostCollect(OT_PS)
 	traceExitErr("CollectUsageStatus", 0, err)
 	return err
 }
@@ -148,7 +146,7 @@ func collectUsageStatus() error {
 	buf = append(cfh.Bytes(), buf...)
 
 	// And now put the command to the queue
-	err = ci.si.cmdQObj.Put(putmqmd, pmo, buf)
+	err = statusPutCommand(ci, putmqmd, pmo, buf)
 	if err != nil {
 		traceExitErr("collectUsageStatus", 1, err)
 		return err
@@ -254,6 +252,9 @@ func parseUsageData(cfh *ibmmq.MQCFH, buf []byte) string {
 		stbp.Attributes[ATTR_BP_LOCATION].Values[key] = newStatusValueString(bpLocation)
 		stbp.Attributes[ATTR_BP_CLASS].Values[key] = newStatusValueString(bpClass)
 
+		// Look for counters that have gone back to zero before working out the deltas
+		statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_BP), cfh, buf, key)
+
 		parmAvail = true
 		// And then re-parse the message so we can store the metrics now knowing the map key
 		offset = 0
@@ -274,6 +275,9 @@ func parseUsageData(cfh *ibmmq.MQCFH, buf []byte) string {
 		stps.Attributes[ATTR_PS_ID].Values[key] = newStatusValueString(psId)
 		stps.Attributes[ATTR_PS_BPID].Values[key] = newStatusValueString(bpId)
 
+		// Look for counters that have gone back to zero before working out the deltas
+		statusCheckCounters(GetObjectStatus(GetConnectionKey(), OT_PS), cfh, buf, key)
+
 		parmAvail = true
 		// And then re-parse the message so we can store the metrics now knowing the map key
 		offset = 0
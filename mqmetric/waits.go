@@ -0,0 +1,105 @@
+package mqmetric
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+/*
+This file holds the wait intervals for the three kinds of MQGET that the package makes:
+
+  - Metadata discovery, which waits for the queue manager to publish the descriptions of
+    the available metrics. MetadataWaitInterval, in seconds. Default 30.
+  - Publications, read at the start of each ProcessPublications. By default they are read
+    without waiting. PublicationWaitInterval, in milliseconds, allows publications that
+    are just arriving to be included in this collection rather than the next.
+  - Command responses from the command server. WaitInterval, in seconds.
+
+With AdaptiveWait, the publication and command waits are halved after several in a row
+that found nothing, down to a tenth of the configured value, and go back to the full value
+as soon as a message arrives. A collector whose publications normally arrive before the
+scrape, or that talks to a slow command server that is not running, then stops paying the
+full wait every time.
+*/
+
+import (
+	"time"
+)
+
+const (
+	defaultMetadataWait = 30 * time.Second
+
+	adaptiveEmptyLimit = 3  // Empty waits in a row before the wait is reduced
+	adaptiveMinDivisor = 10 // The shortest wait is this fraction of the configured one
+)
+
+type adaptiveWait struct {
+	base     time.Duration
+	current  time.Duration
+	adaptive bool
+	empty    int // Consecutive waits that found nothing
+}
+
+func newAdaptiveWait(base time.Duration, adaptive bool) *adaptiveWait {
+	return &adaptiveWait{base: base, current: base, adaptive: adaptive}
+}
+
+// How long the next MQGET should wait
+func (w *adaptiveWait) interval() time.Duration {
+	if w == nil {
+		return 0
+	}
+	return w.current
+}
+
+// Record whether a wait found a message
+func (w *adaptiveWait) result(found bool) {
+	if w == nil || !w.adaptive {
+		return
+	}
+	if found {
+		if w.current != w.base {
+			logDebug("Restoring MQGET wait to %v", w.base)
+		}
+		w.empty = 0
+		w.current = w.base
+		return
+	}
+
+	w.empty++
+	if w.empty >= adaptiveEmptyLimit {
+		w.empty = 0
+		min := w.base / adaptiveMinDivisor
+		if w.current/2 >= min {
+			w.current /= 2
+		} else {
+			w.current = min
+		}
+		logDebug("Reducing MQGET wait to %v", w.current)
+	}
+}
+
+// Set up the waits from the connection configuration
+func setWaitIntervals(ci *connectionInfo, cc *ConnectionConfig) {
+	ci.waitInterval = cc.WaitInterval
+	ci.cmdWait = newAdaptiveWait(time.Duration(cc.WaitInterval)*time.Second, cc.AdaptiveWait)
+	ci.pubWait = newAdaptiveWait(time.Duration(cc.PublicationWaitInterval)*time.Millisecond, cc.AdaptiveWait)
+	ci.metaWait = defaultMetadataWait
+	if cc.MetadataWaitInterval > 0 {
+		ci.metaWait = time.Duration(cc.MetadataWaitInterval) * time.Second
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Package mqutil has small helpers for the strings that MQ returns, which programs using
+the ibmmq and mqmetric packages otherwise each write for themselves:
+
+  - Object names and other fixed-length fields are padded with blanks, and sometimes nulls
+  - Connection names are lists of "host(port)", with a default port
+  - Dates and times in status responses and message descriptors are separate strings,
+    using either dots or colons in the time
+
+The package does not use the MQ client, so it can be used without cgo.
+*/
+package mqutil
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is used for connection names that do not give one
+const DefaultPort = 1414
+
+// The layouts of MQ timestamps. Status responses mostly use dots in the time.
+const (
+	DateLayout      = "2006-01-02"
+	TimeLayoutDot   = "15.04.05"
+	TimeLayoutColon = "15:04:05"
+)
+
+/*
+TrimName removes the blank and null padding from a fixed-length MQ field such as an
+object name
+*/
+func TrimName(s string) string {
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// Address is one entry from a connection name
+type Address struct {
+	Host string
+	Port int
+}
+
+// String returns the address as "host:port", with brackets round an IPv6 host
+func (a Address) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// ConnName returns the address in MQ's "host(port)" form
+func (a Address) ConnName() string {
+	return fmt.Sprintf("%s(%d)", a.Host, a.Port)
+}
+
+/*
+ParseConnName splits a connection name such as "host1(1414),host2(1415)" into its
+addresses. Entries without a port get DefaultPort. Host names are converted to lower
+case, so the same address is always written the same way.
+*/
+func ParseConnName(connName string) ([]Address, error) {
+	var l []Address
+
+	for _, c := range strings.Split(TrimName(connName), ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		host := c
+		port := DefaultPort
+		if i := strings.Index(c, "("); i >= 0 {
+			if !strings.HasSuffix(c, ")") {
+				return nil, fmt.Errorf("Connection name '%s' has no closing bracket", c)
+			}
+			host = strings.TrimSpace(c[0:i])
+			p, err := strconv.Atoi(strings.TrimSpace(c[i+1 : len(c)-1]))
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("Connection name '%s' has an invalid port", c)
+			}
+			port = p
+		}
+		if host == "" {
+			return nil, fmt.Errorf("Connection name '%s' has no host", c)
+		}
+		l = append(l, Address{Host: strings.ToLower(host), Port: port})
+	}
+	return l, nil
+}
+
+/*
+NormaliseConnName returns a connection name in a standard form, with every address
+given as "host(port)". A value that cannot be parsed is returned trimmed but otherwise
+unchanged, as connection names in status responses are not always in the same format
+as those in channel definitions.
+*/
+func NormaliseConnName(connName string) string {
+	l, err := ParseConnName(connName)
+	if err != nil || len(l) == 0 {
+		return TrimName(connName)
+	}
+	s := make([]string, len(l))
+	for i, a := range l {
+		s[i] = a.ConnName()
+	}
+	return strings.Join(s, ",")
+}
+
+/*
+ParseDateTime converts an MQ date such as "2024-05-14" and time such as "12.00.00" or
+"12:00:00" into a time in the given location. MQ gives status times in the queue manager's
+local time, and message descriptor times in UTC.
+*/
+func ParseDateTime(d string, t string, loc *time.Location) (time.Time, error) {
+	d = TrimName(d)
+	t = TrimName(t)
+	layout := TimeLayoutDot
+	if strings.Contains(t, ":") {
+		layout = TimeLayoutColon
+	}
+	return time.ParseInLocation(DateLayout+" "+layout, d+" "+t, loc)
+}
+
+/*
+ParseTimestamp converts a combined MQ timestamp such as "2024-05-14 12.00.00" into a time in
+the given location
+*/
+func ParseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	fields := strings.Fields(TrimName(s))
+	if len(fields) != 2 {
+		return time.Time{}, fmt.Errorf("Timestamp '%s' is not a date and a time", s)
+	}
+	return ParseDateTime(fields[0], fields[1], loc)
+}
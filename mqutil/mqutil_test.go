@@ -0,0 +1,73 @@
+package mqutil
+
+/*
+  Copyright (c) IBM Corporation 2023
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+   Contributors:
+     Mark Taylor - Initial Contribution
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrimName(t *testing.T) {
+	if s := TrimName("APP.QUEUE   \x00\x00"); s != "APP.QUEUE" {
+		t.Logf("TrimName returned '%s'", s)
+		t.Fail()
+	}
+}
+
+func TestConnName(t *testing.T) {
+	tests := map[string]string{
+		"Host1(1414), host2":     "host1(1414),host2(1414)",
+		"10.0.0.1( 1415 )":       "10.0.0.1(1415)",
+		"::1(1416)":              "::1(1416)",
+		"host(notaport)":         "host(notaport)",
+		"  spaced.host     \x00": "spaced.host(1414)",
+	}
+	for in, exp := range tests {
+		if s := NormaliseConnName(in); s != exp {
+			t.Logf("NormaliseConnName('%s') returned '%s', expected '%s'", in, s, exp)
+			t.Fail()
+		}
+	}
+
+	l, err := ParseConnName("::1(1416),host")
+	if err != nil || len(l) != 2 || l[0].String() != "[::1]:1416" || l[1].String() != "host:1414" {
+		t.Logf("ParseConnName returned %v %v", l, err)
+		t.Fail()
+	}
+	if _, err = ParseConnName("host(1414"); err == nil {
+		t.Logf("ParseConnName accepted a missing bracket")
+		t.Fail()
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	exp := time.Date(2024, 5, 14, 12, 0, 1, 0, time.UTC)
+	for _, s := range []string{"2024-05-14 12.00.01", "2024-05-14 12:00:01 "} {
+		ts, err := ParseTimestamp(s, time.UTC)
+		if err != nil || !ts.Equal(exp) {
+			t.Logf("ParseTimestamp('%s') returned %v %v", s, ts, err)
+			t.Fail()
+		}
+	}
+	if _, err := ParseDateTime("2024-05-14", "", time.UTC); err == nil {
+		t.Logf("ParseDateTime accepted an empty time")
+		t.Fail()
+	}
+}